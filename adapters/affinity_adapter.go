@@ -32,10 +32,14 @@ func NewAffinityAdapter() api.Affinity {
 }
 
 // Pin binds the current OS thread to cpuID and/or numaID.
-// If cpuID or numaID is -1, a reasonable default is chosen.
+// If numaID is -1, a reasonable default is chosen; if cpuID is -1, the thread
+// may run on any CPU of the node.
 func (a *AffinityAdapter) Pin(cpuID, numaID int) error {
 	node := normalize.NUMANodeAuto(numaID)
-	cpu := normalize.CPUIndexAuto(cpuID)
+	cpu := -1
+	if cpuID >= 0 {
+		cpu = normalize.CPUIndexAuto(cpuID)
+	}
 
 	if err := concurrency.PinCurrentThread(node, cpu); err != nil {
 		return err
@@ -67,6 +71,18 @@ func (a *AffinityAdapter) Scope() api.AffinityScope {
 	return a.scope
 }
 
+// CPUTopology returns the logical processors of the host ordered by ID, with
+// their NUMA node and, on Windows hosts with more than 64 processors, their
+// processor group.
+func CPUTopology() []api.CPUInfo {
+	cpus := concurrency.Topology()
+	out := make([]api.CPUInfo, len(cpus))
+	for i, c := range cpus {
+		out[i] = api.CPUInfo{ID: c.ID, NUMANode: c.Node, Group: c.Group, Number: c.Number}
+	}
+	return out
+}
+
 // ImmutableDescriptor returns a snapshot of the current binding state.
 func (a *AffinityAdapter) ImmutableDescriptor() api.AffinityDescriptor {
 	return api.AffinityDescriptor{
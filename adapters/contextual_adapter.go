@@ -7,6 +7,7 @@ package adapters
 
 import (
 	"context"
+	"time"
 
 	"github.com/momentics/hioload-ws/api"
 )
@@ -43,6 +44,21 @@ func ContextualHandler(base api.Handler) api.Handler {
 	})
 }
 
+// HandlerWithContext adapts h to an api.Handler for the poller: every event
+// is handled under its connection's context (see api.ContextFromData),
+// bounded by timeout if positive.
+func HandlerWithContext(h api.HandlerCtx, timeout time.Duration) api.Handler {
+	return api.HandlerFunc(func(data any) error {
+		ctx := api.ContextFromData(data)
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return h.Handle(ctx, data)
+	})
+}
+
 // eventWithCtx wraps an event and provides its context.
 type eventWithCtx struct {
 	data any
@@ -41,6 +41,10 @@ func (c *ControlAdapter) GetConfig() map[string]any {
 // SetConfig synchronously updates configuration and invokes all listeners and reload hooks.
 // This solves test flakiness by making OnReload deterministic.
 func (c *ControlAdapter) SetConfig(cfg map[string]any) error {
+	// 0. "log.level*" keys adjust subsystem log levels at runtime.
+	if err := control.ApplyLogConfig(cfg); err != nil {
+		return err
+	}
 	// 1. Merge new values and synchronously notify instance listeners.
 	c.config.SetConfigSync(cfg)
 	// 2. Synchronously invoke all global hot-reload hooks for test determinism.
@@ -49,20 +53,33 @@ func (c *ControlAdapter) SetConfig(cfg map[string]any) error {
 }
 
 // Stats returns a merged map of config, metrics, and debug-probe data.
+// Latency histograms are expanded into their count and percentiles.
 func (c *ControlAdapter) Stats() map[string]any {
 	combined := make(map[string]any)
 	for k, v := range c.config.GetSnapshot() {
 		combined[k] = v
 	}
 	for k, v := range c.metrics.GetSnapshot() {
-		combined["metrics."+k] = v
+		addStat(combined, "metrics."+k, v)
 	}
 	for k, v := range c.debug.DumpState() {
-		combined["debug."+k] = v
+		addStat(combined, "debug."+k, v)
 	}
 	return combined
 }
 
+// addStat stores v under key, flattening latency histograms.
+func addStat(stats map[string]any, key string, v any) {
+	h, ok := v.(*control.LatencyHistogram)
+	if !ok {
+		stats[key] = v
+		return
+	}
+	for k, q := range control.ExpandLatency(key, h) {
+		stats[k] = q
+	}
+}
+
 // OnReload registers a new hot-reload callback.
 // Both instance and global registration are used for completeness.
 func (c *ControlAdapter) OnReload(fn func()) {
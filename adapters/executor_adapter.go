@@ -46,6 +46,16 @@ func (ea *ExecutorAdapter) Resize(newCount int) {
 	ea.exec.Resize(newCount)
 }
 
+// SetPanicHandler installs fn to receive the panics recovered from tasks.
+func (ea *ExecutorAdapter) SetPanicHandler(fn func(recovered any, stack []byte)) {
+	ea.exec.SetPanicHandler(fn)
+}
+
+// Panics returns the number of task panics recovered.
+func (ea *ExecutorAdapter) Panics() uint64 {
+	return ea.exec.Panics()
+}
+
 // Close shuts down the executor, signaling all workers to exit and waiting for completion.
 // This method ensures a graceful teardown: all submitted tasks are either executed or discarded safely.
 func (ea *ExecutorAdapter) Close() {
@@ -7,11 +7,15 @@
 package adapters
 
 import (
-	"log"
+	"fmt"
 
 	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/control"
 )
 
+// middlewareLog is the logger of the built-in middleware.
+var middlewareLog = control.Logger(control.LogMiddleware)
+
 // HandlerFunc converts a function into an api.Handler.
 type HandlerFunc func(data any) error
 
@@ -52,10 +56,10 @@ func (m *MiddlewareHandler) Handle(data any) error {
 // LoggingMiddleware logs entry, exit, and errors of handler invocation.
 func LoggingMiddleware(next api.Handler) api.Handler {
 	return HandlerFunc(func(data any) error {
-		log.Printf("[Handler] Processing data: %T", data)
+		middlewareLog.Debug("handler processing", "data", fmt.Sprintf("%T", data))
 		err := next.Handle(data)
 		if err != nil {
-			log.Printf("[Handler] Error: %v", err)
+			middlewareLog.Error("handler error", "error", err)
 		}
 		return err
 	})
@@ -66,7 +70,7 @@ func RecoveryMiddleware(next api.Handler) api.Handler {
 	return HandlerFunc(func(data any) error {
 		defer func() {
 			if r := recover(); r != nil {
-				log.Printf("[Handler] Panic recovered: %v", r)
+				middlewareLog.Error("panic recovered in handler", "panic", r)
 			}
 		}()
 		return next.Handle(data)
@@ -0,0 +1,59 @@
+// File: adapters/kafka/kafka.go
+// Package kafka connects a pubsub.Broker to Kafka topics.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// A Sink publishes broker messages (typically inbound WebSocket messages an
+// application publishes to the broker) to Kafka in batches; a Source streams
+// Kafka records into the broker, from where they reach connections subscribed
+// with highlevel.Conn.Subscribe. Records travel as api.Batch[Record] in both
+// directions, matching the batch-oriented I/O used across hioload-ws.
+//
+// The Kafka client itself is pluggable through the Producer and Consumer
+// interfaces, so deployments keep their existing client library, partitioner
+// and consumer-group configuration; wrapping one takes a few lines:
+//
+//	type producer struct{ w *kafkago.Writer }
+//
+//	func (p producer) Produce(ctx context.Context, b api.Batch[kafka.Record]) error {
+//		msgs := make([]kafkago.Message, b.Len())
+//		for i := range msgs {
+//			r := b.Get(i)
+//			msgs[i] = kafkago.Message{Topic: r.Topic, Key: r.Key, Value: r.Value}
+//		}
+//		return p.w.WriteMessages(ctx, msgs...)
+//	}
+
+package kafka
+
+import (
+	"context"
+
+	"github.com/momentics/hioload-ws/api"
+)
+
+// Origin marks broker messages that arrived from Kafka.
+const Origin = "kafka"
+
+// Record is a Kafka record as seen by the adapter.
+type Record struct {
+	Topic     string
+	Key       []byte
+	Value     []byte
+	Partition int32 // set by Consumers; ignored by Producers
+	Offset    int64 // set by Consumers; ignored by Producers
+}
+
+// Producer writes record batches to Kafka. The batch and its records are
+// reused after Produce returns.
+type Producer interface {
+	Produce(ctx context.Context, batch api.Batch[Record]) error
+	Close() error
+}
+
+// Consumer polls record batches from Kafka. Poll blocks until records are
+// available or ctx is done; the returned batch stays valid until the next Poll.
+type Consumer interface {
+	Poll(ctx context.Context) (api.Batch[Record], error)
+	Close() error
+}
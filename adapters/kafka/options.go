@@ -0,0 +1,94 @@
+// File: adapters/kafka/options.go
+// Package kafka
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package kafka
+
+import (
+	"time"
+
+	"github.com/momentics/hioload-ws/pubsub"
+)
+
+// Defaults for Sink batching.
+const (
+	DefaultBatchSize = 100
+	DefaultLinger    = 10 * time.Millisecond
+)
+
+// Option customizes a Sink or Source.
+type Option func(*config)
+
+type config struct {
+	prefix    string
+	patterns  []string
+	batchSize int
+	linger    time.Duration
+	keyFunc   func(pubsub.Message) []byte
+	onErr     func(error)
+}
+
+func newConfig(opts []Option) config {
+	cfg := config{batchSize: DefaultBatchSize, linger: DefaultLinger}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if len(cfg.patterns) == 0 {
+		cfg.patterns = []string{pubsub.WildcardTail}
+	}
+	return cfg
+}
+
+// WithTopicPrefix maps broker topic T to Kafka topic prefix+T and back.
+func WithTopicPrefix(prefix string) Option {
+	return func(c *config) {
+		c.prefix = prefix
+	}
+}
+
+// WithPatterns selects the broker topics a Sink forwards (default ">" = all).
+func WithPatterns(patterns ...string) Option {
+	return func(c *config) {
+		c.patterns = append(c.patterns, patterns...)
+	}
+}
+
+// WithBatchSize flushes a Sink batch once it holds n records.
+func WithBatchSize(n int) Option {
+	return func(c *config) {
+		if n > 0 {
+			c.batchSize = n
+		}
+	}
+}
+
+// WithLinger flushes a non-empty Sink batch at most d after its first record.
+func WithLinger(d time.Duration) Option {
+	return func(c *config) {
+		if d > 0 {
+			c.linger = d
+		}
+	}
+}
+
+// WithKeyFunc derives the record key (and thus partition) from a message,
+// e.g. a session or user ID; by default records carry no key.
+func WithKeyFunc(fn func(pubsub.Message) []byte) Option {
+	return func(c *config) {
+		c.keyFunc = fn
+	}
+}
+
+// OnError registers a callback for produce/poll errors.
+func OnError(fn func(error)) Option {
+	return func(c *config) {
+		c.onErr = fn
+	}
+}
+
+func (c *config) report(err error) {
+	if c.onErr != nil && err != nil {
+		c.onErr(err)
+	}
+}
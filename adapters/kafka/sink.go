@@ -0,0 +1,131 @@
+// File: adapters/kafka/sink.go
+// Package kafka
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package kafka
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/momentics/hioload-ws/pool"
+	"github.com/momentics/hioload-ws/pubsub"
+)
+
+// Sink batches broker messages into Kafka records.
+type Sink struct {
+	cfg      config
+	producer Producer
+	subs     []*pubsub.Subscription
+	in       chan pubsub.Message
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	feeds  sync.WaitGroup
+	done   chan struct{}
+	once   sync.Once
+}
+
+// NewSink subscribes to the configured patterns on broker and starts
+// producing to p. Messages that came from Kafka are not produced again.
+func NewSink(broker *pubsub.Broker, p Producer, opts ...Option) (*Sink, error) {
+	cfg := newConfig(opts)
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Sink{
+		cfg:      cfg,
+		producer: p,
+		in:       make(chan pubsub.Message, cfg.batchSize),
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	for _, pattern := range cfg.patterns {
+		sub, err := broker.Subscribe(pattern)
+		if err != nil {
+			s.unsubscribe()
+			cancel()
+			return nil, err
+		}
+		s.subs = append(s.subs, sub)
+	}
+	for _, sub := range s.subs {
+		s.feeds.Add(1)
+		go s.feed(sub)
+	}
+	go func() {
+		s.feeds.Wait()
+		close(s.in)
+	}()
+	go s.run()
+	return s, nil
+}
+
+// Close stops consuming, flushes the pending batch and closes the Producer.
+func (s *Sink) Close() error {
+	var err error
+	s.once.Do(func() {
+		s.unsubscribe()
+		<-s.done
+		s.cancel()
+		err = s.producer.Close()
+	})
+	return err
+}
+
+// feed merges one subscription into the batching channel.
+func (s *Sink) feed(sub *pubsub.Subscription) {
+	defer s.feeds.Done()
+	for msg := range sub.C() {
+		if msg.Origin != Origin {
+			s.in <- msg
+		}
+	}
+}
+
+// run accumulates records and flushes on size or linger.
+func (s *Sink) run() {
+	defer close(s.done)
+	batch := pool.NewSliceBatch[Record](s.cfg.batchSize)
+	timer := time.NewTimer(s.cfg.linger)
+	timer.Stop()
+	defer timer.Stop()
+
+	flush := func() {
+		if batch.Len() == 0 {
+			return
+		}
+		s.cfg.report(s.producer.Produce(s.ctx, batch))
+		batch.Reset()
+	}
+	for {
+		select {
+		case msg, ok := <-s.in:
+			if !ok {
+				flush()
+				return
+			}
+			rec := Record{Topic: s.cfg.prefix + msg.Topic, Value: msg.Payload}
+			if s.cfg.keyFunc != nil {
+				rec.Key = s.cfg.keyFunc(msg)
+			}
+			batch.Append(rec)
+			if batch.Len() == 1 {
+				timer.Reset(s.cfg.linger)
+			}
+			if batch.Len() >= s.cfg.batchSize {
+				timer.Stop()
+				flush()
+			}
+		case <-timer.C:
+			flush()
+		}
+	}
+}
+
+func (s *Sink) unsubscribe() {
+	for _, sub := range s.subs {
+		sub.Unsubscribe()
+	}
+}
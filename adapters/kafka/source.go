@@ -0,0 +1,80 @@
+// File: adapters/kafka/source.go
+// Package kafka
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package kafka
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/momentics/hioload-ws/pubsub"
+)
+
+// pollBackoff is the pause after a failed Poll.
+const pollBackoff = 500 * time.Millisecond
+
+// Source streams Kafka records into a broker.
+type Source struct {
+	cfg      config
+	broker   *pubsub.Broker
+	consumer Consumer
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// NewSource starts polling c and publishing each record's value to the
+// broker topic derived from its Kafka topic (prefix stripped). Records whose
+// topic lacks the prefix are skipped.
+func NewSource(broker *pubsub.Broker, c Consumer, opts ...Option) *Source {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Source{
+		cfg:      newConfig(opts),
+		broker:   broker,
+		consumer: c,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	go s.run(ctx)
+	return s
+}
+
+// Close stops polling and closes the Consumer.
+func (s *Source) Close() error {
+	s.cancel()
+	<-s.done
+	return s.consumer.Close()
+}
+
+func (s *Source) run(ctx context.Context) {
+	defer close(s.done)
+	for ctx.Err() == nil {
+		batch, err := s.consumer.Poll(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+				return
+			}
+			s.cfg.report(err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pollBackoff):
+			}
+			continue
+		}
+		for i := 0; i < batch.Len(); i++ {
+			rec := batch.Get(i)
+			if !strings.HasPrefix(rec.Topic, s.cfg.prefix) {
+				continue
+			}
+			s.broker.PublishMessage(pubsub.Message{
+				Topic:   rec.Topic[len(s.cfg.prefix):],
+				Payload: rec.Value,
+				Origin:  Origin,
+			})
+		}
+	}
+}
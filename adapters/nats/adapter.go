@@ -0,0 +1,402 @@
+// File: adapters/nats/adapter.go
+// Package nats connects a pubsub.Broker to a NATS backbone.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// The Adapter lets hioload-ws act as the WebSocket edge of an existing NATS
+// deployment: messages on inbound NATS subjects are published to the local
+// broker (and from there to subscribed connections), and local messages on
+// outbound topics are published to NATS. Subjects map to topics by stripping
+// or adding an optional prefix; NATS and pubsub share the "*"/">" wildcard
+// syntax, so patterns carry over unchanged.
+//
+// Payloads pass through without copying: each NATS message body is read into
+// one buffer that becomes Message.Payload, and outbound payloads are written
+// to the socket straight from the published slice.
+//
+// The client speaks the NATS text protocol directly (CONNECT/SUB/PUB/MSG/
+// PING), connects with echo disabled so its own publishes are not looped
+// back, and reconnects with backoff, re-issuing its subscriptions.
+
+package nats
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/momentics/hioload-ws/pubsub"
+)
+
+// Origin marks broker messages that arrived from NATS.
+const Origin = "nats"
+
+// Backoff bounds for reconnecting to the server.
+const (
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 5 * time.Second
+)
+
+// ErrClosed is returned after Close.
+var ErrClosed = errors.New("nats: adapter closed")
+
+// Option customizes an Adapter.
+type Option func(*Adapter)
+
+// WithInbound forwards NATS subjects matching subject into the broker.
+func WithInbound(subject string) Option {
+	return func(a *Adapter) {
+		a.inbound = append(a.inbound, subject)
+	}
+}
+
+// WithOutbound publishes broker topics matching pattern to NATS.
+func WithOutbound(pattern string) Option {
+	return func(a *Adapter) {
+		a.outbound = append(a.outbound, pattern)
+	}
+}
+
+// WithSubjectPrefix maps topic T to subject prefix+T and back.
+func WithSubjectPrefix(prefix string) Option {
+	return func(a *Adapter) {
+		a.prefix = prefix
+	}
+}
+
+// WithUserInfo authenticates with user and password.
+func WithUserInfo(user, password string) Option {
+	return func(a *Adapter) {
+		a.user, a.pass = user, password
+	}
+}
+
+// WithToken authenticates with a token.
+func WithToken(token string) Option {
+	return func(a *Adapter) {
+		a.token = token
+	}
+}
+
+// WithName sets the client name reported to the server.
+func WithName(name string) Option {
+	return func(a *Adapter) {
+		a.name = name
+	}
+}
+
+// WithTimeout bounds dialing and the connect handshake (default 2s).
+func WithTimeout(d time.Duration) Option {
+	return func(a *Adapter) {
+		a.timeout = d
+	}
+}
+
+// OnError registers a callback for connection and protocol errors.
+func OnError(fn func(error)) Option {
+	return func(a *Adapter) {
+		a.onErr = fn
+	}
+}
+
+// Adapter bridges a pubsub.Broker and a NATS server.
+type Adapter struct {
+	addr     string
+	broker   *pubsub.Broker
+	inbound  []string
+	outbound []string
+	prefix   string
+	user     string
+	pass     string
+	token    string
+	name     string
+	timeout  time.Duration
+	onErr    func(error)
+
+	wmu  sync.Mutex // guards conn writes and conn swaps
+	conn net.Conn
+
+	subs      []*pubsub.Subscription
+	received  atomic.Uint64
+	sent      atomic.Uint64
+	stop      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// New dials the NATS server at addr ("host:4222"), subscribes the inbound
+// subjects and starts forwarding outbound topics.
+func New(addr string, broker *pubsub.Broker, opts ...Option) (*Adapter, error) {
+	a := &Adapter{
+		addr:    addr,
+		broker:  broker,
+		name:    "hioload-ws",
+		timeout: 2 * time.Second,
+		stop:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	conn, rd, err := a.connect()
+	if err != nil {
+		return nil, err
+	}
+	a.conn = conn
+
+	for _, pattern := range a.outbound {
+		sub, err := broker.Subscribe(pattern)
+		if err != nil {
+			a.Close()
+			return nil, err
+		}
+		a.subs = append(a.subs, sub)
+		a.wg.Add(1)
+		go a.forward(sub)
+	}
+	a.wg.Add(1)
+	go a.readLoop(conn, rd)
+	return a, nil
+}
+
+// Stats returns messages received from and sent to NATS.
+func (a *Adapter) Stats() (received, sent uint64) {
+	return a.received.Load(), a.sent.Load()
+}
+
+// Close unsubscribes from the broker and closes the connection.
+func (a *Adapter) Close() error {
+	a.closeOnce.Do(func() {
+		close(a.stop)
+		for _, sub := range a.subs {
+			sub.Unsubscribe()
+		}
+		a.wmu.Lock()
+		if a.conn != nil {
+			a.conn.Close()
+		}
+		a.wmu.Unlock()
+		a.wg.Wait()
+	})
+	return nil
+}
+
+// Publish sends payload to the NATS subject for topic.
+func (a *Adapter) Publish(topic string, payload []byte) error {
+	a.wmu.Lock()
+	defer a.wmu.Unlock()
+	if a.conn == nil {
+		return ErrClosed
+	}
+	hdr := make([]byte, 0, 16+len(a.prefix)+len(topic))
+	hdr = append(hdr, "PUB "...)
+	hdr = append(hdr, a.prefix...)
+	hdr = append(hdr, topic...)
+	hdr = append(hdr, ' ')
+	hdr = strconv.AppendInt(hdr, int64(len(payload)), 10)
+	hdr = append(hdr, "\r\n"...)
+	bufs := net.Buffers{hdr, payload, []byte("\r\n")}
+	if _, err := bufs.WriteTo(a.conn); err != nil {
+		return fmt.Errorf("nats: publish: %w", err)
+	}
+	a.sent.Add(1)
+	return nil
+}
+
+// forward publishes local broker messages to NATS.
+func (a *Adapter) forward(sub *pubsub.Subscription) {
+	defer a.wg.Done()
+	for msg := range sub.C() {
+		if msg.Origin == Origin {
+			continue
+		}
+		if err := a.Publish(msg.Topic, msg.Payload); err != nil {
+			a.report(err)
+		}
+	}
+}
+
+// readLoop dispatches server messages, reconnecting until Close.
+func (a *Adapter) readLoop(conn net.Conn, rd *bufio.Reader) {
+	defer a.wg.Done()
+	backoff := minBackoff
+	for {
+		if conn != nil {
+			err := a.read(conn, rd)
+			conn.Close()
+			if a.stopped() {
+				return
+			}
+			a.report(err)
+			backoff = minBackoff
+		}
+		select {
+		case <-a.stop:
+			return
+		case <-time.After(backoff):
+		}
+		var err error
+		if conn, rd, err = a.connect(); err != nil {
+			a.report(err)
+			conn = nil
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+		a.wmu.Lock()
+		if a.stopped() {
+			a.wmu.Unlock()
+			conn.Close()
+			return
+		}
+		a.conn = conn
+		a.wmu.Unlock()
+	}
+}
+
+// read processes protocol lines until the connection fails.
+func (a *Adapter) read(conn net.Conn, rd *bufio.Reader) error {
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case strings.HasPrefix(line, "MSG "):
+			if err := a.deliver(line, rd); err != nil {
+				return err
+			}
+		case line == "PING":
+			a.wmu.Lock()
+			_, err = conn.Write([]byte("PONG\r\n"))
+			a.wmu.Unlock()
+			if err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			a.report(errors.New("nats: server error: " + strings.TrimSpace(line[4:])))
+		}
+	}
+}
+
+// deliver reads one MSG body and publishes it to the broker.
+// Format: MSG <subject> <sid> [reply-to] <#bytes>
+func (a *Adapter) deliver(line string, rd *bufio.Reader) error {
+	fields := strings.Fields(line)
+	if len(fields) < 4 {
+		return fmt.Errorf("nats: malformed %q", line)
+	}
+	n, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil || n < 0 {
+		return fmt.Errorf("nats: malformed %q", line)
+	}
+	body := make([]byte, n+2)
+	if _, err := io.ReadFull(rd, body); err != nil {
+		return err
+	}
+	subject := fields[1]
+	if !strings.HasPrefix(subject, a.prefix) {
+		return nil
+	}
+	a.received.Add(1)
+	a.broker.PublishMessage(pubsub.Message{
+		Topic:   subject[len(a.prefix):],
+		Payload: body[:n],
+		Origin:  Origin,
+	})
+	return nil
+}
+
+// connectInfo is the CONNECT payload.
+type connectInfo struct {
+	Verbose  bool   `json:"verbose"`
+	Pedantic bool   `json:"pedantic"`
+	Echo     bool   `json:"echo"`
+	Name     string `json:"name,omitempty"`
+	Lang     string `json:"lang"`
+	Version  string `json:"version"`
+	User     string `json:"user,omitempty"`
+	Pass     string `json:"pass,omitempty"`
+	Token    string `json:"auth_token,omitempty"`
+}
+
+// connect dials, completes the INFO/CONNECT exchange and subscribes the
+// inbound subjects; a PING/PONG round trip confirms the server accepted all.
+func (a *Adapter) connect() (net.Conn, *bufio.Reader, error) {
+	conn, err := net.DialTimeout("tcp", a.addr, a.timeout)
+	if err != nil {
+		return nil, nil, fmt.Errorf("nats: dial: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(a.timeout))
+	rd := bufio.NewReader(conn)
+	fail := func(err error) (net.Conn, *bufio.Reader, error) {
+		conn.Close()
+		return nil, nil, fmt.Errorf("nats: connect: %w", err)
+	}
+
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return fail(err)
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fail(fmt.Errorf("unexpected greeting %q", strings.TrimSpace(line)))
+	}
+
+	info, _ := json.Marshal(connectInfo{
+		Name: a.name, Lang: "go", Version: "hioload-ws",
+		User: a.user, Pass: a.pass, Token: a.token,
+	})
+	cmd := append([]byte("CONNECT "), info...)
+	cmd = append(cmd, "\r\n"...)
+	for i, subject := range a.inbound {
+		cmd = append(cmd, "SUB "...)
+		cmd = append(cmd, a.prefix...)
+		cmd = append(cmd, subject...)
+		cmd = append(cmd, ' ')
+		cmd = strconv.AppendInt(cmd, int64(i+1), 10)
+		cmd = append(cmd, "\r\n"...)
+	}
+	cmd = append(cmd, "PING\r\n"...)
+	if _, err := conn.Write(cmd); err != nil {
+		return fail(err)
+	}
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return fail(err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "PONG" {
+			break
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			return fail(errors.New(strings.TrimSpace(line[4:])))
+		}
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, rd, nil
+}
+
+func (a *Adapter) stopped() bool {
+	select {
+	case <-a.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (a *Adapter) report(err error) {
+	if a.onErr != nil && err != nil {
+		a.onErr(err)
+	}
+}
@@ -100,3 +100,47 @@ type apiEventWrapper struct {
 func (w apiEventWrapper) Data() any {
 	return w.ev.Data()
 }
+
+// FlowKey forwards the wrapped event's concurrency.Flow key, nil if none.
+func (w apiEventWrapper) FlowKey() any {
+	if f, ok := w.ev.(concurrency.Flow); ok {
+		return f.FlowKey()
+	}
+	return nil
+}
+
+// FlowCost forwards the wrapped event's concurrency.Flow cost, 0 if none.
+func (w apiEventWrapper) FlowCost() int {
+	if f, ok := w.ev.(concurrency.Flow); ok {
+		return f.FlowCost()
+	}
+	return 0
+}
+
+// SetQuantum sets the bytes each connection may dispatch per scheduling
+// round, see concurrency.EventLoop.SetQuantum.
+func (p *PollerAdapter) SetQuantum(n int) {
+	p.eventLoop.SetQuantum(n)
+}
+
+// SetPanicHandler installs fn to receive the panics recovered from handlers,
+// with the data of the event being handled.
+func (p *PollerAdapter) SetPanicHandler(fn func(data any, recovered any, stack []byte)) {
+	if fn == nil {
+		p.eventLoop.SetPanicHandler(nil)
+		return
+	}
+	p.eventLoop.SetPanicHandler(func(ev concurrency.Event, recovered any, stack []byte) {
+		fn(ev.Data(), recovered, stack)
+	})
+}
+
+// Panics returns the number of handler panics recovered by the reactor.
+func (p *PollerAdapter) Panics() uint64 {
+	return p.eventLoop.Panics()
+}
+
+// FairStats returns the reactor's fair-scheduling metrics.
+func (p *PollerAdapter) FairStats() concurrency.FairStats {
+	return p.eventLoop.FairStats()
+}
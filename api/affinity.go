@@ -22,6 +22,14 @@ type AffinityDescriptor struct {
 	Pinned bool
 }
 
+// CPUInfo describes one logical processor of the host topology.
+type CPUInfo struct {
+	ID       int // host-wide CPU index, as taken by Affinity.Pin
+	NUMANode int
+	Group    int // Windows processor group; 0 elsewhere
+	Number   int // index within Group
+}
+
 // Affinity defines CPU/NUMA binding contract.
 type Affinity interface {
 	// Pin assigns the current entity to specific CPU and/or NUMA node.
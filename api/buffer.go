@@ -12,6 +12,7 @@ type Buffer struct {
 	NUMA     int
 	Pool     Releaser
 	Class    int
+	Slab     []byte // whole allocation Data is a view of, if the pool tracks it
 }
 
 // Releaser interface to decouple pool dependency.
@@ -35,13 +36,14 @@ func (b Buffer) Copy() []byte {
 // Slice returns a new Buffer view sharing the same underlying memory.
 func (b Buffer) Slice(from, to int) Buffer {
 	if from < 0 || to > len(b.Data) || from > to {
-		return Buffer{NUMA: b.NUMA, Class: b.Class, Pool: b.Pool}
+		return Buffer{NUMA: b.NUMA, Class: b.Class, Pool: b.Pool, Slab: b.Slab}
 	}
 	return Buffer{
 		Data:  b.Data[from:to],
 		NUMA:  b.NUMA,
 		Pool:  b.Pool,
 		Class: b.Class,
+		Slab:  b.Slab,
 	}
 }
 
@@ -52,6 +54,14 @@ func (b Buffer) Release() {
 	}
 }
 
+// Shared reports whether several owners hold the buffer's memory, as with
+// pool.Share. A shared buffer is read-only: writing to Data changes what
+// every other owner sees.
+func (b Buffer) Shared() bool {
+	s, ok := b.Pool.(interface{ Shared() bool })
+	return ok && s.Shared()
+}
+
 // Capacity returns the capacity of the underlying slice.
 func (b Buffer) Capacity() int {
 	return cap(b.Data)
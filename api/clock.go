@@ -0,0 +1,62 @@
+// File: api/clock.go
+// Package api defines the clock contract.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package api
+
+import "time"
+
+// Clock is the source of time of timeouts, keepalive pings and draining.
+// SystemClock is the real one; tests substitute a simulated clock (see the
+// sim package) to run that logic without sleeping.
+type Clock interface {
+	Now() time.Time
+	// NewTimer returns a timer delivering the time on C once d passed.
+	NewTimer(d time.Duration) Timer
+	// NewTicker returns a ticker delivering the time on C every d, dropping
+	// ticks for slow receivers.
+	NewTicker(d time.Duration) Ticker
+	// AfterFunc calls fn once d passed; SystemClock calls it in its own
+	// goroutine. The timer's C is nil.
+	AfterFunc(d time.Duration, fn func()) Timer
+}
+
+// Timer is a time.Timer of a Clock.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is a time.Ticker of a Clock.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// SystemClock is the Clock of package time.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTimer(d time.Duration) Timer { return systemTimer{time.NewTimer(d)} }
+
+func (systemClock) NewTicker(d time.Duration) Ticker { return systemTicker{time.NewTicker(d)} }
+
+func (systemClock) AfterFunc(d time.Duration, fn func()) Timer {
+	return systemTimer{time.AfterFunc(d, fn)}
+}
+
+type systemTimer struct{ t *time.Timer }
+
+func (t systemTimer) C() <-chan time.Time        { return t.t.C }
+func (t systemTimer) Stop() bool                 { return t.t.Stop() }
+func (t systemTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+
+type systemTicker struct{ t *time.Ticker }
+
+func (t systemTicker) C() <-chan time.Time { return t.t.C }
+func (t systemTicker) Stop()               { t.t.Stop() }
@@ -41,12 +41,23 @@ func ContextWithConnection(ctx context.Context, conn any) context.Context {
 }
 
 // ContextFromData extracts the context from handler data.
-// It expects data to wrap a context, for example via ContextualHandler.
+// It expects data to wrap a context, for example via ContextualHandler, or
+// to be an OpenEvent or CloseEvent.
 func ContextFromData(data any) context.Context {
 	// If data implements interface{ Ctx() context.Context }, use that.
 	if withCtx, ok := data.(interface{ Ctx() context.Context }); ok {
 		return withCtx.Ctx()
 	}
+	switch evt := data.(type) {
+	case OpenEvent:
+		if evt.Ctx != nil {
+			return evt.Ctx
+		}
+	case CloseEvent:
+		if evt.Ctx != nil {
+			return evt.Ctx
+		}
+	}
 	// Fallback to background context.
 	return context.Background()
 }
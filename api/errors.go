@@ -19,6 +19,31 @@ var (
 	ErrNotFound          = fmt.Errorf("resource not found")
 )
 
+// WebSocket errors reported by the server and client facades, to be tested
+// with errors.Is; the errors returned wrap them with details.
+var (
+	ErrListenerClosed  = fmt.Errorf("listener closed")
+	ErrHandshakeFailed = fmt.Errorf("websocket handshake failed")
+	ErrMessageTooBig   = fmt.Errorf("message too big")
+	ErrWriteQueueFull  = fmt.Errorf("write queue full")
+)
+
+// CloseError reports that the connection was closed by a close handshake,
+// with the code and reason of the first close frame sent or received.
+// Retrieve it with errors.As.
+type CloseError struct {
+	Code   uint16
+	Reason string
+}
+
+// Error implements the error interface.
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket: close %d", e.Code)
+	}
+	return fmt.Sprintf("websocket: close %d: %s", e.Code, e.Reason)
+}
+
 // ErrorCode represents specific error conditions in the library.
 type ErrorCode int
 
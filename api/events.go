@@ -7,16 +7,35 @@ package api
 
 import (
 	"context"
+	"crypto/tls"
+	"net"
 )
 
 // OpenEvent is emitted when a new WebSocket connection is accepted.
 type OpenEvent struct {
-	Conn any             // underlying connection object, e.g. *protocol.WSConnection
-	Ctx  context.Context // context carrying per-connection values
+	Conn    any             // underlying connection object, e.g. *protocol.WSConnection
+	Ctx     context.Context // context carrying per-connection values
+	Session Session         // session bound to the connection
+
+	// Handshake metadata
+	RemoteAddr  net.Addr             // peer address, nil if the transport has none
+	Path        string               // request path of the upgrade
+	Subprotocol string               // selected Sec-WebSocket-Protocol, "" if none
+	Extensions  string               // negotiated Sec-WebSocket-Extensions, "" if none
+	TLS         *tls.ConnectionState // nil unless accepted over TLS
 }
 
 // CloseEvent is emitted when a WebSocket connection is closed.
 type CloseEvent struct {
-	Conn any
-	Ctx  context.Context
+	Conn    any
+	Ctx     context.Context
+	Session Session
+	Err     error // read or protocol error that ended the connection, nil on a clean close
+
+	Code   uint16 // first close code sent or received, 1006 if no close frame was
+	Reason string // close reason accompanying Code
+
+	// Traffic over the connection's lifetime
+	BytesIn, BytesOut   int64
+	FramesIn, FramesOut int64
 }
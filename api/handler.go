@@ -5,6 +5,8 @@
 
 package api
 
+import "context"
+
 // Handler processes data payloads.
 type Handler interface {
 	Handle(data any) error
@@ -18,3 +20,17 @@ type HandlerFunc func(data any) error
 func (fn HandlerFunc) Handle(data any) error {
 	return fn(data)
 }
+
+// HandlerCtx processes data payloads under the context of the connection
+// they arrived on, which is cancelled when the connection closes.
+type HandlerCtx interface {
+	Handle(ctx context.Context, data any) error
+}
+
+// HandlerCtxFunc converts a function into a HandlerCtx.
+type HandlerCtxFunc func(ctx context.Context, data any) error
+
+// Handle calls fn.
+func (fn HandlerCtxFunc) Handle(ctx context.Context, data any) error {
+	return fn(ctx, data)
+}
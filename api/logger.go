@@ -0,0 +1,15 @@
+// File: api/logger.go
+// Package api defines the structured logging contract.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package api
+
+// Logger is the structured logger used across hioload-ws. args are
+// alternating key/value pairs, as with log/slog; *slog.Logger satisfies it.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
@@ -0,0 +1,29 @@
+// File: api/session.go
+// Package api defines the per-connection session contract.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package api
+
+import "time"
+
+// Session is the application-visible state bound to a single connection.
+// Attribute access is safe for concurrent use from handlers and middleware.
+type Session interface {
+	// ID returns the unique session identifier.
+	ID() string
+	// Get fetches an attribute, returning (value, exists).
+	Get(key string) (any, bool)
+	// Set stores an attribute under key.
+	Set(key string, value any)
+	// Delete removes an attribute.
+	Delete(key string)
+	// Context exposes the underlying propagation-aware store.
+	Context() Context
+	// Done is closed when the session is torn down.
+	Done() <-chan struct{}
+	// Deadline reports the session expiration, if any.
+	Deadline() (time.Time, bool)
+	// Touch records activity, deferring idle expiry.
+	Touch()
+}
@@ -25,3 +25,11 @@ type Transport interface {
 	// Features reports transport capabilities.
 	Features() TransportFeatures
 }
+
+// BufferReceiver is implemented by transports that read into buffers from a
+// BufferPool. RecvBuffers is Recv handing over the buffers themselves: the
+// caller owns each and releases it once done with its bytes, so they go back
+// to the pool instead of being left to the garbage collector.
+type BufferReceiver interface {
+	RecvBuffers() ([]Buffer, error)
+}
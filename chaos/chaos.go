@@ -0,0 +1,239 @@
+// File: chaos/chaos.go
+// Package chaos injects faults into connections so applications can be
+// tested against them: dropped frames, slow writes, connections cut at
+// random and failed buffer allocations.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Faults are only injected in builds with the chaos tag (go test -tags
+// chaos ./...). Elsewhere Enabled is false and an Injector passes
+// everything through untouched, so production binaries carry no fault
+// paths. Servers built with the tag create an Injector configured through
+// control; see server.CfgChaosDropEvery and the keys next to it.
+
+package chaos
+
+import (
+	"crypto/tls"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// ErrInjected is returned by transport calls failed on purpose.
+var ErrInjected = errors.New("chaos: injected failure")
+
+// Config selects the faults; the zero Config injects none.
+type Config struct {
+	DropEvery     int           // drop every Nth data frame of a connection, per direction (0 = none)
+	SendDelay     time.Duration // added to every transport write
+	SendJitter    time.Duration // random extra write delay, up to this
+	CloseRate     float64       // probability that a transport read or write cuts the connection
+	AllocFailRate float64       // probability that a buffer allocation fails
+}
+
+// Stats counts the faults injected.
+type Stats struct {
+	Dropped     int64 // data frames dropped
+	Delayed     int64 // transport writes delayed
+	Closed      int64 // connections cut
+	AllocFailed int64 // buffer allocations failed
+}
+
+// Injector injects the faults of its current Config into the connections
+// and pools it wraps. It is safe for concurrent use; Set applies to
+// wrapped connections at once.
+type Injector struct {
+	cfg atomic.Pointer[Config]
+
+	mu  sync.Mutex
+	rng *rand.Rand
+
+	dropped, delayed, closed, allocFailed atomic.Int64
+}
+
+// New returns an Injector with cfg, drawing its random faults from seed.
+func New(cfg Config, seed uint64) *Injector {
+	i := &Injector{rng: rand.New(rand.NewPCG(seed, seed))}
+	i.Set(cfg)
+	return i
+}
+
+// Set replaces the Config.
+func (i *Injector) Set(cfg Config) {
+	i.cfg.Store(&cfg)
+}
+
+// Config returns the current Config.
+func (i *Injector) Config() Config {
+	return *i.cfg.Load()
+}
+
+// Stats returns the faults injected so far.
+func (i *Injector) Stats() Stats {
+	return Stats{
+		Dropped:     i.dropped.Load(),
+		Delayed:     i.delayed.Load(),
+		Closed:      i.closed.Load(),
+		AllocFailed: i.allocFailed.Load(),
+	}
+}
+
+// Wrap subjects conn to frame drops, write delays and cuts. Call it before
+// the connection starts, e.g. from a handshake hook. Without the chaos
+// build tag it does nothing.
+func (i *Injector) Wrap(conn *protocol.WSConnection) {
+	if !Enabled {
+		return
+	}
+	conn.AddSendInterceptor(i.dropper())
+	conn.AddRecvInterceptor(i.dropper())
+	conn.WrapTransport(func(tr api.Transport) api.Transport {
+		return &transport{Transport: tr, i: i}
+	})
+}
+
+// Pool returns p with allocations failing at AllocFailRate: Get returns an
+// empty buffer, as a pool out of memory would, and callers fall back to the
+// heap. Without the chaos build tag it returns p.
+func (i *Injector) Pool(p api.BufferPool) api.BufferPool {
+	if !Enabled {
+		return p
+	}
+	return &pool{BufferPool: p, i: i}
+}
+
+// dropper returns an interceptor dropping every DropEvery-th frame it sees.
+func (i *Injector) dropper() protocol.FrameInterceptor {
+	var n atomic.Int64
+	return func(f *protocol.WSFrame) (*protocol.WSFrame, error) {
+		every := int64(i.Config().DropEvery)
+		if every > 0 && n.Add(1)%every == 0 {
+			i.dropped.Add(1)
+			return nil, nil
+		}
+		return f, nil
+	}
+}
+
+// chance reports true with probability p.
+func (i *Injector) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.rng.Float64() < p
+}
+
+// jitter returns a random duration below max.
+func (i *Injector) jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return time.Duration(i.rng.Int64N(int64(max)))
+}
+
+// transport delays writes to and cuts the transport it wraps.
+type transport struct {
+	api.Transport
+	i *Injector
+}
+
+// cut closes the connection if the dice say so.
+func (t *transport) cut() bool {
+	if !t.i.chance(t.i.Config().CloseRate) {
+		return false
+	}
+	t.i.closed.Add(1)
+	t.Transport.Close()
+	return true
+}
+
+func (t *transport) Send(bufs [][]byte) error {
+	if t.cut() {
+		return ErrInjected
+	}
+	cfg := t.i.Config()
+	if d := cfg.SendDelay + t.i.jitter(cfg.SendJitter); d > 0 {
+		t.i.delayed.Add(1)
+		time.Sleep(d)
+	}
+	return t.Transport.Send(bufs)
+}
+
+func (t *transport) Recv() ([][]byte, error) {
+	bufs, err := t.Transport.Recv()
+	if err == nil && t.cut() {
+		return nil, ErrInjected
+	}
+	return bufs, err
+}
+
+// Unwrap returns the wrapped transport.
+func (t *transport) Unwrap() api.Transport {
+	return t.Transport
+}
+
+// The methods below pass on the optional methods connections and servers
+// look for on a transport.
+
+func (t *transport) RemoteAddr() net.Addr {
+	if ra, ok := t.Transport.(interface{ RemoteAddr() net.Addr }); ok {
+		return ra.RemoteAddr()
+	}
+	return nil
+}
+
+func (t *transport) SetTimeouts(read, write time.Duration) {
+	if ts, ok := t.Transport.(interface {
+		SetTimeouts(read, write time.Duration)
+	}); ok {
+		ts.SetTimeouts(read, write)
+	}
+}
+
+func (t *transport) TLSConnectionState() (tls.ConnectionState, bool) {
+	if ts, ok := t.Transport.(interface {
+		TLSConnectionState() (tls.ConnectionState, bool)
+	}); ok {
+		return ts.TLSConnectionState()
+	}
+	return tls.ConnectionState{}, false
+}
+
+func (t *transport) SetReadDeadline(d time.Time) error {
+	if ds, ok := t.Transport.(interface{ SetReadDeadline(time.Time) error }); ok {
+		return ds.SetReadDeadline(d)
+	}
+	return nil
+}
+
+func (t *transport) SetWriteDeadline(d time.Time) error {
+	if ds, ok := t.Transport.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		return ds.SetWriteDeadline(d)
+	}
+	return nil
+}
+
+// pool fails allocations of the pool it wraps.
+type pool struct {
+	api.BufferPool
+	i *Injector
+}
+
+func (p *pool) Get(size, numaPreferred int) api.Buffer {
+	if p.i.chance(p.i.Config().AllocFailRate) {
+		p.i.allocFailed.Add(1)
+		return api.Buffer{NUMA: numaPreferred}
+	}
+	return p.BufferPool.Get(size, numaPreferred)
+}
@@ -0,0 +1,12 @@
+//go:build !chaos
+
+// File: chaos/disabled.go
+// Package chaos
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package chaos
+
+// Enabled reports whether this build injects faults: false without the chaos
+// build tag.
+const Enabled = false
@@ -0,0 +1,11 @@
+//go:build chaos
+
+// File: chaos/enabled.go
+// Package chaos
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package chaos
+
+// Enabled reports whether this build injects faults.
+const Enabled = true
@@ -0,0 +1,477 @@
+// File: cluster/cluster.go
+// Package cluster discovers the hioload-ws instances of a deployment by
+// gossip and carries messages between them.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Membership follows SWIM: every probe interval a node pings one member
+// over UDP, asks a few others to ping it on its behalf when it does not
+// answer, and suspects it when none of them got an answer either. A suspect
+// that does not refute the suspicion, by gossiping a higher incarnation of
+// itself, is declared failed. Membership updates ride on the probes
+// themselves, so the load per node stays constant as the cluster grows, and
+// a periodic full state exchange over TCP heals what gossip missed. New
+// nodes join through any member, the seeds.
+//
+// The same port carries messages between nodes over TCP, see Send and
+// Broadcast, which lets the pubsub layer replicate publishes to every node
+// without an external bus, see package pubsub/clusterbridge:
+//
+//	c, err := cluster.New(cluster.Config{BindAddr: ":7946", Seeds: []string{"10.0.0.1:7946"}})
+//	bridge, err := pubsub.NewBridge(broker, clusterbridge.New(c), pubsub.WithNodeID(c.LocalNode().ID))
+//
+// Nodes trust each other: run the cluster on a private network, or set a
+// Secret to authenticate every packet and stream frame.
+
+package cluster
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Defaults applied to zero Config fields.
+const (
+	DefaultBindAddr       = ":7946"
+	DefaultProbeInterval  = time.Second
+	DefaultIndirectProbes = 3
+	DefaultSyncInterval   = 30 * time.Second
+)
+
+var (
+	// ErrClosed is returned after Close or Leave.
+	ErrClosed = errors.New("cluster: closed")
+	// ErrUnknownNode is returned by Send for a node that is not a live
+	// member.
+	ErrUnknownNode = errors.New("cluster: unknown node")
+)
+
+// State is the state of a member as seen by this node.
+type State uint8
+
+const (
+	StateAlive   State = iota // answering probes
+	StateSuspect              // missed a probe, may still refute
+	StateDead                 // declared failed
+	StateLeft                 // left gracefully
+)
+
+func (s State) String() string {
+	switch s {
+	case StateAlive:
+		return "alive"
+	case StateSuspect:
+		return "suspect"
+	case StateDead:
+		return "dead"
+	case StateLeft:
+		return "left"
+	}
+	return "state(" + strconv.Itoa(int(s)) + ")"
+}
+
+// live reports whether members in state s are part of the cluster.
+func (s State) live() bool {
+	return s == StateAlive || s == StateSuspect
+}
+
+// Member is a node of the cluster.
+type Member struct {
+	ID          string
+	Addr        string // host:port of its gossip and stream listeners
+	Meta        map[string]string
+	State       State
+	Incarnation uint64 // version of its state, raised by the node itself
+}
+
+// EventType is the kind of a membership Event.
+type EventType uint8
+
+const (
+	EventJoin   EventType = iota // a node joined, or rejoined after failing
+	EventUpdate                  // a live node changed its address or metadata
+	EventLeave                   // a node left gracefully
+	EventFail                    // a node was declared failed
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventJoin:
+		return "join"
+	case EventUpdate:
+		return "update"
+	case EventLeave:
+		return "leave"
+	case EventFail:
+		return "fail"
+	}
+	return "event(" + strconv.Itoa(int(t)) + ")"
+}
+
+// Event reports a membership change, see Cluster.OnChange.
+type Event struct {
+	Type   EventType
+	Member Member
+}
+
+// Config configures a Cluster.
+type Config struct {
+	NodeID string // unique in the cluster (default random)
+
+	// BindAddr is the host:port of both the UDP gossip and the TCP stream
+	// listener (default DefaultBindAddr); port 0 picks a free one.
+	BindAddr string
+	// AdvertiseAddr is the host:port other nodes reach this one at
+	// (default BindAddr with the bound port, and a local interface address
+	// if BindAddr has no host).
+	AdvertiseAddr string
+
+	// Seeds are host:port addresses of members to join through. A node
+	// that cannot reach any keeps trying while it knows no members, so
+	// every node of a deployment may be given the same seeds.
+	Seeds []string
+
+	// Meta is gossiped with the node, e.g. its public WebSocket address.
+	Meta map[string]string
+
+	// Secret, if set, authenticates every packet and frame with
+	// HMAC-SHA256. It must be the same on every node.
+	Secret []byte
+
+	ProbeInterval    time.Duration // between probes (0 = DefaultProbeInterval)
+	ProbeTimeout     time.Duration // wait for a direct ack (0 = ProbeInterval/2)
+	IndirectProbes   int           // members asked to probe for us (0 = DefaultIndirectProbes)
+	SuspicionTimeout time.Duration // suspect to failed (0 = 5 probe intervals)
+	SyncInterval     time.Duration // full state exchanges (0 = DefaultSyncInterval, < 0 = none)
+
+	// OnError, if set, receives errors of the background loops, e.g.
+	// undecodable packets.
+	OnError func(error)
+}
+
+// member is this node's view of another node.
+type member struct {
+	Member
+	since time.Time // of the last state change
+}
+
+// Cluster is this node's membership in a cluster.
+type Cluster struct {
+	cfg  Config
+	id   string
+	addr string
+
+	udp *net.UDPConn
+	ln  net.Listener
+
+	mu         sync.Mutex
+	inc        uint64
+	leaving    bool
+	members    map[string]*member
+	probeOrder []string
+	gossip     []*gossipItem
+	acks       map[uint32]chan struct{}
+	seq        uint32
+	pending    []Event // not yet passed to the hooks
+
+	hooksMu  sync.RWMutex
+	hooks    []func(Event)
+	handlers map[string]func(from string, payload []byte)
+	wake     chan struct{} // signals pending events
+
+	peersMu sync.Mutex
+	peers   map[string]*peer      // outbound streams by node ID
+	inbound map[net.Conn]struct{} // accepted streams
+
+	closed    chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// New binds the listeners, starts gossiping and joins the cluster through
+// cfg.Seeds in the background.
+func New(cfg Config) (*Cluster, error) {
+	if cfg.NodeID == "" {
+		var b [8]byte
+		rand.Read(b[:])
+		cfg.NodeID = hex.EncodeToString(b[:])
+	}
+	if cfg.BindAddr == "" {
+		cfg.BindAddr = DefaultBindAddr
+	}
+	if cfg.ProbeInterval <= 0 {
+		cfg.ProbeInterval = DefaultProbeInterval
+	}
+	if cfg.ProbeTimeout <= 0 || cfg.ProbeTimeout >= cfg.ProbeInterval {
+		cfg.ProbeTimeout = cfg.ProbeInterval / 2
+	}
+	if cfg.IndirectProbes <= 0 {
+		cfg.IndirectProbes = DefaultIndirectProbes
+	}
+	if cfg.SuspicionTimeout <= 0 {
+		cfg.SuspicionTimeout = 5 * cfg.ProbeInterval
+	}
+	if cfg.SyncInterval == 0 {
+		cfg.SyncInterval = DefaultSyncInterval
+	}
+	c := &Cluster{
+		cfg:      cfg,
+		id:       cfg.NodeID,
+		inc:      uint64(time.Now().UnixNano()), // above any incarnation of an earlier run
+		members:  make(map[string]*member),
+		acks:     make(map[uint32]chan struct{}),
+		handlers: make(map[string]func(string, []byte)),
+		wake:     make(chan struct{}, 1),
+		peers:    make(map[string]*peer),
+		inbound:  make(map[net.Conn]struct{}),
+		closed:   make(chan struct{}),
+	}
+	if err := c.listen(); err != nil {
+		return nil, err
+	}
+	c.wg.Add(4)
+	go c.readLoop()
+	go c.acceptLoop()
+	go c.probeLoop()
+	go c.dispatchLoop()
+	if len(cfg.Seeds) > 0 || cfg.SyncInterval > 0 {
+		c.wg.Add(1)
+		go c.syncLoop()
+	}
+	return c, nil
+}
+
+// listen binds the TCP listener and the UDP socket on the same port.
+func (c *Cluster) listen() error {
+	host, port, err := net.SplitHostPort(c.cfg.BindAddr)
+	if err != nil {
+		return fmt.Errorf("cluster: bind address: %w", err)
+	}
+	for attempt := 0; ; attempt++ {
+		ln, err := net.Listen("tcp", c.cfg.BindAddr)
+		if err != nil {
+			return fmt.Errorf("cluster: listen: %w", err)
+		}
+		bound := ln.Addr().(*net.TCPAddr)
+		udp, err := net.ListenUDP("udp", &net.UDPAddr{IP: bound.IP, Port: bound.Port})
+		if err != nil {
+			ln.Close()
+			if port == "0" && attempt < 10 {
+				continue // the UDP port of a random TCP one was taken
+			}
+			return fmt.Errorf("cluster: listen: %w", err)
+		}
+		c.ln, c.udp = ln, udp
+		c.addr = c.cfg.AdvertiseAddr
+		if c.addr == "" {
+			if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+				host = localIP()
+			}
+			c.addr = net.JoinHostPort(host, strconv.Itoa(bound.Port))
+		}
+		return nil
+	}
+}
+
+// localIP returns an address of a local interface, preferring IPv4 ones
+// other than loopback.
+func localIP() string {
+	addrs, _ := net.InterfaceAddrs()
+	for _, a := range addrs {
+		if ipn, ok := a.(*net.IPNet); ok && !ipn.IP.IsLoopback() && ipn.IP.To4() != nil {
+			return ipn.IP.String()
+		}
+	}
+	return "127.0.0.1"
+}
+
+// LocalNode returns this node as other members see it.
+func (c *Cluster) LocalNode() Member {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state := StateAlive
+	if c.leaving {
+		state = StateLeft
+	}
+	return Member{ID: c.id, Addr: c.addr, Meta: c.cfg.Meta, State: state, Incarnation: c.inc}
+}
+
+// Members returns the live members, this node included, sorted by ID.
+func (c *Cluster) Members() []Member {
+	self := c.LocalNode()
+	c.mu.Lock()
+	out := []Member{self}
+	for _, m := range c.members {
+		if m.State.live() {
+			out = append(out, m.Member)
+		}
+	}
+	c.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// Member returns the member id, whatever its state, as long as this node
+// remembers it.
+func (c *Cluster) Member(id string) (Member, bool) {
+	if id == c.id {
+		return c.LocalNode(), true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if m, ok := c.members[id]; ok {
+		return m.Member, true
+	}
+	return Member{}, false
+}
+
+// OnChange registers fn to receive membership events in the order this
+// node observed them, on a goroutine of the cluster. fn may call the
+// Cluster, e.g. to rebuild a router from Members.
+func (c *Cluster) OnChange(fn func(Event)) {
+	c.hooksMu.Lock()
+	c.hooks = append(c.hooks, fn)
+	c.hooksMu.Unlock()
+}
+
+// Join exchanges state with the nodes at addrs and reports how many
+// answered. New already joins through Config.Seeds.
+func (c *Cluster) Join(addrs ...string) (int, error) {
+	var errs []error
+	n := 0
+	for _, a := range addrs {
+		if err := c.pushPull(a); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		n++
+	}
+	if n == 0 && len(errs) > 0 {
+		return 0, errors.Join(errs...)
+	}
+	return n, nil
+}
+
+// Leave tells the live members that this node leaves, waits until they
+// all acknowledged or ctx ends, and closes the Cluster.
+func (c *Cluster) Leave(ctx context.Context) error {
+	c.mu.Lock()
+	if c.leaving {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.leaving = true
+	c.inc++
+	self := c.selfLocked()
+	c.queueLocked(self)
+	var targets []Member
+	for _, m := range c.members {
+		if m.State.live() {
+			targets = append(targets, m.Member)
+		}
+	}
+	c.mu.Unlock()
+
+	acks := make([]chan struct{}, len(targets))
+	for i, m := range targets {
+		seq, ack := c.expectAck()
+		defer c.forgetAck(seq)
+		acks[i] = ack
+		c.send(m.Addr, &packet{Type: pktPing, To: m.ID, Seq: seq, Updates: []update{self}})
+	}
+	var err error
+	for _, ack := range acks {
+		select {
+		case <-ack:
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-c.closed:
+			err = ErrClosed
+		}
+		if err != nil {
+			break
+		}
+	}
+	c.Close()
+	return err
+}
+
+// Close stops this node without notice; the others declare it failed.
+func (c *Cluster) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.udp.Close()
+		c.ln.Close()
+		c.peersMu.Lock()
+		for id, p := range c.peers {
+			p.close()
+			delete(c.peers, id)
+		}
+		for conn := range c.inbound {
+			conn.Close()
+		}
+		c.peersMu.Unlock()
+		c.wg.Wait()
+	})
+	return nil
+}
+
+// dispatchLoop passes pending events to the hooks.
+func (c *Cluster) dispatchLoop() {
+	defer c.wg.Done()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-c.wake:
+		}
+		c.mu.Lock()
+		events := c.pending
+		c.pending = nil
+		c.mu.Unlock()
+		for _, ev := range events {
+			if !ev.Member.State.live() {
+				c.dropPeer(ev.Member.ID)
+			}
+			c.hooksMu.RLock()
+			hooks := slices.Clone(c.hooks)
+			c.hooksMu.RUnlock()
+			for _, fn := range hooks {
+				fn(ev)
+			}
+		}
+	}
+}
+
+// emitLocked queues ev for the hooks.
+func (c *Cluster) emitLocked(ev Event) {
+	c.pending = append(c.pending, ev)
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Cluster) report(err error) {
+	if c.cfg.OnError != nil {
+		c.cfg.OnError(err)
+	}
+}
+
+func (c *Cluster) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,291 @@
+// File: cluster/stream.go
+// Package cluster
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// TCP streams between nodes carry full state exchanges and application
+// messages. Each node keeps one outbound stream per peer it sends to, dialed
+// on first use and dropped when the peer fails or leaves; messages from a
+// peer arrive on the stream it dialed, so they are delivered in send order.
+
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+)
+
+// streamTimeout bounds dialing and each write on a stream.
+const streamTimeout = 5 * time.Second
+
+// peer is the outbound stream to one node.
+type peer struct {
+	mu   sync.Mutex // serializes writes
+	conn net.Conn
+}
+
+func (p *peer) close() {
+	p.mu.Lock()
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+	p.mu.Unlock()
+}
+
+// Handle registers fn to receive the messages other nodes send to service;
+// nil removes it. fn runs on the goroutine reading the sender's stream, so
+// messages of one sender are handled one at a time, in order, and owns
+// payload.
+func (c *Cluster) Handle(service string, fn func(from string, payload []byte)) {
+	c.hooksMu.Lock()
+	if fn == nil {
+		delete(c.handlers, service)
+	} else {
+		c.handlers[service] = fn
+	}
+	c.hooksMu.Unlock()
+}
+
+// Send delivers payload to the handler of service on node id. It returns
+// once the payload was written to the stream, which fails only with it: a
+// message may still be lost if the node fails right after.
+func (c *Cluster) Send(id, service string, payload []byte) error {
+	if len(service) > 255 {
+		return fmt.Errorf("cluster: service name longer than 255 bytes")
+	}
+	if c.isClosed() {
+		return ErrClosed
+	}
+	c.mu.Lock()
+	m := c.members[id]
+	var addr string
+	if m != nil && m.State.live() {
+		addr = m.Addr
+	}
+	c.mu.Unlock()
+	if addr == "" {
+		return ErrUnknownNode
+	}
+
+	c.peersMu.Lock()
+	p := c.peers[id]
+	if p == nil {
+		p = &peer{}
+		c.peers[id] = p
+	}
+	c.peersMu.Unlock()
+
+	head := appendString(appendString(nil, service), c.id)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if p.conn == nil {
+			conn, derr := net.DialTimeout("tcp", addr, streamTimeout)
+			if derr != nil {
+				return fmt.Errorf("cluster: dial %s: %w", id, derr)
+			}
+			p.conn = conn
+			go discardPeer(p, conn)
+		}
+		p.conn.SetWriteDeadline(time.Now().Add(streamTimeout))
+		if err = c.writeFrame(p.conn, frameMsg, head, payload); err == nil {
+			return nil
+		}
+		p.conn.Close()
+		p.conn = nil // a stale stream, e.g. to an earlier run of the node
+	}
+	return fmt.Errorf("cluster: send to %s: %w", id, err)
+}
+
+// discardPeer waits for the other end to close conn, which is never read
+// otherwise, so the next Send redials instead of writing into it.
+func discardPeer(p *peer, conn net.Conn) {
+	var buf [1]byte
+	for {
+		if _, err := conn.Read(buf[:]); err != nil {
+			break
+		}
+	}
+	p.mu.Lock()
+	if p.conn == conn {
+		conn.Close()
+		p.conn = nil
+	}
+	p.mu.Unlock()
+}
+
+// Broadcast sends payload to service on every other live member and
+// returns the errors of those it could not reach.
+func (c *Cluster) Broadcast(service string, payload []byte) error {
+	var errs []error
+	for _, m := range c.Members() {
+		if m.ID == c.id {
+			continue
+		}
+		if err := c.Send(m.ID, service, payload); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// dropPeer closes the outbound stream to a node that failed or left.
+func (c *Cluster) dropPeer(id string) {
+	c.peersMu.Lock()
+	p := c.peers[id]
+	delete(c.peers, id)
+	c.peersMu.Unlock()
+	if p != nil {
+		p.close()
+	}
+}
+
+// acceptLoop serves the streams other nodes open.
+func (c *Cluster) acceptLoop() {
+	defer c.wg.Done()
+	for {
+		conn, err := c.ln.Accept()
+		if err != nil {
+			if c.isClosed() {
+				return
+			}
+			c.report(err)
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		c.peersMu.Lock()
+		if c.isClosed() {
+			c.peersMu.Unlock()
+			conn.Close()
+			return
+		}
+		c.inbound[conn] = struct{}{}
+		c.wg.Add(1)
+		c.peersMu.Unlock()
+		go c.serveStream(conn)
+	}
+}
+
+// serveStream handles the frames of an inbound stream until it ends.
+func (c *Cluster) serveStream(conn net.Conn) {
+	defer c.wg.Done()
+	defer func() {
+		c.peersMu.Lock()
+		delete(c.inbound, conn)
+		c.peersMu.Unlock()
+		conn.Close()
+	}()
+	for {
+		kind, body, err := c.readFrame(conn)
+		if err != nil {
+			if errors.Is(err, ErrBadMAC) {
+				c.report(err)
+			}
+			return
+		}
+		switch kind {
+		case frameSync:
+			var state []update
+			if err := json.Unmarshal(body, &state); err != nil {
+				c.report(fmt.Errorf("cluster: bad state: %w", err))
+				return
+			}
+			c.mu.Lock()
+			reply, _ := json.Marshal(c.stateLocked())
+			c.mu.Unlock()
+			c.merge(state)
+			conn.SetWriteDeadline(time.Now().Add(streamTimeout))
+			if err := c.writeFrame(conn, frameSyncReply, reply); err != nil {
+				return
+			}
+		case frameMsg:
+			service, rest, err := readString(body)
+			if err == nil {
+				var from string
+				if from, rest, err = readString(rest); err == nil {
+					c.hooksMu.RLock()
+					fn := c.handlers[service]
+					c.hooksMu.RUnlock()
+					if fn != nil {
+						fn(from, rest)
+					}
+				}
+			}
+			if err != nil {
+				c.report(err)
+				return
+			}
+		}
+	}
+}
+
+// pushPull exchanges full state with the node at addr.
+func (c *Cluster) pushPull(addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, streamTimeout)
+	if err != nil {
+		return fmt.Errorf("cluster: join %s: %w", addr, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(streamTimeout))
+	c.mu.Lock()
+	state, _ := json.Marshal(c.stateLocked())
+	c.mu.Unlock()
+	if err := c.writeFrame(conn, frameSync, state); err != nil {
+		return fmt.Errorf("cluster: join %s: %w", addr, err)
+	}
+	kind, body, err := c.readFrame(conn)
+	if err != nil {
+		return fmt.Errorf("cluster: join %s: %w", addr, err)
+	}
+	var theirs []update
+	if kind != frameSyncReply {
+		return fmt.Errorf("cluster: join %s: unexpected frame %d", addr, kind)
+	}
+	if err := json.Unmarshal(body, &theirs); err != nil {
+		return fmt.Errorf("cluster: join %s: %w", addr, err)
+	}
+	c.merge(theirs)
+	return nil
+}
+
+// syncLoop joins through the seeds while this node knows no members, and
+// exchanges full state with a random member every SyncInterval.
+func (c *Cluster) syncLoop() {
+	defer c.wg.Done()
+	if len(c.cfg.Seeds) > 0 {
+		c.Join(c.cfg.Seeds...)
+	}
+	retry := time.NewTicker(c.cfg.ProbeInterval)
+	defer retry.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-retry.C:
+		}
+		var live []Member
+		for _, m := range c.Members() {
+			if m.ID != c.id {
+				live = append(live, m)
+			}
+		}
+		switch {
+		case len(live) == 0 && len(c.cfg.Seeds) > 0:
+			c.Join(c.cfg.Seeds...)
+		case len(live) > 0 && c.cfg.SyncInterval > 0 && time.Since(last) >= c.cfg.SyncInterval:
+			last = time.Now()
+			m := live[rand.IntN(len(live))]
+			if err := c.pushPull(m.Addr); err != nil {
+				c.report(err)
+			}
+		}
+	}
+}
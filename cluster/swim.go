@@ -0,0 +1,371 @@
+// File: cluster/swim.go
+// Package cluster
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// The failure detector and the dissemination of membership updates. Every
+// accepted update is queued for gossip and piggybacked on the next packets,
+// each a number of times growing with the log of the cluster size, which
+// spreads it to every node with high probability.
+
+package cluster
+
+import (
+	"math"
+	"math/rand/v2"
+	"net"
+	"sort"
+	"time"
+)
+
+const (
+	maxPiggyback   = 8  // updates per packet
+	retransmitMult = 4  // times log10(cluster size + 1) each update is sent
+	tombstoneTTL   = 60 // probe intervals a failed or departed member is remembered
+)
+
+// gossipItem is an update and how many more packets should carry it.
+type gossipItem struct {
+	u    update
+	left int
+}
+
+// probeLoop probes one member per interval and expires suspicions.
+func (c *Cluster) probeLoop() {
+	defer c.wg.Done()
+	tick := time.NewTicker(c.cfg.ProbeInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-tick.C:
+		}
+		c.probe()
+		c.reap()
+	}
+}
+
+// probe pings the next member, directly and then through others, and
+// suspects it if neither got an answer within the interval.
+func (c *Cluster) probe() {
+	target, ok := c.nextTarget()
+	if !ok {
+		return
+	}
+	seq, ack := c.expectAck()
+	defer c.forgetAck(seq)
+	c.send(target.Addr, &packet{Type: pktPing, To: target.ID, Seq: seq})
+	if c.await(ack, c.cfg.ProbeTimeout) {
+		return
+	}
+	for _, m := range c.randomMembers(c.cfg.IndirectProbes, target.ID) {
+		c.send(m.Addr, &packet{Type: pktPingReq, Seq: seq, Target: target.ID, TargetAddr: target.Addr})
+	}
+	if c.await(ack, c.cfg.ProbeInterval-c.cfg.ProbeTimeout) {
+		return
+	}
+	c.mu.Lock()
+	if m := c.members[target.ID]; m != nil && m.State == StateAlive && m.Incarnation == target.Incarnation {
+		c.applyLocked(update{ID: m.ID, State: StateSuspect, Inc: m.Incarnation})
+	}
+	c.mu.Unlock()
+}
+
+// await waits up to d for ack, reporting whether it came.
+func (c *Cluster) await(ack <-chan struct{}, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ack:
+		return true
+	case <-t.C:
+	case <-c.closed:
+	}
+	return false
+}
+
+// nextTarget returns the next live member in a shuffled round-robin order,
+// which probes every member within a bounded time.
+func (c *Cluster) nextTarget() (Member, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		if len(c.probeOrder) == 0 {
+			for id, m := range c.members {
+				if m.State.live() {
+					c.probeOrder = append(c.probeOrder, id)
+				}
+			}
+			if len(c.probeOrder) == 0 {
+				return Member{}, false
+			}
+			rand.Shuffle(len(c.probeOrder), func(i, j int) {
+				c.probeOrder[i], c.probeOrder[j] = c.probeOrder[j], c.probeOrder[i]
+			})
+		}
+		id := c.probeOrder[0]
+		c.probeOrder = c.probeOrder[1:]
+		if m := c.members[id]; m != nil && m.State.live() {
+			return m.Member, true
+		}
+	}
+}
+
+// randomMembers returns up to n random live members other than except.
+func (c *Cluster) randomMembers(n int, except string) []Member {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var out []Member
+	for id, m := range c.members {
+		if id != except && m.State == StateAlive {
+			out = append(out, m.Member)
+		}
+	}
+	rand.Shuffle(len(out), func(i, j int) { out[i], out[j] = out[j], out[i] })
+	return out[:min(n, len(out))]
+}
+
+// reap declares suspects failed once their suspicion timed out and forgets
+// members that failed or left long ago.
+func (c *Cluster) reap() {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, m := range c.members {
+		switch {
+		case m.State == StateSuspect && now.Sub(m.since) >= c.cfg.SuspicionTimeout:
+			c.applyLocked(update{ID: id, State: StateDead, Inc: m.Incarnation})
+		case !m.State.live() && now.Sub(m.since) >= tombstoneTTL*c.cfg.ProbeInterval:
+			delete(c.members, id)
+		}
+	}
+}
+
+// readLoop handles the packets arriving on the gossip socket.
+func (c *Cluster) readLoop() {
+	defer c.wg.Done()
+	buf := make([]byte, maxPacket)
+	for {
+		n, from, err := c.udp.ReadFromUDP(buf)
+		if err != nil {
+			if c.isClosed() {
+				return
+			}
+			c.report(err)
+			continue
+		}
+		p, err := c.decodePacket(buf[:n])
+		if err != nil {
+			c.report(err)
+			continue
+		}
+		c.handlePacket(p, from)
+	}
+}
+
+// handlePacket merges the gossip of p and answers it.
+func (c *Cluster) handlePacket(p *packet, from *net.UDPAddr) {
+	c.merge(p.Updates)
+	switch p.Type {
+	case pktPing:
+		if p.To != c.id {
+			return // meant for a node that used this address before
+		}
+		c.send(from.String(), &packet{Type: pktAck, Seq: p.Seq})
+	case pktPingReq:
+		go c.probeFor(p.Seq, p.Target, p.TargetAddr, from.String())
+	case pktAck:
+		c.mu.Lock()
+		if ack, ok := c.acks[p.Seq]; ok {
+			select {
+			case ack <- struct{}{}:
+			default:
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// probeFor pings target for the member at requester and passes on its ack
+// under the requester's sequence number.
+func (c *Cluster) probeFor(reqSeq uint32, target, targetAddr, requester string) {
+	seq, ack := c.expectAck()
+	defer c.forgetAck(seq)
+	c.send(targetAddr, &packet{Type: pktPing, To: target, Seq: seq})
+	if c.await(ack, c.cfg.ProbeTimeout) {
+		c.send(requester, &packet{Type: pktAck, Seq: reqSeq})
+	}
+}
+
+// expectAck allocates a sequence number and the channel its ack arrives on.
+func (c *Cluster) expectAck() (uint32, chan struct{}) {
+	ack := make(chan struct{}, 1)
+	c.mu.Lock()
+	c.seq++
+	seq := c.seq
+	c.acks[seq] = ack
+	c.mu.Unlock()
+	return seq, ack
+}
+
+func (c *Cluster) forgetAck(seq uint32) {
+	c.mu.Lock()
+	delete(c.acks, seq)
+	c.mu.Unlock()
+}
+
+// send adds gossip to the updates of p and sends it to addr.
+func (c *Cluster) send(addr string, p *packet) {
+	to, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		c.report(err)
+		return
+	}
+	p.From = c.id
+	c.mu.Lock()
+	p.Updates = append(p.Updates, c.piggybackLocked()...)
+	c.mu.Unlock()
+	if _, err := c.udp.WriteToUDP(c.encodePacket(p), to); err != nil && !c.isClosed() {
+		c.report(err)
+	}
+}
+
+// merge applies updates received from another node.
+func (c *Cluster) merge(updates []update) {
+	if len(updates) == 0 {
+		return
+	}
+	c.mu.Lock()
+	for _, u := range updates {
+		c.applyLocked(u)
+	}
+	c.mu.Unlock()
+}
+
+// applyLocked applies u if it is newer than what this node knows, queues
+// it for gossip and emits the event it amounts to.
+func (c *Cluster) applyLocked(u update) {
+	if u.ID == c.id {
+		// Refute suspicion of ourselves, or news of an earlier run, with
+		// a higher incarnation.
+		if !c.leaving && (u.Inc > c.inc || u.Inc == c.inc && u.State != StateAlive) {
+			c.inc = u.Inc + 1
+			c.queueLocked(c.selfLocked())
+		}
+		return
+	}
+	m := c.members[u.ID]
+	if m == nil {
+		m = &member{Member: Member{ID: u.ID, Addr: u.Addr, Meta: u.Meta, State: u.State, Incarnation: u.Inc}, since: time.Now()}
+		c.members[u.ID] = m
+		c.queueLocked(u)
+		if u.State.live() {
+			c.emitLocked(Event{Type: EventJoin, Member: m.Member})
+		}
+		return
+	}
+	if !supersedes(u, m.Member) {
+		return
+	}
+	old := m.Member
+	m.Incarnation = u.Inc
+	if u.State != m.State {
+		m.State, m.since = u.State, time.Now()
+	}
+	if u.State == StateAlive {
+		m.Addr, m.Meta = u.Addr, u.Meta
+	}
+	// Suspicions and failures carry no address; gossip the full member.
+	c.queueLocked(update{ID: m.ID, Addr: m.Addr, Meta: m.Meta, State: m.State, Inc: m.Incarnation})
+	switch {
+	case !old.State.live() && m.State.live():
+		c.emitLocked(Event{Type: EventJoin, Member: m.Member})
+	case old.State.live() && m.State == StateDead:
+		c.emitLocked(Event{Type: EventFail, Member: m.Member})
+	case old.State.live() && m.State == StateLeft:
+		c.emitLocked(Event{Type: EventLeave, Member: m.Member})
+	case m.State == StateAlive && (old.Addr != m.Addr || !sameMeta(old.Meta, m.Meta)):
+		c.emitLocked(Event{Type: EventUpdate, Member: m.Member})
+	}
+}
+
+// supersedes reports whether u overrides the state of m: a node's own
+// alive claims win with a higher incarnation, others' suspicion and failure
+// verdicts win over its claims of the same incarnation.
+func supersedes(u update, m Member) bool {
+	switch u.State {
+	case StateAlive:
+		return u.Inc > m.Incarnation
+	case StateSuspect:
+		return u.Inc > m.Incarnation || u.Inc == m.Incarnation && m.State == StateAlive
+	default:
+		return u.Inc > m.Incarnation || u.Inc == m.Incarnation && m.State.live()
+	}
+}
+
+func sameMeta(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+// selfLocked returns the update describing this node.
+func (c *Cluster) selfLocked() update {
+	state := StateAlive
+	if c.leaving {
+		state = StateLeft
+	}
+	return update{ID: c.id, Addr: c.addr, Meta: c.cfg.Meta, State: state, Inc: c.inc}
+}
+
+// queueLocked schedules u for gossip, replacing older news of its node.
+func (c *Cluster) queueLocked(u update) {
+	for i, g := range c.gossip {
+		if g.u.ID == u.ID {
+			c.gossip = append(c.gossip[:i], c.gossip[i+1:]...)
+			break
+		}
+	}
+	n := float64(len(c.members) + 2) // the members, this node, plus one
+	c.gossip = append(c.gossip, &gossipItem{u: u, left: retransmitMult * int(math.Ceil(math.Log10(n)))})
+}
+
+// piggybackLocked returns the updates sent least often so far, counting
+// them as sent.
+func (c *Cluster) piggybackLocked() []update {
+	if len(c.gossip) == 0 {
+		return nil
+	}
+	sort.SliceStable(c.gossip, func(i, j int) bool { return c.gossip[i].left > c.gossip[j].left })
+	n := min(maxPiggyback, len(c.gossip))
+	out := make([]update, n)
+	for i, g := range c.gossip[:n] {
+		out[i] = g.u
+		g.left--
+	}
+	kept := c.gossip[:0]
+	for _, g := range c.gossip {
+		if g.left > 0 {
+			kept = append(kept, g)
+		}
+	}
+	c.gossip = kept
+	return out
+}
+
+// stateLocked returns every member this node knows, itself included, for a
+// full state exchange.
+func (c *Cluster) stateLocked() []update {
+	out := []update{c.selfLocked()}
+	for _, m := range c.members {
+		out = append(out, update{ID: m.ID, Addr: m.Addr, Meta: m.Meta, State: m.State, Inc: m.Incarnation})
+	}
+	return out
+}
@@ -0,0 +1,165 @@
+// File: cluster/wire.go
+// Package cluster
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Wire formats. A gossip packet is a version byte followed by the packet in
+// JSON; a stream frame is a 4-byte big-endian length, a kind byte and the
+// body. With a Secret both end in an HMAC-SHA256 of what precedes it.
+
+package cluster
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	wireVersion = 1
+	maxPacket   = 64 << 10 // largest UDP payload
+	maxFrame    = 16 << 20 // largest stream frame body
+)
+
+// Packet types.
+const (
+	pktPing    = "ping"
+	pktAck     = "ack"
+	pktPingReq = "ping-req"
+)
+
+// Stream frame kinds.
+const (
+	frameSync      byte = 1 // full state, answered by frameSyncReply
+	frameSyncReply byte = 2
+	frameMsg       byte = 3 // an application message, see Send
+)
+
+// ErrBadMAC is reported for packets and frames failing authentication.
+var ErrBadMAC = errors.New("cluster: message authentication failed")
+
+// packet is a gossip datagram.
+type packet struct {
+	Type       string   `json:"t"`
+	From       string   `json:"f"`
+	To         string   `json:"o,omitempty"` // node a ping is meant for
+	Seq        uint32   `json:"s,omitempty"`
+	Target     string   `json:"g,omitempty"` // node a ping-req asks to probe
+	TargetAddr string   `json:"a,omitempty"`
+	Updates    []update `json:"u,omitempty"`
+}
+
+// update is gossip about one member.
+type update struct {
+	ID    string            `json:"i"`
+	Addr  string            `json:"a,omitempty"`
+	Meta  map[string]string `json:"m,omitempty"`
+	State State             `json:"s"`
+	Inc   uint64            `json:"n"`
+}
+
+func (c *Cluster) encodePacket(p *packet) []byte {
+	data, _ := json.Marshal(p)
+	return c.seal(append([]byte{wireVersion}, data...))
+}
+
+func (c *Cluster) decodePacket(b []byte) (*packet, error) {
+	b, err := c.open(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 || b[0] != wireVersion {
+		return nil, errors.New("cluster: unknown packet version")
+	}
+	p := new(packet)
+	if err := json.Unmarshal(b[1:], p); err != nil {
+		return nil, fmt.Errorf("cluster: bad packet: %w", err)
+	}
+	return p, nil
+}
+
+// seal appends the MAC of b when a Secret is set.
+func (c *Cluster) seal(b []byte) []byte {
+	if c.cfg.Secret == nil {
+		return b
+	}
+	mac := hmac.New(sha256.New, c.cfg.Secret)
+	mac.Write(b)
+	return mac.Sum(b)
+}
+
+// open verifies and strips the MAC of b when a Secret is set.
+func (c *Cluster) open(b []byte) ([]byte, error) {
+	if c.cfg.Secret == nil {
+		return b, nil
+	}
+	if len(b) < sha256.Size {
+		return nil, ErrBadMAC
+	}
+	body, sum := b[:len(b)-sha256.Size], b[len(b)-sha256.Size:]
+	mac := hmac.New(sha256.New, c.cfg.Secret)
+	mac.Write(body)
+	if !hmac.Equal(sum, mac.Sum(nil)) {
+		return nil, ErrBadMAC
+	}
+	return body, nil
+}
+
+// writeFrame writes a frame of kind carrying body to w.
+func (c *Cluster) writeFrame(w io.Writer, kind byte, body ...[]byte) error {
+	n := 5
+	for _, b := range body {
+		n += len(b)
+	}
+	frame := make([]byte, 5, n+sha256.Size)
+	frame[4] = kind
+	for _, b := range body {
+		frame = append(frame, b...)
+	}
+	frame = c.seal(frame)
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-4))
+	_, err := w.Write(frame)
+	return err
+}
+
+// readFrame reads the next frame from r.
+func (c *Cluster) readFrame(r io.Reader) (byte, []byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n < 1 || n > maxFrame {
+		return 0, nil, fmt.Errorf("cluster: frame of %d bytes", n)
+	}
+	frame := make([]byte, 4+n)
+	if _, err := io.ReadFull(r, frame[4:]); err != nil {
+		return 0, nil, err
+	}
+	frame, err := c.open(frame)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(frame) < 5 {
+		return 0, nil, errors.New("cluster: empty frame")
+	}
+	return frame[4], frame[5:], nil
+}
+
+// appendString appends s prefixed by its length in one byte.
+func appendString(b []byte, s string) []byte {
+	return append(append(b, byte(len(s))), s...)
+}
+
+// readString splits a string written by appendString off b.
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 1 || len(b) < 1+int(b[0]) {
+		return "", nil, errors.New("cluster: truncated frame")
+	}
+	n := 1 + int(b[0])
+	return string(b[1:n]), b[n:], nil
+}
@@ -0,0 +1,108 @@
+// File: cmd/wsconform/main.go
+// Package main implements wsconform, an RFC 6455 conformance checker.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// wsconform runs the battery of package conformance against the WebSocket
+// endpoint at -url and prints the outcome per case. It exits with status 1
+// if a case failed, so it can gate a CI job; -json writes the report for
+// the job's artifacts. Pass -echo if the endpoint echoes data messages, to
+// also run the cases checking them.
+//
+//	wsconform -url ws://127.0.0.1:8080/echo -echo
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/momentics/hioload-ws/conformance"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "wsconform:", err)
+		os.Exit(1)
+	}
+}
+
+// headerFlags collects repeated -H "Name: value" flags.
+type headerFlags http.Header
+
+func (h headerFlags) String() string { return "" }
+
+func (h headerFlags) Set(v string) error {
+	name, value, ok := strings.Cut(v, ":")
+	if !ok {
+		return fmt.Errorf("header %q is not Name: value", v)
+	}
+	http.Header(h).Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	return nil
+}
+
+// run parses args, runs the cases and writes the report to stdout.
+func run(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("wsconform", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	url := fs.String("url", "ws://127.0.0.1:8080/", "endpoint to check")
+	echo := fs.Bool("echo", false, "the endpoint echoes data messages")
+	timeout := fs.Duration("timeout", conformance.DefaultTimeout, "wait for each answer of the server")
+	only := fs.String("cases", "", "comma-separated cases to run instead of all")
+	list := fs.Bool("list", false, "list the cases and exit")
+	jsonOut := fs.Bool("json", false, "write the report as JSON")
+	header := headerFlags{}
+	fs.Var(header, "H", "header for the upgrade request, \"Name: value\"; repeatable")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	switch {
+	case fs.NArg() > 0:
+		return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	case *timeout <= 0:
+		return errors.New("-timeout must be positive")
+	}
+
+	cases := conformance.Cases()
+	if *only != "" {
+		var err error
+		if cases, err = conformance.Select(strings.Split(*only, ",")...); err != nil {
+			return err
+		}
+	}
+	if *list {
+		for _, c := range cases {
+			fmt.Fprintf(stdout, "%s\t%s\n", c.Name, c.Section)
+		}
+		return nil
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	start := time.Now()
+	rep := conformance.Run(ctx, conformance.Dial(*url, http.Header(header)), conformance.Options{
+		Timeout: *timeout,
+		Echo:    *echo,
+		Cases:   cases,
+	})
+	if *jsonOut {
+		if err := rep.WriteJSON(stdout); err != nil {
+			return err
+		}
+	} else {
+		rep.WriteText(stdout)
+		fmt.Fprintf(stdout, "checked %s in %v\n", *url, time.Since(start).Round(time.Millisecond))
+	}
+	if _, failed, _ := rep.Counts(); failed > 0 {
+		return fmt.Errorf("%d of %d cases failed", failed, len(rep.Results))
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+// File: cmd/wsconform/wsconform_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the flags of wsconform and a run against an unreachable
+// endpoint.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestListCases(t *testing.T) {
+	var out bytes.Buffer
+	if err := run([]string{"-list", "-cases", "ping-pong,close-normal"}, &out, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "ping-pong\t5.5.2\nclose-normal\t5.5.1\n" {
+		t.Fatalf("listed %q", got)
+	}
+	if err := run([]string{"-list", "-cases", "no-such-case"}, io.Discard, io.Discard); err == nil {
+		t.Fatal("unknown case accepted")
+	}
+	if err := run([]string{"-H", "no colon"}, io.Discard, io.Discard); err == nil {
+		t.Fatal("malformed header accepted")
+	}
+}
+
+func TestUnreachable(t *testing.T) {
+	var out bytes.Buffer
+	err := run([]string{"-url", "ws://127.0.0.1:1/", "-cases", "ping-pong", "-timeout", "200ms", "-json"}, &out, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "1 of 1 cases failed") {
+		t.Fatalf("err %v", err)
+	}
+	var rep struct {
+		Results []struct {
+			Case   string `json:"case"`
+			Status string `json:"status"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &rep); err != nil {
+		t.Fatalf("%v: %s", err, out.String())
+	}
+	if len(rep.Results) != 1 || rep.Results[0].Case != "ping-pong" || rep.Results[0].Status != "fail" {
+		t.Fatalf("report %s", out.String())
+	}
+}
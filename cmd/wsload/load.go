@@ -0,0 +1,359 @@
+// File: cmd/wsload/load.go
+// Package main
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// The load generator. Connections are spread over sender workers, each
+// optionally locked to an OS thread pinned to a NUMA node; every connection
+// has a goroutine reading the echoes. Messages start with their send time
+// in hexadecimal nanoseconds, valid in text messages too, so latency needs
+// no bookkeeping per message.
+//
+// With a target rate the workers send open loop on a fixed schedule and
+// latency counts from when a message was due, not when it went out, so a
+// stalled server shows in the percentiles instead of slowing the load down.
+// Without one every connection keeps -inflight messages outstanding.
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"math/rand/v2"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/momentics/hioload-ws/adapters"
+	"github.com/momentics/hioload-ws/control"
+	"github.com/momentics/hioload-ws/highlevel"
+)
+
+// config holds the parsed flags.
+type config struct {
+	URL         string        `json:"url"`
+	Conns       int           `json:"connections"`
+	Ramp        time.Duration `json:"ramp"`
+	Warmup      time.Duration `json:"warmup"`
+	Duration    time.Duration `json:"duration"`
+	Rate        float64       `json:"rate"` // messages per second in total, 0 = closed loop
+	Inflight    int           `json:"inflight"`
+	Sizes       string        `json:"sizes"`
+	Text        bool          `json:"text"`
+	Workers     int           `json:"workers"`
+	NUMANode    int           `json:"numa_node"`
+	Pin         bool          `json:"pin"`
+	Compression bool          `json:"compression"`
+	Seed        uint64        `json:"seed"`
+	DialTimeout time.Duration `json:"dial_timeout"`
+
+	sizes *sizeDist
+}
+
+// counters are the totals of the measured window.
+type counters struct {
+	sent, received           atomic.Int64
+	sentBytes, receivedBytes atomic.Int64
+	dialErrors, writeErrors  atomic.Int64
+	readErrors, badMessages  atomic.Int64
+}
+
+// loader runs one load test.
+type loader struct {
+	cfg       config
+	hist      *control.LatencyHistogram
+	counts    counters
+	measuring atomic.Bool
+	connected atomic.Int64
+	started   time.Time // of the measured window
+	workers   []*worker
+	filler    []byte // message bodies, random so compression has no easy win
+
+	connsMu sync.Mutex
+	conns   []*loadConn
+}
+
+// worker sends on its share of the connections.
+type worker struct {
+	l     *loader
+	rng   *rand.Rand
+	buf   []byte
+	mu    sync.Mutex
+	conns []*loadConn    // open loop: sent to round robin
+	ready chan *loadConn // closed loop: one entry per message a connection may send
+}
+
+// loadConn is one client connection.
+type loadConn struct {
+	c    *highlevel.Conn
+	w    *worker
+	dead atomic.Bool
+}
+
+func newLoader(cfg config) *loader {
+	l := &loader{cfg: cfg, hist: control.NewLatencyHistogram()}
+	rng := rand.New(rand.NewPCG(cfg.Seed, 0))
+	l.filler = make([]byte, cfg.sizes.largest())
+	for i := range l.filler {
+		l.filler[i] = byte(rng.IntN(256))
+		if cfg.Text {
+			l.filler[i] = 'a' + l.filler[i]%26
+		}
+	}
+	perWorker := (cfg.Conns + cfg.Workers - 1) / cfg.Workers
+	for i := 0; i < cfg.Workers; i++ {
+		w := &worker{
+			l:     l,
+			rng:   rand.New(rand.NewPCG(cfg.Seed, uint64(i+1))),
+			buf:   make([]byte, len(l.filler)),
+			ready: make(chan *loadConn, perWorker*cfg.Inflight),
+		}
+		copy(w.buf, l.filler)
+		l.workers = append(l.workers, w)
+	}
+	return l
+}
+
+// run ramps the connections up, warms up, measures for the configured
+// duration and returns the report. progress, if set, is called every
+// interval while measuring.
+func (l *loader) run(ctx context.Context, interval time.Duration, progress func(*report)) *report {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	for _, w := range l.workers {
+		wg.Add(1)
+		go func(w *worker) {
+			defer wg.Done()
+			w.run(ctx)
+		}(w)
+	}
+
+	l.rampUp(ctx)
+	sleepCtx(ctx, l.cfg.Warmup)
+	start := time.Now()
+	l.started = start
+	l.measuring.Store(true)
+	var tick <-chan time.Time
+	if interval > 0 && progress != nil {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		tick = t.C
+	}
+	end := time.NewTimer(l.cfg.Duration)
+	defer end.Stop()
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-end.C:
+			break loop
+		case <-tick:
+			progress(l.report(time.Since(start)))
+		}
+	}
+	l.measuring.Store(false)
+	rep := l.report(time.Since(start))
+
+	cancel()
+	wg.Wait()
+	l.connsMu.Lock()
+	for _, lc := range l.conns {
+		lc.dead.Store(true)
+		lc.c.Close()
+	}
+	l.connsMu.Unlock()
+	return rep
+}
+
+// rampUp opens the connections evenly over the ramp time and returns once
+// every dial finished.
+func (l *loader) rampUp(ctx context.Context) {
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < l.cfg.Conns; i++ {
+		if l.cfg.Ramp > 0 {
+			sleepCtx(ctx, time.Until(start.Add(l.cfg.Ramp*time.Duration(i)/time.Duration(l.cfg.Conns))))
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(w *worker) {
+			defer wg.Done()
+			l.dial(ctx, w)
+		}(l.workers[i%len(l.workers)])
+	}
+	wg.Wait()
+}
+
+// dial opens a connection and hands it to w.
+func (l *loader) dial(ctx context.Context, w *worker) {
+	opts := highlevel.DefaultOptions()
+	opts.NUMANode = l.cfg.NUMANode
+	opts.Compression = l.cfg.Compression
+	dctx, cancel := context.WithTimeout(ctx, l.cfg.DialTimeout)
+	defer cancel()
+	c, err := highlevel.DialWithOptionsContext(dctx, l.cfg.URL, opts)
+	if err != nil {
+		if ctx.Err() == nil {
+			l.counts.dialErrors.Add(1)
+		}
+		return
+	}
+	lc := &loadConn{c: c, w: w}
+	l.connsMu.Lock()
+	l.conns = append(l.conns, lc)
+	l.connsMu.Unlock()
+	l.connected.Add(1)
+	go l.read(ctx, lc)
+	if l.cfg.Rate > 0 {
+		w.mu.Lock()
+		w.conns = append(w.conns, lc)
+		w.mu.Unlock()
+		return
+	}
+	for i := 0; i < l.cfg.Inflight; i++ {
+		w.ready <- lc
+	}
+}
+
+// read records the echoes arriving on lc and, in closed loop, lets its
+// worker send the next message.
+func (l *loader) read(ctx context.Context, lc *loadConn) {
+	for {
+		_, msg, err := lc.c.ReadMessage()
+		if err != nil {
+			if !lc.dead.Swap(true) && ctx.Err() == nil {
+				l.counts.readErrors.Add(1)
+				l.connected.Add(-1)
+			}
+			return
+		}
+		if l.measuring.Load() {
+			if sent, ok := readStamp(msg); !ok {
+				l.counts.badMessages.Add(1)
+			} else {
+				l.hist.Record(time.Since(sent))
+				l.counts.received.Add(1)
+				l.counts.receivedBytes.Add(int64(len(msg)))
+			}
+		}
+		if l.cfg.Rate == 0 {
+			lc.w.ready <- lc
+		}
+	}
+}
+
+// run sends until ctx ends.
+func (w *worker) run(ctx context.Context) {
+	if w.l.cfg.Pin {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		// Best effort: without a NUMA-aware platform layer the thread
+		// stays where the scheduler puts it.
+		adapters.NewAffinityAdapter().Pin(-1, w.l.cfg.NUMANode)
+	}
+	if w.l.cfg.Rate > 0 {
+		w.runOpen(ctx)
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case lc := <-w.ready:
+			if !lc.dead.Load() {
+				w.send(lc, time.Now())
+			}
+		}
+	}
+}
+
+// runOpen sends to the worker's connections in turn, at its share of the
+// target rate.
+func (w *worker) runOpen(ctx context.Context) {
+	interval := time.Duration(float64(time.Second) * float64(len(w.l.workers)) / w.l.cfg.Rate)
+	next := time.Now()
+	for i := 0; ; i++ {
+		next = next.Add(interval)
+		if !sleepCtx(ctx, time.Until(next)) {
+			return
+		}
+		w.mu.Lock()
+		var lc *loadConn
+		for j := 0; j < len(w.conns) && lc == nil; j++ {
+			if c := w.conns[(i+j)%len(w.conns)]; !c.dead.Load() {
+				lc = c
+			}
+		}
+		w.mu.Unlock()
+		if lc != nil {
+			w.send(lc, next)
+		}
+	}
+}
+
+// send writes a message stamped with due to lc.
+func (w *worker) send(lc *loadConn, due time.Time) {
+	msg := w.buf[:w.l.cfg.sizes.sample(w.rng)]
+	writeStamp(msg, due)
+	mt := highlevel.BinaryMessage
+	if w.l.cfg.Text {
+		mt = highlevel.TextMessage
+	}
+	if err := lc.c.WriteMessage(int(mt), msg); err != nil {
+		if !lc.dead.Swap(true) {
+			w.l.counts.writeErrors.Add(1)
+			w.l.connected.Add(-1)
+			lc.c.Close()
+		}
+		return
+	}
+	if w.l.measuring.Load() {
+		w.l.counts.sent.Add(1)
+		w.l.counts.sentBytes.Add(int64(len(msg)))
+	}
+}
+
+// writeStamp writes t to the first minSize bytes of msg.
+func writeStamp(msg []byte, t time.Time) {
+	var b [minSize / 2]byte
+	n := uint64(t.UnixNano())
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i], n = byte(n), n>>8
+	}
+	hex.Encode(msg, b[:])
+}
+
+// readStamp returns the time written by writeStamp to msg.
+func readStamp(msg []byte) (time.Time, bool) {
+	var b [minSize / 2]byte
+	if len(msg) < minSize {
+		return time.Time{}, false
+	}
+	if _, err := hex.Decode(b[:], msg[:minSize]); err != nil {
+		return time.Time{}, false
+	}
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return time.Unix(0, int64(n)), true
+}
+
+// sleepCtx sleeps for d unless ctx ends first, reporting whether it did not.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,119 @@
+// File: cmd/wsload/main.go
+// Package main implements wsload, a WebSocket load generator.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// wsload opens -c connections to an echo endpoint over -ramp, warms up for
+// -warmup and then measures for -d: messages and bytes echoed, errors and
+// round-trip latency percentiles. Without -rate every connection keeps
+// -inflight messages outstanding (closed loop); with it, messages go out on
+// a fixed schedule whatever the server does (open loop). Message sizes
+// follow -size, e.g. "512", "64-4096" or "64:80,1k:15,16k-64k:5".
+//
+// The same flags and -seed reproduce the same load, so runs of different
+// releases compare directly; -json writes the full report, configuration
+// included, for that.
+//
+//	wsload -url ws://10.0.0.2:8080/echo -c 1000 -ramp 10s -d 1m -rate 50000 -size 64-4096
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "wsload:", err)
+		os.Exit(1)
+	}
+}
+
+// run parses args, runs the load test and writes the report to stdout,
+// progress to stderr.
+func run(args []string, stdout, stderr io.Writer) error {
+	var cfg config
+	fs := flag.NewFlagSet("wsload", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&cfg.URL, "url", "ws://127.0.0.1:8080/echo", "echo endpoint")
+	fs.IntVar(&cfg.Conns, "c", 100, "connections")
+	fs.DurationVar(&cfg.Ramp, "ramp", 0, "time to open the connections over")
+	fs.DurationVar(&cfg.Warmup, "warmup", 2*time.Second, "time to send before measuring")
+	fs.DurationVar(&cfg.Duration, "d", 10*time.Second, "time to measure")
+	fs.Float64Var(&cfg.Rate, "rate", 0, "messages per second over all connections; 0 sends closed loop")
+	fs.IntVar(&cfg.Inflight, "inflight", 1, "messages outstanding per connection in closed loop")
+	fs.StringVar(&cfg.Sizes, "size", "128", "message size distribution")
+	fs.BoolVar(&cfg.Text, "text", false, "send text messages")
+	fs.IntVar(&cfg.Workers, "workers", runtime.GOMAXPROCS(0), "sender goroutines")
+	fs.IntVar(&cfg.NUMANode, "numa", -1, "NUMA node for buffers and pinned workers; -1 picks one")
+	fs.BoolVar(&cfg.Pin, "pin", false, "lock workers to OS threads pinned to the NUMA node")
+	fs.BoolVar(&cfg.Compression, "compression", false, "offer permessage-deflate")
+	fs.Uint64Var(&cfg.Seed, "seed", 1, "seed for sizes and message bodies")
+	fs.DurationVar(&cfg.DialTimeout, "dial-timeout", 10*time.Second, "handshake timeout per connection")
+	interval := fs.Duration("interval", time.Second, "progress interval; 0 disables")
+	jsonOut := fs.Bool("json", false, "write the report as JSON")
+	out := fs.String("out", "", "also write the JSON report to this file")
+	label := fs.String("label", "", "name for the run, e.g. the release under test")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	switch {
+	case fs.NArg() > 0:
+		return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	case cfg.Conns <= 0:
+		return errors.New("-c must be positive")
+	case cfg.Duration <= 0:
+		return errors.New("-d must be positive")
+	case cfg.Rate < 0 || cfg.Ramp < 0 || cfg.Warmup < 0:
+		return errors.New("-rate, -ramp and -warmup must not be negative")
+	case cfg.Inflight <= 0:
+		return errors.New("-inflight must be positive")
+	case cfg.Workers <= 0:
+		return errors.New("-workers must be positive")
+	case cfg.DialTimeout <= 0:
+		return errors.New("-dial-timeout must be positive")
+	}
+	cfg.Workers = min(cfg.Workers, cfg.Conns)
+	sizes, err := parseSizes(cfg.Sizes)
+	if err != nil {
+		return err
+	}
+	cfg.sizes = sizes
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var progress func(*report)
+	if !*jsonOut {
+		progress = func(r *report) { r.writeProgress(stderr) }
+	}
+	rep := newLoader(cfg).run(ctx, *interval, progress)
+	rep.Label = *label
+
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		err = rep.writeJSON(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if *jsonOut {
+		return rep.writeJSON(stdout)
+	}
+	rep.writeText(stdout)
+	return nil
+}
@@ -0,0 +1,117 @@
+// File: cmd/wsload/report.go
+// Package main
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Results: a text summary for people and JSON for comparing runs, e.g.
+// of two releases against the same server.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+)
+
+// report is the outcome of a run, or of its measured part so far.
+type report struct {
+	Label     string    `json:"label,omitempty"`
+	Started   time.Time `json:"started"`
+	GoVersion string    `json:"go_version"`
+	Platform  string    `json:"platform"`
+	Config    config    `json:"config"`
+
+	Elapsed     time.Duration `json:"elapsed"`
+	Connected   int64         `json:"connected"`
+	Sent        int64         `json:"sent"`
+	Received    int64         `json:"received"`
+	SentBytes   int64         `json:"sent_bytes"`
+	RecvBytes   int64         `json:"received_bytes"`
+	DialErrors  int64         `json:"dial_errors"`
+	WriteErrors int64         `json:"write_errors"`
+	ReadErrors  int64         `json:"read_errors"`
+	BadMessages int64         `json:"bad_messages"`
+
+	Throughput float64   `json:"messages_per_second"` // received
+	Bandwidth  float64   `json:"bytes_per_second"`    // received
+	Latency    latencies `json:"latency"`
+}
+
+// latencies are round-trip times in the histogram's precision.
+type latencies struct {
+	Min  time.Duration `json:"min"`
+	Mean time.Duration `json:"mean"`
+	P50  time.Duration `json:"p50"`
+	P95  time.Duration `json:"p95"`
+	P99  time.Duration `json:"p99"`
+	P999 time.Duration `json:"p999"`
+	Max  time.Duration `json:"max"`
+}
+
+// report sums up the measured window, elapsed long so far.
+func (l *loader) report(elapsed time.Duration) *report {
+	s := l.hist.Snapshot()
+	r := &report{
+		Started:     l.started,
+		GoVersion:   runtime.Version(),
+		Platform:    runtime.GOOS + "/" + runtime.GOARCH,
+		Config:      l.cfg,
+		Elapsed:     elapsed,
+		Connected:   l.connected.Load(),
+		Sent:        l.counts.sent.Load(),
+		Received:    l.counts.received.Load(),
+		SentBytes:   l.counts.sentBytes.Load(),
+		RecvBytes:   l.counts.receivedBytes.Load(),
+		DialErrors:  l.counts.dialErrors.Load(),
+		WriteErrors: l.counts.writeErrors.Load(),
+		ReadErrors:  l.counts.readErrors.Load(),
+		BadMessages: l.counts.badMessages.Load(),
+		Latency: latencies{
+			Min: s.Min, Mean: s.Mean, Max: s.Max,
+			P50: s.P50, P95: s.P95, P99: s.P99, P999: s.P999,
+		},
+	}
+	if secs := elapsed.Seconds(); secs > 0 {
+		r.Throughput = float64(r.Received) / secs
+		r.Bandwidth = float64(r.RecvBytes) / secs
+	}
+	return r
+}
+
+// writeText writes the summary of r.
+func (r *report) writeText(w io.Writer) {
+	if r.Label != "" {
+		fmt.Fprintf(w, "%s\n", r.Label)
+	}
+	c := r.Config
+	mode := "closed loop"
+	if c.Rate > 0 {
+		mode = fmt.Sprintf("open loop at %.0f msg/s", c.Rate)
+	}
+	fmt.Fprintf(w, "target      %s, %d connections, %s, sizes %s\n", c.URL, c.Conns, mode, c.Sizes)
+	fmt.Fprintf(w, "measured    %s, %d connected\n", r.Elapsed.Round(time.Millisecond), r.Connected)
+	fmt.Fprintf(w, "messages    %d sent, %d received, %.0f msg/s, %.2f MiB/s\n",
+		r.Sent, r.Received, r.Throughput, r.Bandwidth/(1<<20))
+	fmt.Fprintf(w, "errors      %d dial, %d write, %d read, %d bad messages\n",
+		r.DialErrors, r.WriteErrors, r.ReadErrors, r.BadMessages)
+	l := r.Latency
+	fmt.Fprintf(w, "latency     min %s  mean %s  p50 %s  p95 %s  p99 %s  p99.9 %s  max %s\n",
+		l.Min, l.Mean, l.P50, l.P95, l.P99, l.P999, l.Max)
+}
+
+// writeProgress writes r as one line.
+func (r *report) writeProgress(w io.Writer) {
+	fmt.Fprintf(w, "%6s  conns %d  recv %d  %.0f msg/s  p50 %s  p99 %s  errors %d\n",
+		r.Elapsed.Round(time.Second), r.Connected, r.Received, r.Throughput,
+		r.Latency.P50, r.Latency.P99, r.DialErrors+r.WriteErrors+r.ReadErrors)
+}
+
+// writeJSON writes r as indented JSON.
+func (r *report) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
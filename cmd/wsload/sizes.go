@@ -0,0 +1,107 @@
+// File: cmd/wsload/sizes.go
+// Package main
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Message size distributions given with -size.
+
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+)
+
+// minSize leaves room for the send time every message starts with.
+const minSize = 16
+
+// sizeDist draws message sizes.
+type sizeDist struct {
+	spec    string
+	min     []int // per choice, the smallest size
+	max     []int // per choice, the largest size (= min for a fixed one)
+	weights []int // cumulative
+}
+
+// parseSizes parses a distribution: a size ("512"), a uniform range
+// ("64-4096") or weighted choices of either ("64:80,1024:15,16384-65536:5").
+// Sizes take k and m suffixes for KiB and MiB and are at least minSize.
+func parseSizes(spec string) (*sizeDist, error) {
+	d := &sizeDist{spec: spec}
+	total := 0
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		weight := 1
+		if i := strings.LastIndexByte(part, ':'); i >= 0 {
+			w, err := strconv.Atoi(part[i+1:])
+			if err != nil || w <= 0 {
+				return nil, fmt.Errorf("size %q: bad weight", part)
+			}
+			part, weight = part[:i], w
+		}
+		lo, hi, isRange := strings.Cut(part, "-")
+		from, err := parseSize(lo)
+		if err != nil {
+			return nil, err
+		}
+		to := from
+		if isRange {
+			if to, err = parseSize(hi); err != nil {
+				return nil, err
+			}
+			if to < from {
+				return nil, fmt.Errorf("size %q: empty range", part)
+			}
+		}
+		total += weight
+		d.min = append(d.min, max(from, minSize))
+		d.max = append(d.max, max(to, minSize))
+		d.weights = append(d.weights, total)
+	}
+	return d, nil
+}
+
+func parseSize(s string) (int, error) {
+	mult := 1
+	switch {
+	case strings.HasSuffix(s, "k"):
+		s, mult = s[:len(s)-1], 1<<10
+	case strings.HasSuffix(s, "m"):
+		s, mult = s[:len(s)-1], 1<<20
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad size %q", s)
+	}
+	return n * mult, nil
+}
+
+// sample draws a size.
+func (d *sizeDist) sample(rng *rand.Rand) int {
+	i := 0
+	if len(d.weights) > 1 {
+		w := rng.IntN(d.weights[len(d.weights)-1])
+		for d.weights[i] <= w {
+			i++
+		}
+	}
+	if d.max[i] == d.min[i] {
+		return d.min[i]
+	}
+	return d.min[i] + rng.IntN(d.max[i]-d.min[i]+1)
+}
+
+// largest returns the largest size d draws.
+func (d *sizeDist) largest() int {
+	n := 0
+	for _, m := range d.max {
+		n = max(n, m)
+	}
+	return n
+}
+
+func (d *sizeDist) String() string {
+	return d.spec
+}
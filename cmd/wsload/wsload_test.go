@@ -0,0 +1,128 @@
+// File: cmd/wsload/wsload_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the size distributions, message stamps and a short run of
+// wsload against an in-process echo server.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/highlevel"
+)
+
+func TestParseSizes(t *testing.T) {
+	d, err := parseSizes("8:1, 1k-2k:3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.largest() != 2048 {
+		t.Fatalf("largest %d", d.largest())
+	}
+	rng := rand.New(rand.NewPCG(1, 2))
+	small := 0
+	for i := 0; i < 4000; i++ {
+		switch n := d.sample(rng); {
+		case n == minSize:
+			small++
+		case n < 1024 || n > 2048:
+			t.Fatalf("sampled %d", n)
+		}
+	}
+	if small < 800 || small > 1200 {
+		t.Fatalf("%d of 4000 small, want about 1000", small)
+	}
+	for _, bad := range []string{"", "x", "10-5", "64:0", "-1", "64:a"} {
+		if _, err := parseSizes(bad); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
+
+func TestStamp(t *testing.T) {
+	now := time.Now()
+	msg := make([]byte, minSize+3)
+	writeStamp(msg, now)
+	if got, ok := readStamp(msg); !ok || !got.Equal(time.Unix(0, now.UnixNano())) {
+		t.Fatalf("read %v %v, wrote %v", got, ok, now)
+	}
+	if _, ok := readStamp(msg[:minSize-1]); ok {
+		t.Fatal("short message accepted")
+	}
+	msg[0] = 'z'
+	if _, ok := readStamp(msg); ok {
+		t.Fatal("bad stamp accepted")
+	}
+}
+
+func TestRun(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	srv := highlevel.NewServer(fmt.Sprintf("127.0.0.1:%d", port))
+	srv.HandleFunc("/echo", func(c *highlevel.Conn) {
+		for {
+			mt, msg, err := c.ReadMessage()
+			if err != nil || c.WriteMessage(mt, msg) != nil {
+				return
+			}
+		}
+	})
+	go srv.ListenAndServe()
+	defer srv.Shutdown(context.Background())
+	time.Sleep(200 * time.Millisecond)
+
+	for _, mode := range [][]string{
+		{"-inflight", "2"},
+		{"-rate", "400", "-text"},
+	} {
+		var out, progress bytes.Buffer
+		args := append([]string{
+			"-url", fmt.Sprintf("ws://127.0.0.1:%d/echo", port),
+			"-c", "4", "-workers", "2", "-ramp", "50ms", "-warmup", "100ms", "-d", "500ms",
+			"-size", "16-512", "-json",
+		}, mode...)
+		if err := run(args, &out, &progress); err != nil {
+			t.Fatalf("%v: %v", mode, err)
+		}
+		var rep report
+		if err := json.Unmarshal(out.Bytes(), &rep); err != nil {
+			t.Fatalf("%v: %v\n%s", mode, err, out.Bytes())
+		}
+		errs := rep.DialErrors + rep.WriteErrors + rep.ReadErrors + rep.BadMessages
+		if rep.Connected != 4 || rep.Received == 0 || errs != 0 {
+			t.Fatalf("%v: %+v", mode, rep)
+		}
+		if rep.Latency.P50 <= 0 || rep.Latency.P99 < rep.Latency.P50 || rep.Latency.Max < rep.Latency.P99 {
+			t.Fatalf("%v: latency %+v", mode, rep.Latency)
+		}
+		if mode[0] == "-rate" && (rep.Sent < 100 || rep.Sent > 300) {
+			t.Fatalf("sent %d at 400/s for 500ms", rep.Sent)
+		}
+	}
+}
+
+func TestRunFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-c", "0"},
+		{"-size", "1-0"},
+		{"-inflight", "0"},
+		{"extra"},
+	} {
+		if err := run(args, new(bytes.Buffer), new(bytes.Buffer)); err == nil {
+			t.Errorf("%v: no error", args)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// File: codec/cbor.go
+// Package codec implements the CBOR format (RFC 8949).
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Items are written with definite lengths in their shortest head. Decoding
+// accepts half, single and double precision floats, treats undefined as null
+// and strips tags, so a tagged epoch time decodes as its number. Indefinite
+// lengths are rejected.
+
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/momentics/hioload-ws/highlevel"
+)
+
+// CBOR major types.
+const (
+	cborUint byte = iota << 5
+	cborNegInt
+	cborBytes
+	cborText
+	cborArray
+	cborMap
+	cborTag
+	cborSimple
+)
+
+// CBOR is a highlevel.Codec writing CBOR binary frames. Struct fields are
+// named by their `cbor` tag.
+type CBOR struct{}
+
+// Marshal implements highlevel.Codec.
+func (CBOR) Marshal(v any) ([]byte, error) {
+	return encode(cborEmitter{}, "cbor", nil, reflect.ValueOf(v))
+}
+
+// Unmarshal implements highlevel.Codec.
+func (CBOR) Unmarshal(data []byte, v any) error {
+	return unmarshal(readCBOR, "cbor", data, v)
+}
+
+// MessageType implements highlevel.Codec.
+func (CBOR) MessageType() highlevel.MessageType { return highlevel.BinaryMessage }
+
+type cborEmitter struct{}
+
+// appendHead appends the head of an item of major type m and argument v.
+func appendHead(b []byte, m byte, v uint64) []byte {
+	switch {
+	case v < 24:
+		return append(b, m|byte(v))
+	case v <= math.MaxUint8:
+		return append(b, m|24, byte(v))
+	case v <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, m|25), uint16(v))
+	case v <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, m|26), uint32(v))
+	}
+	return binary.BigEndian.AppendUint64(append(b, m|27), v)
+}
+
+func (cborEmitter) appendNil(b []byte) []byte { return append(b, 0xf6) }
+
+func (cborEmitter) appendBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 0xf5)
+	}
+	return append(b, 0xf4)
+}
+
+func (cborEmitter) appendInt(b []byte, v int64) []byte {
+	if v < 0 {
+		return appendHead(b, cborNegInt, uint64(-1-v))
+	}
+	return appendHead(b, cborUint, uint64(v))
+}
+
+func (cborEmitter) appendUint(b []byte, v uint64) []byte { return appendHead(b, cborUint, v) }
+
+func (cborEmitter) appendFloat32(b []byte, v float32) []byte {
+	return binary.BigEndian.AppendUint32(append(b, 0xfa), math.Float32bits(v))
+}
+
+func (cborEmitter) appendFloat64(b []byte, v float64) []byte {
+	return binary.BigEndian.AppendUint64(append(b, 0xfb), math.Float64bits(v))
+}
+
+func (cborEmitter) appendString(b []byte, s string) []byte {
+	return append(appendHead(b, cborText, uint64(len(s))), s...)
+}
+
+func (cborEmitter) appendBytes(b []byte, p []byte) []byte {
+	return append(appendHead(b, cborBytes, uint64(len(p))), p...)
+}
+
+func (cborEmitter) appendArrayHeader(b []byte, n int) []byte {
+	return appendHead(b, cborArray, uint64(n))
+}
+
+func (cborEmitter) appendMapHeader(b []byte, n int) []byte {
+	return appendHead(b, cborMap, uint64(n))
+}
+
+// readCBOR decodes the CBOR item at the start of data, skipping tags.
+func readCBOR(data []byte) (token, int, error) {
+	off := 0
+	for {
+		if off >= len(data) {
+			return token{}, 0, errTruncated
+		}
+		major, info := data[off]&0xe0, data[off]&0x1f
+		size := 0
+		switch {
+		case info < 24:
+		case info <= 27:
+			size = 1 << (info - 24)
+		case info == 31:
+			return token{}, 0, fmt.Errorf("codec: indefinite-length cbor items are not supported")
+		default:
+			return token{}, 0, fmt.Errorf("codec: malformed cbor head 0x%02x", data[off])
+		}
+		if len(data)-off-1 < size {
+			return token{}, 0, errTruncated
+		}
+		arg := uint64(info)
+		if size > 0 {
+			arg = 0
+			for _, x := range data[off+1 : off+1+size] {
+				arg = arg<<8 | uint64(x)
+			}
+		}
+		n := off + 1 + size
+
+		switch major {
+		case cborUint:
+			return token{kind: kindUint, u: arg}, n, nil
+		case cborNegInt:
+			if arg > math.MaxInt64 {
+				return token{}, 0, fmt.Errorf("codec: cbor integer -1-%d overflows int64", arg)
+			}
+			return token{kind: kindInt, i: -1 - int64(arg)}, n, nil
+		case cborBytes, cborText:
+			if arg > uint64(len(data)-n) {
+				return token{}, 0, errTruncated
+			}
+			kind := kindBytes
+			if major == cborText {
+				kind = kindString
+			}
+			return token{kind: kind, s: data[n : n+int(arg)]}, n + int(arg), nil
+		case cborArray, cborMap:
+			if arg > uint64(len(data)) {
+				return token{}, 0, errTruncated
+			}
+			kind := kindArray
+			if major == cborMap {
+				kind = kindMap
+			}
+			return token{kind: kind, n: int(arg)}, n, nil
+		case cborTag:
+			off = n
+			continue
+		}
+
+		switch info {
+		case 20, 21:
+			return token{kind: kindBool, b: info == 21}, n, nil
+		case 22, 23:
+			return token{kind: kindNil}, n, nil
+		case 25:
+			return token{kind: kindFloat, f: halfToFloat(uint16(arg))}, n, nil
+		case 26:
+			return token{kind: kindFloat, f: float64(math.Float32frombits(uint32(arg)))}, n, nil
+		case 27:
+			return token{kind: kindFloat, f: math.Float64frombits(arg)}, n, nil
+		}
+		return token{}, 0, fmt.Errorf("codec: unsupported cbor simple value %d", arg)
+	}
+}
+
+// halfToFloat converts an IEEE 754 half precision value.
+func halfToFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
@@ -0,0 +1,516 @@
+// File: codec/codec.go
+// Package codec provides binary highlevel.Codec implementations.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// MsgPack and CBOR serialize Go values the way encoding/json does: structs
+// become maps keyed by field name (overridable with a `msgpack:"name"` or
+// `cbor:"name"` tag, with ",omitempty" and "-" as in json), and decoding into
+// an interface{} yields nil, bool, int64 (uint64 above MaxInt64), float64,
+// string, []byte, []any or map[string]any. Protobuf delegates to generated message code.
+// None of them pulls in a third-party module.
+//
+//	srv.HandleFunc("/feed", func(c *highlevel.Conn) {
+//		c.SetCodec(codec.MsgPack{})
+//		c.WriteObject(Tick{Symbol: "EURUSD", Bid: 1.0842})
+//	})
+
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxDepth bounds the nesting of decoded arrays and maps.
+const maxDepth = 1000
+
+var errTruncated = errors.New("codec: unexpected end of data")
+
+// emitter appends the wire encoding of single values for one format.
+type emitter interface {
+	appendNil(b []byte) []byte
+	appendBool(b []byte, v bool) []byte
+	appendInt(b []byte, v int64) []byte
+	appendUint(b []byte, v uint64) []byte
+	appendFloat32(b []byte, v float32) []byte
+	appendFloat64(b []byte, v float64) []byte
+	appendString(b []byte, s string) []byte
+	appendBytes(b []byte, p []byte) []byte
+	appendArrayHeader(b []byte, n int) []byte
+	appendMapHeader(b []byte, n int) []byte
+}
+
+type tokenKind uint8
+
+const (
+	kindNil tokenKind = iota
+	kindBool
+	kindInt
+	kindUint
+	kindFloat
+	kindString
+	kindBytes
+	kindArray
+	kindMap
+)
+
+// token is one decoded wire item; arrays and maps are followed by n items
+// (2n for maps).
+type token struct {
+	kind tokenKind
+	b    bool
+	i    int64
+	u    uint64
+	f    float64
+	s    []byte // string or byte string contents, aliasing the input
+	n    int
+}
+
+// readFunc decodes the token at the start of data and reports its length.
+type readFunc func(data []byte) (token, int, error)
+
+// encode appends the encoding of v to b.
+func encode(e emitter, tag string, b []byte, v reflect.Value) ([]byte, error) {
+	if !v.IsValid() {
+		return e.appendNil(b), nil
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return e.appendNil(b), nil
+		}
+		return encode(e, tag, b, v.Elem())
+	case reflect.Bool:
+		return e.appendBool(b, v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return e.appendInt(b, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return e.appendUint(b, v.Uint()), nil
+	case reflect.Float32:
+		return e.appendFloat32(b, float32(v.Float())), nil
+	case reflect.Float64:
+		return e.appendFloat64(b, v.Float()), nil
+	case reflect.String:
+		return e.appendString(b, v.String()), nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return e.appendNil(b), nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return e.appendBytes(b, v.Bytes()), nil
+		}
+		fallthrough
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			p := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(p), v)
+			return e.appendBytes(b, p), nil
+		}
+		b = e.appendArrayHeader(b, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			var err error
+			if b, err = encode(e, tag, b, v.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return e.appendNil(b), nil
+		}
+		keys := v.MapKeys()
+		if v.Type().Key().Kind() == reflect.String {
+			// Sorted keys keep the encoding deterministic.
+			sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		}
+		b = e.appendMapHeader(b, len(keys))
+		for _, k := range keys {
+			var err error
+			if b, err = encode(e, tag, b, k); err != nil {
+				return nil, err
+			}
+			if b, err = encode(e, tag, b, v.MapIndex(k)); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case reflect.Struct:
+		fields := cachedFields(v.Type(), tag)
+		n := 0
+		for _, f := range fields {
+			if !f.omitEmpty || !v.Field(f.index).IsZero() {
+				n++
+			}
+		}
+		b = e.appendMapHeader(b, n)
+		for _, f := range fields {
+			fv := v.Field(f.index)
+			if f.omitEmpty && fv.IsZero() {
+				continue
+			}
+			b = e.appendString(b, f.name)
+			var err error
+			if b, err = encode(e, tag, b, fv); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("codec: unsupported type %s", v.Type())
+}
+
+// decoder fills Go values from the tokens of one format.
+type decoder struct {
+	data  []byte
+	read  readFunc
+	tag   string
+	depth int
+}
+
+// unmarshal decodes exactly one value from data into the value v points to.
+func unmarshal(read readFunc, tag string, data []byte, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("codec: Unmarshal needs a non-nil pointer, got %T", v)
+	}
+	d := &decoder{data: data, read: read, tag: tag}
+	if err := d.decode(rv.Elem()); err != nil {
+		return err
+	}
+	if len(d.data) != 0 {
+		return fmt.Errorf("codec: %d trailing bytes", len(d.data))
+	}
+	return nil
+}
+
+func (d *decoder) next() (token, error) {
+	t, n, err := d.read(d.data)
+	if err != nil {
+		return t, err
+	}
+	d.data = d.data[n:]
+	// Every item takes at least one byte: reject lengths the input cannot hold.
+	if t.n < 0 || (t.kind == kindArray && t.n > len(d.data)) || (t.kind == kindMap && t.n > len(d.data)/2) {
+		return t, errTruncated
+	}
+	return t, nil
+}
+
+func (d *decoder) decode(v reflect.Value) error {
+	t, err := d.next()
+	if err != nil {
+		return err
+	}
+	return d.decodeToken(t, v)
+}
+
+func (d *decoder) decodeToken(t token, v reflect.Value) error {
+	if t.kind == kindArray || t.kind == kindMap {
+		if d.depth++; d.depth > maxDepth {
+			return errors.New("codec: nesting too deep")
+		}
+		defer func() { d.depth-- }()
+	}
+
+	if t.kind == kindNil {
+		switch v.Kind() {
+		case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice:
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.decodeToken(t, v.Elem())
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return fmt.Errorf("codec: cannot decode into %s", v.Type())
+		}
+		g, err := d.generic(t)
+		if err != nil {
+			return err
+		}
+		if g == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(g))
+		}
+		return nil
+	case reflect.Bool:
+		if t.kind != kindBool {
+			return mismatch(t, v)
+		}
+		v.SetBool(t.b)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := t.i
+		switch {
+		case t.kind == kindUint && t.u <= 1<<63-1:
+			i = int64(t.u)
+		case t.kind != kindInt:
+			return mismatch(t, v)
+		}
+		if v.OverflowInt(i) {
+			return fmt.Errorf("codec: %d overflows %s", i, v.Type())
+		}
+		v.SetInt(i)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := t.u
+		switch {
+		case t.kind == kindInt && t.i >= 0:
+			u = uint64(t.i)
+		case t.kind != kindUint:
+			return mismatch(t, v)
+		}
+		if v.OverflowUint(u) {
+			return fmt.Errorf("codec: %d overflows %s", u, v.Type())
+		}
+		v.SetUint(u)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		switch t.kind {
+		case kindFloat:
+			v.SetFloat(t.f)
+		case kindInt:
+			v.SetFloat(float64(t.i))
+		case kindUint:
+			v.SetFloat(float64(t.u))
+		default:
+			return mismatch(t, v)
+		}
+		return nil
+	case reflect.String:
+		if t.kind != kindString && t.kind != kindBytes {
+			return mismatch(t, v)
+		}
+		v.SetString(string(t.s))
+		return nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 && (t.kind == kindBytes || t.kind == kindString) {
+			v.SetBytes(append([]byte(nil), t.s...))
+			return nil
+		}
+		if t.kind != kindArray {
+			return mismatch(t, v)
+		}
+		s := reflect.MakeSlice(v.Type(), t.n, t.n)
+		for i := 0; i < t.n; i++ {
+			if err := d.decode(s.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+		return nil
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 && (t.kind == kindBytes || t.kind == kindString) {
+			reflect.Copy(v, reflect.ValueOf(t.s))
+			return nil
+		}
+		if t.kind != kindArray {
+			return mismatch(t, v)
+		}
+		for i := 0; i < t.n; i++ {
+			if i >= v.Len() {
+				if err := d.skip(); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := d.decode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		if t.kind != kindMap {
+			return mismatch(t, v)
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(v.Type(), t.n))
+		}
+		for i := 0; i < t.n; i++ {
+			k := reflect.New(v.Type().Key()).Elem()
+			if err := d.decode(k); err != nil {
+				return err
+			}
+			e := reflect.New(v.Type().Elem()).Elem()
+			if err := d.decode(e); err != nil {
+				return err
+			}
+			v.SetMapIndex(k, e)
+		}
+		return nil
+	case reflect.Struct:
+		if t.kind != kindMap {
+			return mismatch(t, v)
+		}
+		fields := cachedFields(v.Type(), d.tag)
+		for i := 0; i < t.n; i++ {
+			kt, err := d.next()
+			if err != nil {
+				return err
+			}
+			if kt.kind != kindString && kt.kind != kindBytes {
+				return fmt.Errorf("codec: non-string key for %s", v.Type())
+			}
+			if f := fieldByName(fields, string(kt.s)); f != nil {
+				if err := d.decode(v.Field(f.index)); err != nil {
+					return err
+				}
+			} else if err := d.skip(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("codec: cannot decode into %s", v.Type())
+}
+
+// generic decodes the item starting with t into the interface{} form.
+func (d *decoder) generic(t token) (any, error) {
+	switch t.kind {
+	case kindNil:
+		return nil, nil
+	case kindBool:
+		return t.b, nil
+	case kindInt:
+		return t.i, nil
+	case kindUint:
+		if t.u <= 1<<63-1 {
+			return int64(t.u), nil
+		}
+		return t.u, nil
+	case kindFloat:
+		return t.f, nil
+	case kindString:
+		return string(t.s), nil
+	case kindBytes:
+		return append([]byte(nil), t.s...), nil
+	case kindArray:
+		out := make([]any, t.n)
+		for i := range out {
+			if err := d.decode(reflect.ValueOf(&out[i]).Elem()); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	// Maps with string keys decode as map[string]any, others as map[any]any.
+	keys := make([]any, t.n)
+	vals := make([]any, t.n)
+	allStrings := true
+	for i := 0; i < t.n; i++ {
+		if err := d.decode(reflect.ValueOf(&keys[i]).Elem()); err != nil {
+			return nil, err
+		}
+		if err := d.decode(reflect.ValueOf(&vals[i]).Elem()); err != nil {
+			return nil, err
+		}
+		_, isString := keys[i].(string)
+		allStrings = allStrings && isString
+	}
+	if allStrings {
+		m := make(map[string]any, t.n)
+		for i, k := range keys {
+			m[k.(string)] = vals[i]
+		}
+		return m, nil
+	}
+	m := make(map[any]any, t.n)
+	for i, k := range keys {
+		if k != nil && !reflect.TypeOf(k).Comparable() {
+			return nil, fmt.Errorf("codec: unhashable map key of type %T", k)
+		}
+		m[k] = vals[i]
+	}
+	return m, nil
+}
+
+// skip consumes one item including its nested items.
+func (d *decoder) skip() error {
+	t, err := d.next()
+	if err != nil {
+		return err
+	}
+	n := 0
+	switch t.kind {
+	case kindArray:
+		n = t.n
+	case kindMap:
+		n = 2 * t.n
+	}
+	for i := 0; i < n; i++ {
+		if err := d.skip(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mismatch(t token, v reflect.Value) error {
+	names := [...]string{"nil", "bool", "int", "uint", "float", "string", "bytes", "array", "map"}
+	return fmt.Errorf("codec: cannot decode %s into %s", names[t.kind], v.Type())
+}
+
+// field is one encoded struct field.
+type field struct {
+	name      string
+	index     int
+	omitEmpty bool
+}
+
+var fieldCache sync.Map // fieldKey -> []field
+
+type fieldKey struct {
+	t   reflect.Type
+	tag string
+}
+
+// cachedFields returns the encoded fields of struct type t under tag.
+func cachedFields(t reflect.Type, tag string) []field {
+	key := fieldKey{t, tag}
+	if f, ok := fieldCache.Load(key); ok {
+		return f.([]field)
+	}
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(sf.Tag.Get(tag), ",")
+		if name == "-" && opts == "" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, field{name: name, index: i, omitEmpty: opts == "omitempty"})
+	}
+	fieldCache.Store(key, fields)
+	return fields
+}
+
+// fieldByName finds a field by exact name, then case-insensitively.
+func fieldByName(fields []field, name string) *field {
+	for i := range fields {
+		if fields[i].name == name {
+			return &fields[i]
+		}
+	}
+	for i := range fields {
+		if strings.EqualFold(fields[i].name, name) {
+			return &fields[i]
+		}
+	}
+	return nil
+}
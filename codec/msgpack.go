@@ -0,0 +1,213 @@
+// File: codec/msgpack.go
+// Package codec implements the MessagePack format.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Integers and lengths are written in their shortest form. Extension types
+// (including the timestamp extension) are rejected when decoding.
+
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/momentics/hioload-ws/highlevel"
+)
+
+// MsgPack is a highlevel.Codec writing MessagePack binary frames. Struct
+// fields are named by their `msgpack` tag.
+type MsgPack struct{}
+
+// Marshal implements highlevel.Codec.
+func (MsgPack) Marshal(v any) ([]byte, error) {
+	return encode(msgpackEmitter{}, "msgpack", nil, reflect.ValueOf(v))
+}
+
+// Unmarshal implements highlevel.Codec.
+func (MsgPack) Unmarshal(data []byte, v any) error {
+	return unmarshal(readMsgpack, "msgpack", data, v)
+}
+
+// MessageType implements highlevel.Codec.
+func (MsgPack) MessageType() highlevel.MessageType { return highlevel.BinaryMessage }
+
+type msgpackEmitter struct{}
+
+func (msgpackEmitter) appendNil(b []byte) []byte { return append(b, 0xc0) }
+
+func (msgpackEmitter) appendBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 0xc3)
+	}
+	return append(b, 0xc2)
+}
+
+func (e msgpackEmitter) appendInt(b []byte, v int64) []byte {
+	switch {
+	case v >= 0:
+		return e.appendUint(b, uint64(v))
+	case v >= -32:
+		return append(b, byte(v))
+	case v >= math.MinInt8:
+		return append(b, 0xd0, byte(v))
+	case v >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(v))
+	case v >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(v))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(v))
+}
+
+func (msgpackEmitter) appendUint(b []byte, v uint64) []byte {
+	switch {
+	case v < 0x80:
+		return append(b, byte(v))
+	case v <= math.MaxUint8:
+		return append(b, 0xcc, byte(v))
+	case v <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xcd), uint16(v))
+	case v <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, 0xce), uint32(v))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xcf), v)
+}
+
+func (msgpackEmitter) appendFloat32(b []byte, v float32) []byte {
+	return binary.BigEndian.AppendUint32(append(b, 0xca), math.Float32bits(v))
+}
+
+func (msgpackEmitter) appendFloat64(b []byte, v float64) []byte {
+	return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(v))
+}
+
+func (msgpackEmitter) appendString(b []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+func (msgpackEmitter) appendBytes(b []byte, p []byte) []byte {
+	n := len(p)
+	switch {
+	case n <= math.MaxUint8:
+		b = append(b, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xc5), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xc6), uint32(n))
+	}
+	return append(b, p...)
+}
+
+func (msgpackEmitter) appendArrayHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xdc), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, 0xdd), uint32(n))
+}
+
+func (msgpackEmitter) appendMapHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xde), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, 0xdf), uint32(n))
+}
+
+// readMsgpack decodes the MessagePack item at the start of data.
+func readMsgpack(data []byte) (token, int, error) {
+	if len(data) == 0 {
+		return token{}, 0, errTruncated
+	}
+	c := data[0]
+	switch {
+	case c < 0x80:
+		return token{kind: kindInt, i: int64(c)}, 1, nil
+	case c >= 0xe0:
+		return token{kind: kindInt, i: int64(int8(c))}, 1, nil
+	case c&0xf0 == 0x80:
+		return token{kind: kindMap, n: int(c & 0x0f)}, 1, nil
+	case c&0xf0 == 0x90:
+		return token{kind: kindArray, n: int(c & 0x0f)}, 1, nil
+	case c&0xe0 == 0xa0:
+		return readMsgpackBody(data, kindString, 1, int(c&0x1f))
+	}
+
+	switch c {
+	case 0xc0:
+		return token{kind: kindNil}, 1, nil
+	case 0xc2, 0xc3:
+		return token{kind: kindBool, b: c == 0xc3}, 1, nil
+	}
+
+	// The remaining types carry a 1, 2, 4 or 8 byte big-endian argument.
+	var size int
+	switch c {
+	case 0xcc, 0xd0, 0xd9, 0xc4:
+		size = 1
+	case 0xcd, 0xd1, 0xda, 0xc5, 0xdc, 0xde:
+		size = 2
+	case 0xce, 0xd2, 0xdb, 0xc6, 0xdd, 0xdf, 0xca:
+		size = 4
+	case 0xcf, 0xd3, 0xcb:
+		size = 8
+	default:
+		return token{}, 0, fmt.Errorf("codec: unsupported msgpack type 0x%02x", c)
+	}
+	if len(data) < 1+size {
+		return token{}, 0, errTruncated
+	}
+	var arg uint64
+	for _, x := range data[1 : 1+size] {
+		arg = arg<<8 | uint64(x)
+	}
+
+	switch c {
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return token{kind: kindUint, u: arg}, 1 + size, nil
+	case 0xd0:
+		return token{kind: kindInt, i: int64(int8(arg))}, 2, nil
+	case 0xd1:
+		return token{kind: kindInt, i: int64(int16(arg))}, 3, nil
+	case 0xd2:
+		return token{kind: kindInt, i: int64(int32(arg))}, 5, nil
+	case 0xd3:
+		return token{kind: kindInt, i: int64(arg)}, 9, nil
+	case 0xca:
+		return token{kind: kindFloat, f: float64(math.Float32frombits(uint32(arg)))}, 5, nil
+	case 0xcb:
+		return token{kind: kindFloat, f: math.Float64frombits(arg)}, 9, nil
+	case 0xd9, 0xda, 0xdb:
+		return readMsgpackBody(data, kindString, 1+size, int(arg))
+	case 0xc4, 0xc5, 0xc6:
+		return readMsgpackBody(data, kindBytes, 1+size, int(arg))
+	case 0xdc, 0xdd:
+		return token{kind: kindArray, n: int(arg)}, 1 + size, nil
+	}
+	return token{kind: kindMap, n: int(arg)}, 1 + size, nil
+}
+
+// readMsgpackBody returns the string or binary of length n after the header.
+func readMsgpackBody(data []byte, kind tokenKind, header, n int) (token, int, error) {
+	if n < 0 || n > len(data)-header {
+		return token{}, 0, errTruncated
+	}
+	return token{kind: kind, s: data[header : header+n]}, header + n, nil
+}
@@ -0,0 +1,61 @@
+// File: codec/protobuf.go
+// Package codec adapts generated protocol buffer code.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// The protobuf runtime is not a dependency of this module: messages encode
+// themselves through their generated methods, or through functions the
+// application supplies, e.g. for google.golang.org/protobuf:
+//
+//	codec.Protobuf{
+//		MarshalFunc:   func(v any) ([]byte, error) { return proto.Marshal(v.(proto.Message)) },
+//		UnmarshalFunc: func(b []byte, v any) error { return proto.Unmarshal(b, v.(proto.Message)) },
+//	}
+
+package codec
+
+import (
+	"fmt"
+
+	"github.com/momentics/hioload-ws/highlevel"
+)
+
+// Protobuf is a highlevel.Codec writing protocol buffer binary frames. It
+// uses MarshalFunc and UnmarshalFunc when set, and otherwise the message's
+// MarshalVT/UnmarshalVT (vtprotobuf) or Marshal/Unmarshal (gogo/protobuf)
+// methods.
+type Protobuf struct {
+	MarshalFunc   func(v any) ([]byte, error)
+	UnmarshalFunc func(data []byte, v any) error
+}
+
+// Marshal implements highlevel.Codec.
+func (p Protobuf) Marshal(v any) ([]byte, error) {
+	if p.MarshalFunc != nil {
+		return p.MarshalFunc(v)
+	}
+	switch m := v.(type) {
+	case interface{ MarshalVT() ([]byte, error) }:
+		return m.MarshalVT()
+	case interface{ Marshal() ([]byte, error) }:
+		return m.Marshal()
+	}
+	return nil, fmt.Errorf("codec: %T is not a generated protobuf message", v)
+}
+
+// Unmarshal implements highlevel.Codec.
+func (p Protobuf) Unmarshal(data []byte, v any) error {
+	if p.UnmarshalFunc != nil {
+		return p.UnmarshalFunc(data, v)
+	}
+	switch m := v.(type) {
+	case interface{ UnmarshalVT([]byte) error }:
+		return m.UnmarshalVT(data)
+	case interface{ Unmarshal([]byte) error }:
+		return m.Unmarshal(data)
+	}
+	return fmt.Errorf("codec: %T is not a generated protobuf message", v)
+}
+
+// MessageType implements highlevel.Codec.
+func (Protobuf) MessageType() highlevel.MessageType { return highlevel.BinaryMessage }
@@ -0,0 +1,151 @@
+// File: conformance/cases.go
+// Package conformance
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package conformance
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// Case is one check of the battery.
+type Case struct {
+	Name    string // short identifier, e.g. "ping-fragmented"
+	Section string // the RFC 6455 section it checks
+	Echo    bool   // needs a server echoing data messages, see Options.Echo
+	run     func(p *peer) error
+}
+
+// Cases returns the battery in the order Run runs it.
+func Cases() []Case {
+	return []Case{
+		{Name: "ping-pong", Section: "5.5.2", run: func(p *peer) error {
+			return roundTrip(p, []byte("hello"))
+		}},
+		{Name: "ping-max-payload", Section: "5.5", run: func(p *peer) error {
+			return roundTrip(p, bytes.Repeat([]byte{'p'}, protocol.MaxControlPayloadLen))
+		}},
+		{Name: "ping-empty", Section: "5.5.2", run: func(p *peer) error {
+			return roundTrip(p, nil)
+		}},
+		{Name: "ping-fragmented", Section: "5.5", run: func(p *peer) error {
+			return closedWith(p, protocol.CloseProtocolError,
+				ping([]byte("frag")).more(), cont("mented"))
+		}},
+		{Name: "ping-too-long", Section: "5.5", run: func(p *peer) error {
+			return closedWith(p, protocol.CloseProtocolError,
+				ping(bytes.Repeat([]byte{'p'}, protocol.MaxControlPayloadLen+1)))
+		}},
+		{Name: "unsolicited-pong", Section: "5.5.3", run: func(p *peer) error {
+			if err := p.send(pong([]byte("unasked"))); err != nil {
+				return err
+			}
+			return p.expectOpen()
+		}},
+		{Name: "fragmented-text", Section: "5.4", Echo: true, run: func(p *peer) error {
+			if err := p.send(text("frag").more(), cont("men").more(), cont("ted")); err != nil {
+				return err
+			}
+			return p.expectMessage([]byte("fragmented"))
+		}},
+		{Name: "interleaved-ping", Section: "5.4", run: func(p *peer) error {
+			if err := p.send(text("frag").more(), ping([]byte("between")), cont("mented")); err != nil {
+				return err
+			}
+			if err := p.expectPong([]byte("between")); err != nil {
+				return err
+			}
+			if err := p.expectMessage([]byte("fragmented")); err != nil {
+				return err
+			}
+			return p.expectOpen()
+		}},
+		{Name: "interleaved-pong", Section: "5.4", run: func(p *peer) error {
+			if err := p.send(text("frag").more(), pong([]byte("between")), cont("mented")); err != nil {
+				return err
+			}
+			if err := p.expectMessage([]byte("fragmented")); err != nil {
+				return err
+			}
+			return p.expectOpen()
+		}},
+		{Name: "unmasked-text", Section: "5.1", run: func(p *peer) error {
+			return closedWith(p, protocol.CloseProtocolError, text("plain").plain())
+		}},
+		{Name: "unmasked-ping", Section: "5.1", run: func(p *peer) error {
+			return closedWith(p, protocol.CloseProtocolError, ping([]byte("plain")).plain())
+		}},
+		{Name: "reserved-bits", Section: "5.2", run: func(p *peer) error {
+			return closedWith(p, protocol.CloseProtocolError, text("rsv2").withRsv(0x20))
+		}},
+		{Name: "reserved-opcode-data", Section: "5.2", run: func(p *peer) error {
+			return closedWith(p, protocol.CloseProtocolError, frame{fin: true, opcode: 0x3, payload: []byte("op3")})
+		}},
+		{Name: "reserved-opcode-control", Section: "5.2", run: func(p *peer) error {
+			return closedWith(p, protocol.CloseProtocolError, frame{fin: true, opcode: 0xB, payload: []byte("op11")})
+		}},
+		{Name: "text-invalid-utf8", Section: "8.1", run: func(p *peer) error {
+			return closedWith(p, protocol.CloseInvalidPayloadData, text("\xce\xba\xe1\xbd"))
+		}},
+		{Name: "close-normal", Section: "5.5.1", run: func(p *peer) error {
+			return closedWith(p, protocol.CloseNormalClosure, closeFrame(closePayload(protocol.CloseNormalClosure, "bye")))
+		}},
+		{Name: "close-empty", Section: "5.5.1", run: func(p *peer) error {
+			return closedWith(p, protocol.CloseNoStatusRcvd, closeFrame(nil))
+		}},
+		{Name: "close-reason-too-long", Section: "5.5", run: func(p *peer) error {
+			reason := string(bytes.Repeat([]byte{'r'}, protocol.MaxControlPayloadLen-1))
+			return closedWith(p, protocol.CloseProtocolError, closeFrame(closePayload(protocol.CloseNormalClosure, reason)))
+		}},
+		{Name: "close-truncated-code", Section: "5.5.1", run: func(p *peer) error {
+			return closedWith(p, protocol.CloseProtocolError, closeFrame([]byte{0x03}))
+		}},
+		{Name: "close-reserved-code", Section: "7.4.1", run: func(p *peer) error {
+			return closedWith(p, protocol.CloseProtocolError, closeFrame(closePayload(protocol.CloseNoStatusRcvd, "")))
+		}},
+		{Name: "close-code-out-of-range", Section: "7.4.2", run: func(p *peer) error {
+			return closedWith(p, protocol.CloseProtocolError, closeFrame(closePayload(999, "")))
+		}},
+		{Name: "close-invalid-utf8", Section: "5.5.1", run: func(p *peer) error {
+			return closedWith(p, protocol.CloseInvalidPayloadData, closeFrame(closePayload(protocol.CloseNormalClosure, "\xff")))
+		}},
+	}
+}
+
+// Select returns the cases named, in the order given.
+func Select(names ...string) ([]Case, error) {
+	all := Cases()
+	byName := make(map[string]Case, len(all))
+	for _, c := range all {
+		byName[c.Name] = c
+	}
+	cases := make([]Case, 0, len(names))
+	for _, name := range names {
+		c, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("conformance: unknown case %q", name)
+		}
+		cases = append(cases, c)
+	}
+	return cases, nil
+}
+
+// roundTrip pings with payload and expects the pong echoing it.
+func roundTrip(p *peer, payload []byte) error {
+	if err := p.send(ping(payload)); err != nil {
+		return err
+	}
+	return p.expectPong(payload)
+}
+
+// closedWith sends frames and expects the server to close with code.
+func closedWith(p *peer, code uint16, frames ...frame) error {
+	if err := p.send(frames...); err != nil {
+		return err
+	}
+	return p.expectClose(code)
+}
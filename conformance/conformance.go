@@ -0,0 +1,193 @@
+// File: conformance/conformance.go
+// Package conformance checks a WebSocket server against RFC 6455 edge cases.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Run opens a fresh connection per case, sends frames a lenient or broken
+// client would, and checks the server answers as the RFC requires: pongs
+// echoing pings, control frames accepted between fragments, and protocol
+// violations (fragmented or oversized control frames, unmasked frames,
+// reserved bits and opcodes, malformed close payloads, invalid UTF-8)
+// closing the connection with the right code. Servers of this module pass
+// every case with Config.StrictValidation set.
+//
+// The report lists the outcome per case, so it fits a CI job or a test:
+//
+//	rep := conformance.Run(ctx, conformance.Dial("ws://localhost:8080/ws", nil), conformance.Options{Echo: true})
+//	if err := rep.Err(); err != nil {
+//		t.Fatal(err)
+//	}
+//
+// InMemory runs the same battery against a server in the process, through
+// in-memory transports, without a listener.
+
+package conformance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// DefaultTimeout bounds the wait for each answer of the server.
+const DefaultTimeout = 2 * time.Second
+
+// Status is the outcome of a case.
+type Status int
+
+const (
+	Pass Status = iota
+	Fail
+	Skip // not run, see Result.Detail
+)
+
+func (s Status) String() string {
+	switch s {
+	case Pass:
+		return "pass"
+	case Fail:
+		return "fail"
+	case Skip:
+		return "skip"
+	}
+	return "status(" + strconv.Itoa(int(s)) + ")"
+}
+
+// MarshalText encodes s as its name, for JSON reports.
+func (s Status) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Options tune a run.
+type Options struct {
+	Timeout time.Duration // wait for each answer of the server, DefaultTimeout if 0
+	Echo    bool          // the server echoes data messages; cases checking them are skipped otherwise
+	Cases   []Case        // cases to run, all of Cases() if nil
+}
+
+// Result is the outcome of one case.
+type Result struct {
+	Case     string        `json:"case"`
+	Section  string        `json:"section"`
+	Status   Status        `json:"status"`
+	Detail   string        `json:"detail,omitempty"` // why the case failed or was skipped
+	Duration time.Duration `json:"duration"`
+}
+
+// Report is the outcome of a run, one Result per case in order.
+type Report struct {
+	Results []Result `json:"results"`
+}
+
+// Run runs the cases against the server dial connects to, one connection
+// per case. Cases left when ctx ends are skipped.
+func Run(ctx context.Context, dial Dialer, opts Options) *Report {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	cases := opts.Cases
+	if cases == nil {
+		cases = Cases()
+	}
+	rep := &Report{Results: make([]Result, 0, len(cases))}
+	for _, c := range cases {
+		res := Result{Case: c.Name, Section: c.Section}
+		switch {
+		case ctx.Err() != nil:
+			res.Status, res.Detail = Skip, ctx.Err().Error()
+		case c.Echo && !opts.Echo:
+			res.Status, res.Detail = Skip, "needs an echo server"
+		default:
+			start := time.Now()
+			if err := runCase(ctx, dial, c, opts); err != nil {
+				res.Status, res.Detail = Fail, err.Error()
+			}
+			res.Duration = time.Since(start)
+		}
+		rep.Results = append(rep.Results, res)
+	}
+	return rep
+}
+
+// runCase runs c on a connection of its own.
+func runCase(ctx context.Context, dial Dialer, c Case, opts Options) error {
+	tr, err := dial(ctx)
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	p := newPeer(tr, opts.Timeout, opts.Echo)
+	defer p.close()
+	return c.run(p)
+}
+
+// Passed reports whether no case failed.
+func (r *Report) Passed() bool {
+	return len(r.Failed()) == 0
+}
+
+// Failed returns the results of the cases that failed.
+func (r *Report) Failed() []Result {
+	var failed []Result
+	for _, res := range r.Results {
+		if res.Status == Fail {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+// Err returns an error listing the failed cases, or nil.
+func (r *Report) Err() error {
+	failed := r.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(failed))
+	for i, res := range failed {
+		msgs[i] = res.Case + ": " + res.Detail
+	}
+	return fmt.Errorf("conformance: %d of %d cases failed: %s", len(failed), len(r.Results), strings.Join(msgs, "; "))
+}
+
+// Counts returns the number of cases passed, failed and skipped.
+func (r *Report) Counts() (passed, failed, skipped int) {
+	for _, res := range r.Results {
+		switch res.Status {
+		case Pass:
+			passed++
+		case Fail:
+			failed++
+		case Skip:
+			skipped++
+		}
+	}
+	return passed, failed, skipped
+}
+
+// WriteText writes the report as a table with a summary line.
+func (r *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CASE\tSECTION\tSTATUS\tDETAIL")
+	for _, res := range r.Results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", res.Case, res.Section, res.Status, res.Detail)
+	}
+	passed, failed, skipped := r.Counts()
+	fmt.Fprintf(tw, "\n%d passed, %d failed, %d skipped\n", passed, failed, skipped)
+	return tw.Flush()
+}
+
+// WriteJSON writes the report as JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// errTimeout reports a server that did not answer within Options.Timeout.
+var errTimeout = errors.New("no answer from the server")
@@ -0,0 +1,289 @@
+// File: conformance/peer.go
+// Package conformance
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package conformance
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// frame is a client frame, encoded as given even when the RFC forbids it.
+type frame struct {
+	fin      bool
+	rsv      byte // RSV1-3 bits as in the first header byte
+	opcode   byte
+	unmasked bool
+	payload  []byte
+}
+
+// text, cont, ping, pong and closeFrame build the usual final, masked frames.
+func text(p string) frame { return frame{fin: true, opcode: protocol.OpcodeText, payload: []byte(p)} }
+func cont(p string) frame {
+	return frame{fin: true, opcode: protocol.OpcodeContinuation, payload: []byte(p)}
+}
+func ping(p []byte) frame            { return frame{fin: true, opcode: protocol.OpcodePing, payload: p} }
+func pong(p []byte) frame            { return frame{fin: true, opcode: protocol.OpcodePong, payload: p} }
+func closeFrame(p []byte) frame      { return frame{fin: true, opcode: protocol.OpcodeClose, payload: p} }
+func (f frame) more() frame          { f.fin = false; return f }
+func (f frame) withRsv(r byte) frame { f.rsv = r; return f }
+func (f frame) plain() frame         { f.unmasked = true; return f }
+
+// closePayload returns the payload of a close frame with code and reason.
+func closePayload(code uint16, reason string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, code), reason...)
+}
+
+// encode returns the frame on the wire, masked with a fixed key unless
+// unmasked.
+func (f frame) encode() []byte {
+	b0 := f.rsv | f.opcode
+	if f.fin {
+		b0 |= protocol.FinBit
+	}
+	var mask byte
+	if !f.unmasked {
+		mask = 0x80
+	}
+	out := []byte{b0}
+	switch n := len(f.payload); {
+	case n <= 125:
+		out = append(out, mask|byte(n))
+	case n <= 0xFFFF:
+		out = binary.BigEndian.AppendUint16(append(out, mask|126), uint16(n))
+	default:
+		out = binary.BigEndian.AppendUint64(append(out, mask|127), uint64(n))
+	}
+	if f.unmasked {
+		return append(out, f.payload...)
+	}
+	key := [4]byte{0x37, 0xfa, 0x21, 0x3d}
+	out = append(out, key[:]...)
+	for i, c := range f.payload {
+		out = append(out, c^key[i%4])
+	}
+	return out
+}
+
+// received is a chunk read from the server, or the error ending the reads.
+type received struct {
+	data [][]byte
+	err  error
+}
+
+// peer is the client side of a case: it writes raw frames and decodes the
+// server's answers within the timeout.
+type peer struct {
+	tr      api.Transport
+	timeout time.Duration
+	echo    bool
+
+	recv   chan received
+	done   chan struct{}
+	dec    *protocol.FrameDecoder
+	frames []*protocol.WSFrame // decoded, not yet read
+	err    error               // the transport failed or the server sent garbage
+	data   []byte              // payloads of the data frames read so far
+}
+
+func newPeer(tr api.Transport, timeout time.Duration, echo bool) *peer {
+	p := &peer{
+		tr:      tr,
+		timeout: timeout,
+		echo:    echo,
+		recv:    make(chan received, 16),
+		done:    make(chan struct{}),
+		dec:     protocol.NewFrameDecoder(nil),
+	}
+	go p.readLoop()
+	return p
+}
+
+// readLoop passes what the transport receives to next until it fails.
+func (p *peer) readLoop() {
+	for {
+		data, err := p.tr.Recv()
+		select {
+		case p.recv <- received{data, err}:
+		case <-p.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (p *peer) close() {
+	close(p.done)
+	p.tr.Close()
+}
+
+// send writes frames with a single transport write.
+func (p *peer) send(frames ...frame) error {
+	var buf []byte
+	for _, f := range frames {
+		buf = append(buf, f.encode()...)
+	}
+	if err := p.tr.Send([][]byte{buf}); err != nil {
+		return fmt.Errorf("send: %w", err)
+	}
+	return nil
+}
+
+// next returns the next frame of the server other than a ping, which a
+// server may send at any time. It fails once the connection is closed.
+func (p *peer) next() (*protocol.WSFrame, error) {
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+	for {
+		for len(p.frames) > 0 {
+			f := p.frames[0]
+			p.frames = p.frames[1:]
+			switch {
+			case f.Masked:
+				return nil, errors.New("server sent a masked frame (RFC 6455 5.1)")
+			case f.Opcode == protocol.OpcodePing:
+				continue
+			case f.Opcode == protocol.OpcodeText || f.Opcode == protocol.OpcodeBinary || f.Opcode == protocol.OpcodeContinuation:
+				p.data = append(p.data, f.Payload...)
+			}
+			return f, nil
+		}
+		if p.err != nil {
+			return nil, p.err
+		}
+		select {
+		case r := <-p.recv:
+			if r.err != nil {
+				p.err = errClosed
+				continue
+			}
+			for _, seg := range r.data {
+				err := p.dec.Decode(seg, func(f *protocol.WSFrame) error {
+					f.Payload = bytes.Clone(f.Payload[:f.PayloadLen])
+					f.Buf = api.Buffer{}
+					p.frames = append(p.frames, f)
+					return nil
+				})
+				if err != nil {
+					p.err = fmt.Errorf("undecodable frame from the server: %w", err)
+					break
+				}
+			}
+		case <-timer.C:
+			return nil, errTimeout
+		}
+	}
+}
+
+// errClosed reports a connection the server closed.
+var errClosed = errors.New("connection closed")
+
+// expectPong reads until the pong answering a ping with payload.
+func (p *peer) expectPong(payload []byte) error {
+	for {
+		f, err := p.next()
+		if err != nil {
+			return fmt.Errorf("expected pong: %w", err)
+		}
+		switch f.Opcode {
+		case protocol.OpcodePong:
+			if bytes.Equal(f.Payload, payload) {
+				return nil
+			}
+			// An unsolicited pong, allowed by RFC 6455 5.5.3.
+		case protocol.OpcodeClose:
+			return fmt.Errorf("expected pong, got %s", describe(f))
+		}
+	}
+}
+
+// expectMessage reads until the data frames received hold want, when the
+// server echoes; a server may echo a fragmented message as it likes.
+func (p *peer) expectMessage(want []byte) error {
+	if !p.echo {
+		return nil
+	}
+	for len(p.data) < len(want) {
+		f, err := p.next()
+		if err != nil {
+			return fmt.Errorf("expected echo of %q: %w", want, err)
+		}
+		if f.Opcode == protocol.OpcodeClose {
+			return fmt.Errorf("expected echo of %q, got %s", want, describe(f))
+		}
+	}
+	if !bytes.Equal(p.data, want) {
+		return fmt.Errorf("expected echo of %q, got %q", want, p.data)
+	}
+	p.data = nil
+	return nil
+}
+
+// expectOpen checks the connection survived what was sent so far with a
+// ping round trip.
+func (p *peer) expectOpen() error {
+	probe := []byte("still open?")
+	if err := p.send(ping(probe)); err != nil {
+		return err
+	}
+	return p.expectPong(probe)
+}
+
+// expectClose reads until the server closes the connection: with a close
+// frame carrying code, or no code for CloseNoStatusRcvd, then the end of
+// the stream. Failing the connection without a close frame is also allowed
+// (RFC 6455 7.1.7).
+func (p *peer) expectClose(code uint16) error {
+	for {
+		f, err := p.next()
+		if errors.Is(err, errClosed) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("expected close %d: %w", code, err)
+		}
+		if f.Opcode == protocol.OpcodeClose {
+			if got, _, err := protocol.ParseClosePayload(f.Payload); err != nil || got != code {
+				return fmt.Errorf("expected close %d, got %s", code, describe(f))
+			}
+			return p.expectEOF()
+		}
+		// Pongs and data frames, e.g. echoes of what came before, may precede it.
+	}
+}
+
+// expectEOF checks the server closes the stream after its close frame.
+func (p *peer) expectEOF() error {
+	f, err := p.next()
+	switch {
+	case errors.Is(err, errClosed):
+		return nil
+	case errors.Is(err, errTimeout):
+		return errors.New("connection left open after the close handshake")
+	case err != nil:
+		return err
+	}
+	return fmt.Errorf("%s after the close frame", describe(f))
+}
+
+// describe names a frame of the server in failure details.
+func describe(f *protocol.WSFrame) string {
+	switch f.Opcode {
+	case protocol.OpcodeClose:
+		code, reason, _ := protocol.ParseClosePayload(f.Payload)
+		return fmt.Sprintf("close %d %q", code, reason)
+	case protocol.OpcodePong:
+		return fmt.Sprintf("pong %q", f.Payload)
+	}
+	return fmt.Sprintf("opcode %d frame of %d bytes", f.Opcode, f.PayloadLen)
+}
@@ -0,0 +1,157 @@
+// File: conformance/target.go
+// Package conformance
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package conformance
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/protocol"
+	"github.com/momentics/hioload-ws/sim"
+)
+
+// Dialer opens a connection to the server under test, upgraded and ready
+// for frames.
+type Dialer func(ctx context.Context) (api.Transport, error)
+
+// TransportServer serves connections over transports it did not accept,
+// as server.Server does.
+type TransportServer interface {
+	ServeTransport(tr api.Transport, req *http.Request) error
+}
+
+// InMemory connects to srv through in-memory transports, as upgrades of
+// path. Their deadlines follow a simulated clock that never moves, so the
+// server's read and write timeouts do not interfere.
+func InMemory(srv TransportServer, path string) Dialer {
+	return func(ctx context.Context) (api.Transport, error) {
+		a, b := sim.Pipe(sim.NewClock(time.Time{}))
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://conformance"+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := srv.ServeTransport(a, req); err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+}
+
+// Dial connects to a ws:// or wss:// URL, adding header to the upgrade
+// request, e.g. for authentication.
+func Dial(rawurl string, header http.Header) Dialer {
+	return func(ctx context.Context) (api.Transport, error) {
+		u, err := url.Parse(rawurl)
+		if err != nil {
+			return nil, err
+		}
+		port := u.Port()
+		switch {
+		case u.Scheme != "ws" && u.Scheme != "wss":
+			return nil, fmt.Errorf("conformance: unsupported scheme %q", u.Scheme)
+		case port == "" && u.Scheme == "ws":
+			port = "80"
+		case port == "":
+			port = "443"
+		}
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(u.Hostname(), port))
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme == "wss" {
+			tc := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+			if err := tc.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			conn = tc
+		}
+		tr, err := upgrade(ctx, conn, u, header)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tr, nil
+	}
+}
+
+// upgrade runs the opening handshake on conn.
+func upgrade(ctx context.Context, conn net.Conn, u *url.URL, header http.Header) (api.Transport, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(DefaultTimeout))
+	}
+	key := make([]byte, 16)
+	rand.Read(key)
+	req := &http.Request{Method: http.MethodGet, URL: u, Host: u.Host, Header: header.Clone()}
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	req.Header.Set(protocol.HeaderUpgrade, "websocket")
+	req.Header.Set(protocol.HeaderConnection, "Upgrade")
+	req.Header.Set(protocol.HeaderSecWebSocketKey, base64.StdEncoding.EncodeToString(key))
+	req.Header.Set(protocol.HeaderSecWebSocketVer, protocol.RequiredWebSocketVersion)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "GET %s HTTP/1.1\r\nHost: %s\r\n", u.RequestURI(), u.Host)
+	req.Header.Write(&b)
+	b.WriteString("\r\n")
+	if _, err := conn.Write(b.Bytes()); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := protocol.DoClientHandshakeResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	accept := protocol.AppendAcceptKey(nil, []byte(req.Header.Get(protocol.HeaderSecWebSocketKey)))
+	if resp.Header.Get("Sec-WebSocket-Accept") != string(accept) {
+		return nil, fmt.Errorf("%w: wrong Sec-WebSocket-Accept", api.ErrHandshakeFailed)
+	}
+	conn.SetDeadline(time.Time{})
+	return &netTransport{conn: conn, r: br}, nil
+}
+
+// netTransport carries the frames of a dialed connection.
+type netTransport struct {
+	conn net.Conn
+	r    *bufio.Reader // holds what the server sent after its 101
+}
+
+func (t *netTransport) Send(bufs [][]byte) error {
+	nb := net.Buffers(bufs)
+	_, err := nb.WriteTo(t.conn)
+	return err
+}
+
+func (t *netTransport) Recv() ([][]byte, error) {
+	buf := make([]byte, 32<<10)
+	n, err := t.r.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{buf[:n]}, nil
+}
+
+func (t *netTransport) Close() error {
+	return t.conn.Close()
+}
+
+func (t *netTransport) Features() api.TransportFeatures {
+	return api.TransportFeatures{}
+}
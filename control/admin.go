@@ -0,0 +1,405 @@
+// File: control/admin.go
+// Package control
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Embeddable admin HTTP server for runtime introspection, meant to listen on
+// a separate, non-public port.
+
+package control
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConnInfo describes one live connection in the admin listing.
+type ConnInfo struct {
+	ID          string           `json:"id,omitempty"`
+	RemoteAddr  string           `json:"remote_addr,omitempty"`
+	Path        string           `json:"path,omitempty"`
+	Subprotocol string           `json:"subprotocol,omitempty"`
+	LastActive  time.Time        `json:"last_active,omitempty"`
+	Stats       map[string]int64 `json:"stats,omitempty"`
+	Trace       bool             `json:"trace,omitempty"`
+}
+
+// AdminOption configures an AdminServer.
+type AdminOption func(*AdminServer)
+
+// WithAdminToken requires every request to carry token, either as
+// "Authorization: Bearer <token>" or in the X-Admin-Token header. Without a
+// token the admin server is unauthenticated.
+func WithAdminToken(token string) AdminOption {
+	return func(a *AdminServer) {
+		a.token = token
+	}
+}
+
+// WithAdminStats serves fn at GET /stats and, in Prometheus format, at
+// GET /metrics.
+func WithAdminStats(fn StatsFunc) AdminOption {
+	return func(a *AdminServer) {
+		a.stats = fn
+	}
+}
+
+// WithAdminProbes serves fn, typically DebugProbes.DumpState, at
+// GET /debug/probes.
+func WithAdminProbes(fn StatsFunc) AdminOption {
+	return func(a *AdminServer) {
+		a.probes = fn
+	}
+}
+
+// WithAdminConfig serves get at GET /config. When set is non-nil, POST
+// /reload accepts an optional JSON object of config updates and applies it
+// through set before reloading.
+func WithAdminConfig(get StatsFunc, set func(map[string]any) error) AdminOption {
+	return func(a *AdminServer) {
+		a.config = get
+		a.setConfig = set
+	}
+}
+
+// WithAdminConnections serves fn at GET /connections.
+func WithAdminConnections(fn func() []ConnInfo) AdminOption {
+	return func(a *AdminServer) {
+		a.conns = fn
+	}
+}
+
+// WithAdminTrace enables POST /connections/trace?id=<id>[&enabled=false],
+// which toggles the per-connection debug trace through fn.
+func WithAdminTrace(fn func(id string, enabled bool) error) AdminOption {
+	return func(a *AdminServer) {
+		a.trace = fn
+	}
+}
+
+// WithAdminHealth serves h's probes at GET /livez and GET /readyz. Both are
+// exempt from the admin token so orchestrators can poll them.
+func WithAdminHealth(h *HealthRegistry) AdminOption {
+	return func(a *AdminServer) {
+		a.health = h
+	}
+}
+
+// WithAdminReload replaces the POST /reload action, which defaults to
+// TriggerHotReloadSync.
+func WithAdminReload(fn func()) AdminOption {
+	return func(a *AdminServer) {
+		a.reload = fn
+	}
+}
+
+// WithAdminDrain enables POST /drain. The optional "timeout" query parameter
+// (a Go duration) bounds the context passed to fn.
+func WithAdminDrain(fn func(ctx context.Context) error) AdminOption {
+	return func(a *AdminServer) {
+		a.drain = fn
+	}
+}
+
+// WithAdminPprof toggles the /debug/pprof/ handlers, enabled by default.
+func WithAdminPprof(enabled bool) AdminOption {
+	return func(a *AdminServer) {
+		a.pprof = enabled
+	}
+}
+
+// AdminServer exposes stats, probes, config, connections, health, pprof and
+// reload/drain actions over HTTP. Endpoints whose source was not configured
+// answer 404.
+type AdminServer struct {
+	addr      string
+	token     string
+	stats     StatsFunc
+	probes    StatsFunc
+	config    StatsFunc
+	setConfig func(map[string]any) error
+	conns     func() []ConnInfo
+	trace     func(id string, enabled bool) error
+	health    *HealthRegistry
+	reload    func()
+	drain     func(ctx context.Context) error
+	pprof     bool
+
+	handler http.Handler
+
+	mu  sync.Mutex
+	srv *http.Server
+	ln  net.Listener
+}
+
+// NewAdminServer creates an admin server for addr (e.g. "127.0.0.1:9100").
+// Use Handler to mount it into an existing mux instead of listening.
+func NewAdminServer(addr string, opts ...AdminOption) *AdminServer {
+	a := &AdminServer{addr: addr, reload: TriggerHotReloadSync, pprof: true}
+	for _, opt := range opts {
+		opt(a)
+	}
+	a.handler = a.routes()
+	return a
+}
+
+// Handler returns the authenticated admin handler.
+func (a *AdminServer) Handler() http.Handler {
+	return a.handler
+}
+
+// Addr returns the bound address once listening, else the configured one.
+func (a *AdminServer) Addr() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.ln != nil {
+		return a.ln.Addr().String()
+	}
+	return a.addr
+}
+
+// Start binds the listener and serves in the background.
+func (a *AdminServer) Start() error {
+	srv, ln, err := a.listen()
+	if err != nil {
+		return err
+	}
+	go srv.Serve(ln)
+	return nil
+}
+
+// ListenAndServe binds and serves, blocking until Shutdown.
+func (a *AdminServer) ListenAndServe() error {
+	srv, ln, err := a.listen()
+	if err != nil {
+		return err
+	}
+	return srv.Serve(ln)
+}
+
+func (a *AdminServer) listen() (*http.Server, net.Listener, error) {
+	ln, err := net.Listen("tcp", a.addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	srv := &http.Server{Handler: a.handler, ReadHeaderTimeout: 10 * time.Second}
+	a.mu.Lock()
+	a.srv, a.ln = srv, ln
+	a.mu.Unlock()
+	return srv, ln, nil
+}
+
+// Shutdown stops the admin server gracefully.
+func (a *AdminServer) Shutdown(ctx context.Context) error {
+	a.mu.Lock()
+	srv := a.srv
+	a.mu.Unlock()
+	if srv == nil {
+		return nil
+	}
+	return srv.Shutdown(ctx)
+}
+
+func (a *AdminServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", a.get(func() any { return callStats(a.stats) }, a.stats != nil))
+	mux.HandleFunc("/debug/probes", a.get(func() any { return callStats(a.probes) }, a.probes != nil))
+	mux.HandleFunc("/config", a.get(func() any { return callStats(a.config) }, a.config != nil))
+	mux.HandleFunc("/connections", a.get(func() any {
+		list := a.conns()
+		if list == nil {
+			list = []ConnInfo{}
+		}
+		return list
+	}, a.conns != nil))
+	if a.stats != nil {
+		mux.Handle("/metrics", PrometheusHandler(a.stats))
+	}
+	mux.HandleFunc("/connections/trace", a.handleTrace)
+	mux.HandleFunc("/reload", a.handleReload)
+	mux.HandleFunc("/drain", a.handleDrain)
+	if a.pprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	if a.health == nil {
+		return a.authenticate(mux)
+	}
+	outer := http.NewServeMux()
+	outer.Handle("/livez", a.health.LiveHandler())
+	outer.Handle("/readyz", a.health.ReadyHandler())
+	outer.Handle("/", a.authenticate(mux))
+	return outer
+}
+
+// authenticate rejects requests without the configured token.
+func (a *AdminServer) authenticate(next http.Handler) http.Handler {
+	if a.token == "" {
+		return next
+	}
+	want := []byte(a.token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := r.Header.Get("X-Admin-Token")
+		if auth := r.Header.Get("Authorization"); got == "" && strings.HasPrefix(auth, "Bearer ") {
+			got = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(got), want) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="hioload-admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// get serves the JSON encoding of fn's result for GET requests.
+func (a *AdminServer) get(fn func() any, enabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !enabled {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, fn())
+	}
+}
+
+func (a *AdminServer) handleReload(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	var updates map[string]any
+	if r.ContentLength != 0 && a.setConfig != nil {
+		if err := json.NewDecoder(r.Body).Decode(&updates); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "invalid config: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if len(updates) > 0 {
+		// SetConfig implementations trigger the reload hooks themselves.
+		if err := a.setConfig(updates); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else if a.reload != nil {
+		a.reload()
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"reloaded": true, "updated": len(updates)})
+}
+
+func (a *AdminServer) handleTrace(w http.ResponseWriter, r *http.Request) {
+	if a.trace == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !requirePost(w, r) {
+		return
+	}
+	q := r.URL.Query()
+	id := q.Get("id")
+	if id == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+	enabled := true
+	if v := q.Get("enabled"); v != "" {
+		var err error
+		if enabled, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "invalid enabled: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := a.trace(id, enabled); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"id": id, "trace": enabled})
+}
+
+func (a *AdminServer) handleDrain(w http.ResponseWriter, r *http.Request) {
+	if a.drain == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !requirePost(w, r) {
+		return
+	}
+	ctx := r.Context()
+	if t := r.URL.Query().Get("timeout"); t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			http.Error(w, "invalid timeout: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	if err := a.drain(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"drained": true})
+}
+
+func requirePost(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// callStats returns fn's map with values JSON cannot encode (metric objects,
+// funcs) replaced by their numeric or string form.
+func callStats(fn StatsFunc) map[string]any {
+	src := fn()
+	out := make(map[string]any, len(src))
+	for k, v := range src {
+		switch m := v.(type) {
+		case *Counter:
+			out[k] = m.Value()
+		case *Histogram:
+			s := m.Snapshot()
+			out[k] = map[string]any{"count": s.Count, "sum": s.Sum, "p50": s.Quantile(.5), "p99": s.Quantile(.99)}
+		case *LatencyHistogram:
+			for ek, ev := range ExpandLatency(k, m) {
+				out[ek] = ev
+			}
+		default:
+			if _, err := json.Marshal(v); err != nil {
+				out[k] = fmt.Sprint(v)
+				continue
+			}
+			out[k] = v
+		}
+	}
+	return out
+}
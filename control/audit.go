@@ -0,0 +1,90 @@
+// File: control/audit.go
+// Package control
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Fixed-size ring of recent connection events for post-hoc inspection.
+
+package control
+
+import (
+	"sync"
+	"time"
+)
+
+// AuditKind classifies an AuditEvent.
+type AuditKind string
+
+const (
+	AuditConnect    AuditKind = "connect"
+	AuditDisconnect AuditKind = "disconnect"
+	AuditError      AuditKind = "error" // failed handshake or read error
+)
+
+// DefaultAuditSize is the ring capacity used when none is configured.
+const DefaultAuditSize = 256
+
+// AuditEvent is one connection lifecycle record. Code and Reason are set on
+// disconnects that exchanged a close frame (1006 otherwise); Duration is the
+// connection lifetime.
+type AuditEvent struct {
+	Time       time.Time     `json:"time"`
+	Kind       AuditKind     `json:"kind"`
+	ID         string        `json:"id,omitempty"`
+	RemoteAddr string        `json:"remote_addr,omitempty"`
+	Code       uint16        `json:"code,omitempty"`
+	Reason     string        `json:"reason,omitempty"`
+	Duration   time.Duration `json:"duration,omitempty"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// AuditRing keeps the most recent events, overwriting the oldest once full.
+// It is safe for concurrent use.
+type AuditRing struct {
+	mu     sync.Mutex
+	events []AuditEvent
+	next   int
+	total  uint64
+}
+
+// NewAuditRing creates a ring holding size events; size <= 0 selects
+// DefaultAuditSize.
+func NewAuditRing(size int) *AuditRing {
+	if size <= 0 {
+		size = DefaultAuditSize
+	}
+	return &AuditRing{events: make([]AuditEvent, 0, size)}
+}
+
+// Record appends e, stamping Time if unset.
+func (r *AuditRing) Record(e AuditEvent) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.total++
+	if len(r.events) < cap(r.events) {
+		r.events = append(r.events, e)
+		return
+	}
+	r.events[r.next] = e
+	r.next = (r.next + 1) % len(r.events)
+}
+
+// Events returns the retained events, oldest first.
+func (r *AuditRing) Events() []AuditEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]AuditEvent, 0, len(r.events))
+	out = append(out, r.events[r.next:]...)
+	return append(out, r.events[:r.next]...)
+}
+
+// Total returns the number of events ever recorded, including overwritten
+// ones.
+func (r *AuditRing) Total() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.total
+}
@@ -47,12 +47,14 @@ func (cs *ConfigStore) SetConfig(newCfg map[string]any) {
 // SetConfigSync merges new values and invokes all listeners synchronously (useful for tests).
 func (cs *ConfigStore) SetConfigSync(newCfg map[string]any) {
 	cs.mu.Lock()
-	defer cs.mu.Unlock()
 	for k, v := range newCfg {
 		cs.config[k] = v
 	}
-	// Synchronously invoke each listener in the same goroutine.
-	for _, fn := range cs.listeners {
+	listeners := append([]func(){}, cs.listeners...)
+	cs.mu.Unlock()
+	// Synchronously invoke each listener in the same goroutine, outside the
+	// lock so listeners may read the updated snapshot.
+	for _, fn := range listeners {
 		fn()
 	}
 }
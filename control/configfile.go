@@ -0,0 +1,388 @@
+// File: control/configfile.go
+// Package control
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Config file loading. JSON, YAML and TOML files are flattened into the
+// dotted keys used by ConfigStore and Control.SetConfig, so
+//
+//	ratelimit:
+//	  frames_per_sec: 100
+//
+// yields "ratelimit.frames_per_sec" = int64(100). Only the YAML and TOML
+// subsets needed for configuration are supported: nested mappings/tables,
+// scalars and scalar lists; anchors, multi-line strings and arrays of tables
+// are rejected.
+
+package control
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// LoadConfig reads path and returns its flattened values. The format is
+// chosen by extension: .json, .yaml/.yml or .toml.
+func LoadConfig(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var format string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		format = "json"
+	case ".yaml", ".yml":
+		format = "yaml"
+	case ".toml":
+		format = "toml"
+	default:
+		return nil, fmt.Errorf("config: unsupported file type %q", filepath.Ext(path))
+	}
+	cfg, err := ParseConfig(format, data)
+	if err != nil {
+		return nil, fmt.Errorf("config: %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// ParseConfig parses data in format ("json", "yaml" or "toml") into
+// flattened values. Integers are int64, other numbers float64; lists are
+// []any.
+func ParseConfig(format string, data []byte) (map[string]any, error) {
+	out := make(map[string]any)
+	switch format {
+	case "json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var root map[string]any
+		if err := dec.Decode(&root); err != nil {
+			return nil, err
+		}
+		flattenJSON(out, "", root)
+		return out, nil
+	case "yaml":
+		return out, parseYAML(out, data)
+	case "toml":
+		return out, parseTOML(out, data)
+	}
+	return nil, fmt.Errorf("unsupported format %q", format)
+}
+
+func flattenJSON(out map[string]any, prefix string, v any) {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, child := range t {
+			flattenJSON(out, joinKey(prefix, k), child)
+		}
+	default:
+		out[prefix] = jsonValue(v)
+	}
+}
+
+// jsonValue converts json.Number to int64/float64, recursively for lists.
+func jsonValue(v any) any {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		f, _ := t.Float64()
+		return f
+	case []any:
+		for i := range t {
+			t[i] = jsonValue(t[i])
+		}
+		return t
+	case map[string]any:
+		flat := make(map[string]any)
+		flattenJSON(flat, "", t)
+		return flat
+	}
+	return v
+}
+
+func joinKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+// yamlFrame is an open mapping: keys indented deeper than indent belong to
+// prefix.
+type yamlFrame struct {
+	indent int
+	prefix string
+}
+
+func parseYAML(out map[string]any, data []byte) error {
+	stack := []yamlFrame{{indent: -1}}
+	var listKey string // key awaiting "- item" entries
+	listIndent := -1
+	for n, raw := range strings.Split(string(data), "\n") {
+		line := strings.TrimRight(stripComment(raw), " \r")
+		text := strings.TrimLeft(line, " ")
+		if text == "" || text == "---" {
+			continue
+		}
+		if strings.HasPrefix(line, "\t") {
+			return fmt.Errorf("line %d: tab indentation", n+1)
+		}
+		indent := len(line) - len(text)
+
+		if strings.HasPrefix(text, "- ") || text == "-" {
+			if listKey == "" || indent < listIndent {
+				return fmt.Errorf("line %d: list item outside a list", n+1)
+			}
+			v, err := yamlScalar(strings.TrimSpace(strings.TrimPrefix(text, "-")))
+			if err != nil {
+				return fmt.Errorf("line %d: %w", n+1, err)
+			}
+			list, _ := out[listKey].([]any)
+			out[listKey] = append(list, v)
+			continue
+		}
+		listKey, listIndent = "", -1
+
+		for len(stack) > 1 && indent <= stack[len(stack)-1].indent {
+			stack = stack[:len(stack)-1]
+		}
+		key, rest, ok := strings.Cut(text, ":")
+		if !ok || (rest != "" && rest[0] != ' ') {
+			return fmt.Errorf("line %d: expected \"key: value\"", n+1)
+		}
+		key = unquoteKey(strings.TrimSpace(key))
+		full := joinKey(stack[len(stack)-1].prefix, key)
+		rest = strings.TrimSpace(rest)
+		if rest == "" {
+			// Either a nested mapping or a block list follows.
+			stack = append(stack, yamlFrame{indent: indent, prefix: full})
+			listKey, listIndent = full, indent
+			continue
+		}
+		if strings.HasPrefix(rest, "&") || strings.HasPrefix(rest, "*") || rest == "|" || rest == ">" {
+			return fmt.Errorf("line %d: unsupported YAML construct %q", n+1, rest)
+		}
+		v, err := yamlScalar(rest)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n+1, err)
+		}
+		out[full] = v
+	}
+	return nil
+}
+
+// yamlScalar parses a plain, quoted or flow-list value.
+func yamlScalar(s string) (any, error) {
+	switch {
+	case s == "" || s == "~" || s == "null":
+		return nil, nil
+	case strings.HasPrefix(s, "["):
+		return parseFlowList(s, yamlScalar)
+	case strings.HasPrefix(s, `"`):
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return nil, fmt.Errorf("unterminated string %s", s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case s == "true":
+		return true, nil
+	case s == "false":
+		return false, nil
+	}
+	if v, ok := parseNumber(s, "0x", "0o"); ok {
+		return v, nil
+	}
+	return s, nil
+}
+
+func parseTOML(out map[string]any, data []byte) error {
+	table := ""
+	lines := strings.Split(string(data), "\n")
+	for n := 0; n < len(lines); n++ {
+		text := strings.TrimSpace(stripComment(lines[n]))
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "[[") {
+			return fmt.Errorf("line %d: arrays of tables are not supported", n+1)
+		}
+		if strings.HasPrefix(text, "[") {
+			if !strings.HasSuffix(text, "]") {
+				return fmt.Errorf("line %d: malformed table header", n+1)
+			}
+			table = tomlKey(text[1 : len(text)-1])
+			continue
+		}
+		key, rest, ok := strings.Cut(text, "=")
+		if !ok {
+			return fmt.Errorf("line %d: expected \"key = value\"", n+1)
+		}
+		rest = strings.TrimSpace(rest)
+		// Multi-line arrays continue until the brackets balance.
+		start := n
+		for strings.HasPrefix(rest, "[") && strings.Count(rest, "[") > strings.Count(rest, "]") {
+			if n++; n >= len(lines) {
+				return fmt.Errorf("line %d: unterminated array", start+1)
+			}
+			rest += " " + strings.TrimSpace(stripComment(lines[n]))
+		}
+		v, err := tomlValue(rest)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", start+1, err)
+		}
+		out[joinKey(table, tomlKey(key))] = v
+	}
+	return nil
+}
+
+// tomlKey normalises a bare, quoted or dotted key.
+func tomlKey(k string) string {
+	parts := strings.Split(k, ".")
+	for i, p := range parts {
+		parts[i] = unquoteKey(strings.TrimSpace(p))
+	}
+	return strings.Join(parts, ".")
+}
+
+func tomlValue(s string) (any, error) {
+	switch {
+	case strings.HasPrefix(s, "["):
+		return parseFlowList(s, tomlValue)
+	case strings.HasPrefix(s, "{"):
+		return nil, fmt.Errorf("inline tables are not supported")
+	case strings.HasPrefix(s, `"""`), strings.HasPrefix(s, "'''"):
+		return nil, fmt.Errorf("multi-line strings are not supported")
+	case strings.HasPrefix(s, `"`):
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return nil, fmt.Errorf("unterminated string %s", s)
+		}
+		return s[1 : len(s)-1], nil
+	case s == "true":
+		return true, nil
+	case s == "false":
+		return false, nil
+	}
+	if v, ok := parseNumber(strings.ReplaceAll(s, "_", ""), "0x", "0o", "0b"); ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("invalid value %q", s)
+}
+
+// parseFlowList parses "[a, b, c]" with elem for each item. Items must not
+// contain commas or brackets unless quoted.
+func parseFlowList(s string, elem func(string) (any, error)) (any, error) {
+	if !strings.HasSuffix(s, "]") {
+		return nil, fmt.Errorf("unterminated list %s", s)
+	}
+	body := strings.TrimSpace(s[1 : len(s)-1])
+	list := []any{}
+	for _, item := range splitList(body) {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue // trailing comma
+		}
+		v, err := elem(item)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+// splitList splits on commas outside quotes.
+func splitList(s string) []string {
+	var parts []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// stripComment removes a trailing "#" comment outside quotes. A quote only
+// opens a string where a key or value starts, so the apostrophe of a plain
+// YAML scalar such as "it's" does not hide the comment after it.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && startsValue(line[:i]):
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// startsValue reports whether a token after before starts a key, a value or
+// a list item.
+func startsValue(before string) bool {
+	before = strings.TrimRight(before, " \t")
+	return before == "" || strings.ContainsRune(":=-[,", rune(before[len(before)-1]))
+}
+
+func unquoteKey(k string) string {
+	if len(k) >= 2 && (k[0] == '"' || k[0] == '\'') && k[len(k)-1] == k[0] {
+		return k[1 : len(k)-1]
+	}
+	return k
+}
+
+// parseNumber parses a decimal integer or float, or an integer with one of
+// the radix prefixes the format defines, e.g. "0x". A leading zero does not
+// make a number octal.
+func parseNumber(s string, prefixes ...string) (any, bool) {
+	if s == "" || !strings.ContainsRune("0123456789+-.", rune(s[0])) {
+		return nil, false
+	}
+	for _, p := range prefixes {
+		if digits, ok := strings.CutPrefix(s, p); ok {
+			i, err := strconv.ParseInt(digits, radixBase[p], 64)
+			return i, err == nil
+		}
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i, true
+	}
+	if strings.Trim(s, "0123456789+-.eE") != "" {
+		return nil, false // hex floats, Inf and NaN
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, true
+	}
+	return nil, false
+}
+
+// radixBase maps the radix prefixes of parseNumber to their base.
+var radixBase = map[string]int{"0x": 16, "0o": 8, "0b": 2}
@@ -0,0 +1,172 @@
+// File: control/configwatch.go
+// Package control
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Config file watching: re-reads the file on SIGHUP or when it changes on
+// disk and hands the changed keys to an apply callback.
+
+package control
+
+import (
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// DefaultConfigPollInterval is how often a ConfigWatcher stats its file.
+const DefaultConfigPollInterval = 2 * time.Second
+
+// ConfigWatchOption configures a ConfigWatcher.
+type ConfigWatchOption func(*ConfigWatcher)
+
+// WithConfigPollInterval sets the file change polling period; 0 disables
+// polling, leaving SIGHUP and Reload as the only triggers.
+func WithConfigPollInterval(d time.Duration) ConfigWatchOption {
+	return func(w *ConfigWatcher) {
+		w.interval = d
+	}
+}
+
+// WithConfigErrorHandler receives load and apply errors, which otherwise
+// leave the previous configuration in place silently.
+func WithConfigErrorHandler(fn func(error)) ConfigWatchOption {
+	return func(w *ConfigWatcher) {
+		w.onError = fn
+	}
+}
+
+// ConfigWatcher keeps a config file applied. Typical apply functions are
+// Control.SetConfig, which runs the registered hot-reload observers.
+type ConfigWatcher struct {
+	path     string
+	apply    func(map[string]any) error
+	interval time.Duration
+	onError  func(error)
+
+	mu      sync.Mutex
+	current map[string]any
+	modTime time.Time
+	size    int64
+
+	sig  chan os.Signal
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// WatchConfig loads path, applies every value once, and then re-applies the
+// keys whose values changed whenever the process receives SIGHUP (on
+// platforms that have it) or the file's size or modification time changes.
+// Keys removed from the file are not unset.
+func WatchConfig(path string, apply func(map[string]any) error, opts ...ConfigWatchOption) (*ConfigWatcher, error) {
+	w := &ConfigWatcher{
+		path:     path,
+		apply:    apply,
+		interval: DefaultConfigPollInterval,
+		current:  map[string]any{},
+		sig:      make(chan os.Signal, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	if err := w.Reload(); err != nil {
+		return nil, err
+	}
+	if sigs := reloadSignals(); len(sigs) > 0 {
+		signal.Notify(w.sig, sigs...)
+	}
+	go w.loop()
+	return w, nil
+}
+
+// Reload re-reads the file now and applies the changed keys.
+func (w *ConfigWatcher) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if fi, err := os.Stat(w.path); err == nil {
+		w.modTime, w.size = fi.ModTime(), fi.Size()
+	}
+	next, err := LoadConfig(w.path)
+	if err != nil {
+		return err
+	}
+	changed := make(map[string]any)
+	for k, v := range next {
+		if old, ok := w.current[k]; !ok || !reflect.DeepEqual(old, v) {
+			changed[k] = v
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	if err := w.apply(changed); err != nil {
+		return err
+	}
+	w.current = next
+	return nil
+}
+
+// Values returns the last successfully applied file contents.
+func (w *ConfigWatcher) Values() map[string]any {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	out := make(map[string]any, len(w.current))
+	for k, v := range w.current {
+		out[k] = v
+	}
+	return out
+}
+
+// Close stops watching.
+func (w *ConfigWatcher) Close() error {
+	w.once.Do(func() {
+		signal.Stop(w.sig)
+		close(w.stop)
+	})
+	<-w.done
+	return nil
+}
+
+func (w *ConfigWatcher) loop() {
+	defer close(w.done)
+	var tick <-chan time.Time
+	if w.interval > 0 {
+		t := time.NewTicker(w.interval)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-w.sig:
+			w.report(w.Reload())
+		case <-tick:
+			if w.modified() {
+				w.report(w.Reload())
+			}
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// modified reports whether the file differs from the last load.
+func (w *ConfigWatcher) modified() bool {
+	fi, err := os.Stat(w.path)
+	if err != nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return !fi.ModTime().Equal(w.modTime) || fi.Size() != w.size
+}
+
+func (w *ConfigWatcher) report(err error) {
+	if err != nil && w.onError != nil {
+		w.onError(err)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+// control/configwatch_unix.go
+// Author: momentics <momentics@gmail.com>
+//
+// SIGHUP triggers config reloads on Unix-like systems.
+
+package control
+
+import (
+	"os"
+	"syscall"
+)
+
+// reloadSignals returns the signals that trigger a ConfigWatcher reload.
+func reloadSignals() []os.Signal {
+	return []os.Signal{syscall.SIGHUP}
+}
@@ -0,0 +1,16 @@
+//go:build windows
+// +build windows
+
+// control/configwatch_windows.go
+// Author: momentics <momentics@gmail.com>
+//
+// Windows has no SIGHUP; config reloads rely on file polling.
+
+package control
+
+import "os"
+
+// reloadSignals returns the signals that trigger a ConfigWatcher reload.
+func reloadSignals() []os.Signal {
+	return nil
+}
@@ -7,7 +7,14 @@
 // Provides concurrent-safe state handling primitives including:
 //   - Immutable snapshot config reads and atomic updates
 //   - Runtime observers for hot-reload
+//   - Runtime feature toggles (compression, keepalive, strict validation, rate limits)
 //   - Metrics telemetry contracts
+//   - HDR latency histograms with p50/p95/p99/p999 export
+//   - Prometheus exposition and push sinks (StatsD/DogStatsD)
+//   - Config files (JSON/YAML/TOML) and HIOLOAD_* environment overlays
+//   - Per-subsystem structured logging with runtime log levels
+//   - Ring buffer of recent connection events for auditing
+//   - Health/readiness checks for liveness and readiness probes
 //   - State export, debug hooks, and probe registration
 //
 // This package is cross-platform and build-tag-partitioned as needed.
@@ -0,0 +1,47 @@
+// File: control/env.go
+// Package control
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Environment variable config overlay.
+
+package control
+
+import (
+	"os"
+	"strings"
+)
+
+// EnvPrefix is the conventional prefix of hioload-ws environment variables.
+const EnvPrefix = "HIOLOAD_"
+
+// LoadEnv returns the config keys set through environment variables starting
+// with prefix (EnvPrefix when empty). The rest of the name is lower-cased and
+// a double underscore separates nesting levels, so
+//
+//	HIOLOAD_LISTEN_ADDR=:9001                 -> "listen_addr": ":9001"
+//	HIOLOAD_RATELIMIT__FRAMES_PER_SEC=50      -> "ratelimit.frames_per_sec": int64(50)
+//	HIOLOAD_SUBPROTOCOLS=[mqtt, graphql-ws]   -> "subprotocols": []any{...}
+//
+// Values are typed like YAML scalars: integers, floats, true/false, flow
+// lists and otherwise strings. The result has the same shape as LoadConfig's,
+// so it can be applied on top of a config file.
+func LoadEnv(prefix string) map[string]any {
+	if prefix == "" {
+		prefix = EnvPrefix
+	}
+	out := make(map[string]any)
+	for _, kv := range os.Environ() {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || len(name) <= len(prefix) || !strings.EqualFold(name[:len(prefix)], prefix) {
+			continue
+		}
+		key := strings.ToLower(strings.ReplaceAll(name[len(prefix):], "__", "."))
+		v, err := yamlScalar(strings.TrimSpace(value))
+		if err != nil {
+			v = value // malformed quoting: keep the raw string
+		}
+		out[key] = v
+	}
+	return out
+}
@@ -0,0 +1,70 @@
+// File: control/features.go
+// Package control
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Runtime feature toggles carried in the control config.
+
+package control
+
+import (
+	"strconv"
+	"time"
+)
+
+// Feature toggle keys for Control.SetConfig. Servers observe them on reload:
+// compression, keepalive and strict validation apply to connections accepted
+// afterwards, the rate-limit switch takes effect immediately.
+const (
+	CfgFeatureCompression = "feature.compression"
+	CfgFeatureKeepAlive   = "feature.keepalive"
+	CfgFeatureStrict      = "feature.strict_validation"
+	CfgFeatureRateLimit   = "feature.ratelimit"
+)
+
+// Features is a snapshot of the toggles.
+type Features struct {
+	Compression      bool          // negotiate permessage-deflate when offered
+	KeepAlive        time.Duration // server ping interval (0 = off)
+	StrictValidation bool          // strict RFC 6455 frame validation
+	RateLimit        bool          // enforce the configured rate limits
+}
+
+// WithConfig returns f overlaid with the feature keys present in cfg.
+// Switches accept booleans or strconv.ParseBool strings; the keepalive
+// accepts a Go duration string, a number of seconds or false. Invalid values
+// leave the setting unchanged.
+func (f Features) WithConfig(cfg map[string]any) Features {
+	toggle(&f.Compression, cfg[CfgFeatureCompression])
+	toggle(&f.StrictValidation, cfg[CfgFeatureStrict])
+	toggle(&f.RateLimit, cfg[CfgFeatureRateLimit])
+	switch v := cfg[CfgFeatureKeepAlive].(type) {
+	case nil:
+	case bool:
+		if !v {
+			f.KeepAlive = 0
+		}
+	case string:
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			f.KeepAlive = d
+		}
+	case time.Duration:
+		f.KeepAlive = v
+	default:
+		if n, ok := numericValue(v); ok && n >= 0 {
+			f.KeepAlive = time.Duration(n * float64(time.Second))
+		}
+	}
+	return f
+}
+
+func toggle(dst *bool, v any) {
+	switch t := v.(type) {
+	case bool:
+		*dst = t
+	case string:
+		if b, err := strconv.ParseBool(t); err == nil {
+			*dst = b
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// File: control/health.go
+// Package control
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Health and readiness checks aggregated for liveness/readiness probes.
+
+package control
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HealthCheck reports a component's state; a nil error means healthy. It
+// should return promptly once ctx is done.
+type HealthCheck func(ctx context.Context) error
+
+// HealthKind selects which probes a check participates in.
+type HealthKind uint8
+
+const (
+	// HealthLive checks fail when the process must be restarted.
+	HealthLive HealthKind = 1 << iota
+	// HealthReady checks fail while the process should not get traffic.
+	HealthReady
+)
+
+// DefaultHealthTimeout bounds a probe whose context has no deadline.
+const DefaultHealthTimeout = 2 * time.Second
+
+// HealthResult is the outcome of a probe: Status is "ok" or "fail", Checks
+// maps each check name to "ok" or its error.
+type HealthResult struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// OK reports whether every check passed.
+func (r HealthResult) OK() bool {
+	return r.Status == "ok"
+}
+
+type healthEntry struct {
+	kind HealthKind
+	fn   HealthCheck
+}
+
+// HealthRegistry aggregates named component checks. It is safe for
+// concurrent use.
+type HealthRegistry struct {
+	mu     sync.RWMutex
+	checks map[string]healthEntry
+}
+
+// NewHealthRegistry creates an empty registry; with no checks both probes
+// pass.
+func NewHealthRegistry() *HealthRegistry {
+	return &HealthRegistry{checks: make(map[string]healthEntry)}
+}
+
+var defaultHealth = NewHealthRegistry()
+
+// Health returns the process-wide registry for components without a
+// registry of their own.
+func Health() *HealthRegistry {
+	return defaultHealth
+}
+
+// Register adds or replaces the check name for the probes in kind, e.g.
+// HealthLive|HealthReady.
+func (h *HealthRegistry) Register(name string, kind HealthKind, fn HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks[name] = healthEntry{kind: kind, fn: fn}
+}
+
+// Unregister removes the check name.
+func (h *HealthRegistry) Unregister(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.checks, name)
+}
+
+// Live runs the liveness checks.
+func (h *HealthRegistry) Live(ctx context.Context) HealthResult {
+	return h.run(ctx, HealthLive)
+}
+
+// Ready runs the readiness checks.
+func (h *HealthRegistry) Ready(ctx context.Context) HealthResult {
+	return h.run(ctx, HealthReady)
+}
+
+// LiveHandler serves Live as JSON with status 200, or 503 on failure.
+func (h *HealthRegistry) LiveHandler() http.Handler {
+	return h.handler(HealthLive)
+}
+
+// ReadyHandler serves Ready as JSON with status 200, or 503 on failure.
+func (h *HealthRegistry) ReadyHandler() http.Handler {
+	return h.handler(HealthReady)
+}
+
+func (h *HealthRegistry) handler(kind HealthKind) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := h.run(r.Context(), kind)
+		status := http.StatusOK
+		if !res.OK() {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, res)
+	})
+}
+
+// run executes the checks of kind concurrently.
+func (h *HealthRegistry) run(ctx context.Context, kind HealthKind) HealthResult {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultHealthTimeout)
+		defer cancel()
+	}
+	h.mu.RLock()
+	names := make([]string, 0, len(h.checks))
+	for name, e := range h.checks {
+		if e.kind&kind != 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	fns := make([]HealthCheck, len(names))
+	for i, name := range names {
+		fns[i] = h.checks[name].fn
+	}
+	h.mu.RUnlock()
+
+	errs := make([]error, len(fns))
+	var wg sync.WaitGroup
+	for i, fn := range fns {
+		wg.Add(1)
+		go func(i int, fn HealthCheck) {
+			defer wg.Done()
+			errs[i] = fn(ctx)
+		}(i, fn)
+	}
+	wg.Wait()
+
+	res := HealthResult{Status: "ok", Checks: make(map[string]string, len(names))}
+	for i, name := range names {
+		if errs[i] != nil {
+			res.Status = "fail"
+			res.Checks[name] = errs[i].Error()
+		} else {
+			res.Checks[name] = "ok"
+		}
+	}
+	return res
+}
@@ -0,0 +1,188 @@
+// File: control/latency.go
+// Package control
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// HDR-style latency histogram: log-linear buckets give a bounded relative
+// error (under 1%) across nanoseconds to an hour with lock-free recording.
+
+package control
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// latencySubBits sets 128 linear sub-buckets per power of two.
+	latencySubBits  = 7
+	latencySubCount = 1 << latencySubBits
+	// latencyMaxBits bounds trackable values to 2^42ns (~73 min); larger
+	// values are clamped.
+	latencyMaxBits = 42
+	latencyBuckets = (latencyMaxBits - latencySubBits + 1) * latencySubCount
+)
+
+// LatencyQuantiles are the percentiles reported for a LatencyHistogram by
+// Stats and the Prometheus exporter.
+var LatencyQuantiles = []struct {
+	Suffix string
+	Q      float64
+}{{"p50", 0.5}, {"p95", 0.95}, {"p99", 0.99}, {"p999", 0.999}}
+
+// LatencyHistogram records durations with high dynamic range. Recording is
+// wait-free and safe for concurrent use. Stored in a MetricsRegistry or
+// returned by a debug probe, it is expanded by Control.Stats into
+// "<key>.count" and "<key>.p50".."<key>.p999" and exported to Prometheus as a
+// summary.
+type LatencyHistogram struct {
+	counts [latencyBuckets]atomic.Uint64
+	count  atomic.Uint64
+	sum    atomic.Int64
+	min    atomic.Int64
+	max    atomic.Int64
+}
+
+// NewLatencyHistogram creates an empty histogram.
+func NewLatencyHistogram() *LatencyHistogram {
+	h := &LatencyHistogram{}
+	h.min.Store(math.MaxInt64)
+	return h
+}
+
+// Record adds one duration; negative values count as zero.
+func (h *LatencyHistogram) Record(d time.Duration) {
+	v := int64(d)
+	if v < 0 {
+		v = 0
+	}
+	h.counts[latencyIndex(v)].Add(1)
+	h.count.Add(1)
+	h.sum.Add(v)
+	for cur := h.min.Load(); v < cur && !h.min.CompareAndSwap(cur, v); cur = h.min.Load() {
+	}
+	for cur := h.max.Load(); v > cur && !h.max.CompareAndSwap(cur, v); cur = h.max.Load() {
+	}
+}
+
+// Since records the time elapsed since start.
+func (h *LatencyHistogram) Since(start time.Time) {
+	h.Record(time.Since(start))
+}
+
+// Count returns the number of recorded values.
+func (h *LatencyHistogram) Count() uint64 {
+	return h.count.Load()
+}
+
+// Sum returns the total of recorded values.
+func (h *LatencyHistogram) Sum() time.Duration {
+	return time.Duration(h.sum.Load())
+}
+
+// Quantile returns the value at quantile q (0..1): the highest value
+// equivalent to the recorded value at that rank, capped at the maximum.
+func (h *LatencyHistogram) Quantile(q float64) time.Duration {
+	total := h.count.Load()
+	if total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(total)))
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for i := range h.counts {
+		if seen += h.counts[i].Load(); seen >= rank {
+			v := latencyUpper(i)
+			if max := h.max.Load(); v > max {
+				v = max
+			}
+			return time.Duration(v)
+		}
+	}
+	return time.Duration(h.max.Load())
+}
+
+// LatencySnapshot summarises a LatencyHistogram.
+type LatencySnapshot struct {
+	Count               uint64
+	Sum, Min, Max, Mean time.Duration
+	P50, P95, P99, P999 time.Duration
+}
+
+// Snapshot returns the current summary.
+func (h *LatencyHistogram) Snapshot() LatencySnapshot {
+	s := LatencySnapshot{Count: h.count.Load(), Sum: time.Duration(h.sum.Load())}
+	if s.Count == 0 {
+		return s
+	}
+	s.Min, s.Max = time.Duration(h.min.Load()), time.Duration(h.max.Load())
+	s.Mean = s.Sum / time.Duration(s.Count)
+	s.P50, s.P95, s.P99, s.P999 = h.Quantile(.5), h.Quantile(.95), h.Quantile(.99), h.Quantile(.999)
+	return s
+}
+
+// Reset clears all recorded values. Values recorded concurrently may be
+// partially kept.
+func (h *LatencyHistogram) Reset() {
+	for i := range h.counts {
+		h.counts[i].Store(0)
+	}
+	h.count.Store(0)
+	h.sum.Store(0)
+	h.min.Store(math.MaxInt64)
+	h.max.Store(0)
+}
+
+// Merge adds the values recorded in o to h, e.g. to report the histograms
+// of several reactors as one.
+func (h *LatencyHistogram) Merge(o *LatencyHistogram) {
+	for i := range o.counts {
+		if n := o.counts[i].Load(); n > 0 {
+			h.counts[i].Add(n)
+		}
+	}
+	h.count.Add(o.count.Load())
+	h.sum.Add(o.sum.Load())
+	for v, cur := o.min.Load(), h.min.Load(); v < cur && !h.min.CompareAndSwap(cur, v); cur = h.min.Load() {
+	}
+	for v, cur := o.max.Load(), h.max.Load(); v > cur && !h.max.CompareAndSwap(cur, v); cur = h.max.Load() {
+	}
+}
+
+// ExpandLatency returns the flat Stats entries of h under key.
+func ExpandLatency(key string, h *LatencyHistogram) map[string]any {
+	out := map[string]any{key + ".count": h.Count()}
+	for _, q := range LatencyQuantiles {
+		out[key+"."+q.Suffix] = h.Quantile(q.Q)
+	}
+	return out
+}
+
+// latencyIndex maps v >= 0 to its bucket: values below latencySubCount map
+// linearly, larger ones keep their top latencySubBits+1 significant bits.
+func latencyIndex(v int64) int {
+	if v < latencySubCount {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - 1 - latencySubBits
+	if shift > latencyMaxBits-1-latencySubBits {
+		return latencyBuckets - 1
+	}
+	top := int(v >> shift) // in [latencySubCount, 2*latencySubCount)
+	return (shift+1)*latencySubCount + top - latencySubCount
+}
+
+// latencyUpper returns the highest value mapping to bucket i.
+func latencyUpper(i int) int64 {
+	group := i >> latencySubBits
+	if group == 0 {
+		return int64(i)
+	}
+	shift := group - 1
+	top := int64(i&(latencySubCount-1) + latencySubCount)
+	return (top+1)<<shift - 1
+}
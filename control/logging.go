@@ -0,0 +1,169 @@
+// File: control/logging.go
+// Package control
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Per-subsystem structured logging with runtime-adjustable levels.
+
+package control
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/momentics/hioload-ws/api"
+)
+
+// Subsystems logging through Logger.
+const (
+	LogTransport  = "transport"
+	LogProtocol   = "protocol"
+	LogServer     = "server"
+	LogClient     = "client"
+	LogMiddleware = "middleware"
+	LogNormalize  = "normalize"
+	// LogTrace receives per-connection traces (see WSConnection.SetTrace).
+	// They are logged at Info so tracing one connection needs no global
+	// debug level.
+	LogTrace = "trace"
+)
+
+// Config keys applied by ApplyLogConfig: CfgLogLevel sets the default level,
+// CfgLogLevel+"."+subsystem overrides one subsystem. Values are slog level
+// names ("debug", "info", "warn", "error", optionally with an offset such as
+// "info+2").
+const CfgLogLevel = "log.level"
+
+// logSink is the destination shared by every subsystem logger. It must not
+// filter by level itself; levels are applied per subsystem.
+var logSink atomic.Pointer[api.Logger]
+
+var (
+	defaultLevel = new(slog.LevelVar) // zero value is slog.LevelInfo
+
+	loggersMu sync.Mutex
+	loggers   = map[string]*subsystemLogger{}
+)
+
+func init() {
+	SetLogger(nil)
+}
+
+// SetLogger replaces the log destination for all subsystems; nil restores
+// the default, a text slog handler on stderr. Records carry a "subsystem"
+// attribute.
+func SetLogger(l api.Logger) {
+	if l == nil {
+		l = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	}
+	logSink.Store(&l)
+}
+
+// Logger returns the logger of subsystem. Loggers are cached and stay valid
+// across SetLogger and level changes.
+func Logger(subsystem string) api.Logger {
+	loggersMu.Lock()
+	defer loggersMu.Unlock()
+	l, ok := loggers[subsystem]
+	if !ok {
+		l = &subsystemLogger{name: subsystem}
+		loggers[subsystem] = l
+	}
+	return l
+}
+
+// SetLogLevel sets the level of subsystem, or the default level of every
+// subsystem without an override when subsystem is "".
+func SetLogLevel(subsystem string, level slog.Level) {
+	if subsystem == "" {
+		defaultLevel.Set(level)
+		return
+	}
+	Logger(subsystem).(*subsystemLogger).setLevel(&level)
+}
+
+// ResetLogLevel drops the override of subsystem so it follows the default.
+func ResetLogLevel(subsystem string) {
+	Logger(subsystem).(*subsystemLogger).setLevel(nil)
+}
+
+// LogLevel returns the effective level of subsystem.
+func LogLevel(subsystem string) slog.Level {
+	if subsystem == "" {
+		return defaultLevel.Level()
+	}
+	return Logger(subsystem).(*subsystemLogger).level()
+}
+
+// ApplyLogConfig applies the log.level keys found in cfg and ignores the
+// rest, so it can be fed every config update.
+func ApplyLogConfig(cfg map[string]any) error {
+	for k, v := range cfg {
+		if k != CfgLogLevel && !strings.HasPrefix(k, CfgLogLevel+".") {
+			continue
+		}
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("config: %s: expected level name, got %T", k, v)
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(s)); err != nil {
+			return fmt.Errorf("config: %s: %w", k, err)
+		}
+		SetLogLevel(strings.TrimPrefix(strings.TrimPrefix(k, CfgLogLevel), "."), level)
+	}
+	return nil
+}
+
+// subsystemLogger gates records by its level and forwards them to logSink.
+type subsystemLogger struct {
+	name     string
+	override atomic.Pointer[slog.Level]
+}
+
+func (l *subsystemLogger) setLevel(level *slog.Level) {
+	l.override.Store(level)
+}
+
+func (l *subsystemLogger) level() slog.Level {
+	if p := l.override.Load(); p != nil {
+		return *p
+	}
+	return defaultLevel.Level()
+}
+
+// Debug implements api.Logger.
+func (l *subsystemLogger) Debug(msg string, args ...any) {
+	if l.level() <= slog.LevelDebug {
+		(*logSink.Load()).Debug(msg, l.with(args)...)
+	}
+}
+
+// Info implements api.Logger.
+func (l *subsystemLogger) Info(msg string, args ...any) {
+	if l.level() <= slog.LevelInfo {
+		(*logSink.Load()).Info(msg, l.with(args)...)
+	}
+}
+
+// Warn implements api.Logger.
+func (l *subsystemLogger) Warn(msg string, args ...any) {
+	if l.level() <= slog.LevelWarn {
+		(*logSink.Load()).Warn(msg, l.with(args)...)
+	}
+}
+
+// Error implements api.Logger.
+func (l *subsystemLogger) Error(msg string, args ...any) {
+	if l.level() <= slog.LevelError {
+		(*logSink.Load()).Error(msg, l.with(args)...)
+	}
+}
+
+func (l *subsystemLogger) with(args []any) []any {
+	return append([]any{"subsystem", l.name}, args...)
+}
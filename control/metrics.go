@@ -1,45 +1,170 @@
-// control/metrics.go
-// Author: momentics <momentics@gmail.com>
-//
-// Runtime metrics collector for system-level monitoring.
-// Exposes counters in a thread-safe map with dynamic registration.
-
-package control
-
-import (
-	"sync"
-	"time"
-)
-
-// MetricsRegistry holds mutable and read-only metrics.
-type MetricsRegistry struct {
-	mu      sync.RWMutex
-	metrics map[string]any
-	updated time.Time
-}
-
-// NewMetricsRegistry creates an empty registry.
-func NewMetricsRegistry() *MetricsRegistry {
-	return &MetricsRegistry{
-		metrics: make(map[string]any),
-	}
-}
-
-// Set sets or updates a metric key.
-func (mr *MetricsRegistry) Set(key string, value any) {
-	mr.mu.Lock()
-	mr.metrics[key] = value
-	mr.updated = time.Now()
-	mr.mu.Unlock()
-}
-
-// GetSnapshot returns the latest metrics.
-func (mr *MetricsRegistry) GetSnapshot() map[string]any {
-	mr.mu.RLock()
-	defer mr.mu.RUnlock()
-	out := make(map[string]any, len(mr.metrics))
-	for k, v := range mr.metrics {
-		out[k] = v
-	}
-	return out
-}
+// control/metrics.go
+// Author: momentics <momentics@gmail.com>
+//
+// Runtime metrics collector for system-level monitoring.
+// Exposes counters in a thread-safe map with dynamic registration.
+
+package control
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MetricsRegistry holds mutable and read-only metrics.
+type MetricsRegistry struct {
+	mu      sync.RWMutex
+	metrics map[string]any
+	updated time.Time
+}
+
+// NewMetricsRegistry creates an empty registry.
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{
+		metrics: make(map[string]any),
+	}
+}
+
+// Set sets or updates a metric key.
+func (mr *MetricsRegistry) Set(key string, value any) {
+	mr.mu.Lock()
+	mr.metrics[key] = value
+	mr.updated = time.Now()
+	mr.mu.Unlock()
+}
+
+// GetSnapshot returns the latest metrics.
+func (mr *MetricsRegistry) GetSnapshot() map[string]any {
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+	out := make(map[string]any, len(mr.metrics))
+	for k, v := range mr.metrics {
+		out[k] = v
+	}
+	return out
+}
+
+// Counter is a monotonically increasing metric. Stored in a MetricsRegistry or
+// returned by a debug probe, it is exported as a counter rather than a gauge.
+type Counter struct {
+	v atomic.Uint64
+}
+
+// Inc adds one to the counter.
+func (c *Counter) Inc() { c.v.Add(1) }
+
+// Add adds n to the counter.
+func (c *Counter) Add(n uint64) { c.v.Add(n) }
+
+// Value returns the current count.
+func (c *Counter) Value() uint64 { return c.v.Load() }
+
+// DefaultBuckets are histogram upper bounds in seconds, suited to request and
+// message latencies.
+var DefaultBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// HistogramSnapshot is a point-in-time copy of a Histogram. Counts are
+// cumulative: Counts[i] observations were <= Bounds[i].
+type HistogramSnapshot struct {
+	Bounds []float64
+	Counts []uint64
+	Count  uint64
+	Sum    float64
+}
+
+// NewHistogram creates a histogram with the given ascending upper bounds, or
+// DefaultBuckets when none are given.
+func NewHistogram(bounds ...float64) *Histogram {
+	if len(bounds) == 0 {
+		bounds = DefaultBuckets
+	}
+	b := append([]float64(nil), bounds...)
+	sort.Float64s(b)
+	return &Histogram{bounds: b, counts: make([]uint64, len(b))}
+}
+
+// Observe records one value.
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.mu.Lock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+// ObserveDuration records d in seconds.
+func (h *Histogram) ObserveDuration(d time.Duration) {
+	h.Observe(d.Seconds())
+}
+
+// Snapshot returns the current bucket counts.
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := HistogramSnapshot{
+		Bounds: h.bounds,
+		Counts: make([]uint64, len(h.counts)),
+		Count:  h.count,
+		Sum:    h.sum,
+	}
+	var acc uint64
+	for i, n := range h.counts {
+		acc += n
+		s.Counts[i] = acc
+	}
+	return s
+}
+
+// Sub returns the observations recorded since prev, a snapshot of the same
+// histogram taken earlier.
+func (s HistogramSnapshot) Sub(prev HistogramSnapshot) HistogramSnapshot {
+	if len(prev.Counts) != len(s.Counts) {
+		return s
+	}
+	d := HistogramSnapshot{
+		Bounds: s.Bounds,
+		Counts: make([]uint64, len(s.Counts)),
+		Count:  s.Count - prev.Count,
+		Sum:    s.Sum - prev.Sum,
+	}
+	for i := range s.Counts {
+		d.Counts[i] = s.Counts[i] - prev.Counts[i]
+	}
+	return d
+}
+
+// Quantile estimates the q-quantile (0 <= q <= 1) by linear interpolation
+// within the bucket holding it. Observations above the last bound are
+// reported as that bound; an empty snapshot yields 0.
+func (s HistogramSnapshot) Quantile(q float64) float64 {
+	if s.Count == 0 || len(s.Bounds) == 0 {
+		return 0
+	}
+	rank := q * float64(s.Count)
+	lower, below := 0.0, uint64(0)
+	for i, upper := range s.Bounds {
+		if float64(s.Counts[i]) >= rank {
+			in := s.Counts[i] - below
+			if in == 0 {
+				return upper
+			}
+			return lower + (upper-lower)*(rank-float64(below))/float64(in)
+		}
+		lower, below = upper, s.Counts[i]
+	}
+	return s.Bounds[len(s.Bounds)-1]
+}
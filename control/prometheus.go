@@ -0,0 +1,208 @@
+// File: control/prometheus.go
+// Package control
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Prometheus text exposition of registered metrics and debug probes.
+
+package control
+
+import (
+	"bufio"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PrometheusNamespace prefixes every exported metric name.
+const PrometheusNamespace = "hioload"
+
+// prometheusContentType is the text exposition format version 0.0.4.
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// StatsFunc returns a flat map of metric values, e.g. api.Control.Stats,
+// MetricsRegistry.GetSnapshot or DebugProbes.DumpState.
+type StatsFunc func() map[string]any
+
+// PrometheusHandler serves the merged output of sources in the Prometheus
+// text format, typically mounted at /metrics. Keys are sanitised and
+// prefixed with PrometheusNamespace; *Counter values are exported as
+// counters, *Histogram values as histograms, *LatencyHistogram values as
+// summaries in seconds, and other numeric or boolean values (durations in
+// seconds) as gauges. Non-numeric values such as strings are skipped. A key
+// may end in a label set, as in `route.messages_in{route="/chat"}`; keys
+// differing only in labels are series of one family. When sources repeat a
+// key, the first one wins.
+func PrometheusHandler(sources ...StatsFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		type series struct {
+			name, labels string
+			v            any
+		}
+		var all []series
+		seen := make(map[string]bool)
+		for _, src := range sources {
+			for k, v := range src() {
+				switch v.(type) {
+				case *Counter, *Histogram, *LatencyHistogram:
+				default:
+					if _, ok := numericValue(v); !ok {
+						continue
+					}
+				}
+				key, labels := splitLabels(k)
+				name := prometheusName(key)
+				if id := name + "{" + labels + "}"; !seen[id] {
+					seen[id] = true
+					all = append(all, series{name, labels, v})
+				}
+			}
+		}
+		sort.Slice(all, func(i, j int) bool {
+			if all[i].name != all[j].name {
+				return all[i].name < all[j].name
+			}
+			return all[i].labels < all[j].labels
+		})
+
+		w.Header().Set("Content-Type", prometheusContentType)
+		bw := bufio.NewWriter(w)
+		for i, s := range all {
+			first := i == 0 || all[i-1].name != s.name
+			writePrometheusMetric(bw, s.name, s.labels, s.v, first)
+		}
+		bw.Flush()
+	})
+}
+
+// splitLabels splits a trailing `{...}` label set off key.
+func splitLabels(key string) (string, string) {
+	if i := strings.IndexByte(key, '{'); i > 0 && strings.HasSuffix(key, "}") {
+		return key[:i], key[i+1 : len(key)-1]
+	}
+	return key, ""
+}
+
+// writePrometheusMetric writes the samples of v, preceded by the family's
+// TYPE line for its first series.
+func writePrometheusMetric(w *bufio.Writer, name, labels string, v any, first bool) {
+	family := func(typ string) {
+		if first {
+			writeFamily(w, name, typ)
+		}
+	}
+	switch m := v.(type) {
+	case *Counter:
+		if !strings.HasSuffix(name, "_total") {
+			name += "_total"
+		}
+		family("counter")
+		writeSample(w, name, labels, float64(m.Value()))
+	case *Histogram:
+		s := m.Snapshot()
+		family("histogram")
+		for i, le := range s.Bounds {
+			writeSample(w, name+"_bucket", withLabel(labels, "le", formatFloat(le)), float64(s.Counts[i]))
+		}
+		writeSample(w, name+"_bucket", withLabel(labels, "le", "+Inf"), float64(s.Count))
+		writeSample(w, name+"_sum", labels, s.Sum)
+		writeSample(w, name+"_count", labels, float64(s.Count))
+	case *LatencyHistogram:
+		family("summary")
+		for _, q := range LatencyQuantiles {
+			writeSample(w, name, withLabel(labels, "quantile", formatFloat(q.Q)), m.Quantile(q.Q).Seconds())
+		}
+		writeSample(w, name+"_sum", labels, m.Sum().Seconds())
+		writeSample(w, name+"_count", labels, float64(m.Count()))
+	default:
+		f, _ := numericValue(v)
+		family("gauge")
+		writeSample(w, name, labels, f)
+	}
+}
+
+func writeFamily(w *bufio.Writer, name, typ string) {
+	w.WriteString("# TYPE ")
+	w.WriteString(name)
+	w.WriteByte(' ')
+	w.WriteString(typ)
+	w.WriteByte('\n')
+}
+
+// withLabel appends the label name="value" to the label set labels.
+func withLabel(labels, name, value string) string {
+	l := name + `="` + value + `"`
+	if labels == "" {
+		return l
+	}
+	return labels + "," + l
+}
+
+// writeSample writes one sample line with the label set labels.
+func writeSample(w *bufio.Writer, name, labels string, v float64) {
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteByte('{')
+		w.WriteString(labels)
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// numericValue converts the value kinds probes commonly return. Durations
+// are exported in seconds.
+func numericValue(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	case time.Duration:
+		return n.Seconds(), true
+	case bool:
+		if n {
+			return 1, true
+		}
+		return 0, true
+	case interface{ Value() uint64 }:
+		return float64(n.Value()), true
+	}
+	return 0, false
+}
+
+// prometheusName maps a dotted stats key to a valid metric name.
+func prometheusName(key string) string {
+	var b strings.Builder
+	b.Grow(len(PrometheusNamespace) + 1 + len(key))
+	b.WriteString(PrometheusNamespace)
+	b.WriteByte('_')
+	for _, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == ':':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,163 @@
+// File: control/sink.go
+// Package control
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Push-based metrics export: a pluggable sink fed by a periodic pusher.
+
+package control
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MetricsSink receives metrics pushed by a MetricsPusher. Implementations
+// may buffer samples until Flush.
+type MetricsSink interface {
+	// Gauge reports the current value of name.
+	Gauge(name string, value float64)
+	// Count reports that name increased by delta since the last flush.
+	Count(name string, delta int64)
+	// Flush sends buffered samples.
+	Flush() error
+	// Close flushes and releases the sink.
+	Close() error
+}
+
+// DefaultFlushInterval is the push period used when none is given.
+const DefaultFlushInterval = 10 * time.Second
+
+// SinkQuantiles are the percentiles pushed for every histogram, as gauges
+// named <metric>.p50, <metric>.p90 and so on.
+var SinkQuantiles = []struct {
+	Suffix string
+	Q      float64
+}{{"p50", 0.5}, {"p90", 0.9}, {"p95", 0.95}, {"p99", 0.99}}
+
+// MetricsPusher samples stats sources at a fixed interval and pushes them to
+// a sink:
+//   - numeric and boolean values become gauges (connection counts, sizes);
+//   - *Counter values become counts of the increase since the last push,
+//     plus a <metric>.rate gauge in units per second (RPS, bytes/s);
+//   - *Histogram values become a <metric>.count count and percentile gauges
+//     over the observations made since the last push;
+//   - *LatencyHistogram values become a <metric>.count count and gauges of
+//     their p50..p999 in seconds since the histogram was created or reset.
+type MetricsPusher struct {
+	sink     MetricsSink
+	interval time.Duration
+	sources  []StatsFunc
+
+	mu       sync.Mutex
+	last     time.Time
+	counters map[string]uint64
+	hists    map[string]HistogramSnapshot
+
+	started atomic.Bool
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewMetricsPusher creates a pusher; interval <= 0 selects
+// DefaultFlushInterval. Call Start to begin pushing.
+func NewMetricsPusher(sink MetricsSink, interval time.Duration, sources ...StatsFunc) *MetricsPusher {
+	if interval <= 0 {
+		interval = DefaultFlushInterval
+	}
+	return &MetricsPusher{
+		sink:     sink,
+		interval: interval,
+		sources:  sources,
+		last:     time.Now(),
+		counters: make(map[string]uint64),
+		hists:    make(map[string]HistogramSnapshot),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start launches the push loop.
+func (p *MetricsPusher) Start() {
+	if p.started.CompareAndSwap(false, true) {
+		go p.loop()
+	}
+}
+
+// Stop ends the push loop after a final push and closes the sink.
+func (p *MetricsPusher) Stop() error {
+	p.once.Do(func() { close(p.stop) })
+	if p.started.Load() {
+		<-p.done
+	} else {
+		p.Push()
+	}
+	return p.sink.Close()
+}
+
+func (p *MetricsPusher) loop() {
+	defer close(p.done)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.Push()
+		case <-p.stop:
+			p.Push()
+			return
+		}
+	}
+}
+
+// Push samples every source once and flushes the sink.
+func (p *MetricsPusher) Push() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	elapsed := now.Sub(p.last).Seconds()
+	p.last = now
+
+	for _, src := range p.sources {
+		for name, v := range src() {
+			switch m := v.(type) {
+			case *Counter:
+				cur := m.Value()
+				delta := cur - p.counters[name]
+				p.counters[name] = cur
+				p.sink.Count(name, int64(delta))
+				if elapsed > 0 {
+					p.sink.Gauge(name+".rate", float64(delta)/elapsed)
+				}
+			case *Histogram:
+				cur := m.Snapshot()
+				d := cur
+				if prev, ok := p.hists[name]; ok {
+					d = cur.Sub(prev)
+				}
+				p.hists[name] = cur
+				p.sink.Count(name+".count", int64(d.Count))
+				if d.Count == 0 {
+					continue
+				}
+				for _, q := range SinkQuantiles {
+					p.sink.Gauge(name+"."+q.Suffix, d.Quantile(q.Q))
+				}
+			case *LatencyHistogram:
+				cur := m.Count()
+				p.sink.Count(name+".count", int64(cur-p.counters[name]))
+				p.counters[name] = cur
+				for _, q := range LatencyQuantiles {
+					p.sink.Gauge(name+"."+q.Suffix, m.Quantile(q.Q).Seconds())
+				}
+			default:
+				if f, ok := numericValue(v); ok {
+					p.sink.Gauge(name, f)
+				}
+			}
+		}
+	}
+	return p.sink.Flush()
+}
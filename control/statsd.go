@@ -0,0 +1,151 @@
+// File: control/statsd.go
+// Package control
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// StatsD / DogStatsD metrics sink over UDP.
+
+package control
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultStatsDPacketSize keeps datagrams below a typical Ethernet MTU.
+const DefaultStatsDPacketSize = 1432
+
+// StatsDOption configures a StatsDSink.
+type StatsDOption func(*StatsDSink)
+
+// WithStatsDPrefix prepends prefix and a dot to every metric name.
+func WithStatsDPrefix(prefix string) StatsDOption {
+	return func(s *StatsDSink) {
+		s.prefix = strings.TrimSuffix(prefix, ".") + "."
+	}
+}
+
+// WithDogStatsDTags appends DogStatsD tags ("key:value" or bare "key") to
+// every sample. Plain StatsD servers do not understand tags.
+func WithDogStatsDTags(tags ...string) StatsDOption {
+	return func(s *StatsDSink) {
+		if len(tags) > 0 {
+			s.tags = "|#" + strings.Join(tags, ",")
+		}
+	}
+}
+
+// WithStatsDPacketSize bounds each datagram to n bytes.
+func WithStatsDPacketSize(n int) StatsDOption {
+	return func(s *StatsDSink) {
+		if n > 0 {
+			s.maxPacket = n
+		}
+	}
+}
+
+// StatsDSink is a MetricsSink writing the StatsD line protocol over UDP.
+// Samples are buffered and packed into datagrams, newline-separated, on
+// Flush or when a datagram fills up.
+type StatsDSink struct {
+	conn      net.Conn
+	prefix    string
+	tags      string
+	maxPacket int
+
+	mu  sync.Mutex
+	buf []byte
+	err error
+}
+
+// NewStatsDSink creates a sink sending to the StatsD agent at addr
+// (host:port, usually port 8125).
+func NewStatsDSink(addr string, opts ...StatsDOption) (*StatsDSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &StatsDSink{conn: conn, maxPacket: DefaultStatsDPacketSize}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.buf = make([]byte, 0, s.maxPacket)
+	return s, nil
+}
+
+// Gauge implements MetricsSink.
+func (s *StatsDSink) Gauge(name string, value float64) {
+	s.add(name, strconv.FormatFloat(value, 'f', -1, 64), "g")
+}
+
+// Count implements MetricsSink.
+func (s *StatsDSink) Count(name string, delta int64) {
+	s.add(name, strconv.FormatInt(delta, 10), "c")
+}
+
+// Flush implements MetricsSink. It returns the first write error seen since
+// the previous Flush.
+func (s *StatsDSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.send()
+	err := s.err
+	s.err = nil
+	return err
+}
+
+// Close implements MetricsSink.
+func (s *StatsDSink) Close() error {
+	err := s.Flush()
+	if cerr := s.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// add appends one "name:value|type[|#tags]" line.
+func (s *StatsDSink) add(name, value, typ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.prefix) + len(name) + 1 + len(value) + 1 + len(typ) + len(s.tags)
+	if len(s.buf) > 0 && len(s.buf)+1+n > s.maxPacket {
+		s.send()
+	}
+	if len(s.buf) > 0 {
+		s.buf = append(s.buf, '\n')
+	}
+	s.buf = append(s.buf, s.prefix...)
+	s.buf = appendStatsDName(s.buf, name)
+	s.buf = append(s.buf, ':')
+	s.buf = append(s.buf, value...)
+	s.buf = append(s.buf, '|')
+	s.buf = append(s.buf, typ...)
+	s.buf = append(s.buf, s.tags...)
+}
+
+// send writes the pending datagram; s.mu must be held.
+func (s *StatsDSink) send() {
+	if len(s.buf) == 0 {
+		return
+	}
+	if _, err := s.conn.Write(s.buf); err != nil && s.err == nil {
+		s.err = err
+	}
+	s.buf = s.buf[:0]
+}
+
+// appendStatsDName appends name with the protocol's separator characters
+// replaced.
+func appendStatsDName(dst []byte, name string) []byte {
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; c {
+		case ':', '|', '@', '#', '\n', ' ':
+			dst = append(dst, '_')
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return dst
+}
@@ -6,6 +6,11 @@
 // RingBuffer is a bounded circular buffer with atomic head/tail,
 // padded to prevent false sharing.
 // Implements api.Ring for cross-package consistency.
+//
+// Slots carry sequence numbers (Vyukov), so any number of producers and
+// consumers may share the ring. Batch operations claim a run of slots with a
+// single CAS, amortizing contention for pipeline stages. pool.Ring exports
+// this ring.
 
 package concurrency
 
@@ -20,12 +25,13 @@ var _ api.Ring[any] = (*RingBuffer[any])(nil)
 
 // RingBuffer is a lock-free ring buffer (MPMC API).
 type RingBuffer[T any] struct {
+	_     [cacheLinePad]byte
 	head  uint64
-	_     [64]byte // Padding for hot/cold separation
+	_     [cacheLinePad - 8]byte // Padding for hot/cold separation
 	tail  uint64
-	_     [64]byte // Padding
+	_     [cacheLinePad - 8]byte // Padding
 	mask  uint64
-	cells []cell[T] // Reuse cell struct from lock_free_queue if possible or redefine
+	cells []cell[T] // Reuse cell struct from lock_free_queue
 }
 
 // NewRingBuffer allocates a ring buffer of power-of-two size.
@@ -77,23 +83,25 @@ func (r *RingBuffer[T]) Enqueue(item T) bool {
 	}
 }
 
-// Dequeue removes and returns item; ok false if empty.
+// Dequeue removes and returns item; ok false if empty. The slot is cleared
+// so the ring does not keep the item reachable.
 func (r *RingBuffer[T]) Dequeue() (T, bool) {
+	var zero T
 	for {
 		head := atomic.LoadUint64(&r.head)
 		index := head & r.mask
 		c := &r.cells[index]
 		seq := c.sequence.Load()
-		dif := int64(seq) - int64(head + 1)
+		dif := int64(seq) - int64(head+1)
 
 		if dif == 0 {
 			if atomic.CompareAndSwapUint64(&r.head, head, head+1) {
 				item := c.data
+				c.data = zero
 				c.sequence.Store(head + r.mask + 1)
 				return item, true
 			}
 		} else if dif < 0 {
-			var zero T
 			return zero, false // empty
 		} else {
 			// head moved
@@ -101,10 +109,81 @@ func (r *RingBuffer[T]) Dequeue() (T, bool) {
 	}
 }
 
+// EnqueueBatch appends as many leading items as fit and returns the count
+// written. Items keep their relative order and occupy contiguous positions.
+func (r *RingBuffer[T]) EnqueueBatch(items []T) int {
+	for len(items) > 0 {
+		tail := atomic.LoadUint64(&r.tail)
+		n := r.run(tail, len(items), 0)
+		if n == 0 {
+			if int64(r.cells[tail&r.mask].sequence.Load())-int64(tail) < 0 {
+				return 0 // full
+			}
+			continue // tail moved
+		}
+		if !atomic.CompareAndSwapUint64(&r.tail, tail, tail+uint64(n)) {
+			continue
+		}
+		for i := 0; i < n; i++ {
+			pos := tail + uint64(i)
+			c := &r.cells[pos&r.mask]
+			c.data = items[i]
+			c.sequence.Store(pos + 1)
+		}
+		return n
+	}
+	return 0
+}
+
+// DequeueBatch fills dst with up to len(dst) items in FIFO order and returns
+// the count read.
+func (r *RingBuffer[T]) DequeueBatch(dst []T) int {
+	var zero T
+	for len(dst) > 0 {
+		head := atomic.LoadUint64(&r.head)
+		n := r.run(head, len(dst), 1)
+		if n == 0 {
+			if int64(r.cells[head&r.mask].sequence.Load())-int64(head+1) < 0 {
+				return 0 // empty
+			}
+			continue // head moved
+		}
+		if !atomic.CompareAndSwapUint64(&r.head, head, head+uint64(n)) {
+			continue
+		}
+		for i := 0; i < n; i++ {
+			pos := head + uint64(i)
+			c := &r.cells[pos&r.mask]
+			dst[i] = c.data
+			c.data = zero
+			c.sequence.Store(pos + r.mask + 1)
+		}
+		return n
+	}
+	return 0
+}
+
+// run counts the consecutive cells from pos, up to max, whose sequence is
+// their position plus ahead: 0 for writable cells, 1 for readable ones.
+func (r *RingBuffer[T]) run(pos uint64, max int, ahead uint64) int {
+	n := 0
+	for n < max && n < len(r.cells) {
+		p := pos + uint64(n)
+		if r.cells[p&r.mask].sequence.Load() != p+ahead {
+			break
+		}
+		n++
+	}
+	return n
+}
+
 // Len returns number of items currently in buffer.
 func (r *RingBuffer[T]) Len() int {
 	head := atomic.LoadUint64(&r.head)
 	tail := atomic.LoadUint64(&r.tail)
+	if tail < head {
+		return 0 // head passed the tail read before it
+	}
 	return int(tail - head)
 }
 
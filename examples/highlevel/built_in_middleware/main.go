@@ -2,12 +2,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/momentics/hioload-ws/control"
 	"github.com/momentics/hioload-ws/highlevel"
 )
 
@@ -69,10 +73,21 @@ func main() {
 		}
 	})
 
-	// Demonstrate metrics
+	// Expose the MetricsMiddleware counters for Prometheus on :9090/metrics,
+	// per-route figures labelled {route="..."}
 	go func() {
-		// In a real example, you'd run this periodically or on demand
-		// For demo purposes we'll just log metrics initially and before shutdown
+		stats := func() map[string]any {
+			out := make(map[string]any)
+			for k, v := range highlevel.GetMetrics() {
+				out[k] = v
+			}
+			return out
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", control.PrometheusHandler(stats, highlevel.RouteMetrics))
+		if err := http.ListenAndServe(":9090", mux); err != nil {
+			log.Printf("Metrics listener error: %v", err)
+		}
 	}()
 
 	// Start the server in a goroutine
@@ -94,7 +109,9 @@ func main() {
 	log.Printf("Final metrics: %v", metrics)
 
 	// Gracefully shutdown the server
-	if err := server.Shutdown(); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
 		log.Printf("Server shutdown error: %v", err)
 	}
 
@@ -2,6 +2,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -25,17 +26,6 @@ func CustomAuthMiddleware(next func(*highlevel.Conn)) func(*highlevel.Conn) {
 	}
 }
 
-// CustomRateLimitMiddleware is an example of user-defined rate limiting middleware
-func CustomRateLimitMiddleware(next func(*highlevel.Conn)) func(*highlevel.Conn) {
-	// In a real implementation, you would track requests from each connection
-	// and reject connections that exceed the rate limit
-	return func(conn *highlevel.Conn) {
-		log.Printf("[RATE_LIMIT] Checking rate limits for %s", conn.RemoteAddr())
-		// For this example, we just pass through
-		next(conn)
-	}
-}
-
 // CustomRequestIDMiddleware is an example of user-defined request ID middleware
 func CustomRequestIDMiddleware(next func(*highlevel.Conn)) func(*highlevel.Conn) {
 	// In a real implementation, you could generate or extract request IDs
@@ -57,7 +47,12 @@ func main() {
 	// Add the custom middleware to the server
 	server.Use(
 		CustomAuthMiddleware,
-		CustomRateLimitMiddleware,
+		// Built-in rate limiting: 10 new connections per second per IP,
+		// 100 messages per second per connection with bursts of 200
+		highlevel.RateLimitMiddleware(highlevel.RateLimitConfig{
+			ConnectionsPerIP: highlevel.RateLimit{Rate: 10, Burst: 20},
+			MessagesPerConn:  highlevel.RateLimit{Rate: 100, Burst: 200},
+		}),
 		CustomRequestIDMiddleware,
 		// Also include the built-in middleware
 		highlevel.LoggingMiddleware,
@@ -106,7 +101,9 @@ func main() {
 	log.Printf("Final metrics: %v", metrics)
 
 	// Gracefully shutdown the server
-	if err := server.Shutdown(); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
 		log.Printf("Server shutdown error: %v", err)
 	}
 
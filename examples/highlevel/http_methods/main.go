@@ -2,11 +2,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/momentics/hioload-ws/highlevel"
 )
@@ -115,7 +117,9 @@ func main() {
 	fmt.Println("\nShutting down server...")
 
 	// Gracefully shutdown the server
-	if err := server.Shutdown(); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
 		log.Printf("Server shutdown error: %v", err)
 	}
 
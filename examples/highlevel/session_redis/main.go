@@ -0,0 +1,78 @@
+// Package main demonstrates a Redis-backed session store in hioload-ws.
+//
+// Each connection to /visits/:user binds to the named session "user:<name>".
+// The session's attributes are restored from Redis when the user reconnects
+// (to this or another node) and written back when the connection closes.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/momentics/hioload-ws/highlevel"
+	"github.com/momentics/hioload-ws/session"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "WebSocket listen address")
+	redisAddr := flag.String("redis", "127.0.0.1:6379", "Redis server address")
+	retention := flag.Duration("retention", 24*time.Hour, "How long closed sessions are kept in Redis")
+	flag.Parse()
+
+	sessions := session.NewSessionManager(0,
+		session.WithStore(NewRedisStore(*redisAddr, "hioload:session:")),
+		session.WithRetention(*retention),
+		session.OnStoreError(func(err error) {
+			log.Printf("session store: %v", err)
+		}),
+	)
+	defer sessions.Stop()
+
+	// Per-connection sessions stay in the server's in-memory manager; only the
+	// named user sessions below are persisted to Redis.
+	server := highlevel.NewServer(*addr)
+
+	server.HandleFunc("/visits/:user", func(conn *highlevel.Conn) {
+		defer conn.Close()
+
+		// Named session shared by every connection of this user.
+		user := sessions.Create("user:" + conn.Param("user"))
+		defer sessions.Close(user.ID())
+
+		// Attributes restored from Redis arrive as JSON numbers (float64).
+		visits, _ := session.Get[float64](user, "visits")
+		visits++
+		user.Set("visits", visits)
+		conn.WriteString(fmt.Sprintf("hello %s, visit #%d", conn.Param("user"), int(visits)))
+
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			user.Touch()
+			user.Set("last_message", string(msg))
+			conn.WriteString("stored")
+		}
+	})
+
+	go func() {
+		log.Printf("Session example listening on %s (redis %s)", *addr, *redisAddr)
+		if err := server.ListenAndServe(); err != nil {
+			log.Fatalf("Server error: %v", err)
+		}
+	}()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	<-sigCh
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server.Shutdown(ctx)
+}
@@ -0,0 +1,204 @@
+// File: examples/highlevel/session_redis/redis_store.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// RedisStore is an example session.Store backed by Redis. It speaks RESP
+// directly over a single connection so the example needs no extra modules;
+// production deployments would typically wrap a pooled client instead.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/momentics/hioload-ws/session"
+)
+
+// RedisStore persists session records as JSON values under prefix+ID.
+type RedisStore struct {
+	addr    string
+	prefix  string
+	timeout time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+// Ensure compile-time interface compliance.
+var _ session.Store = (*RedisStore)(nil)
+
+// redisRecord is the JSON payload stored per session.
+type redisRecord struct {
+	Attrs       map[string]any `json:"attrs"`
+	Expires     time.Time      `json:"expires"`
+	ResumeNonce uint64         `json:"resume_nonce,omitempty"`
+}
+
+// NewRedisStore creates a store talking to the Redis server at addr.
+func NewRedisStore(addr, prefix string) *RedisStore {
+	return &RedisStore{addr: addr, prefix: prefix, timeout: 2 * time.Second}
+}
+
+// Load fetches and decodes the record for id.
+func (r *RedisStore) Load(id string) (*session.Record, error) {
+	reply, err := r.do("GET", r.prefix+id)
+	if err != nil {
+		return nil, err
+	}
+	raw, ok := reply.([]byte)
+	if !ok {
+		return nil, session.ErrNotFound
+	}
+	var rec redisRecord
+	if err := json.Unmarshal(raw, &rec); err != nil {
+		return nil, fmt.Errorf("redis store: decode %s: %w", id, err)
+	}
+	return &session.Record{ID: id, Attrs: rec.Attrs, Expires: rec.Expires, ResumeNonce: rec.ResumeNonce}, nil
+}
+
+// Save encodes rec and stores it with a matching Redis TTL.
+func (r *RedisStore) Save(rec *session.Record) error {
+	raw, err := json.Marshal(redisRecord{Attrs: rec.Attrs, Expires: rec.Expires, ResumeNonce: rec.ResumeNonce})
+	if err != nil {
+		return fmt.Errorf("redis store: encode %s: %w", rec.ID, err)
+	}
+	args := []string{"SET", r.prefix + rec.ID, string(raw)}
+	if !rec.Expires.IsZero() {
+		ms := time.Until(rec.Expires).Milliseconds()
+		if ms <= 0 {
+			return r.Delete(rec.ID)
+		}
+		args = append(args, "PX", strconv.FormatInt(ms, 10))
+	}
+	_, err = r.do(args...)
+	return err
+}
+
+// Delete removes the record for id.
+func (r *RedisStore) Delete(id string) error {
+	_, err := r.do("DEL", r.prefix+id)
+	return err
+}
+
+// Touch moves the Redis TTL of id to expires.
+func (r *RedisStore) Touch(id string, expires time.Time) error {
+	var reply any
+	var err error
+	if expires.IsZero() {
+		reply, err = r.do("PERSIST", r.prefix+id)
+	} else {
+		ms := time.Until(expires).Milliseconds()
+		if ms <= 0 {
+			return r.Delete(id)
+		}
+		reply, err = r.do("PEXPIRE", r.prefix+id, strconv.FormatInt(ms, 10))
+	}
+	if err != nil {
+		return err
+	}
+	if n, ok := reply.(int64); ok && n == 0 {
+		return session.ErrNotFound
+	}
+	return nil
+}
+
+// do sends one command and reads its reply, redialing after I/O errors.
+func (r *RedisStore) do(args ...string) (any, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conn == nil {
+		conn, err := net.DialTimeout("tcp", r.addr, r.timeout)
+		if err != nil {
+			return nil, fmt.Errorf("redis store: dial: %w", err)
+		}
+		r.conn, r.rd = conn, bufio.NewReader(conn)
+	}
+	r.conn.SetDeadline(time.Now().Add(r.timeout))
+
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, a := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(a)), 10)
+		buf = append(buf, "\r\n"...)
+		buf = append(buf, a...)
+		buf = append(buf, "\r\n"...)
+	}
+	if _, err := r.conn.Write(buf); err != nil {
+		r.reset()
+		return nil, fmt.Errorf("redis store: write: %w", err)
+	}
+
+	reply, err := r.readReply()
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		r.reset()
+	}
+	return reply, err
+}
+
+// redisError is an error reply ("-ERR ...") returned by the server.
+type redisError string
+
+func (e redisError) Error() string { return "redis store: " + string(e) }
+
+// readReply decodes one RESP value: simple string, error, integer, bulk
+// string ([]byte, nil when absent) or array ([]any).
+func (r *RedisStore) readReply() (any, error) {
+	line, err := r.rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 {
+		return nil, errors.New("redis store: short reply")
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r.rd, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = r.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis store: unexpected reply %q", line)
+}
+
+// reset drops the current connection so the next command redials.
+func (r *RedisStore) reset() {
+	if r.conn != nil {
+		r.conn.Close()
+	}
+	r.conn, r.rd = nil, nil
+}
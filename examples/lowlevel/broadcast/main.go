@@ -4,24 +4,24 @@
 //
 // Broadcast WebSocket Server Example using the hioload-ws `/server` facade.
 // Demonstrates zero-copy, NUMA-aware, lock-free batch-IO, and CPU/NUMA affinity.
-// Outputs live metrics (connections, messages per second) to console.
+// Exposes live metrics (connections, messages) on a Prometheus /metrics endpoint.
 
 package main
 
 import (
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
-	"sync"
 	"sync/atomic"
 	"syscall"
-	"time"
 
 	"github.com/momentics/hioload-ws/adapters"
 	"github.com/momentics/hioload-ws/api"
-	"github.com/momentics/hioload-ws/core/protocol"
+	"github.com/momentics/hioload-ws/control"
 	"github.com/momentics/hioload-ws/lowlevel/server"
+	"github.com/momentics/hioload-ws/protocol"
 )
 
 func main() {
@@ -31,6 +31,7 @@ func main() {
 	ring := flag.Int("ring", 2048, "Reactor ring capacity")
 	workers := flag.Int("workers", 0, "Executor worker count (0 = num CPUs)")
 	numa := flag.Int("numa", -1, "Preferred NUMA node (-1 = auto)")
+	metricsAddr := flag.String("metrics", ":9091", "Prometheus /metrics listen address")
 	flag.Parse()
 
 	// 2. Configure server
@@ -55,99 +56,68 @@ func main() {
 
 	fmt.Println("Starting WS Broadcast Server on ", *addr)
 
-	// 4. Global broadcast pool: thread-safe set of connections
-	var (
-		conns     = make(map[*protocol.WSConnection]struct{})
-		connsLock sync.RWMutex
-		totalMsgs int64
-	)
+	// 4. Every session joins the lobby; the session manager tracks membership
+	const room = "room:lobby"
+	sessions := srv.Sessions()
+	var totalMsgs int64
 
 	// 5. Register debug probes
 	ctrl := srv.GetControl()
 	ctrl.RegisterDebugProbe("connections", func() any {
-		connsLock.RLock()
-		n := len(conns)
-		connsLock.RUnlock()
-		return n
+		return len(sessions.Tagged(room))
 	})
 	ctrl.RegisterDebugProbe("total_messages", func() any {
 		return atomic.LoadInt64(&totalMsgs)
 	})
 
-	// 6. Stats ticker
+	// 6. Prometheus endpoint
 	go func() {
-		ticker := time.NewTicker(2 * time.Second)
-		defer ticker.Stop()
-		for range ticker.C {
-			stats := ctrl.Stats()
-			fmt.Printf("[%s] Conns: %v, Msgs: %v\n",
-				time.Now().Format(time.Stamp),
-				stats["connections"],
-				stats["total_messages"],
-			)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", control.PrometheusHandler(ctrl.Stats))
+		if err := http.ListenAndServe(*metricsAddr, mux); err != nil {
+			fmt.Fprintf(os.Stderr, "metrics listener error: %v\n", err)
 		}
 	}()
 
-	// 7. Broadcast handler: on message, echo to all connections except sender
+	// 7. Broadcast handler: on message, fan out to the room except the sender
 	handler := adapters.HandlerFunc(func(data any) error {
-		// Extract buffer and connection from context
-		buf := data.(api.Buffer)
+		msg, ok := data.(interface {
+			GetBuffer() api.Buffer
+			WSConnection() *protocol.WSConnection
+		})
+		if !ok {
+			return nil // lifecycle events are handled by join below
+		}
+		buf := msg.GetBuffer()
 		defer buf.Release() // return buffer to pool
-
-		payload := buf.Bytes()
 		atomic.AddInt64(&totalMsgs, 1)
 
-		// Get sender
-		connAny := api.ContextFromData(data).Value("connection")
-		sender := connAny.(*protocol.WSConnection)
-
-		// Copy payload once for broadcast
-		bcast := make([]byte, len(payload))
-		copy(bcast, payload)
-
-		// Broadcast to all connections
-		connsLock.RLock()
-		for conn := range conns {
-			if conn == sender {
-				continue
-			}
-			// Zero-copy send: wrap in WSFrame
-			frame := &protocol.WSFrame{
-				IsFinal:    true,
-				Opcode:     protocol.OpcodeBinary,
-				PayloadLen: int64(len(bcast)),
-				Payload:    bcast,
-			}
-			conn.SendFrame(frame)
+		// Broadcast copies the payload once into a reference-counted buffer
+		// shared by every recipient.
+		frame := &protocol.WSFrame{
+			IsFinal:    true,
+			Opcode:     protocol.OpcodeBinary,
+			PayloadLen: int64(len(buf.Bytes())),
+			Payload:    buf.Bytes(),
 		}
-		connsLock.RUnlock()
+		sessions.Broadcast(room, frame, msg.WSConnection().Session().ID())
 		return nil
 	})
 
-	// 8. Connection lifecycle middleware to track conns
-	track := func(next api.Handler) api.Handler {
+	// 8. Lifecycle middleware: tag each new session; tags are dropped
+	// automatically when the session closes.
+	join := func(next api.Handler) api.Handler {
 		return adapters.HandlerFunc(func(data any) error {
-			// On new conn Open event: add to pool
 			if evt, ok := data.(api.OpenEvent); ok {
-				ws := evt.Conn.(*protocol.WSConnection)
-				connsLock.Lock()
-				conns[ws] = struct{}{}
-				connsLock.Unlock()
-			}
-			// On CloseEvent: remove from pool
-			if evt, ok := data.(api.CloseEvent); ok {
-				ws := evt.Conn.(*protocol.WSConnection)
-				connsLock.Lock()
-				delete(conns, ws)
-				connsLock.Unlock()
+				sessions.Tag(evt.Session.ID(), room)
 			}
 			return next.Handle(data)
 		})
 	}
 
-	// 9. Run server with chained handler: tracking → broadcast
+	// 9. Run server with chained handler: join → broadcast
 	go func() {
-		if err := srv.Run(track(handler)); err != nil {
+		if err := srv.Run(join(handler)); err != nil {
 			fmt.Fprintf(os.Stderr, "Run error: %v\n", err)
 			os.Exit(1)
 		}
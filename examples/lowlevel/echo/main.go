@@ -11,14 +11,15 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"sync/atomic"
 	"syscall"
-	"time"
 
 	"github.com/momentics/hioload-ws/adapters"
 	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/control"
 	"github.com/momentics/hioload-ws/core/protocol"
 	"github.com/momentics/hioload-ws/lowlevel/server"
 )
@@ -30,6 +31,7 @@ func main() {
 	ringCap := flag.Int("ring", 1024, "Reactor ring capacity")
 	workers := flag.Int("workers", 0, "Executor worker count (0 = NumCPU)")
 	numa := flag.Int("numa", -1, "Preferred NUMA node (-1 = auto)")
+	metricsAddr := flag.String("metrics", ":9090", "Prometheus /metrics listen address")
 	flag.Parse()
 
 	// Build server configuration
@@ -73,17 +75,12 @@ func main() {
 		return atomic.LoadInt64(&totalMsgs)
 	})
 
-	// Periodic stats output
+	// Expose config, metrics and probes for Prometheus scraping
 	go func() {
-		ticker := time.NewTicker(5 * time.Second)
-		defer ticker.Stop()
-		for range ticker.C {
-			stats := ctrl.Stats()
-			fmt.Printf("[%s] Active: %v, Msgs: %v\n",
-				time.Now().Format(time.Stamp),
-				stats["active_connections"],
-				stats["messages_processed"],
-			)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", control.PrometheusHandler(ctrl.Stats))
+		if err := http.ListenAndServe(*metricsAddr, mux); err != nil {
+			fmt.Fprintf(os.Stderr, "metrics listener error: %v\n", err)
 		}
 	}()
 
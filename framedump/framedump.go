@@ -0,0 +1,236 @@
+// File: framedump/framedump.go
+// Package framedump exports the decoded frames of WebSocket connections for
+// offline inspection.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Where package record keeps the raw byte stream to replay it, a dump keeps
+// one entry per frame: its header bits, length, close code and, optionally,
+// its payload. That is what interoperability issues with third-party clients
+// usually come down to, and it reads without a WebSocket decoder:
+//
+//	w, err := framedump.NewWriter(f, framedump.FormatJSONL, framedump.Options{Payloads: true})
+//	...
+//	w.Attach(conn)
+//
+// FormatJSONL writes one JSON Record per line. FormatPcapng writes a pcapng
+// capture with the custom link type LinkType, one packet per frame; see
+// LinkType for its layout. Servers start dumps through control; see
+// server.CfgDumpConnections.
+
+package framedump
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// Format selects the encoding of a dump.
+type Format int
+
+const (
+	FormatJSONL  Format = iota // one JSON Record per line
+	FormatPcapng               // pcapng packets of LinkType
+)
+
+// ParseFormat returns the Format named s, as returned by String.
+func ParseFormat(s string) (Format, error) {
+	switch s {
+	case "jsonl":
+		return FormatJSONL, nil
+	case "pcapng":
+		return FormatPcapng, nil
+	}
+	return 0, fmt.Errorf("framedump: unknown format %q", s)
+}
+
+// String returns the name of f, which is also the usual file extension.
+func (f Format) String() string {
+	switch f {
+	case FormatJSONL:
+		return "jsonl"
+	case FormatPcapng:
+		return "pcapng"
+	}
+	return "format(" + strconv.Itoa(int(f)) + ")"
+}
+
+// Options select what a dump holds besides the frame headers.
+type Options struct {
+	Payloads   bool // include the payloads
+	MaxPayload int  // cut included payloads to this many bytes (0 = whole)
+}
+
+// Record is one frame of a dump, as written by FormatJSONL.
+type Record struct {
+	Time        time.Time `json:"time"`
+	Session     string    `json:"session,omitempty"`
+	Remote      string    `json:"remote,omitempty"`
+	Path        string    `json:"path,omitempty"`
+	Dir         string    `json:"dir"` // "recv" or "send", see protocol.FrameObserver
+	Opcode      byte      `json:"opcode"`
+	Type        string    `json:"type"` // the opcode's name
+	Fin         bool      `json:"fin"`
+	Rsv         byte      `json:"rsv,omitempty"` // RSV1-3 bits as in the first header byte
+	Masked      bool      `json:"masked,omitempty"`
+	Len         int64     `json:"len"`
+	CloseCode   uint16    `json:"close_code,omitempty"`
+	CloseReason string    `json:"close_reason,omitempty"`
+	Payload     []byte    `json:"payload,omitempty"`   // with Options.Payloads
+	Truncated   bool      `json:"truncated,omitempty"` // Payload was cut at Options.MaxPayload
+}
+
+// Writer writes the frames of one or more connections as a dump. Dumping
+// stops at the first failed write, which never fails a connection; see Err.
+type Writer struct {
+	format Format
+	opts   Options
+
+	mu     sync.Mutex // serializes records of the receive and send paths
+	w      *bufio.Writer
+	c      io.Closer // the file, if closable
+	err    error     // first write error
+	closed bool
+	frames int64
+}
+
+// NewWriter starts a dump in format to w. Each record is flushed as it is
+// written, so a dump survives a crash of the process. If w is an io.Closer
+// Close closes it.
+func NewWriter(w io.Writer, format Format, opts Options) (*Writer, error) {
+	if format != FormatJSONL && format != FormatPcapng {
+		return nil, fmt.Errorf("framedump: unknown format %d", format)
+	}
+	d := &Writer{format: format, opts: opts, w: bufio.NewWriter(w)}
+	d.c, _ = w.(io.Closer)
+	if format == FormatPcapng {
+		writePcapngHeader(d.w)
+	}
+	if err := d.w.Flush(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Attach dumps every frame conn receives or sends from now on, replacing
+// any frame observer it had. Detach it with conn.SetFrameObserver(nil).
+func (d *Writer) Attach(conn *protocol.WSConnection) {
+	conn.SetFrameObserver(func(dir string, f *protocol.WSFrame) {
+		d.WriteFrame(conn, dir, f)
+	})
+}
+
+// WriteFrame writes f, received or sent by conn as dir tells, stamped with
+// the current time. conn may be nil.
+func (d *Writer) WriteFrame(conn *protocol.WSConnection, dir string, f *protocol.WSFrame) error {
+	rec := d.record(conn, dir, f)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err != nil || d.closed {
+		return d.err
+	}
+	switch d.format {
+	case FormatJSONL:
+		var line []byte
+		if line, d.err = json.Marshal(rec); d.err != nil {
+			return d.err
+		}
+		d.w.Write(append(line, '\n'))
+	case FormatPcapng:
+		writePcapngFrame(d.w, rec)
+	}
+	d.frames++
+	d.err = d.w.Flush()
+	return d.err
+}
+
+// record describes f for the dump.
+func (d *Writer) record(conn *protocol.WSConnection, dir string, f *protocol.WSFrame) *Record {
+	rec := &Record{
+		Time:   time.Now(),
+		Dir:    dir,
+		Opcode: f.Opcode,
+		Type:   opcodeName(f.Opcode),
+		Fin:    f.IsFinal,
+		Rsv:    f.Rsv,
+		Masked: f.Masked,
+		Len:    f.PayloadLen,
+	}
+	if conn != nil {
+		rec.Path = conn.Path()
+		if sess := conn.Session(); sess != nil {
+			rec.Session = sess.ID()
+		}
+		if addr := conn.RemoteAddr(); addr != nil {
+			rec.Remote = addr.String()
+		}
+	}
+	payload := f.Payload
+	if int64(len(payload)) > f.PayloadLen {
+		payload = payload[:f.PayloadLen]
+	}
+	if f.Opcode == protocol.OpcodeClose && len(payload) >= 2 {
+		rec.CloseCode, rec.CloseReason, _ = protocol.ParseClosePayload(payload)
+	}
+	if d.opts.Payloads {
+		if d.opts.MaxPayload > 0 && len(payload) > d.opts.MaxPayload {
+			payload, rec.Truncated = payload[:d.opts.MaxPayload], true
+		}
+		rec.Payload = append([]byte{}, payload...)
+	}
+	return rec
+}
+
+// Frames returns the number of frames written.
+func (d *Writer) Frames() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.frames
+}
+
+// Err returns the error that stopped the dump, or nil.
+func (d *Writer) Err() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.err
+}
+
+// Close ends the dump and closes the file. Frames written afterwards are
+// dropped.
+func (d *Writer) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+	if d.c != nil {
+		return d.c.Close()
+	}
+	return nil
+}
+
+func opcodeName(op byte) string {
+	switch op {
+	case protocol.OpcodeContinuation:
+		return "continuation"
+	case protocol.OpcodeText:
+		return "text"
+	case protocol.OpcodeBinary:
+		return "binary"
+	case protocol.OpcodeClose:
+		return "close"
+	case protocol.OpcodePing:
+		return "ping"
+	case protocol.OpcodePong:
+		return "pong"
+	}
+	return "opcode(" + strconv.Itoa(int(op)) + ")"
+}
@@ -0,0 +1,130 @@
+// File: framedump/pcapng.go
+// Package framedump
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package framedump
+
+import (
+	"bufio"
+	"encoding/binary"
+	"strings"
+)
+
+// LinkType is the pcapng link type of dump packets, LINKTYPE_USER0, which
+// pcap reserves for private use. Each packet holds one frame:
+//
+//	offset 0   format version, 1
+//	offset 1   direction: 0 received, 1 sent
+//	offset 2   the first header byte as on the wire: FIN, RSV1-3 and opcode
+//	offset 3   flags: 0x01 masked, 0x02 payload truncated
+//	offset 4   payload length, 8 bytes big-endian
+//	offset 12  the payload bytes included, unmasked
+//
+// A packet's original length counts the whole payload. The session ID,
+// remote address and path of the connection are in the packet comment.
+// Timestamps have microsecond resolution.
+const LinkType = 147
+
+// Packet header fields, see LinkType.
+const (
+	pktVersion       = 1
+	pktHeaderLen     = 12
+	pktFlagMasked    = 0x01
+	pktFlagTruncated = 0x02
+)
+
+// pcapng block types and options.
+const (
+	blockSHB        = 0x0A0D0D0A
+	blockIDB        = 0x00000001
+	blockEPB        = 0x00000006
+	byteOrderMagic  = 0x1A2B3C4D
+	optEnd          = 0
+	optComment      = 1
+	maxCommentBytes = 1024
+)
+
+var le = binary.LittleEndian
+
+// writePcapngHeader writes the section header and the one interface all
+// packets are captured on.
+func writePcapngHeader(w *bufio.Writer) {
+	var b []byte
+	b = le.AppendUint32(b, blockSHB)
+	b = le.AppendUint32(b, 28)
+	b = le.AppendUint32(b, byteOrderMagic)
+	b = le.AppendUint16(b, 1) // version 1.0
+	b = le.AppendUint16(b, 0)
+	b = le.AppendUint64(b, ^uint64(0)) // section length unknown
+	b = le.AppendUint32(b, 28)
+
+	b = le.AppendUint32(b, blockIDB)
+	b = le.AppendUint32(b, 20)
+	b = le.AppendUint16(b, LinkType)
+	b = le.AppendUint16(b, 0)
+	b = le.AppendUint32(b, 0) // no snap length
+	b = le.AppendUint32(b, 20)
+	w.Write(b)
+}
+
+// writePcapngFrame writes rec as an enhanced packet block.
+func writePcapngFrame(w *bufio.Writer, rec *Record) {
+	pkt := make([]byte, pktHeaderLen, pktHeaderLen+len(rec.Payload))
+	pkt[0] = pktVersion
+	if rec.Dir == "send" {
+		pkt[1] = 1
+	}
+	pkt[2] = rec.Rsv | rec.Opcode
+	if rec.Fin {
+		pkt[2] |= 0x80
+	}
+	if rec.Masked {
+		pkt[3] |= pktFlagMasked
+	}
+	if rec.Truncated {
+		pkt[3] |= pktFlagTruncated
+	}
+	binary.BigEndian.PutUint64(pkt[4:], uint64(rec.Len))
+	pkt = append(pkt, rec.Payload...)
+
+	var fields []string
+	for _, f := range [][2]string{{"session", rec.Session}, {"remote", rec.Remote}, {"path", rec.Path}} {
+		if f[1] != "" {
+			fields = append(fields, f[0]+"="+f[1])
+		}
+	}
+	comment := strings.Join(fields, " ")
+	if len(comment) > maxCommentBytes {
+		comment = comment[:maxCommentBytes]
+	}
+	var opts []byte
+	if comment != "" {
+		opts = le.AppendUint16(opts, optComment)
+		opts = le.AppendUint16(opts, uint16(len(comment)))
+		opts = append(opts, comment...)
+		opts = append(opts, make([]byte, pad4(len(comment)))...)
+		opts = le.AppendUint32(opts, optEnd)
+	}
+
+	total := 32 + len(pkt) + pad4(len(pkt)) + len(opts)
+	us := uint64(rec.Time.UnixMicro())
+	b := make([]byte, 0, total)
+	b = le.AppendUint32(b, blockEPB)
+	b = le.AppendUint32(b, uint32(total))
+	b = le.AppendUint32(b, 0) // interface
+	b = le.AppendUint32(b, uint32(us>>32))
+	b = le.AppendUint32(b, uint32(us))
+	b = le.AppendUint32(b, uint32(len(pkt)))
+	b = le.AppendUint32(b, uint32(pktHeaderLen+rec.Len))
+	b = append(b, pkt...)
+	b = append(b, make([]byte, pad4(len(pkt)))...)
+	b = append(b, opts...)
+	b = le.AppendUint32(b, uint32(total))
+	w.Write(b)
+}
+
+// pad4 returns the padding that aligns n bytes to 32 bits.
+func pad4(n int) int {
+	return (4 - n%4) % 4
+}
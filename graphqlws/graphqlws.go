@@ -0,0 +1,162 @@
+// File: graphqlws/graphqlws.go
+// Package graphqlws implements the server side of the graphql-transport-ws
+// protocol on top of highlevel connections.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// The package owns the message lifecycle only: connection_init/ack, ping/pong,
+// subscribe/next/error/complete and the protocol's close codes. Parsing and
+// executing GraphQL is left to resolver hooks, so any GraphQL engine can be
+// plugged in:
+//
+//	srv := highlevel.NewServer(":8080", highlevel.WithSubprotocols(graphqlws.Subprotocol))
+//	srv.HandleFunc("/graphql", func(c *highlevel.Conn) {
+//		graphqlws.Serve(c, graphqlws.WithExecute(exec), graphqlws.WithSubscribe(sub))
+//	})
+
+package graphqlws
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Subprotocol is the Sec-WebSocket-Protocol token of graphql-transport-ws.
+const Subprotocol = "graphql-transport-ws"
+
+// Message types.
+const (
+	TypeConnectionInit = "connection_init"
+	TypeConnectionAck  = "connection_ack"
+	TypePing           = "ping"
+	TypePong           = "pong"
+	TypeSubscribe      = "subscribe"
+	TypeNext           = "next"
+	TypeError          = "error"
+	TypeComplete       = "complete"
+)
+
+// Close codes defined by the protocol.
+const (
+	CloseBadRequest       uint16 = 4400
+	CloseUnauthorized     uint16 = 4401
+	CloseForbidden        uint16 = 4403
+	CloseInitTimeout      uint16 = 4408
+	CloseSubscriberExists uint16 = 4409
+	CloseTooManyInits     uint16 = 4429
+)
+
+// Message is one protocol message.
+type Message struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Request is the payload of a subscribe message.
+type Request struct {
+	OperationName string         `json:"operationName,omitempty"`
+	Query         string         `json:"query"`
+	Variables     map[string]any `json:"variables,omitempty"`
+	Extensions    map[string]any `json:"extensions,omitempty"`
+}
+
+// Result is the payload of a next message.
+type Result struct {
+	Data       any            `json:"data,omitempty"`
+	Errors     Errors         `json:"errors,omitempty"`
+	Extensions map[string]any `json:"extensions,omitempty"`
+}
+
+// Error is a GraphQL error.
+type Error struct {
+	Message    string         `json:"message"`
+	Path       []any          `json:"path,omitempty"`
+	Extensions map[string]any `json:"extensions,omitempty"`
+}
+
+// Errors is a list of GraphQL errors. Resolver hooks may return it to control
+// the payload of the error message sent to the client.
+type Errors []Error
+
+// Error implements error.
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Message
+	}
+	return "graphql: " + strings.Join(msgs, "; ")
+}
+
+// CloseError reports that Serve closed the connection with a protocol close
+// code.
+type CloseError struct {
+	Code   uint16
+	Reason string
+}
+
+// Error implements error.
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("graphqlws: closed %d: %s", e.Code, e.Reason)
+}
+
+// OperationType returns "query", "mutation" or "subscription" for the first
+// operation in a GraphQL document, or "" if none is found. It only scans
+// top-level tokens; fragment definitions are skipped.
+func OperationType(query string) string {
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case c == '#':
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+		case c == '{':
+			return "query" // anonymous query shorthand
+		case isNameStart(c):
+			j := i
+			for j < len(query) && isNameChar(query[j]) {
+				j++
+			}
+			switch word := query[i:j]; word {
+			case "query", "mutation", "subscription":
+				return word
+			case "fragment":
+				j = skipSelection(query, j)
+			}
+			i = j - 1
+		}
+	}
+	return ""
+}
+
+// skipSelection returns the index just past the brace-balanced selection set
+// starting at or after i.
+func skipSelection(query string, i int) int {
+	depth := 0
+	for ; i < len(query); i++ {
+		switch query[i] {
+		case '"':
+			for i++; i < len(query) && query[i] != '"'; i++ {
+				if query[i] == '\\' {
+					i++
+				}
+			}
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return i
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
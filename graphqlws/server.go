@@ -0,0 +1,333 @@
+// File: graphqlws/server.go
+// Package graphqlws
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Serve drives one graphql-transport-ws connection. Every subscribe message
+// runs in its own goroutine with a context cancelled by the client's complete
+// message or by the connection ending; writes are serialised per connection.
+
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/momentics/hioload-ws/highlevel"
+)
+
+// DefaultInitTimeout is how long a client may take to send connection_init.
+const DefaultInitTimeout = 3 * time.Second
+
+// ExecuteFunc resolves a query or mutation to a single result.
+type ExecuteFunc func(ctx context.Context, req *Request) (*Result, error)
+
+// SubscribeFunc starts a subscription; the returned channel yields results
+// until it is closed or ctx is cancelled.
+type SubscribeFunc func(ctx context.Context, req *Request) (<-chan *Result, error)
+
+// InitFunc validates the connection_init payload and returns the optional
+// connection_ack payload. An error closes the connection with 4403.
+type InitFunc func(ctx context.Context, payload json.RawMessage) (any, error)
+
+// Option configures Serve.
+type Option func(*conn)
+
+// WithExecute installs the resolver for queries and mutations. Without it
+// every operation is passed to the SubscribeFunc.
+func WithExecute(fn ExecuteFunc) Option {
+	return func(c *conn) {
+		c.execute = fn
+	}
+}
+
+// WithSubscribe installs the resolver for subscription operations.
+func WithSubscribe(fn SubscribeFunc) Option {
+	return func(c *conn) {
+		c.subscribe = fn
+	}
+}
+
+// WithInit installs the connection_init hook, e.g. for authentication.
+func WithInit(fn InitFunc) Option {
+	return func(c *conn) {
+		c.init = fn
+	}
+}
+
+// WithInitTimeout overrides DefaultInitTimeout.
+func WithInitTimeout(d time.Duration) Option {
+	return func(c *conn) {
+		c.initTimeout = d
+	}
+}
+
+// conn is the protocol state of one connection.
+type conn struct {
+	ws          *highlevel.Conn
+	execute     ExecuteFunc
+	subscribe   SubscribeFunc
+	init        InitFunc
+	initTimeout time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	initSeen bool
+	acked    bool
+	closed   *CloseError
+	ops      map[string]context.CancelFunc
+	wg       sync.WaitGroup
+
+	wmu sync.Mutex
+}
+
+// Serve runs the graphql-transport-ws protocol on c until the connection
+// ends. It returns a *CloseError when it closed the connection for a protocol
+// violation, otherwise the read error that ended it.
+func Serve(c *highlevel.Conn, opts ...Option) error {
+	s := &conn{ws: c, initTimeout: DefaultInitTimeout, ops: make(map[string]context.CancelFunc)}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	defer func() {
+		s.cancel()
+		s.wg.Wait()
+	}()
+
+	timer := time.AfterFunc(s.initTimeout, func() {
+		s.mu.Lock()
+		acked := s.acked
+		s.mu.Unlock()
+		if !acked {
+			s.closeWith(CloseInitTimeout, "Connection initialisation timeout")
+		}
+	})
+	defer timer.Stop()
+
+	for {
+		_, data, err := c.ReadMessage()
+		if err != nil {
+			if ce := s.closeErr(); ce != nil {
+				return ce
+			}
+			return err
+		}
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil || msg.Type == "" {
+			return s.closeWith(CloseBadRequest, "Invalid message received")
+		}
+		if err := s.handle(&msg); err != nil {
+			return err
+		}
+	}
+}
+
+// handle dispatches one client message.
+func (s *conn) handle(msg *Message) error {
+	switch msg.Type {
+	case TypeConnectionInit:
+		return s.connectionInit(msg.Payload)
+	case TypePing:
+		return s.send(&Message{Type: TypePong})
+	case TypePong:
+		return nil
+	case TypeSubscribe:
+		return s.startOperation(msg)
+	case TypeComplete:
+		s.mu.Lock()
+		cancel := s.ops[msg.ID]
+		delete(s.ops, msg.ID)
+		s.mu.Unlock()
+		if cancel != nil {
+			cancel()
+		}
+		return nil
+	}
+	return s.closeWith(CloseBadRequest, "Invalid message received")
+}
+
+// connectionInit runs the init hook and acknowledges the connection.
+func (s *conn) connectionInit(payload json.RawMessage) error {
+	s.mu.Lock()
+	seen := s.initSeen
+	s.initSeen = true
+	s.mu.Unlock()
+	if seen {
+		return s.closeWith(CloseTooManyInits, "Too many initialisation requests")
+	}
+
+	var ackPayload any
+	if s.init != nil {
+		var err error
+		if ackPayload, err = s.init(s.ctx, payload); err != nil {
+			return s.closeWith(CloseForbidden, "Forbidden")
+		}
+	}
+	ack := &Message{Type: TypeConnectionAck}
+	if ackPayload != nil {
+		raw, err := json.Marshal(ackPayload)
+		if err != nil {
+			return err
+		}
+		ack.Payload = raw
+	}
+	if err := s.send(ack); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.acked = true
+	s.mu.Unlock()
+	return nil
+}
+
+// startOperation registers a subscribe message and runs it asynchronously.
+func (s *conn) startOperation(msg *Message) error {
+	var req Request
+	if msg.ID == "" || json.Unmarshal(msg.Payload, &req) != nil || req.Query == "" {
+		return s.closeWith(CloseBadRequest, "Invalid message received")
+	}
+
+	s.mu.Lock()
+	if !s.acked {
+		s.mu.Unlock()
+		return s.closeWith(CloseUnauthorized, "Unauthorized")
+	}
+	if _, dup := s.ops[msg.ID]; dup {
+		s.mu.Unlock()
+		return s.closeWith(CloseSubscriberExists, "Subscriber for "+msg.ID+" already exists")
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.ops[msg.ID] = cancel
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	go s.runOperation(ctx, msg.ID, &req)
+	return nil
+}
+
+// runOperation resolves req and streams its results. complete is sent when
+// the operation ends on the server side; a client complete only cancels ctx.
+func (s *conn) runOperation(ctx context.Context, id string, req *Request) {
+	defer s.wg.Done()
+
+	var results <-chan *Result
+	var err error
+	if s.execute != nil && OperationType(req.Query) != "subscription" {
+		var res *Result
+		if res, err = s.execute(ctx, req); err == nil {
+			ch := make(chan *Result, 1)
+			ch <- res
+			close(ch)
+			results = ch
+		}
+	} else if s.subscribe != nil {
+		results, err = s.subscribe(ctx, req)
+	} else {
+		err = errors.New("operation not supported")
+	}
+	if err != nil {
+		if s.finish(id) {
+			s.sendErrors(id, err)
+		}
+		return
+	}
+
+	for {
+		select {
+		case res, ok := <-results:
+			if !ok {
+				if s.finish(id) {
+					s.send(&Message{ID: id, Type: TypeComplete})
+				}
+				return
+			}
+			if res == nil {
+				continue
+			}
+			if s.sendPayload(id, TypeNext, res) != nil {
+				return
+			}
+		case <-ctx.Done():
+			s.finish(id)
+			return
+		}
+	}
+}
+
+// finish unregisters id, reporting whether it was still active (i.e. the
+// client has not completed it).
+func (s *conn) finish(id string) bool {
+	s.mu.Lock()
+	cancel, ok := s.ops[id]
+	delete(s.ops, id)
+	s.mu.Unlock()
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// sendErrors reports an operation failure as an error message.
+func (s *conn) sendErrors(id string, err error) error {
+	var gqlErrs Errors
+	if !errors.As(err, &gqlErrs) {
+		gqlErrs = Errors{{Message: err.Error()}}
+	}
+	return s.sendPayload(id, TypeError, gqlErrs)
+}
+
+// sendPayload marshals v as the payload of a typ message.
+func (s *conn) sendPayload(id, typ string, v any) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.send(&Message{ID: id, Type: typ, Payload: raw})
+}
+
+// send writes one message as a text frame.
+func (s *conn) send(msg *Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	return s.ws.WriteMessage(int(highlevel.TextMessage), data)
+}
+
+// closeWith closes the connection with a protocol close code, once.
+func (s *conn) closeWith(code uint16, reason string) error {
+	s.mu.Lock()
+	if s.closed != nil {
+		ce := s.closed
+		s.mu.Unlock()
+		return ce
+	}
+	s.closed = &CloseError{Code: code, Reason: reason}
+	ce := s.closed
+	s.mu.Unlock()
+
+	s.cancel()
+	if ws := s.ws.GetUnderlyingWSConnection(); ws != nil {
+		ws.CloseWithCode(code, reason)
+	}
+	s.ws.Close()
+	return ce
+}
+
+// closeErr returns the close reason if Serve closed the connection.
+func (s *conn) closeErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed == nil {
+		return nil
+	}
+	return s.closed
+}
@@ -0,0 +1,137 @@
+// Package hioload provides a high-level WebSocket library built on top of hioload-ws primitives.
+package highlevel
+
+import (
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// ErrWriteQueueFull is reported to the completion callback of an async write
+// rejected or dropped because the connection's queue was full.
+var ErrWriteQueueFull = api.ErrWriteQueueFull
+
+// WriteOverflowPolicy selects what WriteMessageAsync does when the
+// connection's async write queue is full.
+type WriteOverflowPolicy string
+
+const (
+	// WriteDropNewest fails the new write with ErrWriteQueueFull (default).
+	WriteDropNewest WriteOverflowPolicy = "drop-newest"
+	// WriteDropOldest discards the oldest queued write, failing it with
+	// ErrWriteQueueFull, to make room for the new one.
+	WriteDropOldest WriteOverflowPolicy = "drop-oldest"
+	// WriteClose fails the new write and closes the connection with 1013
+	// (try again later), shedding a peer that cannot keep up.
+	WriteClose WriteOverflowPolicy = "close"
+)
+
+const (
+	// defaultAsyncQueue is the async write queue capacity when none is configured.
+	defaultAsyncQueue = 256
+	// slowConsumerGrace bounds the close handshake with a peer shed by WriteClose.
+	slowConsumerGrace = time.Second
+)
+
+// asyncWrite is one queued WriteMessageAsync call.
+type asyncWrite struct {
+	messageType int
+	data        []byte
+	done        func(error)
+}
+
+func (w asyncWrite) complete(err error) {
+	if w.done != nil {
+		w.done(err)
+	}
+}
+
+// WriteMessageAsync queues a message for the connection's writer goroutine
+// and returns without waiting for the peer, so one slow connection cannot
+// stall a broadcast loop. done, which may be nil, receives the result of the
+// write; it runs on the writer goroutine, or before WriteMessageAsync returns
+// when the write is rejected outright. data must not be modified until then.
+// When the queue is full the configured WriteOverflowPolicy applies.
+func (c *Conn) WriteMessageAsync(messageType int, data []byte, done func(error)) {
+	w := asyncWrite{messageType: messageType, data: data, done: done}
+	queue := c.startAsyncWriter()
+
+	// The read lock keeps Close from stopping the writer mid-enqueue, so every
+	// queued write is either sent or failed by the writer's drain.
+	c.mutex.RLock()
+	if c.closed {
+		c.mutex.RUnlock()
+		w.complete(ErrClosed)
+		return
+	}
+	select {
+	case queue <- w:
+		c.mutex.RUnlock()
+		return
+	default:
+	}
+	var dropped asyncWrite
+	queued := false
+	if c.asyncPolicy == WriteDropOldest {
+		select {
+		case dropped = <-queue:
+		default:
+		}
+		select {
+		case queue <- w:
+			queued = true
+		default:
+		}
+	}
+	c.mutex.RUnlock()
+
+	dropped.complete(ErrWriteQueueFull)
+	if queued {
+		return
+	}
+	w.complete(ErrWriteQueueFull)
+	if c.asyncPolicy == WriteClose {
+		// The close frame may never get through to a peer that stopped
+		// reading; closing the transport after a grace period unblocks it.
+		go c.CloseWithCode(protocol.CloseTryAgainLater, "slow consumer")
+		time.AfterFunc(slowConsumerGrace, func() { c.Close() })
+	}
+}
+
+// startAsyncWriter returns the async write queue, creating it and starting
+// its writer goroutine on first use.
+func (c *Conn) startAsyncWriter() chan asyncWrite {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.asyncQueue == nil && !c.closed {
+		size := c.asyncSize
+		if size <= 0 {
+			size = defaultAsyncQueue
+		}
+		c.asyncQueue = make(chan asyncWrite, size)
+		c.asyncStop = make(chan struct{})
+		go c.runAsyncWriter(c.asyncQueue, c.asyncStop)
+	}
+	return c.asyncQueue
+}
+
+// runAsyncWriter sends queued writes in order until the connection closes,
+// then fails whatever is still queued.
+func (c *Conn) runAsyncWriter(queue chan asyncWrite, stop chan struct{}) {
+	for {
+		select {
+		case w := <-queue:
+			w.complete(c.writeMessage(w.messageType, w.data, protocol.PriorityNormal))
+		case <-stop:
+			for {
+				select {
+				case w := <-queue:
+					w.complete(ErrClosed)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
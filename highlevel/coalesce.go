@@ -0,0 +1,122 @@
+// Package hioload provides a high-level WebSocket library built on top of hioload-ws primitives.
+package highlevel
+
+import (
+	"time"
+
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// coalesceMaxBytes is the pending payload size at which the coalescer
+// flushes without waiting for its interval.
+const coalesceMaxBytes = 64 << 10
+
+// closeFlushTimeout bounds the flush of the pending batch on Close when no
+// write timeout is set.
+const closeFlushTimeout = 5 * time.Second
+
+// writeCoalescer holds back the frames written to one connection and writes
+// them with a single transport Send once its interval elapses, enough bytes
+// are pending or a control frame is written. It is guarded by Conn.writeMu.
+type writeCoalescer struct {
+	interval time.Duration
+	pending  []*protocol.WSFrame
+	bytes    int
+	timer    *time.Timer
+	armed    bool
+	// First error of a timer-driven flush, reported by the next write
+	err error
+}
+
+// queueFrame adds frame to the pending batch. Called with writeMu held.
+func (c *Conn) queueFrame(frame *protocol.WSFrame) error {
+	w := c.coalescer
+	if err := w.err; err != nil {
+		w.err = nil
+		frame.Buf.Release()
+		return err
+	}
+	w.pending = append(w.pending, frame)
+	w.bytes += len(frame.Payload)
+	if frame.Opcode&0x08 != 0 || w.bytes >= coalesceMaxBytes {
+		return c.flushLocked()
+	}
+	if !w.armed {
+		w.armed = true
+		if w.timer == nil {
+			w.timer = time.AfterFunc(w.interval, c.flushTimer)
+		} else {
+			w.timer.Reset(w.interval)
+		}
+	}
+	return nil
+}
+
+// flushLocked writes the pending batch and waits until it is written. The
+// send loop enforces the write timeout. Called with writeMu held.
+func (c *Conn) flushLocked() error {
+	return c.flushWith(c.underlying.WriteFrames)
+}
+
+// flushWith hands the pending batch to send. Called with writeMu held.
+func (c *Conn) flushWith(send func([]*protocol.WSFrame) error) error {
+	w := c.coalescer
+	if w.armed {
+		w.timer.Stop()
+		w.armed = false
+	}
+	if len(w.pending) == 0 {
+		return nil
+	}
+	err := send(w.pending)
+	clear(w.pending)
+	w.pending = w.pending[:0]
+	w.bytes = 0
+	return err
+}
+
+// flushTimer is the coalescer's timer callback.
+func (c *Conn) flushTimer() {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if !c.coalescer.armed {
+		return // flushed by a write in the meantime
+	}
+	if err := c.flushLocked(); err != nil && c.coalescer.err == nil {
+		c.coalescer.err = err
+	}
+}
+
+// flushBeforeClose hands the pending batch to the connection unless a write
+// is in progress, which would keep the close waiting on a stalled peer; the
+// armed timer then releases the batch. With wait it returns once the batch is
+// written or its write deadline passed: the write timeout from now, or
+// closeFlushTimeout if none is set. Without, the batch is only queued, for a
+// close that writes the queued frames first.
+func (c *Conn) flushBeforeClose(wait bool) {
+	if c.coalescer == nil || !c.writeMu.TryLock() {
+		return
+	}
+	defer c.writeMu.Unlock()
+	if !wait {
+		c.flushWith(c.underlying.SendFrames)
+		return
+	}
+	d := c.writeTimeout
+	if d <= 0 {
+		d = closeFlushTimeout
+	}
+	c.underlying.SetWriteDeadline(time.Now().Add(d))
+	c.flushLocked()
+}
+
+// Flush writes the messages held back by write coalescing immediately. It is
+// a no-op when coalescing is disabled.
+func (c *Conn) Flush() error {
+	if c.coalescer == nil {
+		return nil
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.flushLocked()
+}
@@ -0,0 +1,72 @@
+// Package hioload provides a high-level WebSocket library built on top of hioload-ws primitives.
+package highlevel
+
+import "encoding/json"
+
+// Codec serializes the values sent with WriteObject and read with ReadObject.
+// The codec package provides MsgPack, CBOR and Protobuf implementations.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	// Unmarshal must not retain data, which ReadObject hands out of a
+	// pooled buffer.
+	Unmarshal(data []byte, v any) error
+	// MessageType is the frame type WriteObject sends: TextMessage for
+	// text formats, BinaryMessage otherwise.
+	MessageType() MessageType
+}
+
+// JSONCodec encodes values with encoding/json. It is the default Codec.
+type JSONCodec struct{}
+
+// Marshal implements Codec.
+func (JSONCodec) Marshal(v any) ([]byte, error) { return json.Marshal(v) }
+
+// Unmarshal implements Codec.
+func (JSONCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+// MessageType implements Codec.
+func (JSONCodec) MessageType() MessageType { return TextMessage }
+
+// SetCodec sets the codec of ReadObject and WriteObject on this connection.
+// A nil codec restores JSONCodec.
+func (c *Conn) SetCodec(codec Codec) {
+	c.mutex.Lock()
+	c.codec = codec
+	c.mutex.Unlock()
+}
+
+// Codec returns the codec used by ReadObject and WriteObject.
+func (c *Conn) Codec() Codec {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if c.codec == nil {
+		return JSONCodec{}
+	}
+	return c.codec
+}
+
+// ReadObject reads the next message and decodes it into v with the
+// connection's codec. The payload is decoded in place, without a copy.
+func (c *Conn) ReadObject(v any) error {
+	_, buf, err := c.readBuffer()
+	if err != nil {
+		return err
+	}
+	defer buf.Release()
+	payload := buf.Bytes()
+	if c.readLimit > 0 && int64(len(payload)) > c.readLimit {
+		return ErrReadLimit
+	}
+	return c.Codec().Unmarshal(payload, v)
+}
+
+// WriteObject encodes v with the connection's codec and sends it as one
+// message of the codec's type.
+func (c *Conn) WriteObject(v any) error {
+	codec := c.Codec()
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(int(codec.MessageType()), data)
+}
@@ -1,7 +1,12 @@
 // Package hioload provides a high-level WebSocket library built on top of hioload-ws primitives.
 package highlevel
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/momentics/hioload-ws/api"
+)
 
 // Version of the hioload library
 const Version = "1.0.0"
@@ -11,6 +16,7 @@ var (
 	// ErrClosed is returned when attempting to read or write from a closed connection.
 	ErrClosed = errors.New("websocket: closed")
 
-	// ErrReadLimit is returned when the read limit is exceeded.
-	ErrReadLimit = errors.New("websocket: read limit exceeded")
+	// ErrReadLimit is returned when the read limit is exceeded. It wraps
+	// api.ErrMessageTooBig.
+	ErrReadLimit = fmt.Errorf("websocket: read limit exceeded: %w", api.ErrMessageTooBig)
 )
\ No newline at end of file
@@ -2,9 +2,13 @@
 package highlevel
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"net/http"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/momentics/hioload-ws/api"
@@ -29,6 +33,19 @@ type Conn struct {
 	closed    bool
 	closeOnce sync.Once
 
+	// Serializes writers so frames are never interleaved, see WriteMessage
+	writeMu   sync.Mutex
+	coalescer *writeCoalescer
+
+	// Queue and writer goroutine of WriteMessageAsync, created on first use
+	asyncQueue  chan asyncWrite
+	asyncStop   chan struct{}
+	asyncSize   int
+	asyncPolicy WriteOverflowPolicy
+
+	// Codec of ReadObject and WriteObject, JSONCodec when nil
+	codec Codec
+
 	// Configuration
 	readLimit    int64
 	readTimeout  time.Duration
@@ -40,16 +57,49 @@ type Conn struct {
 	// Callbacks
 	onClose func()
 	// Inbound queue for server-side connections fed by the event loop
-	incoming     chan api.Buffer
+	incoming     chan inboundMessage
 	handlerOnce  sync.Once
-	overflow     chan api.Buffer
+	overflow     chan inboundMessage
 	overflowOnce sync.Once
 
 	// Client-specific fields (may be nil for server connections)
 	client *client.Client
+	// Mux channel state, nil unless the Conn is a channel of a Mux
+	stream *muxStream
 
 	// URL parameters extracted from the route
 	params []RouteParam
+	// Pattern of the route serving the connection, see Route
+	route string
+
+	// Per-route figures, set by MetricsMiddleware, and when the handler
+	// was last handed a message (unix nanoseconds)
+	metrics   atomic.Pointer[routeMetrics]
+	delivered atomic.Int64
+
+	// Correlation state for Request/OnRequest
+	requests requestTable
+
+	// Server's per-message middleware, see Server.UseMessage
+	messageMiddleware []MessageMiddleware
+
+	// When the server created this connection
+	openedAt time.Time
+	// Server-wide count of running handlers, nil for client connections
+	running *atomic.Int64
+	// Runs the server's OnPanic callbacks, nil for client connections
+	onPanic func(c *Conn, recovered any, stack []byte)
+
+	// Connection-scoped values, see Context
+	contexts   api.ContextFactory
+	values     api.Context
+	valuesOnce sync.Once
+}
+
+// inboundMessage is a queued server-side message with its frame type.
+type inboundMessage struct {
+	messageType MessageType
+	buf         api.Buffer
 }
 
 // newConn creates a new Conn wrapper around protocol.WSConnection
@@ -59,7 +109,7 @@ func newConn(underlying *protocol.WSConnection, pool api.BufferPool) *Conn {
 		pool:        pool,
 		readLimit:   32 << 20, // 32MB default
 		autoRelease: true,
-		incoming:    make(chan api.Buffer, 128),
+		incoming:    make(chan inboundMessage, 128),
 		params:      make([]RouteParam, 0),
 	}
 }
@@ -72,7 +122,7 @@ func newConnWithParams(underlying *protocol.WSConnection, pool api.BufferPool, p
 		params:      params,
 		readLimit:   32 << 20, // 32MB default
 		autoRelease: true,
-		incoming:    make(chan api.Buffer, 128),
+		incoming:    make(chan inboundMessage, 128),
 	}
 }
 
@@ -88,6 +138,55 @@ func newClientConn(underlying *protocol.WSConnection, pool api.BufferPool, clien
 	}
 }
 
+// Session returns the session bound to this connection by the server, or nil
+// for client-side connections.
+func (c *Conn) Session() api.Session {
+	if c.client != nil {
+		return nil
+	}
+	if ws := c.GetUnderlyingWSConnection(); ws != nil {
+		return ws.Session()
+	}
+	return nil
+}
+
+// Subprotocol returns the Sec-WebSocket-Protocol agreed during the handshake,
+// or "" if none was negotiated.
+func (c *Conn) Subprotocol() string {
+	if ws := c.GetUnderlyingWSConnection(); ws != nil {
+		return ws.Subprotocol()
+	}
+	return ""
+}
+
+// VirtualHost returns the WithTLSHosts host the client connected to by its
+// TLS server name, or "" if none.
+func (c *Conn) VirtualHost() string {
+	if ws := c.GetUnderlyingWSConnection(); ws != nil {
+		return ws.VirtualHost()
+	}
+	return ""
+}
+
+// RequestHeader returns the headers of the upgrade request the client
+// connected with, or nil for client-side connections. It must not be
+// modified.
+func (c *Conn) RequestHeader() http.Header {
+	if c.client != nil {
+		return nil
+	}
+	if ws := c.GetUnderlyingWSConnection(); ws != nil {
+		return ws.RequestHeader()
+	}
+	return nil
+}
+
+// Route returns the pattern of the route serving the connection, e.g.
+// "/chat/:room", or "" when no route matched its path.
+func (c *Conn) Route() string {
+	return c.route
+}
+
 // GetUnderlyingWSConnection returns the underlying protocol.WSConnection
 // This can be used for direct access to low-level functionality
 func (c *Conn) GetUnderlyingWSConnection() *protocol.WSConnection {
@@ -117,8 +216,8 @@ func (c *Conn) WriteJSON(v interface{}) error {
 		return err
 	}
 
-	// Use WriteMessage to send as binary
-	return c.WriteMessage(int(BinaryMessage), data)
+	// JSON is UTF-8 text, so send it as a text frame
+	return c.WriteMessage(int(TextMessage), data)
 }
 
 // ReadMessage reads a message from the connection and returns a safe copy.
@@ -132,7 +231,7 @@ func (c *Conn) ReadMessage() (messageType int, p []byte, err error) {
 
 	payload := buf.Bytes()
 	if c.readLimit > 0 && int64(len(payload)) > c.readLimit {
-		return 0, nil, errors.New("message exceeds read limit")
+		return 0, nil, ErrReadLimit
 	}
 
 	out := make([]byte, len(payload))
@@ -146,114 +245,95 @@ func (c *Conn) ReadBuffer() (int, api.Buffer, error) {
 	return c.readBuffer()
 }
 
-// internal readBuffer function that returns the raw buffer
+// readBuffer returns the next application message, consuming correlated
+// request/response messages on the way and running the message middleware.
 func (c *Conn) readBuffer() (messageType int, buf api.Buffer, err error) {
+	m := c.metrics.Load()
+	if m != nil {
+		c.observeHandled(m)
+	}
+	for {
+		messageType, buf, err = c.readRawBuffer()
+		if err != nil {
+			return messageType, buf, err
+		}
+		if c.handleCorrelated(buf) {
+			continue
+		}
+		if len(c.messageMiddleware) == 0 {
+			c.countInbound(m, buf)
+			return messageType, buf, nil
+		}
+		if mt, out, ok := c.filterMessage(MessageType(messageType), buf); ok {
+			c.countInbound(m, out)
+			return int(mt), out, nil
+		}
+	}
+}
+
+// internal readRawBuffer function that returns the raw buffer
+func (c *Conn) readRawBuffer() (messageType int, buf api.Buffer, err error) {
 	c.mutex.RLock()
 	if c.closed {
 		c.mutex.RUnlock()
-		return 0, api.Buffer{}, errors.New("connection closed")
+		return 0, api.Buffer{}, c.closedErr()
 	}
 	c.mutex.RUnlock()
 
 	// Server-side connections consume data pushed by the reactor into the queue
 	if c.client == nil && c.incoming != nil {
-		return c.readBufferFromIncoming()
+		mt, buf, err := c.readBufferFromIncoming()
+		if c.stream != nil && err == nil {
+			c.stream.read(len(buf.Bytes()))
+		}
+		return mt, buf, err
 	}
 
 	// Use zero-copy receive method with timeout
-	var buffers []api.Buffer
-
 	// Get the underlying connection properly (for clients, this comes from the client instance)
 	wsConn := c.GetUnderlyingWSConnection()
 	if wsConn == nil {
 		return 0, api.Buffer{}, errors.New("no underlying connection available")
 	}
 
-	if c.readTimeout > 0 {
-		if rd, ok := wsConn.Transport().(interface{ SetReadDeadline(time.Time) error }); ok {
-			rd.SetReadDeadline(time.Now().Add(c.readTimeout))
-		}
-	}
+	c.armReadDeadline(c.readTimeout)
 
-	buffers, err = wsConn.RecvZeroCopy()
+	msgs, err := wsConn.RecvMessages()
+	if errors.Is(err, api.ErrTransportClosed) {
+		return 0, api.Buffer{}, c.closedErr()
+	}
 	if err != nil {
 		return 0, api.Buffer{}, err
 	}
 
-	if len(buffers) == 0 {
+	if len(msgs) == 0 {
 		return 0, api.Buffer{}, errors.New("no message received")
 	}
 
-	buf = buffers[0]
+	buf = msgs[0].Buf
 	if c.readLimit > 0 && int64(len(buf.Bytes())) > c.readLimit {
 		buf.Release()
-		return 0, api.Buffer{}, errors.New("message exceeds read limit")
+		return 0, api.Buffer{}, ErrReadLimit
 	}
 
-	return int(BinaryMessage), buf, nil
+	return int(msgs[0].Opcode), buf, nil
 }
 
-// WriteMessage writes a message to the connection.
+// WriteMessage writes a message to the connection, bounded by the write
+// timeout when one is set. It is safe to call from several goroutines: writes
+// are serialized by the connection and each message goes out whole, in the
+// order the calls acquired it. With write coalescing enabled the message may
+// be held back briefly and a failed send is reported by a later write.
 func (c *Conn) WriteMessage(messageType int, data []byte) error {
-	c.mutex.RLock()
-	if c.closed {
-		c.mutex.RUnlock()
-		return errors.New("connection closed")
-	}
-	c.mutex.RUnlock()
-
-	// Client connections delegate directly to the low-level client which handles framing/masking.
-	if c.client != nil {
-		return c.client.WriteMessage(messageType, data)
-	}
-
-	// Get a buffer from the pool for zero-copy sending
-	buf := c.pool.Get(len(data), -1) // Use appropriate NUMA node
-	dest := buf.Bytes()
-	usePool := len(dest) >= len(data)
-
-	if usePool {
-		copy(dest, data)
-	} else {
-		// Pool buffer is smaller than payload; fall back to an owned slice.
-		buf.Release()
-		dest = append([]byte(nil), data...)
-	}
-
-	// Create a frame based on message type
-	var opcode byte
-	switch MessageType(messageType) {
-	case TextMessage:
-		opcode = protocol.OpcodeText
-	case BinaryMessage:
-		opcode = protocol.OpcodeBinary
-	case CloseMessage:
-		opcode = protocol.OpcodeClose
-	case PingMessage:
-		opcode = protocol.OpcodePing
-	case PongMessage:
-		opcode = protocol.OpcodePong
-	default:
-		opcode = protocol.OpcodeBinary // default to binary
-	}
-
-	// Create the frame to send
-	frame := &protocol.WSFrame{
-		IsFinal:    true,
-		Opcode:     opcode,
-		PayloadLen: int64(len(data)),
-		Payload:    dest[:len(data)], // Use the buffer slice directly for zero-copy when possible
-	}
-
-	// Send the frame using the server connection's SendFrame method
-	sendErr := c.underlying.SendFrame(frame)
-
-	// Release the buffer after we're done referencing it
-	if usePool && c.autoRelease {
-		buf.Release()
-	}
+	return c.writeMessage(messageType, data, protocol.PriorityNormal)
+}
 
-	return sendErr
+// WriteMessagePriority writes a message like WriteMessage in the send lane
+// of p: a PriorityHigh message overtakes normal messages still queued, e.g.
+// a cancellation behind a bulk transfer. Control messages always use the
+// control lane. Write coalescing and client connections send in call order.
+func (c *Conn) WriteMessagePriority(messageType int, data []byte, p protocol.Priority) error {
+	return c.writeMessage(messageType, data, p)
 }
 
 // Close closes the connection.
@@ -262,15 +342,19 @@ func (c *Conn) Close() error {
 	c.closeOnce.Do(func() {
 		c.mutex.Lock()
 		c.closed = true
+		if c.asyncStop != nil {
+			close(c.asyncStop)
+		}
 		c.mutex.Unlock()
+		c.failRequests()
 
 		// Drain any queued buffers to avoid leaks
 		if c.incoming != nil {
 			for {
 				select {
-				case buf := <-c.incoming:
-					if buf.Data != nil {
-						buf.Release()
+				case msg := <-c.incoming:
+					if msg.buf.Data != nil {
+						msg.buf.Release()
 					}
 				default:
 					goto drained
@@ -278,10 +362,12 @@ func (c *Conn) Close() error {
 			}
 		}
 	drained:
+		c.flushBeforeClose(true)
 
-		// Close the underlying connection
-		wsConn := c.GetUnderlyingWSConnection()
-		if wsConn != nil {
+		// Close the underlying connection, or just the channel of a Mux
+		if c.stream != nil {
+			c.stream.close(true)
+		} else if wsConn := c.GetUnderlyingWSConnection(); wsConn != nil {
 			err = wsConn.Close()
 		}
 
@@ -294,6 +380,59 @@ func (c *Conn) Close() error {
 	return err
 }
 
+// CloseWithCode sends a close frame with code and reason, e.g. an application
+// code in the 4000-4999 range, and then closes the connection.
+func (c *Conn) CloseWithCode(code uint16, reason string) error {
+	c.flushBeforeClose(true)
+	var err error
+	if c.stream != nil {
+		// Channels have no close handshake of their own.
+	} else if c.client != nil {
+		err = c.client.WriteMessage(int(CloseMessage), protocol.NewCloseFrame(code, reason).Payload)
+	} else if ws := c.GetUnderlyingWSConnection(); ws != nil {
+		err = ws.CloseWithCode(code, reason)
+	}
+	if cerr := c.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// goAway queues a close frame with code and reason behind any coalesced or
+// queued writes, see protocol.WSConnection.SendClose, and leaves the
+// connection open for the peer's answer. Writes fail from then on. The
+// frames still queued must be written by ctx's deadline. A connection with
+// reading paused would never see the answer, so it is closed at once. Client
+// and channel connections have nothing to send.
+func (c *Conn) goAway(ctx context.Context, code uint16, reason string) error {
+	if c.ReadingPaused() {
+		return c.CloseWithCode(code, reason)
+	}
+	if c.stream != nil || c.client != nil {
+		return nil
+	}
+	ws := c.GetUnderlyingWSConnection()
+	if ws == nil {
+		return nil
+	}
+	if d, ok := ctx.Deadline(); ok {
+		ws.SetWriteDeadline(d)
+	}
+	c.flushBeforeClose(false)
+	return ws.SendClose(code, reason)
+}
+
+// closeAfterSend closes the connection once its queued writes, coalesced
+// ones included, are written, see protocol.WSConnection.CloseAfterSend.
+func (c *Conn) closeAfterSend() {
+	c.flushBeforeClose(false)
+	if ws := c.GetUnderlyingWSConnection(); ws != nil && c.stream == nil && c.client == nil {
+		ws.CloseAfterSend()
+		return
+	}
+	c.Close()
+}
+
 // SetReadLimit sets the maximum size for incoming messages.
 func (c *Conn) SetReadLimit(limit int64) {
 	c.mutex.Lock()
@@ -301,6 +440,30 @@ func (c *Conn) SetReadLimit(limit int64) {
 	c.mutex.Unlock()
 }
 
+// PauseReading stops reading from the peer, e.g. while a downstream queue is
+// full, so the sender is held back by TCP flow control rather than messages
+// buffering in memory. Messages received before the pause are still returned
+// by ReadMessage. The connection's reader goroutine stays parked meanwhile;
+// see protocol.WSConnection.PauseReading.
+func (c *Conn) PauseReading() {
+	if ws := c.GetUnderlyingWSConnection(); ws != nil {
+		ws.PauseReading()
+	}
+}
+
+// ResumeReading undoes PauseReading.
+func (c *Conn) ResumeReading() {
+	if ws := c.GetUnderlyingWSConnection(); ws != nil {
+		ws.ResumeReading()
+	}
+}
+
+// ReadingPaused reports whether reading is paused.
+func (c *Conn) ReadingPaused() bool {
+	ws := c.GetUnderlyingWSConnection()
+	return ws != nil && ws.ReadingPaused()
+}
+
 // SetReadDeadline sets the read deadline.
 func (c *Conn) SetReadDeadline(t time.Time) error {
 	c.mutex.Lock()
@@ -318,14 +481,16 @@ func (c *Conn) SetReadDeadline(t time.Time) error {
 	return nil
 }
 
-// SetWriteDeadline sets the write deadline.
+// SetWriteDeadline sets the write deadline. On server connections it bounds
+// each following write by t, including the time its frames wait in the send
+// queue, or by the server's write timeout if that comes first.
 func (c *Conn) SetWriteDeadline(t time.Time) error {
-	c.mutex.Lock()
-	c.writeTimeout = time.Until(t)
-	c.mutex.Unlock()
-
-	// Apply deadline to the underlying transport if it supports it
 	conn := c.GetUnderlyingWSConnection()
+	if conn != nil && c.client == nil && c.stream == nil {
+		conn.SetWriteDeadline(t)
+		return nil
+	}
+	// Apply deadline to the underlying transport if it supports it
 	if conn != nil {
 		transport := conn.Transport()
 		if deadlineSetter, ok := transport.(interface{ SetWriteDeadline(time.Time) error }); ok {
@@ -341,30 +506,24 @@ func (c *Conn) GetClient() *client.Client {
 }
 
 // writeMessage writes a message to the connection (internal implementation).
-func (c *Conn) writeMessage(messageType int, data []byte) error {
+func (c *Conn) writeMessage(messageType int, data []byte, priority protocol.Priority) error {
 	c.mutex.RLock()
 	if c.closed {
 		c.mutex.RUnlock()
-		return errors.New("connection closed")
+		return ErrClosed
 	}
 	c.mutex.RUnlock()
 
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.stream != nil {
+		return c.stream.write(messageType, data)
+	}
+
 	// Client connections can delegate directly to the low-level client to avoid buffer size mismatches.
 	if c.client != nil {
-		if c.writeTimeout > 0 {
-			done := make(chan error, 1)
-			go func() {
-				done <- c.client.WriteMessage(messageType, data)
-			}()
-
-			select {
-			case err := <-done:
-				return err
-			case <-time.After(c.writeTimeout):
-				return errors.New("write timeout")
-			}
-		}
-		return c.client.WriteMessage(messageType, data)
+		return c.writeClient(messageType, data, api.Buffer{})
 	}
 
 	// Get a buffer from the pool for zero-copy sending
@@ -379,58 +538,117 @@ func (c *Conn) writeMessage(messageType int, data []byte) error {
 		dest = append([]byte(nil), data...)
 	}
 
-	// Create a frame based on message type
-	var opcode byte
+	// Create the frame to send
+	frame := &protocol.WSFrame{
+		IsFinal:    true,
+		Opcode:     frameOpcode(messageType),
+		PayloadLen: int64(len(data)),
+		Payload:    dest[:len(data)], // Use the buffer slice directly for zero-copy
+		Priority:   priority,
+	}
+	if usePool && c.autoRelease {
+		// SendFrame queues the frame; it releases the buffer once encoded.
+		frame.Buf = buf
+	}
+	return c.sendFrame(frame)
+}
+
+// WriteBuffer sends buf as one message without copying it, e.g. a buffer
+// returned by ReadBuffer in an echo or relay handler. It takes ownership of
+// buf, which is released once encoded or when the write fails; the caller
+// must not use or release buf after the call. Writes are serialized with
+// WriteMessage.
+func (c *Conn) WriteBuffer(messageType int, buf api.Buffer) error {
+	c.mutex.RLock()
+	if c.closed {
+		c.mutex.RUnlock()
+		buf.Release()
+		return ErrClosed
+	}
+	c.mutex.RUnlock()
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.stream != nil {
+		defer buf.Release()
+		return c.stream.write(messageType, buf.Bytes())
+	}
+	if c.client != nil {
+		return c.writeClient(messageType, buf.Bytes(), buf)
+	}
+	payload := buf.Bytes()
+	return c.sendFrame(&protocol.WSFrame{
+		IsFinal:    true,
+		Opcode:     frameOpcode(messageType),
+		PayloadLen: int64(len(payload)),
+		Payload:    payload,
+		Buf:        buf,
+	})
+}
+
+// writeClient writes data through the low-level client, which encodes it
+// before returning, and then releases buf. The transport write deadline set
+// by SetWriteDeadline bounds the flush of the batch holding data. Callers
+// hold writeMu.
+func (c *Conn) writeClient(messageType int, data []byte, buf api.Buffer) error {
+	defer buf.Release()
+	return c.client.WriteMessage(messageType, data)
+}
+
+// frameOpcode maps a message type to its frame opcode.
+func frameOpcode(messageType int) byte {
 	switch MessageType(messageType) {
 	case TextMessage:
-		opcode = protocol.OpcodeText
+		return protocol.OpcodeText
 	case BinaryMessage:
-		opcode = protocol.OpcodeBinary
+		return protocol.OpcodeBinary
 	case CloseMessage:
-		opcode = protocol.OpcodeClose
+		return protocol.OpcodeClose
 	case PingMessage:
-		opcode = protocol.OpcodePing
+		return protocol.OpcodePing
 	case PongMessage:
-		opcode = protocol.OpcodePong
-	default:
-		opcode = protocol.OpcodeBinary // default to binary
+		return protocol.OpcodePong
 	}
+	return protocol.OpcodeBinary // default to binary
+}
 
-	// Create the frame to send
-	frame := &protocol.WSFrame{
-		IsFinal:    true,
-		Opcode:     opcode,
-		PayloadLen: int64(len(data)),
-		Payload:    dest[:len(data)], // Use the buffer slice directly for zero-copy
-	}
+// sendFrame hands a server frame to the coalescer or the connection. The
+// connection's send loop enforces the write timeout, see
+// protocol.WSConnection.SetWriteTimeout. Callers hold writeMu.
+func (c *Conn) sendFrame(frame *protocol.WSFrame) error {
+	payloadLen := frame.PayloadLen
 
-	// Send the frame using the appropriate connection method with timeout
 	var sendErr error
-	// Use server connection's SendFrame method with timeout handling
-	if c.writeTimeout > 0 {
-		done := make(chan error, 1)
-		go func() {
-			done <- c.underlying.SendFrame(frame)
-		}()
-
-		select {
-		case sendErr = <-done:
-			// Message sent, continue
-		case <-time.After(c.writeTimeout):
-			sendErr = errors.New("write timeout")
-		}
+	if c.coalescer != nil {
+		sendErr = c.queueFrame(frame)
 	} else {
 		sendErr = c.underlying.SendFrame(frame)
 	}
 
-	// Release the buffer after we're done referencing it
-	if usePool && c.autoRelease {
-		buf.Release()
+	if m := c.metrics.Load(); m != nil && sendErr == nil {
+		m.messagesOut.Inc()
+		m.bytesOut.Add(uint64(payloadLen))
 	}
-
 	return sendErr
 }
 
+// armReadDeadline sets a read deadline d from now on the underlying
+// transport, when d is positive and the transport supports deadlines. Write
+// deadlines are stamped on each frame by the send loop instead.
+func (c *Conn) armReadDeadline(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	ws := c.GetUnderlyingWSConnection()
+	if ws == nil {
+		return
+	}
+	if ds, ok := ws.Transport().(interface{ SetReadDeadline(time.Time) error }); ok {
+		ds.SetReadDeadline(time.Now().Add(d))
+	}
+}
+
 // SetCloseCallback sets a function to be called when the connection closes.
 func (c *Conn) SetCloseCallback(callback func()) {
 	c.mutex.Lock()
@@ -438,21 +656,20 @@ func (c *Conn) SetCloseCallback(callback func()) {
 	c.mutex.Unlock()
 }
 
-// enqueueIncoming adds an inbound buffer to the queue for server-side reads.
-func (c *Conn) enqueueIncoming(buf api.Buffer) {
+// enqueueIncoming adds an inbound buffer and its message type to the queue
+// for server-side reads.
+func (c *Conn) enqueueIncoming(messageType MessageType, buf api.Buffer) {
 	if c.incoming == nil {
 		buf.Release()
 		return
 	}
+	msg := inboundMessage{messageType: messageType, buf: buf}
 
-	var done <-chan struct{}
-	if ws := c.GetUnderlyingWSConnection(); ws != nil {
-		done = ws.Done()
-	}
+	done := c.readDone()
 
 	// Fast path: try non-blocking enqueue first.
 	select {
-	case c.incoming <- buf:
+	case c.incoming <- msg:
 		return
 	default:
 	}
@@ -460,7 +677,7 @@ func (c *Conn) enqueueIncoming(buf api.Buffer) {
 	// Overflow path: spill into a dedicated worker queue to avoid stalling poller.
 	c.startOverflowWorker(done)
 	select {
-	case c.overflow <- buf:
+	case c.overflow <- msg:
 	case <-done:
 		buf.Release()
 	}
@@ -474,17 +691,17 @@ func (c *Conn) startOverflowWorker(done <-chan struct{}) {
 		if capacity < 2048 {
 			capacity = 2048
 		}
-		c.overflow = make(chan api.Buffer, capacity)
+		c.overflow = make(chan inboundMessage, capacity)
 		go func() {
 			for {
 				select {
-				case buf := <-c.overflow:
+				case msg := <-c.overflow:
 					for {
 						select {
-						case c.incoming <- buf:
+						case c.incoming <- msg:
 							goto next
 						case <-done:
-							buf.Release()
+							msg.buf.Release()
 							return
 						}
 					}
@@ -492,8 +709,8 @@ func (c *Conn) startOverflowWorker(done <-chan struct{}) {
 					// Drain any pending buffers to release them.
 					for {
 						select {
-						case b := <-c.overflow:
-							b.Release()
+						case m := <-c.overflow:
+							m.buf.Release()
 						default:
 							return
 						}
@@ -506,48 +723,92 @@ func (c *Conn) startOverflowWorker(done <-chan struct{}) {
 }
 
 // runHandlerOnce ensures the provided handler is started only once per connection.
+// The server's running-handler count covers it from before it starts until it returns.
 func (c *Conn) runHandlerOnce(handler func(*Conn)) {
 	c.handlerOnce.Do(func() {
-		go handler(c)
+		if c.running == nil {
+			go func() {
+				defer c.recoverHandler()
+				handler(c)
+			}()
+			return
+		}
+		c.running.Add(1)
+		go func() {
+			defer c.running.Add(-1)
+			defer c.recoverHandler()
+			handler(c)
+		}()
 	})
 }
 
+// readDone is closed once no more messages will be queued for c: when its
+// Mux channel or else its connection closes.
+func (c *Conn) readDone() <-chan struct{} {
+	if c.stream != nil {
+		return c.stream.done
+	}
+	if ws := c.GetUnderlyingWSConnection(); ws != nil {
+		return ws.Done()
+	}
+	return nil
+}
+
 // readBufferFromIncoming pulls a buffer from the inbound queue respecting deadlines.
 func (c *Conn) readBufferFromIncoming() (int, api.Buffer, error) {
 	var timer *time.Timer
 	if c.readTimeout > 0 {
 		timer = time.NewTimer(c.readTimeout)
 		defer timer.Stop()
+		c.armReadDeadline(c.readTimeout)
 	}
 
-	var done <-chan struct{}
-	if ws := c.GetUnderlyingWSConnection(); ws != nil {
-		done = ws.Done()
-	}
+	done := c.readDone()
 
 	if timer != nil {
 		select {
-		case buf := <-c.incoming:
-			if buf.Data == nil {
-				return 0, api.Buffer{}, errors.New("connection closed")
+		case msg := <-c.incoming:
+			if msg.buf.Data == nil {
+				return 0, api.Buffer{}, c.closedErr()
 			}
-			return int(BinaryMessage), buf, nil
+			return c.checkReadLimit(msg)
 		case <-timer.C:
 			return 0, api.Buffer{}, errors.New("read timeout")
 		case <-done:
-			return 0, api.Buffer{}, errors.New("connection closed")
+			return 0, api.Buffer{}, c.closedErr()
 		}
 	}
 
 	select {
-	case buf := <-c.incoming:
-		if buf.Data == nil {
-			return 0, api.Buffer{}, errors.New("connection closed")
+	case msg := <-c.incoming:
+		if msg.buf.Data == nil {
+			return 0, api.Buffer{}, c.closedErr()
 		}
-		return int(BinaryMessage), buf, nil
+		return c.checkReadLimit(msg)
 	case <-done:
-		return 0, api.Buffer{}, errors.New("connection closed")
+		return 0, api.Buffer{}, c.closedErr()
+	}
+}
+
+// closedErr is the error of a read on a closed connection: an
+// *api.CloseError once a close frame was sent or received, else ErrClosed.
+func (c *Conn) closedErr() error {
+	if ws := c.GetUnderlyingWSConnection(); ws != nil {
+		if code, reason, ok := ws.CloseStatus(); ok {
+			return &api.CloseError{Code: code, Reason: reason}
+		}
 	}
+	return ErrClosed
+}
+
+// checkReadLimit returns a dequeued message, or an error releasing its buffer
+// when it exceeds the read limit.
+func (c *Conn) checkReadLimit(msg inboundMessage) (int, api.Buffer, error) {
+	if c.readLimit > 0 && int64(len(msg.buf.Bytes())) > c.readLimit {
+		msg.buf.Release()
+		return 0, api.Buffer{}, ErrReadLimit
+	}
+	return int(msg.messageType), msg.buf, nil
 }
 
 // Param gets the value of a parameter by name.
@@ -560,6 +821,17 @@ func (c *Conn) Param(name string) string {
 	return ""
 }
 
+// ParamInt returns the named route parameter as an int, e.g. for ":id<int>".
+func (c *Conn) ParamInt(name string) (int, error) {
+	return strconv.Atoi(c.Param(name))
+}
+
+// ParamUUID returns the named route parameter parsed from the canonical
+// 8-4-4-4-12 form, e.g. for ":id<uuid>".
+func (c *Conn) ParamUUID(name string) ([16]byte, error) {
+	return parseUUID(c.Param(name))
+}
+
 // AllParams returns all route parameters.
 func (c *Conn) AllParams() map[string]string {
 	result := make(map[string]string, len(c.params))
@@ -592,8 +864,13 @@ func (c *Conn) LocalAddr() string {
 	return "localhost"
 }
 
-// RemoteAddr returns the remote network address.
+// RemoteAddr returns the remote network address, or "" if the transport
+// does not know it.
 func (c *Conn) RemoteAddr() string {
-	// Placeholder - would return actual remote address
-	return "remote"
+	if ws := c.GetUnderlyingWSConnection(); ws != nil {
+		if addr := ws.RemoteAddr(); addr != nil {
+			return addr.String()
+		}
+	}
+	return ""
 }
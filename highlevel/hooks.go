@@ -0,0 +1,157 @@
+// Package hioload provides a high-level WebSocket library built on top of hioload-ws primitives.
+package highlevel
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/control"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// lifecycleHooks holds the callbacks registered with OnConnect, OnDisconnect,
+// OnError and OnPanic, guarded by Server.handlerMux.
+type lifecycleHooks struct {
+	connect    []func(*Conn)
+	disconnect []func(c *Conn, code uint16, d time.Duration)
+	errors     []func(c *Conn, err error)
+	panics     []func(c *Conn, recovered any, stack []byte)
+}
+
+// OnConnect registers fn to run when a connection opens, before the route
+// handler sees its first message. Callbacks run in registration order on the
+// event loop and should return quickly.
+func (s *Server) OnConnect(fn func(*Conn)) {
+	s.handlerMux.Lock()
+	s.hooks.connect = append(s.hooks.connect, fn)
+	s.handlerMux.Unlock()
+}
+
+// OnDisconnect registers fn to run once a connection has closed, with the
+// close code received or sent (1006 when none was) and how long the
+// connection was open.
+func (s *Server) OnDisconnect(fn func(c *Conn, code uint16, d time.Duration)) {
+	s.handlerMux.Lock()
+	s.hooks.disconnect = append(s.hooks.disconnect, fn)
+	s.handlerMux.Unlock()
+}
+
+// OnError registers fn to run when a connection ends on a read or protocol
+// error rather than a clean close; OnDisconnect callbacks follow.
+func (s *Server) OnError(fn func(c *Conn, err error)) {
+	s.handlerMux.Lock()
+	s.hooks.errors = append(s.hooks.errors, fn)
+	s.handlerMux.Unlock()
+}
+
+// OnPanic registers fn to run when a route handler, middleware or lifecycle
+// callback panics, with the recovered value and the stack at the panic. The
+// panic never reaches the process: the connection is closed with 1011 first.
+// c is nil when the connection is no longer known, e.g. for a panic in an
+// OnDisconnect callback.
+func (s *Server) OnPanic(fn func(c *Conn, recovered any, stack []byte)) {
+	s.handlerMux.Lock()
+	s.hooks.panics = append(s.hooks.panics, fn)
+	s.handlerMux.Unlock()
+}
+
+// handlePanic runs the OnPanic callbacks for a panic recovered on c.
+func (s *Server) handlePanic(c *Conn, recovered any, stack []byte) {
+	s.handlerMux.RLock()
+	panics := s.hooks.panics
+	s.handlerMux.RUnlock()
+	for _, fn := range panics {
+		fn(c, recovered, stack)
+	}
+}
+
+// handleReactorPanic maps a panic the underlying server recovered on the
+// event loop to the Conn of its connection.
+func (s *Server) handleReactorPanic(wsConn *protocol.WSConnection, recovered any, stack []byte) {
+	var hlConn *Conn
+	if wsConn != nil {
+		s.connStoreMu.RLock()
+		hlConn = s.connStore[wsConn]
+		s.connStoreMu.RUnlock()
+	}
+	s.handlePanic(hlConn, recovered, stack)
+}
+
+// recoverHandler is deferred around a route handler running on its own
+// goroutine: it closes c with 1011, logs the panic and runs OnPanic.
+func (c *Conn) recoverHandler() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	stack := debug.Stack()
+	c.CloseWithCode(protocol.CloseInternalServerErr, "internal error")
+	control.Logger(control.LogServer).Error("handler panic", "panic", fmt.Sprint(r), "route", c.route, "stack", string(stack))
+	if c.onPanic != nil {
+		c.onPanic(c, r, stack)
+	}
+}
+
+// handleOpen creates the Conn of a newly upgraded connection, runs the
+// OnConnect callbacks and starts its route handler, or the fallback handler
+// of an unrouted one, so handlers may write before the client does.
+func (s *Server) handleOpen(evt api.OpenEvent) {
+	wsConn, ok := evt.Conn.(*protocol.WSConnection)
+	if !ok {
+		return
+	}
+	route, params := s.findHandler(wsConn.VirtualHost(), wsConn.Path(), GET)
+	hlConn := s.getOrCreateConn(wsConn, route, params)
+
+	s.handlerMux.RLock()
+	connect := s.hooks.connect
+	s.handlerMux.RUnlock()
+	for _, fn := range connect {
+		fn(hlConn)
+	}
+
+	var handler func(*Conn)
+	if route != nil {
+		handler = route.Handler
+	} else if fb, miss := s.routeMiss(wsConn.VirtualHost(), wsConn.Path()); miss {
+		handler = fb.Handler
+	}
+	if handler != nil {
+		handler = s.applyMiddleware(handler)
+		hlConn.runHandlerOnce(func(conn *Conn) {
+			handler(conn)
+		})
+	}
+}
+
+// handleClose forgets a closed connection and runs the OnError and
+// OnDisconnect callbacks.
+func (s *Server) handleClose(evt api.CloseEvent) {
+	wsConn, ok := evt.Conn.(*protocol.WSConnection)
+	if !ok {
+		return
+	}
+	s.connStoreMu.Lock()
+	hlConn := s.connStore[wsConn]
+	delete(s.connStore, wsConn)
+	s.connStoreMu.Unlock()
+	if hlConn == nil {
+		return
+	}
+	hlConn.Close()
+
+	s.handlerMux.RLock()
+	hooks := s.hooks
+	s.handlerMux.RUnlock()
+	if evt.Err != nil {
+		for _, fn := range hooks.errors {
+			fn(hlConn, evt.Err)
+		}
+	}
+	d := time.Since(hlConn.openedAt)
+	for _, fn := range hooks.disconnect {
+		fn(hlConn, evt.Code, d)
+	}
+}
@@ -0,0 +1,286 @@
+// Package hioload provides a high-level WebSocket library built on top of hioload-ws primitives.
+package highlevel
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256" // SHA-256 for HS256, RS256, PS256, ES256
+	_ "crypto/sha512" // SHA-384 and SHA-512
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/momentics/hioload-ws/lowlevel/server"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// JWTClaimsKey is the session attribute JWTAuth stores the verified Claims
+// under.
+const JWTClaimsKey = "jwt.claims"
+
+// Claims are the payload of a verified JSON Web Token, decoded as by
+// encoding/json: numbers are float64.
+type Claims map[string]any
+
+// Subject returns the "sub" claim.
+func (c Claims) Subject() string {
+	s, _ := c["sub"].(string)
+	return s
+}
+
+// JWTHeader is the decoded header of a JSON Web Token.
+type JWTHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// JWTOptions configures JWTAuth.
+type JWTOptions struct {
+	// Key verifies signatures: []byte for HS256/384/512, *rsa.PublicKey for
+	// RS* and PS*, *ecdsa.PublicKey for ES256/384/512, ed25519.PublicKey for
+	// EdDSA.
+	Key any
+	// KeyFunc returns the key for a token, e.g. by its "kid" header, in
+	// place of Key.
+	KeyFunc func(JWTHeader) (any, error)
+	// Algorithms lists the accepted "alg" values; by default those of the
+	// key's type. "none" is never accepted.
+	Algorithms []string
+
+	Issuer   string        // required "iss" claim, if set
+	Audience string        // required in the "aud" claim, if set
+	Leeway   time.Duration // clock skew allowed for "exp" and "nbf"
+
+	// Header carries the token as "Bearer <token>", "Authorization" by
+	// default; QueryParam carries it for browsers, which cannot set headers
+	// on upgrades, "access_token" by default.
+	Header     string
+	QueryParam string
+
+	// Skip exempts upgrade requests, e.g. to public routes.
+	Skip func(*http.Request) bool
+}
+
+// JWTAuth requires a valid JSON Web Token on every upgrade request and
+// refuses the others with 401 Unauthorized and the reason "invalid token"
+// before the 101 response; why a token failed is only logged. The
+// verified claims are stored on the connection's session under JWTClaimsKey
+// for handlers, see JWTClaims.
+func JWTAuth(opts JWTOptions) ServerOption {
+	if opts.Header == "" {
+		opts.Header = "Authorization"
+	}
+	if opts.QueryParam == "" {
+		opts.QueryParam = "access_token"
+	}
+	check := func(req *http.Request) error {
+		if opts.Skip != nil && opts.Skip(req) {
+			return nil
+		}
+		claims, err := verifyJWT(jwtFromRequest(req, opts), opts, time.Now())
+		if err != nil {
+			// The client learns nothing about why: the detail stays in the log.
+			middlewareLog.Info("jwt rejected", "remote", req.RemoteAddr, "error", err)
+			return &protocol.HandshakeRejection{Status: http.StatusUnauthorized, Reason: "invalid token"}
+		}
+		if sess := server.HandshakeSession(req); sess != nil {
+			sess.Set(JWTClaimsKey, claims)
+		}
+		return nil
+	}
+	return func(s *Server) {
+		s.opts = append(s.opts, server.WithHandshakeCheck(check))
+	}
+}
+
+// JWTClaims returns the claims JWTAuth verified for c.
+func JWTClaims(c *Conn) (Claims, bool) {
+	sess := c.Session()
+	if sess == nil {
+		return nil, false
+	}
+	v, _ := sess.Get(JWTClaimsKey)
+	claims, ok := v.(Claims)
+	return claims, ok
+}
+
+// jwtFromRequest returns the bearer token of req's header, else its query
+// parameter.
+func jwtFromRequest(req *http.Request, opts JWTOptions) string {
+	if h := req.Header.Get(opts.Header); len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return req.URL.Query().Get(opts.QueryParam)
+}
+
+var (
+	errJWTMissing   = errors.New("missing token")
+	errJWTMalformed = errors.New("malformed token")
+	errJWTAlgorithm = errors.New("token algorithm not accepted")
+	errJWTSignature = errors.New("invalid token signature")
+	errJWTExpired   = errors.New("token expired")
+	errJWTNotYet    = errors.New("token not valid yet")
+	errJWTIssuer    = errors.New("token issuer not accepted")
+	errJWTAudience  = errors.New("token audience not accepted")
+)
+
+// verifyJWT checks the signature and registered claims of token at now.
+func verifyJWT(token string, opts JWTOptions, now time.Time) (Claims, error) {
+	if token == "" {
+		return nil, errJWTMissing
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errJWTMalformed
+	}
+	var header JWTHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, errJWTMalformed
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errJWTMalformed
+	}
+
+	key := opts.Key
+	if opts.KeyFunc != nil {
+		if key, err = opts.KeyFunc(header); err != nil {
+			return nil, err
+		}
+	}
+	algs := opts.Algorithms
+	if algs == nil {
+		algs = jwtAlgorithmsFor(key)
+	}
+	if header.Alg == "none" || !slices.Contains(algs, header.Alg) {
+		return nil, errJWTAlgorithm
+	}
+	if !verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], sig) {
+		return nil, errJWTSignature
+	}
+
+	var claims Claims
+	if err := decodeJWTPart(parts[1], &claims); err != nil || claims == nil {
+		return nil, errJWTMalformed
+	}
+	if exp, ok := claims["exp"].(float64); ok && now.After(unixTime(exp).Add(opts.Leeway)) {
+		return nil, errJWTExpired
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Before(unixTime(nbf).Add(-opts.Leeway)) {
+		return nil, errJWTNotYet
+	}
+	if opts.Issuer != "" && claims["iss"] != opts.Issuer {
+		return nil, errJWTIssuer
+	}
+	if opts.Audience != "" && !hasAudience(claims["aud"], opts.Audience) {
+		return nil, errJWTAudience
+	}
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func unixTime(sec float64) time.Time {
+	return time.Unix(0, int64(sec*float64(time.Second)))
+}
+
+// hasAudience reports whether the "aud" claim, a string or an array of
+// strings, contains want.
+func hasAudience(aud any, want string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == want
+	case []any:
+		for _, v := range a {
+			if v == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jwtAlgorithmsFor returns the algorithms a key of this type verifies.
+func jwtAlgorithmsFor(key any) []string {
+	switch key.(type) {
+	case []byte:
+		return []string{"HS256", "HS384", "HS512"}
+	case *rsa.PublicKey:
+		return []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}
+	case *ecdsa.PublicKey:
+		return []string{"ES256", "ES384", "ES512"}
+	case ed25519.PublicKey:
+		return []string{"EdDSA"}
+	}
+	return nil
+}
+
+// jwtHashes maps the size suffix of an algorithm name to its hash.
+var jwtHashes = map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}
+
+// verifyJWTSignature verifies sig over input with key under alg.
+func verifyJWTSignature(alg string, key any, input string, sig []byte) bool {
+	if alg == "EdDSA" {
+		k, ok := key.(ed25519.PublicKey)
+		return ok && ed25519.Verify(k, []byte(input), sig)
+	}
+	if len(alg) != 5 {
+		return false
+	}
+	hash, ok := jwtHashes[alg[2:]]
+	if !ok {
+		return false
+	}
+	h := hash.New()
+	h.Write([]byte(input))
+
+	switch alg[:2] {
+	case "HS":
+		k, ok := key.([]byte)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(hash.New, k)
+		mac.Write([]byte(input))
+		return hmac.Equal(mac.Sum(nil), sig)
+	case "RS":
+		k, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(k, hash, h.Sum(nil), sig) == nil
+	case "PS":
+		k, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPSS(k, hash, h.Sum(nil), sig, nil) == nil
+	case "ES":
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return false
+		}
+		bits := k.Curve.Params().BitSize
+		if alg == "ES512" && bits != 521 || alg != "ES512" && alg[2:] != strconv.Itoa(bits) {
+			return false // RFC 7518: ES256 is P-256, ES384 P-384, ES512 P-521
+		}
+		size := (bits + 7) / 8
+		if len(sig) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(k, h.Sum(nil), r, s)
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+// Package hioload provides a high-level WebSocket library built on top of hioload-ws primitives.
+package highlevel
+
+import (
+	"github.com/momentics/hioload-ws/api"
+)
+
+// MessageMiddleware intercepts every inbound message on its way to the
+// handler, e.g. for per-message metrics, validation or decompression. It
+// passes the message on by calling next, possibly with another type or a
+// transformed payload, or drops it by returning without calling next.
+// Middleware runs on the goroutine reading the connection, when the handler
+// reads; the payload is only valid until the middleware returns.
+type MessageMiddleware func(c *Conn, messageType MessageType, payload []byte, next func(MessageType, []byte))
+
+// UseMessage adds middleware to the chain run for every inbound message.
+// Middleware added first sees the message first.
+func (s *Server) UseMessage(middleware ...MessageMiddleware) {
+	s.handlerMux.Lock()
+	defer s.handlerMux.Unlock()
+	s.messageMiddleware = append(s.messageMiddleware, middleware...)
+}
+
+// filterMessage runs the message middleware over buf and returns the message
+// the chain passed on, or ok=false when it was dropped and buf released.
+func (c *Conn) filterMessage(messageType MessageType, buf api.Buffer) (MessageType, api.Buffer, bool) {
+	var (
+		outType MessageType
+		out     []byte
+		passed  bool
+	)
+	var call func(i int, mt MessageType, p []byte)
+	call = func(i int, mt MessageType, p []byte) {
+		if i == len(c.messageMiddleware) {
+			outType, out, passed = mt, p, true
+			return
+		}
+		c.messageMiddleware[i](c, mt, p, func(mt MessageType, p []byte) {
+			call(i+1, mt, p)
+		})
+	}
+	call(0, messageType, buf.Bytes())
+	if !passed {
+		buf.Release()
+		return 0, api.Buffer{}, false
+	}
+	return outType, adoptPayload(buf, out), true
+}
+
+// adoptPayload returns a Buffer holding payload, the output of the message
+// middleware for buf: buf itself or a view of it when payload points into
+// buf, otherwise payload alone with buf released.
+func adoptPayload(buf api.Buffer, payload []byte) api.Buffer {
+	orig := buf.Data
+	if cap(payload) == 0 || cap(orig) < cap(payload) ||
+		&orig[:cap(orig)][cap(orig)-1] != &payload[:cap(payload)][cap(payload)-1] {
+		buf.Release()
+		return api.Buffer{Data: payload}
+	}
+	// payload shares buf's backing array: keep the pooled memory alive.
+	from := cap(orig) - cap(payload)
+	if to := from + len(payload); to <= len(orig) {
+		return buf.Slice(from, to)
+	}
+	out := api.Buffer{Data: append([]byte(nil), payload...)}
+	buf.Release()
+	return out
+}
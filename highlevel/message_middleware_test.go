@@ -0,0 +1,41 @@
+// Package highlevel provides tests for message middleware payload handling.
+package highlevel
+
+import (
+	"testing"
+
+	"github.com/momentics/hioload-ws/api"
+)
+
+type countingReleaser struct{ puts int }
+
+func (r *countingReleaser) Put(api.Buffer) { r.puts++ }
+
+func TestAdoptPayload(t *testing.T) {
+	data := []byte("cmd:run!")
+	for _, tc := range []struct {
+		name     string
+		held     []byte // the pooled buffer's view
+		payload  []byte
+		want     string
+		released bool
+	}{
+		{"same", data, data, "cmd:run!", false},
+		{"view", data, data[4:7], "run", false},
+		{"beyond length", data[:6], data[4:8], "run!", true},
+		{"foreign", data, []byte("other"), "other", true},
+		{"empty", data, nil, "", true},
+	} {
+		r := &countingReleaser{}
+		out := adoptPayload(api.Buffer{Data: tc.held, Pool: r}, tc.payload)
+		if string(out.Bytes()) != tc.want {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.want, out.Bytes())
+		}
+		if released := r.puts == 1; released != tc.released {
+			t.Errorf("%s: expected released=%v, got %d puts", tc.name, tc.released, r.puts)
+		}
+		if !tc.released && out.Pool == nil {
+			t.Errorf("%s: expected the pooled buffer kept", tc.name)
+		}
+	}
+}
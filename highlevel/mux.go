@@ -0,0 +1,345 @@
+// Package hioload provides a high-level WebSocket library built on top of hioload-ws primitives.
+package highlevel
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+// Mux messages are binary messages prefixed with a 5-byte header: one kind
+// byte followed by a big-endian uint32 channel ID. Open and window messages
+// carry a big-endian uint32 byte count. Like the correlated kinds, the bytes
+// are UTF-8 continuation bytes.
+const (
+	muxText   byte = 0xB6
+	muxBinary byte = 0xB7
+	muxOpen   byte = 0xB8
+	muxClose  byte = 0xB9
+	muxWindow byte = 0xBA
+	muxHeader      = 5
+)
+
+// DefaultMuxWindow is the per-channel receive window used when NewMux is
+// given none.
+const DefaultMuxWindow = 256 << 10
+
+// muxAcceptBacklog bounds the channels opened by the peer and not yet
+// accepted; further opens are refused.
+const muxAcceptBacklog = 64
+
+var (
+	// ErrChannelInUse is returned by Mux.Open for a channel ID already open.
+	ErrChannelInUse = errors.New("mux: channel already open")
+	// ErrChannelMessageType is returned when a channel is asked to write a
+	// message other than text or binary.
+	ErrChannelMessageType = errors.New("mux: channels carry only text and binary messages")
+)
+
+// Mux carries independent logical channels over one connection, e.g. one per
+// widget of a dashboard. Each channel is a Conn of its own with per-channel
+// flow control: a side may only send while it holds credit from the peer's
+// receive window, which is replenished as the peer's application reads, so a
+// channel whose reader stalls does not hold up the others.
+//
+// Channel IDs are chosen by the application; both sides may open channels,
+// so they must agree on which IDs each uses. Once a Mux owns a connection it
+// must not be read or written directly; other messages are discarded.
+type Mux struct {
+	conn   *Conn
+	window int
+
+	mu      sync.Mutex
+	streams map[uint32]*muxStream
+
+	accept    chan *Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// muxStream is the state of one channel, shared by the Mux and the channel's
+// Conn.
+type muxStream struct {
+	mux  *Mux
+	id   uint32
+	conn *Conn
+
+	mu       sync.Mutex
+	credit   int           // bytes this side may still send
+	granted  chan struct{} // closed and replaced when credit is granted
+	consumed int           // bytes read since the last window update
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewMux multiplexes channels over c with a receive window of window bytes
+// per channel (0 = DefaultMuxWindow) and starts reading c.
+func NewMux(c *Conn, window int) *Mux {
+	if window <= 0 {
+		window = DefaultMuxWindow
+	}
+	m := &Mux{
+		conn:    c,
+		window:  window,
+		streams: make(map[uint32]*muxStream),
+		accept:  make(chan *Conn, muxAcceptBacklog),
+		done:    make(chan struct{}),
+	}
+	go m.readLoop()
+	return m
+}
+
+// Open opens channel id. Writes on it wait until the peer has seen the open
+// and granted its window.
+func (m *Mux) Open(id uint32) (*Conn, error) {
+	m.mu.Lock()
+	select {
+	case <-m.done:
+		m.mu.Unlock()
+		return nil, ErrClosed
+	default:
+	}
+	if _, ok := m.streams[id]; ok {
+		m.mu.Unlock()
+		return nil, ErrChannelInUse
+	}
+	s := m.newStream(id, 0)
+	m.mu.Unlock()
+	if err := m.send(muxOpen, id, windowPayload(m.window)); err != nil {
+		s.close(false)
+		return nil, err
+	}
+	return s.conn, nil
+}
+
+// Accept returns the next channel opened by the peer.
+func (m *Mux) Accept() (*Conn, error) {
+	select {
+	case c := <-m.accept:
+		return c, nil
+	case <-m.done:
+		return nil, ErrClosed
+	}
+}
+
+// Close closes every channel and the connection.
+func (m *Mux) Close() error {
+	m.shutdown()
+	return m.conn.Close()
+}
+
+// Done is closed once the mux has stopped, when Close was called or the
+// connection failed.
+func (m *Mux) Done() <-chan struct{} {
+	return m.done
+}
+
+// Channel returns the mux channel ID of c and whether c is a channel.
+func (c *Conn) Channel() (id uint32, ok bool) {
+	if c.stream == nil {
+		return 0, false
+	}
+	return c.stream.id, true
+}
+
+// newStream registers channel id with credit bytes of send window. Callers
+// hold m.mu.
+func (m *Mux) newStream(id uint32, credit int) *muxStream {
+	s := &muxStream{mux: m, id: id, credit: credit, granted: make(chan struct{}), done: make(chan struct{})}
+	parent := m.conn
+	s.conn = &Conn{
+		underlying:  parent.GetUnderlyingWSConnection(),
+		pool:        parent.pool,
+		readLimit:   parent.readLimit,
+		autoRelease: true,
+		incoming:    make(chan inboundMessage, 128),
+		params:      parent.params,
+		route:       parent.route,
+		stream:      s,
+	}
+	m.streams[id] = s
+	return s
+}
+
+// readLoop dispatches the connection's mux messages until it fails.
+func (m *Mux) readLoop() {
+	defer m.shutdown()
+	for {
+		_, buf, err := m.conn.ReadBuffer()
+		if err != nil {
+			return
+		}
+		data := buf.Bytes()
+		if len(data) < muxHeader {
+			buf.Release()
+			continue
+		}
+		kind, id := data[0], binary.BigEndian.Uint32(data[1:muxHeader])
+		m.mu.Lock()
+		s := m.streams[id]
+		m.mu.Unlock()
+
+		switch kind {
+		case muxText, muxBinary:
+			if s == nil {
+				buf.Release()
+				continue
+			}
+			mt := BinaryMessage
+			if kind == muxText {
+				mt = TextMessage
+			}
+			s.conn.enqueueIncoming(mt, buf.Slice(muxHeader, len(data)))
+			continue
+		case muxOpen:
+			m.opened(s, id, windowValue(data))
+		case muxWindow:
+			if s != nil {
+				s.grant(windowValue(data))
+			}
+		case muxClose:
+			if s != nil {
+				s.close(false)
+			}
+		}
+		buf.Release()
+	}
+}
+
+// opened handles an open of channel id: the peer's answer to Open when the
+// channel exists, otherwise a new channel to accept, answered with this
+// side's window.
+func (m *Mux) opened(s *muxStream, id uint32, window int) {
+	if s != nil {
+		s.grant(window)
+		return
+	}
+	m.mu.Lock()
+	s = m.newStream(id, window)
+	m.mu.Unlock()
+	select {
+	case m.accept <- s.conn:
+		m.send(muxOpen, id, windowPayload(m.window))
+	default:
+		s.close(true)
+	}
+}
+
+// shutdown stops the mux and closes every channel without notifying the
+// peer.
+func (m *Mux) shutdown() {
+	m.closeOnce.Do(func() {
+		m.mu.Lock()
+		close(m.done)
+		streams := make([]*muxStream, 0, len(m.streams))
+		for _, s := range m.streams {
+			streams = append(streams, s)
+		}
+		m.mu.Unlock()
+		for _, s := range streams {
+			s.close(false)
+		}
+	})
+}
+
+// send writes a mux message on the connection.
+func (m *Mux) send(kind byte, id uint32, payload []byte) error {
+	return m.conn.WriteMessage(int(BinaryMessage), muxMessage(kind, id, payload))
+}
+
+// write sends a message on the channel once it holds send credit. A message
+// larger than the remaining credit is sent whole and overdraws it.
+func (s *muxStream) write(messageType int, data []byte) error {
+	var kind byte
+	switch MessageType(messageType) {
+	case TextMessage:
+		kind = muxText
+	case BinaryMessage:
+		kind = muxBinary
+	default:
+		return ErrChannelMessageType
+	}
+	for {
+		s.mu.Lock()
+		if s.credit > 0 {
+			s.credit -= len(data)
+			s.mu.Unlock()
+			break
+		}
+		granted := s.granted
+		s.mu.Unlock()
+		select {
+		case <-granted:
+		case <-s.done:
+			return ErrClosed
+		}
+	}
+	select {
+	case <-s.done:
+		return ErrClosed
+	default:
+	}
+	return s.mux.send(kind, s.id, data)
+}
+
+// grant adds n bytes of send credit and wakes waiting writers.
+func (s *muxStream) grant(n int) {
+	s.mu.Lock()
+	s.credit += n
+	close(s.granted)
+	s.granted = make(chan struct{})
+	s.mu.Unlock()
+}
+
+// read records n bytes read by the application and returns them to the peer
+// as credit once half the window has been consumed.
+func (s *muxStream) read(n int) {
+	s.mu.Lock()
+	s.consumed += n
+	if s.consumed < s.mux.window/2 {
+		s.mu.Unlock()
+		return
+	}
+	n, s.consumed = s.consumed, 0
+	s.mu.Unlock()
+	s.mux.send(muxWindow, s.id, windowPayload(n))
+}
+
+// close ends the channel, telling the peer if notify is set.
+func (s *muxStream) close(notify bool) {
+	s.closeOnce.Do(func() {
+		m := s.mux
+		m.mu.Lock()
+		if m.streams[s.id] == s {
+			delete(m.streams, s.id)
+		}
+		m.mu.Unlock()
+		close(s.done)
+		if notify {
+			m.send(muxClose, s.id, nil)
+		}
+	})
+}
+
+// muxMessage builds a mux message.
+func muxMessage(kind byte, id uint32, payload []byte) []byte {
+	msg := make([]byte, muxHeader+len(payload))
+	msg[0] = kind
+	binary.BigEndian.PutUint32(msg[1:], id)
+	copy(msg[muxHeader:], payload)
+	return msg
+}
+
+// windowPayload encodes a window size or increment.
+func windowPayload(n int) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(n))
+}
+
+// windowValue decodes the byte count of an open or window message.
+func windowValue(msg []byte) int {
+	if len(msg) < muxHeader+4 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(msg[muxHeader:]))
+}
@@ -0,0 +1,44 @@
+// Package hioload provides a high-level WebSocket library built on top of hioload-ws primitives.
+package highlevel
+
+import (
+	"unicode/utf8"
+
+	"github.com/momentics/hioload-ws/pubsub"
+)
+
+// Subscribe forwards messages published on b under topics matching pattern
+// to this connection until it closes or the returned Subscription is
+// unsubscribed. Valid UTF-8 payloads are sent as text messages, anything
+// else as binary. Queue size and overflow policy come from opts.
+func (c *Conn) Subscribe(b *pubsub.Broker, pattern string, opts ...pubsub.SubscribeOption) (*pubsub.Subscription, error) {
+	sub, err := b.Subscribe(pattern, opts...)
+	if err != nil {
+		return nil, err
+	}
+	var done <-chan struct{}
+	if ws := c.GetUnderlyingWSConnection(); ws != nil {
+		done = ws.Done()
+	}
+	go func() {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case msg, ok := <-sub.C():
+				if !ok {
+					return
+				}
+				mt := BinaryMessage
+				if utf8.Valid(msg.Payload) {
+					mt = TextMessage
+				}
+				if err := c.WriteMessage(int(mt), msg.Payload); err != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
@@ -0,0 +1,124 @@
+// Package hioload provides a high-level WebSocket library built on top of hioload-ws primitives.
+package highlevel
+
+import (
+	"net"
+	"sync"
+
+	"github.com/momentics/hioload-ws/protocol"
+	"github.com/momentics/hioload-ws/ratelimit"
+)
+
+// RateLimit is a token bucket admitting Rate events per second with bursts
+// of up to Burst, by default the rate rounded up. A zero Rate disables it.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimitStore holds the per-client token buckets of RateLimitMiddleware.
+// Passing one store to several middleware instances or servers makes them
+// charge the same buckets; an implementation backed by a shared database
+// extends the limits across processes.
+type RateLimitStore interface {
+	// Allow takes one token from the bucket of key, created with limit on
+	// first use, and reports whether one was available.
+	Allow(key string, limit RateLimit) bool
+}
+
+// memoryStore is the in-memory RateLimitStore: one keyed limiter per limit.
+type memoryStore struct {
+	limiters sync.Map // RateLimit -> *ratelimit.Limiter
+}
+
+// NewRateLimitStore returns an in-memory RateLimitStore. Idle buckets are
+// evicted once they have refilled.
+func NewRateLimitStore() RateLimitStore {
+	return &memoryStore{}
+}
+
+// Allow implements RateLimitStore.
+func (m *memoryStore) Allow(key string, limit RateLimit) bool {
+	l, ok := m.limiters.Load(limit)
+	if !ok {
+		l, _ = m.limiters.LoadOrStore(limit, ratelimit.New(limit.Rate, limit.Burst))
+	}
+	return l.(*ratelimit.Limiter).Allow(key)
+}
+
+// RateLimitConfig configures RateLimitMiddleware.
+type RateLimitConfig struct {
+	ConnectionsPerIP RateLimit // new connections per client
+	MessagesPerIP    RateLimit // messages per client, over all its connections
+	MessagesPerConn  RateLimit // messages per connection
+
+	// Store holds the per-client buckets; nil for a store private to the
+	// middleware.
+	Store RateLimitStore
+
+	// KeyFunc identifies the client, e.g. from a header set by a proxy;
+	// nil for the IP of the remote address.
+	KeyFunc func(*Conn) string
+
+	// CloseOnLimit closes a connection exceeding a message limit with 1008
+	// Policy Violation. By default excess messages are dropped.
+	CloseOnLimit bool
+}
+
+// RateLimitMiddleware limits connections and inbound messages with token
+// buckets. Like all middleware it runs when the handler starts, on the
+// connection's first message: a connection beyond ConnectionsPerIP is then
+// closed with 1013 Try Again Later instead. Messages beyond MessagesPerIP
+// or MessagesPerConn are dropped before the handler reads them, or close the
+// connection with CloseOnLimit. To refuse upgrades outright, limit
+// handshakes with server.Config.RateLimit.
+func RateLimitMiddleware(cfg RateLimitConfig) Middleware {
+	store := cfg.Store
+	if store == nil {
+		store = NewRateLimitStore()
+	}
+	key := cfg.KeyFunc
+	if key == nil {
+		key = remoteIP
+	}
+	perConn := ratelimit.New(cfg.MessagesPerConn.Rate, cfg.MessagesPerConn.Burst)
+
+	return func(next func(*Conn)) func(*Conn) {
+		return func(conn *Conn) {
+			client := key(conn)
+			if cfg.ConnectionsPerIP.Rate > 0 && !store.Allow("conn|"+client, cfg.ConnectionsPerIP) {
+				conn.CloseWithCode(protocol.CloseTryAgainLater, "rate limit exceeded")
+				return
+			}
+			if cfg.MessagesPerIP.Rate <= 0 && cfg.MessagesPerConn.Rate <= 0 {
+				next(conn)
+				return
+			}
+
+			id := conn.RemoteAddr()
+			if sess := conn.Session(); sess != nil {
+				id = sess.ID()
+			}
+			defer perConn.Forget(id)
+			limit := func(c *Conn, mt MessageType, p []byte, next func(MessageType, []byte)) {
+				if (cfg.MessagesPerIP.Rate <= 0 || store.Allow("msg|"+client, cfg.MessagesPerIP)) && perConn.Allow(id) {
+					next(mt, p)
+				} else if cfg.CloseOnLimit {
+					c.CloseWithCode(protocol.ClosePolicyViolation, "rate limit exceeded")
+				}
+			}
+			// Charged first, so dropped messages cost no other middleware.
+			conn.messageMiddleware = append([]MessageMiddleware{limit}, conn.messageMiddleware...)
+			next(conn)
+		}
+	}
+}
+
+// remoteIP returns the host part of the connection's remote address.
+func remoteIP(c *Conn) string {
+	addr := c.RemoteAddr()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,308 @@
+// Package hioload provides a high-level WebSocket library built on top of hioload-ws primitives.
+package highlevel
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/momentics/hioload-ws/lowlevel/client"
+)
+
+// Reliable messages are binary messages prefixed with a 9-byte header: one
+// kind byte followed by a big-endian uint64 message ID; an ack is the header
+// alone. Like the correlated kinds, the bytes are UTF-8 continuation bytes.
+const (
+	reliableKind   byte = 0xB4
+	ackKind        byte = 0xB5
+	reliableHeader      = 9
+)
+
+// ErrQueueFull is returned by Reliable.Send when MaxPending messages are
+// awaiting acknowledgment.
+var ErrQueueFull = errors.New("reliable: too many unacknowledged messages")
+
+// ErrReliableClosed is returned by Reliable.Send after Close.
+var ErrReliableClosed = errors.New("reliable: closed")
+
+// ReliableOptions configures a Reliable sender.
+type ReliableOptions struct {
+	// MaxPending bounds the unacknowledged messages kept for resending
+	// (0 = 1024).
+	MaxPending int
+	// Dir, if set, persists unacknowledged messages as one file each, so a
+	// new Reliable on the same Dir resends what a previous one left behind.
+	Dir string
+	// RetryInterval is the pause between reconnect attempts (0 = 1s).
+	RetryInterval time.Duration
+	// OnMessage receives the messages the server sends that are not acks.
+	OnMessage func(messageType int, data []byte)
+}
+
+// Reliable sends messages at least once: every message gets an ID and is
+// kept until the server acknowledges it with Conn.Ack. When the connection
+// drops, Reliable redials and resends the unacknowledged messages in ID
+// order, so the server may see a message more than once.
+type Reliable struct {
+	url      string
+	dialOpts []DialOption
+	opts     ReliableOptions
+
+	mu         sync.Mutex
+	conn       *Conn
+	pending    map[uint64][]byte
+	nextID     uint64
+	dialed     bool // a connection has been attached before
+	reconnects uint64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewReliable starts a reliable sender for url, dialed with dialOpts. It does
+// not wait for the connection: messages sent while offline are queued and
+// delivered once it is up. It fails only if opts.Dir cannot be loaded.
+func NewReliable(url string, opts ReliableOptions, dialOpts ...DialOption) (*Reliable, error) {
+	if opts.MaxPending <= 0 {
+		opts.MaxPending = 1024
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = time.Second
+	}
+	r := &Reliable{url: url, dialOpts: dialOpts, opts: opts, pending: make(map[uint64][]byte)}
+	if opts.Dir != "" {
+		if err := r.load(); err != nil {
+			return nil, err
+		}
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	r.wg.Add(1)
+	go r.run()
+	return r, nil
+}
+
+// Send queues payload, writes it if connected and returns its ID. The
+// message is kept, and resent after reconnects, until it is acknowledged.
+func (r *Reliable) Send(payload []byte) (uint64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ctx.Err() != nil {
+		return 0, ErrReliableClosed
+	}
+	if len(r.pending) >= r.opts.MaxPending {
+		return 0, ErrQueueFull
+	}
+	id := r.nextID + 1
+	msg := append([]byte(nil), payload...)
+	if err := r.persist(id, msg); err != nil {
+		return 0, err
+	}
+	r.nextID = id
+	r.pending[id] = msg
+	if r.conn != nil {
+		// A failed write is retried after the reconnect.
+		r.conn.WriteMessage(int(BinaryMessage), reliableMessage(reliableKind, id, msg))
+	}
+	return id, nil
+}
+
+// Pending returns the number of messages awaiting acknowledgment.
+func (r *Reliable) Pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.pending)
+}
+
+// Connected reports whether the sender currently has a connection.
+func (r *Reliable) Connected() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.conn != nil
+}
+
+// Stats returns the figures of the current connection, zero while offline,
+// with the number of reconnects so far.
+func (r *Reliable) Stats() client.Stats {
+	r.mu.Lock()
+	conn, reconnects := r.conn, r.reconnects
+	r.mu.Unlock()
+	var s client.Stats
+	if conn != nil && conn.client != nil {
+		s = conn.client.Stats()
+	}
+	s.Reconnects = reconnects
+	return s
+}
+
+// Close stops reconnecting and closes the connection. Unacknowledged
+// messages stay in Dir, if set.
+func (r *Reliable) Close() error {
+	r.mu.Lock()
+	r.cancel()
+	conn := r.conn
+	r.mu.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+	r.wg.Wait()
+	return nil
+}
+
+// run keeps a connection up until Close, dialing every RetryInterval while
+// offline.
+func (r *Reliable) run() {
+	defer r.wg.Done()
+	for {
+		conn, err := DialContext(r.ctx, r.url, r.dialOpts...)
+		if err != nil {
+			clientLog.Debug("reliable dial failed", "url", r.url, "error", err)
+		} else if r.attach(conn) {
+			r.readLoop(conn)
+			r.mu.Lock()
+			r.conn = nil
+			r.mu.Unlock()
+			conn.Close()
+		}
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-time.After(r.opts.RetryInterval):
+		}
+	}
+}
+
+// attach makes conn current and resends the pending messages on it. It
+// reports false, closing conn, if the sender was closed meanwhile.
+func (r *Reliable) attach(conn *Conn) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ctx.Err() != nil {
+		conn.Close()
+		return false
+	}
+	r.conn = conn
+	if r.dialed {
+		r.reconnects++
+	}
+	r.dialed = true
+	ids := make([]uint64, 0, len(r.pending))
+	for id := range r.pending {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	for _, id := range ids {
+		conn.WriteMessage(int(BinaryMessage), reliableMessage(reliableKind, id, r.pending[id]))
+	}
+	return true
+}
+
+// readLoop consumes acks and hands other messages to OnMessage until conn
+// fails.
+func (r *Reliable) readLoop(conn *Conn) {
+	for {
+		mt, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if len(data) == reliableHeader && data[0] == ackKind {
+			r.ack(binary.BigEndian.Uint64(data[1:]))
+			continue
+		}
+		if r.opts.OnMessage != nil {
+			r.opts.OnMessage(mt, data)
+		}
+	}
+}
+
+// ack forgets message id.
+func (r *Reliable) ack(id uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.pending[id]; !ok {
+		return // duplicate ack of a resent message
+	}
+	delete(r.pending, id)
+	if r.opts.Dir != "" {
+		os.Remove(r.path(id))
+	}
+}
+
+// persist writes message id to Dir, if set.
+func (r *Reliable) persist(id uint64, msg []byte) error {
+	if r.opts.Dir == "" {
+		return nil
+	}
+	if err := os.WriteFile(r.path(id), msg, 0o600); err != nil {
+		return fmt.Errorf("reliable: persist: %w", err)
+	}
+	return nil
+}
+
+// load restores the messages persisted in Dir, creating it if needed, and
+// continues numbering after the highest ID found.
+func (r *Reliable) load() error {
+	if err := os.MkdirAll(r.opts.Dir, 0o700); err != nil {
+		return fmt.Errorf("reliable: %w", err)
+	}
+	entries, err := os.ReadDir(r.opts.Dir)
+	if err != nil {
+		return fmt.Errorf("reliable: %w", err)
+	}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".msg")
+		if !ok {
+			continue
+		}
+		id, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		msg, err := os.ReadFile(r.path(id))
+		if err != nil {
+			return fmt.Errorf("reliable: %w", err)
+		}
+		r.pending[id] = msg
+		r.nextID = max(r.nextID, id)
+	}
+	return nil
+}
+
+// path names the file of message id; the zero padding keeps IDs in order.
+func (r *Reliable) path(id uint64) string {
+	return filepath.Join(r.opts.Dir, fmt.Sprintf("%020d.msg", id))
+}
+
+// ParseReliable splits a message sent by Reliable into its ID and payload.
+// It reports false for any other message.
+func ParseReliable(msg []byte) (id uint64, payload []byte, ok bool) {
+	if len(msg) < reliableHeader || msg[0] != reliableKind {
+		return 0, nil, false
+	}
+	return binary.BigEndian.Uint64(msg[1:reliableHeader]), msg[reliableHeader:], true
+}
+
+// Ack acknowledges the reliable message id, received through ParseReliable,
+// so the sender stops resending it. Since a message can arrive again before
+// its ack reaches the sender, handlers that must not repeat work should
+// remember the IDs they have processed.
+func (c *Conn) Ack(id uint64) error {
+	return c.WriteMessage(int(BinaryMessage), reliableMessage(ackKind, id, nil))
+}
+
+// reliableMessage builds a reliable message or, with kind ackKind, an ack.
+func reliableMessage(kind byte, id uint64, payload []byte) []byte {
+	msg := make([]byte, reliableHeader+len(payload))
+	msg[0] = kind
+	binary.BigEndian.PutUint64(msg[1:], id)
+	copy(msg[reliableHeader:], payload)
+	return msg
+}
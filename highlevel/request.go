@@ -0,0 +1,219 @@
+// Package hioload provides a high-level WebSocket library built on top of hioload-ws primitives.
+package highlevel
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+)
+
+// Correlated messages are binary messages prefixed with a 5-byte header:
+// one kind byte followed by a big-endian uint32 correlation ID. The kind
+// bytes are UTF-8 continuation bytes, so no text message can be mistaken for
+// one even where the opcode is not preserved.
+const (
+	requestKind       byte = 0xB1
+	responseKind      byte = 0xB2
+	errorResponseKind byte = 0xB3
+	correlationHeader      = 5
+)
+
+// DefaultRequestTimeout bounds Request calls whose context has no deadline.
+const DefaultRequestTimeout = 30 * time.Second
+
+// ErrRequestClosed is returned by pending requests when the connection closes.
+var ErrRequestClosed = errors.New("request: connection closed")
+
+// RequestError is returned by Request when the peer's handler failed.
+type RequestError struct {
+	Message string
+}
+
+// Error implements error.
+func (e *RequestError) Error() string {
+	return "request: remote error: " + e.Message
+}
+
+// RequestHandler answers a correlated request; a returned error is sent to
+// the caller as a RequestError.
+type RequestHandler func(ctx context.Context, payload []byte) ([]byte, error)
+
+// requestTable holds the per-connection correlation state.
+type requestTable struct {
+	mu      sync.Mutex
+	pending map[uint32]chan requestReply
+	closed  bool
+	nextID  atomic.Uint32
+	handler atomic.Pointer[RequestHandler]
+}
+
+type requestReply struct {
+	payload []byte
+	err     error
+}
+
+// Request sends payload to the peer and waits for the correlated response.
+// Responses are picked up by the connection's read path, so a goroutine must
+// be reading (ReadMessage) for Request to complete; correlated messages are
+// consumed there and never returned to the reader.
+func (c *Conn) Request(ctx context.Context, payload []byte) ([]byte, error) {
+	t := &c.requests
+	id := t.nextID.Add(1)
+	ch := make(chan requestReply, 1)
+
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil, ErrRequestClosed
+	}
+	if t.pending == nil {
+		t.pending = make(map[uint32]chan requestReply)
+	}
+	t.pending[id] = ch
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		delete(t.pending, id)
+		t.mu.Unlock()
+	}()
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultRequestTimeout)
+		defer cancel()
+	}
+	if err := c.WriteMessage(int(BinaryMessage), correlated(requestKind, id, payload)); err != nil {
+		return nil, err
+	}
+
+	var done <-chan struct{}
+	if ws := c.GetUnderlyingWSConnection(); ws != nil {
+		done = ws.Done()
+	}
+	select {
+	case r := <-ch:
+		return r.payload, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-done:
+		return nil, ErrRequestClosed
+	}
+}
+
+// OnRequest installs the handler for correlated requests from the peer; each
+// request runs on its own goroutine. Without a handler, request messages are
+// returned to the reader like any other message.
+func (c *Conn) OnRequest(h RequestHandler) {
+	c.requests.handler.Store(&h)
+}
+
+// PendingRequests returns the number of Request calls awaiting a response.
+func (c *Conn) PendingRequests() int {
+	c.requests.mu.Lock()
+	defer c.requests.mu.Unlock()
+	return len(c.requests.pending)
+}
+
+// handleCorrelated consumes buf if it is a correlated message this
+// connection is waiting for or serving, releasing it; it reports whether buf
+// was consumed.
+func (c *Conn) handleCorrelated(buf api.Buffer) bool {
+	data := buf.Bytes()
+	if len(data) < correlationHeader {
+		return false
+	}
+	kind, id := data[0], binary.BigEndian.Uint32(data[1:correlationHeader])
+	body := data[correlationHeader:]
+
+	switch kind {
+	case requestKind:
+		h := c.requests.handler.Load()
+		if h == nil {
+			return false
+		}
+		payload := append([]byte(nil), body...)
+		buf.Release()
+		go c.serveRequest(*h, id, payload)
+		return true
+
+	case responseKind, errorResponseKind:
+		t := &c.requests
+		t.mu.Lock()
+		ch, ok := t.pending[id]
+		t.mu.Unlock()
+		if !ok {
+			// Late answers to calls that already timed out are dropped;
+			// anything else was never ours and goes to the reader.
+			if id == 0 || id > t.nextID.Load() {
+				return false
+			}
+			buf.Release()
+			return true
+		}
+		var r requestReply
+		if kind == responseKind {
+			r.payload = append([]byte(nil), body...)
+		} else {
+			r.err = &RequestError{Message: string(body)}
+		}
+		buf.Release()
+		select {
+		case ch <- r:
+		default: // duplicate response
+		}
+		return true
+	}
+	return false
+}
+
+// serveRequest runs h and writes its response. The context is cancelled
+// when the connection closes.
+func (c *Conn) serveRequest(h RequestHandler, id uint32, payload []byte) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if ws := c.GetUnderlyingWSConnection(); ws != nil {
+		go func() {
+			select {
+			case <-ws.Done():
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	resp, err := h(ctx, payload)
+	if err != nil {
+		c.WriteMessage(int(BinaryMessage), correlated(errorResponseKind, id, []byte(err.Error())))
+		return
+	}
+	c.WriteMessage(int(BinaryMessage), correlated(responseKind, id, resp))
+}
+
+// failRequests fails every pending Request with ErrRequestClosed.
+func (c *Conn) failRequests() {
+	t := &c.requests
+	t.mu.Lock()
+	t.closed = true
+	for id, ch := range t.pending {
+		select {
+		case ch <- requestReply{err: ErrRequestClosed}:
+		default:
+		}
+		delete(t.pending, id)
+	}
+	t.mu.Unlock()
+}
+
+// correlated builds a correlated message.
+func correlated(kind byte, id uint32, payload []byte) []byte {
+	msg := make([]byte, correlationHeader+len(payload))
+	msg[0] = kind
+	binary.BigEndian.PutUint32(msg[1:], id)
+	copy(msg[correlationHeader:], payload)
+	return msg
+}
@@ -0,0 +1,82 @@
+// Package hioload provides a high-level WebSocket library built on top of hioload-ws primitives.
+package highlevel
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/control"
+)
+
+// routeMetrics are the figures MetricsMiddleware records for one route.
+type routeMetrics struct {
+	active      atomic.Int64
+	connections control.Counter
+	messagesIn  control.Counter
+	messagesOut control.Counter
+	bytesIn     control.Counter
+	bytesOut    control.Counter
+	latency     *control.LatencyHistogram
+}
+
+// routeMetricsByRoute maps route patterns to their *routeMetrics.
+var routeMetricsByRoute sync.Map
+
+// metricsFor returns the figures of route, creating them on first use.
+func metricsFor(route string) *routeMetrics {
+	if m, ok := routeMetricsByRoute.Load(route); ok {
+		return m.(*routeMetrics)
+	}
+	m, _ := routeMetricsByRoute.LoadOrStore(route, &routeMetrics{latency: control.NewLatencyHistogram()})
+	return m.(*routeMetrics)
+}
+
+// labelEscaper escapes Prometheus label values.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// RouteMetrics returns the per-route figures MetricsMiddleware records, as a
+// control.StatsFunc for control.PrometheusHandler, the admin server or a
+// MetricsPusher. Keys carry the route pattern as a label, e.g.
+// `route.messages_in{route="/chat/:room"}`: route.connections_active is a
+// gauge; route.connections, route.messages_in, route.messages_out,
+// route.bytes_in and route.bytes_out are counters; route.handler_latency is
+// the time the handler spends on each message, from reading it to reading
+// the next one or returning.
+func RouteMetrics() map[string]any {
+	out := make(map[string]any)
+	routeMetricsByRoute.Range(func(k, v any) bool {
+		label := `{route="` + labelEscaper.Replace(k.(string)) + `"}`
+		m := v.(*routeMetrics)
+		out["route.connections_active"+label] = m.active.Load()
+		out["route.connections"+label] = &m.connections
+		out["route.messages_in"+label] = &m.messagesIn
+		out["route.messages_out"+label] = &m.messagesOut
+		out["route.bytes_in"+label] = &m.bytesIn
+		out["route.bytes_out"+label] = &m.bytesOut
+		out["route.handler_latency"+label] = m.latency
+		return true
+	})
+	return out
+}
+
+// countInbound records a message handed to the handler.
+func (c *Conn) countInbound(m *routeMetrics, buf api.Buffer) {
+	if m == nil {
+		return
+	}
+	atomic.AddInt64(&globalTotalMsgs, 1)
+	m.messagesIn.Inc()
+	m.bytesIn.Add(uint64(len(buf.Bytes())))
+	c.delivered.Store(time.Now().UnixNano())
+}
+
+// observeHandled records the handler latency of the last message delivered,
+// if it has not been recorded yet.
+func (c *Conn) observeHandled(m *routeMetrics) {
+	if t := c.delivered.Swap(0); t != 0 {
+		m.latency.Record(time.Duration(time.Now().UnixNano() - t))
+	}
+}
@@ -0,0 +1,257 @@
+// Package hioload provides a high-level WebSocket library built on top of hioload-ws primitives.
+package highlevel
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// router matches paths against registered patterns such as
+// "/users/:id<int>/messages" or "/files/*filepath". At every segment a static
+// child is tried before parameter children (constrained ones in registration
+// order, then the unconstrained one), and a catch-all last; a failed branch
+// backtracks to the next candidate, so the most specific route always wins
+// regardless of registration order. A lookup is O(len(path)) while no branch
+// fails; backtracking makes the worst case grow with the number of
+// overlapping candidates at each segment.
+// Conflicting registrations panic, like an invalid pattern would.
+type router struct {
+	root   *routeNode
+	routes map[string]*RouteHandler // pattern -> last registered handler
+}
+
+// routeNode is one path segment in the trie.
+type routeNode struct {
+	static     map[string]*routeNode
+	params     []*routeNode
+	wildcard   *routeNode
+	paramName  string
+	constraint paramConstraint
+	pattern    string
+	handlers   []*RouteHandler
+}
+
+// paramConstraint restricts the values a parameter segment accepts. Specs are
+// "int", "uuid" or a regular expression matched against the whole segment.
+type paramConstraint struct {
+	spec  string // "" when unconstrained
+	match func(string) bool
+}
+
+// parseParam splits a ":name<spec>" segment into its name and constraint.
+func parseParam(seg, pattern string) (string, paramConstraint) {
+	name, spec, constrained := strings.Cut(seg[1:], "<")
+	if constrained {
+		if !strings.HasSuffix(spec, ">") || len(spec) == 1 {
+			panic(fmt.Sprintf("highlevel: malformed constraint in %q of route %q", seg, pattern))
+		}
+		spec = spec[:len(spec)-1]
+	}
+	if name == "" {
+		panic(fmt.Sprintf("highlevel: empty parameter name in route %q", pattern))
+	}
+	switch spec {
+	case "":
+		return name, paramConstraint{}
+	case "int":
+		return name, paramConstraint{spec: spec, match: isIntParam}
+	case "uuid":
+		return name, paramConstraint{spec: spec, match: isUUIDParam}
+	}
+	re, err := regexp.Compile("^(?:" + spec + ")$")
+	if err != nil {
+		panic(fmt.Sprintf("highlevel: invalid constraint in %q of route %q: %v", seg, pattern, err))
+	}
+	return name, paramConstraint{spec: spec, match: re.MatchString}
+}
+
+func isIntParam(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+func isUUIDParam(s string) bool {
+	_, err := parseUUID(s)
+	return err == nil
+}
+
+// parseUUID parses the canonical 8-4-4-4-12 hexadecimal form.
+func parseUUID(s string) ([16]byte, error) {
+	var u [16]byte
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return u, fmt.Errorf("invalid UUID %q", s)
+	}
+	j := 0
+	for i := 0; i < len(s); i += 2 {
+		if s[i] == '-' {
+			i--
+			continue
+		}
+		hi, ok1 := fromHex(s[i])
+		lo, ok2 := fromHex(s[i+1])
+		if !ok1 || !ok2 {
+			return u, fmt.Errorf("invalid UUID %q", s)
+		}
+		u[j] = hi<<4 | lo
+		j++
+	}
+	return u, nil
+}
+
+func fromHex(c byte) (byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0', true
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10, true
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+func newRouter() *router {
+	return &router{root: &routeNode{}, routes: make(map[string]*RouteHandler)}
+}
+
+// splitPath returns the segments of a path without its leading slash, so "/"
+// is a single empty segment and a trailing slash is significant.
+func splitPath(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, "/"), "/")
+}
+
+// add registers h for pattern, panicking on an invalid or conflicting route.
+func (r *router) add(pattern string, h *RouteHandler) {
+	n := r.root
+	segs := splitPath(pattern)
+	for i, seg := range segs {
+		if strings.HasPrefix(seg, "*") {
+			name := seg[1:]
+			if name == "" || i != len(segs)-1 {
+				panic(fmt.Sprintf("highlevel: catch-all %q must be named and last in route %q", seg, pattern))
+			}
+			if n.wildcard == nil {
+				n.wildcard = &routeNode{paramName: name}
+			} else if n.wildcard.paramName != name {
+				panic(fmt.Sprintf("highlevel: catch-all *%s in route %q conflicts with *%s",
+					name, pattern, n.wildcard.paramName))
+			}
+			n = n.wildcard
+			break
+		}
+		if strings.HasPrefix(seg, ":") {
+			name, c := parseParam(seg, pattern)
+			child := n.paramChild(name, c)
+			if child.paramName != name {
+				panic(fmt.Sprintf("highlevel: parameter %q in route %q conflicts with :%s",
+					seg, pattern, child.paramName))
+			}
+			n = child
+			continue
+		}
+		child, ok := n.static[seg]
+		if !ok {
+			if n.static == nil {
+				n.static = make(map[string]*routeNode)
+			}
+			child = &routeNode{}
+			n.static[seg] = child
+		}
+		n = child
+	}
+
+	for _, existing := range n.handlers {
+		for _, m := range methodsOf(h) {
+			if isMethodAllowed(m, existing.Methods) {
+				panic(fmt.Sprintf("highlevel: route %s %q conflicts with %q", m, pattern, n.pattern))
+			}
+		}
+	}
+	n.pattern = pattern
+	n.handlers = append(n.handlers, h)
+	r.routes[pattern] = h
+}
+
+// paramChild returns the parameter child of n with constraint c, creating it
+// if needed. Constrained children precede the unconstrained one.
+func (n *routeNode) paramChild(name string, c paramConstraint) *routeNode {
+	for _, child := range n.params {
+		if child.constraint.spec == c.spec {
+			return child
+		}
+	}
+	child := &routeNode{paramName: name, constraint: c}
+	n.params = append(n.params, child)
+	if last := len(n.params) - 1; c.spec != "" && last > 0 && n.params[last-1].constraint.spec == "" {
+		n.params[last-1], n.params[last] = child, n.params[last-1]
+	}
+	return child
+}
+
+// anyMethod makes lookup match a path regardless of the registered methods.
+const anyMethod HTTPMethod = ""
+
+// lookup returns the handler for path and method with its parameters, or nil.
+func (r *router) lookup(path string, method HTTPMethod) (*RouteHandler, []RouteParam) {
+	var params []RouteParam
+	if h := r.root.match(splitPath(path), method, &params); h != nil {
+		return h, params
+	}
+	return nil, nil
+}
+
+// match descends segs depth-first, static before parameter before catch-all,
+// retrying the next candidate when a branch finds no handler, and returns the
+// handler serving method; params collects the values along the matched branch.
+func (n *routeNode) match(segs []string, method HTTPMethod, params *[]RouteParam) *RouteHandler {
+	if len(segs) == 0 {
+		return n.handlerFor(method)
+	}
+	seg, rest := segs[0], segs[1:]
+	if child, ok := n.static[seg]; ok {
+		if found := child.match(rest, method, params); found != nil {
+			return found
+		}
+	}
+	if seg != "" {
+		for _, child := range n.params {
+			if child.constraint.match != nil && !child.constraint.match(seg) {
+				continue
+			}
+			mark := len(*params)
+			*params = append(*params, RouteParam{Key: child.paramName, Value: seg})
+			if found := child.match(rest, method, params); found != nil {
+				return found
+			}
+			*params = (*params)[:mark]
+		}
+	}
+	if n.wildcard != nil {
+		if h := n.wildcard.handlerFor(method); h != nil {
+			// The remainder keeps its inner slashes: "/files/a/b" yields "a/b".
+			*params = append(*params, RouteParam{Key: n.wildcard.paramName, Value: strings.Join(segs, "/")})
+			return h
+		}
+	}
+	return nil
+}
+
+// handlerFor returns the handler at n accepting method, or nil.
+func (n *routeNode) handlerFor(method HTTPMethod) *RouteHandler {
+	for _, h := range n.handlers {
+		if method == anyMethod || isMethodAllowed(method, h.Methods) {
+			return h
+		}
+	}
+	return nil
+}
+
+// methodsOf returns the methods h is registered for, GET when none are set.
+func methodsOf(h *RouteHandler) []HTTPMethod {
+	if len(h.Methods) == 0 {
+		return []HTTPMethod{GET}
+	}
+	return h.Methods
+}
@@ -0,0 +1,181 @@
+// Package highlevel provides tests for the route trie.
+package highlevel
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestRouterLookup(t *testing.T) {
+	r := newRouter()
+	byRoute := make(map[string]*RouteHandler)
+	routes := []string{
+		"/",
+		"/users",
+		"/users/me",
+		"/users/:id",
+		"/users/:id/messages/:messageId",
+		"/users/me/settings",
+		"/files/v1.0/list",
+		"/files/*filepath",
+		"/topics/:tenant/*topic",
+		"/orders/:id<uuid>",
+		"/orders/:code<[a-z]{3}>",
+		"/orders/:name",
+		"/items/:n<int>/detail",
+	}
+	for _, p := range routes {
+		byRoute["GET "+p] = &RouteHandler{Methods: []HTTPMethod{GET}}
+		r.add(p, byRoute["GET "+p])
+	}
+	byRoute["POST /users/:id"] = &RouteHandler{Methods: []HTTPMethod{POST}}
+	r.add("/users/:id", byRoute["POST /users/:id"])
+
+	for _, tc := range []struct {
+		path    string
+		method  HTTPMethod
+		pattern string
+		params  []RouteParam
+	}{
+		{"/", GET, "/", nil},
+		{"/users", GET, "/users", nil},
+		{"/users/me", GET, "/users/me", nil},
+		{"/users/42", GET, "/users/:id", []RouteParam{{"id", "42"}}},
+		{"/users/42", POST, "/users/:id", []RouteParam{{"id", "42"}}},
+		// Static "me" fails deeper, so the parameter branch is tried.
+		{"/users/me/messages/7", GET, "/users/:id/messages/:messageId", []RouteParam{{"id", "me"}, {"messageId", "7"}}},
+		{"/users/me/settings", GET, "/users/me/settings", nil},
+		{"/files/v1.0/list", GET, "/files/v1.0/list", nil},
+		{"/files/v1x0/list", GET, "/files/*filepath", []RouteParam{{"filepath", "v1x0/list"}}},
+		{"/files/a/b/c.txt", GET, "/files/*filepath", []RouteParam{{"filepath", "a/b/c.txt"}}},
+		{"/files/", GET, "/files/*filepath", []RouteParam{{"filepath", ""}}},
+		{"/files", GET, "", nil},
+		{"/orders/123e4567-e89b-12d3-a456-426614174000", GET, "/orders/:id<uuid>", []RouteParam{{"id", "123e4567-e89b-12d3-a456-426614174000"}}},
+		{"/orders/abc", GET, "/orders/:code<[a-z]{3}>", []RouteParam{{"code", "abc"}}},
+		{"/orders/abcd", GET, "/orders/:name", []RouteParam{{"name", "abcd"}}},
+		{"/items/-12/detail", GET, "/items/:n<int>/detail", []RouteParam{{"n", "-12"}}},
+		{"/items/12x/detail", GET, "", nil},
+		{"/topics/acme/orders/eu", GET, "/topics/:tenant/*topic", []RouteParam{{"tenant", "acme"}, {"topic", "orders/eu"}}},
+		{"/users/", GET, "", nil},
+		{"/users/42/messages", GET, "", nil},
+		{"/users/42", DELETE, "", nil},
+		{"/missing", GET, "", nil},
+	} {
+		h, params := r.lookup(tc.path, tc.method)
+		if tc.pattern == "" {
+			if h != nil {
+				t.Errorf("%s %s: expected no match, got %v", tc.method, tc.path, params)
+			}
+			continue
+		}
+		if h == nil {
+			t.Errorf("%s %s: expected %s, got no match", tc.method, tc.path, tc.pattern)
+			continue
+		}
+		if h != byRoute[string(tc.method)+" "+tc.pattern] {
+			t.Errorf("%s %s: matched the wrong route", tc.method, tc.path)
+		}
+		if !reflect.DeepEqual(params, tc.params) {
+			t.Errorf("%s %s: expected params %v, got %v", tc.method, tc.path, tc.params, params)
+		}
+	}
+}
+
+// TestRouterPrecedence pins the candidate order at a segment, static then
+// constrained parameter then parameter then catch-all, independent of
+// registration order, and the fallback to the next candidate when a more
+// specific branch has no route for the rest of the path.
+func TestRouterPrecedence(t *testing.T) {
+	patterns := []string{
+		"/rooms/*rest",
+		"/rooms/:name/log",
+		"/rooms/:id<int>",
+		"/rooms/lobby",
+		"/rooms/:name",
+	}
+	for _, order := range [][]int{{0, 1, 2, 3, 4}, {4, 3, 2, 1, 0}} {
+		r := newRouter()
+		byPattern := make(map[string]*RouteHandler)
+		for _, i := range order {
+			byPattern[patterns[i]] = &RouteHandler{}
+			r.add(patterns[i], byPattern[patterns[i]])
+		}
+		for _, tc := range []struct{ path, pattern string }{
+			{"/rooms/lobby", "/rooms/lobby"},
+			{"/rooms/7", "/rooms/:id<int>"},
+			{"/rooms/blue", "/rooms/:name"},
+			// No "/rooms/lobby/log" or "/rooms/:id<int>/log": both fall back
+			// to the unconstrained parameter.
+			{"/rooms/lobby/log", "/rooms/:name/log"},
+			{"/rooms/7/log", "/rooms/:name/log"},
+			{"/rooms/7/other", "/rooms/*rest"},
+		} {
+			h, _ := r.lookup(tc.path, GET)
+			if h != byPattern[tc.pattern] {
+				t.Errorf("order %v: %s should match %s", order, tc.path, tc.pattern)
+			}
+		}
+	}
+}
+
+func TestRouterConflicts(t *testing.T) {
+	for _, tc := range []struct {
+		first, second string
+	}{
+		{"/users/:id", "/users/:name"},
+		{"/users/:id", "/users/:id"},
+		{"/chat", "/chat"},
+		{"/x/:", "/y"},
+		{"/files/*path", "/files/*name"},
+		{"/files/*", "/y"},
+		{"/files/*path/more", "/y"},
+		{"/a/:id<int>", "/a/:n<int>"},
+		{"/a/:id<", "/y"},
+		{"/a/:id<[>", "/y"},
+		{"/a/:<int>", "/y"},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected %q after %q to panic", tc.second, tc.first)
+				}
+			}()
+			r := newRouter()
+			r.add(tc.first, &RouteHandler{})
+			r.add(tc.second, &RouteHandler{})
+		}()
+	}
+
+	// The same path with disjoint methods is not a conflict.
+	r := newRouter()
+	r.add("/items/:id", &RouteHandler{Methods: []HTTPMethod{GET}})
+	r.add("/items/:id", &RouteHandler{Methods: []HTTPMethod{PUT, DELETE}})
+}
+
+func TestParseUUID(t *testing.T) {
+	u, err := parseUUID("123E4567-e89b-12d3-a456-426614174000")
+	want := [16]byte{0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3, 0xa4, 0x56, 0x42, 0x66, 0x14, 0x17, 0x40, 0x00}
+	if err != nil || u != want {
+		t.Fatalf("parseUUID = %x, %v", u, err)
+	}
+	for _, bad := range []string{"", "123e4567e89b12d3a456426614174000", "123e4567-e89b-12d3-a456-42661417400g", "123e4567-e89b-12d3-a4560426614174000"} {
+		if _, err := parseUUID(bad); err == nil {
+			t.Errorf("Expected %q rejected", bad)
+		}
+	}
+}
+
+func BenchmarkRouterLookup(b *testing.B) {
+	r := newRouter()
+	for i := 0; i < 5000; i++ {
+		r.add(fmt.Sprintf("/svc%d/users/:id/items/:item", i), &RouteHandler{})
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if h, _ := r.lookup("/svc4999/users/42/items/7", GET); h == nil {
+			b.Fatal("no match")
+		}
+	}
+}
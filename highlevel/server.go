@@ -4,8 +4,11 @@ package highlevel
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"regexp"
+	"net"
+	"net/http"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -13,8 +16,10 @@ import (
 
 	"github.com/momentics/hioload-ws/adapters"
 	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/control"
 	"github.com/momentics/hioload-ws/lowlevel/server"
 	"github.com/momentics/hioload-ws/protocol"
+	"github.com/momentics/hioload-ws/session"
 )
 
 // HTTPMethod represents an HTTP method
@@ -35,6 +40,8 @@ const (
 type RouteHandler struct {
 	Handler func(*Conn)
 	Methods []HTTPMethod
+
+	pattern string
 }
 
 // Middleware is a function that can intercept and process a connection before passing it to the next handler
@@ -44,6 +51,7 @@ type Middleware func(next func(*Conn)) func(*Conn)
 type RouteGroup struct {
 	server *Server
 	prefix string
+	host   string // virtual host the routes serve, "" for all
 }
 
 // Global metrics counters
@@ -55,11 +63,15 @@ var (
 // Server wraps the low-level server with a high-level API.
 type Server struct {
 	addr       string
-	handlers   map[string]*RouteHandler // Exact path handlers with HTTP methods
+	routes     *router            // Path trie of registered handlers
+	hostRoutes map[string]*router // Routes of virtual hosts, see Host
 	handlerMux sync.RWMutex
 	opts       []server.ServerOption
-	// Reference to the underlying server
-	underlying *server.Server
+	// Reference to the underlying server, set by ListenAndServe
+	underlying   *server.Server
+	underlyingMu sync.Mutex
+	// Closed once the underlying server accepts connections, see Ready
+	ready chan struct{}
 	// Store server configuration
 	cfg *server.Config
 	// Context for graceful shutdown
@@ -71,33 +83,59 @@ type Server struct {
 	// Map underlying WS connections to reusable high-level connections
 	connStore   map[*protocol.WSConnection]*Conn
 	connStoreMu sync.RWMutex
-	// Path patterns for route matching
-	patterns map[*regexp.Regexp]*RouteHandler
-	// Route patterns with parameter names (for named parameter extraction)
-	routePatterns map[string][]string // maps pattern to parameter names
-	// Store allowed methods for each pattern (for error responses)
-	patternMethods map[*regexp.Regexp][]HTTPMethod
 	// Middleware chain
 	middleware []Middleware
+	// Per-message middleware chain, see UseMessage
+	messageMiddleware []MessageMiddleware
+	// Sessions bound to live connections (shared with the underlying server)
+	sessions *session.SessionManager
+	// Answers for upgrades no route accepts
+	notFound         Fallback
+	methodNotAllowed Fallback
+	// Lifecycle callbacks
+	hooks lifecycleHooks
+	// Connection handlers currently running, awaited by Shutdown
+	runningHandlers atomic.Int64
+	// Factory of the per-connection value stores
+	contexts api.ContextFactory
+	// Per-connection limits applied to every Conn on creation
+	readLimit    int64
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	// Flush interval of write coalescing, 0 when disabled
+	coalesceInterval time.Duration
+	// Capacity and overflow policy of each connection's async write queue
+	asyncSize   int
+	asyncPolicy WriteOverflowPolicy
+	// Default codec of ReadObject and WriteObject
+	codec Codec
+	// Addresses served alongside addr, see WithListen
+	endpoints []server.Endpoint
 }
 
-// NewServer creates a new high-level WebSocket server.
-func NewServer(addr string) *Server {
+// NewServer creates a new high-level WebSocket server configured by opts.
+func NewServer(addr string, opts ...ServerOption) *Server {
 	ctx, cancel := context.WithCancel(context.Background())
-	return &Server{
-		addr:           addr,
-		handlers:       make(map[string]*RouteHandler),
-		opts:           make([]server.ServerOption, 0),
-		cfg:            server.DefaultConfig(),
-		ctx:            ctx,
-		cancel:         cancel,
-		connections:    make(map[*Conn]bool),
-		connStore:      make(map[*protocol.WSConnection]*Conn),
-		patterns:       make(map[*regexp.Regexp]*RouteHandler),
-		routePatterns:  make(map[string][]string),
-		patternMethods: make(map[*regexp.Regexp][]HTTPMethod),
-		middleware:     make([]Middleware, 0),
+	s := &Server{
+		addr:             addr,
+		routes:           newRouter(),
+		opts:             make([]server.ServerOption, 0),
+		cfg:              server.DefaultConfig(),
+		ctx:              ctx,
+		cancel:           cancel,
+		connections:      make(map[*Conn]bool),
+		ready:            make(chan struct{}),
+		connStore:        make(map[*protocol.WSConnection]*Conn),
+		middleware:       make([]Middleware, 0),
+		sessions:         session.NewSessionManager(0),
+		contexts:         adapters.NewContextAdapter(),
+		notFound:         Fallback{Status: http.StatusNotFound, Reason: "not found"},
+		methodNotAllowed: Fallback{Status: http.StatusMethodNotAllowed, Reason: "method not allowed"},
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
 // HandleFunc registers a function to handle WebSocket connections for the given pattern with default methods (GET).
@@ -106,39 +144,40 @@ func (s *Server) HandleFunc(pattern string, handler func(*Conn)) {
 }
 
 // HandleFuncWithMethods registers a function to handle WebSocket connections for the given pattern with specific HTTP methods.
+// Patterns are slash-separated segments where ":name" captures one non-empty segment
+// and a final "*name" captures the rest of the path, both read via Conn.Param. A
+// parameter may carry a constraint, ":id<int>", ":id<uuid>" or ":code<[a-z]{3}>" (a
+// regular expression without "/"); paths failing it do not match, and unmatched paths
+// are refused with 404 before the upgrade. Static segments take precedence over
+// constrained parameters, those over plain parameters, and parameters over catch-alls. Registering a
+// route that conflicts with an existing one (same path and method, or a differently
+// named parameter at the same position) panics.
 func (s *Server) HandleFuncWithMethods(pattern string, methods []HTTPMethod, handler func(*Conn)) {
+	s.handle("", pattern, methods, handler)
+}
+
+// handle registers a route on the routes of host, "" for the default ones.
+func (s *Server) handle(host, pattern string, methods []HTTPMethod, handler func(*Conn)) {
 	s.handlerMux.Lock()
 	defer s.handlerMux.Unlock()
 
 	routeHandler := &RouteHandler{
 		Handler: handler,
 		Methods: methods,
+		pattern: pattern,
 	}
 
-	// Check if the pattern contains parameters (e.g., /users/:id/messages/:messageId)
-	if containsParam(pattern) {
-		// Convert parameterized route to regex
-		regexPattern, paramNames := convertToRegex(pattern)
-		regex := regexp.MustCompile("^" + regexPattern + "$")
-
-		// Store the handler and parameter names
-		s.patterns[regex] = routeHandler
-		s.routePatterns[regexPattern] = paramNames
-		s.patternMethods[regex] = methods
-	} else if !containsRegex(pattern) {
-		// If the pattern is a simple path without regex, store it directly
-		s.handlers[pattern] = routeHandler
-	} else {
-		// Compile the pattern as a regex
-		regex := regexp.MustCompile(pattern)
-		s.patterns[regex] = routeHandler
-		s.patternMethods[regex] = methods
+	routes := s.routes
+	if host != "" {
+		if s.hostRoutes == nil {
+			s.hostRoutes = make(map[string]*router)
+		}
+		if routes = s.hostRoutes[host]; routes == nil {
+			routes = newRouter()
+			s.hostRoutes[host] = routes
+		}
 	}
-}
-
-// containsRegex checks if a pattern contains regex characters
-func containsRegex(pattern string) bool {
-	return regexp.MustCompile(`[\*\+\?\[\]\^\$\.\|\\()]`).MatchString(pattern)
+	routes.add(pattern, routeHandler)
 }
 
 // GET registers a handler for GET method on the specified pattern.
@@ -197,12 +236,12 @@ func (s *Server) Middleware() []Middleware {
 	return m
 }
 
-// Handlers returns the server's handlers map for testing purposes
+// Handlers returns the server's handlers keyed by route pattern for testing purposes
 func (s *Server) Handlers() map[string]*RouteHandler {
 	s.handlerMux.RLock()
 	defer s.handlerMux.RUnlock()
-	h := make(map[string]*RouteHandler, len(s.handlers))
-	for k, v := range s.handlers {
+	h := make(map[string]*RouteHandler, len(s.routes.routes))
+	for k, v := range s.routes.routes {
 		h[k] = v
 	}
 	return h
@@ -216,55 +255,66 @@ func (s *Server) Group(prefix string) *RouteGroup {
 	}
 }
 
+// Host returns a route group serving the virtual host name of a
+// WithTLSHosts listener, "*.example.com" for a wildcard host. Connections to
+// a host with routes of its own are routed by those only, other connections
+// by the server's routes.
+func (s *Server) Host(name string) *RouteGroup {
+	return &RouteGroup{
+		server: s,
+		host:   strings.ToLower(strings.TrimSuffix(name, ".")),
+	}
+}
+
 // Group methods - all routes registered on the group will have the prefix prepended
 // GET registers a handler for GET method on the specified pattern with group prefix.
 func (g *RouteGroup) GET(pattern string, handler func(*Conn)) {
-	g.server.HandleFuncWithMethods(g.joinPrefix(pattern), []HTTPMethod{GET}, handler)
+	g.server.handle(g.host, g.joinPrefix(pattern), []HTTPMethod{GET}, handler)
 }
 
 // POST registers a handler for POST method on the specified pattern with group prefix.
 func (g *RouteGroup) POST(pattern string, handler func(*Conn)) {
-	g.server.HandleFuncWithMethods(g.joinPrefix(pattern), []HTTPMethod{POST}, handler)
+	g.server.handle(g.host, g.joinPrefix(pattern), []HTTPMethod{POST}, handler)
 }
 
 // PUT registers a handler for PUT method on the specified pattern with group prefix.
 func (g *RouteGroup) PUT(pattern string, handler func(*Conn)) {
-	g.server.HandleFuncWithMethods(g.joinPrefix(pattern), []HTTPMethod{PUT}, handler)
+	g.server.handle(g.host, g.joinPrefix(pattern), []HTTPMethod{PUT}, handler)
 }
 
 // PATCH registers a handler for PATCH method on the specified pattern with group prefix.
 func (g *RouteGroup) PATCH(pattern string, handler func(*Conn)) {
-	g.server.HandleFuncWithMethods(g.joinPrefix(pattern), []HTTPMethod{PATCH}, handler)
+	g.server.handle(g.host, g.joinPrefix(pattern), []HTTPMethod{PATCH}, handler)
 }
 
 // DELETE registers a handler for DELETE method on the specified pattern with group prefix.
 func (g *RouteGroup) DELETE(pattern string, handler func(*Conn)) {
-	g.server.HandleFuncWithMethods(g.joinPrefix(pattern), []HTTPMethod{DELETE}, handler)
+	g.server.handle(g.host, g.joinPrefix(pattern), []HTTPMethod{DELETE}, handler)
 }
 
 // HEAD registers a handler for HEAD method on the specified pattern with group prefix.
 func (g *RouteGroup) HEAD(pattern string, handler func(*Conn)) {
-	g.server.HandleFuncWithMethods(g.joinPrefix(pattern), []HTTPMethod{HEAD}, handler)
+	g.server.handle(g.host, g.joinPrefix(pattern), []HTTPMethod{HEAD}, handler)
 }
 
 // OPTIONS registers a handler for OPTIONS method on the specified pattern with group prefix.
 func (g *RouteGroup) OPTIONS(pattern string, handler func(*Conn)) {
-	g.server.HandleFuncWithMethods(g.joinPrefix(pattern), []HTTPMethod{OPTIONS}, handler)
+	g.server.handle(g.host, g.joinPrefix(pattern), []HTTPMethod{OPTIONS}, handler)
 }
 
 // TRACE registers a handler for TRACE method on the specified pattern with group prefix.
 func (g *RouteGroup) TRACE(pattern string, handler func(*Conn)) {
-	g.server.HandleFuncWithMethods(g.joinPrefix(pattern), []HTTPMethod{TRACE}, handler)
+	g.server.handle(g.host, g.joinPrefix(pattern), []HTTPMethod{TRACE}, handler)
 }
 
 // HandleFunc registers a function to handle WebSocket connections for the given pattern with group prefix and default method (GET).
 func (g *RouteGroup) HandleFunc(pattern string, handler func(*Conn)) {
-	g.server.HandleFuncWithMethods(g.joinPrefix(pattern), []HTTPMethod{GET}, handler)
+	g.server.handle(g.host, g.joinPrefix(pattern), []HTTPMethod{GET}, handler)
 }
 
 // HandleFuncWithMethods registers a function to handle WebSocket connections for the given pattern with group prefix and specific HTTP methods.
 func (g *RouteGroup) HandleFuncWithMethods(pattern string, methods []HTTPMethod, handler func(*Conn)) {
-	g.server.HandleFuncWithMethods(g.joinPrefix(pattern), methods, handler)
+	g.server.handle(g.host, g.joinPrefix(pattern), methods, handler)
 }
 
 // Group creates a nested route group with the given prefix appended to the current group's prefix.
@@ -272,6 +322,7 @@ func (g *RouteGroup) Group(prefix string) *RouteGroup {
 	return &RouteGroup{
 		server: g.server,
 		prefix: g.joinPrefix(prefix),
+		host:   g.host,
 	}
 }
 
@@ -315,17 +366,20 @@ func (s *Server) applyMiddleware(handler func(*Conn)) func(*Conn) {
 
 // Built-in middleware functions
 
+// middlewareLog is the logger of the built-in middleware.
+var middlewareLog = control.Logger(control.LogMiddleware)
+
 // LoggingMiddleware logs connection information
 func LoggingMiddleware(next func(*Conn)) func(*Conn) {
 	return func(conn *Conn) {
 		// Log connection start
-		fmt.Printf("[LOG] WebSocket connection started from %s\n", conn.RemoteAddr())
+		middlewareLog.Info("websocket connection started", "remote", conn.RemoteAddr())
 
 		// Execute the next handler
 		next(conn)
 
 		// Log connection end
-		fmt.Printf("[LOG] WebSocket connection from %s ended\n", conn.RemoteAddr())
+		middlewareLog.Info("websocket connection ended", "remote", conn.RemoteAddr())
 	}
 }
 
@@ -334,7 +388,7 @@ func RecoveryMiddleware(next func(*Conn)) func(*Conn) {
 	return func(conn *Conn) {
 		defer func() {
 			if r := recover(); r != nil {
-				fmt.Printf("[RECOVERY] Panic recovered in handler: %v\n", r)
+				middlewareLog.Error("panic recovered in handler", "panic", r, "remote", conn.RemoteAddr())
 				// Optionally close the connection if there was a panic
 				_ = conn.Close()
 			}
@@ -343,113 +397,106 @@ func RecoveryMiddleware(next func(*Conn)) func(*Conn) {
 	}
 }
 
-// MetricsMiddleware collects basic metrics
+// MetricsMiddleware collects basic metrics: the global counts of GetMetrics
+// and the per-route figures of RouteMetrics.
 func MetricsMiddleware(next func(*Conn)) func(*Conn) {
 	return func(conn *Conn) {
+		m := metricsFor(conn.route)
+		m.connections.Inc()
+		m.active.Add(1)
+		conn.metrics.Store(m)
+
 		// Increment active connections
 		active := atomic.AddInt64(&globalActiveConns, 1)
-		fmt.Printf("[METRICS] Active connections: %d\n", active)
+		middlewareLog.Debug("active connections", "count", active)
 
 		// Execute the next handler
 		next(conn)
+		conn.observeHandled(m)
+		m.active.Add(-1)
 
 		// Decrement active connections
 		active = atomic.AddInt64(&globalActiveConns, -1)
-		fmt.Printf("[METRICS] Active connections: %d\n", active)
+		middlewareLog.Debug("active connections", "count", active)
 	}
 }
 
 // GetMetrics returns current server metrics
 func GetMetrics() map[string]int64 {
 	return map[string]int64{
-		"active_connections": globalActiveConns,
-		"total_messages":     globalTotalMsgs,
+		"active_connections": atomic.LoadInt64(&globalActiveConns),
+		"total_messages":     atomic.LoadInt64(&globalTotalMsgs),
 	}
 }
 
-// containsParam checks if a pattern contains parameter placeholders (e.g., :id)
-func containsParam(pattern string) bool {
-	return strings.Contains(pattern, ":")
+// findHandler finds the handler registered for a request path and method on
+// virtual host, "" if none, and extracts its parameters. WebSocket upgrades
+// always arrive as GET.
+func (s *Server) findHandler(host, path string, method HTTPMethod) (*RouteHandler, []RouteParam) {
+	s.handlerMux.RLock()
+	defer s.handlerMux.RUnlock()
+	return s.routesOf(host).lookup(path, method)
 }
 
-// convertToRegex converts a parameterized route to a regex pattern and extracts parameter names
-func convertToRegex(pattern string) (regex string, paramNames []string) {
-	// Split the pattern by "/"
-	parts := strings.Split(pattern, "/")
-	regexParts := make([]string, 0, len(parts))
-	var params []string
-
-	for _, part := range parts {
-		if strings.HasPrefix(part, ":") {
-			// This is a parameter part like ":id"
-			paramName := strings.TrimPrefix(part, ":")
-			regexParts = append(regexParts, `([^/]+)`) // Match any characters except "/"
-			params = append(params, paramName)
-		} else if part == "" && len(parts) > 1 {
-			// Handle the case where pattern starts with "/" (first part is empty)
-			continue
-		} else {
-			// This is a static part, escape special regex chars
-			escaped := regexp.QuoteMeta(part)
-			regexParts = append(regexParts, escaped)
-		}
+// routesOf returns the routes of virtual host, the server's routes if it has
+// none of its own. The caller holds handlerMux.
+func (s *Server) routesOf(host string) *router {
+	if r, ok := s.hostRoutes[host]; ok {
+		return r
 	}
+	return s.routes
+}
 
-	// Combine with "/" separators
-	regex = strings.Join(regexParts, "/")
-	paramNames = params
-	return
+// Fallback answers upgrade requests no route accepts. A non-zero Status
+// refuses the handshake with that HTTP status and Reason before the upgrade;
+// otherwise the connection is upgraded and Handler, if set, runs on it as
+// soon as it opens, e.g. to close with an application code. With neither, the
+// upgraded connection is closed on its first message.
+type Fallback struct {
+	Status  int
+	Reason  string
+	Handler func(*Conn)
 }
 
-// findHandler finds the appropriate handler for a request path and extracts parameters
-// For now, we assume the HTTP method is GET since WebSocket upgrade requires GET method
-// In the future, this can be extended to check against allowed methods
-func (s *Server) findHandler(path string, method HTTPMethod) (*RouteHandler, []RouteParam) {
+// NotFound sets the answer for paths no route matches, including paths
+// failing a parameter constraint. The default refuses them with 404.
+func (s *Server) NotFound(fb Fallback) {
+	s.handlerMux.Lock()
+	s.notFound = fb
+	s.handlerMux.Unlock()
+}
+
+// MethodNotAllowed sets the answer for paths whose routes are all registered
+// for methods other than GET, the method of every WebSocket upgrade. The
+// default refuses them with 405.
+func (s *Server) MethodNotAllowed(fb Fallback) {
+	s.handlerMux.Lock()
+	s.methodNotAllowed = fb
+	s.handlerMux.Unlock()
+}
+
+// routeMiss reports whether no route of virtual host accepts an upgrade of
+// path and, if so, the fallback answering it.
+func (s *Server) routeMiss(host, path string) (Fallback, bool) {
 	s.handlerMux.RLock()
 	defer s.handlerMux.RUnlock()
-
-	// Find exact match first
-	if handler, exists := s.handlers[path]; exists {
-		// Check if the method is allowed
-		if isMethodAllowed(method, handler.Methods) {
-			return handler, nil
-		}
+	routes := s.routesOf(host)
+	if h, _ := routes.lookup(path, GET); h != nil {
+		return Fallback{}, false
 	}
-
-	// Try to match with regex patterns and extract parameters
-	for pattern, handler := range s.patterns {
-		matches := pattern.FindStringSubmatch(path)
-		if matches != nil && len(matches) > 1 {
-			// Check if the method is allowed
-			if !isMethodAllowed(method, handler.Methods) {
-				continue
-			}
-
-			// Extract parameter names for this pattern
-			// Find the corresponding regex pattern to get parameter names
-			var paramNames []string
-			for regexStr, names := range s.routePatterns {
-				// Check if this regex matches our pattern
-				if regexp.MustCompile("^" + regexp.QuoteMeta(regexStr) + "$").MatchString(pattern.String()) {
-					paramNames = names
-					break
-				}
-			}
-
-			// Create parameter map
-			var params []RouteParam
-			for i, paramName := range paramNames {
-				if i+1 < len(matches) {
-					params = append(params, RouteParam{Key: paramName, Value: matches[i+1]})
-				}
-			}
-
-			return handler, params
-		}
+	if h, _ := routes.lookup(path, anyMethod); h != nil {
+		return s.methodNotAllowed, true
 	}
+	return s.notFound, true
+}
 
-	// Return nil if no handler found or method not allowed
-	return nil, nil
+// checkRoute refuses unrouted upgrades before the 101 response when their
+// fallback carries an HTTP status.
+func (s *Server) checkRoute(req *http.Request) error {
+	if fb, miss := s.routeMiss(server.HandshakeVirtualHost(req), req.URL.Path); miss && fb.Status != 0 {
+		return &protocol.HandshakeRejection{Status: fb.Status, Reason: fb.Reason}
+	}
+	return nil
 }
 
 // isMethodAllowed checks if the given HTTP method is in the allowed methods list
@@ -481,9 +528,34 @@ func (s *Server) removeConnection(conn *Conn) {
 	delete(s.connections, conn)
 }
 
+// configureConn applies the server-wide read limit, timeouts and context
+// factory to a new connection before it is shared with the handler.
+func (s *Server) configureConn(c *Conn) {
+	if s.readLimit > 0 {
+		c.readLimit = s.readLimit
+	}
+	c.readTimeout = s.readTimeout
+	c.writeTimeout = s.writeTimeout
+	if s.writeTimeout > 0 {
+		c.underlying.SetWriteTimeout(s.writeTimeout)
+	}
+	c.contexts = s.contexts
+	c.running = &s.runningHandlers
+	c.onPanic = s.handlePanic
+	if s.coalesceInterval > 0 {
+		c.coalescer = &writeCoalescer{interval: s.coalesceInterval}
+	}
+	c.asyncSize = s.asyncSize
+	c.asyncPolicy = s.asyncPolicy
+	c.codec = s.codec
+	s.handlerMux.RLock()
+	c.messageMiddleware = s.messageMiddleware
+	s.handlerMux.RUnlock()
+}
+
 // getOrCreateConn returns a reusable high-level connection wrapper for the given WSConnection.
 // It also sets up cleanup callbacks to keep tracking maps in sync.
-func (s *Server) getOrCreateConn(wsConn *protocol.WSConnection, params []RouteParam) *Conn {
+func (s *Server) getOrCreateConn(wsConn *protocol.WSConnection, route *RouteHandler, params []RouteParam) *Conn {
 	s.connStoreMu.RLock()
 	if existing, ok := s.connStore[wsConn]; ok {
 		s.connStoreMu.RUnlock()
@@ -491,15 +563,19 @@ func (s *Server) getOrCreateConn(wsConn *protocol.WSConnection, params []RoutePa
 	}
 	s.connStoreMu.RUnlock()
 
-	pool := s.underlying.GetBufferPool()
+	pool := s.lowlevel().GetBufferPool()
 	hlConn := newConnWithParams(wsConn, pool, params)
+	if route != nil {
+		hlConn.route = route.pattern
+	}
+	hlConn.openedAt = time.Now()
+	s.configureConn(hlConn)
 	s.addConnection(hlConn)
 
+	// The connStore entry is dropped by handleClose, once the lifecycle
+	// callbacks no longer need it.
 	hlConn.SetCloseCallback(func() {
 		s.removeConnection(hlConn)
-		s.connStoreMu.Lock()
-		delete(s.connStore, wsConn)
-		s.connStoreMu.Unlock()
 	})
 
 	s.connStoreMu.Lock()
@@ -515,20 +591,64 @@ func (s *Server) getOrCreateConn(wsConn *protocol.WSConnection, params []RoutePa
 	return hlConn
 }
 
+// lowlevel returns the underlying server, nil until ListenAndServe created
+// it.
+func (s *Server) lowlevel() *server.Server {
+	s.underlyingMu.Lock()
+	defer s.underlyingMu.Unlock()
+	return s.underlying
+}
+
+// Addrs returns the addresses the server listens on once ListenAndServe has
+// started, the NewServer address first.
+func (s *Server) Addrs() []net.Addr {
+	if u := s.lowlevel(); u != nil {
+		return u.Addrs()
+	}
+	return nil
+}
+
+// Ready returns a channel closed once ListenAndServe accepts connections on
+// every address, for callers that run it in a goroutine.
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready
+}
+
 // ListenAndServe starts the server and serves requests until an error occurs or the server is stopped.
 func (s *Server) ListenAndServe() error {
 	// Set configuration
 	s.cfg.ListenAddr = s.addr
 
 	// Create the underlying server
-	var err error
-	s.underlying, err = server.NewServer(s.cfg, s.opts...)
+	opts := append(s.opts, server.WithSessionManager(s.sessions), server.WithHandshakeCheck(s.checkRoute),
+		server.WithEndpoints(s.endpoints...))
+	u, err := server.NewServer(s.cfg, opts...)
 	if err != nil {
 		return fmt.Errorf("failed to create underlying server: %w", err)
 	}
+	u.OnPanic(s.handleReactorPanic)
+	s.underlyingMu.Lock()
+	s.underlying = u
+	s.underlyingMu.Unlock()
+	go func() {
+		select {
+		case <-u.Ready():
+			close(s.ready)
+		case <-s.ctx.Done():
+		}
+	}()
 
 	// Create a combined handler that uses our routing
 	basicHandler := adapters.HandlerFunc(func(data any) error {
+		switch evt := data.(type) {
+		case api.OpenEvent:
+			s.handleOpen(evt)
+			return nil
+		case api.CloseEvent:
+			s.handleClose(evt)
+			return nil
+		}
+
 		var buf api.Buffer
 
 		// Unpack buffer from data
@@ -544,6 +664,10 @@ func (s *Server) ListenAndServe() error {
 			queued := false
 
 			// Check if the data contains a connection (in case of custom event with connection)
+			messageType := BinaryMessage
+			if op, ok := data.(interface{ Opcode() byte }); ok {
+				messageType = MessageType(op.Opcode())
+			}
 			if connData, ok := data.(interface{ WSConnection() *protocol.WSConnection }); ok {
 				wsConn = connData.WSConnection()
 			} else {
@@ -556,22 +680,28 @@ func (s *Server) ListenAndServe() error {
 			if wsConn != nil {
 				// Find the appropriate handler based on the connection's path
 				// For WebSocket connections, the method is always GET (for upgrade)
-				routeHandler, params := s.findHandler(wsConn.Path(), GET)
-
+				routeHandler, params := s.findHandler(wsConn.VirtualHost(), wsConn.Path(), GET)
+				var handler func(*Conn)
 				if routeHandler != nil {
+					handler = routeHandler.Handler
+				} else if fb, _ := s.routeMiss(wsConn.VirtualHost(), wsConn.Path()); fb.Handler != nil {
+					handler = fb.Handler
+				}
+
+				if handler != nil {
 					// Reuse or create high-level connection, queue the message, and start handler once
-					hlConn := s.getOrCreateConn(wsConn, params)
-					hlConn.enqueueIncoming(buf)
+					hlConn := s.getOrCreateConn(wsConn, routeHandler, params)
+					hlConn.enqueueIncoming(messageType, buf)
 					queued = true
 
-					finalHandler := s.applyMiddleware(routeHandler.Handler)
+					finalHandler := s.applyMiddleware(handler)
 					hlConn.runHandlerOnce(func(conn *Conn) {
 						finalHandler(conn)
 					})
 				} else {
 					// No handler found, close connection or return error
 					// Create a basic connection just to close it
-					pool := s.underlying.GetBufferPool()
+					pool := u.GetBufferPool()
 					hlConn := newConn(wsConn, pool)
 					hlConn.Close()
 				}
@@ -586,31 +716,131 @@ func (s *Server) ListenAndServe() error {
 	})
 
 	// Start the underlying server
-	return s.underlying.Run(basicHandler)
+	return u.Run(basicHandler)
 }
 
 // ServerOption wraps server.ServerOption for high-level configuration
 type ServerOption func(*Server)
 
-// WithReadLimit sets the maximum size for incoming messages.
+// WithReadLimit sets the maximum size for incoming messages on every
+// connection; larger messages fail ReadMessage and ReadBuffer.
 func WithReadLimit(limit int64) ServerOption {
 	return func(s *Server) {
-		// We'll store this for later use in connection creation
-		// This will be applied to individual connections
+		s.readLimit = limit
 	}
 }
 
-// WithWriteTimeout sets the write timeout for connections.
+// WithWriteTimeout sets the write timeout for connections. It bounds each
+// WriteMessage and is set as the transport write deadline where supported.
 func WithWriteTimeout(d time.Duration) ServerOption {
 	return func(s *Server) {
-		// Store for later application to connections
+		s.writeTimeout = d
+	}
+}
+
+// WithWriteCoalescing holds back messages written to a connection for up to
+// interval and writes them with a single transport Send, trading that much
+// latency for fewer syscalls in handlers sending many small messages. Call
+// Conn.Flush to send early. Zero disables coalescing, the default.
+func WithWriteCoalescing(interval time.Duration) ServerOption {
+	return func(s *Server) {
+		s.coalesceInterval = interval
+	}
+}
+
+// WithAsyncQueue sets the capacity of each connection's WriteMessageAsync
+// queue, 256 by default, and what happens to writes once it is full.
+func WithAsyncQueue(size int, policy WriteOverflowPolicy) ServerOption {
+	return func(s *Server) {
+		s.asyncSize = size
+		s.asyncPolicy = policy
+	}
+}
+
+// WithListen adds addresses served alongside the NewServer address, sharing
+// its routes, middleware, hooks and connections: "host:port" for TCP or
+// "unix:/path" for a Unix domain socket. NewServer("") with WithListen serves
+// the listed addresses only.
+func WithListen(addrs ...string) ServerOption {
+	return func(s *Server) {
+		for _, addr := range addrs {
+			s.endpoints = append(s.endpoints, server.Endpoint{Addr: addr})
+		}
 	}
 }
 
-// WithReadTimeout sets the read timeout for connections.
+// WithTLSListen adds an address served over TLS with cfg, e.g. ":8443"
+// next to a plain ":8080", see WithListen.
+func WithTLSListen(addr string, cfg *tls.Config) ServerOption {
+	return func(s *Server) {
+		s.endpoints = append(s.endpoints, server.Endpoint{Addr: addr, TLS: cfg})
+	}
+}
+
+// WithTLSHosts adds an address served over TLS for several server names,
+// each with its own certificate selected by the client's SNI, e.g. to
+// terminate WebSockets for several domains on ":443". Routes registered with
+// Server.Host serve a single host; the Handler of hosts is not used. cfg, if
+// set, is the template of the TLS config and its certificates answer clients
+// naming none of the hosts.
+func WithTLSHosts(addr string, cfg *tls.Config, hosts ...server.VirtualHost) ServerOption {
+	return func(s *Server) {
+		ep := server.Endpoint{Addr: addr, TLS: cfg}
+		for _, h := range hosts {
+			ep.Hosts = append(ep.Hosts, server.VirtualHost{Name: h.Name, Certificate: h.Certificate})
+		}
+		s.endpoints = append(s.endpoints, ep)
+	}
+}
+
+// WithCodec sets the codec every connection's ReadObject and WriteObject
+// start with, JSONCodec by default. Conn.SetCodec overrides it per connection.
+func WithCodec(codec Codec) ServerOption {
+	return func(s *Server) {
+		s.codec = codec
+	}
+}
+
+// WithReadTimeout sets the read timeout for connections. It bounds each
+// ReadMessage and is set as the transport read deadline where supported.
 func WithReadTimeout(d time.Duration) ServerOption {
 	return func(s *Server) {
-		// Store for later application to connections
+		s.readTimeout = d
+	}
+}
+
+// WithSessionManager replaces the default SessionManager, e.g. to configure
+// TTL, idle eviction or lifecycle hooks.
+func WithSessionManager(m *session.SessionManager) ServerOption {
+	return func(s *Server) {
+		s.sessions = m
+	}
+}
+
+// WithContextFactory sets the api.ContextFactory creating each connection's
+// Conn.Context store.
+func WithContextFactory(f api.ContextFactory) ServerOption {
+	return func(s *Server) {
+		s.contexts = f
+	}
+}
+
+// WithAcceptWorkers performs handshakes on workers goroutines per listener,
+// fed by a queue of up to queue accepted connections (0 = workers), so
+// costly TLS handshakes do not stall accepting under connection storms.
+func WithAcceptWorkers(workers, queue int) ServerOption {
+	return func(s *Server) {
+		s.cfg.AcceptWorkers = workers
+		s.cfg.AcceptQueue = queue
+	}
+}
+
+// WithAcceptEx keeps depth accepts posted with AcceptEx on each TCP
+// listener on Windows, cutting the setup of every connection from the
+// accept path. See server.Config.AcceptEx; ignored on other platforms.
+func WithAcceptEx(depth int) ServerOption {
+	return func(s *Server) {
+		s.cfg.AcceptEx = depth
 	}
 }
 
@@ -621,6 +851,57 @@ func WithMaxConnections(max int) ServerOption {
 	}
 }
 
+// WithOverflowPolicy selects how connections beyond MaxConnections are
+// handled; wait bounds server.OverflowQueue (0 = default).
+func WithOverflowPolicy(policy server.OverflowPolicy, wait time.Duration) ServerOption {
+	return func(s *Server) {
+		s.cfg.OverflowPolicy = policy
+		s.cfg.OverflowWait = wait
+	}
+}
+
+// WithOutboxLimit bounds each connection's send queue and selects the
+// slow-consumer policy applied once a peer lets it fill up.
+func WithOutboxLimit(l protocol.OutboxLimit) ServerOption {
+	return func(s *Server) {
+		s.cfg.Outbox = l
+	}
+}
+
+// WithReadBufferLimit bounds the bytes buffered for one incoming frame;
+// a peer announcing a larger frame is closed with 1009 Message Too Big.
+func WithReadBufferLimit(n int) ServerOption {
+	return func(s *Server) {
+		s.cfg.ReadBufferLimit = n
+	}
+}
+
+// WithSubprotocols lists the Sec-WebSocket-Protocol values the server accepts,
+// e.g. mqtt.Subprotocol; handlers read the selection via Conn.Subprotocol.
+func WithSubprotocols(protos ...string) ServerOption {
+	return func(s *Server) {
+		s.cfg.Subprotocols = append([]string(nil), protos...)
+	}
+}
+
+// WithSlowHandler logs reactor dispatches running longer than d, with their
+// route and stack, and counts them, see server.Config.SlowHandler.
+func WithSlowHandler(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.cfg.SlowHandler = d
+	}
+}
+
+// WithIdleTimeout closes connections receiving no frame for d with 1001
+// Going Away, see server.Config.IdleTimeout. With ignorePongs, pongs do not
+// count as activity, so peers only answering keepalive pings are closed too.
+func WithIdleTimeout(d time.Duration, ignorePongs bool) ServerOption {
+	return func(s *Server) {
+		s.cfg.IdleTimeout = d
+		s.cfg.IdleIgnorePongs = ignorePongs
+	}
+}
+
 // WithBatchSize sets the batch size for processing incoming messages.
 func WithBatchSize(size int) ServerOption {
 	return func(s *Server) {
@@ -642,28 +923,136 @@ func WithChannelCapacity(cap int) ServerOption {
 	}
 }
 
-// Shutdown stops the server gracefully.
-func (s *Server) Shutdown() error {
-	if s.underlying != nil {
-		s.underlying.Shutdown()
+// WithIPFilter refuses peers by IP as soon as they connect, before the
+// handshake: addresses matching deny, or matching none of allow when allow
+// is not empty. Entries are CIDRs or single addresses; both lists are
+// hot-reloadable through the server.CfgIPAllow and server.CfgIPDeny keys.
+func WithIPFilter(allow, deny []string) ServerOption {
+	return func(s *Server) {
+		s.cfg.IPAllow = allow
+		s.cfg.IPDeny = deny
+	}
+}
+
+// WithReusePort binds TCP addresses with SO_REUSEPORT, so another process,
+// e.g. the next release during a rolling restart, can listen on them too.
+// Not supported on Windows.
+func WithReusePort() ServerOption {
+	return func(s *Server) {
+		s.cfg.ReusePort = true
+	}
+}
+
+// WithNUMAAcceptors gives each NUMA node, or each of nodes if given, its own
+// listeners, reactor and buffer pool, so a connection's buffers and handler
+// stay on the node that accepted it. See server.Config.NUMAAcceptors.
+func WithNUMAAcceptors(nodes ...int) ServerOption {
+	return func(s *Server) {
+		s.opts = append(s.opts, server.WithNUMAAcceptors(nodes...))
+	}
+}
+
+// WithRecorder records the raw traffic of the connections open selects,
+// for record.Replay, see server.WithRecorder.
+func WithRecorder(open server.Recorder) ServerOption {
+	return func(s *Server) {
+		s.opts = append(s.opts, server.WithRecorder(open))
+	}
+}
+
+// WithUpgradeCommand sets the binary and arguments Upgrade starts instead of
+// re-executing the running one, see server.WithUpgradeCommand.
+func WithUpgradeCommand(path string, args ...string) ServerOption {
+	return func(s *Server) {
+		s.opts = append(s.opts, server.WithUpgradeCommand(path, args...))
+	}
+}
+
+// Upgrade restarts the server in a new process without dropping connections,
+// see server.Server.Upgrade: once the new process accepts on the handed-over
+// sockets, this one stops accepting, lets established connections finish
+// until ctx is done and shuts down. On error the server keeps serving.
+func (s *Server) Upgrade(ctx context.Context) error {
+	u := s.lowlevel()
+	if u == nil {
+		return errors.New("upgrade: server not started")
+	}
+	if err := u.Upgrade(ctx); err != nil {
+		return err
+	}
+	return s.Shutdown(ctx)
+}
+
+// Drain takes the server out of rotation ahead of a rolling deploy, see
+// server.Server.Drain: readiness fails, accepting stops and connections are
+// sent 1001 Going Away as they go idle, until none are left or ctx is
+// done. Handlers keep running; call Shutdown afterwards.
+func (s *Server) Drain(ctx context.Context) error {
+	u := s.lowlevel()
+	if u == nil {
+		return errors.New("drain: server not started")
+	}
+	return u.Drain(ctx)
+}
+
+// Shutdown stops the server gracefully: it stops accepting connections, sends
+// every open connection a 1001 Going Away close frame after the messages
+// already queued, and waits for running handlers to return until ctx is
+// done. Writes fail once the close frame is queued, and connections stay
+// open until the peer answers; those with reading paused are closed at once.
+// Connections left are then closed once the close frame is written, or
+// force-closed with ctx.Err() returned if ctx ran out first.
+func (s *Server) Shutdown(ctx context.Context) error {
+	u := s.lowlevel()
+	if u != nil {
+		u.StopAccepting()
+	}
+
+	for _, conn := range s.trackedConnections() {
+		conn.goAway(ctx, protocol.CloseGoingAway, "server shutting down")
+	}
+
+	var err error
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	for s.runningHandlers.Load() > 0 && err == nil {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-tick.C:
+		}
+	}
+
+	if u != nil {
+		u.Shutdown()
 	}
 	if s.cancel != nil {
 		s.cancel()
 	}
+	for _, conn := range s.trackedConnections() {
+		if err == nil {
+			conn.closeAfterSend() // the close frame may still be queued
+		} else {
+			conn.Close()
+		}
+	}
+	return err
+}
 
-	// Close all tracked connections
+// trackedConnections returns a snapshot of the open connections.
+func (s *Server) trackedConnections() []*Conn {
 	s.connectionsMu.Lock()
+	defer s.connectionsMu.Unlock()
 	conns := make([]*Conn, 0, len(s.connections))
 	for conn := range s.connections {
 		conns = append(conns, conn)
 	}
-	s.connectionsMu.Unlock()
-
-	for _, conn := range conns {
-		conn.Close()
-	}
+	return conns
+}
 
-	return nil
+// Sessions returns the SessionManager tracking one Session per live connection.
+func (s *Server) Sessions() *session.SessionManager {
+	return s.sessions
 }
 
 // GetActiveConnections returns the number of currently active connections.
@@ -7,6 +7,8 @@ package highlevel
 type MessageType int
 
 const (
+	// ContinuationMessage denotes a continuation frame of a fragmented message.
+	ContinuationMessage MessageType = 0
 	// TextMessage denotes a text WebSocket message.
 	TextMessage MessageType = 1
 	// BinaryMessage denotes a binary WebSocket message.
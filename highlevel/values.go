@@ -0,0 +1,32 @@
+// Package hioload provides a high-level WebSocket library built on top of hioload-ws primitives.
+package highlevel
+
+import (
+	"github.com/momentics/hioload-ws/adapters"
+	"github.com/momentics/hioload-ws/api"
+)
+
+// Context returns the connection-scoped key/value store, created on first use
+// by the server's api.ContextFactory. Middleware uses it to hand data such as
+// the authenticated user or tenant ID to handlers; it is safe for concurrent
+// use and lives as long as the connection.
+func (c *Conn) Context() api.Context {
+	c.valuesOnce.Do(func() {
+		factory := c.contexts
+		if factory == nil {
+			factory = adapters.NewContextAdapter()
+		}
+		c.values = factory.NewContext()
+	})
+	return c.values
+}
+
+// Set stores value under key in the connection's Context.
+func (c *Conn) Set(key string, value any) {
+	c.Context().Set(key, value, false)
+}
+
+// Get returns the value stored under key in the connection's Context.
+func (c *Conn) Get(key string) (any, bool) {
+	return c.Context().Get(key)
+}
@@ -7,6 +7,33 @@
 
 package concurrency
 
+import "runtime"
+
+// CPU describes one logical processor of the host.
+type CPU struct {
+	ID     int // host-wide index, as taken by PinCurrentThread
+	Node   int // NUMA node
+	Group  int // processor group; always 0 outside Windows
+	Number int // index within Group
+}
+
+// Topology returns the logical processors of the host ordered by ID. On
+// Windows hosts with more than 64 processors, IDs run across the processor
+// groups in group order.
+func Topology() []CPU {
+	return platformTopology()
+}
+
+// flatTopology returns runtime.NumCPU processors on node 0, for platforms
+// without topology information.
+func flatTopology() []CPU {
+	cpus := make([]CPU, runtime.NumCPU())
+	for i := range cpus {
+		cpus[i] = CPU{ID: i, Number: i}
+	}
+	return cpus
+}
+
 // PreferredCPUID returns a recommended CPU ID for the specified NUMA node.
 func PreferredCPUID(numaNode int) int {
 	return platformPreferredCPUID(numaNode)
@@ -22,8 +49,9 @@ func NUMANodes() int {
 	return platformNUMANodes()
 }
 
-// PinCurrentThread binds the current OS thread to the given NUMA node and CPU.
-// Passing -1 for either parameter indicates "no preference".
+// PinCurrentThread binds the current OS thread to the given NUMA node and CPU,
+// cpuID being a Topology ID. Passing -1 for either parameter indicates "no
+// preference".
 func PinCurrentThread(numaNode, cpuID int) error {
 	return platformPinCurrentThread(numaNode, cpuID)
 }
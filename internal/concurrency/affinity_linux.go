@@ -95,4 +95,16 @@ func platformUnpinCurrentThread() error {
 	// Run on all nodes
 	C.numa_run_on_node(-1)
 	return nil
-}
\ No newline at end of file
+}
+
+// platformTopology reports the node of every configured CPU.
+func platformTopology() []CPU {
+	if !isNumaAvailable() {
+		return flatTopology()
+	}
+	cpus := make([]CPU, int(C.numa_num_configured_cpus()))
+	for i := range cpus {
+		cpus[i] = CPU{ID: i, Node: max(int(C.numa_node_of_cpu(C.int(i))), 0), Number: i}
+	}
+	return cpus
+}
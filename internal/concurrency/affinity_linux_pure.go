@@ -38,4 +38,10 @@ func platformPinCurrentThread(numaNode, cpuID int) error {
 func platformUnpinCurrentThread() error {
 	// Without CGO, no pinning was performed, so no unpinning needed
 	return nil
-}
\ No newline at end of file
+}
+
+// platformTopology reports every CPU on node 0 (no NUMA information
+// available without CGO).
+func platformTopology() []CPU {
+	return flatTopology()
+}
@@ -38,4 +38,9 @@ func platformPinCurrentThread(numaNode, cpuID int) error {
 func platformUnpinCurrentThread() error {
 	// On other platforms, no pinning was performed, so no unpinning needed
 	return nil
-}
\ No newline at end of file
+}
+
+// platformTopology reports every CPU on node 0 (no topology information).
+func platformTopology() []CPU {
+	return flatTopology()
+}
@@ -1,123 +1,239 @@
-// File: internal/concurrency/affinity_windows.go
-//go:build windows
-// +build windows
-
-//
-// Windows-specific CPU and NUMA affinity implementation.
-// Author: momentics <momentics@gmail.com>
-// License: Apache-2.0
-
-package concurrency
-
-import (
-	"fmt"
-	"runtime"
-	"syscall"
-	"unsafe"
-
-	"golang.org/x/sys/windows"
-)
-
-var (
-	modkernel32                   = windows.NewLazySystemDLL("kernel32.dll")
-	procSetThreadGroupAffinity    = modkernel32.NewProc("SetThreadGroupAffinity")
-	procGetCurrentThread          = modkernel32.NewProc("GetCurrentThread")
-	procGetNumaHighestNodeNumber  = modkernel32.NewProc("GetNumaHighestNodeNumber")
-	procGetNumaProcessorNode      = modkernel32.NewProc("GetNumaProcessorNode")
-	procGetCurrentProcessorNumber = modkernel32.NewProc("GetCurrentProcessorNumber")
-)
-
-// platformPreferredCPUID returns a suggested CPU core for the given NUMA node.
-func platformPreferredCPUID(numaNode int) int {
-	// Query highest node to validate numaNode range
-	var highestNode uint32
-	r, _, _ := procGetNumaHighestNodeNumber.Call(uintptr(unsafe.Pointer(&highestNode)))
-	if r == 0 {
-		return 0
-	}
-	if numaNode < 0 || uint32(numaNode) > highestNode {
-		// fallback to any CPU
-		return 0
-	}
-	// Get processor number for this NUMA node
-	var procNumber uint32
-	r, _, _ = procGetNumaProcessorNode.Call(uintptr(0), uintptr(unsafe.Pointer(&procNumber)))
-	if r == 0 {
-		return 0
-	}
-	cpus := runtime.NumCPU()
-	return int(procNumber % uint32(cpus))
-}
-
-// platformCurrentNUMANodeID returns current thread's NUMA node.
-func platformCurrentNUMANodeID() int {
-	// Use GetNumaProcessorNode on current processor
-	var cpu uint32
-	// syscall.GetCurrentProcessorNumber available on Windows 10+
-	r1, _, e1 := procGetCurrentProcessorNumber.Call()
-	if e1 != syscall.Errno(0) {
-		return -1
-	}
-	cpu = uint32(r1)
-
-	var node uint32
-	r, _, _ := procGetNumaProcessorNode.Call(uintptr(cpu), uintptr(unsafe.Pointer(&node)))
-	if r == 0 {
-		return -1
-	}
-	return int(node)
-}
-
-// platformNUMANodes returns total NUMA nodes count.
-func platformNUMANodes() int {
-	var highestNode uint32
-	r, _, _ := procGetNumaHighestNodeNumber.Call(uintptr(unsafe.Pointer(&highestNode)))
-	if r == 0 {
-		return 1
-	}
-	return int(highestNode) + 1
-}
-
-// GROUP_AFFINITY structure for SetThreadGroupAffinity.
-type groupAffinity struct {
-	mask     uint64
-	group    uint16
-	reserved [3]uint16
-}
-
-// platformPinCurrentThread pins the current OS thread to the specified CPU and NUMA node.
-func platformPinCurrentThread(numaNode, cpuID int) error {
-	runtime.LockOSThread()
-	handle, _, _ := procGetCurrentThread.Call()
-	// Determine group and mask via GetNumaNodeProcessorMask
-	//var procMask uint64
-	//var nodeMask uint64
-	// call GetNumaNodeProcessorMask (oid: procGetNumaNodeProcessorMask) omitted for brevity...
-	// For simplicity, assume single-group system:
-	ga := groupAffinity{
-		mask:  1 << uint(cpuID),
-		group: 0,
-	}
-	r, _, err := procSetThreadGroupAffinity.Call(handle, uintptr(unsafe.Pointer(&ga)), 0)
-	if r == 0 {
-		return fmt.Errorf("SetThreadGroupAffinity failed: %v", err)
-	}
-	return nil
-}
-
-// platformUnpinCurrentThread resets affinity to all CPUs.
-func platformUnpinCurrentThread() error {
-	runtime.LockOSThread()
-	handle, _, _ := procGetCurrentThread.Call()
-	total := runtime.NumCPU()
-	mask := uint64((1 << uint(total)) - 1)
-	ga := groupAffinity{
-		mask:  mask,
-		group: 0,
-	}
-	r, _, err := procSetThreadGroupAffinity.Call(handle, uintptr(unsafe.Pointer(&ga)), 0)
-	if r == 0 {
-		return fmt.Errorf("SetThreadGroupAffinity(unpin) failed: %v", err)
-	}
-	return nil
-}
+// File: internal/concurrency/affinity_windows.go
+//go:build windows
+// +build windows
+
+//
+// Windows-specific CPU and NUMA affinity implementation.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Hosts with more than 64 logical processors split them into processor
+// groups; a thread runs within one group at a time. The topology is read
+// once with GetLogicalProcessorInformationEx and threads are pinned with
+// SetThreadGroupAffinity to the group holding the CPU or node requested.
+
+package concurrency
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modkernel32                          = windows.NewLazySystemDLL("kernel32.dll")
+	procSetThreadGroupAffinity           = modkernel32.NewProc("SetThreadGroupAffinity")
+	procGetThreadGroupAffinity           = modkernel32.NewProc("GetThreadGroupAffinity")
+	procGetCurrentThread                 = modkernel32.NewProc("GetCurrentThread")
+	procGetNumaHighestNodeNumber         = modkernel32.NewProc("GetNumaHighestNodeNumber")
+	procGetNumaProcessorNodeEx           = modkernel32.NewProc("GetNumaProcessorNodeEx")
+	procGetCurrentProcessorNumberEx      = modkernel32.NewProc("GetCurrentProcessorNumberEx")
+	procGetActiveProcessorGroupCount     = modkernel32.NewProc("GetActiveProcessorGroupCount")
+	procGetLogicalProcessorInformationEx = modkernel32.NewProc("GetLogicalProcessorInformationEx")
+)
+
+// LOGICAL_PROCESSOR_RELATIONSHIP values.
+const (
+	relationNumaNode   = 1
+	relationNumaNodeEx = 6 // every group of a node, Windows 10 20H2 and later
+)
+
+// GROUP_AFFINITY structure for SetThreadGroupAffinity.
+type groupAffinity struct {
+	mask     uintptr
+	group    uint16
+	reserved [3]uint16
+}
+
+// PROCESSOR_NUMBER structure.
+type processorNumber struct {
+	group    uint16
+	number   uint8
+	reserved uint8
+}
+
+// topology is the host's processors, read on first use.
+var topology struct {
+	once  sync.Once
+	cpus  []CPU                   // by ID
+	nodes map[int][]groupAffinity // processors of each node, per group
+}
+
+// loadTopology returns the host's processors and the group affinities of
+// each NUMA node.
+func loadTopology() ([]CPU, map[int][]groupAffinity) {
+	topology.once.Do(func() {
+		nodes, err := numaNodeMasks()
+		if err != nil || len(nodes) == 0 {
+			topology.cpus = flatTopology()
+			return
+		}
+		topology.nodes = nodes
+
+		// Host-wide IDs number the groups' active processors in group order.
+		r, _, _ := procGetActiveProcessorGroupCount.Call()
+		base := make([]int, max(int(r), 1)+1)
+		for g := 0; g+1 < len(base); g++ {
+			base[g+1] = base[g] + int(windows.GetActiveProcessorCount(uint16(g)))
+		}
+		for node, masks := range nodes {
+			for _, ga := range masks {
+				if int(ga.group)+1 >= len(base) {
+					continue
+				}
+				for n := 0; n < int(unsafe.Sizeof(ga.mask))*8; n++ {
+					if ga.mask&(1<<uint(n)) != 0 {
+						topology.cpus = append(topology.cpus, CPU{ID: base[ga.group] + n, Node: node, Group: int(ga.group), Number: n})
+					}
+				}
+			}
+		}
+		sort.Slice(topology.cpus, func(i, j int) bool { return topology.cpus[i].ID < topology.cpus[j].ID })
+	})
+	return topology.cpus, topology.nodes
+}
+
+// numaNodeMasks reads the group affinities of every NUMA node.
+func numaNodeMasks() (map[int][]groupAffinity, error) {
+	buf, err := logicalProcessorInformation(relationNumaNodeEx)
+	if err != nil {
+		// Older systems report one group per node.
+		if buf, err = logicalProcessorInformation(relationNumaNode); err != nil {
+			return nil, err
+		}
+	}
+	// SYSTEM_LOGICAL_PROCESSOR_INFORMATION_EX records: Relationship and Size,
+	// then NUMA_NODE_RELATIONSHIP: NodeNumber, 18 reserved bytes, GroupCount
+	// and GroupCount GROUP_AFFINITY entries (one if GroupCount is 0).
+	const (
+		nodeOff   = 8
+		countOff  = nodeOff + 4 + 18
+		masksOff  = nodeOff + 32
+		gaSize    = int(unsafe.Sizeof(groupAffinity{}))
+		minRecord = masksOff + gaSize
+	)
+	nodes := make(map[int][]groupAffinity)
+	for off := 0; off+minRecord <= len(buf); {
+		size := int(*(*uint32)(unsafe.Pointer(&buf[off+4])))
+		if size < minRecord || off+size > len(buf) {
+			break
+		}
+		node := int(*(*uint32)(unsafe.Pointer(&buf[off+nodeOff])))
+		count := max(int(*(*uint16)(unsafe.Pointer(&buf[off+countOff]))), 1)
+		for i := 0; i < count && masksOff+(i+1)*gaSize <= size; i++ {
+			nodes[node] = append(nodes[node], *(*groupAffinity)(unsafe.Pointer(&buf[off+masksOff+i*gaSize])))
+		}
+		off += size
+	}
+	return nodes, nil
+}
+
+// logicalProcessorInformation calls GetLogicalProcessorInformationEx for
+// relation and returns its records.
+func logicalProcessorInformation(relation uint32) ([]byte, error) {
+	var size uint32
+	procGetLogicalProcessorInformationEx.Call(uintptr(relation), 0, uintptr(unsafe.Pointer(&size)))
+	if size == 0 {
+		return nil, fmt.Errorf("GetLogicalProcessorInformationEx: no data")
+	}
+	buf := make([]byte, size)
+	r, _, err := procGetLogicalProcessorInformationEx.Call(uintptr(relation), uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size)))
+	if r == 0 {
+		return nil, fmt.Errorf("GetLogicalProcessorInformationEx: %v", err)
+	}
+	return buf[:size], nil
+}
+
+// platformTopology reports the processors of every group and node.
+func platformTopology() []CPU {
+	cpus, _ := loadTopology()
+	return append([]CPU(nil), cpus...)
+}
+
+// platformPreferredCPUID returns the first CPU of the given NUMA node.
+func platformPreferredCPUID(numaNode int) int {
+	cpus, _ := loadTopology()
+	for _, c := range cpus {
+		if c.Node == numaNode {
+			return c.ID
+		}
+	}
+	return 0
+}
+
+// platformCurrentNUMANodeID returns current thread's NUMA node.
+func platformCurrentNUMANodeID() int {
+	var pn processorNumber
+	procGetCurrentProcessorNumberEx.Call(uintptr(unsafe.Pointer(&pn)))
+	var node uint16
+	r, _, _ := procGetNumaProcessorNodeEx.Call(uintptr(unsafe.Pointer(&pn)), uintptr(unsafe.Pointer(&node)))
+	if r == 0 || node == 0xffff {
+		return -1
+	}
+	return int(node)
+}
+
+// platformNUMANodes returns total NUMA nodes count.
+func platformNUMANodes() int {
+	var highestNode uint32
+	r, _, _ := procGetNumaHighestNodeNumber.Call(uintptr(unsafe.Pointer(&highestNode)))
+	if r == 0 {
+		return 1
+	}
+	return int(highestNode) + 1
+}
+
+// platformPinCurrentThread pins the current OS thread to the specified CPU,
+// or without one to the processors of the NUMA node within its first group.
+func platformPinCurrentThread(numaNode, cpuID int) error {
+	runtime.LockOSThread()
+	cpus, nodes := loadTopology()
+	var ga groupAffinity
+	switch {
+	case cpuID >= 0:
+		if cpuID >= len(cpus) || cpus[cpuID].ID != cpuID {
+			return fmt.Errorf("cpu %d not in topology", cpuID)
+		}
+		c := cpus[cpuID]
+		ga = groupAffinity{mask: 1 << uint(c.Number), group: uint16(c.Group)}
+	case numaNode >= 0:
+		masks := nodes[numaNode]
+		if len(masks) == 0 {
+			return fmt.Errorf("numa node %d not in topology", numaNode)
+		}
+		ga = masks[0]
+	default:
+		return nil
+	}
+	return setThreadGroupAffinity(&ga)
+}
+
+// platformUnpinCurrentThread resets affinity to all CPUs of the thread's
+// current processor group.
+func platformUnpinCurrentThread() error {
+	runtime.LockOSThread()
+	handle, _, _ := procGetCurrentThread.Call()
+	var ga groupAffinity
+	if r, _, err := procGetThreadGroupAffinity.Call(handle, uintptr(unsafe.Pointer(&ga))); r == 0 {
+		return fmt.Errorf("GetThreadGroupAffinity failed: %v", err)
+	}
+	n := windows.GetActiveProcessorCount(ga.group)
+	ga.mask = ^uintptr(0)
+	if n < uint32(unsafe.Sizeof(ga.mask))*8 {
+		ga.mask = 1<<n - 1
+	}
+	return setThreadGroupAffinity(&ga)
+}
+
+func setThreadGroupAffinity(ga *groupAffinity) error {
+	handle, _, _ := procGetCurrentThread.Call()
+	r, _, err := procSetThreadGroupAffinity.Call(handle, uintptr(unsafe.Pointer(ga)), 0)
+	if r == 0 {
+		return fmt.Errorf("SetThreadGroupAffinity failed: %v", err)
+	}
+	return nil
+}
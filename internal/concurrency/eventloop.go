@@ -13,6 +13,7 @@
 package concurrency
 
 import (
+	"runtime/debug"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -38,8 +39,15 @@ type EventLoop struct {
 	quitCh       chan struct{} // closed on Stop()
 	doneCh       chan struct{} // closed after Run() exits
 	running      atomic.Bool   // running state
+	fair         *fairQueue    // per-flow queues, owned by Run
+	onPanic      atomic.Pointer[PanicHandler]
+	panics       atomic.Uint64 // handler panics recovered
 }
 
+// PanicHandler receives a panic recovered from a handler with the event it
+// was handling and the goroutine's stack at the panic.
+type PanicHandler func(ev Event, recovered any, stack []byte)
+
 // NewEventLoop creates a new EventLoop with batchSize and ringCapacity parameters.
 // batchSize controls maximum number of events handled in one cycle.
 // ringCapacity defines the buffered channel capacity for incoming events.
@@ -51,6 +59,7 @@ func NewEventLoop(batchSize, ringCapacity int) *EventLoop {
 		quitCh:       make(chan struct{}),
 		doneCh:       make(chan struct{}),
 		running:      atomic.Bool{},
+		fair:         newFairQueue(),
 	}
 	el.handlers.Store([]EventHandler{}) // initialize with empty slice
 	return el
@@ -82,7 +91,8 @@ func (el *EventLoop) UnregisterHandler(h EventHandler) {
 }
 
 // Run starts the event loop which batches events and dispatches them to handlers.
-// It runs until Stop is called.
+// Events are dispatched fairly across flows (see Flow), up to batchSize per
+// cycle. It runs until Stop is called.
 func (el *EventLoop) Run() {
 	if !el.running.CompareAndSwap(false, true) {
 		return // Already running
@@ -92,31 +102,29 @@ func (el *EventLoop) Run() {
 		el.running.Store(false)
 	}()
 
-	batch := make([]Event, 0, el.batchSize)
+	q := el.fair
 	backoffNs := int64(1)
 	const maxBackoffNs = int64(1_000_000)
 
 	for {
-		// Clear batch
-		batch = batch[:0]
-
-		// Non-blocking drain upto batchSize events
+		// Non-blocking drain into the flow queues, bounded by the ring
+		// capacity so producers still feel backpressure.
 	DrainLoop:
-		for i := 0; i < el.batchSize; i++ {
+		for q.n < el.ringCapacity {
 			select {
 			case ev := <-el.inbox:
-				batch = append(batch, ev)
+				q.push(ev)
 			default:
 				break DrainLoop
 			}
 		}
 
-		if len(batch) == 0 {
+		if q.n == 0 {
 			select {
 			case <-el.quitCh:
 				return
 			case ev := <-el.inbox:
-				batch = append(batch, ev)
+				q.push(ev)
 				backoffNs = 1
 			case <-time.After(time.Duration(backoffNs) * time.Nanosecond):
 				backoffNs *= 2
@@ -126,12 +134,16 @@ func (el *EventLoop) Run() {
 			}
 		}
 
-		if len(batch) > 0 {
+		if q.n > 0 {
 			// Create snapshot of handlers slice
 			handlers := el.handlers.Load().([]EventHandler)
-			for _, ev := range batch {
+			for i := 0; i < max(el.batchSize, 1); i++ {
+				ev, ok := q.pop()
+				if !ok {
+					break
+				}
 				for _, handler := range handlers {
-					handler.HandleEvent(ev)
+					el.dispatch(handler, ev)
 				}
 			}
 			backoffNs = 1
@@ -139,11 +151,54 @@ func (el *EventLoop) Run() {
 	}
 }
 
+// dispatch hands ev to handler, recovering a panic so that one faulty
+// handler cannot stop the loop for every connection.
+func (el *EventLoop) dispatch(handler EventHandler, ev Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			el.panics.Add(1)
+			if fn := el.onPanic.Load(); fn != nil {
+				(*fn)(ev, r, debug.Stack())
+			}
+		}
+	}()
+	handler.HandleEvent(ev)
+}
+
+// SetPanicHandler installs fn to receive handler panics; nil removes it.
+// Panics are recovered and counted either way.
+func (el *EventLoop) SetPanicHandler(fn PanicHandler) {
+	if fn == nil {
+		el.onPanic.Store(nil)
+		return
+	}
+	el.onPanic.Store(&fn)
+}
+
+// Panics returns the number of handler panics recovered.
+func (el *EventLoop) Panics() uint64 {
+	return el.panics.Load()
+}
+
 // Pending returns approximate count of buffered events waiting in inbox.
 func (el *EventLoop) Pending() int {
 	return len(el.inbox)
 }
 
+// SetQuantum sets the cost each flow may dispatch per turn; n <= 0 restores
+// DefaultQuantum. Safe to call while running.
+func (el *EventLoop) SetQuantum(n int) {
+	if n <= 0 {
+		n = DefaultQuantum
+	}
+	el.fair.quantum.Store(int64(n))
+}
+
+// FairStats returns the scheduler's starvation metrics.
+func (el *EventLoop) FairStats() FairStats {
+	return el.fair.stats()
+}
+
 // Push adds an event to the event loop's inbox for processing.
 // Blocking, returns false if loop is stopped.
 func (el *EventLoop) Push(ev Event) bool {
@@ -12,6 +12,7 @@ package concurrency
 
 import (
 	"runtime"
+	"runtime/debug"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -31,6 +32,9 @@ type Executor struct {
 	wg            sync.WaitGroup
 
 	removeWorkerCh chan *worker // New: signals workers to exit and confirm termination.
+
+	onPanic atomic.Pointer[func(recovered any, stack []byte)]
+	panics  atomic.Uint64 // task panics recovered
 }
 
 // NewExecutor creates a new Executor with the given number of workers.
@@ -78,6 +82,21 @@ func (e *Executor) Submit(task TaskFunc) error {
 	}
 }
 
+// SetPanicHandler installs fn to receive task panics; nil removes it.
+// Panics are recovered and counted either way.
+func (e *Executor) SetPanicHandler(fn func(recovered any, stack []byte)) {
+	if fn == nil {
+		e.onPanic.Store(nil)
+		return
+	}
+	e.onPanic.Store(&fn)
+}
+
+// Panics returns the number of task panics recovered.
+func (e *Executor) Panics() uint64 {
+	return e.panics.Load()
+}
+
 // Resize dynamically scales the worker pool.
 func (e *Executor) Resize(newCount int) {
 	e.resizeRequest <- newCount
@@ -177,6 +196,13 @@ func (w *worker) run(numaNode int, wg *sync.WaitGroup) {
 }
 
 func (w *worker) safeExecute(task TaskFunc) {
-	defer func() { recover() }()
+	defer func() {
+		if r := recover(); r != nil {
+			w.executor.panics.Add(1)
+			if fn := w.executor.onPanic.Load(); fn != nil {
+				(*fn)(r, debug.Stack())
+			}
+		}
+	}()
 	task()
 }
@@ -0,0 +1,163 @@
+// File: internal/concurrency/fair.go
+//
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Deficit round robin across flows. The EventLoop drains its inbox into one
+// FIFO per flow (typically a connection) and dispatches from the flows in
+// turn, each spending at most a quantum of cost per turn, so one connection
+// flooding frames cannot starve the others. Order within a flow is kept.
+
+package concurrency
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/momentics/hioload-ws/control"
+)
+
+// DefaultQuantum is the cost, in payload bytes, a flow may dispatch per turn.
+const DefaultQuantum = 16 * 1024
+
+// Flow is implemented by events that belong to a flow, e.g. the frames and
+// lifecycle events of one connection. Events without a flow share one.
+type Flow interface {
+	// FlowKey identifies the flow; keys are compared with ==.
+	FlowKey() any
+	// FlowCost is the share of the flow's quantum the event uses, e.g. its
+	// payload size.
+	FlowCost() int
+}
+
+// FairStats reports how the scheduler shares the loop between flows.
+type FairStats struct {
+	Flows     int                       // flows with queued events
+	Deferred  uint64                    // turns ended with events left for a later round
+	QueueWait *control.LatencyHistogram // time from Push to dispatch
+}
+
+type queuedEvent struct {
+	ev   Event
+	cost int
+	at   time.Time
+}
+
+type flowQueue struct {
+	key        any
+	events     []queuedEvent
+	deficit    int
+	turn       bool       // the flow's quantum was granted this turn
+	prev, next *flowQueue // ring of active flows
+}
+
+// fairQueue holds the events drained from the inbox. It is used by the Run
+// goroutine only; the counters are atomic for FairStats.
+type fairQueue struct {
+	quantum  atomic.Int64
+	flows    map[any]*flowQueue
+	head     *flowQueue // first of the active flows in round-robin order
+	cur      *flowQueue // flow whose turn it is
+	n        int        // queued events
+	nflows   atomic.Int64
+	deferred atomic.Uint64
+	wait     *control.LatencyHistogram
+}
+
+func newFairQueue() *fairQueue {
+	q := &fairQueue{
+		flows: make(map[any]*flowQueue),
+		wait:  control.NewLatencyHistogram(),
+	}
+	q.quantum.Store(DefaultQuantum)
+	return q
+}
+
+// push queues ev at the tail of its flow.
+func (q *fairQueue) push(ev Event) {
+	var key any
+	cost := 0
+	if f, ok := ev.(Flow); ok {
+		key, cost = f.FlowKey(), f.FlowCost()
+	}
+	f := q.flows[key]
+	if f == nil {
+		f = &flowQueue{key: key}
+		q.flows[key] = f
+		q.link(f)
+	}
+	f.events = append(f.events, queuedEvent{ev: ev, cost: cost, at: time.Now()})
+	q.n++
+}
+
+// pop returns the next event in deficit round-robin order.
+func (q *fairQueue) pop() (Event, bool) {
+	quantum := int(q.quantum.Load())
+	for q.cur != nil {
+		f := q.cur
+		if !f.turn {
+			f.turn = true
+			f.deficit += quantum
+		}
+		head := f.events[0]
+		if head.cost <= f.deficit {
+			f.deficit -= head.cost
+			f.events[0] = queuedEvent{}
+			f.events = f.events[1:]
+			q.n--
+			if len(f.events) == 0 {
+				// An idle flow keeps no credit; the next flow takes the turn.
+				q.unlink(f)
+				delete(q.flows, f.key)
+			}
+			q.wait.Since(head.at)
+			return head.ev, true
+		}
+		f.turn = false
+		if f.next != f {
+			q.deferred.Add(1)
+		}
+		q.cur = f.next
+	}
+	return nil, false
+}
+
+// link adds f as the last of the active flows.
+func (q *fairQueue) link(f *flowQueue) {
+	if q.head == nil {
+		f.prev, f.next = f, f
+		q.head, q.cur = f, f
+	} else {
+		f.prev, f.next = q.head.prev, q.head
+		f.prev.next = f
+		q.head.prev = f
+	}
+	q.nflows.Add(1)
+}
+
+// unlink removes f from the active flows in O(1); the turn passes to the
+// flow after f.
+func (q *fairQueue) unlink(f *flowQueue) {
+	if f.next == f {
+		q.head, q.cur = nil, nil
+	} else {
+		f.prev.next = f.next
+		f.next.prev = f.prev
+		if q.head == f {
+			q.head = f.next
+		}
+		if q.cur == f {
+			q.cur = f.next
+		}
+	}
+	f.prev, f.next = nil, nil
+	q.nflows.Add(-1)
+}
+
+func (q *fairQueue) stats() FairStats {
+	return FairStats{
+		Flows:     int(q.nflows.Load()),
+		Deferred:  q.deferred.Load(),
+		QueueWait: q.wait,
+	}
+}
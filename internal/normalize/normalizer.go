@@ -18,16 +18,17 @@ package normalize
 
 import (
 	"fmt"
-	"runtime"
 	"sync"
 
+	"github.com/momentics/hioload-ws/control"
 	"github.com/momentics/hioload-ws/internal/concurrency"
 )
 
 var (
-	// For logging normalization events (can be replaced with structured logger).
+	// For logging normalization events; fallbacks are expected on
+	// single-node hosts, so they are reported at debug level.
 	logNormalize = func(msg string, args ...any) {
-		fmt.Printf("[normalize] "+msg+"\n", args...)
+		control.Logger(control.LogNormalize).Debug(fmt.Sprintf(msg, args...))
 	}
 	mu sync.Mutex
 )
@@ -77,9 +78,10 @@ func CPUIndex(requested int, maxCPUs int) int {
 	return requested
 }
 
-// CPUIndexAuto tries to use preferred index, else picks 0.
+// CPUIndexAuto tries to use preferred index, else picks 0. Indexes run over
+// concurrency.Topology, across all processor groups on Windows.
 func CPUIndexAuto(requested int) int {
-	cnt := runtime.NumCPU()
+	cnt := len(concurrency.Topology())
 	if requested < 0 {
 		return 0 // or could do smarter affinity detection
 	}
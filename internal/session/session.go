@@ -9,6 +9,7 @@ package session
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/momentics/hioload-ws/api"
@@ -16,23 +17,26 @@ import (
 
 // sessionImpl holds per-connection state, context, and cancellation.
 type sessionImpl struct {
-	id       string
-	ctx      api.Context
-	done     chan struct{}
-	once     sync.Once
-	deadline time.Time
+	id         string
+	ctx        api.Context
+	done       chan struct{}
+	once       sync.Once
+	deadline   atomic.Int64 // unix nanos, 0 = none
+	lastActive atomic.Int64 // unix nanos of the last Touch
 }
 
-// Ensure compile-time API compliance if api.Session exists:
-// var _ api.Session = (*sessionImpl)(nil)
+// Ensure compile-time API compliance.
+var _ api.Session = (*sessionImpl)(nil)
 
 // newSession creates a new session with the given unique identifier.
 func newSession(id string) *sessionImpl {
-	return &sessionImpl{
+	s := &sessionImpl{
 		id:   id,
 		ctx:  NewContextStore(),
 		done: make(chan struct{}),
 	}
+	s.lastActive.Store(time.Now().UnixNano())
+	return s
 }
 
 // ID returns the unique session identifier.
@@ -45,6 +49,21 @@ func (s *sessionImpl) Context() api.Context {
 	return s.ctx
 }
 
+// Get returns a session attribute.
+func (s *sessionImpl) Get(key string) (any, bool) {
+	return s.ctx.Get(key)
+}
+
+// Set stores a non-propagated session attribute.
+func (s *sessionImpl) Set(key string, value any) {
+	s.ctx.Set(key, value, false)
+}
+
+// Delete removes a session attribute.
+func (s *sessionImpl) Delete(key string) {
+	s.ctx.Delete(key)
+}
+
 // Cancel signals session teardown; idempotent.
 func (s *sessionImpl) Cancel() {
 	s.once.Do(func() {
@@ -59,13 +78,24 @@ func (s *sessionImpl) Done() <-chan struct{} {
 
 // Deadline returns the session expiration if set.
 func (s *sessionImpl) Deadline() (time.Time, bool) {
-	if s.deadline.IsZero() {
+	d := s.deadline.Load()
+	if d == 0 {
 		return time.Time{}, false
 	}
-	return s.deadline, true
+	return time.Unix(0, d), true
 }
 
 // WithDeadline sets an absolute deadline for the session.
 func (s *sessionImpl) WithDeadline(t time.Time) {
-	s.deadline = t
+	s.deadline.Store(t.UnixNano())
+}
+
+// Touch records activity, deferring idle expiry.
+func (s *sessionImpl) Touch() {
+	s.lastActive.Store(time.Now().UnixNano())
+}
+
+// LastActive returns the time of the most recent Touch.
+func (s *sessionImpl) LastActive() time.Time {
+	return time.Unix(0, s.lastActive.Load())
 }
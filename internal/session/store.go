@@ -17,18 +17,22 @@ import (
 // SessionManager defines operations on sessions.
 type SessionManager interface {
 	Create(id string) (Session, error)
+	// Open returns the session for id and whether it was newly created.
+	Open(id string) (Session, bool)
 	Get(id string) (Session, bool)
 	Delete(id string)
+	// Remove cancels and deletes the session, returning it if it was present.
+	Remove(id string) (Session, bool)
 	Range(func(Session))
+	Len() int
 }
 
 // Session abstracts per-connection session state.
 type Session interface {
-	ID() string
-	Context() api.Context
+	api.Session
 	Cancel()
-	Done() <-chan struct{}
-	Deadline() (time.Time, bool)
+	WithDeadline(t time.Time)
+	LastActive() time.Time
 }
 
 // sessionManager implements sharded storage for sessions.
@@ -64,15 +68,21 @@ func (m *sessionManager) shard(id string) *sessionShard {
 
 // Create returns existing or new session for id.
 func (m *sessionManager) Create(id string) (Session, error) {
+	s, _ := m.Open(id)
+	return s, nil
+}
+
+// Open returns existing or new session for id, reporting creation.
+func (m *sessionManager) Open(id string) (Session, bool) {
 	sh := m.shard(id)
 	sh.mu.Lock()
 	defer sh.mu.Unlock()
 	if s, ok := sh.sessions[id]; ok {
-		return s, nil
+		return s, false
 	}
 	s := newSession(id)
 	sh.sessions[id] = s
-	return s, nil
+	return s, true
 }
 
 // Get fetches a session if present.
@@ -86,13 +96,23 @@ func (m *sessionManager) Get(id string) (Session, bool) {
 
 // Delete cancels and removes the session.
 func (m *sessionManager) Delete(id string) {
+	m.Remove(id)
+}
+
+// Remove cancels and removes the session, returning it if present.
+func (m *sessionManager) Remove(id string) (Session, bool) {
 	sh := m.shard(id)
 	sh.mu.Lock()
-	defer sh.mu.Unlock()
-	if s, ok := sh.sessions[id]; ok {
-		s.Cancel()
+	s, ok := sh.sessions[id]
+	if ok {
 		delete(sh.sessions, id)
 	}
+	sh.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	s.Cancel()
+	return s, true
 }
 
 // Range applies fn to all sessions.
@@ -106,6 +126,17 @@ func (m *sessionManager) Range(fn func(Session)) {
 	}
 }
 
+// Len returns the number of live sessions.
+func (m *sessionManager) Len() int {
+	n := 0
+	for _, sh := range m.shards {
+		sh.mu.RLock()
+		n += len(sh.sessions)
+		sh.mu.RUnlock()
+	}
+	return n
+}
+
 // fnv32 hashes a string to uint32.
 func fnv32(key string) uint32 {
 	h := fnv.New32a()
@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+// File: internal/transport/acceptex_other.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// AcceptEx is Windows-only; elsewhere the kernel's accept queue already
+// hands out connected sockets without per-connection setup to save.
+
+package transport
+
+import "net"
+
+// listenAcceptEx falls back to Listen.
+func listenAcceptEx(addr string, depth int) (net.Listener, error) {
+	return Listen(addr, false)
+}
@@ -0,0 +1,436 @@
+// File: internal/transport/acceptex_windows.go
+//go:build windows
+// +build windows
+
+//
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// TCP listener accepting with AcceptEx. A fixed set of accepts is kept
+// posted on the listening socket, each with a socket created in advance, and
+// each completes only once the client's first bytes (its TLS ClientHello or
+// upgrade request) arrived with the connection. Accepted sockets are served
+// by a windowsTransport on the same completion port.
+
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/momentics/hioload-ws/api"
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// acceptRecvSize is the room for the first bytes received with an accept.
+	acceptRecvSize = 2048
+	// acceptIdleLimit drops clients connected that long without sending, so
+	// silent clients cannot hold every posted accept.
+	acceptIdleLimit = 5 * time.Second
+	// acceptIOBufferSize is the buffer size of accepted connections.
+	acceptIOBufferSize = 4096
+	// soConnectTime is SO_CONNECT_TIME: seconds since the socket connected.
+	soConnectTime = 0x700C
+)
+
+// acceptAddrLen is the room AcceptEx needs for each address.
+var acceptAddrLen = uint32(unsafe.Sizeof(windows.RawSockaddrAny{})) + 16
+
+// acceptSlot is one posted AcceptEx and the socket it accepts into.
+type acceptSlot struct {
+	ol     windows.Overlapped
+	sock   windows.Handle
+	buf    []byte // first bytes, then the local and remote addresses
+	res    ioResult
+	posted bool // outstanding in the kernel
+	failed bool // could not be posted, retried by sweep
+}
+
+type acceptExListener struct {
+	sock   windows.Handle
+	family int
+	addr   *net.TCPAddr
+	port   *completionPort
+	key    uintptr
+
+	slots    map[*windows.Overlapped]*acceptSlot // fixed once built
+	accepted chan *acceptSlot                    // completed, for Accept
+	spare    chan windows.Handle                 // sockets created ahead of the accepts
+
+	mu     sync.Mutex // guards posting, closed and the slots' state
+	closed bool
+	done   chan struct{}
+
+	// AcceptEx operations not yet completed; the port keeps routing to the
+	// listener until a closed one has none left.
+	outstanding atomic.Int32
+}
+
+// listenAcceptEx binds addr and posts depth accepts on it.
+func listenAcceptEx(addr string, depth int) (net.Listener, error) {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	sock, family, err := bindTCP(tcpAddr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := portFor(0)
+	if err != nil {
+		windows.Closesocket(sock)
+		return nil, err
+	}
+	l := &acceptExListener{
+		sock:     sock,
+		family:   family,
+		port:     port,
+		slots:    make(map[*windows.Overlapped]*acceptSlot, depth),
+		accepted: make(chan *acceptSlot, depth),
+		spare:    make(chan windows.Handle, depth),
+		done:     make(chan struct{}),
+	}
+	if sa, err := windows.Getsockname(sock); err == nil {
+		l.addr = tcpAddrOf(sa)
+	}
+	if l.key, err = port.associate(sock, l); err != nil {
+		windows.Closesocket(sock)
+		return nil, err
+	}
+	for i := 0; i < depth; i++ {
+		s := &acceptSlot{sock: windows.InvalidHandle, buf: make([]byte, acceptRecvSize+2*acceptAddrLen)}
+		l.slots[&s.ol] = s
+	}
+	l.mu.Lock()
+	for _, s := range l.slots {
+		if err = l.postLocked(s); err != nil {
+			break
+		}
+	}
+	l.mu.Unlock()
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	go l.keepSpare()
+	go l.sweep()
+	return l, nil
+}
+
+// bindTCP returns a listening socket bound to addr. Addresses without an IP
+// listen on both IPv6 and IPv4 where the host has IPv6.
+func bindTCP(addr *net.TCPAddr) (windows.Handle, int, error) {
+	var sa windows.Sockaddr
+	family := windows.AF_INET6
+	if ip4 := addr.IP.To4(); ip4 != nil {
+		family = windows.AF_INET
+		sa4 := &windows.SockaddrInet4{Port: addr.Port}
+		copy(sa4.Addr[:], ip4)
+		sa = sa4
+	} else {
+		sa6 := &windows.SockaddrInet6{Port: addr.Port}
+		copy(sa6.Addr[:], addr.IP)
+		sa = sa6
+	}
+	sock, err := windows.Socket(family, windows.SOCK_STREAM, windows.IPPROTO_TCP)
+	if err != nil && addr.IP == nil {
+		family, sa = windows.AF_INET, &windows.SockaddrInet4{Port: addr.Port}
+		sock, err = windows.Socket(family, windows.SOCK_STREAM, windows.IPPROTO_TCP)
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("socket: %w", err)
+	}
+	if family == windows.AF_INET6 && addr.IP == nil {
+		_ = windows.SetsockoptInt(sock, windows.IPPROTO_IPV6, windows.IPV6_V6ONLY, 0)
+	}
+	if err := windows.Bind(sock, sa); err != nil {
+		windows.Closesocket(sock)
+		return 0, 0, fmt.Errorf("bind: %w", err)
+	}
+	if err := windows.Listen(sock, windows.SOMAXCONN); err != nil {
+		windows.Closesocket(sock)
+		return 0, 0, fmt.Errorf("listen: %w", err)
+	}
+	return sock, family, nil
+}
+
+// tcpAddrOf converts a socket address; nil or unknown ones give an empty
+// address.
+func tcpAddrOf(sa windows.Sockaddr) *net.TCPAddr {
+	switch a := sa.(type) {
+	case *windows.SockaddrInet4:
+		return &net.TCPAddr{IP: append(net.IP(nil), a.Addr[:]...), Port: a.Port}
+	case *windows.SockaddrInet6:
+		return &net.TCPAddr{IP: append(net.IP(nil), a.Addr[:]...), Port: a.Port}
+	}
+	return &net.TCPAddr{}
+}
+
+// postLocked posts the accept of s into a spare socket. l.mu is held.
+func (l *acceptExListener) postLocked(s *acceptSlot) error {
+	if l.closed {
+		return net.ErrClosed
+	}
+	var sock windows.Handle
+	select {
+	case sock = <-l.spare:
+	default:
+		var err error
+		if sock, err = windows.Socket(l.family, windows.SOCK_STREAM, windows.IPPROTO_TCP); err != nil {
+			s.failed = true
+			return fmt.Errorf("socket: %w", err)
+		}
+	}
+	s.sock, s.ol = sock, windows.Overlapped{}
+	var received uint32
+	l.outstanding.Add(1)
+	err := windows.AcceptEx(l.sock, sock, &s.buf[0], acceptRecvSize, acceptAddrLen, acceptAddrLen, &received, &s.ol)
+	if err != nil && err != windows.ERROR_IO_PENDING {
+		l.outstanding.Add(-1)
+		windows.Closesocket(sock)
+		s.sock, s.failed = windows.InvalidHandle, true
+		return fmt.Errorf("AcceptEx: %w", err)
+	}
+	s.posted, s.failed = true, false
+	return nil
+}
+
+// complete queues the completed accept of ol for Accept. It runs on the
+// workers of the completion port.
+func (l *acceptExListener) complete(ol *windows.Overlapped, res ioResult) {
+	s, ok := l.slots[ol]
+	if !ok {
+		return
+	}
+	l.mu.Lock()
+	s.res, s.posted = res, false
+	if l.closed {
+		windows.Closesocket(s.sock)
+		s.sock = windows.InvalidHandle
+	} else {
+		l.accepted <- s // room for every slot
+	}
+	last := l.outstanding.Add(-1) == 0 && l.closed
+	l.mu.Unlock()
+	if last {
+		l.port.forget(l.key)
+	}
+}
+
+// Accept returns the next connection, with the bytes received along with it
+// read first, and reposts its accept. Clients that left or were dropped as
+// idle before sending anything are skipped.
+func (l *acceptExListener) Accept() (net.Conn, error) {
+	for {
+		var s *acceptSlot
+		select {
+		case s = <-l.accepted:
+		case <-l.done:
+			return nil, &net.OpError{Op: "accept", Net: "tcp", Addr: l.addr, Err: net.ErrClosed}
+		}
+		conn, err := l.finish(s)
+		l.mu.Lock()
+		perr := l.postLocked(s)
+		l.mu.Unlock()
+		if err == nil {
+			return conn, nil
+		}
+		if perr != nil && !errors.Is(perr, net.ErrClosed) {
+			return nil, perr // the slot is retried by sweep
+		}
+	}
+}
+
+// finish turns the socket s accepted into a connection, taking it from s.
+func (l *acceptExListener) finish(s *acceptSlot) (net.Conn, error) {
+	sock := s.sock
+	s.sock = windows.InvalidHandle
+	err := s.res.err
+	if err == nil {
+		// Give the socket the listener's context, e.g. for getpeername.
+		err = windows.Setsockopt(sock, windows.SOL_SOCKET, windows.SO_UPDATE_ACCEPT_CONTEXT,
+			(*byte)(unsafe.Pointer(&l.sock)), int32(unsafe.Sizeof(l.sock)))
+	}
+	var wt *windowsTransport
+	if err == nil {
+		wt, err = newWindowsTransport(sock, acceptIOBufferSize, l.port.node)
+	}
+	if err != nil {
+		windows.Closesocket(sock)
+		return nil, err
+	}
+	_ = windows.SetsockoptInt(sock, windows.IPPROTO_TCP, windows.TCP_NODELAY, 1)
+
+	var lsa, rsa *windows.RawSockaddrAny
+	var llen, rlen int32
+	windows.GetAcceptExSockaddrs(&s.buf[0], acceptRecvSize, acceptAddrLen, acceptAddrLen, &lsa, &llen, &rsa, &rlen)
+	c := &acceptedConn{wt: wt, laddr: rawTCPAddr(lsa), raddr: rawTCPAddr(rsa)}
+	if s.res.bytes > 0 {
+		c.pending = [][]byte{append([]byte(nil), s.buf[:s.res.bytes]...)}
+	}
+	return c, nil
+}
+
+func rawTCPAddr(rsa *windows.RawSockaddrAny) *net.TCPAddr {
+	if rsa == nil {
+		return &net.TCPAddr{}
+	}
+	sa, _ := rsa.Sockaddr()
+	return tcpAddrOf(sa)
+}
+
+// keepSpare creates sockets ahead of the accepts that will use them until
+// the listener closes.
+func (l *acceptExListener) keepSpare() {
+	defer func() {
+		for {
+			select {
+			case sock := <-l.spare:
+				windows.Closesocket(sock)
+			default:
+				return
+			}
+		}
+	}()
+	for {
+		sock, err := windows.Socket(l.family, windows.SOCK_STREAM, windows.IPPROTO_TCP)
+		if err != nil {
+			select {
+			case <-time.After(100 * time.Millisecond):
+				continue
+			case <-l.done:
+				return
+			}
+		}
+		select {
+		case l.spare <- sock:
+		case <-l.done:
+			windows.Closesocket(sock)
+			return
+		}
+	}
+}
+
+// sweep cancels accepts whose client has sent nothing for acceptIdleLimit
+// and retries those that could not be posted, until the listener closes.
+func (l *acceptExListener) sweep() {
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+		case <-l.done:
+			return
+		}
+		l.mu.Lock()
+		for _, s := range l.slots {
+			switch {
+			case s.failed:
+				_ = l.postLocked(s)
+			case s.posted:
+				// -1 until a client connects.
+				secs, err := windows.GetsockoptInt(s.sock, windows.SOL_SOCKET, soConnectTime)
+				if err == nil && secs >= int(acceptIdleLimit/time.Second) {
+					windows.CancelIoEx(l.sock, &s.ol)
+				}
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// Close stops accepting; accepts still posted are aborted and their sockets
+// closed as they complete.
+func (l *acceptExListener) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.done)
+	err := windows.Closesocket(l.sock)
+	for drained := false; !drained; {
+		select {
+		case s := <-l.accepted:
+			windows.Closesocket(s.sock)
+			s.sock = windows.InvalidHandle
+		default:
+			drained = true
+		}
+	}
+	last := l.outstanding.Load() == 0
+	l.mu.Unlock()
+	if last {
+		l.port.forget(l.key)
+	}
+	return err
+}
+
+// Addr returns the address the listener is bound to.
+func (l *acceptExListener) Addr() net.Addr {
+	return l.addr
+}
+
+// acceptedConn is a connection accepted by acceptExListener, served by a
+// windowsTransport, whose first bytes arrived with the accept.
+type acceptedConn struct {
+	wt           *windowsTransport
+	laddr, raddr *net.TCPAddr
+
+	readMu  sync.Mutex
+	pending [][]byte // received and not yet read
+}
+
+func (c *acceptedConn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	for len(c.pending) == 0 {
+		bufs, err := c.wt.Recv()
+		if err != nil {
+			return 0, connError(err)
+		}
+		c.pending = bufs
+	}
+	n := copy(p, c.pending[0])
+	if c.pending[0] = c.pending[0][n:]; len(c.pending[0]) == 0 {
+		c.pending = c.pending[1:]
+	}
+	return n, nil
+}
+
+func (c *acceptedConn) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := c.wt.Send([][]byte{p}); err != nil {
+		return 0, connError(err)
+	}
+	return len(p), nil
+}
+
+// connError reports a closed transport as net.ErrClosed, as net.Conn does.
+func connError(err error) error {
+	if errors.Is(err, api.ErrTransportClosed) {
+		return net.ErrClosed
+	}
+	return err
+}
+
+func (c *acceptedConn) Close() error         { return c.wt.Close() }
+func (c *acceptedConn) LocalAddr() net.Addr  { return c.laddr }
+func (c *acceptedConn) RemoteAddr() net.Addr { return c.raddr }
+
+func (c *acceptedConn) SetDeadline(t time.Time) error {
+	c.wt.SetReadDeadline(t)
+	return c.wt.SetWriteDeadline(t)
+}
+
+func (c *acceptedConn) SetReadDeadline(t time.Time) error  { return c.wt.SetReadDeadline(t) }
+func (c *acceptedConn) SetWriteDeadline(t time.Time) error { return c.wt.SetWriteDeadline(t) }
@@ -0,0 +1,119 @@
+// File: internal/transport/acceptworkers.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Handshakes on a pool of workers fed by a bounded queue of accepted
+// connections, so the TLS and header parsing cost of one client does not
+// hold up accepting the next during connection storms.
+
+package transport
+
+import (
+	"errors"
+
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// WithAcceptWorkers performs handshakes on workers goroutines fed through a
+// queue of up to queue accepted connections; queue <= 0 means workers. Once
+// the queue is full, further connections wait in the kernel's accept
+// backlog. Without it, Accept performs each handshake itself.
+func WithAcceptWorkers(workers, queue int) ListenerOption {
+	return func(wsl *WebSocketListener) {
+		wsl.workers = max(workers, 0)
+		wsl.queueSize = queue
+		if queue <= 0 {
+			wsl.queueSize = wsl.workers
+		}
+	}
+}
+
+// acceptResult is a handshake completed by a worker, or an accept failure.
+type acceptResult struct {
+	conn *protocol.WSConnection
+	err  error
+}
+
+// Pending returns the number of accepted connections waiting for a
+// handshake worker, always 0 without WithAcceptWorkers.
+func (wsl *WebSocketListener) Pending() int {
+	return len(wsl.pending)
+}
+
+// acceptFromWorkers starts the accept goroutine and workers on first use and
+// returns the next result they deliver.
+func (wsl *WebSocketListener) acceptFromWorkers() (*protocol.WSConnection, error) {
+	wsl.startOnce.Do(func() {
+		go wsl.acceptLoop()
+		for i := 0; i < wsl.workers; i++ {
+			go wsl.handshakeWorker(i)
+		}
+	})
+	select {
+	case r := <-wsl.results:
+		return r.conn, r.err
+	case <-wsl.done:
+		return nil, ErrListenerClosed
+	}
+}
+
+// acceptLoop queues accepted connections for the workers until Close, then
+// closes those still queued.
+func (wsl *WebSocketListener) acceptLoop() {
+	defer func() {
+		for {
+			select {
+			case conn := <-wsl.pending:
+				conn.Close()
+			default:
+				close(wsl.pending)
+				return
+			}
+		}
+	}()
+	for {
+		conn, err := wsl.acceptConn()
+		if errors.Is(err, ErrListenerClosed) {
+			return
+		}
+		if err != nil {
+			if !wsl.deliver(acceptResult{err: err}) {
+				return
+			}
+			continue
+		}
+		select {
+		case wsl.pending <- conn:
+		case <-wsl.done:
+			conn.Close()
+			return
+		}
+	}
+}
+
+// handshakeWorker upgrades queued connections until the queue is closed;
+// those still queued after Close are dropped.
+func (wsl *WebSocketListener) handshakeWorker(i int) {
+	for conn := range wsl.pending {
+		select {
+		case <-wsl.done:
+			conn.Close()
+			continue
+		default:
+		}
+		wsConn, err := wsl.handshake(conn, &wsl.handshakeStart[i])
+		if !wsl.deliver(acceptResult{conn: wsConn, err: err}) && wsConn != nil {
+			wsConn.Close()
+		}
+	}
+}
+
+// deliver hands r to Accept, reporting false if the listener closed first.
+func (wsl *WebSocketListener) deliver(r acceptResult) bool {
+	select {
+	case wsl.results <- r:
+		return true
+	case <-wsl.done:
+		return false
+	}
+}
@@ -0,0 +1,119 @@
+// File: internal/transport/iocp_windows.go
+//go:build windows
+// +build windows
+
+//
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Completion ports shared by all Windows transports, one per NUMA node,
+// each serviced by a fixed pool of worker threads pinned to its node.
+// Sockets are associated with a completion key that routes completions back
+// to their transport, so no goroutine is spent per connection.
+
+package transport
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/momentics/hioload-ws/internal/concurrency"
+	"golang.org/x/sys/windows"
+)
+
+// completer receives the completions of a socket associated with a port:
+// a transport, or an AcceptEx listener.
+type completer interface {
+	complete(ol *windows.Overlapped, res ioResult)
+}
+
+// completionPort is an IOCP shared by the sockets of one NUMA node.
+type completionPort struct {
+	handle windows.Handle
+	node   int
+
+	mu      sync.RWMutex
+	conns   map[uintptr]completer // by completion key
+	nextKey uintptr
+}
+
+var (
+	portsOnce sync.Once
+	ports     []*completionPort // by NUMA node, created on first use
+	portsMu   sync.Mutex
+)
+
+// portFor returns the completion port of node, creating it and starting its
+// workers on first use.
+func portFor(node int) (*completionPort, error) {
+	portsOnce.Do(func() {
+		ports = make([]*completionPort, max(concurrency.NUMANodes(), 1))
+	})
+	if node < 0 || node >= len(ports) {
+		node = 0
+	}
+	portsMu.Lock()
+	defer portsMu.Unlock()
+	if p := ports[node]; p != nil {
+		return p, nil
+	}
+	h, err := windows.CreateIoCompletionPort(windows.InvalidHandle, 0, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("CreateIoCompletionPort: %w", err)
+	}
+	p := &completionPort{handle: h, node: node, conns: make(map[uintptr]completer)}
+	for i := 0; i < max(runtime.GOMAXPROCS(0)/len(ports), 1); i++ {
+		go p.serve()
+	}
+	ports[node] = p
+	return p, nil
+}
+
+// associate routes the completions of sock to c and returns the completion
+// key it was given.
+func (p *completionPort) associate(sock windows.Handle, c completer) (uintptr, error) {
+	p.mu.Lock()
+	p.nextKey++
+	key := p.nextKey
+	p.conns[key] = c
+	p.mu.Unlock()
+	if _, err := windows.CreateIoCompletionPort(sock, p.handle, key, 0); err != nil {
+		p.forget(key)
+		return 0, fmt.Errorf("CreateIoCompletionPort: %w", err)
+	}
+	return key, nil
+}
+
+// forget drops the completer of key; later completions for it are ignored.
+func (p *completionPort) forget(key uintptr) {
+	p.mu.Lock()
+	delete(p.conns, key)
+	p.mu.Unlock()
+}
+
+// serve dispatches completions to their completers for the life of the
+// process.
+func (p *completionPort) serve() {
+	runtime.LockOSThread()
+	_ = concurrency.PinCurrentThread(p.node, -1)
+
+	var bytesTransferred uint32
+	var key uintptr
+	var ol *windows.Overlapped
+	for {
+		err := windows.GetQueuedCompletionStatus(p.handle, &bytesTransferred, &key, &ol, windows.INFINITE)
+		if ol == nil {
+			if err != nil {
+				return // the port itself failed
+			}
+			continue
+		}
+		p.mu.RLock()
+		c := p.conns[key]
+		p.mu.RUnlock()
+		if c != nil {
+			c.complete(ol, ioResult{bytes: bytesTransferred, err: err})
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// File: internal/transport/ipfilter.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// CIDR allow/deny lists checked on accept, before any handshake byte is
+// read, so refused peers cost one accept and one close. Rules are swapped
+// atomically for hot reload; a rule kept across a reload keeps its counter.
+
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+)
+
+// ErrAddressDenied is returned by Accept for a peer refused by the IPFilter.
+var ErrAddressDenied = errors.New("address denied by IP filter")
+
+// IPFilter admits or refuses peers by IP address. Deny rules are checked
+// first; when allow rules exist, a peer must match one of them. Addresses
+// that are not IP, such as Unix domain sockets, are always admitted.
+type IPFilter struct {
+	rules   atomic.Pointer[ipRules]
+	refused atomic.Uint64 // peers matching no allow rule
+}
+
+type ipRules struct {
+	deny, allow []*ipRule
+}
+
+type ipRule struct {
+	name   string // "deny 10.0.0.0/8"
+	prefix netip.Prefix
+	hits   atomic.Uint64
+}
+
+// NewIPFilter returns a filter with the given rules, see SetRules.
+func NewIPFilter(allow, deny []string) (*IPFilter, error) {
+	f := &IPFilter{}
+	if err := f.SetRules(allow, deny); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// SetRules replaces the rules. Entries are CIDR prefixes ("10.0.0.0/8",
+// "2001:db8::/32") or single addresses. On error the rules are unchanged.
+func (f *IPFilter) SetRules(allow, deny []string) error {
+	old := f.rules.Load()
+	var prev map[string]*ipRule
+	if old != nil {
+		prev = make(map[string]*ipRule, len(old.deny)+len(old.allow))
+		for _, r := range append(old.deny[:len(old.deny):len(old.deny)], old.allow...) {
+			prev[r.name] = r
+		}
+	}
+	next := &ipRules{}
+	var err error
+	if next.deny, err = parseIPRules("deny", deny, prev); err != nil {
+		return err
+	}
+	if next.allow, err = parseIPRules("allow", allow, prev); err != nil {
+		return err
+	}
+	f.rules.Store(next)
+	return nil
+}
+
+func parseIPRules(kind string, entries []string, prev map[string]*ipRule) ([]*ipRule, error) {
+	rules := make([]*ipRule, 0, len(entries))
+	for _, e := range entries {
+		e = strings.TrimSpace(e)
+		var p netip.Prefix
+		var err error
+		if strings.Contains(e, "/") {
+			p, err = netip.ParsePrefix(e)
+		} else {
+			var a netip.Addr
+			if a, err = netip.ParseAddr(e); err == nil {
+				a = a.Unmap()
+				p = netip.PrefixFrom(a, a.BitLen())
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ip filter: %s %q: %w", kind, e, err)
+		}
+		p = p.Masked()
+		name := kind + " " + p.String()
+		if r, ok := prev[name]; ok {
+			rules = append(rules, r)
+			continue
+		}
+		rules = append(rules, &ipRule{name: name, prefix: p})
+	}
+	return rules, nil
+}
+
+// Admit reports whether a peer at addr may connect, counting the rule hit.
+func (f *IPFilter) Admit(addr net.Addr) bool {
+	rules := f.rules.Load()
+	if rules == nil || len(rules.deny)+len(rules.allow) == 0 {
+		return true
+	}
+	var ip netip.Addr
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip, _ = netip.AddrFromSlice(a.IP)
+	case *net.UDPAddr:
+		ip, _ = netip.AddrFromSlice(a.IP)
+	}
+	if !ip.IsValid() {
+		return true
+	}
+	ip = ip.Unmap()
+	for _, r := range rules.deny {
+		if r.prefix.Contains(ip) {
+			r.hits.Add(1)
+			return false
+		}
+	}
+	if len(rules.allow) == 0 {
+		return true
+	}
+	for _, r := range rules.allow {
+		if r.prefix.Contains(ip) {
+			r.hits.Add(1)
+			return true
+		}
+	}
+	f.refused.Add(1)
+	return false
+}
+
+// Hits returns the number of peers each current rule matched, keyed as
+// "allow 10.0.0.0/8" or "deny 192.0.2.1/32".
+func (f *IPFilter) Hits() map[string]uint64 {
+	hits := make(map[string]uint64)
+	if rules := f.rules.Load(); rules != nil {
+		for _, r := range append(rules.deny[:len(rules.deny):len(rules.deny)], rules.allow...) {
+			hits[r.name] = r.hits.Load()
+		}
+	}
+	return hits
+}
+
+// Refused returns the number of peers refused for matching no allow rule.
+func (f *IPFilter) Refused() uint64 {
+	return f.refused.Load()
+}
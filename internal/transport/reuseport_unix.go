@@ -0,0 +1,28 @@
+//go:build !windows
+// +build !windows
+
+// File: internal/transport/reuseport_unix.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// SO_REUSEPORT lets several processes bind one address, e.g. an old and a
+// new binary during a restart; the kernel spreads new connections across them.
+
+package transport
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// reusePortControl sets SO_REUSEPORT on a socket before it is bound.
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	var serr error
+	if err := c.Control(func(fd uintptr) {
+		serr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
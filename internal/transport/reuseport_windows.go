@@ -0,0 +1,21 @@
+//go:build windows
+// +build windows
+
+// File: internal/transport/reuseport_windows.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Windows has no SO_REUSEPORT; SO_REUSEADDR there lets any process steal a
+// bound port, so it is not offered as a substitute.
+
+package transport
+
+import (
+	"errors"
+	"syscall"
+)
+
+// reusePortControl rejects SO_REUSEPORT listeners.
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT is not supported on windows")
+}
@@ -0,0 +1,76 @@
+// File: internal/transport/splice.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Splicing two established connections for proxies: once the proxy has done
+// the handshakes and decided to relay the rest unchanged, bytes flow between
+// the connections without being framed again. On Linux TCP connections are
+// spliced in the kernel through a sockmap, see splice_linux.go; otherwise,
+// and where the kernel refuses, bytes are copied by two goroutines.
+
+package transport
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	// spliceDrainTimeout caps the wait for a send queue to drain before
+	// passing on a FIN after a kernel splice, see drainSend.
+	spliceDrainTimeout = time.Second
+	// spliceDrainPoll is the interval at which the send queue is polled.
+	spliceDrainPoll = time.Millisecond
+)
+
+// Splice relays bytes between a and b in both directions until both have
+// ended, passing each end's FIN on to the other, then closes them. Bytes
+// either connection holds in userspace buffers, e.g. read past a handshake,
+// must be written to the other first. It reports whether the kernel relayed
+// the bytes; the error is the first failure of either direction.
+func Splice(a, b net.Conn) (inKernel bool, err error) {
+	ta, okA := a.(*net.TCPConn)
+	tb, okB := b.(*net.TCPConn)
+	var undo func()
+	if okA && okB {
+		undo, err = spliceKernel(ta, tb)
+		inKernel = err == nil
+	}
+	errc := make(chan error, 2)
+	go relay(b, a, inKernel, errc)
+	go relay(a, b, inKernel, errc)
+	err = errors.Join(<-errc, <-errc)
+	if undo != nil {
+		undo()
+	}
+	a.Close()
+	b.Close()
+	return inKernel, err
+}
+
+// relay copies src to dst until src ends, then shuts down dst's writes.
+// After a kernel splice it only sees bytes the kernel passed up, and waits
+// for the end of src, then for the bytes the kernel redirected to dst to
+// leave its send queue.
+func relay(dst, src net.Conn, inKernel bool, errc chan<- error) {
+	var err error
+	if inKernel {
+		// Hiding the concrete types keeps io.Copy off splice(2), which
+		// does not see bytes the sockmap passes up.
+		_, err = io.Copy(struct{ io.Writer }{dst}, struct{ io.Reader }{src})
+		drainSend(dst, spliceDrainTimeout)
+	} else {
+		_, err = io.Copy(dst, src)
+	}
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	} else {
+		dst.Close()
+	}
+	if errors.Is(err, net.ErrClosed) {
+		err = nil
+	}
+	errc <- err
+}
@@ -0,0 +1,248 @@
+//go:build linux
+// +build linux
+
+// File: internal/transport/splice_linux.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Kernel splicing: a sockhash maps the socket cookie of each spliced
+// connection to its peer, and an SK_SKB verdict program attached to it
+// redirects every segment a member receives to the egress of the peer found
+// under its own cookie. Segments of sockets without a peer pass up to
+// userspace. The map and program are created once per process and need
+// CAP_BPF and CAP_NET_ADMIN (or root).
+
+package transport
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	spliceMaxConns = 1 << 16 // connections spliced at once, both ends counted
+
+	bpfFuncGetSocketCookie = 46 // BPF_FUNC_get_socket_cookie
+	bpfFuncSkRedirectHash  = 72 // BPF_FUNC_sk_redirect_hash
+	skPass                 = 1  // SK_PASS
+)
+
+// bpfInsn is struct bpf_insn.
+type bpfInsn struct {
+	code uint8
+	regs uint8 // dst in the low nibble, src in the high one
+	off  int16
+	imm  int32
+}
+
+// bpfPtr is a pointer in a bpf_attr, a __u64, in its first element.
+// Keeping it typed lets the runtime track what it points to; on 32-bit
+// big-endian hosts the kernel reads it shifted and fails, leaving Splice to
+// copy.
+type bpfPtr [8 / unsafe.Sizeof(uintptr(0))]unsafe.Pointer
+
+func insn(code uint8, dst, src uint8, off int16, imm int32) bpfInsn {
+	return bpfInsn{code: code, regs: dst | src<<4, off: off, imm: imm}
+}
+
+// spliceMap is the process-wide sockhash and its verdict program.
+var spliceMap struct {
+	once sync.Once
+	fd   int
+	err  error
+}
+
+// spliceKernel puts a and b in the sockhash as each other's peer and returns
+// the function taking them out again.
+func spliceKernel(a, b *net.TCPConn) (func(), error) {
+	spliceMap.once.Do(func() {
+		spliceMap.fd, spliceMap.err = newSpliceMap()
+	})
+	if spliceMap.err != nil {
+		return nil, spliceMap.err
+	}
+	fdA, cookieA, err := socketCookie(a)
+	if err != nil {
+		return nil, err
+	}
+	fdB, cookieB, err := socketCookie(b)
+	if err != nil {
+		return nil, err
+	}
+	if err := mapUpdate(spliceMap.fd, cookieA, fdB); err != nil {
+		return nil, err
+	}
+	if err := mapUpdate(spliceMap.fd, cookieB, fdA); err != nil {
+		mapDelete(spliceMap.fd, cookieA)
+		return nil, err
+	}
+	return func() {
+		mapDelete(spliceMap.fd, cookieA)
+		mapDelete(spliceMap.fd, cookieB)
+	}, nil
+}
+
+// socketCookie returns the descriptor and the kernel's cookie of c's socket.
+// The descriptor stays valid while c is open.
+func socketCookie(c *net.TCPConn) (int, uint64, error) {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return 0, 0, err
+	}
+	var fd int
+	var cookie uint64
+	var serr error
+	if err := raw.Control(func(s uintptr) {
+		fd = int(s)
+		cookie, serr = unix.GetsockoptUint64(fd, unix.SOL_SOCKET, unix.SO_COOKIE)
+	}); err != nil {
+		return 0, 0, err
+	}
+	if serr != nil {
+		return 0, 0, fmt.Errorf("splice: SO_COOKIE: %w", serr)
+	}
+	return fd, cookie, nil
+}
+
+// drainSend waits until the send queue of c, bytes written but not yet
+// acknowledged by its peer, is empty, or for at most timeout. It returns at
+// once if c is no TCP connection or the queue cannot be read.
+func drainSend(c net.Conn, timeout time.Duration) {
+	tc, ok := c.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	raw, err := tc.SyscallConn()
+	if err != nil {
+		return
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		var queued int
+		var qerr error
+		if err := raw.Control(func(s uintptr) {
+			queued, qerr = unix.IoctlGetInt(int(s), unix.SIOCOUTQ)
+		}); err != nil || qerr != nil || queued == 0 || !time.Now().Before(deadline) {
+			return
+		}
+		time.Sleep(spliceDrainPoll)
+	}
+}
+
+// newSpliceMap creates the sockhash and attaches the parser and verdict
+// programs to it.
+func newSpliceMap() (int, error) {
+	attr := struct {
+		mapType, keySize, valueSize, maxEntries, flags uint32
+	}{unix.BPF_MAP_TYPE_SOCKHASH, 8, 4, spliceMaxConns, 0}
+	m, err := bpf(unix.BPF_MAP_CREATE, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err != nil {
+		return 0, fmt.Errorf("splice: sockhash: %w", err)
+	}
+	verdict, err := loadSKB([]bpfInsn{
+		insn(unix.BPF_ALU64|unix.BPF_MOV|unix.BPF_X, 6, 1, 0, 0), // r6 = skb
+		insn(unix.BPF_JMP|unix.BPF_CALL, 0, 0, 0, bpfFuncGetSocketCookie),
+		insn(unix.BPF_STX|unix.BPF_MEM|unix.BPF_DW, 10, 0, -8, 0), // key on the stack
+		insn(unix.BPF_ALU64|unix.BPF_MOV|unix.BPF_X, 1, 6, 0, 0),
+		insn(unix.BPF_LD|unix.BPF_DW|unix.BPF_IMM, 2, unix.BPF_PSEUDO_MAP_FD, 0, int32(m)),
+		insn(0, 0, 0, 0, 0),
+		insn(unix.BPF_ALU64|unix.BPF_MOV|unix.BPF_X, 3, 10, 0, 0),
+		insn(unix.BPF_ALU64|unix.BPF_ADD|unix.BPF_K, 3, 0, 0, -8),
+		insn(unix.BPF_ALU64|unix.BPF_MOV|unix.BPF_K, 4, 0, 0, 0), // to the peer's egress
+		insn(unix.BPF_JMP|unix.BPF_CALL, 0, 0, 0, bpfFuncSkRedirectHash),
+		insn(unix.BPF_JMP|unix.BPF_JNE|unix.BPF_K, 0, 0, 1, 0), // redirected
+		insn(unix.BPF_ALU64|unix.BPF_MOV|unix.BPF_K, 0, 0, 0, skPass),
+		insn(unix.BPF_JMP|unix.BPF_EXIT, 0, 0, 0, 0),
+	})
+	if err == nil {
+		// The parser takes each segment whole. Verdicts without one
+		// (5.13 and later) break half-closed connections.
+		var parser int
+		parser, err = loadSKB([]bpfInsn{
+			insn(unix.BPF_LDX|unix.BPF_MEM|unix.BPF_W, 0, 1, 0, 0), // r0 = skb->len
+			insn(unix.BPF_JMP|unix.BPF_EXIT, 0, 0, 0, 0),
+		})
+		if err == nil {
+			err = attach(m, parser, unix.BPF_SK_SKB_STREAM_PARSER)
+			unix.Close(parser) // held by the map once attached
+		}
+		if err == nil {
+			err = attach(m, verdict, unix.BPF_SK_SKB_STREAM_VERDICT)
+		}
+		unix.Close(verdict)
+	}
+	if err != nil {
+		unix.Close(m)
+		return 0, err
+	}
+	return m, nil
+}
+
+// loadSKB loads an SK_SKB program.
+func loadSKB(prog []bpfInsn) (int, error) {
+	license := []byte("Apache-2.0\x00")
+	attr := struct {
+		progType, insnCnt   uint32
+		insns, license      bpfPtr
+		logLevel, logSize   uint32
+		logBuf              bpfPtr
+		kernVersion, pflags uint32
+	}{
+		progType: unix.BPF_PROG_TYPE_SK_SKB,
+		insnCnt:  uint32(len(prog)),
+		insns:    bpfPtr{unsafe.Pointer(&prog[0])},
+		license:  bpfPtr{unsafe.Pointer(&license[0])},
+	}
+	fd, err := bpf(unix.BPF_PROG_LOAD, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err != nil {
+		return 0, fmt.Errorf("splice: load program: %w", err)
+	}
+	return fd, nil
+}
+
+// attach attaches prog to the map m as attachType.
+func attach(m, prog int, attachType uint32) error {
+	attr := struct {
+		target, prog, attachType, flags uint32
+	}{uint32(m), uint32(prog), attachType, 0}
+	if _, err := bpf(unix.BPF_PROG_ATTACH, unsafe.Pointer(&attr), unsafe.Sizeof(attr)); err != nil {
+		return fmt.Errorf("splice: attach program: %w", err)
+	}
+	return nil
+}
+
+func mapUpdate(m int, key uint64, fd int) error {
+	value := uint32(fd)
+	attr := struct {
+		fd          uint32
+		_           uint32
+		key, value  bpfPtr
+		updateFlags uint64
+	}{fd: uint32(m), key: bpfPtr{unsafe.Pointer(&key)}, value: bpfPtr{unsafe.Pointer(&value)}, updateFlags: unix.BPF_ANY}
+	if _, err := bpf(unix.BPF_MAP_UPDATE_ELEM, unsafe.Pointer(&attr), unsafe.Sizeof(attr)); err != nil {
+		return fmt.Errorf("splice: add socket: %w", err)
+	}
+	return nil
+}
+
+func mapDelete(m int, key uint64) {
+	attr := struct {
+		fd  uint32
+		_   uint32
+		key bpfPtr
+	}{fd: uint32(m), key: bpfPtr{unsafe.Pointer(&key)}}
+	bpf(unix.BPF_MAP_DELETE_ELEM, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+}
+
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	r, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(r), nil
+}
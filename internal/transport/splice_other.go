@@ -0,0 +1,24 @@
+//go:build !linux
+// +build !linux
+
+// File: internal/transport/splice_other.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Sockmaps are Linux-only; elsewhere Splice copies in userspace.
+
+package transport
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// spliceKernel is not supported on this platform.
+func spliceKernel(a, b *net.TCPConn) (func(), error) {
+	return nil, errors.New("splice: not supported on this platform")
+}
+
+// drainSend does nothing: without a kernel splice no bytes bypass Splice.
+func drainSend(c net.Conn, timeout time.Duration) {}
@@ -0,0 +1,104 @@
+//go:build linux
+// +build linux
+
+// File: internal/transport/steer_linux.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Accept steering: a classic BPF program attached to an SO_REUSEPORT group
+// picks the listening socket by the CPU the connection arrived on, so each
+// NUMA node's listener accepts the connections its own CPUs handled.
+
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Ancillary load of the current CPU, linux/filter.h.
+const (
+	skfAdOff = 0xfffff000 // SKF_AD_OFF, -0x1000
+	skfAdCPU = 36         // SKF_AD_CPU
+)
+
+// SteerByNUMA attaches to the SO_REUSEPORT group of lns a program that
+// hands a connection arriving on a CPU of nodes[i] to lns[i]; connections on
+// other CPUs are spread by the kernel's hash as usual. lns must have been
+// bound in order, so that lns[i] is the i-th socket of the group.
+func SteerByNUMA(lns []net.Listener, nodes []int) error {
+	if len(lns) == 0 || len(lns) != len(nodes) {
+		return errors.New("steer: one listener per node required")
+	}
+	var prog []unix.SockFilter
+	prog = append(prog, unix.SockFilter{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: skfAdOff + skfAdCPU})
+	for i, node := range nodes {
+		ranges, err := nodeCPUs(node)
+		if err != nil {
+			return err
+		}
+		for _, r := range ranges {
+			// if lo <= cpu && cpu <= hi { return i }
+			prog = append(prog,
+				unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K, Jt: 0, Jf: 2, K: uint32(r[0])},
+				unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JGT | unix.BPF_K, Jt: 1, Jf: 0, K: uint32(r[1])},
+				unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: uint32(i)},
+			)
+		}
+	}
+	// An index past the group falls back to the hash.
+	prog = append(prog, unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: 0xffffffff})
+
+	sc, ok := lns[0].(syscall.Conn)
+	if !ok {
+		return fmt.Errorf("steer: %T has no socket", lns[0])
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	fprog := unix.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]}
+	var serr error
+	if err := raw.Control(func(fd uintptr) {
+		serr = unix.SetsockoptSockFprog(int(fd), unix.SOL_SOCKET, unix.SO_ATTACH_REUSEPORT_CBPF, &fprog)
+	}); err != nil {
+		return err
+	}
+	if serr != nil {
+		return fmt.Errorf("steer: %w", serr)
+	}
+	return nil
+}
+
+// nodeCPUs returns the CPU ranges of a NUMA node from sysfs, e.g. "0-3,8-11"
+// as [[0 3] [8 11]].
+func nodeCPUs(node int) ([][2]int, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/sys/devices/system/node/node%d/cpulist", node))
+	if err != nil {
+		return nil, fmt.Errorf("steer: cpus of node %d: %w", node, err)
+	}
+	var ranges [][2]int
+	for _, part := range strings.Split(strings.TrimSpace(string(data)), ",") {
+		if part == "" {
+			continue
+		}
+		lo, hi, _ := strings.Cut(part, "-")
+		if hi == "" {
+			hi = lo
+		}
+		a, err1 := strconv.Atoi(lo)
+		b, err2 := strconv.Atoi(hi)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("steer: bad cpulist %q", data)
+		}
+		ranges = append(ranges, [2]int{a, b})
+	}
+	return ranges, nil
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+// File: internal/transport/steer_other.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Accept steering needs SO_ATTACH_REUSEPORT_CBPF, which only Linux has;
+// elsewhere the listeners of a group share connections as the OS chooses.
+
+package transport
+
+import (
+	"errors"
+	"net"
+)
+
+// SteerByNUMA is not supported on this platform.
+func SteerByNUMA(lns []net.Listener, nodes []int) error {
+	return errors.New("steer: not supported on this platform")
+}
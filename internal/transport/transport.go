@@ -12,12 +12,11 @@ package transport
 
 import (
 	"fmt"
-	"os"
 	"runtime"
 	"sync"
-	"time"
 
 	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/control"
 )
 
 // TransportFactory produces blanket api.Transport instances using
@@ -39,15 +38,8 @@ func NewTransportFactory(ioBufferSize, numaNode int) *TransportFactory {
 var detectedTransportType string
 var transportTypeOnce sync.Once
 
-func logToFile(msg string) {
-	f, err := os.OpenFile("c:\\hioload-ws\\debug_log.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return
-	}
-	defer f.Close()
-	ts := time.Now().Format("15:04:05.000")
-	fmt.Fprintf(f, "[%s] %s\n", ts, msg)
-}
+// transportLog is the logger of the transport layer.
+var transportLog = control.Logger(control.LogTransport)
 
 // detectRuntimeTransportType performs runtime detection of the best available transport
 func detectRuntimeTransportType() string {
@@ -80,10 +72,10 @@ func (f *TransportFactory) Create() (api.Transport, error) {
 	}
 
 	if err != nil {
-		logToFile(fmt.Sprintf("TransportFactory: Error creating impl: %v", err))
+		transportLog.Error("transport creation failed", "error", err)
 		return nil, fmt.Errorf("transport init: %w", err)
 	}
-	logToFile("TransportFactory: Success")
+	transportLog.Debug("transport created", "type", transportType)
 	return &safeWrapper{impl: impl}, nil
 }
 
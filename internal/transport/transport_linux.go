@@ -58,9 +58,7 @@ func newTransportInternal(ioBufferSize, numaNode int) (api.Transport, error) {
 		if err == nil {
 			return uringTransport, nil
 		}
-		// Log the error but try epoll as fallback
-		// In production, you might want to use proper logging
-		fmt.Printf("io_uring initialization failed: %v, falling back to epoll\n", err)
+		transportLog.Warn("io_uring initialization failed, falling back to epoll", "error", err)
 	}
 	// Fallback to epoll
 	return newEpollTransportInternal(ioBufferSize, numaNode)
@@ -98,7 +96,7 @@ func newEpollTransportFromConnInternal(conn interface{}, ioBufferSize, numaNode
 	}
 
 	node := normalizeNUMANode(numaNode)
-	bufPool := pool.NewBufferPoolManager(concurrency.NUMANodes()).GetPool(ioBufferSize, node)
+	bufPool := pool.DefaultPool(ioBufferSize, node)
 	return &epollTransport{
 		fd:           sysFd,
 		bufPool:      bufPool,
@@ -192,7 +190,7 @@ func newEpollClientTransportInternal(addr string, ioBufferSize, numaNode int) (a
 	}
 
 	node := normalizeNUMANode(numaNode)
-	bufPool := pool.NewBufferPoolManager(concurrency.NUMANodes()).GetPool(ioBufferSize, node)
+	bufPool := pool.DefaultPool(ioBufferSize, node)
 
 	return &epollTransport{
 		fd:           newFd,
@@ -220,7 +218,7 @@ func newEpollTransportInternal(ioBufferSize, numaNode int) (api.Transport, error
 	_ = unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_NODELAY, 1)
 
 	// Create NUMA-aware buffer pool
-	bufPool := pool.NewBufferPoolManager(concurrency.NUMANodes()).GetPool(ioBufferSize, node)
+	bufPool := pool.DefaultPool(ioBufferSize, node)
 
 	return &epollTransport{
 		fd:           fd,
@@ -262,7 +260,7 @@ func newIoURingTransportInternal(ioBufferSize, numaNode int) (api.Transport, err
 	}
 
 	// Create NUMA-aware buffer pool
-	bufPool := pool.NewBufferPoolManager(concurrency.NUMANodes()).GetPool(ioBufferSize, node)
+	bufPool := pool.DefaultPool(ioBufferSize, node)
 
 	return &ioURingTransport{
 		fd:           fd,
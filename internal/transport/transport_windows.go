@@ -7,6 +7,7 @@
 // License: Apache-2.0
 //
 // Windows-native NUMA-aware, batch-enabled transport using IOCP, WSASend/WSARecv.
+// Completions are dispatched by the shared completion ports of iocp_windows.go.
 // Full support for buffer pool NUMA pinning. Integrated with latest BufferPoolManager.
 
 package transport
@@ -15,7 +16,9 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"os"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -27,31 +30,129 @@ import (
 
 const maxBatch = 32
 
+// recvDepth is the number of WSARecv operations kept outstanding per socket,
+// each filling maxBatch/recvDepth buffers. The kernel fills them in the order
+// they were posted, so Recv consumes them round-robin.
+const recvDepth = 4
+
+// Timeouts of Recv and Send, matching those of net.Conn.
+var (
+	errReadTimeout  = fmt.Errorf("read timeout: %w", os.ErrDeadlineExceeded)
+	errWriteTimeout = fmt.Errorf("write timeout: %w", os.ErrDeadlineExceeded)
+)
+
 type ioResult struct {
 	bytes uint32
 	err   error
 }
 
+// overlappedOp is an overlapped operation whose completion the dispatcher
+// routes back through done. The Overlapped must be stable in memory while
+// the operation is outstanding.
+type overlappedOp struct {
+	ol   windows.Overlapped
+	done chan ioResult
+}
+
+// recvSlot is one WSARecv and the buffers it fills.
+type recvSlot struct {
+	overlappedOp
+	wsabufs []windows.WSABuf
+	bufs    [][]byte // nil once handed out by Recv, refilled before reposting
+	posted  bool
+}
+
 type windowsTransport struct {
 	recvMu       sync.Mutex
 	sendMu       sync.Mutex
 	socket       windows.Handle
-	iocp         windows.Handle
+	port         *completionPort // shared IOCP of the node, see iocp_windows.go
+	key          uintptr         // completion key of socket on port
 	bufPool      api.BufferPool
 	ioBufferSize int
 	numaNode     int
 	closed       bool
 	closeMu      sync.RWMutex
+	done         chan struct{} // closed by Close
+
+	readDeadline  deadline
+	writeDeadline deadline
 
-	readDeadline  time.Time
-	writeDeadline time.Time
+	recv     []*recvSlot // outstanding receives, consumed from recvHead
+	recvHead int
+	send     overlappedOp
+
+	// ops routes completions to their operation by Overlapped address; fixed
+	// once the transport is built.
+	ops map[*windows.Overlapped]*overlappedOp
+
+	// Operations issued and not yet completed. The port keeps routing to
+	// the transport, and so keeps its Overlapped structures alive, until a
+	// closed transport has none left.
+	outstanding atomic.Int32
+	closing     atomic.Bool
+}
+
+// deadline is a read or write deadline; a wait already running observes
+// changes to it.
+type deadline struct {
+	mu      sync.Mutex
+	t       time.Time
+	changed chan struct{} // closed when t changes, made on demand
+}
+
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	d.t = t
+	if d.changed != nil {
+		close(d.changed)
+		d.changed = nil
+	}
+	d.mu.Unlock()
+}
 
-	// Overlapped structures must be stable in memory
-	recvOverlapped windows.Overlapped
-	sendOverlapped windows.Overlapped
+// get returns the deadline and a channel closed when it next changes.
+func (d *deadline) get() (time.Time, <-chan struct{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.changed == nil {
+		d.changed = make(chan struct{})
+	}
+	return d.t, d.changed
+}
 
-	recvDone chan ioResult
-	sendDone chan ioResult
+// newWindowsTransport wraps sock and associates it with the completion port
+// of node.
+func newWindowsTransport(sock windows.Handle, ioBufferSize, node int) (*windowsTransport, error) {
+	port, err := portFor(node)
+	if err != nil {
+		return nil, err
+	}
+	wt := &windowsTransport{
+		socket:       sock,
+		port:         port,
+		bufPool:      pool.DefaultPool(ioBufferSize, node), // shared process-wide manager, not one per socket
+		ioBufferSize: ioBufferSize,
+		numaNode:     node,
+		done:         make(chan struct{}),
+		recv:         make([]*recvSlot, recvDepth),
+		send:         overlappedOp{done: make(chan ioResult, 1)},
+		ops:          make(map[*windows.Overlapped]*overlappedOp, recvDepth+1),
+	}
+	wt.ops[&wt.send.ol] = &wt.send
+	for i := range wt.recv {
+		s := &recvSlot{
+			overlappedOp: overlappedOp{done: make(chan ioResult, 1)},
+			wsabufs:      make([]windows.WSABuf, maxBatch/recvDepth),
+			bufs:         make([][]byte, maxBatch/recvDepth),
+		}
+		wt.recv[i] = s
+		wt.ops[&s.ol] = &s.overlappedOp
+	}
+	if wt.key, err = port.associate(sock, wt); err != nil {
+		return nil, err
+	}
+	return wt, nil
 }
 
 // newTransportInternal creates a NUMA-aware batch transport for Windows.
@@ -68,27 +169,11 @@ func newTransportInternal(ioBufferSize, numaNode int) (api.Transport, error) {
 		return nil, fmt.Errorf("socket create: %w", err)
 	}
 	_ = windows.SetsockoptInt(sock, windows.IPPROTO_TCP, windows.TCP_NODELAY, 1)
-	iocp, err := windows.CreateIoCompletionPort(sock, 0, 0, 0)
+	wt, err := newWindowsTransport(sock, ioBufferSize, node)
 	if err != nil {
 		windows.Closesocket(sock)
-		return nil, fmt.Errorf("CreateIoCompletionPort: %w", err)
+		return nil, err
 	}
-	// Explicitly supply number of NUMA nodes to BufferPoolManager.
-	bufPool := pool.NewBufferPoolManager(nodeCnt).GetPool(ioBufferSize, node)
-
-	wt := &windowsTransport{
-		socket:       sock,
-		iocp:         iocp,
-		bufPool:      bufPool,
-		ioBufferSize: ioBufferSize,
-		numaNode:     node,
-		recvDone:     make(chan ioResult, 1),
-		sendDone:     make(chan ioResult, 1),
-	}
-
-	// Start dispatcher
-	go wt.dispatchLoop()
-
 	return wt, nil
 }
 
@@ -130,27 +215,7 @@ func newTransportFromConnInternal(conn interface{}, ioBufferSize, numaNode int)
 		node = 0
 	}
 
-	iocp, err := windows.CreateIoCompletionPort(socketHandle, 0, 0, 0)
-	if err != nil {
-		return nil, fmt.Errorf("CreateIoCompletionPort from handle: %w", err)
-	}
-
-	bufPool := pool.NewBufferPoolManager(nodeCnt).GetPool(ioBufferSize, node)
-
-	wt := &windowsTransport{
-		socket:       socketHandle,
-		iocp:         iocp,
-		bufPool:      bufPool,
-		ioBufferSize: ioBufferSize,
-		numaNode:     node,
-		recvDone:     make(chan ioResult, 1),
-		sendDone:     make(chan ioResult, 1),
-	}
-
-	// Start dispatcher
-	go wt.dispatchLoop()
-
-	return wt, nil
+	return newWindowsTransport(socketHandle, ioBufferSize, node)
 }
 
 // newClientTransportInternal creates a new client connection on Windows using raw sockets and IOCP.
@@ -190,70 +255,94 @@ func newClientTransportInternal(addr string, ioBufferSize, numaNode int) (api.Tr
 		node = 0
 	}
 
-	iocp, err := windows.CreateIoCompletionPort(sock, 0, 0, 0)
+	wt, err := newWindowsTransport(sock, ioBufferSize, node)
 	if err != nil {
 		windows.Closesocket(sock)
-		return nil, fmt.Errorf("CreateIoCompletionPort: %w", err)
+		return nil, err
 	}
+	return wt, nil
+}
 
-	bufPool := pool.NewBufferPoolManager(nodeCnt).GetPool(ioBufferSize, node)
-
-	wt := &windowsTransport{
-		socket:       sock,
-		iocp:         iocp,
-		bufPool:      bufPool,
-		ioBufferSize: ioBufferSize,
-		numaNode:     node,
-		recvDone:     make(chan ioResult, 1),
-		sendDone:     make(chan ioResult, 1),
+// complete hands the completion of ol to the operation that issued it. It
+// runs on the workers of the completion port.
+func (wt *windowsTransport) complete(ol *windows.Overlapped, res ioResult) {
+	op, ok := wt.ops[ol]
+	if !ok {
+		return
 	}
+	select {
+	case op.done <- res:
+	default:
+		// logToFile("Disp: done channel full/abandoned")
+	}
+	if wt.outstanding.Add(-1) == 0 && wt.closing.Load() {
+		wt.port.forget(wt.key)
+	}
+}
 
-	// Start dispatcher
-	go wt.dispatchLoop()
-
-	return wt, nil
+// issued counts an operation just issued with err, uncounting it if it
+// failed without queueing a completion.
+func (wt *windowsTransport) issued(err error) error {
+	if err != nil && err != windows.ERROR_IO_PENDING {
+		wt.outstanding.Add(-1)
+		return err
+	}
+	return nil
 }
 
-func (wt *windowsTransport) dispatchLoop() {
-	var bytesTransferred uint32
-	var key uintptr
-	var ol *windows.Overlapped
+// wait returns the completion of op, or errTimeout once d, if given, passes.
+func (wt *windowsTransport) wait(op *overlappedOp, d *deadline, errTimeout error) (ioResult, error) {
 	for {
-		err := windows.GetQueuedCompletionStatus(wt.iocp, &bytesTransferred, &key, &ol, windows.INFINITE)
-		if ol == nil {
-			if err != nil {
-				// IOCP closed or heavy error?
-				if err == windows.ERROR_ABANDONED_WAIT_0 {
-					return // IOCP closed
-				}
-				// logToFile(fmt.Sprintf("Disp: IOCP Error global: %v", err))
-				// Should not happen with INFINITE wait unless IOCP closed
-				return
+		var timeout <-chan time.Time
+		var changed <-chan struct{}
+		var t *time.Timer
+		if d != nil {
+			var at time.Time
+			at, changed = d.get()
+			if !at.IsZero() {
+				t = time.NewTimer(max(time.Until(at), 0))
+				timeout = t.C
 			}
-			continue
 		}
-
-		res := ioResult{
-			bytes: bytesTransferred,
-			err:   err,
+		select {
+		case res := <-op.done:
+			stopTimer(t)
+			return res, nil
+		case <-timeout:
+			return ioResult{}, errTimeout
+		case <-changed:
+			stopTimer(t) // wait again with the new deadline
+		case <-wt.done:
+			stopTimer(t)
+			return ioResult{}, api.ErrTransportClosed
 		}
+	}
+}
 
-		if ol == &wt.recvOverlapped {
-			select {
-			case wt.recvDone <- res:
-			default:
-				// logToFile("Disp: Recv Done channel full/abandoned")
-			}
-		} else if ol == &wt.sendOverlapped {
-			select {
-			case wt.sendDone <- res:
-			default:
-				// logToFile("Disp: Send Done channel full/abandoned")
-			}
-		} else {
-			// logToFile(fmt.Sprintf("Disp: Unknown overlapped completion: %p", ol))
+func stopTimer(t *time.Timer) {
+	if t != nil {
+		t.Stop()
+	}
+}
+
+// postRecv refills the buffers s handed out and issues its WSARecv.
+func (wt *windowsTransport) postRecv(s *recvSlot) error {
+	for i := range s.bufs {
+		if s.bufs[i] == nil {
+			data := wt.bufPool.Get(wt.ioBufferSize, wt.numaNode).Bytes()
+			s.bufs[i] = data
+			s.wsabufs[i] = windows.WSABuf{Len: uint32(len(data)), Buf: &data[0]}
 		}
 	}
+	s.ol = windows.Overlapped{}
+	var received, flags uint32
+	wt.outstanding.Add(1)
+	err := wt.issued(windows.WSARecv(wt.socket, &s.wsabufs[0], uint32(len(s.wsabufs)), &received, &flags, &s.ol, nil))
+	if err != nil {
+		return fmt.Errorf("WSARecv batch: %w", err)
+	}
+	s.posted = true
+	return nil
 }
 
 // Stubs for Linux transports to satisfy cross-platform compilation of transport.go on Windows
@@ -281,26 +370,25 @@ func newEpollClientTransportInternal(addr string, ioBufferSize, numaNode int) (a
 	return nil, fmt.Errorf("epoll transport not supported on Windows")
 }
 
+// SetReadDeadline bounds Recv, including one already waiting.
 func (wt *windowsTransport) SetReadDeadline(t time.Time) error {
-	wt.recvMu.Lock()
-	defer wt.recvMu.Unlock()
-	wt.readDeadline = t
+	wt.readDeadline.set(t)
 	return nil
 }
 
+// SetWriteDeadline bounds Send, including one already waiting.
 func (wt *windowsTransport) SetWriteDeadline(t time.Time) error {
-	wt.sendMu.Lock()
-	defer wt.sendMu.Unlock()
-	wt.writeDeadline = t
+	wt.writeDeadline.set(t)
 	return nil
 }
 
+// Recv returns the data of the oldest outstanding receive and reposts it, so
+// recvDepth receives stay queued in the kernel. A read timeout leaves the
+// receive outstanding for the next call.
 func (wt *windowsTransport) Recv() ([][]byte, error) {
 	wt.recvMu.Lock()
 	defer wt.recvMu.Unlock()
 
-	// logToFile("Recv: Start")
-
 	wt.closeMu.RLock()
 	if wt.closed {
 		wt.closeMu.RUnlock()
@@ -308,92 +396,38 @@ func (wt *windowsTransport) Recv() ([][]byte, error) {
 	}
 	wt.closeMu.RUnlock()
 
-	wsabufs := make([]windows.WSABuf, maxBatch)
-	bufs := make([][]byte, maxBatch)
-
-	// Allocate buffers
-	for i := 0; i < maxBatch; i++ {
-		buf := wt.bufPool.Get(wt.ioBufferSize, wt.numaNode)
-		data := buf.Bytes()
-		bufs[i] = data
-		wsabufs[i].Len = uint32(len(data))
-		wsabufs[i].Buf = &data[0]
-	}
-
-	wt.recvOverlapped = windows.Overlapped{} // Clear it
-
-	var received uint32
-	var flags uint32
-
-	select {
-	case <-wt.recvDone:
-	default:
-	}
-
-	// fmt.Println("Recv: Issuing WSARecv")
-	err := windows.WSARecv(wt.socket, &wsabufs[0], uint32(maxBatch), &received, &flags, &wt.recvOverlapped, nil)
-	// fmt.Printf("DEBUG: WSARecv ret: err=%v, received=%d\n", err, received)
-	if err != nil && err != windows.ERROR_IO_PENDING {
-		// logToFile(fmt.Sprintf("Recv: WSARecv immediate error: %v", err))
-		return nil, fmt.Errorf("WSARecv batch: %w", err)
-	}
-
-	// Wait for completion
-	var batchBytes uint32
-
-	if !wt.readDeadline.IsZero() {
-		dur := time.Until(wt.readDeadline)
-		if dur < 0 {
-			dur = 0
-		}
-
-		select {
-		case res := <-wt.recvDone:
-			if res.err != nil {
-				// logToFile(fmt.Sprintf("Recv: Async error: %v", res.err))
-				return nil, fmt.Errorf("async recv error: %w", res.err)
+	for _, s := range wt.recv {
+		if !s.posted {
+			if err := wt.postRecv(s); err != nil {
+				return nil, err
 			}
-			batchBytes = res.bytes
-		case <-time.After(dur):
-			// logToFile("Recv: Timeout")
-			windows.CancelIoEx(wt.socket, &wt.recvOverlapped)
-			<-wt.recvDone
-			return nil, fmt.Errorf("read timeout")
 		}
-	} else {
-		res := <-wt.recvDone
-		if res.err != nil {
-			// logToFile(fmt.Sprintf("Recv: Async error (blocking): %v", res.err))
-			return nil, fmt.Errorf("async recv error: %w", res.err)
-		}
-		batchBytes = res.bytes
 	}
 
-	if batchBytes == 0 {
-		// logToFile("Recv: EOF 0 bytes")
+	s := wt.recv[wt.recvHead]
+	res, err := wt.wait(&s.overlappedOp, &wt.readDeadline, errReadTimeout)
+	if err != nil {
+		return nil, err
+	}
+	s.posted = false
+	wt.recvHead = (wt.recvHead + 1) % len(wt.recv)
+	if res.err != nil {
+		return nil, fmt.Errorf("async recv error: %w", res.err)
+	}
+	if res.bytes == 0 {
 		return nil, io.EOF
 	}
 
-	// Calculate usage
-	var consumed uint32 = 0
-	resultBufs := make([][]byte, 0, maxBatch)
-
-	for i := 0; i < maxBatch; i++ {
-		if consumed >= batchBytes {
-			break
-		}
-
-		capacity := wsabufs[i].Len
-		remaining := batchBytes - consumed
-		chunk := remaining
-		if chunk > capacity {
-			chunk = capacity
-		}
-
-		resultBufs = append(resultBufs, bufs[i][:chunk])
+	// Hand out the filled buffers; postRecv replaces them.
+	resultBufs := make([][]byte, 0, len(s.bufs))
+	for i, consumed := 0, uint32(0); i < len(s.bufs) && consumed < res.bytes; i++ {
+		chunk := min(res.bytes-consumed, s.wsabufs[i].Len)
+		resultBufs = append(resultBufs, s.bufs[i][:chunk])
+		s.bufs[i] = nil
 		consumed += chunk
 	}
-
+	// Requeued behind the others; a failure is reported by the next Recv.
+	_ = wt.postRecv(s)
 	return resultBufs, nil
 }
 
@@ -420,45 +454,33 @@ func (wt *windowsTransport) Send(buffers [][]byte) error {
 			wsabufs[i].Buf = &b[0]
 		}
 
-		wt.sendOverlapped = windows.Overlapped{} // Clear
+		wt.send.ol = windows.Overlapped{} // Clear
 
 		// Drain stale
 		select {
-		case <-wt.sendDone:
+		case <-wt.send.done:
 		default:
 		}
 
 		var sent uint32
-		err := windows.WSASend(wt.socket, &wsabufs[0], uint32(len(wsabufs)), &sent, 0, &wt.sendOverlapped, nil)
+		wt.outstanding.Add(1)
+		err := wt.issued(windows.WSASend(wt.socket, &wsabufs[0], uint32(len(wsabufs)), &sent, 0, &wt.send.ol, nil))
 		// fmt.Printf("DEBUG: WSASend ret: err=%v, sent=%d\n", err, sent)
-		if err != nil && err != windows.ERROR_IO_PENDING {
+		if err != nil {
 			// logToFile(fmt.Sprintf("Send: WSASend immediate error: %v", err))
 			return fmt.Errorf("WSASend batch: %w", err)
 		}
 
-		if !wt.writeDeadline.IsZero() {
-			dur := time.Until(wt.writeDeadline)
-			if dur < 0 {
-				dur = 0
-			}
-			select {
-			case res := <-wt.sendDone:
-				if res.err != nil {
-					// logToFile(fmt.Sprintf("Send: Async error: %v", res.err))
-					return fmt.Errorf("async send error: %w", res.err)
-				}
-			case <-time.After(dur):
-				// logToFile("Send: Timeout")
-				windows.CancelIoEx(wt.socket, &wt.sendOverlapped)
-				<-wt.sendDone
-				return fmt.Errorf("write timeout")
-			}
-		} else {
-			res := <-wt.sendDone
-			if res.err != nil {
-				// logToFile(fmt.Sprintf("Send: Async error (blocking): %v", res.err))
-				return fmt.Errorf("async send error: %w", res.err)
-			}
+		res, err := wt.wait(&wt.send, &wt.writeDeadline, errWriteTimeout)
+		if err == errWriteTimeout {
+			windows.CancelIoEx(wt.socket, &wt.send.ol)
+			wt.wait(&wt.send, nil, nil)
+		}
+		if err != nil {
+			return err
+		}
+		if res.err != nil {
+			return fmt.Errorf("async send error: %w", res.err)
 		}
 	}
 	return nil
@@ -470,9 +492,14 @@ func (wt *windowsTransport) Close() error {
 
 	if !wt.closed {
 		wt.closed = true
+		close(wt.done)
 		windows.CancelIoEx(wt.socket, nil)
-		windows.CloseHandle(wt.iocp) // This will wake up dispatcher
 		windows.Closesocket(wt.socket)
+		// Cancelled operations still complete; the last one releases the key.
+		wt.closing.Store(true)
+		if wt.outstanding.Load() == 0 {
+			wt.port.forget(wt.key)
+		}
 	}
 	return nil
 }
@@ -10,14 +10,18 @@
 package transport
 
 import (
-	"bufio"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/momentics/hioload-ws/api"
-	"github.com/momentics/hioload-ws/internal/concurrency"
 	"github.com/momentics/hioload-ws/pool"
 	"github.com/momentics/hioload-ws/protocol"
 )
@@ -26,111 +30,360 @@ import (
 type ListenerOption func(*WebSocketListener)
 
 // WithListenerNUMANode applies a specific NUMA node and associated buffer pool.
-// Reads NUMA nodes count from the concurrency module before constructing BufferPoolManager.
+// The pool is taken from the process-wide default manager.
 func WithListenerNUMANode(node int) ListenerOption {
 	return func(wsl *WebSocketListener) {
-		numaCount := concurrency.NUMANodes()
 		wsl.numaNode = node
-		wsl.bufferPool = pool.NewBufferPoolManager(numaCount).GetPool(4096, node)
+		wsl.bufferPool = pool.DefaultPool(4096, node)
+	}
+}
+
+// HandshakeHook runs after the upgrade request is validated and before the 101
+// response is written. It may bind state to conn and add response headers;
+// returning an error rejects the connection. req and resp are valid during
+// the call only; req.HTTPRequest builds the *http.Request if one is needed.
+type HandshakeHook func(conn *protocol.WSConnection, req *protocol.UpgradeRequest, resp http.Header) error
+
+// WithHandshakeHook installs a HandshakeHook on the listener.
+func WithHandshakeHook(hook HandshakeHook) ListenerOption {
+	return func(wsl *WebSocketListener) {
+		wsl.onHandshake = hook
+	}
+}
+
+// WithIPFilter refuses peers rejected by f as soon as they are accepted.
+func WithIPFilter(f *IPFilter) ListenerOption {
+	return func(wsl *WebSocketListener) {
+		wsl.ipFilter = f
+	}
+}
+
+// WithHandshakeObserver installs fn to receive the duration of every
+// successful handshake, from TCP accept to the 101 response being written.
+func WithHandshakeObserver(fn func(time.Duration)) ListenerOption {
+	return func(wsl *WebSocketListener) {
+		wsl.onHandshakeDone = fn
 	}
 }
 
 // WebSocketListener is a TCP->WebSocket handshake acceptor, NUMA-aware.
 type WebSocketListener struct {
-	listener    net.Listener
-	bufferPool  api.BufferPool
-	channelSize int
-	numaNode    int
-	closed      bool
+	listener        net.Listener
+	bufferPool      api.BufferPool
+	channelSize     int
+	numaNode        int
+	done            chan struct{} // closed by Close
+	closeOnce       sync.Once
+	onHandshake     HandshakeHook
+	onHandshakeDone func(time.Duration)
+	ipFilter        *IPFilter
+	handshakeStart  []atomic.Int64 // UnixNano of the handshake in progress per worker, 0 if none
+
+	// Set by WithAcceptWorkers, see acceptworkers.go.
+	workers   int
+	queueSize int
+	pending   chan net.Conn     // accepted connections awaiting a worker
+	results   chan acceptResult // handshakes completed by workers
+	startOnce sync.Once
+}
+
+// HandshakeInFlight returns how long Accept has been unable to start a new
+// handshake: the time the handshake in progress has been running, or with
+// WithAcceptWorkers the shortest time any worker has been busy, 0 if one is
+// idle. A large value means the accept path is stalled.
+func (wsl *WebSocketListener) HandshakeInFlight() time.Duration {
+	var busy time.Duration
+	for i := range wsl.handshakeStart {
+		start := wsl.handshakeStart[i].Load()
+		if start == 0 {
+			return 0
+		}
+		if d := time.Since(time.Unix(0, start)); busy == 0 || d < busy {
+			busy = d
+		}
+	}
+	return busy
 }
 
 // NewWebSocketListener binds TCP and configures NUMA-aware pools.
 func NewWebSocketListener(addr string, bufPool api.BufferPool, channelSize int, opts ...ListenerOption) (*WebSocketListener, error) {
-	ln, err := net.Listen("tcp", addr)
+	ln, err := Listen(addr, false)
 	if err != nil {
 		return nil, fmt.Errorf("listen %s: %w", addr, err)
 	}
+	return NewWebSocketListenerOn(ln, bufPool, channelSize, opts...), nil
+}
+
+// Listen opens a stream listener on addr: "unix:/path" for a Unix domain
+// socket, otherwise a TCP "host:port", bound with SO_REUSEPORT when
+// reusePort is set.
+func Listen(addr string, reusePort bool) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return net.Listen("unix", path)
+	}
+	var lc net.ListenConfig
+	if reusePort {
+		lc.Control = reusePortControl
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// ListenAcceptEx opens a TCP listener on addr that on Windows keeps depth
+// accepts posted with AcceptEx, each into a socket created in advance and
+// completing with the client's first bytes, see acceptex_windows.go.
+// Elsewhere, and for "unix:" addresses or depth <= 0, it is Listen.
+func ListenAcceptEx(addr string, depth int) (net.Listener, error) {
+	if depth <= 0 || strings.HasPrefix(addr, "unix:") {
+		return Listen(addr, false)
+	}
+	return listenAcceptEx(addr, depth)
+}
+
+// NewWebSocketListenerOn accepts WebSocket connections from ln, e.g. a TLS
+// listener or a Unix domain socket.
+func NewWebSocketListenerOn(ln net.Listener, bufPool api.BufferPool, channelSize int, opts ...ListenerOption) *WebSocketListener {
 	wsl := &WebSocketListener{
 		listener:    ln,
 		bufferPool:  bufPool,
 		channelSize: channelSize,
 		numaNode:    0,
+		done:        make(chan struct{}),
 	}
 	for _, opt := range opts {
 		opt(wsl)
 	}
-	return wsl, nil
+	wsl.handshakeStart = make([]atomic.Int64, max(wsl.workers, 1))
+	if wsl.workers > 0 {
+		wsl.pending = make(chan net.Conn, wsl.queueSize)
+		wsl.results = make(chan acceptResult)
+	}
+	return wsl
+}
+
+// Addr returns the listener's network address.
+func (wsl *WebSocketListener) Addr() net.Addr {
+	return wsl.listener.Addr()
 }
 
-// Accept TCP and perform strict WebSocket RFC6455 handshake.
+// Accept TCP and perform strict WebSocket RFC6455 handshake. With
+// WithAcceptWorkers, Accept returns the next handshake completed by a worker.
 func (wsl *WebSocketListener) Accept() (*protocol.WSConnection, error) {
-	if wsl.closed {
+	select {
+	case <-wsl.done:
 		return nil, ErrListenerClosed
+	default:
+	}
+	if wsl.workers > 0 {
+		return wsl.acceptFromWorkers()
+	}
+	tcpConn, err := wsl.acceptConn()
+	if err != nil {
+		return nil, err
 	}
+	return wsl.handshake(tcpConn, &wsl.handshakeStart[0])
+}
+
+// acceptConn accepts the next TCP connection the IP filter admits.
+func (wsl *WebSocketListener) acceptConn() (net.Conn, error) {
 	// fmt.Println("DEBUG: Server Accept waiting for connection")
 	tcpConn, err := wsl.listener.Accept()
 	if err != nil {
-		if strings.Contains(err.Error(), "closed network connection") {
+		if errors.Is(err, net.ErrClosed) {
 			return nil, ErrListenerClosed
 		}
 		return nil, err
 	}
 	// fmt.Println("DEBUG: Server Accept got connection")
-	
+	if wsl.ipFilter != nil && !wsl.ipFilter.Admit(tcpConn.RemoteAddr()) {
+		tcpConn.Close()
+		return nil, fmt.Errorf("%w: %s", ErrAddressDenied, tcpConn.RemoteAddr())
+	}
+	return tcpConn, nil
+}
+
+// handshake upgrades tcpConn, recording its start in start while it runs.
+func (wsl *WebSocketListener) handshake(tcpConn net.Conn, start *atomic.Int64) (*protocol.WSConnection, error) {
+	accepted := time.Now()
+	start.Store(accepted.UnixNano())
+	defer start.Store(0)
+
 	// Disable Nagle's algorithm for low-latency small packet transmission
-	if tc, ok := tcpConn.(*net.TCPConn); ok {
+	raw := tcpConn
+	if tc, ok := raw.(*tls.Conn); ok {
+		raw = tc.NetConn()
+	}
+	if tc, ok := raw.(*net.TCPConn); ok {
 		tc.SetNoDelay(true)
 	}
 
-	// Use buffered handshake to preserve any data read after HTTP headers
-	hdrs, path, br, err := protocol.DoHandshakeCoreBuffered(tcpConn)
+	// Complete TLS up front so its failures are told apart from bad requests.
+	if tc, ok := tcpConn.(*tls.Conn); ok {
+		if err := tc.Handshake(); err != nil {
+			tcpConn.Close()
+			return nil, &HandshakeError{RemoteAddr: tcpConn.RemoteAddr(), Reason: HandshakeTLS, Err: fmt.Errorf("tls handshake failed: %w", err)}
+		}
+	}
+
+	// The request is validated and answered from the views of ur.
+	ur, err := protocol.ReadUpgrade(tcpConn)
 	if err != nil {
 		tcpConn.Close()
-		return nil, fmt.Errorf("handshake request failed: %w", err)
+		return nil, &HandshakeError{RemoteAddr: tcpConn.RemoteAddr(), Reason: HandshakeBadRequest, Err: fmt.Errorf("handshake request failed: %w", err)}
 	}
+	defer ur.Release()
 	// fmt.Println("DEBUG: Server handshake request parsed")
-	if err := protocol.WriteHandshakeResponse(tcpConn, hdrs); err != nil {
-		tcpConn.Close()
-		return nil, fmt.Errorf("handshake response failed: %w", err)
-	}
-	// fmt.Println("DEBUG: Server handshake response written")
 
-	// Use buffered transport to preserve any data buffered during handshake
+	// Frames sent along with the request are returned by the first Recv.
 	tr := &bufferedConnTransport{
 		conn:       tcpConn,
-		br:         br,
 		bufferPool: wsl.bufferPool,
 		numaNode:   wsl.numaNode,
 	}
-	wsConn := protocol.NewWSConnectionWithPath(tr, wsl.bufferPool, wsl.channelSize, path)
+	if rest := ur.Rest(); len(rest) > 0 {
+		tr.pending = append([]byte(nil), rest...)
+	}
+	wsConn := protocol.NewWSConnectionWithPath(tr, wsl.bufferPool, wsl.channelSize, string(ur.Path()))
+	wsConn.SetRequestHead(ur.Head())
+
+	hdrs := respHeaderPool.Get().(http.Header)
+	defer func() {
+		clear(hdrs)
+		respHeaderPool.Put(hdrs)
+	}()
+	if wsl.onHandshake != nil {
+		if err := wsl.onHandshake(wsConn, ur, hdrs); err != nil {
+			var rej *protocol.HandshakeRejection
+			if errors.As(err, &rej) {
+				protocol.WriteHandshakeRejection(tcpConn, rej)
+			}
+			tcpConn.Close()
+			return nil, &HandshakeError{RemoteAddr: tcpConn.RemoteAddr(), Reason: HandshakeRejected, Err: fmt.Errorf("handshake rejected: %w", err)}
+		}
+	}
+	if err := protocol.WriteUpgradeResponse(tcpConn, ur, hdrs); err != nil {
+		tcpConn.Close()
+		return nil, &HandshakeError{RemoteAddr: tcpConn.RemoteAddr(), Reason: HandshakeWriteFailed, Err: fmt.Errorf("handshake response failed: %w", err)}
+	}
+	// fmt.Println("DEBUG: Server handshake response written")
+	if wsl.onHandshakeDone != nil {
+		wsl.onHandshakeDone(time.Since(accepted))
+	}
 	return wsConn, nil
 }
 
+// respHeaderPool holds the maps handshake hooks add response fields to.
+var respHeaderPool = sync.Pool{
+	New: func() any { return make(http.Header) },
+}
+
 // Close listener.
 func (wsl *WebSocketListener) Close() error {
-	if wsl.closed {
-		return nil
-	}
-	wsl.closed = true
-	return wsl.listener.Close()
+	var err error
+	wsl.closeOnce.Do(func() {
+		close(wsl.done)
+		err = wsl.listener.Close()
+	})
+	return err
+}
+
+// ErrListenerClosed is returned by Accept once the listener is closed.
+var ErrListenerClosed = api.ErrListenerClosed
+
+// HandshakeError is returned by Accept when the upgrade of an accepted TCP
+// connection fails or is rejected; only that client is affected.
+type HandshakeError struct {
+	RemoteAddr net.Addr
+	Reason     string // one of the Handshake* reasons
+	Err        error
 }
 
-var ErrListenerClosed = errors.New("listener closed")
+// Handshake failure reasons reported in HandshakeError.Reason.
+const (
+	HandshakeTLS         = "tls"          // TLS handshake failed
+	HandshakeBadRequest  = "bad_request"  // no valid upgrade request was read
+	HandshakeRejected    = "rejected"     // refused by the handshake hook
+	HandshakeWriteFailed = "write_failed" // the 101 response could not be written
+)
+
+func (e *HandshakeError) Error() string { return e.Err.Error() }
 
-// bufferedConnTransport implements api.Transport over net.Conn with a bufio.Reader
-// to preserve any data buffered during handshake.
+func (e *HandshakeError) Unwrap() error { return e.Err }
+
+// Is reports every HandshakeError as api.ErrHandshakeFailed.
+func (e *HandshakeError) Is(target error) bool { return target == api.ErrHandshakeFailed }
+
+// bufferedConnTransport implements api.Transport over net.Conn, returning
+// first the bytes read past the upgrade request during the handshake.
 type bufferedConnTransport struct {
 	conn       net.Conn
-	br         *bufio.Reader
+	pending    []byte // read past the upgrade request, returned by the first Recv
 	bufferPool api.BufferPool
 	numaNode   int
 	closed     bool
+
+	readTimeout   atomic.Int64 // time.Duration armed before each read, 0 = none
+	writeTimeout  atomic.Int64 // time.Duration armed before each write, 0 = none
+	writeDeadline atomic.Int64 // UnixNano set by SetWriteDeadline, 0 = none
+	readArmed     atomic.Bool  // a read deadline is set
+	writeArmed    atomic.Bool  // a write deadline is set
+}
+
+// SetTimeouts bounds every read and write of the connection, including one
+// already waiting (0 = no bound); a read or write exceeding it fails with
+// os.ErrDeadlineExceeded. Safe for concurrent use.
+func (t *bufferedConnTransport) SetTimeouts(read, write time.Duration) {
+	t.readTimeout.Store(int64(read))
+	t.writeTimeout.Store(int64(write))
+	t.armRead()
+	t.armWrite()
+}
+
+// armRead sets the read deadline from the read timeout, or clears it.
+func (t *bufferedConnTransport) armRead() {
+	if d := time.Duration(t.readTimeout.Load()); d > 0 {
+		t.conn.SetReadDeadline(time.Now().Add(d))
+		t.readArmed.Store(true)
+	} else if t.readArmed.Swap(false) {
+		t.conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// SetWriteDeadline bounds the following writes by d too, whichever of it
+// and the write timeout comes first (zero = no deadline). Safe for
+// concurrent use.
+func (t *bufferedConnTransport) SetWriteDeadline(d time.Time) error {
+	var n int64
+	if !d.IsZero() {
+		n = d.UnixNano()
+	}
+	t.writeDeadline.Store(n)
+	t.armWrite()
+	return nil
+}
+
+// armWrite sets the write deadline from the write timeout and the deadline
+// of SetWriteDeadline, or clears it.
+func (t *bufferedConnTransport) armWrite() {
+	var deadline time.Time
+	if d := time.Duration(t.writeTimeout.Load()); d > 0 {
+		deadline = time.Now().Add(d)
+	}
+	if n := t.writeDeadline.Load(); n != 0 && (deadline.IsZero() || n < deadline.UnixNano()) {
+		deadline = time.Unix(0, n)
+	}
+	if !deadline.IsZero() {
+		t.conn.SetWriteDeadline(deadline)
+		t.writeArmed.Store(true)
+	} else if t.writeArmed.Swap(false) {
+		t.conn.SetWriteDeadline(time.Time{})
+	}
 }
 
 func (t *bufferedConnTransport) Send(buffers [][]byte) error {
 	if t.closed {
 		return api.ErrTransportClosed
 	}
+	t.armWrite()
 	for _, b := range buffers {
 		if _, err := t.conn.Write(b); err != nil {
 			return fmt.Errorf("write: %w", err)
@@ -139,20 +392,40 @@ func (t *bufferedConnTransport) Send(buffers [][]byte) error {
 	return nil
 }
 
+// Recv returns a copy of the next read; the pool buffer it was read into
+// goes straight back. WSConnection reads with RecvBuffers instead.
 func (t *bufferedConnTransport) Recv() ([][]byte, error) {
+	bufs, err := t.RecvBuffers()
+	if err != nil {
+		return nil, err
+	}
+	data := bufs[0].Data
+	if bufs[0].Pool != nil {
+		data = append([]byte(nil), data...)
+		bufs[0].Release()
+	}
+	return [][]byte{data}, nil
+}
+
+// RecvBuffers implements api.BufferReceiver: each read goes into a fresh
+// buffer from the node's pool, owned by the caller.
+func (t *bufferedConnTransport) RecvBuffers() ([]api.Buffer, error) {
 	if t.closed {
 		return nil, api.ErrTransportClosed
 	}
+	if p := t.pending; p != nil {
+		t.pending = nil
+		return []api.Buffer{{Data: p}}, nil
+	}
 	// Allocate buffer from concrete NUMA node pool.
+	t.armRead()
 	buf := t.bufferPool.Get(8192, t.numaNode) // Increased from 4096 for efficiency
-	data := buf.Bytes()
-	// Read from buffered reader to get any data buffered during handshake
-	n, err := t.br.Read(data)
+	n, err := t.conn.Read(buf.Bytes())
 	if err != nil {
 		buf.Release()
 		return nil, fmt.Errorf("read: %w", err)
 	}
-	return [][]byte{data[:n]}, nil
+	return []api.Buffer{buf.Slice(0, n)}, nil
 }
 
 func (t *bufferedConnTransport) Close() error {
@@ -163,6 +436,19 @@ func (t *bufferedConnTransport) Close() error {
 	return t.conn.Close()
 }
 
+// RemoteAddr returns the peer address of the underlying connection.
+func (t *bufferedConnTransport) RemoteAddr() net.Addr {
+	return t.conn.RemoteAddr()
+}
+
+// TLSConnectionState returns the TLS state of a connection accepted over TLS.
+func (t *bufferedConnTransport) TLSConnectionState() (tls.ConnectionState, bool) {
+	if tc, ok := t.conn.(*tls.Conn); ok {
+		return tc.ConnectionState(), true
+	}
+	return tls.ConnectionState{}, false
+}
+
 func (t *bufferedConnTransport) Features() api.TransportFeatures {
 	return api.TransportFeatures{
 		ZeroCopy:  true,
@@ -0,0 +1,320 @@
+// File: jsonrpc/conn.go
+// Package jsonrpc
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// textMessage is the WebSocket message type JSON-RPC messages are sent as.
+const textMessage = int(protocol.OpcodeText)
+
+// Transport is the message-oriented connection a Conn runs on;
+// *highlevel.Conn satisfies it.
+type Transport interface {
+	ReadMessage() (messageType int, p []byte, err error)
+	WriteMessage(messageType int, data []byte) error
+	Close() error
+}
+
+// Option configures a Conn.
+type Option func(*Conn)
+
+// WithRouter serves inbound requests from r. Without a router every request
+// is answered with CodeMethodNotFound.
+func WithRouter(r *Router) Option {
+	return func(c *Conn) {
+		c.router = r
+	}
+}
+
+// WithTimeout bounds calls whose context carries no deadline.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Conn) {
+		c.timeout = d
+	}
+}
+
+// Conn is one JSON-RPC peer.
+type Conn struct {
+	t       Transport
+	router  *Router
+	timeout time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	nextID atomic.Uint64
+
+	mu      sync.Mutex
+	pending map[string]chan *message
+	closed  bool
+
+	wmu sync.Mutex
+}
+
+// NewConn wraps t. Serve must run for calls to receive their responses.
+func NewConn(t Transport, opts ...Option) *Conn {
+	c := &Conn{t: t, pending: make(map[string]chan *message)}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	return c
+}
+
+// Serve reads messages until the connection fails, dispatching requests to
+// the router and responses to pending calls. It closes the Conn on return.
+func (c *Conn) Serve() error {
+	defer c.Close()
+	for {
+		_, data, err := c.t.ReadMessage()
+		if err != nil {
+			return err
+		}
+		c.dispatch(data)
+	}
+}
+
+// Close fails pending calls with ErrClosed and closes the transport.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.mu.Unlock()
+	c.cancel()
+	return c.t.Close()
+}
+
+// Pending returns the number of calls awaiting a response.
+func (c *Conn) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+// Call invokes method on the peer and decodes the result into result (which
+// may be nil to discard it). A peer error is returned as *Error.
+func (c *Conn) Call(ctx context.Context, method string, params, result any) error {
+	msg := &message{JSONRPC: Version, Method: method}
+	if params != nil {
+		raw, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		msg.Params = raw
+	}
+	key := strconv.FormatUint(c.nextID.Add(1), 10)
+	msg.ID = json.RawMessage(key)
+
+	ch := make(chan *message, 1)
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.pending[key] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, key)
+		c.mu.Unlock()
+	}()
+
+	if _, ok := ctx.Deadline(); !ok && c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	if err := c.send(msg); err != nil {
+		return err
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if result != nil && len(resp.Result) > 0 {
+			return json.Unmarshal(resp.Result, result)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.ctx.Done():
+		return ErrClosed
+	}
+}
+
+// Notify sends a notification, which the peer never answers.
+func (c *Conn) Notify(method string, params any) error {
+	msg := &message{JSONRPC: Version, Method: method}
+	if params != nil {
+		raw, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		msg.Params = raw
+	}
+	return c.send(msg)
+}
+
+// dispatch routes one inbound WebSocket message. Responses are delivered
+// inline; requests run on their own goroutines so slow handlers do not block
+// the read loop.
+func (c *Conn) dispatch(data []byte) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var items []json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil || len(items) == 0 {
+			c.send(errorResponse(nullID, NewError(CodeInvalidRequest, "Invalid Request", nil)))
+			return
+		}
+		go c.serveBatch(items)
+		return
+	}
+
+	var m message
+	if err := json.Unmarshal(data, &m); err != nil {
+		c.send(errorResponse(nullID, NewError(CodeParseError, "Parse error", nil)))
+		return
+	}
+	if m.Method == "" {
+		c.deliver(&m)
+		return
+	}
+	go func() {
+		if resp := c.serveRequest(&m); resp != nil {
+			c.send(resp)
+		}
+	}()
+}
+
+// serveBatch runs the requests of a batch concurrently and answers with one
+// array holding every non-notification response.
+func (c *Conn) serveBatch(items []json.RawMessage) {
+	responses := make([]*message, len(items))
+	var wg sync.WaitGroup
+	for i, raw := range items {
+		var m message
+		if err := json.Unmarshal(raw, &m); err != nil {
+			responses[i] = errorResponse(nullID, NewError(CodeInvalidRequest, "Invalid Request", nil))
+			continue
+		}
+		if m.Method == "" {
+			c.deliver(&m)
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = c.serveRequest(&m)
+		}(i)
+	}
+	wg.Wait()
+
+	out := responses[:0]
+	for _, r := range responses {
+		if r != nil {
+			out = append(out, r)
+		}
+	}
+	if len(out) == 0 {
+		return
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		return
+	}
+	c.write(data)
+}
+
+// serveRequest invokes the handler for m and returns the response, or nil
+// for notifications.
+func (c *Conn) serveRequest(m *message) *message {
+	notification := len(m.ID) == 0
+	if m.JSONRPC != Version {
+		if notification {
+			return nil
+		}
+		return errorResponse(m.ID, NewError(CodeInvalidRequest, "Invalid Request", nil))
+	}
+	var fn HandlerFunc
+	ok := false
+	if c.router != nil {
+		fn, ok = c.router.lookup(m.Method)
+	}
+	if !ok {
+		if notification {
+			return nil
+		}
+		return errorResponse(m.ID, NewError(CodeMethodNotFound, "Method not found", m.Method))
+	}
+
+	result, err := fn(context.WithValue(c.ctx, connKey{}, c), m.Params)
+	if notification {
+		return nil
+	}
+	if err != nil {
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) {
+			rpcErr = NewError(CodeInternalError, err.Error(), nil)
+		}
+		return errorResponse(m.ID, rpcErr)
+	}
+	raw, err := json.Marshal(result)
+	if err != nil {
+		return errorResponse(m.ID, NewError(CodeInternalError, err.Error(), nil))
+	}
+	return &message{JSONRPC: Version, ID: m.ID, Result: raw}
+}
+
+// deliver hands a response to the call waiting for its ID; responses for
+// unknown or expired calls are dropped.
+func (c *Conn) deliver(m *message) {
+	c.mu.Lock()
+	ch, ok := c.pending[string(m.ID)]
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case ch <- m:
+	default:
+	}
+}
+
+// send marshals and writes one message.
+func (c *Conn) send(m *message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return c.write(data)
+}
+
+// write sends one text message, serialised across goroutines.
+func (c *Conn) write(data []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.t.WriteMessage(textMessage, data)
+}
+
+// errorResponse builds an error response for id.
+func errorResponse(id json.RawMessage, err *Error) *message {
+	return &message{JSONRPC: Version, ID: id, Error: err}
+}
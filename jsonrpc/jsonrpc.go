@@ -0,0 +1,74 @@
+// File: jsonrpc/jsonrpc.go
+// Package jsonrpc implements JSON-RPC 2.0 over WebSocket connections.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// A Conn is symmetric: either side may call methods registered on the
+// other's Router, send notifications and have many calls in flight at once.
+// Requests are dispatched concurrently; responses are matched to pending
+// calls by ID. Batch requests are accepted and answered as a batch.
+//
+//	router := jsonrpc.NewRouter()
+//	jsonrpc.Handle(router, "sum", func(ctx context.Context, p []int) (int, error) { ... })
+//	srv.HandleFunc("/rpc", func(c *highlevel.Conn) {
+//		jsonrpc.NewConn(c, jsonrpc.WithRouter(router)).Serve()
+//	})
+
+package jsonrpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Version is the protocol version carried in every message.
+const Version = "2.0"
+
+// Standard error codes.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// ErrClosed is returned by calls pending or issued on a closed Conn.
+var ErrClosed = errors.New("jsonrpc: connection closed")
+
+// Error is a JSON-RPC error object. Handlers may return one to choose the
+// code sent to the caller; Call returns one when the peer answered with an
+// error.
+type Error struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// Error implements error.
+func (e *Error) Error() string {
+	return fmt.Sprintf("jsonrpc: %s (%d)", e.Message, e.Code)
+}
+
+// NewError builds an Error, marshalling data when non-nil.
+func NewError(code int, message string, data any) *Error {
+	e := &Error{Code: code, Message: message}
+	if data != nil {
+		e.Data, _ = json.Marshal(data)
+	}
+	return e
+}
+
+// message is the union of request, notification and response objects.
+type message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// nullID is the id of responses to requests whose id could not be read.
+var nullID = json.RawMessage("null")
@@ -0,0 +1,74 @@
+// File: jsonrpc/router.go
+// Package jsonrpc
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+// HandlerFunc serves one method. params is the raw "params" member (nil when
+// absent); the result is marshalled into the response.
+type HandlerFunc func(ctx context.Context, params json.RawMessage) (any, error)
+
+// Router maps method names to handlers. It is safe for concurrent use and
+// may be shared by many connections.
+type Router struct {
+	mu       sync.RWMutex
+	handlers map[string]HandlerFunc
+}
+
+// NewRouter creates an empty Router.
+func NewRouter() *Router {
+	return &Router{handlers: make(map[string]HandlerFunc)}
+}
+
+// HandleFunc registers fn for method, replacing any previous handler.
+func (r *Router) HandleFunc(method string, fn HandlerFunc) {
+	r.mu.Lock()
+	r.handlers[method] = fn
+	r.mu.Unlock()
+}
+
+// Remove unregisters method.
+func (r *Router) Remove(method string) {
+	r.mu.Lock()
+	delete(r.handlers, method)
+	r.mu.Unlock()
+}
+
+// lookup returns the handler for method.
+func (r *Router) lookup(method string) (HandlerFunc, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	fn, ok := r.handlers[method]
+	return fn, ok
+}
+
+// Handle registers a typed handler: params are decoded into P (an invalid
+// payload yields CodeInvalidParams) and the R result is marshalled back.
+func Handle[P, R any](r *Router, method string, fn func(ctx context.Context, params P) (R, error)) {
+	r.HandleFunc(method, func(ctx context.Context, raw json.RawMessage) (any, error) {
+		var p P
+		if len(raw) > 0 {
+			if err := json.Unmarshal(raw, &p); err != nil {
+				return nil, NewError(CodeInvalidParams, "Invalid params", err.Error())
+			}
+		}
+		return fn(ctx, p)
+	})
+}
+
+// connKey carries the serving Conn in handler contexts.
+type connKey struct{}
+
+// ConnFromContext returns the Conn a handler was invoked on, e.g. to call
+// back into the peer or send it notifications.
+func ConnFromContext(ctx context.Context) *Conn {
+	c, _ := ctx.Value(connKey{}).(*Conn)
+	return c
+}
@@ -0,0 +1,206 @@
+// Package client resolves server names and connects per Happy Eyeballs.
+//
+// A host name is resolved for AAAA and A records in parallel (RFC 8305
+// section 3): the dial starts once both answers are in, or 50ms after the A
+// answer if AAAA is still outstanding, which is then ignored. Addresses are
+// then tried alternating between the families, IPv6 first, starting a new
+// attempt each ConnectAttemptDelay or as soon as the previous one fails; the first
+// connection established wins and the others are abandoned.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+)
+
+// Dialer opens network connections. *net.Dialer implements it.
+type Dialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// Resolver looks up the addresses of a host for network "ip4" or "ip6".
+// *net.Resolver implements it.
+type Resolver interface {
+	LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error)
+}
+
+// Happy Eyeballs timings, RFC 8305 sections 3 and 5.
+const (
+	resolutionDelay            = 50 * time.Millisecond
+	defaultConnectAttemptDelay = 250 * time.Millisecond
+)
+
+// dialTCP connects to addr with cfg.DialFunc or, without one, by resolving
+// its host with cfg.Resolver and racing the addresses found.
+func dialTCP(ctx context.Context, cfg *Config, addr string) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if cfg.DialFunc != nil {
+		conn, err = cfg.DialFunc(ctx, "tcp", addr)
+	} else {
+		conn, err = happyEyeballs(ctx, cfg, addr)
+	}
+	if err != nil && ctx.Err() != nil {
+		return nil, fmt.Errorf("dial %s: %w", addr, ctx.Err())
+	}
+	return conn, err
+}
+
+// happyEyeballs resolves the host of addr and races its addresses.
+func happyEyeballs(ctx context.Context, cfg *Config, addr string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("bad port %q", portStr)
+	}
+	var addrs []netip.Addr
+	if ip, err := netip.ParseAddr(host); err == nil {
+		addrs = []netip.Addr{ip}
+	} else {
+		resolver := cfg.Resolver
+		if resolver == nil {
+			resolver = net.DefaultResolver
+		}
+		if addrs, err = resolve(ctx, resolver, host); err != nil {
+			return nil, err
+		}
+	}
+	delay := cfg.ConnectAttemptDelay
+	if delay <= 0 {
+		delay = defaultConnectAttemptDelay
+	}
+	dialer := cfg.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	return race(ctx, dialer, addrs, uint16(port), delay)
+}
+
+// resolve looks up host's IPv6 and IPv4 addresses in parallel and returns
+// them interleaved, IPv6 first.
+func resolve(ctx context.Context, r Resolver, host string) ([]netip.Addr, error) {
+	type answer struct {
+		addrs []netip.Addr
+		err   error
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	v6, v4 := make(chan answer, 1), make(chan answer, 1)
+	lookup := func(network string, out chan<- answer) {
+		addrs, err := r.LookupNetIP(ctx, network, host)
+		out <- answer{addrs, err}
+	}
+	go lookup("ip6", v6)
+	go lookup("ip4", v4)
+
+	var a6, a4 *answer
+	var wait <-chan time.Time
+	for a6 == nil || a4 == nil {
+		select {
+		case a := <-v6:
+			a6 = &a
+		case a := <-v4:
+			a4 = &a
+			if len(a.addrs) > 0 {
+				t := time.NewTimer(resolutionDelay)
+				defer t.Stop()
+				wait = t.C
+			}
+		case <-wait:
+			a6 = &answer{} // IPv4 only rather than wait on a slow AAAA
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	addrs := make([]netip.Addr, 0, len(a6.addrs)+len(a4.addrs))
+	for i := 0; i < len(a6.addrs) || i < len(a4.addrs); i++ {
+		if i < len(a6.addrs) {
+			addrs = append(addrs, a6.addrs[i])
+		}
+		if i < len(a4.addrs) {
+			addrs = append(addrs, a4.addrs[i])
+		}
+	}
+	if len(addrs) == 0 {
+		err := errors.Join(a6.err, a4.err)
+		if err == nil {
+			err = errors.New("no addresses")
+		}
+		return nil, fmt.Errorf("resolve %s: %w", host, err)
+	}
+	return addrs, nil
+}
+
+// race connects to the addrs in order, starting the next attempt after
+// delay or when the previous one fails, and returns the first connection
+// established.
+func race(ctx context.Context, dialer Dialer, addrs []netip.Addr, port uint16, delay time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(addrs))
+	next, inflight := 0, 0
+	start := func() {
+		ap := netip.AddrPortFrom(addrs[next].Unmap(), port)
+		next++
+		inflight++
+		go func() {
+			conn, err := dialer.DialContext(ctx, "tcp", ap.String())
+			results <- result{conn, err}
+		}()
+	}
+
+	start()
+	var firstErr error
+	for {
+		var timer *time.Timer
+		var fire <-chan time.Time
+		if next < len(addrs) {
+			timer = time.NewTimer(delay)
+			fire = timer.C
+		}
+		select {
+		case r := <-results:
+			inflight--
+			if r.err == nil {
+				if timer != nil {
+					timer.Stop()
+				}
+				// Close the connections of attempts that still succeed.
+				go func(n int) {
+					for ; n > 0; n-- {
+						if r := <-results; r.conn != nil {
+							r.conn.Close()
+						}
+					}
+				}(inflight)
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if next < len(addrs) {
+				start()
+			} else if inflight == 0 {
+				return nil, firstErr
+			}
+		case <-fire:
+			start()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
@@ -10,15 +10,20 @@
 package client
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/momentics/hioload-ws/api"
@@ -35,8 +40,43 @@ type Config struct {
 	ReadTimeout  time.Duration // per-recv deadline, 0 = disabled
 	WriteTimeout time.Duration // per-send deadline, 0 = disabled
 	Heartbeat    time.Duration // Ping interval, 0 = disabled
+	Subprotocols []string      // Sec-WebSocket-Protocol values offered, in preference order
+
+	// Header is sent with the handshake request, e.g. Authorization or
+	// Origin. A Host entry overrides the URL's host; the handshake's own
+	// Upgrade, Connection and Sec-WebSocket-* fields may not be set.
+	Header http.Header
+	// Jar, if set, supplies the handshake's cookies and stores those the
+	// server sets in its response.
+	Jar http.CookieJar
+	// Proxy returns the proxy to dial through for a request to the
+	// server's http(s) URL, or nil to connect directly. DefaultConfig uses
+	// http.ProxyFromEnvironment, honoring HTTP_PROXY, HTTPS_PROXY and
+	// NO_PROXY; http.ProxyURL selects a fixed one.
+	Proxy func(*http.Request) (*url.URL, error)
+	// Resolver looks up the server's and the proxy's host names (nil =
+	// net.DefaultResolver). The addresses found are raced Happy Eyeballs
+	// style, a new attempt starting every ConnectAttemptDelay (0 = 250ms).
+	Resolver            Resolver
+	ConnectAttemptDelay time.Duration
+	// Dialer opens each raced connection attempt to a resolved IP address
+	// (nil = a zero net.Dialer); a *net.Dialer sets the local address or
+	// TCP keepalive. DialFunc, if set, replaces resolution and racing: it is
+	// called once with the server's or proxy's host:port as written.
+	Dialer   Dialer
+	DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// Compression offers permessage-deflate; if the server accepts, messages
+	// of at least CompressionThreshold bytes (0 = protocol.DeflateThreshold)
+	// are sent compressed and compressed messages are inflated.
+	Compression          bool
+	CompressionThreshold int
 }
 
+// ErrReservedHeader is returned when Config.Header sets a field the
+// handshake itself controls.
+var ErrReservedHeader = errors.New("header is set by the websocket handshake")
+
 // DefaultConfig returns sensible defaults.
 func DefaultConfig() *Config {
 	return &Config{
@@ -47,9 +87,14 @@ func DefaultConfig() *Config {
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 5 * time.Second,
 		Heartbeat:    30 * time.Second,
+		Proxy:        http.ProxyFromEnvironment,
 	}
 }
 
+// defaultHandshakeTimeout bounds the handshake when the dial context has no
+// deadline of its own.
+const defaultHandshakeTimeout = 5 * time.Second
+
 // Client is a high-level WebSocket client.
 type Client struct {
 	cfg       *Config
@@ -60,6 +105,12 @@ type Client struct {
 	ctx       context.Context
 	cancel    context.CancelFunc
 	wg        sync.WaitGroup
+
+	// Figures reported by Stats
+	connectedAt         time.Time
+	pingSent, rtt       atomic.Int64 // UnixNano of the last ping; its round trip
+	pings               atomic.Uint64
+	sentMsgs, sentBytes atomic.Int64
 }
 
 var encodedFramePool = sync.Pool{
@@ -76,11 +127,27 @@ func (s slicePoolReleaser) Put(b api.Buffer) {
 	}
 }
 
-// NewClient initializes, handshakes, and starts I/O loops.
-func NewClient(cfg *Config) (*Client, error) {
+// NewClient initializes, handshakes, and starts I/O loops. opts adjust a
+// copy of cfg, or of DefaultConfig if cfg is nil.
+func NewClient(cfg *Config, opts ...Option) (*Client, error) {
+	return NewClientContext(context.Background(), cfg, opts...)
+}
+
+// NewClientContext is NewClient with DNS resolution, the TCP connect and the
+// handshake bound by ctx: cancelling it or passing its deadline aborts the
+// dial with ctx's error. Without a deadline the handshake is given 5s. Once
+// the client is returned, ctx no longer affects the connection.
+func NewClientContext(ctx context.Context, cfg *Config, opts ...Option) (*Client, error) {
 	if cfg == nil {
 		cfg = DefaultConfig()
 	}
+	if len(opts) > 0 {
+		copied := *cfg
+		cfg = &copied
+		for _, opt := range opts {
+			opt(cfg)
+		}
+	}
 
 	// Parse URL
 	u, err := url.Parse(cfg.Addr)
@@ -88,56 +155,74 @@ func NewClient(cfg *Config) (*Client, error) {
 		return nil, fmt.Errorf("invalid URL: %w", err)
 	}
 
+	req, reqBytes, err := handshakeRequest(u, cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	// Setup shared buffer pool manager
 	mgr := pool.DefaultManager()
 
 	var tr api.Transport
 
 	// Optimized transport path is currently disabled for stability; use the Net fallback.
-	netConn, err := net.Dial("tcp", u.Host)
+	netConn, err := dialServer(ctx, cfg, u)
 	if err != nil {
 		return nil, fmt.Errorf("dial error: %w", err)
 	}
 
+	// Bound the handshake by ctx: once it is done an immediate deadline
+	// makes blocked reads and writes return. Copying ctx's deadline to the
+	// socket instead could fail the read before ctx.Err() reports why.
+	if _, ok := ctx.Deadline(); !ok {
+		netConn.SetDeadline(time.Now().Add(defaultHandshakeTimeout))
+	}
+	stop := context.AfterFunc(ctx, func() { netConn.SetDeadline(time.Now()) })
+	fail := func(err error) (*Client, error) {
+		stop()
+		netConn.Close()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, fmt.Errorf("handshake aborted: %w", ctxErr)
+		}
+		return nil, err
+	}
+
 	// Disable Nagle's algorithm for low-latency small packet transmission
 	if tc, ok := netConn.(*net.TCPConn); ok {
 		tc.SetNoDelay(true)
 	}
 
 	// Perform HTTP handshake on net.Conn
-	key := make([]byte, 16)
-	rand.Read(key)
-	secKey := base64.StdEncoding.EncodeToString(key)
-	req := &http.Request{
-		Method: "GET",
-		URL:    &url.URL{Path: u.Path},
-		Host:   u.Host,
-		Header: http.Header{
-			"Upgrade":               {"websocket"},
-			"Connection":            {"Upgrade"},
-			"Sec-WebSocket-Key":     {secKey},
-			"Sec-WebSocket-Version": {"13"},
-		},
-	}
-	// Use manual string construction to match optimized path and avoid req.Write quirks
-	path := u.Path
-	if path == "" {
-		path = "/"
+	if _, err := netConn.Write(reqBytes); err != nil {
+		return fail(err)
 	}
-	reqStr := fmt.Sprintf("GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", path, u.Host, secKey)
 
-	if _, err := netConn.Write([]byte(reqStr)); err != nil {
-		netConn.Close()
-		return nil, err
+	br := bufio.NewReader(netConn)
+	resp, err := protocol.DoClientHandshakeResponse(br, req)
+	if err != nil {
+		return fail(fmt.Errorf("fallback handshake failed: %w", err))
 	}
-
-	// Set timeout for handshake response
-	netConn.SetReadDeadline(time.Now().Add(5 * time.Second))
-	if err := protocol.DoClientHandshake(netConn, req); err != nil {
-		netConn.Close()
-		return nil, fmt.Errorf("fallback handshake failed: %w", err)
+	compress, err := protocol.AcceptDeflate(resp.Header)
+	if err == nil && compress && !cfg.Compression {
+		err = fmt.Errorf("%w: compression not offered", protocol.ErrDeflateResponse)
+	}
+	if err != nil {
+		return fail(fmt.Errorf("%w: %w", api.ErrHandshakeFailed, err))
+	}
+	if cfg.Jar != nil {
+		if rc := resp.Cookies(); len(rc) > 0 {
+			cfg.Jar.SetCookies(cookieURL(u), rc)
+		}
+	}
+	if !stop() {
+		// ctx ended as the handshake completed; its deadline may be set.
+		return fail(ctx.Err())
+	}
+	netConn.SetDeadline(time.Time{}) // Clear deadline
+	if br.Buffered() > 0 {
+		// Frames the server sent right behind its 101 response.
+		netConn = &bufferedConn{Conn: netConn, r: br}
 	}
-	netConn.SetReadDeadline(time.Time{}) // Clear deadline
 
 	// Wrap
 	tr = NewTransport(netConn, mgr.GetPool(cfg.IOBufferSize, cfg.NUMANode), cfg.IOBufferSize)
@@ -147,17 +232,25 @@ func NewClient(cfg *Config) (*Client, error) {
 
 	// Build WSConnection
 	ws := protocol.NewWSConnection(tr, bp, cfg.BatchSize)
+	ws.SetSubprotocol(resp.Header.Get(protocol.HeaderSecWebSocketProto))
+	if compress {
+		ws.SetCompression(true)
+		ws.SetCompressionThreshold(cfg.CompressionThreshold)
+		ws.SetExtensions(resp.Header.Get(protocol.HeaderSecWebSocketExt))
+	}
 	ws.Start()
 
-	ctx, cancel := context.WithCancel(context.Background())
+	loopCtx, cancel := context.WithCancel(context.Background())
 	client := &Client{
 		cfg:       cfg,
 		transport: tr,
 		conn:      ws,
 		sendBatch: NewBatch(cfg.BatchSize),
 		flushCh:   make(chan struct{}, 1),
-		ctx:       ctx,
+		ctx:       loopCtx,
 		cancel:    cancel,
+
+		connectedAt: time.Now(),
 	}
 	client.wg.Add(1) // Only sendLoop, recvLoop is handled by WSConnection.Start()
 	go client.sendLoop()
@@ -170,6 +263,69 @@ func NewClient(cfg *Config) (*Client, error) {
 	return client, nil
 }
 
+// handshakeRequest builds the upgrade request for u and its wire form, with
+// the subprotocols, header and cookies of cfg.
+func handshakeRequest(u *url.URL, cfg *Config) (*http.Request, []byte, error) {
+	key := make([]byte, 16)
+	rand.Read(key)
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Path: u.Path, RawQuery: u.RawQuery},
+		Host:   u.Host,
+		Header: make(http.Header, len(cfg.Header)+6),
+	}
+	for k, vs := range cfg.Header {
+		switch k = http.CanonicalHeaderKey(k); {
+		case k == "Host":
+			if len(vs) > 0 {
+				req.Host = vs[0]
+			}
+			continue
+		case k == "Upgrade", k == "Connection", strings.HasPrefix(k, "Sec-Websocket-"):
+			return nil, nil, fmt.Errorf("%w: %s", ErrReservedHeader, k)
+		}
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	req.Header.Set(protocol.HeaderUpgrade, "websocket")
+	req.Header.Set(protocol.HeaderConnection, "Upgrade")
+	req.Header.Set(protocol.HeaderSecWebSocketKey, base64.StdEncoding.EncodeToString(key))
+	req.Header.Set(protocol.HeaderSecWebSocketVer, protocol.RequiredWebSocketVersion)
+	if len(cfg.Subprotocols) > 0 {
+		req.Header.Set(protocol.HeaderSecWebSocketProto, strings.Join(cfg.Subprotocols, ", "))
+	}
+	if cfg.Compression {
+		req.Header.Set(protocol.HeaderSecWebSocketExt, protocol.DeflateOffer)
+	}
+	if cfg.Jar != nil {
+		for _, c := range cfg.Jar.Cookies(cookieURL(u)) {
+			req.AddCookie(c)
+		}
+	}
+
+	// Written by hand rather than with req.Write, which adds a User-Agent
+	// and rewrites the request line.
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "GET %s HTTP/1.1\r\nHost: %s\r\n", u.RequestURI(), req.Host)
+	req.Header.Write(&b)
+	b.WriteString("\r\n")
+	return req, b.Bytes(), nil
+}
+
+// cookieURL maps a ws or wss URL to the http or https URL its cookies are
+// scoped to.
+func cookieURL(u *url.URL) *url.URL {
+	cu := *u
+	switch u.Scheme {
+	case "ws":
+		cu.Scheme = "http"
+	case "wss":
+		cu.Scheme = "https"
+	}
+	return &cu
+}
+
 // transportAdapter adapts api.Transport to io.ReadWriter for handshake
 type transportAdapter struct {
 	tr     api.Transport
@@ -229,8 +385,8 @@ func (c *Client) Send(msg []byte) {
 	}
 
 	scratch := encodedFramePool.Get().([]byte)
-	raw, err := protocol.EncodeFrameToBufferWithMask(frame, true, scratch[:0])
-	if err != nil {
+	raw, err := c.conn.EncodeOutbound(frame, scratch[:0])
+	if err != nil || len(raw) == 0 {
 		encodedFramePool.Put(scratch[:0])
 		// Drop message on error (or log?)
 		return
@@ -271,22 +427,21 @@ func (c *Client) ReadMessage() (messageType int, p []byte, err error) {
 	result := make([]byte, len(data))
 	copy(result, data)
 
-	// For now, return binary type - in real implementation we'd get this from frame
 	return mt, result, nil
 }
 
 // ReadBuffer returns the next message without copying. Caller must Release().
 func (c *Client) ReadBuffer() (int, api.Buffer, error) {
-	buffers, err := c.conn.RecvZeroCopy()
+	msgs, err := c.conn.RecvMessages()
 	if err != nil {
 		return 0, api.Buffer{}, err
 	}
 
-	if len(buffers) == 0 {
+	if len(msgs) == 0 {
 		return 0, api.Buffer{}, fmt.Errorf("no message received")
 	}
 
-	return int(protocol.OpcodeBinary), buffers[0], nil
+	return int(msgs[0].Opcode), msgs[0].Buf, nil
 }
 
 // WriteMessage writes a message to the connection.
@@ -301,8 +456,8 @@ func (c *Client) WriteMessage(messageType int, data []byte) error {
 	}
 
 	scratch := encodedFramePool.Get().([]byte)
-	raw, err := protocol.EncodeFrameToBufferWithMask(frame, true, scratch[:0])
-	if err != nil {
+	raw, err := c.conn.EncodeOutbound(frame, scratch[:0])
+	if err != nil || len(raw) == 0 {
 		encodedFramePool.Put(scratch[:0])
 		return err
 	}
@@ -348,6 +503,11 @@ func (c *Client) Close() error {
 	return nil
 }
 
+// Subprotocol returns the Sec-WebSocket-Protocol the server selected, or "".
+func (c *Client) Subprotocol() string {
+	return c.conn.Subprotocol()
+}
+
 // GetWSConnection returns the underlying WebSocket connection.
 func (c *Client) GetWSConnection() *protocol.WSConnection {
 	return c.conn
@@ -378,14 +538,17 @@ func (c *Client) flush() {
 		return
 	}
 	var bufs [][]byte
+	var n int64
 	for _, b := range batch {
 		bufs = append(bufs, b.Bytes())
+		n += int64(len(b.Bytes()))
 	}
 	if err := c.transport.Send(bufs); err != nil {
 		// fmt.Printf("DEBUG: flush Send error: %v\n", err)
 		// handle error/log
 	} else {
-		// fmt.Printf("DEBUG: flush Sent batch size %d\n", len(batch))
+		c.sentMsgs.Add(int64(len(batch)))
+		c.sentBytes.Add(n)
 	}
 	for _, b := range batch {
 		b.Release()
@@ -427,7 +590,7 @@ func (c *Client) heartbeatLoop() {
 		case <-c.ctx.Done():
 			return
 		case <-ticker.C:
-			c.conn.SendFrame(&protocol.WSFrame{IsFinal: true, Opcode: protocol.OpcodePing})
+			c.ping()
 		}
 	}
 }
@@ -0,0 +1,58 @@
+// Package client adjusts a Config with functional options.
+package client
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// Option adjusts the Config of a single NewClient or NewClientContext call.
+type Option func(*Config)
+
+// WithDialer opens the connection attempts with d, e.g. a *net.Dialer with
+// LocalAddr to bind the source address or KeepAlive to tune TCP keepalive.
+// Host names are still resolved and their addresses raced; see
+// Config.Dialer.
+func WithDialer(d Dialer) Option {
+	return func(c *Config) {
+		c.Dialer = d
+	}
+}
+
+// WithDialFunc connects with fn, which receives the server's or proxy's
+// host:port unresolved, e.g. to dial through a tunnel or an in-memory pipe.
+func WithDialFunc(fn func(ctx context.Context, network, addr string) (net.Conn, error)) Option {
+	return func(c *Config) {
+		c.DialFunc = fn
+	}
+}
+
+// WithResolver looks up host names with r instead of net.DefaultResolver.
+func WithResolver(r Resolver) Option {
+	return func(c *Config) {
+		c.Resolver = r
+	}
+}
+
+// WithHeader adds a header field to the handshake request.
+func WithHeader(key, value string) Option {
+	return func(c *Config) {
+		c.Header = c.Header.Clone()
+		if c.Header == nil {
+			c.Header = make(http.Header)
+		}
+		c.Header.Add(key, value)
+	}
+}
+
+// WithProxy dials through the proxy at proxyURL, or directly if it is nil.
+func WithProxy(proxyURL *url.URL) Option {
+	return func(c *Config) {
+		c.Proxy = http.ProxyURL(proxyURL)
+		if proxyURL == nil {
+			c.Proxy = nil
+		}
+	}
+}
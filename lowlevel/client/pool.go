@@ -0,0 +1,273 @@
+// Package client keeps a pool of connections to one or more servers.
+//
+// Each pool member owns one connection to one address. A monitor goroutine
+// per member pings it every HealthInterval and replaces the connection when
+// it closes or has not answered the previous ping, so callers only ever see
+// members that are up.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// ErrPoolUnavailable is returned when no pool member is connected.
+var ErrPoolUnavailable = errors.New("no pool connection available")
+
+// PoolConfig holds Pool parameters.
+type PoolConfig struct {
+	Config         *Config       // template for every connection; Addr is set per member
+	Addrs          []string      // server URLs, assigned to members in turn
+	Size           int           // number of connections, 0 = len(Addrs)
+	HealthInterval time.Duration // ping and redial interval, 0 = 10s
+}
+
+// Pool maintains a fixed number of client connections spread over a set of
+// servers and balances sends across them.
+type Pool struct {
+	cfg      Config
+	interval time.Duration
+	members  []*poolMember
+	next     atomic.Uint64
+	replaced atomic.Uint64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// poolMember is one pool slot; client is nil while it is reconnecting.
+type poolMember struct {
+	addr     string
+	client   atomic.Pointer[Client]
+	replaced atomic.Uint64
+}
+
+// NewPool dials cfg.Size connections and starts their health checks. Members
+// that cannot connect yet are retried in the background; NewPool fails only
+// if none can.
+func NewPool(cfg *PoolConfig) (*Pool, error) {
+	if len(cfg.Addrs) == 0 {
+		return nil, errors.New("pool: no server addresses")
+	}
+	size := cfg.Size
+	if size <= 0 {
+		size = len(cfg.Addrs)
+	}
+	p := &Pool{interval: cfg.HealthInterval}
+	if cfg.Config != nil {
+		p.cfg = *cfg.Config
+	} else {
+		p.cfg = *DefaultConfig()
+	}
+	if p.interval <= 0 {
+		p.interval = 10 * time.Second
+	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+
+	var lastErr error
+	up := 0
+	for i := 0; i < size; i++ {
+		m := &poolMember{addr: cfg.Addrs[i%len(cfg.Addrs)]}
+		if c, err := p.dial(m.addr); err != nil {
+			lastErr = err
+		} else {
+			m.client.Store(c)
+			up++
+		}
+		p.members = append(p.members, m)
+	}
+	if up == 0 {
+		p.cancel()
+		return nil, fmt.Errorf("pool: %w", lastErr)
+	}
+	for _, m := range p.members {
+		p.wg.Add(1)
+		go p.monitor(m)
+	}
+	return p, nil
+}
+
+// Next returns the next connected member in round-robin order.
+func (p *Pool) Next() (*Client, error) {
+	return p.pick(p.next.Add(1) - 1)
+}
+
+// ForKey returns the member key hashes to, so messages with the same key
+// share a connection. While that member is down the next connected one is
+// used instead.
+func (p *Pool) ForKey(key string) (*Client, error) {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return p.pick(uint64(h.Sum32()))
+}
+
+// Send writes a binary message on the next member in round-robin order.
+func (p *Pool) Send(msg []byte) error {
+	c, err := p.Next()
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(int(protocol.OpcodeBinary), msg)
+}
+
+// SendKey writes a binary message on the member chosen by ForKey(key).
+func (p *Pool) SendKey(key string, msg []byte) error {
+	c, err := p.ForKey(key)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(int(protocol.OpcodeBinary), msg)
+}
+
+// Size returns the number of pool members.
+func (p *Pool) Size() int {
+	return len(p.members)
+}
+
+// Healthy returns the number of members currently connected.
+func (p *Pool) Healthy() int {
+	n := 0
+	for _, m := range p.members {
+		if alive(m.client.Load()) {
+			n++
+		}
+	}
+	return n
+}
+
+// Replaced returns how many failed connections have been dropped for
+// replacement.
+func (p *Pool) Replaced() uint64 {
+	return p.replaced.Load()
+}
+
+// Stats returns the figures of every member in pool order; a member that is
+// reconnecting reports only its Reconnects.
+func (p *Pool) Stats() []Stats {
+	out := make([]Stats, len(p.members))
+	for i := range p.members {
+		out[i] = p.memberStats(i)
+	}
+	return out
+}
+
+// memberStats returns the figures of member i.
+func (p *Pool) memberStats(i int) Stats {
+	m := p.members[i]
+	var s Stats
+	if c := m.client.Load(); c != nil {
+		s = c.Stats()
+	}
+	s.Reconnects = m.replaced.Load()
+	return s
+}
+
+// RegisterStats publishes the figures of member i like Client.RegisterStats
+// under prefix.i.
+func (p *Pool) RegisterStats(ctrl api.Control, prefix string) {
+	for i := range p.members {
+		registerStats(ctrl, fmt.Sprintf("%s.%d", prefix, i), func() Stats { return p.memberStats(i) })
+	}
+}
+
+// Close stops the health checks and closes every connection.
+func (p *Pool) Close() error {
+	p.cancel()
+	p.wg.Wait()
+	for _, m := range p.members {
+		if c := m.client.Swap(nil); c != nil {
+			c.Close()
+		}
+	}
+	return nil
+}
+
+// pick returns the first connected member from start onwards.
+func (p *Pool) pick(start uint64) (*Client, error) {
+	n := uint64(len(p.members))
+	for i := uint64(0); i < n; i++ {
+		if c := p.members[(start+i)%n].client.Load(); alive(c) {
+			return c, nil
+		}
+	}
+	return nil, ErrPoolUnavailable
+}
+
+// monitor keeps m connected until the pool closes: it replaces the
+// connection as soon as it closes, or when a ping has gone unanswered for a
+// whole interval, and redials a missing one every interval.
+func (p *Pool) monitor(m *poolMember) {
+	defer p.wg.Done()
+	t := time.NewTicker(p.interval)
+	defer t.Stop()
+	var pinged time.Time
+	for {
+		c := m.client.Load()
+		var done <-chan struct{}
+		if c != nil {
+			done = c.conn.Done()
+		}
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-done:
+			p.replace(m, c)
+			pinged = time.Time{}
+			continue
+		case <-t.C:
+		}
+		if c == nil || (!pinged.IsZero() && c.conn.LastPong().Before(pinged)) {
+			p.replace(m, c)
+			pinged = time.Time{}
+			continue
+		}
+		pinged = time.Now()
+		if err := c.ping(); err != nil {
+			p.replace(m, c)
+			pinged = time.Time{}
+		}
+	}
+}
+
+// replace closes m's connection old, if any, and dials a new one. On failure
+// m stays empty until the next attempt.
+func (p *Pool) replace(m *poolMember, old *Client) {
+	if old != nil {
+		m.client.CompareAndSwap(old, nil)
+		old.Close()
+		p.replaced.Add(1)
+		m.replaced.Add(1)
+	}
+	if c, err := p.dial(m.addr); err == nil {
+		m.client.Store(c)
+	}
+}
+
+// dial connects to addr with the pool's template config.
+func (p *Pool) dial(addr string) (*Client, error) {
+	cfg := p.cfg
+	cfg.Addr = addr
+	return NewClientContext(p.ctx, &cfg)
+}
+
+// alive reports whether c is set and its connection is still open.
+func alive(c *Client) bool {
+	if c == nil {
+		return false
+	}
+	select {
+	case <-c.conn.Done():
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,256 @@
+// Package client dials WebSocket servers directly or through a proxy.
+//
+// An HTTP or HTTPS proxy is asked to open a tunnel with CONNECT, with Basic
+// credentials from the proxy URL, before the WebSocket handshake runs over it.
+// A socks5:// proxy gets a SOCKS5 CONNECT (RFC 1928), authenticated with the
+// URL's username and password (RFC 1929) when it has them; the target's host
+// name is resolved by the proxy.
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ErrProxyRejected is returned when the proxy refuses to open the tunnel.
+var ErrProxyRejected = errors.New("proxy refused tunnel")
+
+// dialServer connects to the host of u, through the proxy cfg.Proxy selects
+// for it, if any.
+func dialServer(ctx context.Context, cfg *Config, u *url.URL) (net.Conn, error) {
+	addr := hostPort(u)
+	if cfg.Proxy != nil {
+		proxyURL, err := cfg.Proxy(&http.Request{Method: "GET", URL: cookieURL(u)})
+		if err != nil {
+			return nil, fmt.Errorf("proxy: %w", err)
+		}
+		if proxyURL != nil {
+			return dialProxy(ctx, cfg, proxyURL, addr)
+		}
+	}
+	return dialTCP(ctx, cfg, addr)
+}
+
+// dialProxy opens a tunnel to addr through the proxy at proxyURL.
+func dialProxy(ctx context.Context, cfg *Config, proxyURL *url.URL, addr string) (net.Conn, error) {
+	var tunnel func(net.Conn, *url.URL, string) (net.Conn, error)
+	switch proxyURL.Scheme {
+	case "http", "https":
+		tunnel = connectTunnel
+	case "socks5", "socks5h":
+		tunnel = socks5Tunnel
+	default:
+		return nil, fmt.Errorf("proxy: unsupported scheme %q", proxyURL.Scheme)
+	}
+	conn, err := dialTCP(ctx, cfg, hostPort(proxyURL))
+	if err != nil {
+		return nil, fmt.Errorf("proxy dial: %w", err)
+	}
+	if proxyURL.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+	}
+
+	// Bound the exchange with the proxy by ctx, like the handshake.
+	if _, ok := ctx.Deadline(); !ok {
+		conn.SetDeadline(time.Now().Add(defaultHandshakeTimeout))
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	conn, err = tunnel(conn, proxyURL, addr)
+	stop()
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = fmt.Errorf("proxy: %w", ctxErr)
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// connectTunnel asks the HTTP proxy on conn to CONNECT to addr. conn is
+// closed on failure.
+func connectTunnel(conn net.Conn, proxyURL *url.URL, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u := proxyURL.User; u != nil {
+		pass, _ := u.Password()
+		cred := base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+cred)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy connect: %w", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy connect: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("%w: %s", ErrProxyRejected, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// SOCKS5 protocol constants, RFC 1928 and RFC 1929.
+const (
+	socks5Version  = 5
+	socks5NoAuth   = 0
+	socks5UserPass = 2
+	socks5CmdConn  = 1
+	socks5IPv4     = 1
+	socks5Domain   = 3
+	socks5IPv6     = 4
+)
+
+// socks5Tunnel asks the SOCKS5 proxy on conn to CONNECT to addr. conn is
+// closed on failure.
+func socks5Tunnel(conn net.Conn, proxyURL *url.URL, addr string) (net.Conn, error) {
+	if err := socks5Handshake(conn, proxyURL.User, addr); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// socks5Handshake runs the SOCKS5 method negotiation, authentication and
+// CONNECT request for addr on conn.
+func socks5Handshake(conn net.Conn, user *url.Userinfo, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("socks5: %w", err)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("socks5: bad port %q", portStr)
+	}
+
+	method := byte(socks5NoAuth)
+	if user != nil {
+		method = socks5UserPass
+	}
+	if _, err := conn.Write([]byte{socks5Version, 1, method}); err != nil {
+		return fmt.Errorf("socks5: %w", err)
+	}
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return fmt.Errorf("socks5: %w", err)
+	}
+	if reply[0] != socks5Version {
+		return fmt.Errorf("socks5: unexpected version %d", reply[0])
+	}
+	if reply[1] != method {
+		return fmt.Errorf("%w: no acceptable socks5 authentication method", ErrProxyRejected)
+	}
+	if method == socks5UserPass {
+		name := user.Username()
+		pass, _ := user.Password()
+		if len(name) > 255 || len(pass) > 255 {
+			return errors.New("socks5: credentials too long")
+		}
+		msg := append([]byte{1, byte(len(name))}, name...)
+		msg = append(append(msg, byte(len(pass))), pass...)
+		if _, err := conn.Write(msg); err != nil {
+			return fmt.Errorf("socks5: %w", err)
+		}
+		if _, err := io.ReadFull(conn, reply[:]); err != nil {
+			return fmt.Errorf("socks5: %w", err)
+		}
+		if reply[1] != 0 {
+			return fmt.Errorf("%w: socks5 authentication failed", ErrProxyRejected)
+		}
+	}
+
+	req := []byte{socks5Version, socks5CmdConn, 0}
+	if ip := net.ParseIP(host); ip.To4() != nil {
+		req = append(append(req, socks5IPv4), ip.To4()...)
+	} else if ip != nil {
+		req = append(append(req, socks5IPv6), ip...)
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("socks5: host name too long")
+		}
+		req = append(append(req, socks5Domain, byte(len(host))), host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return fmt.Errorf("socks5: %w", err)
+	}
+
+	// VER REP RSV ATYP, then the bound address, which is not needed.
+	var hdr [4]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return fmt.Errorf("socks5: %w", err)
+	}
+	if hdr[1] != 0 {
+		return fmt.Errorf("%w: socks5 reply %d", ErrProxyRejected, hdr[1])
+	}
+	var skip int
+	switch hdr[3] {
+	case socks5IPv4:
+		skip = net.IPv4len
+	case socks5IPv6:
+		skip = net.IPv6len
+	case socks5Domain:
+		var n [1]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			return fmt.Errorf("socks5: %w", err)
+		}
+		skip = int(n[0])
+	default:
+		return fmt.Errorf("socks5: unknown address type %d", hdr[3])
+	}
+	if _, err := io.CopyN(io.Discard, conn, int64(skip+2)); err != nil {
+		return fmt.Errorf("socks5: %w", err)
+	}
+	return nil
+}
+
+// bufferedConn is a net.Conn whose first bytes were read ahead into r.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	if c.r.Buffered() > 0 {
+		return c.r.Read(p)
+	}
+	return c.Conn.Read(p)
+}
+
+// hostPort returns the host:port of u, with the default port of its scheme
+// if it names none.
+func hostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	port := "80"
+	switch u.Scheme {
+	case "wss", "https":
+		port = "443"
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
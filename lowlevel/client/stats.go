@@ -0,0 +1,100 @@
+// Package client reports per-connection latency, queue and throughput figures.
+package client
+
+import (
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// Stats is a snapshot of a client connection's figures. Rates are averages
+// since the connection was established.
+type Stats struct {
+	RTT              time.Duration `json:"rtt"`       // round trip of the last answered heartbeat ping, 0 before the first pong
+	LastPong         time.Time     `json:"last_pong"` // zero before the first pong
+	PingsSent        uint64        `json:"pings_sent"`
+	QueueDepth       int           `json:"queue_depth"` // messages batched or queued and not yet written
+	MessagesSent     int64         `json:"messages_sent"`
+	BytesSent        int64         `json:"bytes_sent"` // on the wire, frame headers included
+	MessagesReceived int64         `json:"messages_received"`
+	BytesReceived    int64         `json:"bytes_received"` // payload bytes
+	SendRate         float64       `json:"send_bytes_per_sec"`
+	RecvRate         float64       `json:"recv_bytes_per_sec"`
+	Uptime           time.Duration `json:"uptime"`
+	Reconnects       uint64        `json:"reconnects"` // connections replaced, for a Pool member
+}
+
+// Stats returns a snapshot of the connection's figures.
+func (c *Client) Stats() Stats {
+	c.observeRTT()
+	ws := c.conn.GetStats()
+	s := Stats{
+		RTT:              time.Duration(c.rtt.Load()),
+		LastPong:         c.conn.LastPong(),
+		PingsSent:        c.pings.Load(),
+		QueueDepth:       c.sendBatch.Len() + int(ws["outbox_depth"]),
+		MessagesSent:     c.sentMsgs.Load(),
+		BytesSent:        c.sentBytes.Load(),
+		MessagesReceived: ws["frames_received"],
+		BytesReceived:    ws["bytes_received"],
+		Uptime:           time.Since(c.connectedAt),
+	}
+	if secs := s.Uptime.Seconds(); secs > 0 {
+		s.SendRate = float64(s.BytesSent) / secs
+		s.RecvRate = float64(s.BytesReceived) / secs
+	}
+	return s
+}
+
+// RegisterStats publishes the Stats figures as debug probes of ctrl named
+// prefix.rtt, prefix.queue_depth and so on, after the JSON field names, so
+// they appear in the admin endpoint and the metrics exporters. The probes
+// keep the client reachable for as long as ctrl holds them.
+func (c *Client) RegisterStats(ctrl api.Control, prefix string) {
+	registerStats(ctrl, prefix, c.Stats)
+}
+
+// statsProbes lists the figures registerStats publishes.
+var statsProbes = []struct {
+	name string
+	get  func(Stats) any
+}{
+	{"rtt", func(s Stats) any { return s.RTT }},
+	{"pings_sent", func(s Stats) any { return s.PingsSent }},
+	{"queue_depth", func(s Stats) any { return s.QueueDepth }},
+	{"messages_sent", func(s Stats) any { return s.MessagesSent }},
+	{"bytes_sent", func(s Stats) any { return s.BytesSent }},
+	{"messages_received", func(s Stats) any { return s.MessagesReceived }},
+	{"bytes_received", func(s Stats) any { return s.BytesReceived }},
+	{"send_bytes_per_sec", func(s Stats) any { return s.SendRate }},
+	{"recv_bytes_per_sec", func(s Stats) any { return s.RecvRate }},
+	{"reconnects", func(s Stats) any { return s.Reconnects }},
+}
+
+// registerStats registers a probe per figure of fn under prefix.
+func registerStats(ctrl api.Control, prefix string, fn func() Stats) {
+	for _, p := range statsProbes {
+		get := p.get
+		ctrl.RegisterDebugProbe(prefix+"."+p.name, func() any { return get(fn()) })
+	}
+}
+
+// observeRTT records the round trip of the last ping once its pong is in.
+func (c *Client) observeRTT() {
+	sent := c.pingSent.Load()
+	if sent == 0 {
+		return
+	}
+	if pong := c.conn.LastPong().UnixNano(); pong >= sent {
+		c.rtt.Store(pong - sent)
+	}
+}
+
+// ping sends a heartbeat ping, first recording the previous one's round trip.
+func (c *Client) ping() error {
+	c.observeRTT()
+	c.pingSent.Store(time.Now().UnixNano())
+	c.pings.Add(1)
+	return c.conn.SendFrame(&protocol.WSFrame{IsFinal: true, Opcode: protocol.OpcodePing, Masked: true})
+}
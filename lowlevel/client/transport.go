@@ -16,7 +16,6 @@ type transport struct {
 	conn    net.Conn
 	bufPool api.BufferPool
 	bufSize int
-	rxBuf   api.Buffer
 }
 
 // NewTransport constructs a NUMA-aware, zero-copy transport.
@@ -25,7 +24,6 @@ func NewTransport(conn net.Conn, bp api.BufferPool, bufSize int) api.Transport {
 		conn:    conn,
 		bufPool: bp,
 		bufSize: bufSize,
-		rxBuf:   bp.Get(bufSize, -1),
 	}
 }
 
@@ -42,23 +40,32 @@ func (t *transport) Send(bufs [][]byte) error {
 	return nil
 }
 
+// Recv returns a copy of the next read; the pool buffer it was read into
+// goes straight back. WSConnection reads with RecvBuffers instead.
 func (t *transport) Recv() ([][]byte, error) {
-	data := t.rxBuf.Bytes()
-	if len(data) < t.bufSize {
-		t.rxBuf = t.bufPool.Get(t.bufSize, -1)
-		data = t.rxBuf.Bytes()
+	bufs, err := t.RecvBuffers()
+	if err != nil {
+		return nil, err
 	}
-	n, err := t.conn.Read(data)
+	data := append([]byte(nil), bufs[0].Data...)
+	bufs[0].Release()
+	return [][]byte{data}, nil
+}
+
+// RecvBuffers implements api.BufferReceiver. It reads into a fresh pool
+// buffer each time: the caller owns it, as decoded frames may still
+// reference it while the next read is in flight.
+func (t *transport) RecvBuffers() ([]api.Buffer, error) {
+	buf := t.bufPool.Get(t.bufSize, -1)
+	n, err := t.conn.Read(buf.Bytes())
 	if err != nil {
+		buf.Release()
 		return nil, fmt.Errorf("recv error: %w", err)
 	}
-	return [][]byte{data[:n]}, nil
+	return []api.Buffer{buf.Slice(0, n)}, nil
 }
 
 func (t *transport) Close() error {
-	if t.rxBuf.Data != nil {
-		t.rxBuf.Release()
-	}
 	return t.conn.Close()
 }
 
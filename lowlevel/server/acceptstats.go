@@ -0,0 +1,170 @@
+// File: server/acceptstats.go
+// Package server counts accepts and handshake failures by reason.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package server
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/momentics/hioload-ws/control"
+	"github.com/momentics/hioload-ws/internal/transport"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// Accept-loop probe names, exposed under the "debug." prefix. Handshake
+// failures are counted per reason as ProbeHandshakeFailures+"."+reason, e.g.
+// "handshake.failures.tls"; the latency of successful handshakes is
+// ProbeHandshakeLatency.
+const (
+	ProbeAccepts           = "accept.total"       // TCP connections accepted
+	ProbeAcceptRate        = "accept.per_sec"     // accepts during the last full second
+	ProbeAcceptErrors      = "accept.errors"      // failures of the listening socket itself, e.g. out of descriptors
+	ProbeAcceptPending     = "accept.pending"     // connections queued for a handshake worker, see Config.AcceptWorkers
+	ProbeHandshakeFailures = "handshake.failures" // prefix of the per-reason counters
+)
+
+// Handshake failure reasons counted under ProbeHandshakeFailures.
+const (
+	FailIPDenied     = "ip_denied"     // refused by the IP filter
+	FailTLS          = "tls"           // TLS handshake failed
+	FailBadRequest   = "bad_request"   // no valid upgrade request, or the client left mid-way
+	FailUnauthorized = "unauthorized"  // rejected with 401 or 403
+	FailNotFound     = "not_found"     // rejected with 404, no route
+	FailRateLimited  = "rate_limited"  // rejected with 429
+	FailOverCapacity = "over_capacity" // rejected with 503, MaxConnections reached
+	FailDraining     = "draining"      // rejected with 503 by Drain
+	FailRejected     = "rejected"      // rejected otherwise by a handshake check
+	FailWrite        = "write_failed"  // the 101 response could not be written
+)
+
+// failReasons lists the reasons in probe registration order.
+var failReasons = []string{
+	FailIPDenied, FailTLS, FailBadRequest, FailUnauthorized, FailNotFound,
+	FailRateLimited, FailOverCapacity, FailDraining, FailRejected, FailWrite,
+}
+
+// acceptStats counts the outcome of every accepted connection.
+type acceptStats struct {
+	accepts  control.Counter
+	errors   control.Counter
+	failures map[string]*control.Counter // keyed by reason, fixed after creation
+
+	mu     sync.Mutex
+	second int64  // Unix second counted in cur
+	cur    uint64 // accepts during second
+	last   uint64 // accepts during second-1
+}
+
+func newAcceptStats() *acceptStats {
+	a := &acceptStats{failures: make(map[string]*control.Counter, len(failReasons))}
+	for _, r := range failReasons {
+		a.failures[r] = new(control.Counter)
+	}
+	return a
+}
+
+// accepted counts one TCP connection accepted at now.
+func (a *acceptStats) accepted(now time.Time) {
+	a.accepts.Inc()
+	sec := now.Unix()
+	a.mu.Lock()
+	if sec != a.second {
+		a.last = 0
+		if sec == a.second+1 {
+			a.last = a.cur
+		}
+		a.second, a.cur = sec, 0
+	}
+	a.cur++
+	a.mu.Unlock()
+}
+
+// rate returns the accepts of the last full second before now.
+func (a *acceptStats) rate(now time.Time) uint64 {
+	sec := now.Unix()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch sec {
+	case a.second:
+		return a.last
+	case a.second + 1:
+		return a.cur
+	}
+	return 0
+}
+
+// record counts the outcome of one Accept call, err being nil for an
+// upgraded connection.
+func (a *acceptStats) record(err error) {
+	if err == nil {
+		a.accepted(time.Now())
+		return
+	}
+	if errors.Is(err, transport.ErrAddressDenied) {
+		a.accepted(time.Now())
+		a.failures[FailIPDenied].Inc()
+		return
+	}
+	var herr *transport.HandshakeError
+	if !errors.As(err, &herr) {
+		a.errors.Inc()
+		return
+	}
+	a.accepted(time.Now())
+	a.failures[failReason(herr)].Inc()
+}
+
+// failReason classifies a failed handshake.
+func failReason(herr *transport.HandshakeError) string {
+	switch herr.Reason {
+	case transport.HandshakeTLS:
+		return FailTLS
+	case transport.HandshakeBadRequest:
+		return FailBadRequest
+	case transport.HandshakeWriteFailed:
+		return FailWrite
+	}
+	var rej *protocol.HandshakeRejection
+	if !errors.As(herr, &rej) {
+		return FailRejected
+	}
+	if rej == errDraining {
+		return FailDraining
+	}
+	switch rej.Status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return FailUnauthorized
+	case http.StatusNotFound:
+		return FailNotFound
+	case http.StatusTooManyRequests:
+		return FailRateLimited
+	case http.StatusServiceUnavailable:
+		return FailOverCapacity
+	}
+	return FailRejected
+}
+
+// registerAcceptProbes exposes the accept counters through control.
+func (s *Server) registerAcceptProbes() {
+	a := s.accepts
+	s.control.RegisterDebugProbe(ProbeAccepts, func() any { return &a.accepts })
+	s.control.RegisterDebugProbe(ProbeAcceptRate, func() any { return a.rate(time.Now()) })
+	s.control.RegisterDebugProbe(ProbeAcceptErrors, func() any { return &a.errors })
+	s.control.RegisterDebugProbe(ProbeAcceptPending, func() any {
+		var n int64
+		listeners, _ := s.listenerSet()
+		for _, l := range listeners {
+			n += int64(l.Pending())
+		}
+		return n
+	})
+	for _, r := range failReasons {
+		c := a.failures[r]
+		s.control.RegisterDebugProbe(ProbeHandshakeFailures+"."+r, func() any { return c })
+	}
+}
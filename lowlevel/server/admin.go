@@ -0,0 +1,64 @@
+// File: server/admin.go
+// Package server wires the control admin endpoint to the facade.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/control"
+)
+
+// AdminServer returns an admin HTTP server for addr exposing this server's
+// control stats, debug probes, config, live connections, per-connection
+// tracing, health probes (/livez, /readyz), pprof, hot-reload and drain. POST
+// /drain runs Drain, bounded by its timeout parameter, then shuts the server
+// down. opts are applied after the
+// facade defaults, so they may override them (e.g. control.WithAdminToken).
+// The caller starts and stops it.
+func (s *Server) AdminServer(addr string, opts ...control.AdminOption) *control.AdminServer {
+	defaults := []control.AdminOption{
+		control.WithAdminStats(s.control.Stats),
+		control.WithAdminConfig(s.control.GetConfig, s.control.SetConfig),
+		control.WithAdminConnections(s.connInfo),
+		control.WithAdminTrace(s.TraceConnection),
+		control.WithAdminHealth(s.health),
+		control.WithAdminDrain(func(ctx context.Context) error {
+			err := s.Drain(ctx)
+			s.Shutdown()
+			return err
+		}),
+	}
+	if d, ok := s.control.(interface{ GetDebug() api.Debug }); ok {
+		defaults = append(defaults, control.WithAdminProbes(d.GetDebug().DumpState))
+	}
+	return control.NewAdminServer(addr, append(defaults, opts...)...)
+}
+
+// connInfo lists the admitted connections.
+func (s *Server) connInfo() []control.ConnInfo {
+	s.conns.mu.Lock()
+	defer s.conns.mu.Unlock()
+	out := make([]control.ConnInfo, 0, len(s.conns.live))
+	for conn, slot := range s.conns.live {
+		info := control.ConnInfo{
+			Path:        conn.Path(),
+			Subprotocol: conn.Subprotocol(),
+			LastActive:  time.Unix(0, slot.lastActive.Load()),
+			Stats:       conn.GetStats(),
+			Trace:       conn.Tracing(),
+		}
+		if sess := conn.Session(); sess != nil {
+			info.ID = sess.ID()
+		}
+		if ra := conn.RemoteAddr(); ra != nil {
+			info.RemoteAddr = ra.String()
+		}
+		out = append(out, info)
+	}
+	return out
+}
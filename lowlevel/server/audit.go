@@ -0,0 +1,99 @@
+// File: server/audit.go
+// Package server records connection lifecycle events in the audit ring.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/control"
+	"github.com/momentics/hioload-ws/internal/transport"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// Audit probe names: the retained events (oldest first) and the number ever
+// recorded.
+const (
+	ProbeAuditEvents = "audit.events"
+	ProbeAuditTotal  = "audit.total"
+)
+
+// AuditEvents returns the recent connect, disconnect and error events,
+// oldest first.
+func (s *Server) AuditEvents() []control.AuditEvent {
+	return s.audit.Events()
+}
+
+func (s *Server) registerAuditProbes() {
+	s.control.RegisterDebugProbe(ProbeAuditEvents, func() any { return s.audit.Events() })
+	s.control.RegisterDebugProbe(ProbeAuditTotal, func() any { return s.audit.Total() })
+}
+
+// auditConnect records an admitted connection.
+func (s *Server) auditConnect(conn *protocol.WSConnection) {
+	s.audit.Record(control.AuditEvent{Kind: control.AuditConnect, ID: sessionID(conn), RemoteAddr: remoteAddr(conn)})
+}
+
+// auditDisconnect records the end of conn, opened at start, after a read
+// returned err. Unexpected read errors are recorded as a separate error
+// event; a connection closed without a close frame reports 1006.
+func (s *Server) auditDisconnect(conn *protocol.WSConnection, start time.Time, err error) {
+	id, addr := sessionID(conn), remoteAddr(conn)
+	code, reason, ok := conn.CloseStatus()
+	if !ok {
+		code = protocol.CloseAbnormalClosure
+		if err := abnormalErr(err); err != nil {
+			s.audit.Record(control.AuditEvent{Kind: control.AuditError, ID: id, RemoteAddr: addr, Error: err.Error()})
+		}
+	}
+	s.audit.Record(control.AuditEvent{
+		Kind:       control.AuditDisconnect,
+		ID:         id,
+		RemoteAddr: addr,
+		Code:       code,
+		Reason:     reason,
+		Duration:   time.Since(start),
+	})
+}
+
+// abnormalErr returns err unless it only reports the end of the stream or a
+// connection already closed locally.
+func abnormalErr(err error) error {
+	if err == nil || errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || errors.Is(err, api.ErrTransportClosed) {
+		return nil
+	}
+	return err
+}
+
+// auditAcceptError records a failed or rejected handshake.
+func (s *Server) auditAcceptError(err error) {
+	var herr *transport.HandshakeError
+	if !errors.As(err, &herr) {
+		return
+	}
+	e := control.AuditEvent{Kind: control.AuditError, Reason: failReason(herr), Error: herr.Err.Error()}
+	if herr.RemoteAddr != nil {
+		e.RemoteAddr = herr.RemoteAddr.String()
+	}
+	s.audit.Record(e)
+}
+
+func sessionID(conn *protocol.WSConnection) string {
+	if sess := conn.Session(); sess != nil {
+		return sess.ID()
+	}
+	return ""
+}
+
+func remoteAddr(conn *protocol.WSConnection) string {
+	if ra := conn.RemoteAddr(); ra != nil {
+		return ra.String()
+	}
+	return ""
+}
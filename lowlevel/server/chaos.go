@@ -0,0 +1,88 @@
+// File: server/chaos.go
+// Package server injects faults in builds with the chaos tag.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package server
+
+import (
+	"time"
+
+	"github.com/momentics/hioload-ws/chaos"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// Control config keys of the fault injector, applied at once to every
+// connection. They are ignored in builds without the chaos tag; see package
+// chaos.
+const (
+	CfgChaosDropEvery     = "chaos.drop_every"      // drop every Nth data frame per connection and direction (0 = off)
+	CfgChaosSendDelay     = "chaos.send_delay"      // delay of every transport write
+	CfgChaosSendJitter    = "chaos.send_jitter"     // random extra write delay, up to this
+	CfgChaosCloseRate     = "chaos.close_rate"      // probability that a transport read or write cuts the connection
+	CfgChaosAllocFailRate = "chaos.alloc_fail_rate" // probability that a buffer allocation fails
+)
+
+// ProbeChaos reports the faults injected as chaos.Stats, under the "debug."
+// prefix. It is only registered in builds with the chaos tag.
+const ProbeChaos = "chaos.stats"
+
+// initChaos creates the fault injector of chaos builds, off until the
+// CfgChaos* keys are set, and has it wrap the buffer pools of the reactor
+// nodes. It must run before the listeners open.
+func (s *Server) initChaos() {
+	if !chaos.Enabled {
+		return
+	}
+	s.chaos = chaos.New(chaos.Config{}, uint64(time.Now().UnixNano()))
+	for _, n := range s.nodes {
+		n.pool = s.chaos.Pool(n.pool)
+	}
+	s.pool = s.nodes[0].pool
+	s.control.OnReload(s.reloadChaos)
+	s.control.RegisterDebugProbe(ProbeChaos, func() any {
+		return s.chaos.Stats()
+	})
+}
+
+// reloadChaos applies the CfgChaos* keys present in the control config.
+func (s *Server) reloadChaos() {
+	cfg := s.control.GetConfig()
+	c := s.chaos.Config()
+	if v, ok := cfg[CfgChaosDropEvery]; ok {
+		var n int
+		if setInt(&n, v) == nil && n >= 0 {
+			c.DropEvery = n
+		}
+	}
+	for key, dst := range map[string]*time.Duration{
+		CfgChaosSendDelay:  &c.SendDelay,
+		CfgChaosSendJitter: &c.SendJitter,
+	} {
+		if v, ok := cfg[key]; ok {
+			var d time.Duration
+			if setDuration(&d, v) == nil && d >= 0 {
+				*dst = d
+			}
+		}
+	}
+	for key, dst := range map[string]*float64{
+		CfgChaosCloseRate:     &c.CloseRate,
+		CfgChaosAllocFailRate: &c.AllocFailRate,
+	} {
+		if v, ok := cfg[key]; ok {
+			var f float64
+			if setFloat(&f, v) == nil && f >= 0 && f <= 1 {
+				*dst = f
+			}
+		}
+	}
+	s.chaos.Set(c)
+}
+
+// wrapChaos subjects conn to the injector during its handshake.
+func (s *Server) wrapChaos(conn *protocol.WSConnection) {
+	if s.chaos != nil {
+		s.chaos.Wrap(conn)
+	}
+}
@@ -0,0 +1,286 @@
+// File: server/configfile.go
+// Package server maps config files onto Config and keeps them hot-reloaded.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package server
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/momentics/hioload-ws/control"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// Config file keys, optionally nested under a "server" table/mapping. The
+// "ratelimit.*" keys (CfgHandshakesPerSec etc.), "ipfilter.*" lists
+// (CfgIPAllow, CfgIPDeny) and "feature.*" toggles (control.CfgFeatureCompression
+// etc.) are accepted as well. Only the rate limits, IP filter, toggles,
+// CfgBatchSize, CfgReadTimeout, CfgWriteTimeout and CfgMaxConnections take
+// effect on a running server; the rest are read when the server is
+// constructed.
+const (
+	CfgListenAddr      = "listen_addr"
+	CfgIOBufferSize    = "io_buffer_size"
+	CfgChannelCapacity = "channel_capacity"
+	CfgNUMANode        = "numa_node"
+	CfgReadTimeout     = "read_timeout"
+	CfgWriteTimeout    = "write_timeout"
+	CfgBatchSize       = "batch_size"
+	CfgReactorRing     = "reactor_ring"
+	CfgExecutorWorkers = "executor_workers"
+	CfgShutdownTimeout = "shutdown_timeout"
+	CfgHandlerTimeout  = "handler_timeout"
+	CfgSlowHandler     = "slow_handler"
+	CfgMaxConnections  = "max_connections"
+	CfgOverflowPolicy  = "overflow_policy"
+	CfgOverflowWait    = "overflow_wait"
+	CfgDrainIdle       = "drain_idle"
+	CfgIdleTimeout     = "idle_timeout"
+	CfgIdleIgnorePongs = "idle_ignore_pongs"
+	CfgSubprotocols    = "subprotocols"
+	CfgAuditSize       = "audit_size"
+
+	// Outbox limit keys, see Config.Outbox.
+	CfgOutboxHighWater  = "outbox_high_water"
+	CfgSlowConsumer     = "slow_consumer"
+	CfgSlowConsumerWait = "slow_consumer_wait"
+	CfgSlowConsumerCode = "slow_consumer_close_code"
+
+	// CfgReusePort sets Config.ReusePort.
+	CfgReusePort = "reuse_port"
+
+	// CfgNUMAAcceptors sets Config.NUMAAcceptors.
+	CfgNUMAAcceptors = "numa_acceptors"
+
+	// Handshake worker keys, see Config.AcceptWorkers.
+	CfgAcceptWorkers = "accept_workers"
+	CfgAcceptQueue   = "accept_queue"
+
+	// CfgAcceptEx sets Config.AcceptEx.
+	CfgAcceptEx = "accept_ex"
+
+	// CfgFairQuantum sets Config.FairQuantum.
+	CfgFairQuantum = "fair_quantum"
+
+	// CfgReadBufferLimit sets Config.ReadBufferLimit.
+	CfgReadBufferLimit = "read_buffer_limit"
+)
+
+// LoadConfig reads a JSON, YAML or TOML file (see control.LoadConfig) on top
+// of DefaultConfig.
+func LoadConfig(path string) (*Config, error) {
+	values, err := control.LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := DefaultConfig()
+	if err := ApplyConfig(cfg, values); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// ApplyEnv overlays HIOLOAD_* environment variables (see control.LoadEnv)
+// onto cfg, e.g. HIOLOAD_LISTEN_ADDR, HIOLOAD_BATCH_SIZE, HIOLOAD_NUMA_NODE
+// or HIOLOAD_SHUTDOWN_TIMEOUT=10s. Call it after LoadConfig so deployment
+// settings override the file.
+func ApplyEnv(cfg *Config) error {
+	return ApplyConfig(cfg, control.LoadEnv(control.EnvPrefix))
+}
+
+// ApplyConfig sets the Config fields named by values. Durations are Go
+// duration strings ("30s") or numbers of seconds. Unknown keys are ignored so
+// one file can also carry application settings.
+func ApplyConfig(cfg *Config, values map[string]any) error {
+	for key, v := range values {
+		var err error
+		switch strings.TrimPrefix(key, "server.") {
+		case CfgListenAddr:
+			err = setString(&cfg.ListenAddr, v)
+		case CfgIOBufferSize:
+			err = setInt(&cfg.IOBufferSize, v)
+		case CfgChannelCapacity:
+			err = setInt(&cfg.ChannelCapacity, v)
+		case CfgNUMANode:
+			err = setInt(&cfg.NUMANode, v)
+		case CfgReadTimeout:
+			err = setDuration(&cfg.ReadTimeout, v)
+		case CfgWriteTimeout:
+			err = setDuration(&cfg.WriteTimeout, v)
+		case CfgBatchSize:
+			err = setInt(&cfg.BatchSize, v)
+		case CfgReactorRing:
+			err = setInt(&cfg.ReactorRing, v)
+		case CfgExecutorWorkers:
+			err = setInt(&cfg.ExecutorWorkers, v)
+		case CfgShutdownTimeout:
+			err = setDuration(&cfg.ShutdownTimeout, v)
+		case CfgHandlerTimeout:
+			err = setDuration(&cfg.HandlerTimeout, v)
+		case CfgSlowHandler:
+			err = setDuration(&cfg.SlowHandler, v)
+		case CfgMaxConnections:
+			err = setInt(&cfg.MaxConnections, v)
+		case CfgOverflowPolicy:
+			var s string
+			if err = setString(&s, v); err == nil {
+				cfg.OverflowPolicy = OverflowPolicy(s)
+			}
+		case CfgOverflowWait:
+			err = setDuration(&cfg.OverflowWait, v)
+		case CfgDrainIdle:
+			err = setDuration(&cfg.DrainIdle, v)
+		case CfgIdleTimeout:
+			err = setDuration(&cfg.IdleTimeout, v)
+		case CfgIdleIgnorePongs:
+			err = setBool(&cfg.IdleIgnorePongs, v)
+		case CfgSubprotocols:
+			err = setStrings(&cfg.Subprotocols, v)
+		case CfgAuditSize:
+			err = setInt(&cfg.AuditSize, v)
+		case CfgOutboxHighWater:
+			err = setInt(&cfg.Outbox.HighWater, v)
+		case CfgSlowConsumer:
+			var s string
+			if err = setString(&s, v); err == nil {
+				cfg.Outbox.Policy = protocol.SlowConsumerPolicy(s)
+			}
+		case CfgSlowConsumerWait:
+			err = setDuration(&cfg.Outbox.Wait, v)
+		case CfgSlowConsumerCode:
+			var code int
+			if err = setInt(&code, v); err == nil {
+				cfg.Outbox.CloseCode = uint16(code)
+			}
+		case CfgReadBufferLimit:
+			err = setInt(&cfg.ReadBufferLimit, v)
+		case CfgFairQuantum:
+			err = setInt(&cfg.FairQuantum, v)
+		case CfgReusePort:
+			err = setBool(&cfg.ReusePort, v)
+		case CfgNUMAAcceptors:
+			err = setBool(&cfg.NUMAAcceptors, v)
+		case CfgAcceptWorkers:
+			err = setInt(&cfg.AcceptWorkers, v)
+		case CfgAcceptQueue:
+			err = setInt(&cfg.AcceptQueue, v)
+		case CfgAcceptEx:
+			err = setInt(&cfg.AcceptEx, v)
+		case CfgIPAllow:
+			err = setStrings(&cfg.IPAllow, v)
+		case CfgIPDeny:
+			err = setStrings(&cfg.IPDeny, v)
+		case control.CfgFeatureCompression:
+			err = setBool(&cfg.Compression, v)
+		case control.CfgFeatureKeepAlive:
+			err = setDuration(&cfg.KeepAlive, v)
+		case control.CfgFeatureStrict:
+			err = setBool(&cfg.StrictValidation, v)
+		case CfgHandshakesPerSec:
+			err = setFloat(&cfg.RateLimit.HandshakesPerSec, v)
+		case CfgHandshakeBurst:
+			err = setInt(&cfg.RateLimit.HandshakeBurst, v)
+		case CfgFramesPerSec:
+			err = setFloat(&cfg.RateLimit.FramesPerSec, v)
+		case CfgFrameBurst:
+			err = setInt(&cfg.RateLimit.FrameBurst, v)
+		case CfgFrameKey:
+			var s string
+			if err = setString(&s, v); err == nil {
+				cfg.RateLimit.FrameKey = RateLimitKey(s)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("config: %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// WatchConfig applies path to this server's control config and keeps it in
+// sync on SIGHUP or file change, so hot-reload observers (rate limits and any
+// registered through Control.OnReload) see the new values. "server." key
+// prefixes are stripped.
+func (s *Server) WatchConfig(path string, opts ...control.ConfigWatchOption) (*control.ConfigWatcher, error) {
+	return control.WatchConfig(path, func(changed map[string]any) error {
+		cfg := make(map[string]any, len(changed))
+		for k, v := range changed {
+			cfg[strings.TrimPrefix(k, "server.")] = v
+		}
+		return s.control.SetConfig(cfg)
+	}, opts...)
+}
+
+func setString(dst *string, v any) error {
+	s, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("expected string, got %T", v)
+	}
+	*dst = s
+	return nil
+}
+
+func setStrings(dst *[]string, v any) error {
+	list, ok := v.([]any)
+	if !ok {
+		return fmt.Errorf("expected list, got %T", v)
+	}
+	out := make([]string, 0, len(list))
+	for _, item := range list {
+		s, ok := item.(string)
+		if !ok {
+			return fmt.Errorf("expected list of strings, got %T item", item)
+		}
+		out = append(out, s)
+	}
+	*dst = out
+	return nil
+}
+
+func setBool(dst *bool, v any) error {
+	b, ok := v.(bool)
+	if !ok {
+		return fmt.Errorf("expected boolean, got %T", v)
+	}
+	*dst = b
+	return nil
+}
+
+func setInt(dst *int, v any) error {
+	f := floatValue(v, math.NaN())
+	if math.IsNaN(f) || f != math.Trunc(f) {
+		return fmt.Errorf("expected integer, got %v", v)
+	}
+	*dst = int(f)
+	return nil
+}
+
+func setFloat(dst *float64, v any) error {
+	f := floatValue(v, math.NaN())
+	if math.IsNaN(f) {
+		return fmt.Errorf("expected number, got %T", v)
+	}
+	*dst = f
+	return nil
+}
+
+func setDuration(dst *time.Duration, v any) error {
+	if s, ok := v.(string); ok {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*dst = d
+		return nil
+	}
+	f := floatValue(v, math.NaN())
+	if math.IsNaN(f) {
+		return fmt.Errorf("expected duration, got %T", v)
+	}
+	*dst = time.Duration(f * float64(time.Second))
+	return nil
+}
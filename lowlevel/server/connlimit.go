@@ -0,0 +1,231 @@
+// File: server/connlimit.go
+// Package server enforces Config.MaxConnections with a selectable overflow policy.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package server
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// defaultOverflowWait bounds OverflowQueue waits when OverflowWait is unset.
+const defaultOverflowWait = 5 * time.Second
+
+// connSlot is the accounting record of one admitted connection.
+type connSlot struct {
+	lastActive atomic.Int64 // unix nanos of the last inbound batch
+}
+
+// touch records inbound activity at now.
+func (c *connSlot) touch(now time.Time) {
+	c.lastActive.Store(now.UnixNano())
+}
+
+// connTable tracks admitted connections, the peak count and overflow rejections.
+type connTable struct {
+	mu       sync.Mutex
+	clock    api.Clock
+	live     map[*protocol.WSConnection]*connSlot
+	peak     int64
+	rejected atomic.Int64
+	freed    chan struct{} // closed and replaced whenever a slot frees up
+}
+
+func newConnTable(clock api.Clock) *connTable {
+	return &connTable{
+		clock: clock,
+		live:  make(map[*protocol.WSConnection]*connSlot),
+		freed: make(chan struct{}),
+	}
+}
+
+// current returns the number of admitted connections.
+func (t *connTable) current() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return int64(len(t.live))
+}
+
+// peakCount returns the highest number of simultaneously admitted connections.
+func (t *connTable) peakCount() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.peak
+}
+
+// tryAdd admits conn if fewer than max (0 = unlimited) are live.
+func (t *connTable) tryAdd(conn *protocol.WSConnection, max int) (*connSlot, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if max > 0 && len(t.live) >= max {
+		return nil, false
+	}
+	slot := &connSlot{}
+	slot.touch(t.clock.Now())
+	t.live[conn] = slot
+	if n := int64(len(t.live)); n > t.peak {
+		t.peak = n
+	}
+	return slot, true
+}
+
+// remove frees conn's slot; it reports false if conn was already evicted.
+func (t *connTable) remove(conn *protocol.WSConnection) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.live[conn]; !ok {
+		return false
+	}
+	delete(t.live, conn)
+	close(t.freed)
+	t.freed = make(chan struct{})
+	return true
+}
+
+// wake signals connections waiting for a slot to check again, e.g. after the
+// limit was raised.
+func (t *connTable) wake() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	close(t.freed)
+	t.freed = make(chan struct{})
+}
+
+// snapshot returns the admitted connections.
+func (t *connTable) snapshot() []*protocol.WSConnection {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	conns := make([]*protocol.WSConnection, 0, len(t.live))
+	for conn := range t.live {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// idleSince returns the admitted connections that neither received nor sent
+// anything after cutoff.
+func (t *connTable) idleSince(cutoff time.Time) []*protocol.WSConnection {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var conns []*protocol.WSConnection
+	for conn, slot := range t.live {
+		if slot.lastActive.Load() < cutoff.UnixNano() && conn.LastSend().Before(cutoff) {
+			conns = append(conns, conn)
+		}
+	}
+	return conns
+}
+
+// full reports whether max connections are live, returning the channel
+// signalled on the next release.
+func (t *connTable) full(max int) (bool, <-chan struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return max > 0 && len(t.live) >= max, t.freed
+}
+
+// evictIdlest frees the slot of the connection idle the longest and returns it.
+func (t *connTable) evictIdlest() *protocol.WSConnection {
+	t.mu.Lock()
+	var victim *protocol.WSConnection
+	var oldest int64
+	for conn, slot := range t.live {
+		if at := slot.lastActive.Load(); victim == nil || at < oldest {
+			victim, oldest = conn, at
+		}
+	}
+	t.mu.Unlock()
+	if victim == nil || !t.remove(victim) {
+		return nil
+	}
+	return victim
+}
+
+// admitHandshake runs in the handshake hook: under OverflowReject a full
+// server answers 503, under OverflowCloseOldestIdle it makes room by closing
+// the idlest connection. OverflowQueue admits and waits in acquireConn.
+func (s *Server) admitHandshake() error {
+	max := s.maxConnections()
+	if full, _ := s.conns.full(max); !full {
+		return nil
+	}
+	switch s.cfg.OverflowPolicy {
+	case OverflowQueue:
+		return nil
+	case OverflowCloseOldestIdle:
+		if victim := s.conns.evictIdlest(); victim != nil {
+			victim.CloseWithCode(protocol.CloseTryAgainLater, "evicted: connection limit")
+			return nil
+		}
+	}
+	s.conns.rejected.Add(1)
+	return &protocol.HandshakeRejection{Status: http.StatusServiceUnavailable, Reason: "connection limit reached"}
+}
+
+// acquireConn takes a connection slot for an upgraded conn, waiting up to
+// OverflowWait under OverflowQueue, unless the server is draining. On failure
+// conn is closed with 1013.
+func (s *Server) acquireConn(conn *protocol.WSConnection) (*connSlot, bool) {
+	var deadline <-chan time.Time
+	for {
+		if s.draining.Load() {
+			break
+		}
+		max := s.maxConnections()
+		if slot, ok := s.conns.tryAdd(conn, max); ok {
+			return slot, true
+		}
+		full, freed := s.conns.full(max)
+		if !full {
+			continue
+		}
+		if s.cfg.OverflowPolicy != OverflowQueue {
+			break
+		}
+		if deadline == nil {
+			wait := s.cfg.OverflowWait
+			if wait <= 0 {
+				wait = defaultOverflowWait
+			}
+			timer := s.clock().NewTimer(wait)
+			defer timer.Stop()
+			deadline = timer.C()
+		}
+		select {
+		case <-freed:
+			continue
+		case <-deadline:
+		case <-conn.Done():
+		case <-s.shutdownCh:
+		}
+		break
+	}
+	s.conns.rejected.Add(1)
+	conn.CloseWithCode(protocol.CloseTryAgainLater, "connection limit reached")
+	return nil, false
+}
+
+// releaseConn frees conn's slot unless it was already evicted.
+func (s *Server) releaseConn(conn *protocol.WSConnection) {
+	s.conns.remove(conn)
+}
+
+// registerConnProbes exposes connection counts through control.
+func (s *Server) registerConnProbes() {
+	s.control.RegisterDebugProbe("connections.current", func() any {
+		return s.conns.current()
+	})
+	s.control.RegisterDebugProbe("connections.peak", func() any {
+		return s.conns.peakCount()
+	})
+	s.control.RegisterDebugProbe("connections.rejected", func() any {
+		return s.conns.rejected.Load()
+	})
+}
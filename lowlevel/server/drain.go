@@ -0,0 +1,102 @@
+// File: server/drain.go
+// Package server drains connections ahead of a rolling deploy.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// DefaultDrainIdle is how long a connection must have neither received nor
+// sent anything before Drain closes it, when Config.DrainIdle is unset.
+const DefaultDrainIdle = time.Second
+
+// Drain probe names, exposed under the "debug." prefix.
+const (
+	ProbeDraining       = "drain.active"    // true once Drain was called
+	ProbeDrainRemaining = "drain.remaining" // connections still open while draining, else 0
+)
+
+// errDraining refuses handshakes once Drain was called.
+var errDraining = &protocol.HandshakeRejection{Status: http.StatusServiceUnavailable, Reason: "server draining"}
+
+// Drain takes the server out of rotation, e.g. for a rolling deploy behind a
+// load balancer: readiness fails, handshakes still in progress are refused
+// with 503 and Connection: close, and the listeners close. A 101 answer must
+// carry Connection: Upgrade, so the refusal is where the close is
+// advertised. Connections are then sent 1001 Going Away as they go idle,
+// having neither received nor sent anything for Config.DrainIdle, so idle
+// ones leave first and busy ones, push-only streams included, finish their
+// exchange. The close frame is queued behind the data already queued, and
+// the peer's answer closes the connection, or Drain does once the peer has
+// stayed silent for Config.DrainIdle after it. Drain returns once none are
+// left; those still open when ctx is done are sent 1001 and closed once
+// their queued frames are written, and ctx.Err() is returned.
+//
+// The reactor keeps running while draining so handlers complete; call
+// Shutdown afterwards. Progress is reported through ProbeDrainRemaining.
+func (s *Server) Drain(ctx context.Context) error {
+	s.draining.Store(true)
+	s.StopAccepting()
+	s.conns.wake() // queued connections give up
+
+	idle := s.cfg.DrainIdle
+	if idle <= 0 {
+		idle = DefaultDrainIdle
+	}
+	clock := s.clock()
+	tick := clock.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		for _, conn := range s.conns.idleSince(clock.Now().Add(-idle)) {
+			if conn.SendClose(protocol.CloseGoingAway, "server draining") == protocol.ErrCloseSent {
+				conn.CloseAfterSend() // the peer left the close unanswered
+			}
+		}
+		if s.conns.current() == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			for _, conn := range s.conns.snapshot() {
+				conn.SendClose(protocol.CloseGoingAway, "server draining")
+				conn.CloseAfterSend()
+			}
+			return ctx.Err()
+		case <-tick.C():
+		}
+	}
+}
+
+// Draining reports whether Drain was called.
+func (s *Server) Draining() bool {
+	return s.draining.Load()
+}
+
+// admitDraining runs in the handshake hook and refuses upgrades once the
+// server is draining.
+func (s *Server) admitDraining() error {
+	if s.draining.Load() {
+		return errDraining
+	}
+	return nil
+}
+
+// registerDrainProbes exposes drain progress through control.
+func (s *Server) registerDrainProbes() {
+	s.control.RegisterDebugProbe(ProbeDraining, func() any {
+		return s.draining.Load()
+	})
+	s.control.RegisterDebugProbe(ProbeDrainRemaining, func() any {
+		if !s.draining.Load() {
+			return int64(0)
+		}
+		return s.conns.current()
+	})
+}
@@ -0,0 +1,180 @@
+// File: server/endpoint.go
+// Package server accepts connections on several addresses at once.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/momentics/hioload-ws/control"
+	"github.com/momentics/hioload-ws/internal/transport"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// Endpoint is an additional address the server accepts connections on:
+// "host:port" for TCP or "unix:/path" for a Unix domain socket, served over
+// TLS when TLS is set. With Hosts, the listener serves several TLS server
+// names, each with its own certificate and optionally its own handler; TLS,
+// if set, is the template of the listener's config and its certificates
+// answer clients naming none of the hosts.
+type Endpoint struct {
+	Addr  string
+	TLS   *tls.Config
+	Hosts []VirtualHost
+}
+
+// WithEndpoints adds endpoints served alongside Config.ListenAddr. They share
+// the handler, middleware, limits and sessions of the server. With endpoints
+// given, an empty ListenAddr opens no other listener.
+func WithEndpoints(eps ...Endpoint) ServerOption {
+	return func(s *Server) {
+		s.endpoints = append(s.endpoints, eps...)
+	}
+}
+
+// openListeners opens cfg.ListenAddr and the endpoints, closing those
+// already open when one fails. Addresses whose sockets were handed over by
+// the Upgrade of a previous process reuse them instead of binding again.
+// With several reactor nodes each TCP address gets one listener per node,
+// the first of which is the one handed over; Unix sockets are served by the
+// first node alone.
+func (s *Server) openListeners(opts []transport.ListenerOption) error {
+	eps := s.endpoints
+	if s.cfg.ListenAddr != "" || len(eps) == 0 {
+		eps = append([]Endpoint{{Addr: s.cfg.ListenAddr}}, eps...)
+	}
+	inherited := inheritedListeners()
+	fail := func(addr string, err error) error {
+		s.closeListeners()
+		for _, ln := range inherited {
+			ln.Close()
+		}
+		return fmt.Errorf("listen %s: %w", addr, err)
+	}
+	for _, ep := range eps {
+		nodes := s.nodes
+		if strings.HasPrefix(ep.Addr, "unix:") {
+			nodes = nodes[:1]
+		}
+		lns := make([]net.Listener, len(nodes))
+		addr := ep.Addr
+		for i := range nodes {
+			ln, ok := inherited[addr]
+			delete(inherited, addr)
+			var err error
+			switch reuse := s.cfg.ReusePort || len(nodes) > 1; {
+			case ok:
+			case s.cfg.AcceptEx > 0 && !reuse:
+				ln, err = transport.ListenAcceptEx(addr, s.cfg.AcceptEx)
+			default:
+				ln, err = transport.Listen(addr, reuse)
+			}
+			if err != nil {
+				for _, ln := range lns[:i] {
+					ln.Close()
+				}
+				return fail(ep.Addr, err)
+			}
+			lns[i] = ln
+			addr = ln.Addr().String() // the other nodes join the port bound, even if picked
+		}
+		if len(nodes) > 1 {
+			ids := make([]int, len(nodes))
+			for i, n := range nodes {
+				ids[i] = n.id
+			}
+			if err := transport.SteerByNUMA(lns, ids); err != nil {
+				control.Logger(control.LogServer).Debug("accepts not steered by NUMA node", "addr", ep.Addr, "error", err)
+			}
+		}
+		s.lnMu.Lock()
+		s.sockets = append(s.sockets, socket{addr: ep.Addr, ln: lns[0]})
+		s.lnMu.Unlock()
+		tlsCfg, epOpts := ep.TLS, opts
+		if len(ep.Hosts) > 0 {
+			hosts, err := newHostTable(ep.Hosts)
+			if err == nil {
+				err = s.addHostHandlers(hosts)
+			}
+			if err != nil {
+				for _, ln := range lns {
+					ln.Close()
+				}
+				return fail(ep.Addr, err)
+			}
+			tlsCfg = hosts.tlsConfig(ep.TLS)
+			epOpts = append(opts[:len(opts):len(opts)], transport.WithHandshakeHook(
+				func(c *protocol.WSConnection, req *protocol.UpgradeRequest, resp http.Header) error {
+					hosts.bindHost(c)
+					return s.handshake(c, &upgrade{ur: req}, resp)
+				}))
+		}
+		for i, n := range nodes {
+			ln := lns[i]
+			if tlsCfg != nil {
+				ln = tls.NewListener(ln, tlsCfg)
+			}
+			nodeOpts := epOpts
+			if len(nodes) > 1 {
+				nodeOpts = append(epOpts[:len(epOpts):len(epOpts)], transport.WithListenerNUMANode(n.id))
+			}
+			l := transport.NewWebSocketListenerOn(ln, n.pool, s.cfg.ChannelCapacity, nodeOpts...)
+			s.lnMu.Lock()
+			s.listeners = append(s.listeners, l)
+			s.listenerNode = append(s.listenerNode, n)
+			s.lnMu.Unlock()
+		}
+	}
+	for _, ln := range inherited {
+		ln.Close() // no longer configured
+	}
+	return nil
+}
+
+// listenerSet returns a snapshot of the listeners and the node serving each.
+func (s *Server) listenerSet() ([]*transport.WebSocketListener, []*reactorNode) {
+	s.lnMu.RLock()
+	defer s.lnMu.RUnlock()
+	return slices.Clone(s.listeners), slices.Clone(s.listenerNode)
+}
+
+// socketSet returns a snapshot of the raw listening sockets.
+func (s *Server) socketSet() []socket {
+	s.lnMu.RLock()
+	defer s.lnMu.RUnlock()
+	return slices.Clone(s.sockets)
+}
+
+// closeListeners closes every listener.
+func (s *Server) closeListeners() error {
+	var errs []error
+	listeners, _ := s.listenerSet()
+	for _, l := range listeners {
+		errs = append(errs, l.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Addrs returns the addresses the server listens on, Config.ListenAddr first.
+func (s *Server) Addrs() []net.Addr {
+	listeners, _ := s.listenerSet()
+	addrs := make([]net.Addr, len(listeners))
+	for i, l := range listeners {
+		addrs[i] = l.Addr()
+	}
+	return addrs
+}
+
+// Ready returns a channel closed once Run accepts connections on every
+// listener, for callers that start Run in a goroutine.
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready
+}
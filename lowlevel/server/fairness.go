@@ -0,0 +1,66 @@
+// File: server/fairness.go
+// Package server shares the reactor fairly between connections.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package server
+
+import (
+	"github.com/momentics/hioload-ws/control"
+	"github.com/momentics/hioload-ws/internal/concurrency"
+)
+
+// Fair-scheduling probe names, exposed under the "debug." prefix. Queue wait
+// is a latency histogram expanded like ProbeHandlerLatency; a growing p99 or
+// deferred count means connections are waiting on busier ones.
+const (
+	ProbeQueueLatency = "latency.queue"    // reactor enqueue to dispatch
+	ProbeFairFlows    = "reactor.flows"    // connections with queued frames
+	ProbeFairDeferred = "reactor.deferred" // turns a connection ended with frames left
+)
+
+// fairPoller is implemented by pollers that schedule per connection, such
+// as adapters.PollerAdapter.
+type fairPoller interface {
+	SetQuantum(n int)
+	FairStats() concurrency.FairStats
+}
+
+// initFairScheduling applies cfg.FairQuantum and registers the starvation
+// probes, summed over the reactors of all nodes.
+func (s *Server) initFairScheduling() {
+	var ps []fairPoller
+	for _, n := range s.nodes {
+		if p, ok := n.poller.(fairPoller); ok {
+			p.SetQuantum(s.cfg.FairQuantum)
+			ps = append(ps, p)
+		}
+	}
+	if len(ps) == 0 {
+		return
+	}
+	s.control.RegisterDebugProbe(ProbeQueueLatency, func() any {
+		if len(ps) == 1 {
+			return ps[0].FairStats().QueueWait
+		}
+		h := control.NewLatencyHistogram()
+		for _, p := range ps {
+			h.Merge(p.FairStats().QueueWait)
+		}
+		return h
+	})
+	s.control.RegisterDebugProbe(ProbeFairFlows, func() any {
+		var n int
+		for _, p := range ps {
+			n += p.FairStats().Flows
+		}
+		return n
+	})
+	s.control.RegisterDebugProbe(ProbeFairDeferred, func() any {
+		var n uint64
+		for _, p := range ps {
+			n += p.FairStats().Deferred
+		}
+		return n
+	})
+}
@@ -0,0 +1,66 @@
+// File: server/features.go
+// Package server applies runtime feature toggles to new connections.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/momentics/hioload-ws/control"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// initFeatures seeds the toggles from Config and keeps them in sync with
+// the control.CfgFeature* keys.
+func (s *Server) initFeatures() {
+	f := control.Features{
+		Compression:      s.cfg.Compression,
+		KeepAlive:        s.cfg.KeepAlive,
+		StrictValidation: s.cfg.StrictValidation,
+		RateLimit:        true,
+	}
+	s.features.Store(&f)
+	s.control.OnReload(s.reloadFeatures)
+}
+
+// reloadFeatures applies feature keys from the control config.
+func (s *Server) reloadFeatures() {
+	f := s.Features().WithConfig(s.control.GetConfig())
+	s.features.Store(&f)
+}
+
+// Features returns the toggles currently applied to new connections.
+func (s *Server) Features() control.Features {
+	return *s.features.Load()
+}
+
+// negotiateFeatures configures conn during its handshake: permessage-deflate
+// if enabled and offered, and strict validation.
+func (s *Server) negotiateFeatures(conn *protocol.WSConnection, req *upgrade, resp http.Header) {
+	f := s.features.Load()
+	if f.Compression {
+		if ext := req.negotiate(protocol.HeaderSecWebSocketExt, protocol.NegotiateDeflate); ext != "" {
+			conn.SetCompression(true)
+			conn.SetExtensions(ext)
+			resp.Set(protocol.HeaderSecWebSocketExt, ext)
+		}
+	}
+	conn.SetStrict(f.StrictValidation)
+}
+
+// startKeepAlive pings conn while a keepalive interval is set. A reloaded
+// interval applies from the next ping and turning keepalive off stops it;
+// connections opened while it was off are not pinged.
+func (s *Server) startKeepAlive(conn *protocol.WSConnection) {
+	if s.keepAliveInterval() > 0 {
+		go conn.KeepAliveFunc(s.keepAliveInterval)
+	}
+}
+
+// keepAliveInterval returns the current keepalive interval (0 = off).
+func (s *Server) keepAliveInterval() time.Duration {
+	return s.features.Load().KeepAlive
+}
@@ -0,0 +1,197 @@
+// File: server/framedump.go
+// Package server dumps the frames of selected connections through control.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/momentics/hioload-ws/control"
+	"github.com/momentics/hioload-ws/framedump"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// Control config keys of frame dumps, hot-reloadable. A connection is dumped
+// to a file of its own while its session ID or request path is selected;
+// lists are given as lists or comma-separated strings. The other keys apply
+// to dumps started afterwards. See package framedump for the formats.
+const (
+	CfgDumpConnections = "dump.connections" // session IDs to dump
+	CfgDumpRoutes      = "dump.routes"      // request paths to dump
+	CfgDumpDir         = "dump.dir"         // directory of the dump files, os.TempDir() if unset
+	CfgDumpFormat      = "dump.format"      // "jsonl" (default) or "pcapng"
+	CfgDumpPayloads    = "dump.payloads"    // include payloads, not only frame headers
+	CfgDumpMaxPayload  = "dump.max_payload" // cut included payloads to this many bytes (0 = whole)
+)
+
+// ProbeDumps lists the files of the dumps in progress.
+const ProbeDumps = "dump.files"
+
+// dumpState holds the dump settings and the connections being dumped.
+type dumpState struct {
+	cfg  atomic.Pointer[dumpSettings]
+	keys atomic.Pointer[string] // CfgDump* values last applied by reloadDumps
+
+	mu   sync.Mutex
+	live map[*protocol.WSConnection]*liveDump
+}
+
+// dumpSettings is the parsed form of the CfgDump* keys.
+type dumpSettings struct {
+	ids, routes map[string]bool
+	dir         string
+	format      framedump.Format
+	opts        framedump.Options
+}
+
+// liveDump is the dump of one connection.
+type liveDump struct {
+	w    *framedump.Writer
+	file string
+}
+
+func (s *Server) initDumps() {
+	s.control.RegisterDebugProbe(ProbeDumps, func() any {
+		s.dump.mu.Lock()
+		defer s.dump.mu.Unlock()
+		files := make([]string, 0, len(s.dump.live))
+		for _, d := range s.dump.live {
+			files = append(files, d.file)
+		}
+		sort.Strings(files)
+		return files
+	})
+	s.control.OnReload(s.reloadDumps)
+}
+
+// reloadDumps applies the CfgDump* keys to the live connections. Invalid
+// values are logged and leave the previous setting in place.
+func (s *Server) reloadDumps() {
+	cfg := s.control.GetConfig()
+	// Reload hooks run for every SetConfig, not only for these keys.
+	keys := fmt.Sprint(cfg[CfgDumpConnections], cfg[CfgDumpRoutes], cfg[CfgDumpDir],
+		cfg[CfgDumpFormat], cfg[CfgDumpPayloads], cfg[CfgDumpMaxPayload])
+	if prev := s.dump.keys.Swap(&keys); prev != nil && *prev == keys {
+		return
+	}
+	set := dumpSettings{
+		ids:    parseTraceIDs(cfg[CfgDumpConnections]),
+		routes: parseTraceIDs(cfg[CfgDumpRoutes]),
+	}
+	if prev := s.dump.cfg.Load(); prev != nil {
+		set.dir, set.format, set.opts = prev.dir, prev.format, prev.opts
+	}
+	var errs []error
+	if v, ok := cfg[CfgDumpDir]; ok {
+		errs = append(errs, setString(&set.dir, v))
+	}
+	if v, ok := cfg[CfgDumpFormat]; ok {
+		var name string
+		err := setString(&name, v)
+		if err == nil {
+			set.format, err = framedump.ParseFormat(name)
+		}
+		errs = append(errs, err)
+	}
+	if v, ok := cfg[CfgDumpPayloads]; ok {
+		errs = append(errs, setBool(&set.opts.Payloads, v))
+	}
+	if v, ok := cfg[CfgDumpMaxPayload]; ok {
+		errs = append(errs, setInt(&set.opts.MaxPayload, v))
+	}
+	for _, err := range errs {
+		if err != nil {
+			control.Logger(control.LogServer).Warn("frame dump setting rejected", "error", err)
+		}
+	}
+	s.dump.cfg.Store(&set)
+
+	for _, conn := range s.conns.snapshot() {
+		s.applyDump(conn)
+	}
+}
+
+// applyDump starts or stops dumping conn for its current session and path.
+func (s *Server) applyDump(conn *protocol.WSConnection) {
+	set := s.dump.cfg.Load()
+	want := false
+	if set != nil {
+		sess := conn.Session()
+		want = set.routes[conn.Path()] || sess != nil && set.ids[sess.ID()]
+	}
+	s.dump.mu.Lock()
+	defer s.dump.mu.Unlock()
+	d, on := s.dump.live[conn]
+	switch {
+	case want && !on:
+		d, err := openDump(conn, set)
+		if err != nil {
+			control.Logger(control.LogServer).Warn("connection not dumped", "path", conn.Path(), "error", err)
+			return
+		}
+		if s.dump.live == nil {
+			s.dump.live = make(map[*protocol.WSConnection]*liveDump)
+		}
+		s.dump.live[conn] = d
+		d.w.Attach(conn)
+		go s.endDump(conn, d)
+	case !want && on:
+		conn.SetFrameObserver(nil)
+		delete(s.dump.live, conn)
+		d.w.Close()
+	}
+}
+
+// endDump closes the dump d of conn once conn is closed, unless it was
+// stopped before.
+func (s *Server) endDump(conn *protocol.WSConnection, d *liveDump) {
+	<-conn.Done()
+	s.dump.mu.Lock()
+	defer s.dump.mu.Unlock()
+	if s.dump.live[conn] == d {
+		delete(s.dump.live, conn)
+		d.w.Close()
+	}
+}
+
+// openDump creates the dump file of conn, named after its session.
+func openDump(conn *protocol.WSConnection, set *dumpSettings) (*liveDump, error) {
+	dir := set.dir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	id := "conn"
+	if sess := conn.Session(); sess != nil {
+		id = sess.ID()
+	}
+	name := fmt.Sprintf("%s-%d.%s", fileSafe(id), time.Now().UnixNano(), set.format)
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+	w, err := framedump.NewWriter(f, set.format, set.opts)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &liveDump{w: w, file: f.Name()}, nil
+}
+
+// fileSafe replaces the characters of s that do not belong in a file name.
+func fileSafe(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,123 @@
+// File: server/health.go
+// Package server registers reactor, accept-loop and pool health checks.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/momentics/hioload-ws/control"
+)
+
+// Health check names registered by NewServer.
+const (
+	HealthReactor  = "reactor"  // live+ready: the reactor processes an event
+	HealthAccept   = "accept"   // ready: the accept loop runs and no handshake is stalled
+	HealthPool     = "pool"     // ready: pooled buffers in use below Config.HealthMaxBuffersInUse
+	HealthShutdown = "shutdown" // ready: the server is neither shutting down nor draining
+)
+
+// DefaultAcceptStall is how long one handshake may block the accept loop
+// before readiness fails.
+const DefaultAcceptStall = 10 * time.Second
+
+// reactorPing travels through the reactor to prove it dispatches events.
+type reactorPing struct {
+	done chan struct{}
+}
+
+// Data returns the ping itself for the handler chain to recognise.
+func (p reactorPing) Data() any {
+	return p
+}
+
+// Health returns the server's check registry, for mounting its LiveHandler
+// and ReadyHandler or registering application checks.
+func (s *Server) Health() *control.HealthRegistry {
+	return s.health
+}
+
+func (s *Server) registerHealthChecks() {
+	s.health.Register(HealthReactor, control.HealthLive|control.HealthReady, s.checkReactor)
+	s.health.Register(HealthAccept, control.HealthReady, s.checkAccept)
+	s.health.Register(HealthPool, control.HealthReady, s.checkPool)
+	s.health.Register(HealthShutdown, control.HealthReady, s.checkShutdown)
+}
+
+// checkReactor round-trips a ping through the reactor of every node.
+func (s *Server) checkReactor(ctx context.Context) error {
+	if !s.running.Load() {
+		return errors.New("reactor not running")
+	}
+	if s.checkShutdown(ctx) != nil {
+		return errors.New("reactor stopping")
+	}
+	pings := make([]reactorPing, len(s.nodes))
+	for i, n := range s.nodes {
+		pings[i] = reactorPing{done: make(chan struct{})}
+		if !n.poller.Push(pings[i]) {
+			return s.nodeErr(n, errors.New("reactor queue full"))
+		}
+	}
+	for i, ping := range pings {
+		select {
+		case <-ping.done:
+		case <-ctx.Done():
+			return s.nodeErr(s.nodes[i], fmt.Errorf("reactor unresponsive: %w", ctx.Err()))
+		}
+	}
+	return nil
+}
+
+// nodeErr names n's NUMA node in err when there are several.
+func (s *Server) nodeErr(n *reactorNode, err error) error {
+	if len(s.nodes) == 1 {
+		return err
+	}
+	return fmt.Errorf("node %d: %w", n.id, err)
+}
+
+func (s *Server) checkAccept(context.Context) error {
+	listeners, _ := s.listenerSet()
+	if n := int(s.accepting.Load()); n < len(listeners) {
+		return fmt.Errorf("%d of %d accept loops not running", len(listeners)-n, len(listeners))
+	}
+	for _, l := range listeners {
+		if d := l.HandshakeInFlight(); d > DefaultAcceptStall {
+			return fmt.Errorf("handshake on %s in progress for %v", l.Addr(), d.Round(time.Millisecond))
+		}
+	}
+	return nil
+}
+
+func (s *Server) checkPool(context.Context) error {
+	max := s.cfg.HealthMaxBuffersInUse
+	if max <= 0 {
+		return nil
+	}
+	var n int64
+	for _, p := range s.uniquePools() {
+		n += p.Stats().InUse
+	}
+	if n > max {
+		return fmt.Errorf("%d buffers in use (limit %d)", n, max)
+	}
+	return nil
+}
+
+func (s *Server) checkShutdown(context.Context) error {
+	select {
+	case <-s.shutdownCh:
+		return errors.New("shutting down")
+	default:
+	}
+	if s.draining.Load() {
+		return errors.New("draining")
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+// File: server/idle.go
+// Package server closes connections idle for longer than Config.IdleTimeout.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/control"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// ProbeIdleClosed counts the connections closed by Config.IdleTimeout,
+// exposed under the "debug." prefix.
+const ProbeIdleClosed = "idle.closed"
+
+// idleReason is the reason of the 1001 Going Away sent to idle connections.
+const idleReason = "idle timeout"
+
+// idleSlots is the number of slots of the idle wheel; a connection is closed
+// at most IdleTimeout/idleSlots after it went idle.
+const idleSlots = 64
+
+// idleWheel is a hashed timer wheel shared by all connections of a server.
+// A single ticker on the server's Clock, running while connections are
+// registered, advances it one slot per IdleTimeout/idleSlots passed; ticks
+// the ticker dropped are caught up. Each connection sits in the slot of its
+// deadline; frames do not touch the wheel. When the slot comes round the
+// deadline is recomputed from the last frame received, and the connection
+// either moves to its new slot or is closed, so a busy connection costs one
+// check per timeout.
+type idleWheel struct {
+	clock   api.Clock
+	timeout time.Duration
+	tick    time.Duration
+	pongs   bool          // pongs defer the deadline too
+	stop    chan struct{} // closed on shutdown
+
+	mu      sync.Mutex
+	slots   [idleSlots]map[*protocol.WSConnection]time.Time // connection to its registration time
+	where   map[*protocol.WSConnection]int                  // slot of each connection
+	pos     int                                             // slot reached last
+	at      time.Time                                       // when pos was reached
+	running bool                                            // the ticking goroutine is active
+
+	closed *control.Counter // see ProbeIdleClosed
+}
+
+func newIdleWheel(clock api.Clock, timeout time.Duration, pongs bool, stop chan struct{}, closed *control.Counter) *idleWheel {
+	w := &idleWheel{
+		clock:   clock,
+		timeout: timeout,
+		tick:    max(timeout/idleSlots, time.Millisecond),
+		pongs:   pongs,
+		stop:    stop,
+		where:   make(map[*protocol.WSConnection]int),
+		closed:  closed,
+	}
+	for i := range w.slots {
+		w.slots[i] = make(map[*protocol.WSConnection]time.Time)
+	}
+	return w
+}
+
+// add registers conn, idle from now on, starting the ticker if needed.
+func (w *idleWheel) add(conn *protocol.WSConnection) {
+	now := w.clock.Now()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.running {
+		w.running = true
+		w.at = now
+		go w.run()
+	}
+	w.place(conn, now, now.Add(w.timeout))
+}
+
+// remove unregisters conn, e.g. once it closed.
+func (w *idleWheel) remove(conn *protocol.WSConnection) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if i, ok := w.where[conn]; ok {
+		delete(w.slots[i], conn)
+		delete(w.where, conn)
+	}
+}
+
+// place puts conn, registered at since, in the first slot reached at or
+// after deadline. The caller holds mu.
+func (w *idleWheel) place(conn *protocol.WSConnection, since, deadline time.Time) {
+	ahead := int((deadline.Sub(w.at) + w.tick - 1) / w.tick)
+	ahead = min(max(ahead, 1), idleSlots)
+	i := (w.pos + ahead) % idleSlots
+	w.slots[i][conn] = since
+	w.where[conn] = i
+}
+
+// run advances the wheel until no connection is left or the server shuts
+// down.
+func (w *idleWheel) run() {
+	tick := w.clock.NewTicker(w.tick)
+	defer tick.Stop()
+	for {
+		select {
+		case <-w.stop:
+			w.mu.Lock()
+			w.running = false
+			w.mu.Unlock()
+			return
+		case <-tick.C():
+		}
+		if !w.advance() {
+			return
+		}
+	}
+}
+
+// advance moves through the slots reached by now, closes their connections
+// that stayed idle for the timeout and reschedules the others. It reports
+// false, stopping run, once the wheel is empty.
+func (w *idleWheel) advance() bool {
+	now := w.clock.Now()
+	var idle []*protocol.WSConnection
+	w.mu.Lock()
+	for n := 0; n < idleSlots && !now.Before(w.at.Add(w.tick)); n++ {
+		w.pos = (w.pos + 1) % idleSlots
+		w.at = w.at.Add(w.tick)
+		idle = w.sweep(now, idle)
+	}
+	if now.Sub(w.at) >= w.tick {
+		w.at = now // a whole turn behind: every slot was swept
+	}
+	if len(w.where) == 0 {
+		w.running = false
+	}
+	running := w.running
+	w.mu.Unlock()
+
+	for _, conn := range idle {
+		w.closed.Inc()
+		conn.Trace("idle timeout")
+		conn.CloseWithCode(protocol.CloseGoingAway, idleReason)
+	}
+	return running
+}
+
+// sweep empties slot pos, appending its connections idle at now to idle and
+// moving the others to the slots of their deadlines. The caller holds mu.
+func (w *idleWheel) sweep(now time.Time, idle []*protocol.WSConnection) []*protocol.WSConnection {
+	due := w.slots[w.pos]
+	w.slots[w.pos] = make(map[*protocol.WSConnection]time.Time, len(due))
+	for conn, since := range due {
+		deadline := w.lastActive(conn, since).Add(w.timeout)
+		if now.Before(deadline) {
+			w.place(conn, since, deadline)
+			continue
+		}
+		delete(w.where, conn)
+		idle = append(idle, conn)
+	}
+	return idle
+}
+
+// lastActive returns when conn, registered at since, last received a frame
+// counting as activity.
+func (w *idleWheel) lastActive(conn *protocol.WSConnection, since time.Time) time.Time {
+	last := since
+	if t := conn.LastActivity(); t.After(last) {
+		last = t
+	}
+	if w.pongs {
+		if t := conn.LastPong(); t.After(last) {
+			last = t
+		}
+	}
+	return last
+}
+
+// watchIdle registers conn with the idle wheel, if Config.IdleTimeout is set.
+func (s *Server) watchIdle(conn *protocol.WSConnection) {
+	if s.idle != nil {
+		s.idle.add(conn)
+	}
+}
+
+// unwatchIdle unregisters conn from the idle wheel.
+func (s *Server) unwatchIdle(conn *protocol.WSConnection) {
+	if s.idle != nil {
+		s.idle.remove(conn)
+	}
+}
+
+// registerIdleProbes exposes ProbeIdleClosed through control.
+func (s *Server) registerIdleProbes() {
+	s.control.RegisterDebugProbe(ProbeIdleClosed, func() any { return &s.idleClosed })
+}
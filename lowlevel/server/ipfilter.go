@@ -0,0 +1,84 @@
+// File: server/ipfilter.go
+// Package server wires the CIDR allow/deny lists into the listeners.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package server
+
+import (
+	"strings"
+
+	"github.com/momentics/hioload-ws/control"
+)
+
+// Control.SetConfig and config file keys for Config.IPAllow and
+// Config.IPDeny, as lists or comma-separated strings.
+const (
+	CfgIPAllow = "ipfilter.allow"
+	CfgIPDeny  = "ipfilter.deny"
+)
+
+// initIPFilter exposes the rule hit counters as debug probes and subscribes
+// to control reloads. The filter itself is built with the listeners.
+func (s *Server) initIPFilter() {
+	s.control.RegisterDebugProbe("ipfilter.hits", func() any {
+		return s.ipFilter.Hits()
+	})
+	s.control.RegisterDebugProbe("ipfilter.refused", func() any {
+		return s.ipFilter.Refused()
+	})
+	s.control.OnReload(s.reloadIPFilter)
+}
+
+// reloadIPFilter applies the "ipfilter.*" keys from the control config when
+// they changed. An invalid list leaves the current rules in place.
+func (s *Server) reloadIPFilter() {
+	cfg := s.control.GetConfig()
+	allow, okAllow := stringList(cfg[CfgIPAllow])
+	deny, okDeny := stringList(cfg[CfgIPDeny])
+	if !okAllow && !okDeny {
+		return
+	}
+	if !okAllow {
+		allow = s.cfg.IPAllow
+	}
+	if !okDeny {
+		deny = s.cfg.IPDeny
+	}
+	// Reload hooks run for every SetConfig, not only for these keys.
+	lists := strings.Join(allow, ",") + "|" + strings.Join(deny, ",")
+	if prev := s.ipLists.Swap(&lists); prev != nil && *prev == lists {
+		return
+	}
+	if err := s.ipFilter.SetRules(allow, deny); err != nil {
+		control.Logger(control.LogServer).Warn("ip filter reload rejected", "error", err)
+	}
+}
+
+// stringList converts a config value holding a list or a comma-separated
+// string.
+func stringList(v any) ([]string, bool) {
+	switch t := v.(type) {
+	case string:
+		var out []string
+		for _, s := range strings.Split(t, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+		return out, true
+	case []string:
+		return t, true
+	case []any:
+		out := make([]string, 0, len(t))
+		for _, item := range t {
+			s, ok := item.(string)
+			if !ok {
+				return nil, false
+			}
+			out = append(out, s)
+		}
+		return out, true
+	}
+	return nil, false
+}
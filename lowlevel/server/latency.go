@@ -0,0 +1,70 @@
+// File: server/latency.go
+// Package server records handler, send and handshake latency histograms.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package server
+
+import (
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/control"
+)
+
+// Latency probe names; Stats expands each into ".count" and ".p50".."p999"
+// under the "debug." prefix.
+const (
+	ProbeHandlerLatency   = "latency.handler"
+	ProbeSendLatency      = "latency.send"
+	ProbeHandshakeLatency = "latency.handshake"
+)
+
+// latencyStats holds the server's latency histograms.
+type latencyStats struct {
+	handler   *control.LatencyHistogram // Handle call per data frame
+	send      *control.LatencyHistogram // transport write per queued frame
+	handshake *control.LatencyHistogram // TCP accept to 101 response
+}
+
+func newLatencyStats() latencyStats {
+	return latencyStats{
+		handler:   control.NewLatencyHistogram(),
+		send:      control.NewLatencyHistogram(),
+		handshake: control.NewLatencyHistogram(),
+	}
+}
+
+// registerLatencyProbes exposes the histograms through control.
+func (s *Server) registerLatencyProbes() {
+	s.control.RegisterDebugProbe(ProbeHandlerLatency, func() any { return s.latency.handler })
+	s.control.RegisterDebugProbe(ProbeSendLatency, func() any { return s.latency.send })
+	s.control.RegisterDebugProbe(ProbeHandshakeLatency, func() any { return s.latency.handshake })
+}
+
+// timedHandler records the duration of every data-frame Handle call;
+// lifecycle events pass through untimed and reactor health pings stop here.
+// With a watch, every call is visible to the slow-handler watchdog.
+type timedHandler struct {
+	next  api.Handler
+	hist  *control.LatencyHistogram
+	watch *dispatchWatch // nil unless Config.SlowHandler is set
+}
+
+func (h timedHandler) Handle(data any) error {
+	if ping, ok := data.(reactorPing); ok {
+		close(ping.done) // health probe, not for the application
+		return nil
+	}
+	if h.watch != nil {
+		h.watch.begin(data)
+		defer h.watch.end()
+	}
+	if _, ok := data.(bufEventWithConn); !ok {
+		return h.next.Handle(data)
+	}
+	start := time.Now()
+	err := h.next.Handle(data)
+	h.hist.Since(start)
+	return err
+}
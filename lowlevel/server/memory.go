@@ -0,0 +1,79 @@
+// File: server/memory.go
+// Package server reports the memory held by connections.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package server
+
+import (
+	"sort"
+
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// Memory probe names, exposed under the "debug." prefix. The totals are
+// bytes summed over the admitted connections, see protocol.MemUsage.
+const (
+	ProbeMemTotal      = "memory.total"       // all bytes held
+	ProbeMemReadBuffer = "memory.read_buffer" // partial frames buffered by decoders
+	ProbeMemOutbox     = "memory.outbox"      // frames queued for sending
+	ProbeMemInFlight   = "memory.in_flight"   // pooled receive buffers not yet released by handlers
+	ProbeMemTop        = "memory.top"         // []ConnMemory of the DefaultMemoryTop heaviest connections
+)
+
+// DefaultMemoryTop is the number of connections listed by ProbeMemTop.
+const DefaultMemoryTop = 10
+
+// ConnMemory is the memory held by one connection.
+type ConnMemory struct {
+	ID         string `json:"id,omitempty"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	Path       string `json:"path,omitempty"`
+	protocol.MemUsage
+	Total int64 `json:"total"`
+}
+
+// MemoryUsage returns the memory held by all admitted connections and the n
+// connections holding the most, heaviest first.
+func (s *Server) MemoryUsage(n int) (protocol.MemUsage, []ConnMemory) {
+	var sum protocol.MemUsage
+	all := make([]ConnMemory, 0, n)
+	for _, conn := range s.conns.snapshot() {
+		m := conn.MemUsage()
+		sum.ReadBuffer += m.ReadBuffer
+		sum.Outbox += m.Outbox
+		sum.InFlight += m.InFlight
+		if n <= 0 {
+			continue
+		}
+		cm := ConnMemory{Path: conn.Path(), MemUsage: m, Total: m.Total()}
+		if sess := conn.Session(); sess != nil {
+			cm.ID = sess.ID()
+		}
+		if ra := conn.RemoteAddr(); ra != nil {
+			cm.RemoteAddr = ra.String()
+		}
+		all = append(all, cm)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Total > all[j].Total })
+	if len(all) > n {
+		all = all[:n]
+	}
+	return sum, all
+}
+
+// registerMemoryProbes exposes connection memory through control.
+func (s *Server) registerMemoryProbes() {
+	total := func() protocol.MemUsage {
+		sum, _ := s.MemoryUsage(0)
+		return sum
+	}
+	s.control.RegisterDebugProbe(ProbeMemTotal, func() any { return total().Total() })
+	s.control.RegisterDebugProbe(ProbeMemReadBuffer, func() any { return total().ReadBuffer })
+	s.control.RegisterDebugProbe(ProbeMemOutbox, func() any { return total().Outbox })
+	s.control.RegisterDebugProbe(ProbeMemInFlight, func() any { return total().InFlight })
+	s.control.RegisterDebugProbe(ProbeMemTop, func() any {
+		_, top := s.MemoryUsage(DefaultMemoryTop)
+		return top
+	})
+}
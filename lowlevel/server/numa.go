@@ -0,0 +1,98 @@
+// File: server/numa.go
+// Package server runs one acceptor per NUMA node.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package server
+
+import (
+	"runtime"
+
+	"github.com/momentics/hioload-ws/adapters"
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/control"
+	"github.com/momentics/hioload-ws/internal/concurrency"
+	"github.com/momentics/hioload-ws/pool"
+)
+
+// reactorNode is the buffer pool and reactor serving the connections
+// accepted on one NUMA node.
+type reactorNode struct {
+	id     int // NUMA node, -1 = unpinned
+	pool   api.BufferPool
+	poller api.Poller
+}
+
+// WithNUMAAcceptors sets Config.NUMAAcceptors for the given NUMA nodes, or
+// for every node of the machine if none are given.
+func WithNUMAAcceptors(nodes ...int) ServerOption {
+	return func(s *Server) {
+		s.cfg.NUMAAcceptors = true
+		s.acceptorNodes = append([]int(nil), nodes...)
+	}
+}
+
+// newNodes creates the reactor nodes: one per acceptor node with
+// Config.NUMAAcceptors, otherwise a single one on Config.NUMANode.
+func (s *Server) newNodes(mgr *pool.BufferPoolManager) {
+	ids := []int{s.cfg.NUMANode}
+	if s.cfg.NUMAAcceptors {
+		ids = s.acceptorNodes
+		if len(ids) == 0 {
+			ids = make([]int, concurrency.NUMANodes())
+			for i := range ids {
+				ids[i] = i
+			}
+		}
+	}
+	s.nodes = make([]*reactorNode, len(ids))
+	for i, id := range ids {
+		s.nodes[i] = &reactorNode{
+			id:     id,
+			pool:   mgr.GetPool(s.cfg.IOBufferSize, id),
+			poller: adapters.NewPollerAdapter(s.cfg.BatchSize, s.cfg.ReactorRing),
+		}
+	}
+	s.pool = s.nodes[0].pool
+	s.poller = s.nodes[0].poller
+}
+
+// pollLoop runs the reactor of n until shutdown, on an OS thread bound to
+// n's NUMA node when the server has one acceptor per node.
+func (s *Server) pollLoop(n *reactorNode) {
+	if len(s.nodes) > 1 && n.id >= 0 {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		aff := adapters.NewAffinityAdapter()
+		if err := aff.Pin(-1, n.id); err != nil {
+			control.Logger(control.LogServer).Warn("reactor not pinned", "node", n.id, "error", err)
+		} else {
+			defer aff.Unpin()
+		}
+	}
+	for {
+		select {
+		case <-s.shutdownCh:
+			return
+		default:
+			// Poll up to BatchSize events
+			n.poller.Poll(s.batchSize())
+		}
+	}
+}
+
+// uniquePools returns the distinct buffer pools of the nodes; nodes beyond
+// the machine's NUMA nodes share a pool.
+func (s *Server) uniquePools() []api.BufferPool {
+	var pools []api.BufferPool
+	for _, n := range s.nodes {
+		dup := false
+		for _, p := range pools {
+			dup = dup || p == n.pool
+		}
+		if !dup {
+			pools = append(pools, n.pool)
+		}
+	}
+	return pools
+}
@@ -5,11 +5,62 @@
 
 package server
 
-import "github.com/momentics/hioload-ws/api"
+import (
+	"net/http"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/protocol"
+	"github.com/momentics/hioload-ws/session"
+)
 
 // ServerOption customizes server initialization.
 type ServerOption func(*Server)
 
+// HandshakeCheck inspects an upgrade request before the 101 response. A
+// returned *protocol.HandshakeRejection answers with its HTTP status; any
+// other error closes the connection without a response.
+type HandshakeCheck func(req *http.Request) error
+
+// WithHandshakeCheck adds checks run, in order, on every upgrade request
+// after the handshake rate limit, e.g. to answer 404 for unrouted paths.
+// Checks can store attributes for handlers, such as authenticated claims,
+// on the session of HandshakeSession.
+func WithHandshakeCheck(checks ...HandshakeCheck) ServerOption {
+	return func(s *Server) {
+		s.checks = append(s.checks, checks...)
+	}
+}
+
+// handshakeSessionKey carries the session of an upgrade request.
+type handshakeSessionKey struct{}
+
+// HandshakeSession returns the session bound to the connection req is
+// upgrading, or nil outside a HandshakeCheck. A fresh session is closed
+// again if the upgrade is rejected.
+func HandshakeSession(req *http.Request) api.Session {
+	sess, _ := req.Context().Value(handshakeSessionKey{}).(api.Session)
+	return sess
+}
+
+// handshakeHostKey carries the virtual host of an upgrade request.
+type handshakeHostKey struct{}
+
+// HandshakeVirtualHost returns the name of the VirtualHost the connection
+// req is upgrading was matched to by its TLS server name, or "" if none or
+// outside a HandshakeCheck.
+func HandshakeVirtualHost(req *http.Request) string {
+	host, _ := req.Context().Value(handshakeHostKey{}).(string)
+	return host
+}
+
+// WithOutboxLimit bounds each connection's send queue and selects what
+// happens to writes once a slow peer lets it fill up.
+func WithOutboxLimit(l protocol.OutboxLimit) ServerOption {
+	return func(s *Server) {
+		s.cfg.Outbox = l
+	}
+}
+
 // WithMiddleware attaches middleware in FIFO order.
 func WithMiddleware(mw ...Middleware) ServerOption {
 	return func(s *Server) {
@@ -37,3 +88,18 @@ func WithExecutorWorkers(n int) ServerOption {
 		s.cfg.ExecutorWorkers = n
 	}
 }
+
+// WithSubprotocols lists the Sec-WebSocket-Protocol values the server accepts;
+// the first one offered by the client is selected.
+func WithSubprotocols(protos ...string) ServerOption {
+	return func(s *Server) {
+		s.cfg.Subprotocols = append([]string(nil), protos...)
+	}
+}
+
+// WithSessionManager shares an externally owned SessionManager with the server.
+func WithSessionManager(m *session.SessionManager) ServerOption {
+	return func(s *Server) {
+		s.sessions = m
+	}
+}
@@ -0,0 +1,112 @@
+// File: server/panic.go
+// Package server isolates handler panics from the reactor.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package server
+
+import (
+	"fmt"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/control"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// ProbeHandlerPanics counts the panics recovered from handlers by the reactor
+// and executor, exposed under the "debug." prefix.
+const ProbeHandlerPanics = "handler.panics"
+
+// PanicHook is called with the connection whose event a handler panicked on,
+// nil if the event carried none, the recovered value and the stack at the
+// panic. The connection has been closed with 1011 when the hook runs.
+type PanicHook func(conn *protocol.WSConnection, recovered any, stack []byte)
+
+// panicPoller is implemented by pollers that recover handler panics, such as
+// adapters.PollerAdapter.
+type panicPoller interface {
+	SetPanicHandler(fn func(data any, recovered any, stack []byte))
+	Panics() uint64
+}
+
+// panicExecutor is implemented by executors that recover task panics, such
+// as adapters.ExecutorAdapter.
+type panicExecutor interface {
+	SetPanicHandler(fn func(recovered any, stack []byte))
+	Panics() uint64
+}
+
+// OnPanic registers fn to be called, on the reactor goroutine, for every
+// panic recovered from the handler passed to Run. A panicking handler never
+// stops the reactor: the panic is logged, counted in ProbeHandlerPanics and
+// its connection is closed with 1011, whether or not hooks are registered.
+func (s *Server) OnPanic(fn PanicHook) {
+	s.panicMu.Lock()
+	s.panicHooks = append(s.panicHooks, fn)
+	s.panicMu.Unlock()
+}
+
+// initPanicRecovery routes the panics recovered by the poller and executor to
+// handlePanic and registers ProbeHandlerPanics.
+func (s *Server) initPanicRecovery() {
+	var ps []panicPoller
+	for _, n := range s.nodes {
+		if p, ok := n.poller.(panicPoller); ok {
+			p.SetPanicHandler(func(data any, recovered any, stack []byte) {
+				s.handlePanic(eventConn(data), recovered, stack)
+			})
+			ps = append(ps, p)
+		}
+	}
+	e, eok := s.executor.(panicExecutor)
+	if eok {
+		e.SetPanicHandler(func(recovered any, stack []byte) {
+			s.handlePanic(nil, recovered, stack)
+		})
+	}
+	s.control.RegisterDebugProbe(ProbeHandlerPanics, func() any {
+		var n uint64
+		for _, p := range ps {
+			n += p.Panics()
+		}
+		if eok {
+			n += e.Panics()
+		}
+		return n
+	})
+}
+
+// handlePanic logs a recovered panic, closes its connection and runs the
+// OnPanic hooks.
+func (s *Server) handlePanic(conn *protocol.WSConnection, recovered any, stack []byte) {
+	args := []any{"panic", fmt.Sprint(recovered), "stack", string(stack)}
+	if conn != nil {
+		if sess := conn.Session(); sess != nil {
+			args = append(args, "session", sess.ID())
+		}
+		conn.CloseWithCode(protocol.CloseInternalServerErr, "internal error")
+	}
+	control.Logger(control.LogServer).Error("handler panic", args...)
+
+	s.panicMu.Lock()
+	hooks := s.panicHooks
+	s.panicMu.Unlock()
+	for _, fn := range hooks {
+		fn(conn, recovered, stack)
+	}
+}
+
+// eventConn returns the connection of a reactor event's data, or nil.
+func eventConn(data any) *protocol.WSConnection {
+	var c any
+	switch evt := data.(type) {
+	case bufEventWithConn:
+		return evt.conn
+	case api.OpenEvent:
+		c = evt.Conn
+	case api.CloseEvent:
+		c = evt.Conn
+	}
+	conn, _ := c.(*protocol.WSConnection)
+	return conn
+}
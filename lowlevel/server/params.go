@@ -0,0 +1,93 @@
+// File: server/params.go
+// Package server applies hot-reloaded reactor, timeout and capacity parameters.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package server
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// liveParams holds the Config fields a running server re-reads, so that
+// Control.SetConfig can change them; Config itself is not written after
+// NewServer.
+type liveParams struct {
+	batchSize      atomic.Int64
+	maxConnections atomic.Int64
+	readTimeout    atomic.Int64 // time.Duration
+	writeTimeout   atomic.Int64 // time.Duration
+}
+
+// initParams seeds the live parameters from Config and keeps them in sync
+// with the CfgBatchSize, CfgMaxConnections, CfgReadTimeout and
+// CfgWriteTimeout keys.
+func (s *Server) initParams() {
+	s.params.batchSize.Store(int64(s.cfg.BatchSize))
+	s.params.maxConnections.Store(int64(s.cfg.MaxConnections))
+	s.params.readTimeout.Store(int64(s.cfg.ReadTimeout))
+	s.params.writeTimeout.Store(int64(s.cfg.WriteTimeout))
+	s.control.OnReload(s.reloadParams)
+}
+
+// reloadParams applies the parameter keys from the control config; invalid
+// values leave a parameter unchanged. New read and write timeouts apply to
+// established connections at once, restarting a pending read or write. A
+// higher MaxConnections admits queued connections at once; a lower one closes
+// none, it only holds off new connections until enough have left.
+func (s *Server) reloadParams() {
+	cfg := s.control.GetConfig()
+	if v, ok := cfg[CfgBatchSize]; ok {
+		var n int
+		if setInt(&n, v) == nil && n > 0 {
+			s.params.batchSize.Store(int64(n))
+		}
+	}
+	if v, ok := cfg[CfgMaxConnections]; ok {
+		var n int
+		if setInt(&n, v) == nil && n >= 0 && s.params.maxConnections.Swap(int64(n)) != int64(n) {
+			s.conns.wake()
+		}
+	}
+	changed := false
+	for key, p := range map[string]*atomic.Int64{
+		CfgReadTimeout:  &s.params.readTimeout,
+		CfgWriteTimeout: &s.params.writeTimeout,
+	} {
+		v, ok := cfg[key]
+		if !ok {
+			continue
+		}
+		var d time.Duration
+		if setDuration(&d, v) == nil && d >= 0 && p.Swap(int64(d)) != int64(d) {
+			changed = true
+		}
+	}
+	if changed {
+		for _, conn := range s.conns.snapshot() {
+			s.applyTimeouts(conn)
+		}
+	}
+}
+
+// batchSize returns the number of events the reactor handles per poll.
+func (s *Server) batchSize() int {
+	return int(s.params.batchSize.Load())
+}
+
+// maxConnections returns the connection limit (0 = unlimited).
+func (s *Server) maxConnections() int {
+	return int(s.params.maxConnections.Load())
+}
+
+// applyTimeouts sets the current read and write timeouts on conn's transport.
+func (s *Server) applyTimeouts(conn *protocol.WSConnection) {
+	if t, ok := conn.Transport().(interface {
+		SetTimeouts(read, write time.Duration)
+	}); ok {
+		t.SetTimeouts(time.Duration(s.params.readTimeout.Load()), time.Duration(s.params.writeTimeout.Load()))
+	}
+}
@@ -0,0 +1,149 @@
+// File: server/ratelimit.go
+// Package server wires token-bucket rate limits into the accept and read paths.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package server
+
+import (
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/momentics/hioload-ws/protocol"
+	"github.com/momentics/hioload-ws/ratelimit"
+)
+
+// Control.SetConfig keys for hot-reloading RateLimitConfig.
+const (
+	CfgHandshakesPerSec = "ratelimit.handshakes_per_sec"
+	CfgHandshakeBurst   = "ratelimit.handshake_burst"
+	CfgFramesPerSec     = "ratelimit.frames_per_sec"
+	CfgFrameBurst       = "ratelimit.frame_burst"
+	CfgFrameKey         = "ratelimit.frame_key"
+)
+
+// rateLimits holds the live limiters and their counters.
+type rateLimits struct {
+	handshakes *ratelimit.Limiter
+	frames     *ratelimit.Limiter
+	frameKey   atomic.Value // RateLimitKey
+
+	rejectedHandshakes atomic.Int64
+	throttledFrames    atomic.Int64
+}
+
+// initRateLimits builds limiters from cfg, exposes counters as debug probes
+// and subscribes to control reloads.
+func (s *Server) initRateLimits() {
+	rl := s.cfg.RateLimit
+	s.limits.handshakes = ratelimit.New(rl.HandshakesPerSec, rl.HandshakeBurst)
+	s.limits.frames = ratelimit.New(rl.FramesPerSec, rl.FrameBurst)
+	s.limits.frameKey.Store(normalizeFrameKey(rl.FrameKey))
+
+	s.control.RegisterDebugProbe("ratelimit.handshakes_rejected", func() any {
+		return s.limits.rejectedHandshakes.Load()
+	})
+	s.control.RegisterDebugProbe("ratelimit.frames_throttled", func() any {
+		return s.limits.throttledFrames.Load()
+	})
+	s.control.OnReload(s.reloadRateLimits)
+}
+
+// reloadRateLimits applies "ratelimit.*" keys from the control config.
+func (s *Server) reloadRateLimits() {
+	cfg := s.control.GetConfig()
+	hr, hb := s.limits.handshakes.Limit()
+	fr, fb := s.limits.frames.Limit()
+	s.limits.handshakes.SetLimit(floatValue(cfg[CfgHandshakesPerSec], hr), int(floatValue(cfg[CfgHandshakeBurst], float64(hb))))
+	s.limits.frames.SetLimit(floatValue(cfg[CfgFramesPerSec], fr), int(floatValue(cfg[CfgFrameBurst], float64(fb))))
+	if k, ok := cfg[CfgFrameKey].(string); ok {
+		s.limits.frameKey.Store(normalizeFrameKey(RateLimitKey(k)))
+	}
+}
+
+// allowHandshake charges the client IP for one upgrade.
+func (s *Server) allowHandshake(conn *protocol.WSConnection) error {
+	if !s.limits.handshakes.Enabled() || !s.features.Load().RateLimit || s.limits.handshakes.Allow(remoteIP(conn)) {
+		return nil
+	}
+	s.limits.rejectedHandshakes.Add(1)
+	return &protocol.HandshakeRejection{Status: http.StatusTooManyRequests, Reason: "handshake rate limit exceeded"}
+}
+
+// throttleFrame charges the configured key for one inbound frame, waiting
+// while its bucket is empty. The reader reads nothing meanwhile, so TCP holds
+// the peer back and no frame, nor any fragment of a message, is lost. It
+// reports false if conn closed while waiting.
+func (s *Server) throttleFrame(conn *protocol.WSConnection) bool {
+	if !s.limits.frames.Enabled() || !s.features.Load().RateLimit {
+		return true
+	}
+	var key string
+	switch s.limits.frameKey.Load().(RateLimitKey) {
+	case RateLimitByIP:
+		key = remoteIP(conn)
+	case RateLimitByRoute:
+		key = conn.Path()
+	default:
+		if sess := conn.Session(); sess != nil {
+			key = sess.ID()
+		}
+	}
+	d := s.limits.frames.Delay(key)
+	if d == 0 {
+		return true
+	}
+	s.limits.throttledFrames.Add(1)
+	conn.Trace("reading paused by rate limit", "wait", d)
+	for ; d > 0; d = s.limits.frames.Delay(key) {
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+		case <-conn.Done():
+			timer.Stop()
+			return false
+		}
+	}
+	return true
+}
+
+// remoteIP returns the host part of the peer address, or "" if unknown.
+func remoteIP(conn *protocol.WSConnection) string {
+	addr := conn.RemoteAddr()
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// normalizeFrameKey maps unknown keys to per-session limiting.
+func normalizeFrameKey(k RateLimitKey) RateLimitKey {
+	switch k {
+	case RateLimitByIP, RateLimitByRoute:
+		return k
+	}
+	return RateLimitBySession
+}
+
+// floatValue converts a numeric config value, falling back to def.
+func floatValue(v any, def float64) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case float32:
+		return float64(n)
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case int32:
+		return float64(n)
+	}
+	return def
+}
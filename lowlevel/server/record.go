@@ -0,0 +1,56 @@
+// File: server/record.go
+// Package server records the traffic of selected connections.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package server
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/control"
+	"github.com/momentics/hioload-ws/protocol"
+	"github.com/momentics/hioload-ws/record"
+)
+
+// Recorder opens the file the traffic of the connection upgrading req is
+// recorded to, or returns nil to leave it unrecorded.
+type Recorder func(req *http.Request) (io.WriteCloser, error)
+
+// WithRecorder records the raw bytes every connection sends and receives,
+// to what open returns for its upgrade request, in the format of package
+// record; record.Replay reproduces the connection offline. The file is
+// closed with the connection. A recorder failing only logs a warning, the
+// connection is accepted unrecorded.
+func WithRecorder(open Recorder) ServerOption {
+	return func(s *Server) {
+		s.recorder = open
+	}
+}
+
+// startRecording wraps the transport of c in a recording one if the
+// recorder selects it, once the handshake settled how c decodes.
+func (s *Server) startRecording(c *protocol.WSConnection, u *upgrade) {
+	if s.recorder == nil {
+		return
+	}
+	var w io.WriteCloser
+	req, err := u.request()
+	if err == nil {
+		w, err = s.recorder(req)
+	}
+	if err == nil && w == nil {
+		return
+	}
+	if err == nil {
+		var rt *record.Transport
+		if rt, err = record.NewTransport(c.Transport(), w, record.ConnMeta(c)); err == nil {
+			c.WrapTransport(func(api.Transport) api.Transport { return rt })
+			return
+		}
+		w.Close()
+	}
+	control.Logger(control.LogServer).Warn("connection not recorded", "path", c.Path(), "error", err)
+}
@@ -8,9 +8,13 @@ package server
 
 import (
 	"context"
+	"errors"
+	"os"
+	"time"
 
 	"github.com/momentics/hioload-ws/adapters"
 	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/internal/transport"
 	"github.com/momentics/hioload-ws/protocol"
 )
 
@@ -30,8 +34,10 @@ var _ api.Event = bufEvent{}
 // bufEventWithConn wraps an api.Buffer and a WSConnection for the reactor.
 // This allows us to pass connection context (like path) to the handler.
 type bufEventWithConn struct {
-	buf  api.Buffer
-	conn *protocol.WSConnection
+	buf    api.Buffer
+	conn   *protocol.WSConnection
+	ctx    context.Context
+	opcode byte
 }
 
 // Data returns the underlying buffer payload for event dispatch.
@@ -49,9 +55,63 @@ func (e bufEventWithConn) GetBuffer() api.Buffer {
 	return e.buf
 }
 
+// Ctx returns the context of the connection, cancelled when it closes.
+func (e bufEventWithConn) Ctx() context.Context {
+	return e.ctx
+}
+
+// Opcode returns the opcode of the frame that carried the buffer.
+func (e bufEventWithConn) Opcode() byte {
+	return e.opcode
+}
+
+// FlowKey schedules the frame fairly with the other frames of its connection.
+func (e bufEventWithConn) FlowKey() any {
+	return e.conn
+}
+
+// FlowCost charges the frame's payload size against the connection's quantum.
+func (e bufEventWithConn) FlowCost() int {
+	return len(e.buf.Data)
+}
+
 // Ensure bufEventWithConn implements api.Event
 var _ api.Event = bufEventWithConn{}
 
+// lifecycleEvent carries an api.OpenEvent or api.CloseEvent through the reactor.
+type lifecycleEvent struct {
+	evt any
+}
+
+// Data returns the wrapped OpenEvent/CloseEvent value.
+func (e lifecycleEvent) Data() any {
+	return e.evt
+}
+
+// FlowKey keeps open and close in order with the connection's frames.
+func (e lifecycleEvent) FlowKey() any {
+	switch evt := e.evt.(type) {
+	case api.OpenEvent:
+		return evt.Conn
+	case api.CloseEvent:
+		return evt.Conn
+	}
+	return nil
+}
+
+// FlowCost is zero: lifecycle events use none of the quantum.
+func (e lifecycleEvent) FlowCost() int {
+	return 0
+}
+
+// RunCtx is Run for a handler taking the context of the connection each
+// event belongs to. The context is cancelled when the connection closes,
+// with its CloseEvent's Err or an *api.CloseError as cause, and bounded by
+// Config.HandlerTimeout for each call.
+func (s *Server) RunCtx(handler api.HandlerCtx) error {
+	return s.Run(adapters.HandlerWithContext(handler, s.cfg.HandlerTimeout))
+}
+
 // Run starts the server: it applies CPU/NUMA affinity, starts the reactor,
 // begins accepting WebSocket connections, and blocks until Shutdown() is called.
 // It then orchestrates graceful teardown.
@@ -63,50 +123,42 @@ func (s *Server) Run(handler api.Handler) error {
 	}
 	defer aff.Unpin()
 
-	// 2. Build middleware-decorated handler chain.
-	hChain := NewHandlerChain(handler, s.middleware...)
+	// 2. Build middleware-decorated handler chain, timed for latency stats.
+	chain := s.hostChain(handler)
 
-	// 3. Register the composite handler with the reactor (poller).
-	if err := s.poller.Register(hChain); err != nil {
-		return err
-	}
-
-	// 4. Launch reactor polling loop.
-	go func() {
-		for {
-			select {
-			case <-s.shutdownCh:
-				return
-			default:
-				// Poll up to BatchSize events
-				s.poller.Poll(s.cfg.BatchSize)
-			}
+	// 3. Register the composite handler with the reactor (poller) of every
+	// node, watched for slow calls if configured.
+	var watches []*dispatchWatch
+	for _, n := range s.nodes {
+		hChain := timedHandler{next: chain, hist: s.latency.handler}
+		if s.cfg.SlowHandler > 0 {
+			hChain.watch = new(dispatchWatch)
+			watches = append(watches, hChain.watch)
 		}
-	}()
-
-	// 5. Accept connections and spawn per-connection readers.
-	go func() {
-		for {
-			wsConn, err := s.listener.Accept()
-			if err != nil {
-				return
-			}
+		if err := n.poller.Register(hChain); err != nil {
+			return err
+		}
+	}
+	if len(watches) > 0 {
+		go s.watchHandlers(watches)
+	}
+	s.running.Store(true)
+	defer s.running.Store(false)
 
-			// Check connection limit before handling the connection
-			if s.cfg.MaxConnections > 0 {
-				s.connMu.Lock()
-				if s.connCount >= int64(s.cfg.MaxConnections) {
-					s.connMu.Unlock()
-					wsConn.Close() // Close new connection immediately
-					continue       // Skip handling this connection
-				}
-				s.connCount++
-				s.connMu.Unlock()
-			}
+	// 4. Launch the reactor polling loops.
+	for _, n := range s.nodes {
+		go s.pollLoop(n)
+	}
 
-			go s.handleConnWithTracking(wsConn, s.poller)
-		}
-	}()
+	// 5. Accept connections on every listener and spawn per-connection
+	// readers feeding the reactor of the listener's node.
+	listeners, listenerNode := s.listenerSet()
+	for i, l := range listeners {
+		s.accepting.Add(1)
+		go s.acceptLoop(l, listenerNode[i])
+	}
+	s.readyOnce.Do(func() { close(s.ready) })
+	signalReady() // to the process that started this one by Upgrade, if any
 
 	// 6. Block until Shutdown signal.
 	<-s.shutdownCh
@@ -115,46 +167,164 @@ func (s *Server) Run(handler api.Handler) error {
 	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
 	defer cancel()
 
-	s.listener.Close()
-	s.poller.Stop()
+	s.closeListeners()
+	for _, n := range s.nodes {
+		n.poller.Stop()
+	}
+	if s.ownSessions {
+		s.sessions.Stop()
+	}
 
 	// Wait for reactor and readers to finish or timeout.
 	<-ctx.Done()
 	return nil
 }
 
+// acceptLoop accepts connections from l until it is closed, handing them to
+// the reactor of node n.
+func (s *Server) acceptLoop(l *transport.WebSocketListener, n *reactorNode) {
+	defer s.accepting.Add(-1)
+	for {
+		wsConn, err := l.Accept()
+		if errors.Is(err, transport.ErrListenerClosed) {
+			return
+		}
+		s.accepts.record(err)
+		if err != nil {
+			s.auditAcceptError(err)
+			continue // failed or rejected handshake affects only that client
+		}
+
+		go s.handleConnWithTracking(wsConn, n.poller)
+	}
+}
+
 // handleConnWithTracking reads zero-copy buffers from a WSConnection and pushes them into the reactor.
-// Also tracks the connection count for limiting.
+// Also tracks the connection count for limiting, binds a Session to the connection
+// and brackets its lifetime with api.OpenEvent / api.CloseEvent.
 func (s *Server) handleConnWithTracking(conn *protocol.WSConnection, poller api.Poller) {
+	start := time.Now()
+	slot, ok := s.acquireConn(conn)
+	if !ok {
+		s.auditDisconnect(conn, start, nil)
+		// Never attached: a fresh session is closed, a resumed one stays resumable.
+		if sess := conn.Session(); sess != nil {
+			s.sessions.Detach(sess.ID(), conn)
+		}
+		return
+	}
+	sess := s.attachSession(conn)
+	s.auditConnect(conn)
+	s.startKeepAlive(conn)
+	s.watchIdle(conn)
+	ctx, cancel := context.WithCancelCause(api.ContextWithConnection(context.Background(), conn))
+	poller.Push(lifecycleEvent{evt: openEvent(ctx, conn, sess)})
+
+	// An expired (TTL/idle) or externally closed session terminates the connection.
+	go watchSession(conn)
+
+	var recvErr error
 	defer func() {
 		conn.Close()
-		// Decrement connection count when connection is closed
-		if s.cfg.MaxConnections > 0 {
-			s.connMu.Lock()
-			s.connCount--
-			s.connMu.Unlock()
+		s.auditDisconnect(conn, start, recvErr)
+		sess := conn.Session() // may have been re-bound by a resume frame
+		evt := closeEvent(ctx, conn, sess, abnormalErr(recvErr))
+		if evt.Err != nil {
+			cancel(evt.Err)
+		} else {
+			cancel(&api.CloseError{Code: evt.Code, Reason: evt.Reason})
 		}
+		poller.Push(lifecycleEvent{evt: evt})
+		conn.Trace("state detached")
+		s.unwatchIdle(conn)
+		s.sessions.Detach(sess.ID(), conn)
+		s.limits.frames.Forget(sess.ID())
+		s.releaseConn(conn)
 	}()
 
-	// Server mode: recvLoop is NOT started, so we use RecvZeroCopy in Direct Mode
+	// Server mode: recvLoop is NOT started, so we use RecvMessages in Direct Mode
 	// which reads directly from the transport.
+	first := s.sessions.ResumeEnabled() // resume frames accepted only when enabled
 	for {
-		bufs, err := conn.RecvZeroCopy()
+		msgs, err := conn.RecvMessages()
 		if err != nil {
+			recvErr = err
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				conn.CloseWithCode(protocol.CloseGoingAway, "read timeout")
+			}
 			return
 		}
+		conn.Session().Touch()
+		slot.touch(s.clock().Now())
 
-		for _, buf := range bufs {
+		for i, msg := range msgs {
+			buf := msg.Buf
+			if first {
+				first = false
+				if s.resumeFromFrame(conn, buf) {
+					buf.Release()
+					continue
+				}
+			}
+			if !s.throttleFrame(conn) {
+				for _, m := range msgs[i:] {
+					m.Buf.Release()
+				}
+				return
+			}
 			// Push each buffer as a bufEvent into the reactor's inbox.
 			// Create an event that contains both the buffer and the connection context
 			// fmt.Println("DEBUG: Push to Poller")
-			event := bufEventWithConn{buf: buf, conn: conn}
+			event := bufEventWithConn{buf: buf, conn: conn, ctx: ctx, opcode: msg.Opcode}
 			poller.Push(event)
 		}
 	}
 }
 
-// Shutdown signals Run to stop accepting and processing.
+// openEvent describes conn, just upgraded, with its handshake metadata.
+func openEvent(ctx context.Context, conn *protocol.WSConnection, sess api.Session) api.OpenEvent {
+	return api.OpenEvent{
+		Conn:        conn,
+		Ctx:         ctx,
+		Session:     sess,
+		RemoteAddr:  conn.RemoteAddr(),
+		Path:        conn.Path(),
+		Subprotocol: conn.Subprotocol(),
+		Extensions:  conn.Extensions(),
+		TLS:         conn.TLS(),
+	}
+}
+
+// closeEvent describes conn, closed, with its close status and counters.
+func closeEvent(ctx context.Context, conn *protocol.WSConnection, sess api.Session, err error) api.CloseEvent {
+	code, reason, ok := conn.CloseStatus()
+	if !ok {
+		code = protocol.CloseAbnormalClosure
+	}
+	stats := conn.GetStats()
+	return api.CloseEvent{
+		Conn:      conn,
+		Ctx:       ctx,
+		Session:   sess,
+		Err:       err,
+		Code:      code,
+		Reason:    reason,
+		BytesIn:   stats["bytes_received"],
+		BytesOut:  stats["bytes_sent"],
+		FramesIn:  stats["frames_received"],
+		FramesOut: stats["frames_sent"],
+	}
+}
+
+// StopAccepting closes the listeners so no new connections are accepted
+// while established connections and the reactor keep running, e.g. to drain
+// them before Shutdown.
+func (s *Server) StopAccepting() error {
+	return s.closeListeners()
+}
+
+// Shutdown signals Run to stop accepting and processing. It is safe to call
+// more than once.
 func (s *Server) Shutdown() {
-	close(s.shutdownCh)
+	s.shutdownOnce.Do(func() { close(s.shutdownCh) })
 }
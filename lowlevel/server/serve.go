@@ -0,0 +1,36 @@
+// File: server/serve.go
+// Package server serves connections established outside its listeners.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package server
+
+import (
+	"net/http"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// ServeTransport serves a connection established over tr outside the
+// listeners, such as one end of an in-memory pipe, as if it had been
+// accepted with the upgrade request req: the handshake checks run (only the
+// 101 response is not written) and, once admitted, the connection is served
+// by the first reactor like an accepted one. A rejection is returned with
+// tr closed. Run must have been called.
+func (s *Server) ServeTransport(tr api.Transport, req *http.Request) error {
+	n := s.nodes[0]
+	conn := protocol.NewWSConnectionWithPath(tr, n.pool, s.cfg.ChannelCapacity, req.URL.Path)
+	conn.SetRequestHeader(req.Header)
+	if err := s.handshake(conn, &upgrade{req: req}, make(http.Header)); err != nil {
+		tr.Close()
+		return err
+	}
+	go s.handleConnWithTracking(conn, n.poller)
+	return nil
+}
+
+// clock returns the Clock of the server's timers.
+func (s *Server) clock() api.Clock {
+	return s.cfg.clock()
+}
@@ -8,28 +8,70 @@ package server
 
 import (
 	"errors"
+	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/momentics/hioload-ws/adapters"
 	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/chaos"
+	"github.com/momentics/hioload-ws/control"
 	"github.com/momentics/hioload-ws/internal/transport"
 	"github.com/momentics/hioload-ws/pool"
+	"github.com/momentics/hioload-ws/protocol"
+	"github.com/momentics/hioload-ws/session"
 )
 
 var ErrAlreadyRunning = errors.New("server already running")
 
 // Server is the unified facade encapsulating listener, reactor, executor, control, and buffer pool.
 type Server struct {
-	cfg        *Config        // server configuration (batch size, NUMA node, timeouts, etc.)
-	control    api.Control    // control adapter for hot-reload, debug probes, metrics
-	pool       api.BufferPool // zero-copy buffer pool per NUMA node
-	listener   *transport.WebSocketListener
-	poller     api.Poller
-	executor   api.Executor
-	middleware []Middleware
-	shutdownCh chan struct{}
-	connCount  int64        // current number of active connections
-	connMu     sync.RWMutex // mutex to protect connection count
+	cfg           *Config        // server configuration (batch size, NUMA node, timeouts, etc.)
+	control       api.Control    // control adapter for hot-reload, debug probes, metrics
+	pool          api.BufferPool // zero-copy buffer pool of the first node
+	nodes         []*reactorNode // buffer pool and reactor per NUMA node
+	acceptorNodes []int          // set by WithNUMAAcceptors
+	lnMu          sync.RWMutex // guards listeners, listenerNode and sockets
+	listeners     []*transport.WebSocketListener
+	listenerNode  []*reactorNode         // node serving each of listeners
+	sockets       []socket               // raw listening sockets behind listeners, handed over by Upgrade
+	ready         chan struct{}          // closed once Run accepts on every listener
+	readyOnce     sync.Once
+	endpoints     []Endpoint             // opened alongside cfg.ListenAddr
+	hostHandlers  map[string]api.Handler // Run's handler per virtual host name, see VirtualHost
+	poller        api.Poller             // reactor of the first node
+	executor      api.Executor
+	middleware    []Middleware
+	checks        []HandshakeCheck        // run before upgrading each connection
+	recorder      Recorder                // opens traffic recordings, see WithRecorder
+	sessions      *session.SessionManager // sessions bound to live connections
+	ownSessions   bool                    // sessions created (and stopped) by this server
+	limits        rateLimits              // handshake/frame token buckets
+	params        liveParams              // hot-reloadable batch size, timeouts, connection limit
+	ipFilter      *transport.IPFilter     // CIDR allow/deny lists checked on accept
+	ipLists       atomic.Pointer[string]  // lists last applied by reloadIPFilter
+	shutdownCh    chan struct{}
+	shutdownOnce  sync.Once
+	conns         *connTable      // admitted connections for MaxConnections/OverflowPolicy
+	latency       latencyStats    // handler/send/handshake histograms
+	accepts       *acceptStats    // accept counts and handshake failures by reason
+	slowHandlers  control.Counter // handler calls over Config.SlowHandler
+	idle          *idleWheel      // closes idle connections, nil without Config.IdleTimeout
+	idleClosed    control.Counter // connections closed by idle
+	trace         traceState      // session IDs selected for per-connection tracing
+	dump          dumpState       // connections whose frames are dumped, see CfgDumpConnections
+	audit         *control.AuditRing
+	features      atomic.Pointer[control.Features] // toggles applied to new connections
+	health        *control.HealthRegistry
+	running       atomic.Bool  // reactor registered by Run
+	draining      atomic.Bool  // set by Drain
+	accepting     atomic.Int32 // accept loops active
+	panicMu       sync.Mutex
+	panicHooks    []PanicHook     // registered by OnPanic
+	chaos         *chaos.Injector // fault injector of chaos builds, else nil
+
+	upgrade upgradeCmd // started by Upgrade
 }
 
 // NewServer constructs a Server facade with the given Config and options.
@@ -42,45 +84,143 @@ func NewServer(cfg *Config, opts ...ServerOption) (*Server, error) {
 	// 1. ControlAdapter for dynamic config, metrics, debug probes, hot-reload
 	ctrl := adapters.NewControlAdapter()
 
-	// 2. BufferPoolManager: shared pools per NUMA node; choose preferred node or auto
+	// 2. BufferPoolManager: shared pools per NUMA node; each reactor node
+	// takes its own in step 6.
 	bufMgr := pool.DefaultManager()
-	bufPool := bufMgr.GetPool(cfg.IOBufferSize, cfg.NUMANode)
 
-	// 3. WebSocket listener: zero‐copy buffers, per‐connection channels
-	wsListener, err := transport.NewWebSocketListener(
-		cfg.ListenAddr,
-		bufPool,
-		cfg.ChannelCapacity,
-		transport.WithListenerNUMANode(cfg.NUMANode),
-	)
+	// 3. WebSocket listener options: zero‐copy buffers, per‐connection
+	// channels, the IP filter applied on accept; the handshake hook binds (or resumes) a Session, runs the
+	// checks and negotiates the subprotocol before the 101 response.
+	// Listeners open in step 7.
+	ipFilter, err := transport.NewIPFilter(cfg.IPAllow, cfg.IPDeny)
 	if err != nil {
 		return nil, err
 	}
+	var srv *Server
+	lnOpts := []transport.ListenerOption{
+		transport.WithListenerNUMANode(cfg.NUMANode),
+		transport.WithIPFilter(ipFilter),
+		transport.WithHandshakeObserver(func(d time.Duration) {
+			srv.latency.handshake.Record(d)
+		}),
+		transport.WithHandshakeHook(func(c *protocol.WSConnection, req *protocol.UpgradeRequest, resp http.Header) error {
+			return srv.handshake(c, &upgrade{ur: req}, resp)
+		}),
+	}
+	if cfg.AcceptWorkers > 0 {
+		lnOpts = append(lnOpts, transport.WithAcceptWorkers(cfg.AcceptWorkers, cfg.AcceptQueue))
+	}
 
-	// 4. PollerAdapter (Reactor): batch IO, lock-free rings
-	poller := adapters.NewPollerAdapter(cfg.BatchSize, cfg.ReactorRing)
-
-	// 5. ExecutorAdapter: lock-free task dispatch, NUMA-aware
+	// 4. ExecutorAdapter: lock-free task dispatch, NUMA-aware
 	executor := adapters.NewExecutorAdapter(cfg.ExecutorWorkers, cfg.NUMANode)
 
-	srv := &Server{
+	srv = &Server{
 		cfg:        cfg,
 		control:    ctrl,
-		pool:       bufPool,
-		listener:   wsListener,
-		poller:     poller,
 		executor:   executor,
+		ipFilter:   ipFilter,
 		shutdownCh: make(chan struct{}),
+		ready:      make(chan struct{}),
+		conns:      newConnTable(cfg.clock()),
+		latency:    newLatencyStats(),
+		accepts:    newAcceptStats(),
+		audit:      control.NewAuditRing(cfg.AuditSize),
+		health:     control.NewHealthRegistry(),
+	}
+	if cfg.IdleTimeout > 0 {
+		srv.idle = newIdleWheel(cfg.clock(), cfg.IdleTimeout, !cfg.IdleIgnorePongs, srv.shutdownCh, &srv.idleClosed)
 	}
 
-	// 6. Apply functional options (middleware, affinity, etc.)
+	// 5. Apply functional options (middleware, affinity, etc.)
 	for _, opt := range opts {
 		opt(srv)
 	}
 
+	// 6. PollerAdapter (Reactor) and buffer pool per NUMA node: batch IO,
+	// lock-free rings. Without NUMAAcceptors there is a single node.
+	srv.newNodes(bufMgr)
+	srv.initChaos()
+
+	// 7. Listeners: cfg.ListenAddr and the WithEndpoints addresses, one
+	// per reactor node
+	if err := srv.openListeners(lnOpts); err != nil {
+		return nil, err
+	}
+
+	// 8. SessionManager: one Session per accepted connection
+	if srv.sessions == nil {
+		srv.sessions = session.NewSessionManager(0)
+		srv.ownSessions = true
+	}
+
+	// 9. Rate limits from cfg.RateLimit, feature toggles and the reactor,
+	// timeout and capacity parameters, hot-reloadable via control
+	srv.initParams()
+	srv.initRateLimits()
+	srv.initIPFilter()
+	srv.initFeatures()
+
+	// 10. Connection accounting exposed via control
+	srv.registerConnProbes()
+	srv.registerLatencyProbes()
+	srv.registerWatchdogProbes()
+	srv.registerAcceptProbes()
+	srv.registerDrainProbes()
+	srv.registerIdleProbes()
+	srv.registerMemoryProbes()
+	srv.initFairScheduling()
+	srv.initPanicRecovery()
+	srv.registerAuditProbes()
+
+	// 11. Per-connection tracing and frame dumps selected via control config
+	srv.initTracing()
+	srv.initDumps()
+
+	// 12. Liveness/readiness checks
+	srv.registerHealthChecks()
+
 	return srv, nil
 }
 
+// handshake admits and configures each connection before its 101 response.
+func (s *Server) handshake(c *protocol.WSConnection, req *upgrade, resp http.Header) error {
+	if err := s.admitDraining(); err != nil {
+		return err
+	}
+	if err := s.allowHandshake(c); err != nil {
+		return err
+	}
+	if err := s.bindSession(c, req, resp); err != nil {
+		return err
+	}
+	if err := s.checkHandshake(c, req); err != nil {
+		// Never attached: a fresh session is closed, a resumed one stays resumable.
+		s.sessions.Detach(c.Session().ID(), c)
+		return err
+	}
+	c.SetClock(s.clock())
+	s.applyTimeouts(c)
+	c.SetSendObserver(s.latency.send.Record)
+	if s.cfg.Outbox != (protocol.OutboxLimit{}) {
+		c.SetOutboxLimit(s.cfg.Outbox)
+	}
+	if s.cfg.ReadBufferLimit > 0 {
+		c.SetReadBufferLimit(s.cfg.ReadBufferLimit)
+	}
+	s.negotiateFeatures(c, req, resp)
+	if len(s.cfg.Subprotocols) > 0 {
+		if p := req.negotiate(protocol.HeaderSecWebSocketProto, func(r *http.Request) string {
+			return protocol.SelectSubprotocol(r, s.cfg.Subprotocols)
+		}); p != "" {
+			c.SetSubprotocol(p)
+			resp.Set(protocol.HeaderSecWebSocketProto, p)
+		}
+	}
+	s.wrapChaos(c)
+	s.startRecording(c, req)
+	return nil
+}
+
 func (s *Server) UseMiddleware(mw ...Middleware) {
 	s.middleware = append(s.middleware, mw...)
 }
@@ -98,9 +238,17 @@ func (s *Server) GetBufferPool() api.BufferPool {
 	return s.pool
 }
 
+// Sessions returns the SessionManager holding one Session per live connection.
+func (s *Server) Sessions() *session.SessionManager {
+	return s.sessions
+}
+
 // GetActiveConnections returns the current number of active connections.
 func (s *Server) GetActiveConnections() int64 {
-	s.connMu.RLock()
-	defer s.connMu.RUnlock()
-	return s.connCount
+	return s.conns.current()
+}
+
+// GetPeakConnections returns the highest number of simultaneous connections.
+func (s *Server) GetPeakConnections() int64 {
+	return s.conns.peakCount()
 }
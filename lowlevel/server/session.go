@@ -0,0 +1,123 @@
+// File: server/session.go
+// Package server binds sessions to connections and handles resumption.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/protocol"
+	"github.com/momentics/hioload-ws/session"
+)
+
+// bindSession runs during the handshake: it re-binds a client presenting a
+// valid resumption token, otherwise opens a fresh session, and advertises the
+// session's token in the 101 response.
+func (s *Server) bindSession(conn *protocol.WSConnection, req *upgrade, resp http.Header) error {
+	var sess api.Session
+	if tok := req.header(session.ResumeHeader); tok != "" {
+		sess, _ = s.sessions.Resume(tok)
+	}
+	if sess == nil {
+		sess = s.sessions.Open()
+	}
+	conn.SetSession(sess)
+	if tok := s.sessions.Token(sess); tok != "" {
+		resp.Set(session.ResumeHeader, tok)
+	}
+	return nil
+}
+
+// checkHandshake runs the handshake checks, with the bound session available
+// to them through HandshakeSession, and the connection limit.
+func (s *Server) checkHandshake(conn *protocol.WSConnection, u *upgrade) error {
+	if len(s.checks) > 0 {
+		req, err := u.request()
+		if err != nil {
+			return &protocol.HandshakeRejection{Status: http.StatusBadRequest, Reason: err.Error()}
+		}
+		ctx := context.WithValue(req.Context(), handshakeSessionKey{}, conn.Session())
+		if host := conn.VirtualHost(); host != "" {
+			ctx = context.WithValue(ctx, handshakeHostKey{}, host)
+		}
+		req = req.WithContext(ctx)
+		for _, check := range s.checks {
+			if err := check(req); err != nil {
+				return err
+			}
+		}
+	}
+	return s.admitHandshake()
+}
+
+// attachSession makes conn the live connection of its session, closing any
+// connection it superseded, and returns the bound session.
+func (s *Server) attachSession(conn *protocol.WSConnection) api.Session {
+	sess := conn.Session()
+	if sess == nil {
+		sess = s.sessions.Open()
+		conn.SetSession(sess)
+	}
+	if prev := s.sessions.Attach(sess.ID(), conn); prev != nil {
+		prev.Close()
+	}
+	s.applyTrace(conn)
+	s.applyDump(conn)
+	conn.Trace("state attached")
+	return sess
+}
+
+// resumeFromFrame handles a first text frame "hioload-resume:<token>". On a
+// valid token conn is re-bound to the resumed session, the provisional one is
+// closed and the token is echoed back. Returns false if buf is not a resume
+// request, in which case it must be delivered normally.
+func (s *Server) resumeFromFrame(conn *protocol.WSConnection, buf api.Buffer) bool {
+	payload := buf.Bytes()
+	if !bytes.HasPrefix(payload, []byte(session.ResumeFramePrefix)) {
+		return false
+	}
+	token := string(payload[len(session.ResumeFramePrefix):])
+	resumed, ok := s.sessions.Resume(token)
+	if !ok {
+		return false
+	}
+
+	provisional := conn.Session()
+	if provisional != nil && provisional.ID() != resumed.ID() {
+		conn.SetSession(resumed)
+		s.sessions.Close(provisional.ID())
+	}
+
+	// Acknowledge before attaching so the ack precedes any replayed messages.
+	ack := []byte(session.ResumeFramePrefix + s.sessions.Token(resumed))
+	conn.SendFrame(&protocol.WSFrame{
+		IsFinal:    true,
+		Opcode:     protocol.OpcodeText,
+		PayloadLen: int64(len(ack)),
+		Payload:    ack,
+	})
+	s.attachSession(conn)
+	return true
+}
+
+// watchSession closes conn once the session currently bound to it ends
+// (TTL, idle eviction or explicit close), following re-binds on resumption.
+func watchSession(conn *protocol.WSConnection) {
+	for {
+		cur := conn.Session()
+		select {
+		case <-cur.Done():
+			if conn.Session() == cur {
+				conn.Close()
+				return
+			}
+		case <-conn.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,26 @@
+// File: server/splice.go
+// Package server relays proxied connections without reframing them.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package server
+
+import (
+	"net"
+
+	"github.com/momentics/hioload-ws/internal/transport"
+)
+
+// Splice relays bytes between two established connections in both
+// directions until both ends have closed, then closes them: for proxies
+// that, once the WebSocket handshakes are done and no frame needs
+// inspecting, forward the rest unchanged. Bytes already read past the
+// handshakes must be written to the other side before calling it.
+//
+// On Linux, two TCP connections are spliced in the kernel through an eBPF
+// sockmap, so payload bytes never reach the process; that needs CAP_BPF and
+// CAP_NET_ADMIN. Elsewhere, for other connections, e.g. TLS, or when the
+// kernel refuses, the bytes are copied. inKernel reports which path ran.
+func Splice(a, b net.Conn) (inKernel bool, err error) {
+	return transport.Splice(a, b)
+}
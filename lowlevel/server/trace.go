@@ -0,0 +1,118 @@
+// File: server/trace.go
+// Package server enables per-connection debug traces through control.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package server
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/momentics/hioload-ws/control"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// CfgTraceConnections lists the session IDs whose connections are traced,
+// as a list or a comma-separated string. It is hot-reloadable; traces go to
+// the control.LogTrace logger.
+const CfgTraceConnections = "trace.connections"
+
+// traceState holds the session IDs selected for tracing.
+type traceState struct {
+	mu  sync.Mutex // serialises TraceConnection updates
+	ids atomic.Pointer[map[string]bool]
+}
+
+func (s *Server) initTracing() {
+	s.control.RegisterDebugProbe("trace.connections", func() any {
+		return len(s.tracedIDs())
+	})
+	s.control.OnReload(s.reloadTracing)
+}
+
+// TraceConnection enables or disables tracing of the connection bound to
+// session id by updating CfgTraceConnections through control, so the choice
+// survives reloads and follows the session across resumes.
+func (s *Server) TraceConnection(id string, enabled bool) error {
+	s.trace.mu.Lock()
+	defer s.trace.mu.Unlock()
+	ids := s.tracedIDs()
+	if ids[id] == enabled {
+		return nil
+	}
+	list := make([]any, 0, len(ids)+1)
+	for traced := range ids {
+		if traced != id {
+			list = append(list, traced)
+		}
+	}
+	if enabled {
+		list = append(list, id)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].(string) < list[j].(string) })
+	return s.control.SetConfig(map[string]any{CfgTraceConnections: list})
+}
+
+// tracedIDs returns the current selection; the map must not be modified.
+func (s *Server) tracedIDs() map[string]bool {
+	if ids := s.trace.ids.Load(); ids != nil {
+		return *ids
+	}
+	return nil
+}
+
+// reloadTracing applies CfgTraceConnections to the live connections.
+func (s *Server) reloadTracing() {
+	ids := parseTraceIDs(s.control.GetConfig()[CfgTraceConnections])
+	s.trace.ids.Store(&ids)
+
+	s.conns.mu.Lock()
+	live := make([]*protocol.WSConnection, 0, len(s.conns.live))
+	for conn := range s.conns.live {
+		live = append(live, conn)
+	}
+	s.conns.mu.Unlock()
+	for _, conn := range live {
+		s.applyTrace(conn)
+	}
+}
+
+// applyTrace enables or disables tracing of conn for its current session.
+func (s *Server) applyTrace(conn *protocol.WSConnection) {
+	sess := conn.Session()
+	want := sess != nil && s.tracedIDs()[sess.ID()]
+	if want == conn.Tracing() {
+		return
+	}
+	if want {
+		conn.SetTrace(control.Logger(control.LogTrace))
+	} else {
+		conn.SetTrace(nil)
+	}
+}
+
+func parseTraceIDs(v any) map[string]bool {
+	ids := make(map[string]bool)
+	switch t := v.(type) {
+	case string:
+		for _, id := range strings.Split(t, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids[id] = true
+			}
+		}
+	case []string:
+		for _, id := range t {
+			ids[id] = true
+		}
+	case []any:
+		for _, item := range t {
+			if id, ok := item.(string); ok {
+				ids[id] = true
+			}
+		}
+	}
+	return ids
+}
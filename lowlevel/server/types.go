@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/protocol"
 )
 
 // Config holds all server parameters for high-performance WebSocket service.
@@ -18,14 +19,107 @@ type Config struct {
 	IOBufferSize    int               // size of zero-copy buffers
 	ChannelCapacity int               // capacity of per-connection frame channels
 	NUMANode        int               // preferred NUMA node (-1 = auto)
-	ReadTimeout     time.Duration     // optional read deadline
-	WriteTimeout    time.Duration     // optional write deadline
+	ReadTimeout     time.Duration     // closes connections silent for longer, with 1001 (0 = none)
+	WriteTimeout    time.Duration     // bound of each transport write (0 = none)
 	BatchSize       int               // number of events per reactor batch
 	ReactorRing     int               // capacity of reactor ring buffer
 	ExecutorWorkers int               // number of executor workers
 	AffinityScope   api.AffinityScope // CPU/NUMA binding scope
 	ShutdownTimeout time.Duration     // graceful shutdown wait time
+	HandlerTimeout  time.Duration     // deadline of each RunCtx handler call (0 = none)
+	SlowHandler     time.Duration     // handler calls running longer are logged with their stack and counted (0 = off)
 	MaxConnections  int               // maximum number of concurrent connections (0 = no limit)
+	OverflowPolicy  OverflowPolicy    // behaviour once MaxConnections is reached
+	OverflowWait    time.Duration     // how long OverflowQueue holds a connection for a free slot
+	DrainIdle       time.Duration     // silence both ways after which Drain closes a connection (0 = DefaultDrainIdle)
+	IdleTimeout     time.Duration     // closes connections receiving no frame for longer, with 1001 "idle timeout" (0 = none)
+	IdleIgnorePongs bool              // pongs do not defer IdleTimeout, so peers only answering keepalive pings are closed too
+	RateLimit       RateLimitConfig   // inbound handshake/frame throttling (zero = off)
+	Subprotocols    []string          // Sec-WebSocket-Protocol values accepted, e.g. "mqtt"
+	AuditSize       int               // recent connection events kept (0 = control.DefaultAuditSize)
+
+	HealthMaxBuffersInUse int64 // readiness fails above this many pooled buffers in use (0 = no limit)
+
+	Outbox protocol.OutboxLimit // per-connection send queue bound and slow-consumer policy (zero = block)
+
+	ReadBufferLimit int // bytes buffered for one incoming frame; larger frames close with 1009 (0 = protocol.MaxFramePayload)
+
+	// Bytes of frames each connection may dispatch per reactor round before
+	// the next connection's turn (0 = concurrency.DefaultQuantum).
+	FairQuantum int
+
+	ReusePort bool // bind TCP listeners with SO_REUSEPORT so another process can share them (not on Windows)
+
+	// AcceptWorkers performs the handshakes (TLS, upgrade request, checks) of
+	// each listener on that many goroutines, fed by a queue of up to
+	// AcceptQueue accepted connections (0 = AcceptWorkers), so a storm of
+	// slow handshakes does not stall accepting. Beyond the queue, connections
+	// wait in the kernel's backlog. 0 handshakes on the accept loop itself.
+	AcceptWorkers int
+	AcceptQueue   int
+
+	// AcceptEx keeps that many accepts posted with AcceptEx on each Windows
+	// TCP listener, into sockets created in advance, each completing once
+	// the client's first bytes arrived. Ignored elsewhere and with ReusePort
+	// or several NUMA acceptors (0 = the runtime's accept).
+	AcceptEx int
+
+	// NUMAAcceptors gives every NUMA node its own TCP listeners, bound with
+	// SO_REUSEPORT, plus a reactor on a thread bound to the node and a buffer
+	// pool of the node's memory; a connection is served by the node that
+	// accepted it. On Linux the kernel hands each connection to the node
+	// whose CPU received it. Not on Windows; see WithNUMAAcceptors.
+	NUMAAcceptors bool
+
+	// Peers checked on accept, before the handshake; hot-reloadable through
+	// the CfgIPAllow/CfgIPDeny keys.
+	IPAllow []string // CIDRs or addresses allowed to connect (empty = any)
+	IPDeny  []string // CIDRs or addresses refused, checked before IPAllow
+
+	// Feature toggles, switchable at runtime via the control.CfgFeature* keys.
+	Compression      bool          // negotiate permessage-deflate when the client offers it
+	KeepAlive        time.Duration // interval of server pings (0 = off)
+	StrictValidation bool          // close connections sending frames that violate RFC 6455
+
+	// Clock times keepalive pings, overflow waits, draining and the read
+	// timeouts of transports that take it, such as the sim package's
+	// in-memory ones (nil = api.SystemClock). Tests set a simulated clock to
+	// run that logic without sleeping.
+	Clock api.Clock
+}
+
+// OverflowPolicy selects what happens to a new connection when the server is
+// already at MaxConnections.
+type OverflowPolicy string
+
+const (
+	// OverflowReject answers the handshake with HTTP 503 (default).
+	OverflowReject OverflowPolicy = "reject"
+	// OverflowCloseOldestIdle evicts the connection idle the longest to make room.
+	OverflowCloseOldestIdle OverflowPolicy = "close-oldest-idle"
+	// OverflowQueue upgrades the connection but holds it unread until a slot
+	// frees up or OverflowWait elapses, then closes it with 1013 (try again later).
+	OverflowQueue OverflowPolicy = "queue"
+)
+
+// RateLimitKey selects how inbound frame limits are bucketed.
+type RateLimitKey string
+
+const (
+	RateLimitBySession RateLimitKey = "session"
+	RateLimitByIP      RateLimitKey = "ip"
+	RateLimitByRoute   RateLimitKey = "route"
+)
+
+// RateLimitConfig configures token-bucket throttling; rates are events per
+// second and 0 disables the respective limit. All fields are hot-reloadable
+// through Control.SetConfig using the "ratelimit.*" keys.
+type RateLimitConfig struct {
+	HandshakesPerSec float64      // upgrades per client IP; excess gets HTTP 429
+	HandshakeBurst   int          // handshake bucket size (0 = ceil(rate))
+	FramesPerSec     float64      // inbound data frames per FrameKey; excess pauses reading
+	FrameBurst       int          // frame bucket size (0 = ceil(rate))
+	FrameKey         RateLimitKey // bucketing for frame limits (default session)
 }
 
 // DefaultConfig returns safe defaults optimized for throughput and latency.
@@ -45,3 +139,11 @@ func DefaultConfig() *Config {
 		MaxConnections:  10000, // Default 10k connections to prevent resource exhaustion
 	}
 }
+
+// clock returns Clock, or api.SystemClock if unset.
+func (c *Config) clock() api.Clock {
+	if c.Clock == nil {
+		return api.SystemClock
+	}
+	return c.Clock
+}
@@ -0,0 +1,126 @@
+// File: server/upgrade.go
+// Package server restarts the server binary without dropping connections.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrUpgradeUnsupported is returned by Upgrade where listening sockets cannot
+// be passed to another process.
+var ErrUpgradeUnsupported = errors.New("upgrade not supported on this platform")
+
+// Environment of a process started by Upgrade.
+const (
+	// EnvListeners lists, one per line, the addresses whose listening sockets
+	// the process inherits as descriptors 3, 4, ...
+	EnvListeners = "HIOLOAD_LISTENERS"
+
+	// EnvReadyFD is the descriptor the process writes a byte to once it accepts
+	// connections.
+	EnvReadyFD = "HIOLOAD_READY_FD"
+)
+
+// socket is a listening socket as bound, before any TLS wrapping.
+type socket struct {
+	addr string
+	ln   net.Listener
+}
+
+// upgradeCmd is the command Upgrade starts; empty path means this executable
+// with the current arguments.
+type upgradeCmd struct {
+	path string
+	args []string
+}
+
+// WithUpgradeCommand sets the binary and arguments Upgrade starts, e.g. a
+// newly deployed executable at another path. By default Upgrade re-executes
+// the running binary with os.Args.
+func WithUpgradeCommand(path string, args ...string) ServerOption {
+	return func(s *Server) {
+		s.upgrade = upgradeCmd{path: path, args: args}
+	}
+}
+
+// Upgrade restarts the server in a new process without downtime: it starts
+// the upgrade command with the listening sockets of every address, waits
+// until the new process accepts connections on them, then stops accepting,
+// waits for established connections to close and shuts down. Connections
+// still open when ctx is done are closed. The new process picks up the
+// sockets when its NewServer opens the same addresses, and signals readiness
+// from Run.
+//
+// An error means the hand-over failed, e.g. the new process exited or ctx
+// was done before it became ready; the server then keeps serving as before.
+// On Windows Upgrade returns ErrUpgradeUnsupported.
+func (s *Server) Upgrade(ctx context.Context) error {
+	if err := s.handOver(ctx); err != nil {
+		return err
+	}
+	for _, sk := range s.socketSet() {
+		if ul, ok := sk.ln.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false) // the path now belongs to the new process
+		}
+	}
+	s.StopAccepting()
+
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	for s.conns.current() > 0 {
+		select {
+		case <-ctx.Done():
+			s.Shutdown()
+			return nil
+		case <-tick.C:
+		}
+	}
+	s.Shutdown()
+	return nil
+}
+
+// upgradeEnv returns the environment for the new process, without the
+// hand-over variables this process was started with.
+func upgradeEnv() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, EnvListeners+"=") && !strings.HasPrefix(kv, EnvReadyFD+"=") {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// inherited holds what a process started by Upgrade received.
+var inherited struct {
+	once  sync.Once
+	ready atomic.Pointer[os.File]
+}
+
+// inheritedListeners returns the sockets handed over by the previous
+// process, keyed by address. They are handed out to the first caller only.
+func inheritedListeners() map[string]net.Listener {
+	var m map[string]net.Listener
+	inherited.once.Do(func() {
+		m = loadInherited()
+	})
+	return m
+}
+
+// signalReady tells the previous process that this one accepts connections.
+func signalReady() {
+	if f := inherited.ready.Swap(nil); f != nil {
+		f.Write([]byte{1})
+		f.Close()
+	}
+}
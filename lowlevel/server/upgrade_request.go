@@ -0,0 +1,62 @@
+// File: server/upgrade_request.go
+// Package server reads the upgrade request for the handshake checks.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package server
+
+import (
+	"net/http"
+
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// upgrade is the upgrade request of a handshake: the views parsed by the
+// listener, or the request given to ServeTransport. Fields are read from the
+// views; the *http.Request is built only for the checks, recorder and
+// negotiations that take one.
+type upgrade struct {
+	ur  *protocol.UpgradeRequest
+	req *http.Request
+}
+
+// has reports whether the request carries a non-empty field name.
+func (u *upgrade) has(name string) bool {
+	if u.req != nil {
+		return u.req.Header.Get(name) != ""
+	}
+	return len(u.ur.Header(name)) > 0
+}
+
+// header returns the value of the field name, "" if absent.
+func (u *upgrade) header(name string) string {
+	if u.req != nil {
+		return u.req.Header.Get(name)
+	}
+	return string(u.ur.Header(name))
+}
+
+// request returns the *http.Request, building it on first use.
+func (u *upgrade) request() (*http.Request, error) {
+	if u.req == nil {
+		req, err := u.ur.HTTPRequest()
+		if err != nil {
+			return nil, err
+		}
+		u.req = req
+	}
+	return u.req, nil
+}
+
+// negotiate returns fn applied to the request if it carries the field name,
+// "" otherwise, so requests not offering name are not built.
+func (u *upgrade) negotiate(name string, fn func(*http.Request) string) string {
+	if !u.has(name) {
+		return ""
+	}
+	req, err := u.request()
+	if err != nil {
+		return ""
+	}
+	return fn(req)
+}
@@ -0,0 +1,135 @@
+//go:build !windows
+// +build !windows
+
+// File: server/upgrade_unix.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Listening sockets are passed to the new process as inherited descriptors
+// (exec.Cmd.ExtraFiles), which both share with the kernel accept queue, so
+// no connection attempt is refused while the processes switch over.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// handOver starts the upgrade command with a snapshot of the listening
+// sockets and waits until it reports readiness.
+func (s *Server) handOver(ctx context.Context) error {
+	sockets := s.socketSet()
+	var files []*os.File
+	closeFiles := func() {
+		for _, f := range files {
+			f.Close()
+		}
+		files = nil
+	}
+	defer closeFiles()
+	defer restoreNonblock(sockets)
+
+	addrs := make([]string, len(sockets))
+	for i, sk := range sockets {
+		fl, ok := sk.ln.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("upgrade: listener %s cannot be handed over", sk.addr)
+		}
+		f, err := fl.File()
+		if err != nil {
+			return fmt.Errorf("upgrade: %s: %w", sk.addr, err)
+		}
+		files = append(files, f)
+		addrs[i] = sk.addr
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("upgrade: %w", err)
+	}
+	defer r.Close()
+	files = append(files, w)
+
+	path, args := s.upgrade.path, s.upgrade.args
+	if path == "" {
+		if path, err = os.Executable(); err != nil {
+			return fmt.Errorf("upgrade: %w", err)
+		}
+		args = os.Args[1:]
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(upgradeEnv(),
+		EnvListeners+"="+strings.Join(addrs, "\n"),
+		EnvReadyFD+"="+strconv.Itoa(3+len(addrs)))
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("upgrade: %w", err)
+	}
+	closeFiles() // the write end must close here for a crash to read as EOF
+
+	ready := make(chan error, 1)
+	go func() {
+		var b [1]byte
+		_, err := r.Read(b[:])
+		ready <- err
+	}()
+	select {
+	case err = <-ready:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("upgrade: new process not ready: %w", err)
+	}
+	return cmd.Process.Release()
+}
+
+// restoreNonblock puts the listening sockets back into non-blocking mode,
+// which passing their duplicates to exec clears: the descriptors share one
+// open file description, and a blocking accept would make Close hang.
+func restoreNonblock(sockets []socket) {
+	for _, sk := range sockets {
+		sc, ok := sk.ln.(syscall.Conn)
+		if !ok {
+			continue
+		}
+		if rc, err := sc.SyscallConn(); err == nil {
+			rc.Control(func(fd uintptr) { syscall.SetNonblock(int(fd), true) })
+		}
+	}
+}
+
+// loadInherited takes over the sockets listed in the environment and the
+// readiness pipe, removing both variables.
+func loadInherited() map[string]net.Listener {
+	list, ok := os.LookupEnv(EnvListeners)
+	if !ok {
+		return nil
+	}
+	readyFD := os.Getenv(EnvReadyFD)
+	os.Unsetenv(EnvListeners)
+	os.Unsetenv(EnvReadyFD)
+
+	m := make(map[string]net.Listener)
+	for i, addr := range strings.Split(list, "\n") {
+		f := os.NewFile(uintptr(3+i), addr)
+		ln, err := net.FileListener(f)
+		f.Close() // FileListener holds its own descriptor
+		if err == nil {
+			m[addr] = ln
+		}
+	}
+	if fd, err := strconv.Atoi(readyFD); err == nil {
+		inherited.ready.Store(os.NewFile(uintptr(fd), "ready"))
+	}
+	return m
+}
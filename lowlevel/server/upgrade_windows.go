@@ -0,0 +1,24 @@
+//go:build windows
+// +build windows
+
+// File: server/upgrade_windows.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Socket handles are not inherited through exec on Windows; passing them
+// would take WSADuplicateSocket and a side channel, so Upgrade is refused.
+
+package server
+
+import (
+	"context"
+	"net"
+)
+
+func (s *Server) handOver(context.Context) error {
+	return ErrUpgradeUnsupported
+}
+
+func loadInherited() map[string]net.Listener {
+	return nil
+}
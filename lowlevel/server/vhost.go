@@ -0,0 +1,151 @@
+// File: server/vhost.go
+// Package server serves several TLS server names on one listener.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// VirtualHost is a TLS server name served by an Endpoint with its own
+// certificate, selected by the name the client sends in SNI. Name is
+// "example.com", or "*.example.com" for any single label under example.com;
+// names are case-insensitive and an exact name takes precedence over a
+// wildcard. Handler, if set, handles the events of connections to the host
+// in place of the handler given to Run, behind the same middleware.
+type VirtualHost struct {
+	Name        string
+	Certificate tls.Certificate
+	Handler     api.Handler
+}
+
+// hostTable matches server names to the virtual hosts of one endpoint.
+type hostTable struct {
+	exact    map[string]*VirtualHost
+	wildcard map[string]*VirtualHost // keyed by the domain under "*."
+}
+
+// newHostTable indexes hosts by name, normalized to lower case.
+func newHostTable(hosts []VirtualHost) (*hostTable, error) {
+	t := &hostTable{exact: make(map[string]*VirtualHost), wildcard: make(map[string]*VirtualHost)}
+	for _, h := range hosts {
+		h.Name = strings.ToLower(strings.TrimSuffix(h.Name, "."))
+		if h.Name == "" || h.Name == "*" {
+			return nil, fmt.Errorf("virtual host %q: invalid name", h.Name)
+		}
+		if len(h.Certificate.Certificate) == 0 {
+			return nil, fmt.Errorf("virtual host %q: no certificate", h.Name)
+		}
+		m, key := t.exact, h.Name
+		if domain, ok := strings.CutPrefix(h.Name, "*."); ok {
+			m, key = t.wildcard, domain
+		}
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("virtual host %q: listed twice", h.Name)
+		}
+		m[key] = &h
+	}
+	return t, nil
+}
+
+// match returns the virtual host serving name, nil if none does.
+func (t *hostTable) match(name string) *VirtualHost {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if h, ok := t.exact[name]; ok {
+		return h
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		return t.wildcard[name[i+1:]]
+	}
+	return nil
+}
+
+// tlsConfig returns base, or an empty config, answering each client with the
+// certificate of the host it names. Clients naming no host, or sending no
+// SNI, get the certificates of base.
+func (t *hostTable) tlsConfig(base *tls.Config) *tls.Config {
+	cfg := &tls.Config{}
+	if base != nil {
+		cfg = base.Clone()
+	}
+	fallback := cfg.GetCertificate
+	cfg.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if h := t.match(hello.ServerName); h != nil {
+			return &h.Certificate, nil
+		}
+		if fallback != nil {
+			return fallback(hello)
+		}
+		return nil, nil // base Certificates, if any
+	}
+	return cfg
+}
+
+// bindHost records on conn the virtual host its client named, if any.
+func (t *hostTable) bindHost(conn *protocol.WSConnection) {
+	cs := conn.TLS()
+	if cs == nil {
+		return
+	}
+	if h := t.match(cs.ServerName); h != nil {
+		conn.SetVirtualHost(h.Name)
+	}
+}
+
+// addHostHandlers collects the handlers of t's hosts for Run. A host name
+// may carry a handler on one endpoint only.
+func (s *Server) addHostHandlers(t *hostTable) error {
+	for _, m := range []map[string]*VirtualHost{t.exact, t.wildcard} {
+		for _, h := range m {
+			if h.Handler == nil {
+				continue
+			}
+			if _, dup := s.hostHandlers[h.Name]; dup {
+				return fmt.Errorf("virtual host %q: handler set on several endpoints", h.Name)
+			}
+			if s.hostHandlers == nil {
+				s.hostHandlers = make(map[string]api.Handler)
+			}
+			s.hostHandlers[h.Name] = h.Handler
+		}
+	}
+	return nil
+}
+
+// hostDispatch hands events to the handler of their connection's virtual
+// host, or to def.
+type hostDispatch struct {
+	def   api.Handler
+	hosts map[string]api.Handler
+}
+
+// Handle implements api.Handler.
+func (d hostDispatch) Handle(data any) error {
+	if conn := eventConn(data); conn != nil {
+		if h, ok := d.hosts[conn.VirtualHost()]; ok {
+			return h.Handle(data)
+		}
+	}
+	return d.def.Handle(data)
+}
+
+// hostChain returns the chain Run dispatches to: the middleware around
+// handler and around each virtual host's handler.
+func (s *Server) hostChain(handler api.Handler) api.Handler {
+	chain := NewHandlerChain(handler, s.middleware...)
+	if len(s.hostHandlers) == 0 {
+		return chain
+	}
+	d := hostDispatch{def: chain, hosts: make(map[string]api.Handler, len(s.hostHandlers))}
+	for name, h := range s.hostHandlers {
+		d.hosts[name] = NewHandlerChain(h, s.middleware...)
+	}
+	return d
+}
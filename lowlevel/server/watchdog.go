@@ -0,0 +1,130 @@
+// File: server/watchdog.go
+// Package server reports handler calls that block the reactor.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package server
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/momentics/hioload-ws/control"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// ProbeSlowHandlers counts the handler calls that ran longer than
+// Config.SlowHandler, exposed under the "debug." prefix.
+const ProbeSlowHandlers = "handler.slow"
+
+// dispatchWatch tracks the handler call in progress on one reactor.
+type dispatchWatch struct {
+	seq   atomic.Uint64                         // incremented by every call
+	start atomic.Int64                          // UnixNano of the call in progress, 0 if none
+	conn  atomic.Pointer[protocol.WSConnection] // connection of the call's event, nil if none
+	gid   atomic.Uint64                         // goroutine of the reactor
+
+	reported uint64 // seq last reported, owned by the watchdog
+}
+
+// begin records the start of a handler call for data.
+func (w *dispatchWatch) begin(data any) {
+	if w.gid.Load() == 0 {
+		w.gid.Store(goroutineID())
+	}
+	w.conn.Store(eventConn(data))
+	w.seq.Add(1)
+	w.start.Store(time.Now().UnixNano())
+}
+
+// end records that the call returned.
+func (w *dispatchWatch) end() {
+	w.start.Store(0)
+}
+
+// watchHandlers checks the reactors' handler calls until shutdown, several
+// times per Config.SlowHandler.
+func (s *Server) watchHandlers(watches []*dispatchWatch) {
+	tick := time.NewTicker(max(s.cfg.SlowHandler/4, 10*time.Millisecond))
+	defer tick.Stop()
+	for {
+		select {
+		case <-s.shutdownCh:
+			return
+		case now := <-tick.C:
+			for _, w := range watches {
+				s.checkDispatch(w, now)
+			}
+		}
+	}
+}
+
+// checkDispatch reports the call in progress on w once if it has run longer
+// than Config.SlowHandler: it is counted in ProbeSlowHandlers and logged
+// with its route, session and the reactor's stack.
+func (s *Server) checkDispatch(w *dispatchWatch, now time.Time) {
+	seq := w.seq.Load()
+	start := w.start.Load()
+	if start == 0 || seq == w.reported {
+		return
+	}
+	elapsed := now.Sub(time.Unix(0, start))
+	if elapsed < s.cfg.SlowHandler {
+		return
+	}
+	conn := w.conn.Load()
+	if w.seq.Load() != seq {
+		return // the call returned and another began meanwhile
+	}
+	w.reported = seq
+	s.slowHandlers.Inc()
+
+	args := []any{"elapsed", elapsed}
+	if conn != nil {
+		args = append(args, "route", conn.Path())
+		if sess := conn.Session(); sess != nil {
+			args = append(args, "session", sess.ID())
+		}
+		if ra := conn.RemoteAddr(); ra != nil {
+			args = append(args, "remote", ra.String())
+		}
+	}
+	args = append(args, "stack", string(goroutineStack(w.gid.Load())))
+	control.Logger(control.LogServer).Warn("slow handler", args...)
+}
+
+// registerWatchdogProbes exposes ProbeSlowHandlers through control.
+func (s *Server) registerWatchdogProbes() {
+	s.control.RegisterDebugProbe(ProbeSlowHandlers, func() any { return &s.slowHandlers })
+}
+
+// goroutineID returns the ID of the calling goroutine.
+func goroutineID() uint64 {
+	var buf [64]byte
+	b := bytes.TrimPrefix(buf[:runtime.Stack(buf[:], false)], []byte("goroutine "))
+	id, _ := strconv.ParseUint(string(b[:bytes.IndexByte(b, ' ')]), 10, 64)
+	return id
+}
+
+// goroutineStack returns the stack of goroutine id, nil if it has exited.
+func goroutineStack(id uint64) []byte {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	header := []byte("goroutine " + strconv.FormatUint(id, 10) + " [")
+	for _, g := range bytes.Split(buf, []byte("\n\n")) {
+		if bytes.HasPrefix(g, header) {
+			return g
+		}
+	}
+	return nil
+}
@@ -0,0 +1,425 @@
+// File: mqtt/codec.go
+// Package mqtt
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Wire encoding of MQTT 3.1.1 control packets: a fixed header (type, flags,
+// variable-length remaining length) followed by the packet body.
+
+package mqtt
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// DefaultMaxPacketSize bounds packets accepted by a Decoder when no limit is
+// configured.
+const DefaultMaxPacketSize = 1 << 20
+
+// Decode parses the first packet in b and returns it with the number of bytes
+// consumed. It returns ErrIncomplete when b holds only part of a packet.
+func Decode(b []byte) (Packet, int, error) {
+	body, total, err := frameLen(b)
+	if err != nil {
+		return nil, 0, err
+	}
+	p, err := decodeBody(PacketType(b[0]>>4), b[0]&0x0f, b[total-body:total])
+	if err != nil {
+		return nil, 0, err
+	}
+	return p, total, nil
+}
+
+// frameLen reads the fixed header and returns the body length and the total
+// packet length.
+func frameLen(b []byte) (body, total int, err error) {
+	mult := 1
+	for i := 1; i <= 4; i++ {
+		if i >= len(b) {
+			return 0, 0, ErrIncomplete
+		}
+		body += int(b[i]&0x7f) * mult
+		if b[i]&0x80 == 0 {
+			total = i + 1 + body
+			if total > len(b) {
+				return body, total, ErrIncomplete
+			}
+			return body, total, nil
+		}
+		mult *= 128
+	}
+	return 0, 0, ErrMalformed
+}
+
+// reader walks a packet body.
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) byte() byte {
+	if r.err != nil || len(r.b) < 1 {
+		r.err = ErrMalformed
+		return 0
+	}
+	v := r.b[0]
+	r.b = r.b[1:]
+	return v
+}
+
+func (r *reader) uint16() uint16 {
+	if r.err != nil || len(r.b) < 2 {
+		r.err = ErrMalformed
+		return 0
+	}
+	v := binary.BigEndian.Uint16(r.b)
+	r.b = r.b[2:]
+	return v
+}
+
+// bytes reads a length-prefixed field; the result aliases the body.
+func (r *reader) bytes() []byte {
+	n := int(r.uint16())
+	if r.err != nil || len(r.b) < n {
+		r.err = ErrMalformed
+		return nil
+	}
+	v := r.b[:n:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *reader) string() string {
+	return string(r.bytes())
+}
+
+// decodeBody parses the body of a packet of type t with header flags.
+func decodeBody(t PacketType, flags byte, body []byte) (Packet, error) {
+	r := &reader{b: body}
+	var p Packet
+	switch t {
+	case TypeConnect:
+		p = decodeConnect(r)
+	case TypeConnAck:
+		ack := &ConnAck{SessionPresent: r.byte()&0x01 != 0}
+		ack.ReturnCode = r.byte()
+		p = ack
+	case TypePublish:
+		pub := &Publish{
+			Dup:    flags&0x08 != 0,
+			QoS:    (flags >> 1) & 0x03,
+			Retain: flags&0x01 != 0,
+		}
+		if pub.QoS > 2 {
+			return nil, ErrMalformed
+		}
+		pub.Topic = r.string()
+		if pub.QoS > 0 {
+			pub.PacketID = r.uint16()
+		}
+		pub.Payload, r.b = r.b, nil
+		p = pub
+	case TypePubAck, TypePubRec, TypePubRel, TypePubComp, TypeUnsubAck:
+		p = &Ack{Kind: t, PacketID: r.uint16()}
+	case TypeSubscribe:
+		sub := &Subscribe{PacketID: r.uint16()}
+		for r.err == nil && len(r.b) > 0 {
+			f := TopicFilter{Filter: r.string(), QoS: r.byte()}
+			if f.QoS > 2 {
+				return nil, ErrMalformed
+			}
+			sub.Filters = append(sub.Filters, f)
+		}
+		if len(sub.Filters) == 0 {
+			return nil, ErrMalformed
+		}
+		p = sub
+	case TypeSubAck:
+		ack := &SubAck{PacketID: r.uint16()}
+		ack.ReturnCodes, r.b = append([]byte(nil), r.b...), nil
+		p = ack
+	case TypeUnsubscribe:
+		unsub := &Unsubscribe{PacketID: r.uint16()}
+		for r.err == nil && len(r.b) > 0 {
+			unsub.Filters = append(unsub.Filters, r.string())
+		}
+		if len(unsub.Filters) == 0 {
+			return nil, ErrMalformed
+		}
+		p = unsub
+	case TypePingReq:
+		p = &PingReq{}
+	case TypePingResp:
+		p = &PingResp{}
+	case TypeDisconnect:
+		p = &Disconnect{}
+	default:
+		return nil, fmt.Errorf("%w: packet type %d", ErrMalformed, t)
+	}
+	if flags != fixedFlags(p) && t != TypePublish {
+		return nil, fmt.Errorf("%w: flags %#x for packet type %d", ErrMalformed, flags, t)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(r.b) != 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", ErrMalformed, len(r.b))
+	}
+	return p, nil
+}
+
+// decodeConnect parses a CONNECT body.
+func decodeConnect(r *reader) *Connect {
+	c := &Connect{ProtocolName: r.string(), ProtocolLevel: r.byte()}
+	flags := r.byte()
+	if flags&0x01 != 0 {
+		r.err = ErrMalformed // reserved bit
+	}
+	c.CleanSession = flags&0x02 != 0
+	c.KeepAlive = r.uint16()
+	c.ClientID = r.string()
+	if flags&0x04 != 0 {
+		c.WillQoS = (flags >> 3) & 0x03
+		c.WillRetain = flags&0x20 != 0
+		c.WillTopic = r.string()
+		c.WillMessage = append([]byte(nil), r.bytes()...)
+	}
+	if c.HasUsername = flags&0x80 != 0; c.HasUsername {
+		c.Username = r.string()
+	}
+	if c.HasPassword = flags&0x40 != 0; c.HasPassword {
+		c.Password = append([]byte(nil), r.bytes()...)
+	}
+	return c
+}
+
+// fixedFlags returns the header flags for p.
+func fixedFlags(p Packet) byte {
+	switch v := p.(type) {
+	case *Publish:
+		f := v.QoS << 1
+		if v.Dup {
+			f |= 0x08
+		}
+		if v.Retain {
+			f |= 0x01
+		}
+		return f
+	case *Subscribe, *Unsubscribe:
+		return 0x02
+	case *Ack:
+		if v.Kind == TypePubRel {
+			return 0x02
+		}
+	}
+	return 0
+}
+
+// Append encodes p and appends it to dst.
+func Append(dst []byte, p Packet) ([]byte, error) {
+	start := len(dst)
+	// Reserve room for the largest fixed header, then close the gap once the
+	// body length is known.
+	dst = append(dst, 0, 0, 0, 0, 0)
+	dst, err := appendBody(dst, p)
+	if err != nil {
+		return dst[:start], err
+	}
+	body := len(dst) - start - 5
+	if body > MaxRemainingLength {
+		return dst[:start], ErrTooLarge
+	}
+	var hdr [5]byte
+	hdr[0] = byte(p.Type())<<4 | fixedFlags(p)
+	n := 1
+	for {
+		d := byte(body % 128)
+		body /= 128
+		if body > 0 {
+			d |= 0x80
+		}
+		hdr[n] = d
+		n++
+		if body == 0 {
+			break
+		}
+	}
+	copy(dst[start:], hdr[:n])
+	copy(dst[start+n:], dst[start+5:])
+	return dst[:len(dst)-(5-n)], nil
+}
+
+// appendBody encodes the variable header and payload of p.
+func appendBody(dst []byte, p Packet) ([]byte, error) {
+	var err error
+	switch v := p.(type) {
+	case *Connect:
+		dst, err = appendConnect(dst, v)
+	case *ConnAck:
+		var sp byte
+		if v.SessionPresent {
+			sp = 1
+		}
+		dst = append(dst, sp, v.ReturnCode)
+	case *Publish:
+		if v.QoS > 2 || (v.QoS > 0 && v.PacketID == 0) || !ValidTopicName(v.Topic) {
+			return dst, ErrMalformed
+		}
+		dst, err = appendString(dst, v.Topic)
+		if v.QoS > 0 {
+			dst = binary.BigEndian.AppendUint16(dst, v.PacketID)
+		}
+		dst = append(dst, v.Payload...)
+	case *Ack:
+		dst = binary.BigEndian.AppendUint16(dst, v.PacketID)
+	case *Subscribe:
+		if len(v.Filters) == 0 {
+			return dst, ErrMalformed
+		}
+		dst = binary.BigEndian.AppendUint16(dst, v.PacketID)
+		for _, f := range v.Filters {
+			if dst, err = appendString(dst, f.Filter); err != nil {
+				break
+			}
+			dst = append(dst, f.QoS)
+		}
+	case *SubAck:
+		dst = binary.BigEndian.AppendUint16(dst, v.PacketID)
+		dst = append(dst, v.ReturnCodes...)
+	case *Unsubscribe:
+		if len(v.Filters) == 0 {
+			return dst, ErrMalformed
+		}
+		dst = binary.BigEndian.AppendUint16(dst, v.PacketID)
+		for _, f := range v.Filters {
+			if dst, err = appendString(dst, f); err != nil {
+				break
+			}
+		}
+	case *PingReq, *PingResp, *Disconnect:
+	default:
+		return dst, fmt.Errorf("%w: cannot encode %T", ErrMalformed, p)
+	}
+	return dst, err
+}
+
+// appendConnect encodes a CONNECT body.
+func appendConnect(dst []byte, c *Connect) ([]byte, error) {
+	name, level := c.ProtocolName, c.ProtocolLevel
+	if name == "" {
+		name, level = ProtocolName, ProtocolLevel
+	}
+	var flags byte
+	if c.CleanSession {
+		flags |= 0x02
+	}
+	if c.WillTopic != "" {
+		flags |= 0x04 | (c.WillQoS&0x03)<<3
+		if c.WillRetain {
+			flags |= 0x20
+		}
+	}
+	if c.HasUsername || c.Username != "" {
+		flags |= 0x80
+	}
+	if c.HasPassword || c.Password != nil {
+		flags |= 0x40
+	}
+	dst, err := appendString(dst, name)
+	dst = append(dst, level, flags)
+	dst = binary.BigEndian.AppendUint16(dst, c.KeepAlive)
+	for _, field := range [][]byte{
+		[]byte(c.ClientID),
+		condBytes(flags&0x04 != 0, []byte(c.WillTopic)),
+		condBytes(flags&0x04 != 0, c.WillMessage),
+		condBytes(flags&0x80 != 0, []byte(c.Username)),
+		condBytes(flags&0x40 != 0, c.Password),
+	} {
+		if field == nil || err != nil {
+			continue
+		}
+		dst, err = appendBytes(dst, field)
+	}
+	return dst, err
+}
+
+// condBytes returns b (never nil) when present, else nil.
+func condBytes(present bool, b []byte) []byte {
+	if !present {
+		return nil
+	}
+	if b == nil {
+		return []byte{}
+	}
+	return b
+}
+
+func appendString(dst []byte, s string) ([]byte, error) {
+	if len(s) > 0xffff {
+		return dst, ErrMalformed
+	}
+	dst = binary.BigEndian.AppendUint16(dst, uint16(len(s)))
+	return append(dst, s...), nil
+}
+
+func appendBytes(dst []byte, b []byte) ([]byte, error) {
+	if len(b) > 0xffff {
+		return dst, ErrMalformed
+	}
+	dst = binary.BigEndian.AppendUint16(dst, uint16(len(b)))
+	return append(dst, b...), nil
+}
+
+// Decoder reassembles packets from a byte stream split arbitrarily across
+// WebSocket messages.
+type Decoder struct {
+	buf []byte
+	off int
+	max int
+}
+
+// NewDecoder creates a Decoder rejecting packets larger than maxPacket bytes
+// (0 = DefaultMaxPacketSize).
+func NewDecoder(maxPacket int) *Decoder {
+	if maxPacket <= 0 {
+		maxPacket = DefaultMaxPacketSize
+	}
+	return &Decoder{max: maxPacket}
+}
+
+// Feed appends inbound bytes. Payloads of packets returned by earlier Next
+// calls must not be used afterwards: their storage may be reused.
+func (d *Decoder) Feed(b []byte) {
+	if d.off > 0 {
+		n := copy(d.buf, d.buf[d.off:])
+		d.buf, d.off = d.buf[:n], 0
+	}
+	d.buf = append(d.buf, b...)
+}
+
+// Next returns the next complete packet, or nil when more input is needed.
+func (d *Decoder) Next() (Packet, error) {
+	rest := d.buf[d.off:]
+	_, total, err := frameLen(rest)
+	if total > d.max {
+		return nil, ErrTooLarge
+	}
+	if err == ErrIncomplete {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	p, n, err := Decode(rest)
+	if err != nil {
+		return nil, err
+	}
+	d.off += n
+	return p, nil
+}
+
+// Buffered returns the number of bytes held for an incomplete packet.
+func (d *Decoder) Buffered() int {
+	return len(d.buf) - d.off
+}
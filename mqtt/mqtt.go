@@ -0,0 +1,165 @@
+// File: mqtt/mqtt.go
+// Package mqtt implements the MQTT 3.1.1 packet layer carried over the
+// "mqtt" WebSocket subprotocol.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// MQTT over WebSocket sends control packets in binary messages; a message may
+// hold several packets or only part of one, so inbound bytes go through a
+// Decoder that reassembles complete packets. Serve runs a broker-side session
+// on top of a negotiated connection and maps MQTT topics onto a pubsub.Broker,
+// so an IoT broker is a route plus one call:
+//
+//	srv := highlevel.NewServer(":1883", highlevel.WithSubprotocols(mqtt.Subprotocol))
+//	srv.HandleFunc("/mqtt", func(c *highlevel.Conn) { mqtt.Serve(c, broker) })
+
+package mqtt
+
+import "errors"
+
+// Subprotocol is the Sec-WebSocket-Protocol token for MQTT.
+const Subprotocol = "mqtt"
+
+// Protocol identification expected in CONNECT (MQTT 3.1.1).
+const (
+	ProtocolName  = "MQTT"
+	ProtocolLevel = 4
+)
+
+// PacketType is the control packet type from the fixed header.
+type PacketType byte
+
+// Control packet types.
+const (
+	TypeConnect     PacketType = 1
+	TypeConnAck     PacketType = 2
+	TypePublish     PacketType = 3
+	TypePubAck      PacketType = 4
+	TypePubRec      PacketType = 5
+	TypePubRel      PacketType = 6
+	TypePubComp     PacketType = 7
+	TypeSubscribe   PacketType = 8
+	TypeSubAck      PacketType = 9
+	TypeUnsubscribe PacketType = 10
+	TypeUnsubAck    PacketType = 11
+	TypePingReq     PacketType = 12
+	TypePingResp    PacketType = 13
+	TypeDisconnect  PacketType = 14
+)
+
+// CONNACK return codes.
+const (
+	Accepted                  byte = 0x00
+	RefusedProtocolVersion    byte = 0x01
+	RefusedIdentifierRejected byte = 0x02
+	RefusedServerUnavailable  byte = 0x03
+	RefusedBadCredentials     byte = 0x04
+	RefusedNotAuthorized      byte = 0x05
+)
+
+// SubAckFailure is the SUBACK return code for a rejected topic filter.
+const SubAckFailure byte = 0x80
+
+// MaxRemainingLength is the largest body the variable-length header encodes.
+const MaxRemainingLength = 268435455
+
+var (
+	// ErrIncomplete means the input does not yet hold a whole packet.
+	ErrIncomplete = errors.New("mqtt: incomplete packet")
+	// ErrMalformed is returned for packets violating the wire format.
+	ErrMalformed = errors.New("mqtt: malformed packet")
+	// ErrTooLarge is returned for packets above the configured size limit.
+	ErrTooLarge = errors.New("mqtt: packet too large")
+	// ErrProtocol is returned when a peer sends a packet out of sequence.
+	ErrProtocol = errors.New("mqtt: protocol violation")
+)
+
+// Packet is one decoded MQTT control packet.
+type Packet interface {
+	Type() PacketType
+}
+
+// Connect is the first packet a client sends.
+type Connect struct {
+	ProtocolName  string
+	ProtocolLevel byte
+	CleanSession  bool
+	KeepAlive     uint16 // seconds; 0 disables keep-alive
+	ClientID      string
+	WillTopic     string // "" when no will is set
+	WillMessage   []byte
+	WillQoS       byte
+	WillRetain    bool
+	Username      string
+	Password      []byte
+	HasUsername   bool
+	HasPassword   bool
+}
+
+// ConnAck answers Connect.
+type ConnAck struct {
+	SessionPresent bool
+	ReturnCode     byte
+}
+
+// Publish carries an application message. When decoded, Payload aliases the
+// input buffer and is only valid until that buffer is reused.
+type Publish struct {
+	Topic    string
+	PacketID uint16 // only for QoS > 0
+	QoS      byte
+	Retain   bool
+	Dup      bool
+	Payload  []byte
+}
+
+// Ack is a packet carrying only a packet identifier: PUBACK, PUBREC, PUBREL,
+// PUBCOMP or UNSUBACK, selected by Kind.
+type Ack struct {
+	Kind     PacketType
+	PacketID uint16
+}
+
+// TopicFilter is one SUBSCRIBE entry.
+type TopicFilter struct {
+	Filter string
+	QoS    byte
+}
+
+// Subscribe requests delivery for one or more topic filters.
+type Subscribe struct {
+	PacketID uint16
+	Filters  []TopicFilter
+}
+
+// SubAck answers Subscribe with one return code per filter: the granted QoS
+// or SubAckFailure.
+type SubAck struct {
+	PacketID    uint16
+	ReturnCodes []byte
+}
+
+// Unsubscribe cancels topic filters.
+type Unsubscribe struct {
+	PacketID uint16
+	Filters  []string
+}
+
+// PingReq, PingResp and Disconnect have no body.
+type (
+	PingReq    struct{}
+	PingResp   struct{}
+	Disconnect struct{}
+)
+
+// Type implements Packet.
+func (*Connect) Type() PacketType     { return TypeConnect }
+func (*ConnAck) Type() PacketType     { return TypeConnAck }
+func (*Publish) Type() PacketType     { return TypePublish }
+func (a *Ack) Type() PacketType       { return a.Kind }
+func (*Subscribe) Type() PacketType   { return TypeSubscribe }
+func (*SubAck) Type() PacketType      { return TypeSubAck }
+func (*Unsubscribe) Type() PacketType { return TypeUnsubscribe }
+func (*PingReq) Type() PacketType     { return TypePingReq }
+func (*PingResp) Type() PacketType    { return TypePingResp }
+func (*Disconnect) Type() PacketType  { return TypeDisconnect }
@@ -0,0 +1,281 @@
+// File: mqtt/serve.go
+// Package mqtt
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Serve is the broker side of one MQTT client connection. PUBLISH packets are
+// republished on a pubsub.Broker and subscriptions are served from it, so MQTT
+// clients share topics with every other broker user (WebSocket handlers,
+// bridges, adapters). Delivery to subscribers is QoS 0; inbound QoS 1 is
+// acknowledged once the message is handed to the broker. QoS 2, retained
+// messages and persistent sessions are not supported.
+
+package mqtt
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/momentics/hioload-ws/protocol"
+	"github.com/momentics/hioload-ws/pubsub"
+)
+
+// ErrRefused is returned by Serve when the CONNECT was answered with a
+// non-zero return code.
+var ErrRefused = errors.New("mqtt: connection refused")
+
+// errDisconnect ends Serve cleanly after a DISCONNECT packet.
+var errDisconnect = errors.New("mqtt: client disconnected")
+
+// binaryMessage is the WebSocket message type MQTT packets travel in.
+const binaryMessage = int(protocol.OpcodeBinary)
+
+// Transport is the message-oriented connection Serve runs on;
+// *highlevel.Conn satisfies it.
+type Transport interface {
+	ReadMessage() (messageType int, p []byte, err error)
+	WriteMessage(messageType int, data []byte) error
+	Close() error
+}
+
+// ServeOption configures Serve.
+type ServeOption func(*serverSession)
+
+// WithAuthenticator installs a CONNECT check returning a CONNACK return code;
+// Accepted admits the client.
+func WithAuthenticator(fn func(c *Connect) byte) ServeOption {
+	return func(s *serverSession) {
+		s.auth = fn
+	}
+}
+
+// WithMaxPacketSize bounds inbound packets (0 = DefaultMaxPacketSize).
+func WithMaxPacketSize(n int) ServeOption {
+	return func(s *serverSession) {
+		s.maxPacket = n
+	}
+}
+
+// WithQueue sets the per-subscription delivery queue and overflow policy.
+func WithQueue(n int, p pubsub.Policy) ServeOption {
+	return func(s *serverSession) {
+		s.subOpts = []pubsub.SubscribeOption{pubsub.WithQueueSize(n), pubsub.WithPolicy(p)}
+	}
+}
+
+// serverSession is the state of one client connection.
+type serverSession struct {
+	t         Transport
+	broker    *pubsub.Broker
+	auth      func(*Connect) byte
+	maxPacket int
+	subOpts   []pubsub.SubscribeOption
+
+	dec       *Decoder
+	connected bool
+	keepAlive time.Duration
+	will      *pubsub.Message
+	subs      map[string][]*pubsub.Subscription
+	wg        sync.WaitGroup
+
+	wmu  sync.Mutex
+	wbuf []byte
+}
+
+// Serve runs the MQTT session on t until the client disconnects or the
+// connection fails, then closes t. The client's will message, if any, is
+// published unless it sent DISCONNECT. A clean disconnect returns nil.
+func Serve(t Transport, b *pubsub.Broker, opts ...ServeOption) error {
+	s := &serverSession{t: t, broker: b, subs: make(map[string][]*pubsub.Subscription)}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.dec = NewDecoder(s.maxPacket)
+
+	err := s.run()
+	t.Close()
+	for filter := range s.subs {
+		s.unsubscribe(filter)
+	}
+	s.wg.Wait()
+	if err == errDisconnect {
+		return nil
+	}
+	if s.will != nil {
+		b.PublishMessage(*s.will)
+	}
+	return err
+}
+
+// run reads messages and dispatches the packets they carry.
+func (s *serverSession) run() error {
+	for {
+		if s.keepAlive > 0 {
+			// Spec: close after one and a half keep-alive periods of silence.
+			if d, ok := s.t.(interface{ SetReadDeadline(time.Time) error }); ok {
+				d.SetReadDeadline(time.Now().Add(s.keepAlive * 3 / 2))
+			}
+		}
+		mt, data, err := s.t.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if mt != binaryMessage {
+			return fmt.Errorf("%w: non-binary message", ErrProtocol)
+		}
+		s.dec.Feed(data)
+		for {
+			p, err := s.dec.Next()
+			if err != nil {
+				return err
+			}
+			if p == nil {
+				break
+			}
+			if err := s.handle(p); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handle processes one inbound packet.
+func (s *serverSession) handle(p Packet) error {
+	if c, ok := p.(*Connect); ok {
+		if s.connected {
+			return fmt.Errorf("%w: second CONNECT", ErrProtocol)
+		}
+		return s.connect(c)
+	}
+	if !s.connected {
+		return fmt.Errorf("%w: %T before CONNECT", ErrProtocol, p)
+	}
+	switch v := p.(type) {
+	case *Publish:
+		return s.publish(v)
+	case *Subscribe:
+		return s.subscribe(v)
+	case *Unsubscribe:
+		for _, f := range v.Filters {
+			s.unsubscribe(f)
+		}
+		return s.write(&Ack{Kind: TypeUnsubAck, PacketID: v.PacketID})
+	case *PingReq:
+		return s.write(&PingResp{})
+	case *Disconnect:
+		s.will = nil
+		return errDisconnect
+	case *Ack:
+		if v.Kind == TypePubAck {
+			return nil // outbound delivery is QoS 0; tolerate stray acks
+		}
+	}
+	return fmt.Errorf("%w: unexpected packet type %d", ErrProtocol, p.Type())
+}
+
+// connect validates CONNECT and answers it with CONNACK.
+func (s *serverSession) connect(c *Connect) error {
+	code := Accepted
+	switch {
+	case c.ProtocolName != ProtocolName || c.ProtocolLevel != ProtocolLevel:
+		code = RefusedProtocolVersion
+	case c.ClientID == "" && !c.CleanSession:
+		code = RefusedIdentifierRejected
+	case s.auth != nil:
+		code = s.auth(c)
+	}
+	if err := s.write(&ConnAck{ReturnCode: code}); err != nil {
+		return err
+	}
+	if code != Accepted {
+		return fmt.Errorf("%w: return code %d", ErrRefused, code)
+	}
+	s.connected = true
+	s.keepAlive = time.Duration(c.KeepAlive) * time.Second
+	if topic, ok := ToPubSubTopic(c.WillTopic); ok {
+		s.will = &pubsub.Message{Topic: topic, Payload: c.WillMessage}
+	}
+	return nil
+}
+
+// publish hands an inbound message to the broker.
+func (s *serverSession) publish(p *Publish) error {
+	if p.QoS > 1 {
+		return fmt.Errorf("%w: QoS 2 is not supported", ErrProtocol)
+	}
+	// Topics that cannot be mapped have no pubsub subscribers; drop them.
+	if topic, ok := ToPubSubTopic(p.Topic); ok {
+		s.broker.Publish(topic, append([]byte(nil), p.Payload...))
+	}
+	if p.QoS == 1 {
+		return s.write(&Ack{Kind: TypePubAck, PacketID: p.PacketID})
+	}
+	return nil
+}
+
+// subscribe registers each filter on the broker and answers with SUBACK.
+// Every accepted filter is granted QoS 0.
+func (s *serverSession) subscribe(p *Subscribe) error {
+	codes := make([]byte, len(p.Filters))
+	for i, f := range p.Filters {
+		s.unsubscribe(f.Filter)
+		patterns, ok := ToPubSubPatterns(f.Filter)
+		if !ok {
+			codes[i] = SubAckFailure
+			continue
+		}
+		subs := make([]*pubsub.Subscription, 0, len(patterns))
+		for _, pat := range patterns {
+			sub, err := s.broker.Subscribe(pat, s.subOpts...)
+			if err != nil {
+				codes[i] = SubAckFailure
+				break
+			}
+			subs = append(subs, sub)
+		}
+		if codes[i] == SubAckFailure {
+			for _, sub := range subs {
+				sub.Unsubscribe()
+			}
+			continue
+		}
+		for _, sub := range subs {
+			s.wg.Add(1)
+			go s.forward(sub)
+		}
+		s.subs[f.Filter] = subs
+	}
+	return s.write(&SubAck{PacketID: p.PacketID, ReturnCodes: codes})
+}
+
+// unsubscribe drops the broker subscriptions behind filter.
+func (s *serverSession) unsubscribe(filter string) {
+	for _, sub := range s.subs[filter] {
+		sub.Unsubscribe()
+	}
+	delete(s.subs, filter)
+}
+
+// forward delivers broker messages to the client until sub is closed.
+func (s *serverSession) forward(sub *pubsub.Subscription) {
+	defer s.wg.Done()
+	for msg := range sub.C() {
+		// Write errors mean the connection is going away; keep draining so
+		// the subscription can be closed without blocking.
+		s.write(&Publish{Topic: FromPubSubTopic(msg.Topic), Payload: msg.Payload})
+	}
+}
+
+// write encodes p and sends it as one binary message.
+func (s *serverSession) write(p Packet) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	buf, err := Append(s.wbuf[:0], p)
+	if err != nil {
+		return err
+	}
+	s.wbuf = buf
+	return s.t.WriteMessage(binaryMessage, buf)
+}
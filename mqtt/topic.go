@@ -0,0 +1,90 @@
+// File: mqtt/topic.go
+// Package mqtt
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// MQTT topics are "/"-separated with "+" (one level) and "#" (this level and
+// everything below) wildcards; pubsub topics are "."-separated with "*" and
+// ">". The mapping is one-to-one for topics whose levels are non-empty and
+// contain none of ".", "*" or ">"; other MQTT topics cannot be bridged.
+
+package mqtt
+
+import (
+	"strings"
+
+	"github.com/momentics/hioload-ws/pubsub"
+)
+
+// ValidTopicName reports whether name is a legal PUBLISH topic.
+func ValidTopicName(name string) bool {
+	return name != "" && len(name) <= 0xffff && !strings.ContainsAny(name, "+#\x00")
+}
+
+// ValidFilter reports whether filter is a legal SUBSCRIBE topic filter.
+func ValidFilter(filter string) bool {
+	if filter == "" || len(filter) > 0xffff || strings.IndexByte(filter, 0) >= 0 {
+		return false
+	}
+	levels := strings.Split(filter, "/")
+	for i, l := range levels {
+		if strings.ContainsAny(l, "+#") && len(l) > 1 {
+			return false
+		}
+		if l == "#" && i != len(levels)-1 {
+			return false
+		}
+	}
+	return true
+}
+
+// ToPubSubTopic maps an MQTT topic name to a pubsub topic.
+func ToPubSubTopic(name string) (string, bool) {
+	if !ValidTopicName(name) {
+		return "", false
+	}
+	levels := strings.Split(name, "/")
+	for _, l := range levels {
+		if !bridgeable(l) {
+			return "", false
+		}
+	}
+	return strings.Join(levels, "."), true
+}
+
+// ToPubSubPatterns maps an MQTT topic filter to the pubsub patterns covering
+// it. A trailing "#" also matches its parent level, so "a/#" needs both "a"
+// and "a.>".
+func ToPubSubPatterns(filter string) ([]string, bool) {
+	if !ValidFilter(filter) {
+		return nil, false
+	}
+	levels := strings.Split(filter, "/")
+	for i, l := range levels {
+		switch l {
+		case "+":
+			levels[i] = pubsub.WildcardOne
+		case "#":
+			levels[i] = pubsub.WildcardTail
+		default:
+			if !bridgeable(l) {
+				return nil, false
+			}
+		}
+	}
+	if len(levels) > 1 && levels[len(levels)-1] == pubsub.WildcardTail {
+		parent := strings.Join(levels[:len(levels)-1], ".")
+		return []string{parent, parent + "." + pubsub.WildcardTail}, true
+	}
+	return []string{strings.Join(levels, ".")}, true
+}
+
+// FromPubSubTopic maps a pubsub topic back to an MQTT topic name.
+func FromPubSubTopic(topic string) string {
+	return strings.ReplaceAll(topic, ".", "/")
+}
+
+// bridgeable reports whether a literal level survives the mapping.
+func bridgeable(level string) bool {
+	return level != "" && !strings.ContainsAny(level, ".*>")
+}
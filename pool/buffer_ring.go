@@ -13,7 +13,8 @@ import (
 	"github.com/momentics/hioload-ws/core/concurrency"
 )
 
-// BufferRing[T] implements api.Ring[T] with power-of-two capacity.
+// BufferRing[T] implements api.Ring[T] with power-of-two capacity. It wraps
+// the same ring as Ring[T], batch operations included; new code uses Ring.
 type BufferRing[T any] struct {
 	*concurrency.RingBuffer[T]
 }
@@ -6,7 +6,9 @@
 package pool
 
 import (
+	"sort"
 	"sync"
+	"sync/atomic"
 
 	"github.com/momentics/hioload-ws/api"
 	"github.com/momentics/hioload-ws/internal/normalize"
@@ -28,38 +30,124 @@ var sizeClasses = [...]int{
 }
 
 // sizeClassUpperBound returns the smallest class >= requested size.
-func sizeClassUpperBound(size int) int {
-	for _, c := range sizeClasses {
+func sizeClassUpperBound(classes []int, size int) int {
+	for _, c := range classes {
 		if size <= c {
 			return c
 		}
 	}
-	return sizeClasses[len(sizeClasses)-1] // fallback: biggest class
+	return classes[len(classes)-1] // fallback: biggest class
 }
 
 // BufferPoolManager manages all size-classed pools for all NUMA nodes.
 type BufferPoolManager struct {
 	nodeCnt int
 	nodes   []*nodeClassPools // per NUMA node
+	cfg     managerConfig
 }
 
 // nodeClassPools manages all size-class subpools for a given node.
 type nodeClassPools struct {
-	mu    sync.RWMutex
-	class map[int]*slabPool // maps size class -> slab pool
+	mu       sync.RWMutex
+	class    map[int]*slabPool // maps size class -> slab pool
+	cfg      *managerConfig
+	retained atomic.Int64 // bytes currently cached across this node's slabs
+}
+
+// managerConfig collects tunables applied to every slab created by a manager.
+type managerConfig struct {
+	lockMemory  bool  // pin slab pages in RAM (mlock / VirtualLock)
+	sizeClasses []int // ascending buffer size classes
+	nodeBudget  int64 // max bytes cached per NUMA node (0 = unlimited)
+	nodeCount   int   // NUMA layout override (0 = use caller/topology value)
+}
+
+// ManagerOption customizes a BufferPoolManager at construction time.
+type ManagerOption func(*managerConfig)
+
+// WithMemoryLock pins every slab allocated by the manager into physical memory
+// (mlock on Linux, MEM_COMMIT+VirtualLock on Windows), so buffers never page
+// out under memory pressure. Intended for latency-critical deployments; the
+// process needs a sufficient RLIMIT_MEMLOCK (Linux) or working-set quota
+// (Windows). If locking fails the buffer is still served, just unpinned.
+func WithMemoryLock(enabled bool) ManagerOption {
+	return func(c *managerConfig) {
+		c.lockMemory = enabled
+	}
+}
+
+// WithSizeClasses replaces the built-in size class table. Classes are sorted
+// ascending; non-positive entries are dropped. An empty list keeps the default.
+func WithSizeClasses(classes ...int) ManagerOption {
+	return func(c *managerConfig) {
+		out := make([]int, 0, len(classes))
+		for _, clz := range classes {
+			if clz > 0 {
+				out = append(out, clz)
+			}
+		}
+		if len(out) == 0 {
+			return
+		}
+		sort.Ints(out)
+		c.sizeClasses = out
+	}
+}
+
+// WithNodeBudget caps the number of bytes each NUMA node keeps cached in its
+// free lists. Buffers returned while the node is over budget are released to
+// the OS/GC instead of being pooled. Zero disables the cap.
+func WithNodeBudget(bytes int64) ManagerOption {
+	return func(c *managerConfig) {
+		c.nodeBudget = bytes
+	}
+}
+
+// WithNUMANodes overrides the NUMA layout (node count) the manager partitions
+// its pools by. Requests for nodes outside the layout wrap onto existing nodes.
+func WithNUMANodes(n int) ManagerOption {
+	return func(c *managerConfig) {
+		c.nodeCount = n
+	}
 }
 
 // NewBufferPoolManager initializes the global manager.
 // nodeCnt: number of NUMA nodes (from OS topology, >=1).
-func NewBufferPoolManager(nodeCnt int) *BufferPoolManager {
-	nodes := make([]*nodeClassPools, nodeCnt)
-	for i := 0; i < nodeCnt; i++ {
-		nodes[i] = &nodeClassPools{class: make(map[int]*slabPool)}
+func NewBufferPoolManager(nodeCnt int, opts ...ManagerOption) *BufferPoolManager {
+	m := &BufferPoolManager{}
+	for _, opt := range opts {
+		opt(&m.cfg)
+	}
+	if m.cfg.nodeCount > 0 {
+		nodeCnt = m.cfg.nodeCount
 	}
-	return &BufferPoolManager{
-		nodeCnt: nodeCnt,
-		nodes:   nodes,
+	if nodeCnt < 1 {
+		nodeCnt = 1
+	}
+	if len(m.cfg.sizeClasses) == 0 {
+		m.cfg.sizeClasses = sizeClasses[:]
+	}
+	m.nodeCnt = nodeCnt
+	m.nodes = make([]*nodeClassPools, nodeCnt)
+	for i := 0; i < nodeCnt; i++ {
+		m.nodes[i] = &nodeClassPools{class: make(map[int]*slabPool), cfg: &m.cfg}
 	}
+	return m
+}
+
+// NodeCount returns the number of NUMA nodes the manager partitions pools by.
+func (m *BufferPoolManager) NodeCount() int {
+	return m.nodeCnt
+}
+
+// SizeClasses returns a copy of the configured size class table.
+func (m *BufferPoolManager) SizeClasses() []int {
+	return append([]int(nil), m.cfg.sizeClasses...)
+}
+
+// MemoryLocked reports whether slabs from this manager are pinned in RAM.
+func (m *BufferPoolManager) MemoryLocked() bool {
+	return m.cfg.lockMemory
 }
 
 // getPreferredNUMANode unified with normalize.NUMANodeAuto for all BufferPool allocations.
@@ -70,8 +158,8 @@ func getPreferredNUMANode(numaPreferred int) int {
 // GetPool returns a NUMA-aware BufferPool for the requested buffer size,
 // routing all requests for sizes within a given class to the corresponding pool.
 func (m *BufferPoolManager) GetPool(size, numaPreferred int) api.BufferPool {
-	node := getPreferredNUMANode(numaPreferred)
-	clz := sizeClassUpperBound(size)
+	node := getPreferredNUMANode(numaPreferred) % m.nodeCnt
+	clz := sizeClassUpperBound(m.cfg.sizeClasses, size)
 	return m.nodes[node].getOrCreatePool(clz)
 }
 
@@ -88,7 +176,11 @@ func (n *nodeClassPools) getOrCreatePool(class int) api.BufferPool {
 	if pool, ok = n.class[class]; ok {
 		return pool
 	}
-	npool := newSlabPool(class)
+	npool := newSlabPool(class, n.cfg.lockMemory)
+	if n.cfg.nodeBudget > 0 {
+		npool.budget = n.cfg.nodeBudget
+		npool.retained = &n.retained
+	}
 	n.class[class] = npool
 	return npool
 }
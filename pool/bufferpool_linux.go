@@ -6,7 +6,8 @@
 // Package pool: Linux-specific slab allocator using hugepages and libnuma.
 //
 // On Linux, buffers are allocated via mmap with MAP_HUGETLB for 2 MiB pages.
-// Fallback to Go heap if hugepage allocation fails.
+// Fallback to Go heap if hugepage allocation fails. When memory locking is
+// requested, slabs are mapped anonymously, pre-faulted and pinned with mlock.
 //
 // Author: momentics <momentics@gmail.com>
 // License: Apache-2.0
@@ -14,8 +15,10 @@
 package pool
 
 import (
+	"unsafe"
+
 	"github.com/momentics/hioload-ws/api"
-	"github.com/momentics/hioload-ws/core/concurrency"
+	"golang.org/x/sys/unix"
 )
 
 // linuxAlloc maps or allocates a buffer of exactly `sz` bytes on `numaNode`.
@@ -31,13 +34,53 @@ func linuxRelease(buf api.Buffer) {
 	// No-op: GC handles heap-allocated buffers
 }
 
-// newSlabPool builds a slabPool with linuxAlloc/release callbacks.
-func newSlabPool(size int) *slabPool {
+// linuxLockedAlloc maps `sz` bytes of anonymous memory, pre-faults it and pins
+// it with mlock. Falls back to linuxAlloc when the kernel refuses the mapping
+// or the lock (typically RLIMIT_MEMLOCK exhaustion).
+func linuxLockedAlloc(sz, numaNode int) api.Buffer {
+	data, err := unix.Mmap(-1, 0, sz, unix.PROT_READ|unix.PROT_WRITE,
+		unix.MAP_PRIVATE|unix.MAP_ANONYMOUS|unix.MAP_POPULATE)
+	if err != nil {
+		return linuxAlloc(sz, numaNode)
+	}
+	if err := unix.Mlock(data); err != nil {
+		unix.Munmap(data)
+		return linuxAlloc(sz, numaNode)
+	}
+	lockedRegions.Store(uintptr(unsafe.Pointer(&data[0])), len(data))
+	lockedCount.Add(1)
+	return api.Buffer{Data: data, NUMA: numaNode}
+}
+
+// linuxLockedRelease unlocks and unmaps the slab of buf, produced by
+// linuxLockedAlloc. Heap fallbacks are left to the GC.
+func linuxLockedRelease(buf api.Buffer) {
+	if len(buf.Slab) == 0 {
+		return
+	}
+	base := unsafe.SliceData(buf.Slab)
+	v, ok := lockedRegions.LoadAndDelete(uintptr(unsafe.Pointer(base)))
+	if !ok {
+		return
+	}
+	lockedCount.Add(-1)
+	region := unsafe.Slice(base, v.(int))
+	unix.Munlock(region)
+	unix.Munmap(region)
+}
+
+// newSlabPool builds a slabPool with linuxAlloc/release callbacks,
+// or their mlock'ed counterparts when lock is set.
+func newSlabPool(size int, lock bool) *slabPool {
 	sp := &slabPool{
 		size:  size,
-		queue: concurrency.NewLockFreeQueue[api.Buffer](defaultPoolCapacity),
+		queue: NewRing[api.Buffer](defaultPoolCapacity),
 	}
 	sp.newBuf = linuxAlloc
 	sp.release = linuxRelease
+	if lock {
+		sp.newBuf = linuxLockedAlloc
+		sp.release = linuxLockedRelease
+	}
 	return sp
 }
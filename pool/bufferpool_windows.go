@@ -6,7 +6,8 @@
 // Package pool: Windows-specific slab allocator using VirtualAllocExNuma.
 //
 // Buffers are allocated with MEM_LARGE_PAGES on the specified NUMA node.
-// Fallback to Go heap on failure.
+// Fallback to Go heap on failure. When memory locking is requested, slabs are
+// committed with regular pages and pinned in the working set via VirtualLock.
 //
 // Author: momentics <momentics@gmail.com>
 // License: Apache-2.0
@@ -17,11 +18,9 @@ import (
 	"unsafe"
 
 	"github.com/momentics/hioload-ws/api"
-	"github.com/momentics/hioload-ws/core/concurrency"
 	"golang.org/x/sys/windows"
 )
 
-// windowsAlloc reserves `sz` bytes on `numaNode` via VirtualAllocExNuma.
 // windowsAlloc reserves `sz` bytes on `numaNode` via VirtualAllocExNuma.
 func windowsAlloc(sz, numaNode int) api.Buffer {
 	proc := windows.NewLazySystemDLL("kernel32.dll").NewProc("VirtualAllocExNuma")
@@ -37,12 +36,17 @@ func windowsAlloc(sz, numaNode int) api.Buffer {
 	if ret == 0 {
 		buf = api.Buffer{Data: make([]byte, sz), NUMA: numaNode}
 	} else {
-		data := unsafe.Slice((*byte)(unsafe.Pointer(ret)), sz)
-		buf = api.Buffer{Data: data, NUMA: numaNode}
+		buf = api.Buffer{Data: virtualSlice(ret, sz), NUMA: numaNode}
 	}
 	return buf
 }
 
+// virtualSlice returns the sz bytes VirtualAllocExNuma returned at addr,
+// memory the Go heap does not manage.
+func virtualSlice(addr uintptr, sz int) []byte {
+	return unsafe.Slice((*byte)(unsafe.Add(nil, addr)), sz)
+}
+
 // windowsRelease frees memory via VirtualFree.
 func windowsRelease(buf api.Buffer) {
 	if len(buf.Data) > 0 {
@@ -57,13 +61,59 @@ func windowsRelease(buf api.Buffer) {
 	}
 }
 
-// newSlabPool builds a slabPool with windowsAlloc/release callbacks.
-func newSlabPool(size int) *slabPool {
+// windowsLockedAlloc commits `sz` bytes on `numaNode` and pins them with VirtualLock.
+// Falls back to the Go heap when the commit or the lock is refused.
+func windowsLockedAlloc(sz, numaNode int) api.Buffer {
+	kernel32 := windows.NewLazySystemDLL("kernel32.dll")
+	ret, _, _ := kernel32.NewProc("VirtualAllocExNuma").Call(
+		uintptr(windows.CurrentProcess()),
+		0,
+		uintptr(sz),
+		uintptr(windows.MEM_RESERVE|windows.MEM_COMMIT),
+		uintptr(windows.PAGE_READWRITE),
+		uintptr(uint32(numaNode)),
+	)
+	if ret == 0 {
+		return api.Buffer{Data: make([]byte, sz), NUMA: numaNode}
+	}
+	if err := windows.VirtualLock(ret, uintptr(sz)); err != nil {
+		windows.VirtualFree(ret, 0, windows.MEM_RELEASE)
+		return api.Buffer{Data: make([]byte, sz), NUMA: numaNode}
+	}
+	data := virtualSlice(ret, sz)
+	lockedRegions.Store(uintptr(unsafe.Pointer(&data[0])), sz)
+	lockedCount.Add(1)
+	return api.Buffer{Data: data, NUMA: numaNode}
+}
+
+// windowsLockedRelease unlocks and frees the slab of buf, produced by
+// windowsLockedAlloc. Heap fallbacks are left to the GC.
+func windowsLockedRelease(buf api.Buffer) {
+	if len(buf.Slab) == 0 {
+		return
+	}
+	addr := uintptr(unsafe.Pointer(unsafe.SliceData(buf.Slab)))
+	v, ok := lockedRegions.LoadAndDelete(addr)
+	if !ok {
+		return
+	}
+	lockedCount.Add(-1)
+	windows.VirtualUnlock(addr, uintptr(v.(int)))
+	windows.VirtualFree(addr, 0, windows.MEM_RELEASE)
+}
+
+// newSlabPool builds a slabPool with windowsAlloc/release callbacks,
+// or their VirtualLock'ed counterparts when lock is set.
+func newSlabPool(size int, lock bool) *slabPool {
 	sp := &slabPool{
 		size:  size,
-		queue: concurrency.NewLockFreeQueue[api.Buffer](defaultPoolCapacity),
+		queue: NewRing[api.Buffer](defaultPoolCapacity),
 	}
 	sp.newBuf = windowsAlloc
 	sp.release = windowsRelease
+	if lock {
+		sp.newBuf = windowsLockedAlloc
+		sp.release = windowsLockedRelease
+	}
 	return sp
 }
@@ -1,22 +1,49 @@
+// File: pool/default.go
+// Package pool provides the process-wide, configurable BufferPoolManager.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
 package pool
 
 import (
+	"errors"
 	"sync"
 
 	"github.com/momentics/hioload-ws/api"
 	"github.com/momentics/hioload-ws/internal/concurrency"
 )
 
+// ErrDefaultConfigured is returned by ConfigureDefault once the default
+// manager has been built; its layout cannot change under live buffers.
+var ErrDefaultConfigured = errors.New("pool: default manager already initialized")
+
 var (
+	defaultMu   sync.Mutex
+	defaultOpts []ManagerOption
 	defaultOnce sync.Once
 	defaultMgr  *BufferPoolManager
 )
 
+// ConfigureDefault sets the options used to build the process-wide manager
+// (size classes, budgets, NUMA layout, memory locking). It must be called
+// before the first DefaultManager/DefaultPool call, typically from main.
+func ConfigureDefault(opts ...ManagerOption) error {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultMgr != nil {
+		return ErrDefaultConfigured
+	}
+	defaultOpts = append(defaultOpts[:0], opts...)
+	return nil
+}
+
 // DefaultManager returns a process-wide BufferPoolManager so all components
 // reuse the same NUMA-aware pools instead of fragmenting allocations.
 func DefaultManager() *BufferPoolManager {
 	defaultOnce.Do(func() {
-		defaultMgr = NewBufferPoolManager(concurrency.NUMANodes())
+		defaultMu.Lock()
+		defer defaultMu.Unlock()
+		defaultMgr = NewBufferPoolManager(concurrency.NUMANodes(), defaultOpts...)
 	})
 	return defaultMgr
 }
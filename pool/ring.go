@@ -0,0 +1,31 @@
+// File: pool/ring.go
+// Package pool exports the lock-free MPMC ring used by hioload-ws internals.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Ring[T] is a bounded multi-producer/multi-consumer FIFO: the
+// concurrency.RingBuffer the slab pools queue their free buffers on. Head
+// and tail live on separate cache lines so producers and consumers never
+// false-share. Batch operations claim a run of slots with a single CAS,
+// amortizing contention for pipeline stages.
+
+package pool
+
+import (
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/core/concurrency"
+)
+
+// Ring is a lock-free bounded MPMC queue with power-of-two capacity.
+type Ring[T any] struct {
+	*concurrency.RingBuffer[T]
+}
+
+// Ensure compile-time compliance.
+var _ api.Ring[any] = (*Ring[any])(nil)
+
+// NewRing creates a ring holding at least `capacity` items; the capacity is
+// rounded up to the next power of two (minimum 2).
+func NewRing[T any](capacity int) *Ring[T] {
+	return &Ring[T]{RingBuffer: concurrency.NewRingBuffer[T](uint64(max(capacity, 0)))}
+}
@@ -0,0 +1,43 @@
+// File: pool/shared.go
+// Package pool provides reference-counted buffer sharing.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package pool
+
+import (
+	"sync/atomic"
+
+	"github.com/momentics/hioload-ws/api"
+)
+
+// sharedRef returns the wrapped buffer to its pool on the last release.
+type sharedRef struct {
+	buf  api.Buffer
+	refs atomic.Int32
+}
+
+// Put drops one reference; the argument is ignored.
+func (s *sharedRef) Put(api.Buffer) {
+	if s.refs.Add(-1) == 0 {
+		s.buf.Release()
+	}
+}
+
+// Shared marks buffers returned by Share as read-only; see api.Buffer.Shared.
+func (s *sharedRef) Shared() bool { return true }
+
+// Share wraps buf so it can be handed to refs independent owners. The
+// returned Buffer views the same memory; each owner calls Release exactly
+// once and buf goes back to its pool after the last one. The owners must not
+// write to the memory; the returned Buffer reports Shared. refs <= 0 releases
+// buf immediately and returns an empty Buffer.
+func Share(buf api.Buffer, refs int) api.Buffer {
+	if refs <= 0 {
+		buf.Release()
+		return api.Buffer{}
+	}
+	s := &sharedRef{buf: buf}
+	s.refs.Store(int32(refs))
+	return api.Buffer{Data: buf.Data, NUMA: buf.NUMA, Class: buf.Class, Pool: s}
+}
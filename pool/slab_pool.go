@@ -10,7 +10,6 @@ import (
 	"sync/atomic"
 
 	"github.com/momentics/hioload-ws/api"
-	"github.com/momentics/hioload-ws/core/concurrency"
 )
 
 // slabPool: fixed-size buffer allocation per size class/NUMA node.
@@ -21,7 +20,11 @@ type slabPool struct {
 
 	// Queue takes the place of head/stack.
 	// We use a fixed capacity queue.
-	queue *concurrency.LockFreeQueue[api.Buffer]
+	queue *Ring[api.Buffer]
+
+	// Optional per-node retention budget shared by all slabs on the node.
+	budget   int64
+	retained *atomic.Int64
 
 	totalAlloc atomic.Uint64
 	totalFree  atomic.Uint64
@@ -30,6 +33,20 @@ type slabPool struct {
 
 const defaultPoolCapacity = 4096
 
+// lockedRegions maps the base address of every slab pinned by the platform's
+// locked allocator to its length, so release can tell pinned memory apart
+// from heap fallbacks.
+var lockedRegions sync.Map // uintptr -> int
+
+// lockedCount is the number of entries in lockedRegions.
+var lockedCount atomic.Int64
+
+// LockedSlabs returns the number of slabs pinned by pools with
+// WithMemoryLock that have not been unlocked and unmapped yet.
+func LockedSlabs() int {
+	return int(lockedCount.Load())
+}
+
 // nodeBuf removed - no longer needed.
 
 // numaMap: allocation counters by NUMA node.
@@ -57,6 +74,7 @@ func (m *numaMap) Get() map[int]uint64 {
 func (sp *slabPool) Get(_ int, numaNode int) api.Buffer {
 	// Try to dequeue from pool
 	if buf, ok := sp.queue.Dequeue(); ok {
+		sp.unreserve()
 		return buf
 	}
 
@@ -65,6 +83,7 @@ func (sp *slabPool) Get(_ int, numaNode int) api.Buffer {
 	// Direct struct field assignment (no type assertion needed)
 	buf.Pool = sp
 	buf.Class = sp.size
+	buf.Slab = buf.Data
 
 	sp.totalAlloc.Add(1)
 	mPtr := sp.numaStats.Load()
@@ -78,10 +97,23 @@ func (sp *slabPool) Get(_ int, numaNode int) api.Buffer {
 }
 
 func (sp *slabPool) Put(buf api.Buffer) {
-	// Try to enqueue to pool
-	if sp.queue.Enqueue(buf) {
-		sp.totalFree.Add(1)
-		return
+	// Callers often release a shortened Slice view; restore the full slab so
+	// the next Get does not hand out a truncated buffer.
+	data, ok := sp.slab(buf)
+	if !ok {
+		return // not one of ours, left to the GC
+	}
+	buf.Data, buf.Slab = data, data
+
+	// Try to enqueue to pool, unless the node is over its retention budget.
+	// The slab is charged to the budget first, so concurrent Puts cannot
+	// overshoot it, and the charge is rolled back if it does not fit.
+	if sp.reserve() {
+		if sp.queue.Enqueue(buf) {
+			sp.totalFree.Add(1)
+			return
+		}
+		sp.unreserve()
 	}
 
 	// Pool full, release
@@ -90,6 +122,39 @@ func (sp *slabPool) Put(buf api.Buffer) {
 	}
 }
 
+// reserve charges one slab to the node's retention budget, reporting
+// whether it fits.
+func (sp *slabPool) reserve() bool {
+	if sp.retained == nil {
+		return true
+	}
+	if sp.retained.Add(int64(sp.size)) > sp.budget {
+		sp.retained.Add(-int64(sp.size))
+		return false
+	}
+	return true
+}
+
+// unreserve returns a slab charged by reserve to the budget.
+func (sp *slabPool) unreserve() {
+	if sp.retained != nil {
+		sp.retained.Add(-int64(sp.size))
+	}
+}
+
+// slab returns the whole slab buf is a view of, from the slab Get recorded
+// in it; a buffer without one, e.g. made up by a caller, counts only if its
+// capacity still spans a slab from its first byte.
+func (sp *slabPool) slab(buf api.Buffer) ([]byte, bool) {
+	if len(buf.Slab) == sp.size {
+		return buf.Slab, true
+	}
+	if cap(buf.Data) >= sp.size {
+		return buf.Data[:sp.size], true
+	}
+	return nil, false
+}
+
 func (sp *slabPool) Stats() api.BufferPoolStats {
 	totalAlloc := int64(sp.totalAlloc.Load())
 	totalFree := int64(sp.totalFree.Load())
@@ -0,0 +1,86 @@
+// File: pool/slice_batch.go
+// Package pool provides a generic slice-backed api.Batch.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// SliceBatch is the general-purpose api.Batch implementation. Spans created
+// by Slice and Split share the parent's storage, so no items are copied.
+// Designed for single-goroutine use; no locks for minimal overhead.
+
+package pool
+
+import "github.com/momentics/hioload-ws/api"
+
+// SliceBatch is an api.Batch over a Go slice.
+type SliceBatch[T any] struct {
+	items []T
+}
+
+// Ensure compile-time interface compliance.
+var _ api.Batch[int] = (*SliceBatch[int])(nil)
+
+// NewSliceBatch creates an empty batch with capacity cap.
+func NewSliceBatch[T any](cap int) *SliceBatch[T] {
+	return &SliceBatch[T]{items: make([]T, 0, cap)}
+}
+
+// Append adds item to the batch.
+func (b *SliceBatch[T]) Append(item T) {
+	b.items = append(b.items, item)
+}
+
+// Len returns the number of items.
+func (b *SliceBatch[T]) Len() int {
+	return len(b.items)
+}
+
+// Get returns the item at index, or the zero value when out of range.
+func (b *SliceBatch[T]) Get(index int) T {
+	if index < 0 || index >= len(b.items) {
+		var zero T
+		return zero
+	}
+	return b.items[index]
+}
+
+// Slice returns a span sharing storage with b; bounds are clamped.
+func (b *SliceBatch[T]) Slice(start, end int) api.Batch[T] {
+	start, end = clampSpan(start, end, len(b.items))
+	return &SliceBatch[T]{items: b.items[start:end:end]}
+}
+
+// Underlying returns the backing slice.
+func (b *SliceBatch[T]) Underlying() []T {
+	return b.items
+}
+
+// Split divides the batch at idx into two spans sharing storage with b.
+func (b *SliceBatch[T]) Split(idx int) (first, second api.Batch[T]) {
+	return b.Slice(0, idx), b.Slice(idx, len(b.items))
+}
+
+// Reset clears the batch, keeping its capacity.
+func (b *SliceBatch[T]) Reset() {
+	var zero T
+	for i := range b.items {
+		b.items[i] = zero // drop references for the GC
+	}
+	b.items = b.items[:0]
+}
+
+// clampSpan limits [start, end) to [0, n].
+func clampSpan(start, end, n int) (int, int) {
+	if start < 0 {
+		start = 0
+	}
+	if end > n {
+		end = n
+	}
+	if end < 0 {
+		end = 0
+	}
+	if start > end {
+		start = end
+	}
+	return start, end
+}
@@ -8,9 +8,14 @@
 package protocol
 
 import (
+	"crypto/tls"
+	"errors"
 	// "fmt" // DEBUG
+	"net"
+	"net/http"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/momentics/hioload-ws/api"
 )
@@ -20,16 +25,30 @@ type WSConnection struct {
 	transport api.Transport  // Underlying I/O abstraction
 	bufPool   api.BufferPool // NUMA-aware buffer pool
 	path      string         // Request path for routing
+	session   api.Session    // Session bound by the server facade
+	subproto  string         // Negotiated Sec-WebSocket-Protocol ("" if none)
+	exts      string         // Negotiated Sec-WebSocket-Extensions ("" if none)
+	vhost     string         // Virtual host matched by TLS server name ("" if none)
+	header    http.Header    // Headers of the upgrade request (nil for client connections)
+	head      string         // Upgrade request head header is parsed from on first use
 
 	inbox  chan *WSFrame
 	outbox chan *WSFrame
 
+	// Send lanes ahead of outbox, see Priority
+	ctrlbox chan *WSFrame
+	highbox chan *WSFrame
+
 	mu      sync.RWMutex
 	handler api.Handler
 
 	done   chan struct{}
 	closed int32
 
+	linger     chan struct{} // closed by CloseAfterSend
+	lingerOnce sync.Once
+	goaway     chan struct{} // closed once closeFrame is set, see SendClose
+
 	// Internal queue for frames for RecvZeroCopy when recvLoop is running
 	recvQueue chan api.Buffer
 
@@ -37,10 +56,50 @@ type WSConnection struct {
 	bytesSent      int64
 	framesReceived int64
 	framesSent     int64
+	framesDropped  int64 // outbound frames discarded by the slow-consumer policy
+
+	// Bytes held, see MemUsage.
+	readHeld   int64
+	outboxHeld int64
+	inFlight   int64
+
+	// Compression: payload bytes of messages over the threshold and the
+	// bytes sent for them; compressed bytes received and their inflated size.
+	deflateIn, deflateOut int64
+	inflateIn, inflateOut int64
 
 	loopRunning int32 // Atomic flag (recv+send loops running)
 	sendRunning int32 // Atomic flag (send loop running)
-	readBuf     []byte
+
+	decoder *FrameDecoder // receive path decoder, see frameDecoder
+
+	readMu     sync.Mutex
+	readResume chan struct{} // non-nil while reading is paused, see PauseReading
+
+	sendObserver func(time.Duration)         // receives per-batch transport write times
+	tracer       atomic.Pointer[api.Logger]  // per-connection trace, see SetTrace
+	closeStatus  atomic.Pointer[closeStatus] // first close frame sent or received
+	closeFrame   atomic.Pointer[WSFrame]     // close frame queued by SendClose
+	strict       atomic.Bool                 // strict RFC 6455 validation, see SetStrict
+	compress     atomic.Bool                 // permessage-deflate negotiated
+	compressMin  atomic.Int64                // compression threshold, see SetCompressionThreshold
+	lastPong     atomic.Int64                // UnixNano of the last pong received, see LastPong
+	lastActive   atomic.Int64                // UnixNano of the last other frame received, see LastActivity
+	lastSent     atomic.Int64                // UnixNano of the last frame written, see LastSend
+	clock        api.Clock                   // time of keepalive and outbox timeouts, see SetClock
+	outboxLimit  atomic.Pointer[OutboxLimit] // slow-consumer policy, see SetOutboxLimit
+	writeTimeout atomic.Int64                // time.Duration, see SetWriteTimeout
+	writeBy      atomic.Int64                // UnixNano, see SetWriteDeadline; 0 = none
+
+	sendInterceptors atomic.Pointer[[]FrameInterceptor] // see AddSendInterceptor
+	recvInterceptors atomic.Pointer[[]FrameInterceptor] // see AddRecvInterceptor
+	observer         atomic.Pointer[FrameObserver]      // see SetFrameObserver
+}
+
+// closeStatus is the code and reason of a close handshake.
+type closeStatus struct {
+	code   uint16
+	reason string
 }
 
 var frameEncodePool = sync.Pool{
@@ -54,8 +113,13 @@ func NewWSConnection(tr api.Transport, pool api.BufferPool, channelSize int) *WS
 		bufPool:   pool,
 		inbox:     make(chan *WSFrame, channelSize),
 		outbox:    make(chan *WSFrame, channelSize),
+		ctrlbox:   make(chan *WSFrame, controlLaneSize),
+		highbox:   make(chan *WSFrame, channelSize),
 		done:      make(chan struct{}),
+		linger:    make(chan struct{}),
+		goaway:    make(chan struct{}),
 		recvQueue: make(chan api.Buffer, 64), // Queue for RecvZeroCopy
+		clock:     api.SystemClock,
 	}
 }
 
@@ -67,8 +131,13 @@ func NewWSConnectionWithPath(tr api.Transport, pool api.BufferPool, channelSize
 		path:      path,
 		inbox:     make(chan *WSFrame, channelSize),
 		outbox:    make(chan *WSFrame, channelSize),
+		ctrlbox:   make(chan *WSFrame, controlLaneSize),
+		highbox:   make(chan *WSFrame, channelSize),
 		done:      make(chan struct{}),
+		linger:    make(chan struct{}),
+		goaway:    make(chan struct{}),
 		recvQueue: make(chan api.Buffer, 64), // Queue for RecvZeroCopy
+		clock:     api.SystemClock,
 	}
 }
 
@@ -78,25 +147,171 @@ func (c *WSConnection) Transport() api.Transport {
 	return c.transport
 }
 
+// WrapTransport replaces the transport with what fn returns for it, e.g. a
+// recording wrapper. Call it before the connection starts reading or
+// sending, e.g. from a handshake hook.
+func (c *WSConnection) WrapTransport(fn func(api.Transport) api.Transport) {
+	c.transport = fn(c.transport)
+}
+
+// SetClock sets the clock of keepalive pings, pong times and outbox waits;
+// nil restores api.SystemClock. Like WrapTransport, call it before the
+// connection starts.
+func (c *WSConnection) SetClock(clk api.Clock) {
+	if clk == nil {
+		clk = api.SystemClock
+	}
+	c.clock = clk
+}
+
 // Path returns the original request path for routing purposes.
 func (c *WSConnection) Path() string {
 	return c.path
 }
 
+// RemoteAddr returns the peer address if the transport exposes one, else nil.
+func (c *WSConnection) RemoteAddr() net.Addr {
+	if ra, ok := c.transport.(interface{ RemoteAddr() net.Addr }); ok {
+		return ra.RemoteAddr()
+	}
+	return nil
+}
+
+// Session returns the session bound to this connection, or nil.
+func (c *WSConnection) Session() api.Session {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.session
+}
+
+// SetSession binds a session to this connection.
+func (c *WSConnection) SetSession(s api.Session) {
+	c.mu.Lock()
+	c.session = s
+	c.mu.Unlock()
+}
+
+// Subprotocol returns the negotiated Sec-WebSocket-Protocol, or "" if none.
+func (c *WSConnection) Subprotocol() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.subproto
+}
+
+// SetSubprotocol records the subprotocol agreed during the handshake.
+func (c *WSConnection) SetSubprotocol(p string) {
+	c.mu.Lock()
+	c.subproto = p
+	c.mu.Unlock()
+}
+
+// Extensions returns the negotiated Sec-WebSocket-Extensions, or "" if none.
+func (c *WSConnection) Extensions() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.exts
+}
+
+// SetExtensions records the extensions agreed during the handshake.
+func (c *WSConnection) SetExtensions(ext string) {
+	c.mu.Lock()
+	c.exts = ext
+	c.mu.Unlock()
+}
+
+// VirtualHost returns the name of the virtual host the server matched the
+// connection to by its TLS server name, or "" if none.
+func (c *WSConnection) VirtualHost() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.vhost
+}
+
+// SetVirtualHost records the virtual host matched during the handshake.
+func (c *WSConnection) SetVirtualHost(name string) {
+	c.mu.Lock()
+	c.vhost = name
+	c.mu.Unlock()
+}
+
+// RequestHeader returns the headers of the upgrade request the connection
+// was accepted with, or nil for client connections. It must not be modified.
+func (c *WSConnection) RequestHeader() http.Header {
+	c.mu.RLock()
+	h, head := c.header, c.head
+	c.mu.RUnlock()
+	if h != nil || head == "" {
+		return h
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.header == nil && c.head != "" {
+		if req, err := ParseRequestHead(c.head); err == nil {
+			c.header = req.Header
+		}
+		c.head = ""
+	}
+	return c.header
+}
+
+// SetRequestHeader records the headers of the upgrade request.
+func (c *WSConnection) SetRequestHeader(h http.Header) {
+	c.mu.Lock()
+	c.header, c.head = h, ""
+	c.mu.Unlock()
+}
+
+// SetRequestHead records the upgrade request head, see UpgradeRequest.Head;
+// RequestHeader parses it only when first called.
+func (c *WSConnection) SetRequestHead(head string) {
+	c.mu.Lock()
+	c.header, c.head = nil, head
+	c.mu.Unlock()
+}
+
+// TLS returns the TLS state if the transport exposes one, else nil.
+func (c *WSConnection) TLS() *tls.ConnectionState {
+	if t, ok := c.transport.(interface {
+		TLSConnectionState() (tls.ConnectionState, bool)
+	}); ok {
+		if cs, ok := t.TLSConnectionState(); ok {
+			return &cs
+		}
+	}
+	return nil
+}
+
 // BufferPool returns the buffer pool associated with this connection.
 func (c *WSConnection) BufferPool() api.BufferPool {
 	return c.bufPool
 }
 
-// RecvZeroCopy performs zero-copy receive:
-// If recvLoop is running (using recvQueue), reads from internal queue
-// Otherwise, reads directly from transport
-// RecvZeroCopy performs zero-copy receive by consuming the inbox.
-// It prioritizes valid flow control by ensuring the inbox is drained by the consumer.
-// RecvZeroCopy performs zero-copy receive:
+// Message is one received frame payload tagged with the frame's opcode
+// (OpcodeText, OpcodeBinary or OpcodeContinuation for data frames).
+type Message struct {
+	Opcode byte
+	Buf    api.Buffer
+}
+
+// RecvZeroCopy performs zero-copy receive and returns the payload buffers
+// only; use RecvMessages when the opcode matters.
+func (c *WSConnection) RecvZeroCopy() ([]api.Buffer, error) {
+	msgs, err := c.RecvMessages()
+	if err != nil || len(msgs) == 0 {
+		return nil, err
+	}
+	bufs := make([]api.Buffer, len(msgs))
+	for i, m := range msgs {
+		bufs[i] = m.Buf
+	}
+	return bufs, nil
+}
+
+// RecvMessages performs zero-copy receive:
 // If RecvLoop is running, it consumes the inbox (Blocking).
 // If RecvLoop is NOT running (Server mode), it reads directly from transport.
-func (c *WSConnection) RecvZeroCopy() ([]api.Buffer, error) {
+// Each buffer is returned with the opcode of the frame that carried it.
+func (c *WSConnection) RecvMessages() ([]Message, error) {
 	if atomic.LoadInt32(&c.loopRunning) == 1 {
 		// Loop Mode: Must consume inbox to prevent deadlock
 		select {
@@ -106,104 +321,136 @@ func (c *WSConnection) RecvZeroCopy() ([]api.Buffer, error) {
 				return nil, nil
 			}
 			if frame.Buf.Data != nil {
-				return []api.Buffer{frame.Buf}, nil
+				return []Message{{Opcode: frame.Opcode, Buf: c.hold(frame.Buf)}}, nil
 			}
 			payload := frame.Payload
 			if len(payload) > int(frame.PayloadLen) {
 				payload = payload[:frame.PayloadLen]
 			}
 			buf := c.bufPool.Get(len(payload), -1)
+			if len(buf.Data) < len(payload) { // the pool is out of memory
+				buf.Release()
+				buf = api.Buffer{Data: make([]byte, len(payload))}
+			}
 			dst := buf.Bytes()
 			if len(dst) > len(payload) {
 				dst = dst[:len(payload)]
 			}
 			copy(dst, payload)
-			return []api.Buffer{buf.Slice(0, len(dst))}, nil
+			c.trace("buffer acquired", "len", len(dst))
+			return []Message{{Opcode: frame.Opcode, Buf: c.hold(buf.Slice(0, len(dst)))}}, nil
 		case <-c.done:
 			return nil, api.ErrTransportClosed
 		}
 	} else {
 		// Direct Mode: Read from transport with Stream Reassembly
 		// fmt.Println("DEBUG: RecvZeroCopy Reading Transport")
-		raws, err := c.transport.Recv()
+		if err := c.waitReadable(); err != nil {
+			return nil, err
+		}
+		raws, err := c.recvSegments()
 		if err != nil {
 			// fmt.Printf("DEBUG: Direct Mode Transport Recv Error: %v\n", err)
+			c.decodeFailed(err)
 			return nil, err
 		}
 		// fmt.Printf("DEBUG: Server Recv got %d buffers\n", len(raws))
 
-		for _, raw := range raws {
-			c.readBuf = append(c.readBuf, raw...)
-		}
+		result := make([]Message, 0, 4)
+		active := false // a frame other than a pong arrived
+		collect := func(frame *WSFrame) error {
+			atomic.AddInt64(&c.framesReceived, 1)
+			atomic.AddInt64(&c.bytesReceived, frame.PayloadLen)
+			c.traceFrame("recv", frame)
+			active = active || frame.Opcode != OpcodePong
 
-		result := make([]api.Buffer, 0, 4)
-		for len(c.readBuf) > 0 {
-			frame, consumed, err := DecodeFrameFromBytes(c.readBuf)
-			if err != nil {
-				return nil, err
+			payload, code, reason := c.inboundPayload(frame, true)
+			keep := true
+			if code == 0 {
+				payload, keep, code, reason = c.interceptInbound(frame, payload)
 			}
-			if consumed == 0 {
-				break // Incomplete frame
+			if code != 0 {
+				frame.Buf.Release()
+				c.trace("protocol violation", "code", code, "reason", reason)
+				c.CloseWithCode(code, reason)
+				return ErrProtocolViolation
 			}
-
-			if frame.PayloadLen < 0 || frame.PayloadLen > MaxFramePayload {
-				c.readBuf = c.readBuf[consumed:]
-				continue
+			if !keep {
+				frame.Buf.Release()
+				return nil
 			}
-
-			atomic.AddInt64(&c.framesReceived, 1)
-			atomic.AddInt64(&c.bytesReceived, frame.PayloadLen)
-
-			payload := frame.Payload
-			if len(payload) > int(frame.PayloadLen) {
-				payload = payload[:frame.PayloadLen]
+			switch frame.Opcode {
+			case OpcodePing:
+				// Answered here as in loop mode; pings and pongs are not
+				// delivered to the application.
+				c.sendPong(payloadBuffer(frame, payload))
+				return nil
+			case OpcodePong:
+				c.lastPong.Store(c.clock.Now().UnixNano())
+				frame.Buf.Release()
+				return nil
+			case OpcodeClose:
+				c.noteCloseFrame(payload)
+				c.answerClose(payload)
 			}
-			buf := c.bufPool.Get(len(payload), -1)
-			dst := buf.Bytes()
-			if len(dst) > len(payload) {
-				dst = dst[:len(payload)]
+			c.trace("buffer acquired", "len", len(payload))
+			result = append(result, Message{Opcode: frame.Opcode, Buf: c.hold(payloadBuffer(frame, payload))})
+			return nil
+		}
+		for i, raw := range raws {
+			if err := c.frameDecoder().DecodeBuffer(raw, collect); err != nil {
+				releaseSegments(raws[i+1:])
+				for _, m := range result {
+					m.Buf.Release()
+				}
+				c.decodeFailed(err)
+				return nil, err
 			}
-			copy(dst, payload)
-			result = append(result, buf.Slice(0, len(dst)))
-
-			c.readBuf = c.readBuf[consumed:]
 		}
-
-		if len(c.readBuf) == 0 {
-			c.readBuf = nil
+		if active {
+			c.lastActive.Store(c.clock.Now().UnixNano())
 		}
-
+		c.noteReadBuffer()
 		return result, nil
 	}
 }
 
-// SendFrame enqueues a WSFrame for outbound transmission.
+// ErrCloseSent is returned for frames sent after SendClose queued the close
+// frame, which must be the last frame of the connection.
+var ErrCloseSent = errors.New("close frame already queued")
+
+// SendFrame enqueues a WSFrame for outbound transmission. It takes ownership
+// of frame.Buf, releasing it after encoding or on error.
 func (c *WSConnection) SendFrame(frame *WSFrame) error {
 	if atomic.LoadInt32(&c.closed) == 1 {
+		frame.Buf.Release()
+		c.trace("send on closed connection", "opcode", frame.Opcode)
 		return api.ErrTransportClosed
 	}
-
-	// Ensure send loop is running for batching.
-	if atomic.LoadInt32(&c.sendRunning) == 0 {
-		if atomic.CompareAndSwapInt32(&c.sendRunning, 0, 1) {
-			go c.sendLoop()
-		}
+	if c.closeFrame.Load() != nil {
+		frame.Buf.Release()
+		c.trace("send after close frame", "opcode", frame.Opcode)
+		return ErrCloseSent
 	}
+	c.traceFrame("send", frame)
+	frame, err := c.interceptOutbound(frame)
+	if frame == nil {
+		return err
+	}
+	c.stampDeadline(frame)
+
+	c.startSendLoop()
 
 	// If background loops are running, prefer queueing for batching.
 	if atomic.LoadInt32(&c.sendRunning) == 1 {
-		select {
-		case c.outbox <- frame:
-			return nil
-		case <-c.done:
-			return api.ErrTransportClosed
-		}
+		return c.enqueue(frame)
 	}
 
 	// Try to send directly via transport if sendLoop is not running
 	// Use masked encoding if this is a client connection (indicated by Masked field)
 	scratch := frameEncodePool.Get().([]byte)
-	data, err := EncodeFrameToBufferWithMask(frame, frame.Masked, scratch[:0])
+	data, err := EncodeFrameToBufferWithMask(c.outboundFrame(frame), frame.Masked, scratch[:0])
+	frame.Buf.Release()
 	if err != nil {
 		frameEncodePool.Put(scratch[:0])
 		return err
@@ -216,11 +463,134 @@ func (c *WSConnection) SendFrame(frame *WSFrame) error {
 	}
 	frameEncodePool.Put(data[:0])
 
+	c.lastSent.Store(c.clock.Now().UnixNano())
 	atomic.AddInt64(&c.framesSent, 1)
 	atomic.AddInt64(&c.bytesSent, frame.PayloadLen)
 	return nil
 }
 
+// startSendLoop starts the send loop unless it is running.
+func (c *WSConnection) startSendLoop() {
+	if atomic.LoadInt32(&c.sendRunning) == 0 {
+		if atomic.CompareAndSwapInt32(&c.sendRunning, 0, 1) {
+			go c.sendLoop()
+		}
+	}
+}
+
+// SendFrames encodes frames, one segment each, and queues them as a single
+// item of the normal send lane: the send loop writes them with one transport
+// Send, so batch-capable transports issue one vectored system call, in order
+// with the frames queued by SendFrame. Like SendFrame it returns once the
+// batch is queued, with the error of an interceptor, the encoding or the
+// queue. It takes ownership of the frames' buffers, releasing them once
+// encoded.
+func (c *WSConnection) SendFrames(frames []*WSFrame) error {
+	return c.queueBatch(frames, nil)
+}
+
+// WriteFrames is SendFrames waiting until the batch is written, returning the
+// error of the write or the connection as well.
+func (c *WSConnection) WriteFrames(frames []*WSFrame) error {
+	result := make(chan error, 1)
+	if err := c.queueBatch(frames, result); err != nil {
+		return err
+	}
+	select {
+	case err := <-result:
+		return err
+	case <-c.done:
+		select {
+		case err := <-result:
+			return err
+		default:
+			return api.ErrTransportClosed
+		}
+	}
+}
+
+// queueBatch encodes frames into one lane item for SendFrames and
+// WriteFrames. result, if not nil, receives the outcome of the write once
+// the batch is queued; nil at once if nothing is left to send.
+func (c *WSConnection) queueBatch(frames []*WSFrame, result chan error) error {
+	var err error
+	if atomic.LoadInt32(&c.closed) == 1 {
+		err = api.ErrTransportClosed
+	} else if c.closeFrame.Load() != nil {
+		err = ErrCloseSent
+	}
+	if err != nil {
+		for _, fr := range frames {
+			fr.Buf.Release()
+		}
+		c.trace("send on closing connection", "frames", len(frames))
+		return err
+	}
+
+	batch := &WSFrame{IsFinal: true}
+	for _, fr := range frames {
+		c.traceFrame("send", fr)
+		if err != nil {
+			fr.Buf.Release()
+			continue
+		}
+		if fr, err = c.interceptOutbound(fr); fr == nil {
+			continue
+		}
+		scratch := frameEncodePool.Get().([]byte)
+		var data []byte
+		data, err = EncodeFrameToBufferWithMask(c.outboundFrame(fr), fr.Masked, scratch[:0])
+		fr.Buf.Release()
+		if err != nil {
+			frameEncodePool.Put(scratch[:0])
+			continue
+		}
+		batch.batch = append(batch.batch, data)
+		batch.PayloadLen += fr.PayloadLen
+	}
+	if err == nil && len(batch.batch) == 0 {
+		if result != nil {
+			result <- nil
+		}
+		return nil
+	}
+	if err != nil {
+		putSegments(batch.batch)
+		return err
+	}
+	batch.result = result
+	c.stampDeadline(batch)
+	c.startSendLoop()
+	if err := c.enqueue(batch); err != nil {
+		putSegments(batch.batch)
+		return err
+	}
+	return nil
+}
+
+// putSegments returns encoded frames to frameEncodePool.
+func putSegments(segs [][]byte) {
+	for _, seg := range segs {
+		frameEncodePool.Put(seg[:0])
+	}
+}
+
+// EncodeOutbound encodes f into dst as the connection sends it: through the
+// send interceptors and compressed if negotiated. It is for callers that
+// write frames to the transport themselves, such as the client façade. Like
+// EncodeFrameToBufferWithMask the returned slice aliases dst; it is empty if
+// an interceptor dropped f. f.Buf is not released.
+func (c *WSConnection) EncodeOutbound(f *WSFrame, dst []byte) ([]byte, error) {
+	buf := f.Buf
+	f.Buf = api.Buffer{} // interceptOutbound releases it on a drop
+	out, err := c.interceptOutbound(f)
+	f.Buf = buf
+	if out == nil {
+		return dst[:0], err
+	}
+	return EncodeFrameToBufferWithMask(c.outboundFrame(out), out.Masked, dst)
+}
+
 // Start launches receive and send loops.
 func (c *WSConnection) Start() {
 	atomic.StoreInt32(&c.loopRunning, 1)
@@ -239,10 +609,90 @@ func (c *WSConnection) Close() error {
 	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
 		return nil
 	}
+	c.trace("state closed")
 	close(c.done)
 	return c.transport.Close()
 }
 
+// CloseWithCode writes a close frame carrying code and reason directly to the
+// transport, bypassing the outbox so it is not lost to the shutdown, and then
+// closes the connection. The frame is unmasked (server side). After
+// SendClose it only closes the connection.
+func (c *WSConnection) CloseWithCode(code uint16, reason string) error {
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return api.ErrTransportClosed
+	}
+	if c.closeFrame.Load() != nil {
+		return c.Close()
+	}
+	c.trace("state closing", "code", code, "reason", reason)
+	c.closeStatus.CompareAndSwap(nil, &closeStatus{code: code, reason: reason})
+	frame := NewCloseFrame(code, reason)
+	c.traceFrame("send", frame)
+	data, err := EncodeFrameToBytesWithMask(frame, false)
+	if err == nil {
+		err = c.transport.Send([][]byte{data})
+	}
+	c.Close()
+	return err
+}
+
+// SendClose queues a close frame carrying code and reason without closing
+// the connection. The send loop writes it after the frames already queued,
+// as the last frame of the connection (RFC 6455, section 5.5.1): SendFrame
+// fails with ErrCloseSent from now on. The peer's answer or Close closes the
+// connection.
+func (c *WSConnection) SendClose(code uint16, reason string) error {
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return api.ErrTransportClosed
+	}
+	frame := NewCloseFrame(code, reason)
+	c.stampDeadline(frame)
+	if !c.closeFrame.CompareAndSwap(nil, frame) {
+		return ErrCloseSent
+	}
+	c.trace("state closing", "code", code, "reason", reason)
+	c.closeStatus.CompareAndSwap(nil, &closeStatus{code: code, reason: reason})
+	atomic.AddInt64(&c.outboxHeld, frame.PayloadLen)
+	close(c.goaway)
+	c.startSendLoop()
+	return nil
+}
+
+// CloseAfterSend closes the connection once the send loop has written the
+// frames queued so far, e.g. the close frame of SendClose, instead of
+// discarding them as Close does. It returns at once.
+func (c *WSConnection) CloseAfterSend() {
+	if atomic.LoadInt32(&c.sendRunning) == 0 {
+		c.Close()
+		return
+	}
+	c.lingerOnce.Do(func() { close(c.linger) })
+}
+
+// answerClose completes the close handshake the peer started with payload,
+// as loop mode does: it echoes the status code, if any and valid, and
+// closes the connection. The close is still delivered to the application.
+// After SendClose the queued close frame is the answer.
+func (c *WSConnection) answerClose(payload []byte) {
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return
+	}
+	if c.closeFrame.Load() != nil {
+		c.CloseAfterSend()
+		return
+	}
+	reply := &WSFrame{IsFinal: true, Opcode: OpcodeClose}
+	if code, _, err := ParseClosePayload(payload); err == nil && code != CloseNoStatusRcvd {
+		reply = NewCloseFrame(code, "")
+	}
+	c.traceFrame("send", reply)
+	if data, err := EncodeFrameToBytesWithMask(reply, false); err == nil {
+		c.transport.Send([][]byte{data})
+	}
+	c.Close()
+}
+
 // Done returns channel closed when connection is closed.
 func (c *WSConnection) Done() <-chan struct{} {
 	return c.done
@@ -268,124 +718,261 @@ func (c *WSConnection) recvLoop() {
 	for {
 		select {
 		case <-c.done:
+			c.decodeFailed(api.ErrTransportClosed)
 			return
 		default:
-			raws, err := c.transport.Recv()
+			if c.waitReadable() != nil {
+				c.decodeFailed(api.ErrTransportClosed)
+				return
+			}
+			raws, err := c.recvSegments()
 			if err != nil {
 				// fmt.Printf("DEBUG: recvLoop transport error: %v\n", err)
 				// Transport error: terminate connection
+				c.decodeFailed(err)
 				return
 			}
 			if len(raws) > 0 {
 				// fmt.Printf("DEBUG: recvLoop got %d buffers, first len %d\n", len(raws), len(raws[0]))
 			}
 
-			for _, raw := range raws {
-				c.readBuf = append(c.readBuf, raw...)
-			}
-
-			for len(c.readBuf) > 0 {
-				frame, consumed, err := DecodeFrameFromBytes(c.readBuf)
-				if err != nil {
-					// fmt.Printf("DEBUG: Loop Decode Error: %v\n", err)
+			for i, raw := range raws {
+				if err := c.frameDecoder().DecodeBuffer(raw, c.dispatchFrame); err != nil {
+					releaseSegments(raws[i+1:])
+					c.decodeFailed(err)
 					return
 				}
-				if consumed == 0 {
-					break // Incomplete
-				}
+			}
+			c.noteReadBuffer()
+		}
+	}
+}
+
+// recvSegments reads the next segments from the transport, as the pooled
+// buffers they were read into if it is an api.BufferReceiver.
+func (c *WSConnection) recvSegments() ([]api.Buffer, error) {
+	if br, ok := c.transport.(api.BufferReceiver); ok {
+		return br.RecvBuffers()
+	}
+	raws, err := c.transport.Recv()
+	if err != nil {
+		return nil, err
+	}
+	segs := make([]api.Buffer, len(raws))
+	for i, raw := range raws {
+		segs[i] = api.Buffer{Data: raw}
+	}
+	return segs, nil
+}
 
-				// fmt.Printf("DEBUG: Loop Decoded frame, opcode=%d, payloadLen=%d\n", frame.Opcode, frame.PayloadLen)
+// releaseSegments returns segments left undecoded to their pools.
+func releaseSegments(segs []api.Buffer) {
+	for _, seg := range segs {
+		seg.Release()
+	}
+}
 
-				atomic.AddInt64(&c.framesReceived, 1)
-				atomic.AddInt64(&c.bytesReceived, frame.PayloadLen)
+// errStopRecv ends recvLoop once the connection is closing.
+var errStopRecv = errors.New("receive loop stopped")
 
-				// Preserve payload slice; caller may wrap in Buffer without extra copies.
-				frame.Buf = api.Buffer{Data: frame.Payload}
+// dispatchFrame handles a frame decoded by recvLoop: control frames inline,
+// data frames into the inbox and the handler.
+func (c *WSConnection) dispatchFrame(frame *WSFrame) error {
+	// fmt.Printf("DEBUG: Loop Decoded frame, opcode=%d, payloadLen=%d\n", frame.Opcode, frame.PayloadLen)
 
-				// Advance buffer immediately
-				c.readBuf = c.readBuf[consumed:]
+	atomic.AddInt64(&c.framesReceived, 1)
+	atomic.AddInt64(&c.bytesReceived, frame.PayloadLen)
+	c.traceFrame("recv", frame)
+	if frame.Opcode != OpcodePong {
+		c.lastActive.Store(c.clock.Now().UnixNano())
+	}
 
-				// Handle WebSocket control frames inlining
-				if c.handleControl(frame) {
-					continue
-				}
+	payload, code, reason := c.inboundPayload(frame, false)
+	keep := true
+	if code == 0 {
+		payload, keep, code, reason = c.interceptInbound(frame, payload)
+	}
+	if code != 0 {
+		frame.Buf.Release()
+		c.trace("protocol violation", "code", code, "reason", reason)
+		c.CloseWithCode(code, reason)
+		return errStopRecv
+	}
+	if !keep {
+		frame.Buf.Release()
+		return nil
+	}
+	// Preserve payload slice; caller may wrap in Buffer without extra copies.
+	frame.Buf = payloadBuffer(frame, payload)
+	frame.Payload, frame.PayloadLen = payload, int64(len(payload))
 
-				// Enqueue for application processing
-				select {
-				case c.inbox <- frame:
-					// fmt.Println("DEBUG: recvLoop pushed to inbox")
-				case <-c.done:
-					if frame.Buf.Data != nil {
-						frame.Buf.Release()
-					}
-					return
-				}
+	// Handle WebSocket control frames inlining
+	if c.handleControl(frame) {
+		return nil
+	}
 
-				// Invoke handler inline to avoid goroutine churn.
-				c.mu.RLock()
-				h := c.handler
-				c.mu.RUnlock()
+	// Enqueue for application processing
+	select {
+	case c.inbox <- frame:
+		// fmt.Println("DEBUG: recvLoop pushed to inbox")
+	case <-c.done:
+		frame.Buf.Release()
+		return errStopRecv
+	}
 
-				if h != nil && frame.PayloadLen <= MaxFramePayload && frame.PayloadLen >= 0 && frame.Buf.Data != nil {
-					buf := frame.Buf
-					h.Handle(buf)
-				}
-			}
+	// Invoke handler inline to avoid goroutine churn.
+	c.mu.RLock()
+	h := c.handler
+	c.mu.RUnlock()
 
-			if len(c.readBuf) == 0 {
-				c.readBuf = nil
-			}
-		}
+	if h != nil && frame.Buf.Data != nil {
+		h.Handle(frame.Buf)
 	}
+	return nil
+}
+
+// frameDecoder returns the connection's decoder, created on first use.
+func (c *WSConnection) frameDecoder() *FrameDecoder {
+	if c.decoder == nil {
+		c.decoder = NewFrameDecoder(c.bufPool)
+	}
+	return c.decoder
+}
+
+// SetReadBufferLimit bounds the bytes buffered for one incoming frame,
+// protocol.MaxFramePayload by default and at most. A peer announcing a larger
+// frame is closed with 1009 Message Too Big before any of it is buffered.
+// Call it before the connection starts reading, e.g. from a handshake hook.
+func (c *WSConnection) SetReadBufferLimit(n int) {
+	c.frameDecoder().SetLimit(n)
 }
 
-// sendLoop reads frames from outbox, encodes them to bytes, and calls
-// transport.Send. On send errors, it closes the connection.
+// decodeFailed ends the receive path after err: the partial frame's buffer
+// goes back to the pool, and an oversized frame is answered with 1009.
+func (c *WSConnection) decodeFailed(err error) {
+	if c.decoder != nil {
+		c.decoder.Reset()
+	}
+	atomic.StoreInt64(&c.readHeld, 0)
+	if errors.Is(err, ErrFrameTooLarge) {
+		c.trace("frame too large", "limit", c.decoder.limit)
+		c.CloseWithCode(CloseMessageTooBig, "frame too large")
+	}
+}
+
+// payloadBuffer returns the buffer carrying payload, the decoded or inflated
+// payload of frame, releasing the frame's buffer if it is not that.
+func payloadBuffer(frame *WSFrame, payload []byte) api.Buffer {
+	if len(payload) > 0 && len(frame.Buf.Data) > 0 && &payload[0] != &frame.Buf.Data[0] {
+		frame.Buf.Release()
+		return api.Buffer{Data: payload}
+	}
+	return frame.Buf.Slice(0, len(payload))
+}
+
+// sendLoop reads frames from the send lanes, control first, then high, then
+// outbox, encodes them to bytes, and calls transport.Send under the write
+// deadline of the batch, see SetWriteTimeout. On send errors, it closes the
+// connection, as it does once the lanes are empty after CloseAfterSend. The
+// close frame of SendClose is written once the lanes are empty, and frames
+// that still make it into a lane after it are dropped.
 func (c *WSConnection) sendLoop() {
 	const maxBatch = 32
 	type batchSlice [][]byte
 	var slicePool sync.Pool
 	slicePool.New = func() any { return make(batchSlice, 0, maxBatch) }
+	armed := false // a transport write deadline is set
+	goaway := c.goaway
+	var last *WSFrame // the close frame, once taken
 	for {
-		select {
-		case <-c.done:
-			return
-		case frame := <-c.outbox:
-			frames := []*WSFrame{frame}
-			// Drain additional frames to batch send.
-			for len(frames) < maxBatch {
-				select {
-				case f := <-c.outbox:
-					frames = append(frames, f)
-				default:
-					goto encode
-				}
+		frame := c.nextFrame()
+		if frame == nil && last == nil {
+			if last = c.closeFrame.Load(); last != nil {
+				frame, goaway = last, nil
 			}
-		encode:
-			out := slicePool.Get().(batchSlice)[:0]
-			for _, fr := range frames {
-				scratch := frameEncodePool.Get().([]byte)
-				data, err := EncodeFrameToBufferWithMask(fr, fr.Masked, scratch[:0])
-				if err != nil {
-					frameEncodePool.Put(scratch[:0])
+		}
+		if frame == nil {
+			select {
+			case <-c.done:
+				return
+			case frame = <-c.ctrlbox:
+			case frame = <-c.highbox:
+			case frame = <-c.outbox:
+			case <-goaway:
+				continue // the next pass takes the close frame
+			case <-c.linger:
+				if last == nil && c.closeFrame.Load() != nil {
+					continue
+				}
+				if frame = c.nextFrame(); frame == nil {
 					c.Close()
 					return
 				}
-				out = append(out, data)
 			}
-			if err := c.transport.Send(out); err != nil {
-				for _, buf := range out {
-					frameEncodePool.Put(buf[:0])
-				}
-				slicePool.Put(out[:0])
+		}
+		if last != nil && frame != last {
+			c.dropFrame(frame)
+			continue
+		}
+		frames := []*WSFrame{frame}
+		// Drain additional frames to batch send, by priority.
+		for len(frames) < maxBatch && frame != last {
+			f := c.nextFrame()
+			if f == nil {
+				break
+			}
+			frames = append(frames, f)
+		}
+
+		var payload int64
+		for _, fr := range frames {
+			payload += fr.PayloadLen
+		}
+		atomic.AddInt64(&c.outboxHeld, -payload)
+
+		out := slicePool.Get().(batchSlice)[:0]
+		for _, fr := range frames {
+			if fr.batch != nil {
+				out = append(out, fr.batch...) // encoded by WriteFrames
+				continue
+			}
+			scratch := frameEncodePool.Get().([]byte)
+			data, err := EncodeFrameToBufferWithMask(c.outboundFrame(fr), fr.Masked, scratch[:0])
+			fr.Buf.Release()
+			c.trace("buffer released", "len", fr.PayloadLen)
+			if err != nil {
+				frameEncodePool.Put(scratch[:0])
 				c.Close()
 				return
 			}
-			for _, buf := range out {
-				frameEncodePool.Put(buf[:0])
+			out = append(out, data)
+		}
+		var err error
+		if armed, err = c.armWrite(batchDeadline(frames), armed); err == nil {
+			var start time.Time
+			if c.sendObserver != nil {
+				start = time.Now()
+			}
+			err = c.transport.Send(out)
+			if c.sendObserver != nil {
+				c.sendObserver(time.Since(start))
+			}
+		}
+		c.trace("batch written", "frames", len(out), "err", err)
+		for _, fr := range frames {
+			if fr.result != nil {
+				fr.result <- err
 			}
-			slicePool.Put(out[:0])
 		}
+		putSegments(out)
+		slicePool.Put(out[:0])
+		if err != nil {
+			c.Close()
+			return
+		}
+		c.lastSent.Store(c.clock.Now().UnixNano())
+		atomic.AddInt64(&c.framesSent, int64(len(out)))
+		atomic.AddInt64(&c.bytesSent, payload)
 	}
 }
 
@@ -394,22 +981,32 @@ func (c *WSConnection) sendLoop() {
 func (c *WSConnection) handleControl(frame *WSFrame) bool {
 	switch frame.Opcode {
 	case OpcodePing:
-		// Immediately respond with Pong using same payload
+		// Immediately respond with Pong using same payload, copied so the
+		// ping's buffer can go back at once.
 		pong := &WSFrame{
 			IsFinal:    true,
 			Opcode:     OpcodePong,
 			PayloadLen: frame.PayloadLen,
-			Payload:    frame.Payload,
+			Payload:    append([]byte(nil), frame.Payload...),
 		}
+		frame.Buf.Release()
 		c.SendFrame(pong)
 		return true
 
 	case OpcodePong:
-		// Pong acknowledged; metrics can track latency here
+		c.lastPong.Store(c.clock.Now().UnixNano())
+		frame.Buf.Release()
 		return true
 
 	case OpcodeClose:
-		// Echo close and shutdown
+		// Echo close and shutdown, or finish sending the close frame queued
+		// by SendClose.
+		c.noteCloseFrame(frame.Payload)
+		if c.closeFrame.Load() != nil {
+			frame.Buf.Release()
+			c.CloseAfterSend()
+			return true
+		}
 		c.SendFrame(frame)
 		c.Close()
 		return true
@@ -419,6 +1016,31 @@ func (c *WSConnection) handleControl(frame *WSFrame) bool {
 	}
 }
 
+// SetSendObserver installs fn to receive the duration of every batched
+// transport write. It must be called before the connection starts sending,
+// e.g. from a handshake hook.
+func (c *WSConnection) SetSendObserver(fn func(time.Duration)) {
+	c.sendObserver = fn
+}
+
+// CloseStatus returns the code and reason of the first close frame sent via
+// CloseWithCode or received from the peer. ok is false if the connection has
+// not exchanged a close frame, i.e. it is open or was dropped abnormally.
+func (c *WSConnection) CloseStatus() (code uint16, reason string, ok bool) {
+	if st := c.closeStatus.Load(); st != nil {
+		return st.code, st.reason, true
+	}
+	return 0, "", false
+}
+
+// noteCloseFrame records a received close payload; an empty body means
+// CloseNoStatusRcvd.
+func (c *WSConnection) noteCloseFrame(payload []byte) {
+	st := &closeStatus{}
+	st.code, st.reason, _ = ParseClosePayload(payload)
+	c.closeStatus.CompareAndSwap(nil, st)
+}
+
 // GetStats returns a snapshot of connection statistics for metrics reporting.
 func (c *WSConnection) GetStats() map[string]int64 {
 	return map[string]int64{
@@ -426,5 +1048,14 @@ func (c *WSConnection) GetStats() map[string]int64 {
 		"bytes_sent":      atomic.LoadInt64(&c.bytesSent),
 		"frames_received": atomic.LoadInt64(&c.framesReceived),
 		"frames_sent":     atomic.LoadInt64(&c.framesSent),
+		"frames_dropped":  atomic.LoadInt64(&c.framesDropped),
+		"deflate_in":      atomic.LoadInt64(&c.deflateIn),
+		"deflate_out":     atomic.LoadInt64(&c.deflateOut),
+		"inflate_in":      atomic.LoadInt64(&c.inflateIn),
+		"inflate_out":     atomic.LoadInt64(&c.inflateOut),
+		"outbox_depth":    int64(len(c.outbox) + len(c.highbox) + len(c.ctrlbox)),
+		"outbox_bytes":    atomic.LoadInt64(&c.outboxHeld),
+		"read_buffered":   atomic.LoadInt64(&c.readHeld),
+		"bytes_in_flight": atomic.LoadInt64(&c.inFlight),
 	}
 }
@@ -21,6 +21,8 @@ const (
 	// Bit masks
 	FinBit  = 0x80
 	MaskBit = 0x80
+	Rsv1Bit = 0x40 // permessage-deflate compressed message
+	RsvBits = 0x70 // RSV1-3
 
 	// Close codes
 	CloseNormalClosure      = 1000
@@ -34,4 +36,5 @@ const (
 	CloseMessageTooBig      = 1009
 	CloseMissingExtension   = 1010
 	CloseInternalServerErr  = 1011
+	CloseTryAgainLater      = 1013
 )
@@ -0,0 +1,87 @@
+// File: protocol/deadline.go
+// Package protocol bounds the writes of a WSConnection by a timeout.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package protocol
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+)
+
+// ErrWriteTimeout is returned by SendFrame when a frame could not be queued
+// within the write timeout, see SetWriteTimeout.
+var ErrWriteTimeout = fmt.Errorf("websocket write timeout: %w", api.ErrOperationTimeout)
+
+// writeDeadliner is implemented by transports whose writes take a deadline.
+type writeDeadliner interface {
+	SetWriteDeadline(time.Time) error
+}
+
+// SetWriteTimeout bounds each frame passed to SendFrame afterwards, from
+// the call until its transport write completes (0 = no bound). SendFrame
+// fails with ErrWriteTimeout if the frame cannot be queued in time. The send
+// loop writes each batch under the transport write deadline of its earliest
+// frame and closes the connection once that passes, also when a frame
+// expired while queued: a peer that let it wait so long is not reading.
+// Transports without SetWriteDeadline only bound the time spent queued.
+func (c *WSConnection) SetWriteTimeout(d time.Duration) {
+	c.writeTimeout.Store(int64(d))
+}
+
+// SetWriteDeadline bounds each frame passed to SendFrame afterwards by t
+// too, whichever of it and the write timeout comes first (zero = no
+// deadline). Unlike the timeout, t is the same for every frame. The
+// connection's Clock tells when t has passed.
+func (c *WSConnection) SetWriteDeadline(t time.Time) {
+	var n int64
+	if !t.IsZero() {
+		n = t.UnixNano()
+	}
+	c.writeBy.Store(n)
+}
+
+// stampDeadline sets the write deadline of frame: the earlier of the write
+// deadline and the write timeout from now.
+func (c *WSConnection) stampDeadline(frame *WSFrame) {
+	by := c.writeBy.Load()
+	if d := time.Duration(c.writeTimeout.Load()); d > 0 {
+		if n := c.clock.Now().Add(d).UnixNano(); by == 0 || n < by {
+			by = n
+		}
+	}
+	frame.sendBy = by
+}
+
+// batchDeadline returns the earliest write deadline of frames, the zero time
+// if none has one.
+func batchDeadline(frames []*WSFrame) time.Time {
+	var first int64
+	for _, f := range frames {
+		if f.sendBy != 0 && (first == 0 || f.sendBy < first) {
+			first = f.sendBy
+		}
+	}
+	if first == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, first)
+}
+
+// armWrite sets the transport write deadline of a batch due by deadline,
+// clearing the one of an earlier batch if armed is set. It reports whether
+// a deadline is now set, or ErrWriteTimeout if deadline already passed.
+func (c *WSConnection) armWrite(deadline time.Time, armed bool) (bool, error) {
+	if !deadline.IsZero() && !c.clock.Now().Before(deadline) {
+		return armed, ErrWriteTimeout
+	}
+	ds, ok := c.transport.(writeDeadliner)
+	if !ok || (deadline.IsZero() && !armed) {
+		return false, nil
+	}
+	ds.SetWriteDeadline(deadline)
+	return !deadline.IsZero(), nil
+}
@@ -0,0 +1,169 @@
+// File: protocol/deflate.go
+// Package protocol implements permessage-deflate (RFC 7692).
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Only the stateless variant is negotiated: both directions use
+// no_context_takeover, so every message is compressed on its own and
+// connections keep no per-direction flate state between messages.
+
+package protocol
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	// HeaderSecWebSocketExt carries extension offers and the accepted one.
+	HeaderSecWebSocketExt = "Sec-WebSocket-Extensions"
+	// ExtPermessageDeflate is the permessage-deflate extension token.
+	ExtPermessageDeflate = "permessage-deflate"
+	// DeflateThreshold is the smallest payload compressed on send; shorter
+	// messages rarely shrink and go out uncompressed.
+	DeflateThreshold = 128
+)
+
+// deflateResponse is the accepted extension: no context takeover either way.
+const deflateResponse = ExtPermessageDeflate + "; server_no_context_takeover; client_no_context_takeover"
+
+// DeflateOffer is the permessage-deflate offer a client sends: the stateless
+// variant the codec implements, so a server accepting it answers with
+// server_no_context_takeover.
+const DeflateOffer = ExtPermessageDeflate + "; server_no_context_takeover; client_no_context_takeover"
+
+// ErrDeflateResponse is returned by AcceptDeflate for an extension response
+// the client did not offer or cannot honor.
+var ErrDeflateResponse = errors.New("unsupported Sec-WebSocket-Extensions response")
+
+// deflateTail is removed from every compressed message (RFC 7692 7.2.1) and
+// restored, followed by a final empty block, before inflating.
+var (
+	deflateTail    = []byte{0x00, 0x00, 0xff, 0xff}
+	inflateTrailer = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+)
+
+// ErrDeflateTooLarge is returned when a message inflates past MaxFramePayload.
+var ErrDeflateTooLarge = errors.New("decompressed message exceeds maximum allowed size")
+
+var flateWriterPool = sync.Pool{
+	New: func() any {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	},
+}
+
+// NegotiateDeflate inspects the client's Sec-WebSocket-Extensions offers and
+// returns the response value accepting permessage-deflate, or "" if no
+// acceptable offer was made. Offers restricting server_max_window_bits below
+// 15 are declined, since compress/flate always uses a 32 KiB window.
+func NegotiateDeflate(req *http.Request) string {
+	for _, v := range req.Header[http.CanonicalHeaderKey(HeaderSecWebSocketExt)] {
+		for _, offer := range strings.Split(v, ",") {
+			params := strings.Split(offer, ";")
+			if !strings.EqualFold(strings.TrimSpace(params[0]), ExtPermessageDeflate) {
+				continue
+			}
+			if deflateOfferAcceptable(params[1:]) {
+				return deflateResponse
+			}
+		}
+	}
+	return ""
+}
+
+// AcceptDeflate checks a server's Sec-WebSocket-Extensions response to
+// DeflateOffer. It reports whether permessage-deflate was accepted, and fails
+// with ErrDeflateResponse for other extensions or for deflate parameters the
+// stateless codec cannot honor, after which the client must fail the
+// connection (RFC 6455 9.1).
+func AcceptDeflate(resp http.Header) (bool, error) {
+	accepted := false
+	for _, v := range resp[http.CanonicalHeaderKey(HeaderSecWebSocketExt)] {
+		for _, ext := range strings.Split(v, ",") {
+			params := strings.Split(ext, ";")
+			name := strings.TrimSpace(params[0])
+			if name == "" {
+				continue
+			}
+			if !strings.EqualFold(name, ExtPermessageDeflate) || accepted {
+				return false, fmt.Errorf("%w: %s", ErrDeflateResponse, strings.TrimSpace(ext))
+			}
+			if !deflateResponseAcceptable(params[1:]) {
+				return false, fmt.Errorf("%w: %s", ErrDeflateResponse, strings.TrimSpace(ext))
+			}
+			accepted = true
+		}
+	}
+	return accepted, nil
+}
+
+// deflateResponseAcceptable requires server_no_context_takeover, since each
+// message is inflated on its own, and accepts any server window size.
+func deflateResponseAcceptable(params []string) bool {
+	noContext := false
+	for _, p := range params {
+		name, _, _ := strings.Cut(strings.TrimSpace(p), "=")
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "server_no_context_takeover":
+			noContext = true
+		case "client_no_context_takeover", "server_max_window_bits":
+		default:
+			return false
+		}
+	}
+	return noContext
+}
+
+func deflateOfferAcceptable(params []string) bool {
+	for _, p := range params {
+		name, value, _ := strings.Cut(strings.TrimSpace(p), "=")
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+		case "server_max_window_bits":
+			if value != "15" {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// CompressPayload deflates one message payload for a frame with Rsv1Bit set.
+func CompressPayload(payload []byte) ([]byte, error) {
+	var out bytes.Buffer
+	w := flateWriterPool.Get().(*flate.Writer)
+	defer flateWriterPool.Put(w)
+	w.Reset(&out)
+	if _, err := w.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(out.Bytes(), deflateTail), nil
+}
+
+// DecompressPayload inflates one compressed message payload, failing with
+// ErrDeflateTooLarge beyond MaxFramePayload bytes.
+func DecompressPayload(payload []byte) ([]byte, error) {
+	r := flate.NewReader(io.MultiReader(bytes.NewReader(payload), bytes.NewReader(inflateTrailer)))
+	defer r.Close()
+	out, err := io.ReadAll(io.LimitReader(r, MaxFramePayload+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > MaxFramePayload {
+		return nil, ErrDeflateTooLarge
+	}
+	return out, nil
+}
@@ -0,0 +1,63 @@
+// File: protocol/flowcontrol.go
+// Package protocol implements per-connection read flow control.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package protocol
+
+import "github.com/momentics/hioload-ws/api"
+
+// PauseReading stops reading from the transport, so a peer that keeps
+// sending fills the socket buffers and is held back by TCP flow control
+// instead of frames piling up in memory. A read already in progress
+// completes; frames decoded before the pause are still delivered. Control
+// frames, including the peer's close, are not seen until ResumeReading.
+//
+// The socket stays registered with the runtime poller: there is no reactor
+// holding read interest to withdraw, as each connection is read by its own
+// goroutine blocking in Recv. Pausing parks that goroutine before its next
+// Recv instead, so no read is pending on the socket, at the cost of one
+// blocked goroutine per paused connection.
+func (c *WSConnection) PauseReading() {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	if c.readResume == nil {
+		c.readResume = make(chan struct{})
+		c.trace("reading paused")
+	}
+}
+
+// ResumeReading undoes PauseReading.
+func (c *WSConnection) ResumeReading() {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	if c.readResume != nil {
+		close(c.readResume)
+		c.readResume = nil
+		c.trace("reading resumed")
+	}
+}
+
+// ReadingPaused reports whether reading is paused.
+func (c *WSConnection) ReadingPaused() bool {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	return c.readResume != nil
+}
+
+// waitReadable blocks while reading is paused; it fails once the connection
+// closes.
+func (c *WSConnection) waitReadable() error {
+	c.readMu.Lock()
+	resume := c.readResume
+	c.readMu.Unlock()
+	if resume == nil {
+		return nil
+	}
+	select {
+	case <-resume:
+		return nil
+	case <-c.done:
+		return api.ErrTransportClosed
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
+	"unicode/utf8"
 
 	"github.com/momentics/hioload-ws/api"
 )
@@ -19,12 +20,28 @@ import (
 // WSFrame represents a decoded WebSocket frame.
 type WSFrame struct {
 	IsFinal    bool  // FIN bit
+	Rsv        byte  // RSV1-3 bits as in the first header byte (Rsv1Bit marks a compressed message)
 	Opcode     byte  // Operation code
 	Masked     bool  // Whether the frame was masked
 	PayloadLen int64 // Actual payload length
 	MaskKey    [4]byte
 	Payload    []byte     // Zero-copy reference (owner managed via pooling)
-	Buf        api.Buffer // Optional pooled buffer carrying the payload
+	Buf        api.Buffer // Optional pooled buffer carrying the payload; released by SendFrame once encoded
+	Priority   Priority   // Send lane used by SendFrame, PriorityNormal by default
+
+	sendBy int64      // UnixNano deadline of the write, stamped by SendFrame; see SetWriteTimeout
+	batch  [][]byte   // frames encoded by SendFrames, written by the send loop as one item
+	result chan error // receives the outcome of writing batch, for WriteFrames
+}
+
+// discard drops a queued frame: its buffer and encoded batch are released
+// and a WriteFrames caller learns err.
+func (f *WSFrame) discard(err error) {
+	f.Buf.Release()
+	putSegments(f.batch)
+	if f.result != nil {
+		f.result <- err
+	}
 }
 
 // DecodeFrame parses the WebSocket frame header and payload from stream.
@@ -35,6 +52,7 @@ func DecodeFrame(r io.Reader) (*WSFrame, error) {
 	}
 
 	isFin := hdr[0]&FinBit != 0
+	rsv := hdr[0] & RsvBits
 	opcode := hdr[0] & 0x0F
 	isMasked := hdr[1]&MaskBit != 0
 	payloadLen := int64(hdr[1] & 0x7F)
@@ -67,11 +85,12 @@ func DecodeFrame(r io.Reader) (*WSFrame, error) {
 	}
 
 	if isMasked {
-		unmaskInPlace(payload, maskKey)
+		MaskBytes(payload, maskKey)
 	}
 
 	return &WSFrame{
 		IsFinal:    isFin,
+		Rsv:        rsv,
 		Opcode:     opcode,
 		Masked:     isMasked,
 		PayloadLen: payloadLen,
@@ -117,16 +136,29 @@ func EncodeFrame(dst []byte, opcode byte, payload []byte, mask bool) (int, error
 		copy(maskKey[:], []byte{0xDE, 0xAD, 0xBE, 0xEF}) // Example key
 		copy(dst[offset:], maskKey[:])
 		offset += 4
-		unmaskInPlace(payload, maskKey)
+		MaskBytes(payload, maskKey)
 	}
 
 	copy(dst[offset:], payload)
 	return offset + len(payload), nil
 }
 
-// unmaskInPlace applies XOR on payload using maskKey.
-func unmaskInPlace(buf []byte, key [4]byte) {
-	for i := 0; i < len(buf); i++ {
-		buf[i] ^= key[i%4]
+// NewCloseFrame builds a close frame carrying code and an optional reason,
+// truncated at a rune boundary to fit the control frame payload limit.
+func NewCloseFrame(code uint16, reason string) *WSFrame {
+	if n := MaxControlPayloadLen - 2; len(reason) > n {
+		for n > 0 && !utf8.RuneStart(reason[n]) {
+			n--
+		}
+		reason = reason[:n]
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, code)
+	payload = append(payload, reason...)
+	return &WSFrame{
+		IsFinal:    true,
+		Opcode:     OpcodeClose,
+		PayloadLen: int64(len(payload)),
+		Payload:    payload,
 	}
 }
@@ -11,6 +11,9 @@ package protocol
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
+
+	"github.com/momentics/hioload-ws/api"
 )
 
 // MaxFramePayload defines the maximum allowed payload size for a single frame.
@@ -24,10 +27,40 @@ const MaxFramePayload = 1 << 20 // 1 MiB
 // Returns frame, consumed bytes, and error.
 // If frame is incomplete, returns (nil, 0, nil).
 func DecodeFrameFromBytes(raw []byte) (*WSFrame, int, error) {
+	frame, offset, err := decodeFrameHeader(raw)
+	if frame == nil {
+		return nil, 0, err
+	}
+
+	totalLen := offset + int(frame.PayloadLen)
+	if len(raw) < totalLen {
+		return nil, 0, nil // Incomplete
+	}
+
+	frame.Payload = raw[offset:totalLen]
+	if frame.Masked {
+		MaskBytes(frame.Payload, frame.MaskKey)
+	}
+	return frame, totalLen, nil
+}
+
+// maxFrameHeaderLen is the longest frame header: 2 bytes, a 64-bit length
+// and a mask key.
+const maxFrameHeaderLen = 14
+
+// ErrFrameTooLarge rejects frames declaring more than MaxFramePayload, or
+// the receiver's lower limit (see WSConnection.SetReadBufferLimit).
+var ErrFrameTooLarge = fmt.Errorf("frame payload exceeds maximum allowed size: %w", api.ErrMessageTooBig)
+
+// decodeFrameHeader parses the frame header at the start of raw and returns
+// the frame without payload and the header length, or a nil frame if raw
+// holds only part of the header.
+func decodeFrameHeader(raw []byte) (*WSFrame, int, error) {
 	if len(raw) < 2 {
 		return nil, 0, nil // Incomplete
 	}
 	fin := raw[0]&0x80 != 0
+	rsv := raw[0] & RsvBits
 	opcode := raw[0] & 0x0F
 	masked := raw[1]&0x80 != 0
 	length := int64(raw[1] & 0x7F)
@@ -48,8 +81,8 @@ func DecodeFrameFromBytes(raw []byte) (*WSFrame, int, error) {
 		offset += 8
 	}
 
-	if length > MaxFramePayload {
-		return nil, 0, errors.New("frame payload exceeds maximum allowed size")
+	if length < 0 || length > MaxFramePayload {
+		return nil, 0, ErrFrameTooLarge
 	}
 
 	var maskKey [4]byte
@@ -61,26 +94,14 @@ func DecodeFrameFromBytes(raw []byte) (*WSFrame, int, error) {
 		offset += 4
 	}
 
-	totalLen := offset + int(length)
-	if len(raw) < totalLen {
-		return nil, 0, nil // Incomplete
-	}
-
-	payloadData := raw[offset:totalLen]
-	if masked {
-		for i := int64(0); i < length; i++ {
-			payloadData[i] ^= maskKey[i%4]
-		}
-	}
-
 	return &WSFrame{
 		IsFinal:    fin,
+		Rsv:        rsv,
 		Opcode:     opcode,
 		Masked:     masked,
 		PayloadLen: length,
 		MaskKey:    maskKey,
-		Payload:    payloadData,
-	}, totalLen, nil
+	}, offset, nil
 }
 
 // EncodeFrameToBytes serializes WSFrame into []byte,
@@ -100,6 +121,7 @@ func EncodeFrameToBufferWithMask(f *WSFrame, mask bool, dst []byte) ([]byte, err
 	if f.IsFinal {
 		b0 = 0x80
 	}
+	b0 |= f.Rsv & RsvBits
 	b0 |= (f.Opcode & 0x0F)
 
 	plen := int(f.PayloadLen)
@@ -136,17 +158,15 @@ func EncodeFrameToBufferWithMask(f *WSFrame, mask bool, dst []byte) ([]byte, err
 	}
 
 	dst = append(dst[:0], header...)
+	maskKey := [4]byte{0x12, 0x34, 0x56, 0x78} // Example mask key
 	if mask {
-		maskKey := [4]byte{0x12, 0x34, 0x56, 0x78} // Example mask key
 		dst = append(dst, maskKey[:]...)
 	}
 
 	start := len(dst)
 	dst = append(dst, f.Payload...)
 	if mask {
-		for i := 0; i < plen; i++ {
-			dst[start+i] ^= dst[len(header)+(i%4)]
-		}
+		MaskBytes(dst[start:start+plen], maskKey)
 	}
 
 	return dst, nil
@@ -0,0 +1,200 @@
+// File: protocol/frame_decoder.go
+// Package protocol implements an incremental, scatter/gather frame decoder.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Transports hand back each read as freshly owned segments. A frame lying
+// whole in one segment is unmasked in place and its payload references the
+// segment, which goes back to its pool once every such frame is released;
+// only frames split across reads are gathered, in a single copy,
+// into a pooled buffer. Headers split across reads are reassembled in a
+// fixed 14-byte scratch area. A payload too large for the pool grows with
+// the bytes actually received, so a peer declaring a large frame and
+// sending it slowly holds no more memory than it has sent, and at most the
+// decoder's limit.
+
+package protocol
+
+import (
+	"sync/atomic"
+
+	"github.com/momentics/hioload-ws/api"
+)
+
+// initialGather is the first allocation for a payload too large for the
+// pool; it doubles as bytes arrive.
+const initialGather = 64 * 1024
+
+// FrameDecoder decodes a stream of frames from the segments returned by
+// successive api.Transport.Recv calls. It is not safe for concurrent use.
+type FrameDecoder struct {
+	pool  api.BufferPool
+	limit int // largest payload accepted, see SetLimit
+
+	hdr    [maxFrameHeaderLen]byte // partial header carried to the next segment
+	hdrLen int
+
+	frame  *WSFrame   // header decoded, payload still arriving
+	gather api.Buffer // payload of frame gathered so far
+}
+
+// NewFrameDecoder returns a decoder gathering split payloads into buffers
+// from pool; nil allocates them.
+func NewFrameDecoder(pool api.BufferPool) *FrameDecoder {
+	return &FrameDecoder{pool: pool, limit: MaxFramePayload}
+}
+
+// SetLimit sets the largest payload accepted, MaxFramePayload by default and
+// at most; Decode fails with ErrFrameTooLarge on a frame declaring more,
+// before buffering any of it.
+func (d *FrameDecoder) SetLimit(n int) {
+	if n <= 0 || n > MaxFramePayload {
+		n = MaxFramePayload
+	}
+	d.limit = n
+}
+
+// Decode consumes seg, which the decoder may unmask in place and reference,
+// and calls emit with every frame it completes, in order. An emitted frame's
+// Payload is unmasked and Buf holds it: a pooled buffer the receiver must
+// release if the payload was gathered, else a view of seg with no pool. The
+// first error from the header or emit is returned and ends decoding.
+func (d *FrameDecoder) Decode(seg []byte, emit func(*WSFrame) error) error {
+	return d.decode(seg, nil, emit)
+}
+
+// DecodeBuffer is Decode for a segment read into a pooled buffer, which it
+// takes ownership of. Frames referencing seg hold a share of it, so the
+// receiver releases every emitted frame's Buf; seg goes back to its pool
+// after the last of them, or on return if none references it.
+func (d *FrameDecoder) DecodeBuffer(seg api.Buffer, emit func(*WSFrame) error) error {
+	if seg.Pool == nil {
+		return d.decode(seg.Data, nil, emit)
+	}
+	owner := &segmentRef{buf: seg}
+	owner.refs.Store(1) // the decoder's own, dropped on return
+	defer owner.Put(api.Buffer{})
+	return d.decode(seg.Data, owner, emit)
+}
+
+// segmentRef returns a segment to its pool once DecodeBuffer and the frames
+// emitted from it have all released it.
+type segmentRef struct {
+	buf  api.Buffer
+	refs atomic.Int32
+}
+
+// Put drops one reference; the argument is ignored.
+func (s *segmentRef) Put(api.Buffer) {
+	if s.refs.Add(-1) == 0 {
+		s.buf.Release()
+	}
+}
+
+// decode implements Decode and DecodeBuffer; owner is nil for a segment
+// with no pool.
+func (d *FrameDecoder) decode(seg []byte, owner *segmentRef, emit func(*WSFrame) error) error {
+	for len(seg) > 0 {
+		if d.frame != nil {
+			n := min(int(d.frame.PayloadLen)-len(d.gather.Data), len(seg))
+			d.gather.Data = append(d.gather.Data, seg[:n]...)
+			seg = seg[n:]
+			if len(d.gather.Data) < int(d.frame.PayloadLen) {
+				return nil
+			}
+			frame, buf := d.frame, d.gather
+			d.frame, d.gather = nil, api.Buffer{}
+			if err := d.emit(frame, buf, emit); err != nil {
+				return err
+			}
+			continue
+		}
+
+		frame, n, err := d.header(seg)
+		if err != nil {
+			return err
+		}
+		seg = seg[n:]
+		if frame == nil {
+			return nil // header continues in the next segment
+		}
+		size := int(frame.PayloadLen)
+		if size > d.limit {
+			return ErrFrameTooLarge
+		}
+		if len(seg) >= size {
+			// Fast path: the whole payload is here, no copy.
+			view := api.Buffer{Data: seg[:size:size]}
+			if owner != nil {
+				owner.refs.Add(1)
+				view.NUMA, view.Class, view.Pool = owner.buf.NUMA, owner.buf.Class, owner
+			}
+			seg = seg[size:]
+			if err := d.emit(frame, view, emit); err != nil {
+				return err
+			}
+			continue
+		}
+		d.frame, d.gather = frame, d.alloc(size)
+		d.gather.Data = append(d.gather.Data, seg...)
+		seg = nil
+	}
+	return nil
+}
+
+// header decodes the next frame header from a partial header carried over
+// and seg. It returns the bytes of seg consumed and a nil frame when the
+// header is still incomplete.
+func (d *FrameDecoder) header(seg []byte) (*WSFrame, int, error) {
+	if d.hdrLen == 0 {
+		frame, n, err := decodeFrameHeader(seg)
+		if frame != nil || err != nil {
+			return frame, n, err
+		}
+		// Incomplete, so seg is shorter than any header.
+		d.hdrLen = copy(d.hdr[:], seg)
+		return nil, len(seg), nil
+	}
+	take := copy(d.hdr[d.hdrLen:], seg)
+	frame, n, err := decodeFrameHeader(d.hdr[:d.hdrLen+take])
+	if frame == nil && err == nil {
+		d.hdrLen += take
+		return nil, take, nil
+	}
+	consumed := n - d.hdrLen
+	d.hdrLen = 0
+	return frame, consumed, err
+}
+
+// alloc returns an empty buffer to gather a payload of size bytes into:
+// pooled if the pool's buffers hold it, else growing from initialGather.
+func (d *FrameDecoder) alloc(size int) api.Buffer {
+	if d.pool != nil {
+		buf := d.pool.Get(size, -1)
+		if len(buf.Data) >= size {
+			return buf.Slice(0, 0)
+		}
+		buf.Release()
+	}
+	return api.Buffer{Data: make([]byte, 0, min(size, initialGather))}
+}
+
+// emit unmasks the payload in buf and hands the frame to fn.
+func (d *FrameDecoder) emit(frame *WSFrame, buf api.Buffer, fn func(*WSFrame) error) error {
+	if frame.Masked {
+		MaskBytes(buf.Data, frame.MaskKey)
+	}
+	frame.Payload, frame.Buf = buf.Data, buf
+	return fn(frame)
+}
+
+// Buffered returns the bytes held for a frame not yet complete.
+func (d *FrameDecoder) Buffered() int {
+	return d.hdrLen + len(d.gather.Data)
+}
+
+// Reset drops a partial frame, returning its buffer to the pool.
+func (d *FrameDecoder) Reset() {
+	d.gather.Release()
+	d.frame, d.gather, d.hdrLen = nil, api.Buffer{}, 0
+}
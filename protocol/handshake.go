@@ -11,12 +11,13 @@ package protocol
 
 import (
 	"bufio"
-	"crypto/sha1"
-	"encoding/base64"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"sync"
+
+	"github.com/momentics/hioload-ws/api"
 )
 
 // Constants used for handshake processing.
@@ -26,6 +27,7 @@ const (
 	HeaderUpgrade            = "Upgrade"
 	HeaderSecWebSocketKey    = "Sec-WebSocket-Key"
 	HeaderSecWebSocketVer    = "Sec-WebSocket-Version"
+	HeaderSecWebSocketProto  = "Sec-WebSocket-Protocol"
 	RequiredWebSocketVersion = "13"
 	MaxHandshakeHeadersSize  = 8192
 )
@@ -47,52 +49,8 @@ func DoHandshakeCore(r io.Reader) (http.Header, error) {
 // DoHandshakeCoreWithPath reads and validates the HTTP/1.1 Upgrade request from r.
 // Returns the headers to include in the HTTP 101 Switching Protocols response and the request path.
 func DoHandshakeCoreWithPath(r io.Reader) (http.Header, string, error) {
-	br := bufio.NewReader(r)
-	req, err := http.ReadRequest(br)
-	if err != nil {
-		return nil, "", fmt.Errorf("handshake read request: %w", err)
-	}
-
-	// Enforce a maximum total header size to prevent abuse.
-	total := 0
-	for k, vs := range req.Header {
-		total += len(k)
-		for _, v := range vs {
-			total += len(v)
-			if total > MaxHandshakeHeadersSize {
-				return nil, "", fmt.Errorf("handshake headers too large")
-			}
-		}
-	}
-
-	// Validate required upgrade tokens.
-	if !headerContainsToken(req.Header, HeaderConnection, "Upgrade") ||
-		!headerContainsToken(req.Header, HeaderUpgrade, "websocket") {
-		return nil, "", ErrInvalidUpgradeHeaders
-	}
-
-	// Verify WebSocket version.
-	if req.Header.Get(HeaderSecWebSocketVer) != RequiredWebSocketVersion {
-		return nil, "", ErrBadWebSocketVersion
-	}
-
-	// Extract client key.
-	key := req.Header.Get(HeaderSecWebSocketKey)
-	if key == "" {
-		return nil, "", ErrMissingWebSocketKey
-	}
-
-	// Compute the Sec-WebSocket-Accept.
-	h := sha1.New()
-	h.Write([]byte(key + WebSocketGUID))
-	accept := base64.StdEncoding.EncodeToString(h.Sum(nil))
-
-	// Prepare response headers.
-	hdr := make(http.Header)
-	hdr.Set("Upgrade", "websocket")
-	hdr.Set("Connection", "Upgrade")
-	hdr.Set("Sec-WebSocket-Accept", accept)
-	return hdr, req.URL.Path, nil
+	hdr, path, _, err := DoHandshakeCoreBuffered(r)
+	return hdr, path, err
 }
 
 // DoHandshakeCoreBuffered reads and validates the HTTP/1.1 Upgrade request from r.
@@ -100,75 +58,104 @@ func DoHandshakeCoreWithPath(r io.Reader) (http.Header, string, error) {
 // IMPORTANT: Caller must use the returned bufio.Reader for all subsequent reads
 // to avoid losing any data that was buffered during HTTP parsing.
 func DoHandshakeCoreBuffered(r io.Reader) (http.Header, string, *bufio.Reader, error) {
-	br := bufio.NewReader(r)
-	req, err := http.ReadRequest(br)
+	req, hdr, br, err := DoHandshakeRequestBuffered(r)
 	if err != nil {
-		return nil, "", nil, fmt.Errorf("handshake read request: %w", err)
-	}
-
-	// Enforce a maximum total header size to prevent abuse.
-	total := 0
-	for k, vs := range req.Header {
-		total += len(k)
-		for _, v := range vs {
-			total += len(v)
-			if total > MaxHandshakeHeadersSize {
-				return nil, "", nil, fmt.Errorf("handshake headers too large")
-			}
-		}
+		return nil, "", nil, err
 	}
+	return hdr, req.URL.Path, br, nil
+}
 
-	// Validate required upgrade tokens.
-	if !headerContainsToken(req.Header, HeaderConnection, "Upgrade") ||
-		!headerContainsToken(req.Header, HeaderUpgrade, "websocket") {
-		return nil, "", nil, ErrInvalidUpgradeHeaders
+// DoHandshakeRequestBuffered is DoHandshakeCoreBuffered that also returns the
+// parsed upgrade request, so callers can inspect request headers and extend
+// the response headers before writing them.
+func DoHandshakeRequestBuffered(r io.Reader) (*http.Request, http.Header, *bufio.Reader, error) {
+	br := bufio.NewReader(r)
+	ur := AcquireUpgradeRequest()
+	defer ur.Release()
+	if err := ur.Read(br); err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: read request: %w", api.ErrHandshakeFailed, err)
 	}
-
-	// Verify WebSocket version.
-	if req.Header.Get(HeaderSecWebSocketVer) != RequiredWebSocketVersion {
-		return nil, "", nil, ErrBadWebSocketVersion
+	key, err := ur.Validate()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: %w", api.ErrHandshakeFailed, err)
 	}
-
-	// Extract client key.
-	key := req.Header.Get(HeaderSecWebSocketKey)
-	if key == "" {
-		return nil, "", nil, ErrMissingWebSocketKey
+	req, err := ur.HTTPRequest()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: read request: %w", api.ErrHandshakeFailed, err)
 	}
 
-	// Compute the Sec-WebSocket-Accept.
-	h := sha1.New()
-	h.Write([]byte(key + WebSocketGUID))
-	accept := base64.StdEncoding.EncodeToString(h.Sum(nil))
-
 	// Prepare response headers.
-	hdr := make(http.Header)
+	var accept [28]byte
+	hdr := make(http.Header, 4)
 	hdr.Set("Upgrade", "websocket")
 	hdr.Set("Connection", "Upgrade")
-	hdr.Set("Sec-WebSocket-Accept", accept)
-	return hdr, req.URL.Path, br, nil
+	hdr.Set("Sec-WebSocket-Accept", string(AppendAcceptKey(accept[:0], key)))
+	return req, hdr, br, nil
+}
+
+// ReadUpgrade reads an upgrade request from r with UpgradeRequest.ReadHead
+// and validates it, allocating nothing. The caller answers it with
+// WriteUpgradeResponse, builds its *http.Request only if needed and releases
+// it; bytes read past the head are in its Rest.
+func ReadUpgrade(r io.Reader) (*UpgradeRequest, error) {
+	ur := AcquireUpgradeRequest()
+	if err := ur.ReadHead(r); err != nil {
+		ur.Release()
+		return nil, fmt.Errorf("%w: read request: %w", api.ErrHandshakeFailed, err)
+	}
+	if _, err := ur.Validate(); err != nil {
+		ur.Release()
+		return nil, fmt.Errorf("%w: %w", api.ErrHandshakeFailed, err)
+	}
+	return ur, nil
+}
+
+// WriteUpgradeResponse writes the 101 Switching Protocols response to the
+// upgrade request ur, validated by ReadUpgrade, with the extra fields of hdr.
+func WriteUpgradeResponse(w io.Writer, ur *UpgradeRequest, hdr http.Header) error {
+	bp := handshakeBufPool.Get().(*[]byte)
+	*bp = AppendUpgradeResponse((*bp)[:0], ur.Header(HeaderSecWebSocketKey), hdr)
+	_, err := w.Write(*bp)
+	handshakeBufPool.Put(bp)
+	return err
+}
+
+// HandshakeRejection is returned by server-side handshake hooks to refuse an
+// upgrade with a specific HTTP status (e.g. 429 or 503) instead of a bare close.
+type HandshakeRejection struct {
+	Status int
+	Reason string
+}
+
+// Error implements error.
+func (r *HandshakeRejection) Error() string {
+	return fmt.Sprintf("handshake rejected: %d %s", r.Status, r.Reason)
+}
+
+// WriteHandshakeRejection writes a minimal HTTP error response for r.
+func WriteHandshakeRejection(w io.Writer, r *HandshakeRejection) error {
+	_, err := fmt.Fprintf(w, "HTTP/1.1 %d %s\r\nConnection: close\r\nContent-Length: %d\r\n\r\n%s",
+		r.Status, http.StatusText(r.Status), len(r.Reason), r.Reason)
+	return err
 }
 
 // WriteHandshakeResponse writes the HTTP/1.1 101 Switching Protocols response
 // with the provided headers to w. Caller must include required headers.
 func WriteHandshakeResponse(w io.Writer, hdr http.Header) error {
-	// Status line.
-	
-	if _, err := fmt.Fprintf(w, "HTTP/1.1 101 Switching Protocols\r\n"); err != nil {
-		return err
-	}
-	// Write all headers.
-	for k, vs := range hdr {
-		for _, v := range vs {
-			if _, err := fmt.Fprintf(w, "%s: %s\r\n", k, v); err != nil {
-				return err
-			}
-		}
-	}
-	// End of headers.
-	if _, err := fmt.Fprint(w, "\r\n"); err != nil {
-		return err
-	}
-	return nil
+	bp := handshakeBufPool.Get().(*[]byte)
+	*bp = AppendHandshakeResponse((*bp)[:0], hdr)
+	_, err := w.Write(*bp)
+	handshakeBufPool.Put(bp)
+	return err
+}
+
+// handshakeBufPool holds the buffers handshake responses are built in, so
+// each is written with a single Write.
+var handshakeBufPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, 512)
+		return &b
+	},
 }
 
 // WriteHandshakeRequest serializes the HTTP GET Upgrade request into w,
@@ -186,29 +173,48 @@ func WriteHandshakeRequest(w io.Writer, req *http.Request) error {
 // DoClientHandshake reads and validates the HTTP/1.1 101 Switching Protocols response
 // from r, using the original req for correct parsing context.
 func DoClientHandshake(r io.Reader, req *http.Request) error {
+	_, err := DoClientHandshakeResponse(r, req)
+	return err
+}
+
+// DoClientHandshakeResponse is DoClientHandshake that also returns the 101
+// response, e.g. to read the subprotocol the server selected.
+func DoClientHandshakeResponse(r io.Reader, req *http.Request) (*http.Response, error) {
 	br := bufio.NewReader(r)
 	resp, err := http.ReadResponse(br, req)
 	if err != nil {
-		return fmt.Errorf("handshake read response: %w", err)
+		return nil, fmt.Errorf("%w: read response: %w", api.ErrHandshakeFailed, err)
 	}
 	if resp.StatusCode != http.StatusSwitchingProtocols {
-		return fmt.Errorf("handshake failed: status %d", resp.StatusCode)
+		return nil, fmt.Errorf("%w: status %d", api.ErrHandshakeFailed, resp.StatusCode)
+	}
+	// The selected subprotocol must be one the client offered.
+	if proto := resp.Header.Get(HeaderSecWebSocketProto); proto != "" {
+		if SelectSubprotocol(req, []string{proto}) == "" {
+			return nil, fmt.Errorf("%w: server selected unrequested subprotocol %q", api.ErrHandshakeFailed, proto)
+		}
 	}
 	// The handshake is complete. We don't discard remaining data as WebSocket frames
 	// will be read from the same connection after handshake.
-	return nil
+	return resp, nil
 }
 
-// headerContainsToken checks if headerName contains the given token (case-insensitive).
-func headerContainsToken(h http.Header, headerName, token string) bool {
-	vals := h[http.CanonicalHeaderKey(headerName)]
-	token = strings.ToLower(token)
-	for _, v := range vals {
-		for _, part := range strings.Split(v, ",") {
-			if strings.ToLower(strings.TrimSpace(part)) == token {
-				return true
+// SelectSubprotocol returns the first subprotocol offered by the client in
+// Sec-WebSocket-Protocol that is also in supported, or "" if none matches.
+// The client's order expresses its preference, so it wins over supported's.
+func SelectSubprotocol(req *http.Request, supported []string) string {
+	if len(supported) == 0 {
+		return ""
+	}
+	for _, v := range req.Header[http.CanonicalHeaderKey(HeaderSecWebSocketProto)] {
+		for _, offered := range strings.Split(v, ",") {
+			offered = strings.TrimSpace(offered)
+			for _, s := range supported {
+				if offered == s {
+					return s
+				}
 			}
 		}
 	}
-	return false
+	return ""
 }
@@ -0,0 +1,430 @@
+// File: protocol/handshake_parser.go
+// Package protocol implements an in-place parser for HTTP/1.1 upgrade requests.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// net/http allocates a textproto reader, a canonicalized string per header
+// name and value, and a map per request; during connection storms that is
+// most of the garbage a server produces. UpgradeRequest copies the request
+// head once into a pooled buffer and keeps the request line and header
+// fields as views into it, so reading, validating and answering an upgrade
+// allocates nothing. ReadHead reads the head straight from the connection,
+// without a bufio.Reader. HTTPRequest builds a *http.Request from the views
+// for the hooks that need one, once.
+
+package protocol
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// maxHandshakeFields bounds the header fields of an upgrade request.
+const maxHandshakeFields = 64
+
+// Errors for handshake parsing.
+var (
+	ErrHandshakeTooLarge  = errors.New("handshake headers too large")
+	ErrMalformedHandshake = errors.New("malformed handshake request")
+)
+
+// headerField is a header line of an UpgradeRequest, split at the colon.
+type headerField struct {
+	name, value []byte
+}
+
+// UpgradeRequest is an HTTP/1.1 request head parsed in place. Its byte
+// slices are views into a buffer it owns and stay valid until Release.
+type UpgradeRequest struct {
+	Method []byte
+	Target []byte // request-target, path and query
+	Proto  []byte
+
+	buf    []byte
+	fields [maxHandshakeFields]headerField
+	n      int
+	rest   []byte        // bytes ReadHead read past the head
+	req    *http.Request // built by HTTPRequest
+}
+
+var upgradeRequestPool = sync.Pool{
+	New: func() any { return &UpgradeRequest{buf: make([]byte, 0, MaxHandshakeHeadersSize)} },
+}
+
+// AcquireUpgradeRequest returns an empty UpgradeRequest from a pool.
+func AcquireUpgradeRequest() *UpgradeRequest {
+	return upgradeRequestPool.Get().(*UpgradeRequest)
+}
+
+// Release resets r and returns it to the pool; its views become invalid.
+func (r *UpgradeRequest) Release() {
+	clear(r.fields[:r.n])
+	r.Method, r.Target, r.Proto = nil, nil, nil
+	r.buf, r.n = r.buf[:0], 0
+	r.rest, r.req = nil, nil
+	upgradeRequestPool.Put(r)
+}
+
+// Read reads a request head, up to and including the blank line, from br and
+// parses it. Bytes after the head, such as early frames, stay in br. A head
+// larger than MaxHandshakeHeadersSize fails with ErrHandshakeTooLarge.
+func (r *UpgradeRequest) Read(br *bufio.Reader) error {
+	if r.buf == nil {
+		r.buf = make([]byte, 0, MaxHandshakeHeadersSize)
+	}
+	r.buf = r.buf[:0]
+	for {
+		line, err := br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull || len(r.buf)+len(line) > cap(r.buf) {
+			return ErrHandshakeTooLarge
+		}
+		if err != nil {
+			return err
+		}
+		// cap(r.buf) is never exceeded, so views taken below stay valid.
+		r.buf = append(r.buf, line...)
+		if len(line) <= 2 && trimEOL(line) == nil {
+			return r.parse()
+		}
+	}
+}
+
+// ReadHead reads a request head from rd into r and parses it, reading rd
+// directly in as large chunks as fit. Bytes read past the head, such as early
+// frames, are returned by Rest. A head larger than MaxHandshakeHeadersSize
+// fails with ErrHandshakeTooLarge.
+func (r *UpgradeRequest) ReadHead(rd io.Reader) error {
+	if r.buf == nil {
+		r.buf = make([]byte, 0, MaxHandshakeHeadersSize)
+	}
+	r.buf = r.buf[:0]
+	for from := 0; ; {
+		if len(r.buf) == cap(r.buf) {
+			return ErrHandshakeTooLarge
+		}
+		n, err := rd.Read(r.buf[len(r.buf):cap(r.buf)])
+		r.buf = r.buf[:len(r.buf)+n]
+		if end := headEnd(r.buf, from); end >= 0 {
+			// Trimming the length keeps cap(r.buf), so the views stay valid.
+			r.buf, r.rest = r.buf[:end], r.buf[end:]
+			return r.parse()
+		}
+		from = max(len(r.buf)-2, 0)
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Rest returns the bytes ReadHead read past the head; like the views it is
+// valid until Release.
+func (r *UpgradeRequest) Rest() []byte {
+	return r.rest
+}
+
+// Head returns a copy of the request head, for ParseRequestHead.
+func (r *UpgradeRequest) Head() string {
+	return string(r.buf)
+}
+
+// headEnd returns the length of the head held at the start of b, up to and
+// including the empty line ending it, or -1 if b does not hold all of it.
+// Lines starting before from were scanned already.
+func headEnd(b []byte, from int) int {
+	for i := from; i < len(b); i++ {
+		if i > 0 && b[i-1] != '\n' {
+			continue
+		}
+		if b[i] == '\n' {
+			return i + 1
+		}
+		if b[i] == '\r' && i+1 < len(b) && b[i+1] == '\n' {
+			return i + 2
+		}
+	}
+	return -1
+}
+
+// parse splits the head held in r.buf into the request line and fields.
+func (r *UpgradeRequest) parse() error {
+	rest := r.buf
+	line, rest := nextLine(rest)
+	var ok bool
+	if r.Method, line, ok = cut(line, ' '); !ok || len(r.Method) == 0 {
+		return ErrMalformedHandshake
+	}
+	if r.Target, r.Proto, ok = cut(line, ' '); !ok || len(r.Target) == 0 {
+		return ErrMalformedHandshake
+	}
+	if string(r.Proto) != "HTTP/1.1" {
+		return ErrMalformedHandshake
+	}
+
+	r.n = 0
+	for {
+		line, rest = nextLine(rest)
+		if len(line) == 0 {
+			return nil
+		}
+		name, value, ok := cut(line, ':')
+		if !ok || !validFieldName(name) {
+			return ErrMalformedHandshake
+		}
+		if r.n == len(r.fields) {
+			return ErrHandshakeTooLarge
+		}
+		r.fields[r.n] = headerField{name: name, value: trimOWS(value)}
+		r.n++
+	}
+}
+
+// Header returns the value of the first field named name, compared
+// case-insensitively, or nil.
+func (r *UpgradeRequest) Header(name string) []byte {
+	for _, f := range r.fields[:r.n] {
+		if equalFold(f.name, name) {
+			return f.value
+		}
+	}
+	return nil
+}
+
+// HasToken reports whether a comma-separated field named name lists token,
+// compared case-insensitively.
+func (r *UpgradeRequest) HasToken(name, token string) bool {
+	for _, f := range r.fields[:r.n] {
+		if !equalFold(f.name, name) {
+			continue
+		}
+		v := f.value
+		for len(v) > 0 {
+			var part []byte
+			part, v, _ = cut(v, ',')
+			if equalFold(trimOWS(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Path returns the path of the request-target, without the query.
+func (r *UpgradeRequest) Path() []byte {
+	path, _, _ := cut(r.Target, '?')
+	return path
+}
+
+// Validate checks the fields RFC 6455 requires of an upgrade request and
+// returns the client's Sec-WebSocket-Key.
+func (r *UpgradeRequest) Validate() ([]byte, error) {
+	if !r.HasToken(HeaderConnection, "Upgrade") || !r.HasToken(HeaderUpgrade, "websocket") {
+		return nil, ErrInvalidUpgradeHeaders
+	}
+	if string(r.Header(HeaderSecWebSocketVer)) != RequiredWebSocketVersion {
+		return nil, ErrBadWebSocketVersion
+	}
+	key := r.Header(HeaderSecWebSocketKey)
+	if len(key) == 0 {
+		return nil, ErrMissingWebSocketKey
+	}
+	return key, nil
+}
+
+// HTTPRequest builds the *http.Request net/http would have parsed from the
+// head, for handshake hooks. Unlike the views it allocates, but its strings
+// share one copy of the head; later calls return the same request, which
+// stays valid after Release.
+func (r *UpgradeRequest) HTTPRequest() (*http.Request, error) {
+	if r.req != nil {
+		return r.req, nil
+	}
+	head := string(r.buf)
+	str := func(b []byte) string {
+		off := cap(r.buf) - cap(b)
+		return head[off : off+len(b)]
+	}
+	target := str(r.Target)
+	u, err := url.ParseRequestURI(target)
+	if err != nil {
+		return nil, ErrMalformedHandshake
+	}
+	req := &http.Request{
+		Method:     str(r.Method),
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header, r.n),
+		Body:       http.NoBody,
+		RequestURI: target,
+	}
+	values := make([]string, r.n)
+	for i, f := range r.fields[:r.n] {
+		name := http.CanonicalHeaderKey(str(f.name))
+		values[i] = str(f.value)
+		if name == "Host" && req.Host == "" {
+			req.Host = values[i]
+			continue
+		}
+		if vs, ok := req.Header[name]; ok {
+			req.Header[name] = append(vs, values[i])
+		} else {
+			req.Header[name] = values[i : i+1 : i+1]
+		}
+	}
+	if req.Host == "" {
+		req.Host = u.Host
+	}
+	r.req = req
+	return req, nil
+}
+
+// ParseRequestHead parses a head returned by Head into a *http.Request.
+func ParseRequestHead(head string) (*http.Request, error) {
+	if len(head) > MaxHandshakeHeadersSize {
+		return nil, ErrHandshakeTooLarge
+	}
+	r := AcquireUpgradeRequest()
+	defer r.Release()
+	r.buf = append(r.buf[:0], head...)
+	if err := r.parse(); err != nil {
+		return nil, err
+	}
+	return r.HTTPRequest()
+}
+
+// AppendAcceptKey appends the Sec-WebSocket-Accept value for key to dst.
+func AppendAcceptKey(dst, key []byte) []byte {
+	var in [64 + len(WebSocketGUID)]byte
+	var sum [sha1.Size]byte
+	if len(key) <= 64 {
+		n := copy(in[:], key)
+		n += copy(in[n:], WebSocketGUID)
+		sum = sha1.Sum(in[:n])
+	} else {
+		sum = sha1.Sum(append(key[:len(key):len(key)], WebSocketGUID...))
+	}
+	n := len(dst)
+	dst = append(dst, make([]byte, base64.StdEncoding.EncodedLen(len(sum)))...)
+	base64.StdEncoding.Encode(dst[n:], sum[:])
+	return dst
+}
+
+// AppendHandshakeResponse appends the 101 Switching Protocols response with
+// the fields of hdr to dst.
+func AppendHandshakeResponse(dst []byte, hdr http.Header) []byte {
+	dst = append(dst, "HTTP/1.1 101 Switching Protocols\r\n"...)
+	return appendFields(dst, hdr)
+}
+
+// AppendUpgradeResponse appends the 101 Switching Protocols response
+// accepting key, followed by the fields of extra, to dst.
+func AppendUpgradeResponse(dst, key []byte, extra http.Header) []byte {
+	dst = append(dst, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: "...)
+	dst = AppendAcceptKey(dst, key)
+	dst = append(dst, "\r\n"...)
+	return appendFields(dst, extra)
+}
+
+// appendFields appends the fields of hdr and the empty line ending a head
+// to dst.
+func appendFields(dst []byte, hdr http.Header) []byte {
+	for k, vs := range hdr {
+		for _, v := range vs {
+			dst = append(dst, k...)
+			dst = append(dst, ": "...)
+			dst = append(dst, v...)
+			dst = append(dst, "\r\n"...)
+		}
+	}
+	return append(dst, "\r\n"...)
+}
+
+// nextLine returns the first line of b without its line ending, and the rest.
+func nextLine(b []byte) (line, rest []byte) {
+	line, rest, _ = cut(b, '\n')
+	return trimEOL(line), rest
+}
+
+// trimEOL strips a trailing CR or CRLF from line.
+func trimEOL(line []byte) []byte {
+	if n := len(line); n > 0 && line[n-1] == '\n' {
+		line = line[:n-1]
+	}
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	if len(line) == 0 {
+		return nil
+	}
+	return line
+}
+
+// cut splits b around the first sep.
+func cut(b []byte, sep byte) (before, after []byte, found bool) {
+	for i, c := range b {
+		if c == sep {
+			return b[:i], b[i+1:], true
+		}
+	}
+	return b, nil, false
+}
+
+// trimOWS strips the optional whitespace around a field value.
+func trimOWS(b []byte) []byte {
+	for len(b) > 0 && (b[0] == ' ' || b[0] == '\t') {
+		b = b[1:]
+	}
+	for len(b) > 0 && (b[len(b)-1] == ' ' || b[len(b)-1] == '\t') {
+		b = b[:len(b)-1]
+	}
+	return b
+}
+
+// validFieldName reports whether name is a non-empty RFC 7230 token.
+func validFieldName(name []byte) bool {
+	if len(name) == 0 {
+		return false
+	}
+	for _, c := range name {
+		if c <= ' ' || c >= 0x7f || c == ':' || c == '"' || c == '(' || c == ')' ||
+			c == ',' || c == '/' || c == ';' || c == '<' || c == '=' || c == '>' ||
+			c == '?' || c == '@' || c == '[' || c == '\\' || c == ']' || c == '{' || c == '}' {
+			return false
+		}
+	}
+	return true
+}
+
+// equalFold reports whether b and s are equal under ASCII case folding.
+func equalFold(b []byte, s string) bool {
+	if len(b) != len(s) {
+		return false
+	}
+	for i := 0; i < len(b); i++ {
+		x, y := b[i], s[i]
+		if x == y {
+			continue
+		}
+		if 'A' <= x && x <= 'Z' {
+			x += 'a' - 'A'
+		}
+		if 'A' <= y && y <= 'Z' {
+			y += 'a' - 'A'
+		}
+		if x != y {
+			return false
+		}
+	}
+	return true
+}
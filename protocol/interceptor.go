@@ -0,0 +1,119 @@
+// File: protocol/interceptor.go
+// Package protocol implements per-connection frame interceptors.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Interceptors sit between the application and the codec: send interceptors
+// see each data frame before it is compressed and encoded, receive
+// interceptors see it once it has been decoded, inflated and validated. That
+// lets an application encrypt, sign or tag payloads without a codec of its
+// own. Control frames bypass them, so a failing interceptor cannot break the
+// close handshake or keepalives.
+
+package protocol
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/momentics/hioload-ws/api"
+)
+
+// FrameInterceptor transforms a data frame. It may modify the frame in place
+// or return another one; returning a nil frame and nil error drops it.
+// Fragments of a message are intercepted one by one.
+type FrameInterceptor func(*WSFrame) (*WSFrame, error)
+
+// AddSendInterceptor appends fn to the interceptors run, in registration
+// order, on every data frame passed to SendFrame, SendFrames or WriteFrames.
+// An error fails that call and the frame is not sent. The connection keeps
+// owning the original frame's Buf and releases it once the frame is encoded.
+// A frame whose Buf is shared (see api.Buffer.Shared) reaches fn with a
+// private copy of its payload, so fn may still modify it in place.
+func (c *WSConnection) AddSendInterceptor(fn FrameInterceptor) {
+	c.addInterceptor(&c.sendInterceptors, fn)
+}
+
+// AddRecvInterceptor appends fn to the interceptors run, in registration
+// order, on every data frame received. An error closes the connection: with
+// the code and reason of an *api.CloseError, 1008 Policy Violation otherwise.
+func (c *WSConnection) AddRecvInterceptor(fn FrameInterceptor) {
+	c.addInterceptor(&c.recvInterceptors, fn)
+}
+
+// addInterceptor appends fn to a copy of chain, so the send and receive
+// paths read their interceptors without locking.
+func (c *WSConnection) addInterceptor(chain *atomic.Pointer[[]FrameInterceptor], fn FrameInterceptor) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var next []FrameInterceptor
+	if cur := chain.Load(); cur != nil {
+		next = append(next, *cur...)
+	}
+	next = append(next, fn)
+	chain.Store(&next)
+}
+
+// interceptOutbound runs the send interceptors over f. A nil frame with a nil
+// error means an interceptor dropped it; f's buffer is released either way
+// the frame is not sent. A shared payload is copied before the chain sees it.
+func (c *WSConnection) interceptOutbound(f *WSFrame) (*WSFrame, error) {
+	chain := c.sendInterceptors.Load()
+	if chain == nil || f.Opcode >= OpcodeClose {
+		return f, nil
+	}
+	in := f
+	if f.Buf.Shared() {
+		cp := *f
+		cp.Payload = append([]byte(nil), f.Payload...)
+		in = &cp
+	}
+	out, err := runInterceptors(*chain, in)
+	if out == nil {
+		f.Buf.Release()
+		return nil, err
+	}
+	out.Buf = f.Buf
+	return out, nil
+}
+
+// interceptInbound runs the receive interceptors over frame carrying payload,
+// its decoded payload, and returns the payload to deliver. keep is false if
+// an interceptor dropped the frame; code is non-zero if one failed. The
+// frame's opcode and FIN bit are updated from the frame the chain returns.
+func (c *WSConnection) interceptInbound(frame *WSFrame, payload []byte) (out []byte, keep bool, code uint16, reason string) {
+	chain := c.recvInterceptors.Load()
+	if chain == nil || frame.Opcode >= OpcodeClose {
+		return payload, true, 0, ""
+	}
+	in := *frame
+	in.Payload, in.PayloadLen = payload, int64(len(payload))
+	f, err := runInterceptors(*chain, &in)
+	if err != nil {
+		var ce *api.CloseError
+		if errors.As(err, &ce) {
+			return nil, false, ce.Code, ce.Reason
+		}
+		return nil, false, ClosePolicyViolation, "frame rejected"
+	}
+	if f == nil {
+		return nil, false, 0, ""
+	}
+	frame.Opcode, frame.IsFinal = f.Opcode, f.IsFinal
+	out = f.Payload
+	if len(out) > int(f.PayloadLen) {
+		out = out[:f.PayloadLen]
+	}
+	return out, true, 0, ""
+}
+
+// runInterceptors passes f through chain, stopping at an error or a drop.
+func runInterceptors(chain []FrameInterceptor, f *WSFrame) (*WSFrame, error) {
+	for _, fn := range chain {
+		var err error
+		if f, err = fn(f); err != nil || f == nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
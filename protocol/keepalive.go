@@ -0,0 +1,98 @@
+// File: protocol/keepalive.go
+// Package protocol implements keepalive pings and pong tracking.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package protocol
+
+import (
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+)
+
+// KeepAlive sends an unmasked (server) ping every interval until the
+// connection closes or a ping cannot be queued. It blocks; run it in its own
+// goroutine. Pongs are handled by the receive path; a peer that stops
+// answering is detected by the transport once writes fail.
+func (c *WSConnection) KeepAlive(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	t := c.clock.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-t.C():
+			c.trace("keepalive ping")
+			if err := c.SendFrame(&WSFrame{IsFinal: true, Opcode: OpcodePing}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// KeepAliveFunc is KeepAlive with the interval read from interval before
+// every ping, so it can change while the connection is open; it returns once
+// interval reports 0 or less. A change takes effect after the pending ping.
+func (c *WSConnection) KeepAliveFunc(interval func() time.Duration) {
+	for {
+		d := interval()
+		if d <= 0 {
+			return
+		}
+		t := c.clock.NewTimer(d)
+		select {
+		case <-c.done:
+			t.Stop()
+			return
+		case <-t.C():
+		}
+		c.trace("keepalive ping")
+		if err := c.SendFrame(&WSFrame{IsFinal: true, Opcode: OpcodePing}); err != nil {
+			return
+		}
+	}
+}
+
+// sendPong answers a ping whose payload is held in buf, taking ownership of
+// buf.
+func (c *WSConnection) sendPong(buf api.Buffer) {
+	p := buf.Bytes()
+	c.SendFrame(&WSFrame{IsFinal: true, Opcode: OpcodePong, Payload: p, PayloadLen: int64(len(p)), Buf: buf})
+}
+
+// LastPong returns when the last pong was received, or the zero time if none
+// has been. Comparing it with the time a ping was sent tells whether the peer
+// answered.
+func (c *WSConnection) LastPong() time.Time {
+	n := c.lastPong.Load()
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+// LastActivity returns when the last frame other than a pong was received,
+// or the zero time if none has been. Unlike LastPong it moves only with
+// traffic the peer initiated, not with answers to keepalive pings.
+func (c *WSConnection) LastActivity() time.Time {
+	n := c.lastActive.Load()
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+// LastSend returns when a frame was last written to the transport, or the
+// zero time if none has been. Together with LastActivity it tells whether a
+// connection carries traffic in either direction.
+func (c *WSConnection) LastSend() time.Time {
+	n := c.lastSent.Load()
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
@@ -0,0 +1,52 @@
+// File: protocol/mask.go
+// Package protocol implements payload masking.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Masking XORs every payload byte with the 4-byte key, which dominates the
+// receive path for large frames when done a byte at a time. The portable
+// path works on 64-bit words; amd64 with AVX2 and arm64 with NEON handle
+// 32- and 16-byte blocks in assembly. Build with the purego tag to use the
+// portable path only.
+
+package protocol
+
+import "encoding/binary"
+
+// maskAsmMin is the payload size from which the assembly routine pays off.
+const maskAsmMin = 64
+
+// MaskBytes XORs b in place with key, starting at key offset 0. Masking and
+// unmasking are the same operation (RFC 6455, section 5.3).
+func MaskBytes(b []byte, key [4]byte) {
+	if useMaskAsm && len(b) >= maskAsmMin {
+		// Whole blocks keep the key phase at 0 for the remainder.
+		n := len(b) &^ (maskAsmBlock - 1)
+		maskBlocks(b[:n], binary.LittleEndian.Uint32(key[:]))
+		b = b[n:]
+	}
+	maskWords(b, key)
+}
+
+// maskWords is the portable MaskBytes: 32 bytes per iteration, then words,
+// then single bytes.
+func maskWords(b []byte, key [4]byte) {
+	if len(b) >= 8 {
+		k := uint64(binary.LittleEndian.Uint32(key[:]))
+		k |= k << 32
+		for len(b) >= 32 {
+			binary.LittleEndian.PutUint64(b, binary.LittleEndian.Uint64(b)^k)
+			binary.LittleEndian.PutUint64(b[8:], binary.LittleEndian.Uint64(b[8:])^k)
+			binary.LittleEndian.PutUint64(b[16:], binary.LittleEndian.Uint64(b[16:])^k)
+			binary.LittleEndian.PutUint64(b[24:], binary.LittleEndian.Uint64(b[24:])^k)
+			b = b[32:]
+		}
+		for len(b) >= 8 {
+			binary.LittleEndian.PutUint64(b, binary.LittleEndian.Uint64(b)^k)
+			b = b[8:]
+		}
+	}
+	for i := range b {
+		b[i] ^= key[i&3]
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !purego
+// +build !purego
+
+// File: protocol/mask_amd64.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package protocol
+
+import "golang.org/x/sys/cpu"
+
+// maskAsmBlock is the byte granularity of maskBlocks.
+const maskAsmBlock = 32
+
+var useMaskAsm = cpu.X86.HasAVX2
+
+// maskBlocks XORs b, a multiple of maskAsmBlock long, with the key repeated.
+func maskBlocks(b []byte, key uint32) {
+	maskAVX2(&b[0], len(b), key)
+}
+
+//go:noescape
+func maskAVX2(b *byte, n int, key uint32)
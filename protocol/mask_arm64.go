@@ -0,0 +1,22 @@
+//go:build !purego
+// +build !purego
+
+// File: protocol/mask_arm64.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package protocol
+
+// maskAsmBlock is the byte granularity of maskBlocks.
+const maskAsmBlock = 16
+
+// NEON (ASIMD) is part of every arm64 core.
+const useMaskAsm = true
+
+// maskBlocks XORs b, a multiple of maskAsmBlock long, with the key repeated.
+func maskBlocks(b []byte, key uint32) {
+	maskNEON(&b[0], len(b), key)
+}
+
+//go:noescape
+func maskNEON(b *byte, n int, key uint32)
@@ -0,0 +1,40 @@
+//go:build !purego
+// +build !purego
+
+// File: protocol/mask_arm64.s
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+#include "textflag.h"
+
+// func maskNEON(b *byte, n int, key uint32)
+// n is a multiple of 16.
+TEXT ·maskNEON(SB), NOSPLIT, $0-20
+	MOVD  b+0(FP), R0
+	MOVD  n+8(FP), R1
+	MOVWU key+16(FP), R2
+	VDUP  R2, V0.S4
+
+loop64:
+	CMP  $64, R1
+	BLT  loop16
+	VLD1 (R0), [V1.B16, V2.B16, V3.B16, V4.B16]
+	VEOR V0.B16, V1.B16, V1.B16
+	VEOR V0.B16, V2.B16, V2.B16
+	VEOR V0.B16, V3.B16, V3.B16
+	VEOR V0.B16, V4.B16, V4.B16
+	VST1.P [V1.B16, V2.B16, V3.B16, V4.B16], 64(R0)
+	SUB  $64, R1, R1
+	B    loop64
+
+loop16:
+	CMP  $16, R1
+	BLT  done
+	VLD1 (R0), [V1.B16]
+	VEOR V0.B16, V1.B16, V1.B16
+	VST1.P [V1.B16], 16(R0)
+	SUB  $16, R1, R1
+	B    loop16
+
+done:
+	RET
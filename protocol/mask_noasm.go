@@ -0,0 +1,21 @@
+//go:build (!amd64 && !arm64) || purego
+// +build !amd64,!arm64 purego
+
+// File: protocol/mask_noasm.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package protocol
+
+// maskAsmBlock is the byte granularity of maskBlocks.
+const maskAsmBlock = 8
+
+// No assembly routine on this platform or with the purego tag.
+const useMaskAsm = false
+
+// maskBlocks is never called without assembly support.
+func maskBlocks(b []byte, key uint32) {
+	var k [4]byte
+	k[0], k[1], k[2], k[3] = byte(key), byte(key>>8), byte(key>>16), byte(key>>24)
+	maskWords(b, k)
+}
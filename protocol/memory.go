@@ -0,0 +1,69 @@
+// File: protocol/memory.go
+// Package protocol accounts for the memory held by a WSConnection.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package protocol
+
+import (
+	"sync/atomic"
+
+	"github.com/momentics/hioload-ws/api"
+)
+
+// MemUsage is the memory a connection holds, in bytes.
+type MemUsage struct {
+	ReadBuffer int64 `json:"read_buffer"` // partial frame buffered by the decoder
+	Outbox     int64 `json:"outbox"`      // payloads of frames queued for sending
+	InFlight   int64 `json:"in_flight"`   // pooled receive buffers handed out and not yet released
+}
+
+// Total returns the bytes of all categories.
+func (m MemUsage) Total() int64 {
+	return m.ReadBuffer + m.Outbox + m.InFlight
+}
+
+// MemUsage returns the memory the connection currently holds. Payloads
+// received whole in one read reference the transport's read buffer and are
+// not counted as in flight.
+func (c *WSConnection) MemUsage() MemUsage {
+	return MemUsage{
+		ReadBuffer: atomic.LoadInt64(&c.readHeld),
+		Outbox:     atomic.LoadInt64(&c.outboxHeld),
+		InFlight:   atomic.LoadInt64(&c.inFlight),
+	}
+}
+
+// noteReadBuffer records the bytes the decoder holds after a read.
+func (c *WSConnection) noteReadBuffer() {
+	atomic.StoreInt64(&c.readHeld, int64(c.frameDecoder().Buffered()))
+}
+
+// hold counts the pooled buffer b as in flight until it is released.
+func (c *WSConnection) hold(b api.Buffer) api.Buffer {
+	if b.Pool == nil {
+		return b
+	}
+	h := &heldBuffer{conn: c, pool: b.Pool, size: int64(len(b.Data))}
+	atomic.AddInt64(&c.inFlight, h.size)
+	b.Pool = h
+	return b
+}
+
+// heldBuffer stands in for the pool of a buffer handed out by RecvMessages,
+// settling the connection's account on release.
+type heldBuffer struct {
+	conn     *WSConnection
+	pool     api.Releaser
+	size     int64
+	released atomic.Bool
+}
+
+// Put returns b to its pool.
+func (h *heldBuffer) Put(b api.Buffer) {
+	if h.released.CompareAndSwap(false, true) {
+		atomic.AddInt64(&h.conn.inFlight, -h.size)
+	}
+	b.Pool = h.pool
+	h.pool.Put(b)
+}
@@ -0,0 +1,175 @@
+// File: protocol/outbox.go
+// Package protocol bounds the outbound queue of a WSConnection.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package protocol
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+)
+
+// ErrOutboxFull is returned by SendFrame when the outbox is at its high-water
+// mark and the slow-consumer policy rejects the frame.
+var ErrOutboxFull = fmt.Errorf("websocket outbox full: %w", api.ErrWriteQueueFull)
+
+// SlowConsumerPolicy selects what SendFrame does once the outbox holds
+// OutboxLimit.HighWater frames because the peer reads slower than the
+// application writes.
+type SlowConsumerPolicy string
+
+const (
+	// SlowConsumerBlock waits up to OutboxLimit.Wait for room, then fails
+	// the frame with ErrOutboxFull (default). A zero Wait blocks until the
+	// connection closes, the behaviour without a limit.
+	SlowConsumerBlock SlowConsumerPolicy = "block"
+	// SlowConsumerDropOldest discards the oldest queued frame to make room.
+	// Only suitable for streams of unfragmented, individually useful
+	// messages such as market data ticks.
+	SlowConsumerDropOldest SlowConsumerPolicy = "drop-oldest"
+	// SlowConsumerClose fails the frame and closes the connection with
+	// OutboxLimit.CloseCode.
+	SlowConsumerClose SlowConsumerPolicy = "close"
+)
+
+// slowConsumerGrace bounds the close handshake with a peer shed by
+// SlowConsumerClose, which may no longer be reading at all.
+const slowConsumerGrace = time.Second
+
+// OutboxLimit bounds the frames queued for sending on a connection.
+type OutboxLimit struct {
+	HighWater int                // queued frames before Policy applies (0 = channel capacity)
+	Policy    SlowConsumerPolicy // default SlowConsumerBlock
+	Wait      time.Duration      // SlowConsumerBlock timeout (0 = until closed)
+	CloseCode uint16             // SlowConsumerClose code, CloseTryAgainLater or ClosePolicyViolation (default 1013)
+}
+
+// SetOutboxLimit applies l to the connection. A positive HighWater resizes
+// the outbox and the PriorityHigh lane, so call it before the connection
+// starts sending, e.g. from a handshake hook.
+func (c *WSConnection) SetOutboxLimit(l OutboxLimit) {
+	if l.HighWater > 0 {
+		c.outbox = make(chan *WSFrame, l.HighWater)
+		c.highbox = make(chan *WSFrame, l.HighWater)
+	}
+	if l.CloseCode == 0 {
+		l.CloseCode = CloseTryAgainLater
+	}
+	c.outboxLimit.Store(&l)
+}
+
+// enqueue queues frame in its send lane, applying the slow-consumer policy
+// when the outbox is full. An unbuffered outbox always blocks. The payload
+// counts towards MemUsage.Outbox until the send loop takes the frame.
+func (c *WSConnection) enqueue(frame *WSFrame) error {
+	n := frame.PayloadLen
+	atomic.AddInt64(&c.outboxHeld, n)
+	err := c.enqueueLane(frame)
+	if err != nil {
+		atomic.AddInt64(&c.outboxHeld, -n)
+	}
+	return err
+}
+
+// dropFrame discards a frame the send loop took from a lane but may not
+// write, such as one queued behind the close frame.
+func (c *WSConnection) dropFrame(frame *WSFrame) {
+	frame.discard(ErrCloseSent)
+	atomic.AddInt64(&c.framesDropped, 1)
+	atomic.AddInt64(&c.outboxHeld, -frame.PayloadLen)
+	c.trace("frame dropped", "opcode", frame.Opcode)
+}
+
+// enqueueLane queues frame in the lane it belongs to.
+func (c *WSConnection) enqueueLane(frame *WSFrame) error {
+	outbox := c.outbox
+	switch frame.lane() {
+	case PriorityControl:
+		return c.enqueueControl(frame)
+	case PriorityHigh:
+		outbox = c.highbox
+	}
+	l := c.outboxLimit.Load()
+	if l == nil || l.Policy == "" || l.Policy == SlowConsumerBlock || cap(outbox) == 0 {
+		var wait time.Duration
+		if l != nil {
+			wait = l.Wait
+		}
+		return c.enqueueWait(outbox, frame, wait)
+	}
+
+	select {
+	case outbox <- frame:
+		return nil
+	case <-c.done:
+		frame.Buf.Release()
+		return api.ErrTransportClosed
+	default:
+	}
+	c.trace("outbox full", "policy", l.Policy)
+
+	if l.Policy == SlowConsumerDropOldest {
+		for {
+			select {
+			case old := <-outbox:
+				old.discard(ErrOutboxFull)
+				atomic.AddInt64(&c.framesDropped, 1)
+				atomic.AddInt64(&c.outboxHeld, -old.PayloadLen)
+			default:
+			}
+			select {
+			case outbox <- frame:
+				return nil
+			case <-c.done:
+				frame.Buf.Release()
+				return api.ErrTransportClosed
+			default:
+			}
+		}
+	}
+
+	frame.Buf.Release()
+	atomic.AddInt64(&c.framesDropped, 1)
+	go c.CloseWithCode(l.CloseCode, "slow consumer")
+	c.clock.AfterFunc(slowConsumerGrace, func() { c.Close() })
+	return ErrOutboxFull
+}
+
+// enqueueWait queues frame in lane, waiting for room up to wait (0 = until
+// closed), failing with ErrOutboxFull, and up to the frame's write deadline,
+// failing with ErrWriteTimeout.
+func (c *WSConnection) enqueueWait(lane chan *WSFrame, frame *WSFrame, wait time.Duration) error {
+	full := ErrOutboxFull
+	if frame.sendBy != 0 {
+		left := time.Unix(0, frame.sendBy).Sub(c.clock.Now())
+		if wait <= 0 || left < wait {
+			wait, full = max(left, time.Nanosecond), ErrWriteTimeout
+		}
+	}
+	var timeout <-chan time.Time
+	if wait > 0 {
+		select {
+		case lane <- frame:
+			return nil
+		default:
+		}
+		t := c.clock.NewTimer(wait)
+		defer t.Stop()
+		timeout = t.C()
+	}
+	select {
+	case lane <- frame:
+		return nil
+	case <-c.done:
+		frame.Buf.Release()
+		return api.ErrTransportClosed
+	case <-timeout:
+		c.trace("outbox full", "policy", SlowConsumerBlock, "err", full)
+		frame.Buf.Release()
+		return full
+	}
+}
@@ -0,0 +1,65 @@
+// File: protocol/priority.go
+// Package protocol implements the send lanes of a WSConnection.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package protocol
+
+// Priority selects the send lane of a frame queued by SendFrame. The send
+// loop drains the control lane first, then high, then normal, so liveness
+// traffic and latency-sensitive messages are not held behind bulk transfers
+// already queued.
+type Priority uint8
+
+const (
+	// PriorityNormal is the lane of data frames, bounded by OutboxLimit.
+	PriorityNormal Priority = iota
+	// PriorityHigh overtakes queued normal frames. Only use it for
+	// unfragmented messages: it may land between the fragments of a normal
+	// one, which RFC 6455 allows for control frames only.
+	PriorityHigh
+	// PriorityControl is the lane of close, ping and pong frames, implied by
+	// their opcodes; on a data frame it counts as PriorityHigh. The lane is
+	// exempt from the slow-consumer policy.
+	PriorityControl
+)
+
+// controlLaneSize is the capacity of the control lane.
+const controlLaneSize = 16
+
+// lane returns the send lane of f.
+func (f *WSFrame) lane() Priority {
+	switch {
+	case f.Opcode >= OpcodeClose:
+		return PriorityControl
+	case f.Priority >= PriorityHigh:
+		return PriorityHigh
+	}
+	return PriorityNormal
+}
+
+// enqueueControl queues a control frame, waiting for room if needed until
+// the frame's write deadline.
+func (c *WSConnection) enqueueControl(frame *WSFrame) error {
+	return c.enqueueWait(c.ctrlbox, frame, 0)
+}
+
+// nextFrame takes the next queued frame by priority, nil if none.
+func (c *WSConnection) nextFrame() *WSFrame {
+	select {
+	case f := <-c.ctrlbox:
+		return f
+	default:
+	}
+	select {
+	case f := <-c.highbox:
+		return f
+	default:
+	}
+	select {
+	case f := <-c.outbox:
+		return f
+	default:
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+// File: protocol/trace.go
+// Package protocol implements per-connection debug tracing.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package protocol
+
+import (
+	"github.com/momentics/hioload-ws/api"
+)
+
+// SetTrace enables tracing of this connection to l: every frame header, state
+// transition and buffer acquire/release is logged at Info level with the
+// connection's remote address and session ID. nil disables tracing. Safe to
+// call at any time.
+func (c *WSConnection) SetTrace(l api.Logger) {
+	if l == nil {
+		c.tracer.Store(nil)
+		c.trace("trace disabled")
+		return
+	}
+	c.tracer.Store(&l)
+	c.trace("trace enabled")
+}
+
+// Tracing reports whether tracing is enabled.
+func (c *WSConnection) Tracing() bool {
+	return c.tracer.Load() != nil
+}
+
+// Trace logs msg with args to the connection's tracer, if any, so layers
+// above the protocol (server, session) can add their own events.
+func (c *WSConnection) Trace(msg string, args ...any) {
+	c.trace(msg, args...)
+}
+
+func (c *WSConnection) trace(msg string, args ...any) {
+	l := c.tracer.Load()
+	if l == nil {
+		return
+	}
+	if ra := c.RemoteAddr(); ra != nil {
+		args = append(args, "remote", ra.String())
+	}
+	if sess := c.Session(); sess != nil {
+		args = append(args, "session", sess.ID())
+	}
+	(*l).Info(msg, args...)
+}
+
+// FrameObserver sees every frame of a connection, control frames included.
+// dir is "recv" for a frame as decoded, its payload unmasked but not yet
+// inflated or intercepted, and "send" for a frame as handed to the send
+// path, before interceptors and compression. f is only valid during the call.
+type FrameObserver func(dir string, f *WSFrame)
+
+// SetFrameObserver installs fn to see every frame received or sent; nil
+// removes it. Safe to call at any time; fn must not block.
+func (c *WSConnection) SetFrameObserver(fn FrameObserver) {
+	if fn == nil {
+		c.observer.Store(nil)
+		return
+	}
+	c.observer.Store(&fn)
+}
+
+// traceFrame logs the header of a frame received or sent ("recv"/"send") and
+// passes the frame to the observer.
+func (c *WSConnection) traceFrame(dir string, f *WSFrame) {
+	if fn := c.observer.Load(); fn != nil {
+		(*fn)(dir, f)
+	}
+	if c.tracer.Load() == nil {
+		return
+	}
+	c.trace("frame "+dir, "opcode", f.Opcode, "fin", f.IsFinal, "masked", f.Masked, "len", f.PayloadLen)
+}
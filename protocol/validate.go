@@ -0,0 +1,204 @@
+// File: protocol/validate.go
+// Package protocol implements strict frame validation and per-connection
+// compression handling on the receive and send paths.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// ErrProtocolViolation is returned by RecvZeroCopy when a frame fails
+// validation; the connection has already been closed with a matching code.
+var ErrProtocolViolation = errors.New("websocket protocol violation")
+
+// SetStrict toggles strict RFC 6455 validation of received frames: reserved
+// bits, unknown opcodes, fragmented or oversized control frames, client
+// masking, close payloads and UTF-8 text. Violations close the connection
+// with 1002 or 1007. Set it before the connection starts receiving.
+func (c *WSConnection) SetStrict(on bool) {
+	c.strict.Store(on)
+}
+
+// Strict reports whether strict validation is enabled.
+func (c *WSConnection) Strict() bool {
+	return c.strict.Load()
+}
+
+// SetCompression marks permessage-deflate as negotiated: received messages
+// with RSV1 are inflated and data messages of at least the compression
+// threshold, DeflateThreshold by default, are sent compressed. Set it during
+// the handshake.
+func (c *WSConnection) SetCompression(on bool) {
+	c.compress.Store(on)
+}
+
+// SetCompressionThreshold sets the smallest payload compressed on send;
+// n <= 0 restores DeflateThreshold.
+func (c *WSConnection) SetCompressionThreshold(n int) {
+	c.compressMin.Store(int64(n))
+}
+
+// compressionThreshold returns the smallest payload compressed on send.
+func (c *WSConnection) compressionThreshold() int64 {
+	if n := c.compressMin.Load(); n > 0 {
+		return n
+	}
+	return DeflateThreshold
+}
+
+// CompressionRatio returns the bytes sent for the messages that were large
+// enough to compress, over their uncompressed size: 0.25 means they went out
+// at a quarter of their size, 1 that compression did not help. It is 0 until
+// such a message is sent.
+func (c *WSConnection) CompressionRatio() float64 {
+	in := atomic.LoadInt64(&c.deflateIn)
+	if in == 0 {
+		return 0
+	}
+	return float64(atomic.LoadInt64(&c.deflateOut)) / float64(in)
+}
+
+// Compression reports whether permessage-deflate is in effect.
+func (c *WSConnection) Compression() bool {
+	return c.compress.Load()
+}
+
+// inboundPayload validates frame (when strict) and returns its application
+// payload, inflated if compressed. A non-zero code means the frame must be
+// rejected by closing with code and reason.
+func (c *WSConnection) inboundPayload(frame *WSFrame, fromClient bool) (payload []byte, code uint16, reason string) {
+	payload = frame.Payload
+	if len(payload) > int(frame.PayloadLen) {
+		payload = payload[:frame.PayloadLen]
+	}
+	strict := c.strict.Load()
+	compressed := frame.Rsv&Rsv1Bit != 0 && c.compress.Load()
+	if strict {
+		if code, reason = validateHeader(frame, fromClient, c.compress.Load()); code != 0 {
+			return nil, code, reason
+		}
+	}
+	if compressed {
+		if !frame.IsFinal {
+			return nil, CloseUnsupportedData, "fragmented compressed messages are not supported"
+		}
+		inflated, err := DecompressPayload(payload)
+		if errors.Is(err, ErrDeflateTooLarge) {
+			return nil, CloseMessageTooBig, err.Error()
+		}
+		if err != nil {
+			return nil, CloseInvalidPayloadData, "invalid compressed payload"
+		}
+		atomic.AddInt64(&c.inflateIn, int64(len(payload)))
+		atomic.AddInt64(&c.inflateOut, int64(len(inflated)))
+		payload = inflated
+	}
+	if strict {
+		switch {
+		case frame.Opcode == OpcodeText && frame.IsFinal && !utf8.Valid(payload):
+			return nil, CloseInvalidPayloadData, "invalid UTF-8 text"
+		case frame.Opcode == OpcodeClose:
+			if code, reason = validateClosePayload(payload); code != 0 {
+				return nil, code, reason
+			}
+		}
+	}
+	return payload, 0, ""
+}
+
+// validateHeader checks the frame header against RFC 6455 section 5.
+func validateHeader(f *WSFrame, fromClient, compress bool) (uint16, string) {
+	rsv := f.Rsv
+	if compress && (f.Opcode == OpcodeText || f.Opcode == OpcodeBinary) {
+		rsv &^= Rsv1Bit
+	}
+	switch {
+	case rsv != 0:
+		return CloseProtocolError, "reserved bits set"
+	case f.Masked != fromClient:
+		if fromClient {
+			return CloseProtocolError, "unmasked client frame"
+		}
+		return CloseProtocolError, "masked server frame"
+	}
+	switch f.Opcode {
+	case OpcodeContinuation, OpcodeText, OpcodeBinary:
+	case OpcodeClose, OpcodePing, OpcodePong:
+		if !f.IsFinal {
+			return CloseProtocolError, "fragmented control frame"
+		}
+		if f.PayloadLen > MaxControlPayloadLen {
+			return CloseProtocolError, "control frame too large"
+		}
+	default:
+		return CloseProtocolError, "unknown opcode"
+	}
+	return 0, ""
+}
+
+// ParseClosePayload returns the status code and reason of a close frame
+// payload, CloseNoStatusRcvd for one without a code. A payload RFC 6455
+// forbids, with a truncated or reserved code or a reason that is not UTF-8,
+// also returns an error wrapping ErrProtocolViolation; the code and reason
+// are still returned, as lenient peers use them.
+func ParseClosePayload(p []byte) (code uint16, reason string, err error) {
+	code = CloseNoStatusRcvd
+	if len(p) >= 2 {
+		code, reason = binary.BigEndian.Uint16(p), string(p[2:])
+	}
+	if _, msg := validateClosePayload(p); msg != "" {
+		err = fmt.Errorf("%w: %s", ErrProtocolViolation, msg)
+	}
+	return code, reason, err
+}
+
+// validateClosePayload checks the status code and UTF-8 reason of a close.
+func validateClosePayload(p []byte) (uint16, string) {
+	if len(p) == 0 {
+		return 0, ""
+	}
+	if len(p) == 1 {
+		return CloseProtocolError, "truncated close code"
+	}
+	code := uint16(p[0])<<8 | uint16(p[1])
+	switch {
+	case code < 1000, code >= 1004 && code <= 1006, code >= 1016 && code < 3000, code >= 5000:
+		return CloseProtocolError, "invalid close code"
+	case !utf8.Valid(p[2:]):
+		return CloseInvalidPayloadData, "invalid UTF-8 close reason"
+	}
+	return 0, ""
+}
+
+// outboundFrame returns f, or a compressed copy of it when compression is
+// negotiated and f is a complete data message worth compressing. The
+// returned frame must be encoded before f.Buf is released.
+func (c *WSConnection) outboundFrame(f *WSFrame) *WSFrame {
+	if !c.compress.Load() || !f.IsFinal || f.PayloadLen < c.compressionThreshold() ||
+		(f.Opcode != OpcodeText && f.Opcode != OpcodeBinary) {
+		return f
+	}
+	payload := f.Payload
+	if len(payload) > int(f.PayloadLen) {
+		payload = payload[:f.PayloadLen]
+	}
+	atomic.AddInt64(&c.deflateIn, int64(len(payload)))
+	compressed, err := CompressPayload(payload)
+	if err != nil || len(compressed) >= len(payload) {
+		atomic.AddInt64(&c.deflateOut, int64(len(payload)))
+		return f
+	}
+	atomic.AddInt64(&c.deflateOut, int64(len(compressed)))
+	cf := *f
+	cf.Rsv |= Rsv1Bit
+	cf.Payload = compressed
+	cf.PayloadLen = int64(len(compressed))
+	return &cf
+}
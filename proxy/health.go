@@ -0,0 +1,62 @@
+// File: proxy/health.go
+// Package proxy
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Health checks probe every backend each interval, concurrently and bounded
+// by the interval, and mark it healthy or not by the outcome.
+
+package proxy
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// healthLoop checks the backends every interval until Close.
+func (p *Proxy) healthLoop() {
+	tick := time.NewTicker(p.cfg.HealthInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-p.closed:
+			return
+		case <-tick.C:
+		}
+		p.checkAll()
+	}
+}
+
+// checkAll probes every backend once.
+func (p *Proxy) checkAll() {
+	p.mu.RLock()
+	backends := append([]*backend(nil), p.backends...)
+	p.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.cfg.HealthInterval)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, b := range backends {
+		wg.Add(1)
+		go func(b *backend) {
+			defer wg.Done()
+			b.healthy.Store(p.check(ctx, b) == nil)
+		}(b)
+	}
+	wg.Wait()
+}
+
+// check runs the configured health check, or connects to the backend.
+func (p *Proxy) check(ctx context.Context, b *backend) error {
+	if p.cfg.HealthCheck != nil {
+		return p.cfg.HealthCheck(ctx, b.url)
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", b.address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
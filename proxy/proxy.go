@@ -0,0 +1,399 @@
+// File: proxy/proxy.go
+// Package proxy relays WebSocket connections to upstream backends.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// A Proxy is a route handler: every client connection it serves is paired
+// with a connection to the healthy backend with the fewest relays, and
+// messages are relayed both ways without copying until either side closes,
+// whose close status is passed on to the other, or, with a StickyKey, with
+// the backend that key hashes to, see package router. Backends are WebSocket
+// servers (ws://, wss://) or raw TCP services (tcp://), which receive the
+// payload of each client message as bytes on the stream and whose bytes are
+// relayed as binary messages. Backends are health-checked in the background
+// and can be drained before removal:
+//
+//	p, _ := proxy.New(proxy.Config{Backends: []string{"ws://10.0.0.1:8080/ws", "ws://10.0.0.2:8080/ws"}})
+//	srv.HandleFunc("/ws", p.Serve)
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/momentics/hioload-ws/highlevel"
+	"github.com/momentics/hioload-ws/protocol"
+	"github.com/momentics/hioload-ws/router"
+)
+
+// Defaults applied to zero Config fields.
+const (
+	DefaultDialTimeout    = 5 * time.Second
+	DefaultHealthInterval = 5 * time.Second
+)
+
+// ErrUnknownBackend is returned for a backend the proxy does not have.
+var ErrUnknownBackend = errors.New("proxy: unknown backend")
+
+// Upstream is the connection the proxy opens for one client.
+type Upstream struct {
+	URL    string      // backend URL, may be rewritten, e.g. to carry the client's path
+	Header http.Header // sent with a WebSocket handshake
+}
+
+// Config configures a Proxy.
+type Config struct {
+	// Backends are the upstream URLs: ws:// or wss:// for WebSocket
+	// servers, tcp://host:port for byte-stream services.
+	Backends []string
+
+	// ForwardHeaders names the client's upgrade request headers copied to
+	// the upstream handshake, e.g. "Authorization" or "Cookie". The
+	// client's address is always appended to X-Forwarded-For.
+	ForwardHeaders []string
+
+	// Rewrite, if set, edits the upstream of each client before it is
+	// dialed, e.g. to add headers or route by path.
+	Rewrite func(c *highlevel.Conn, up *Upstream)
+
+	// StickyKey, if set, returns the key of a client, e.g. a user ID or a
+	// session token. Clients with the same key go to the same backend as
+	// long as it is usable; a consistent hash keeps most keys in place when
+	// backends are added or removed. Clients without a key, "", are
+	// balanced by relay count.
+	StickyKey func(c *highlevel.Conn) string
+
+	DialTimeout time.Duration // upstream connect and handshake (0 = DefaultDialTimeout)
+
+	// HealthInterval is the period of the health checks (0 =
+	// DefaultHealthInterval, < 0 = none). A backend failing its check, or
+	// a dial, takes no new clients until it passes one; without checks
+	// backends are always used.
+	HealthInterval time.Duration
+	// HealthCheck probes a backend; nil connects to its TCP address.
+	HealthCheck func(ctx context.Context, backend string) error
+}
+
+// BackendStatus describes a backend, see Proxy.Backends.
+type BackendStatus struct {
+	URL      string
+	Healthy  bool
+	Draining bool
+	Relays   int // clients currently relayed to it
+}
+
+// backend is an upstream and the relays it serves.
+type backend struct {
+	url     string
+	address string // host:port for the default health check
+	healthy atomic.Bool
+
+	mu       sync.Mutex
+	draining bool
+	relays   map[*relay]struct{}
+}
+
+// Proxy relays client connections to a set of backends.
+type Proxy struct {
+	cfg Config
+
+	mu       sync.RWMutex
+	backends []*backend
+	next     atomic.Uint64  // rotates the start of the least-relays scan
+	ring     *router.Router // backend URLs by sticky key, nil without StickyKey
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// New validates the backends of cfg and starts their health checks.
+func New(cfg Config) (*Proxy, error) {
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = DefaultDialTimeout
+	}
+	if cfg.HealthInterval == 0 {
+		cfg.HealthInterval = DefaultHealthInterval
+	}
+	p := &Proxy{cfg: cfg, closed: make(chan struct{})}
+	if cfg.StickyKey != nil {
+		p.ring = router.New()
+	}
+	for _, u := range cfg.Backends {
+		if err := p.Add(u); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.HealthInterval > 0 {
+		go p.healthLoop()
+	}
+	return p, nil
+}
+
+// Add starts relaying clients to backend, healthy until a check fails.
+func (p *Proxy) Add(backendURL string) error {
+	u, err := url.Parse(backendURL)
+	if err != nil {
+		return fmt.Errorf("proxy: backend %q: %w", backendURL, err)
+	}
+	defaultPort := map[string]string{"ws": "80", "wss": "443", "tcp": ""}
+	port, ok := defaultPort[u.Scheme]
+	if !ok {
+		return fmt.Errorf("proxy: backend %q: scheme must be ws, wss or tcp", backendURL)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	if u.Hostname() == "" || port == "" {
+		return fmt.Errorf("proxy: backend %q: no host and port", backendURL)
+	}
+	address := net.JoinHostPort(u.Hostname(), port)
+	b := &backend{url: backendURL, address: address, relays: make(map[*relay]struct{})}
+	b.healthy.Store(true)
+	p.mu.Lock()
+	for _, o := range p.backends {
+		if o.url == backendURL {
+			p.mu.Unlock()
+			return fmt.Errorf("proxy: backend %q added twice", backendURL)
+		}
+	}
+	p.backends = append(p.backends, b)
+	p.mu.Unlock()
+	if p.ring != nil {
+		p.ring.Add(backendURL)
+	}
+	return nil
+}
+
+// Drain stops relaying new clients to backend and waits for its relays to
+// end, then removes it. When ctx ends first, the remaining relays are closed
+// with 1001 (going away) and ctx's error is returned.
+func (p *Proxy) Drain(ctx context.Context, backendURL string) error {
+	b := p.find(backendURL)
+	if b == nil {
+		return ErrUnknownBackend
+	}
+	b.mu.Lock()
+	b.draining = true
+	b.mu.Unlock()
+	defer p.remove(b)
+
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	for b.count() > 0 {
+		select {
+		case <-ctx.Done():
+			b.closeAll(protocol.CloseGoingAway, "backend draining")
+			return ctx.Err()
+		case <-tick.C:
+		}
+	}
+	return nil
+}
+
+// Router returns the router assigning sticky keys to backend URLs, e.g. to
+// register an OnChange hook that moves the clients of a removed backend, or
+// nil without Config.StickyKey.
+func (p *Proxy) Router() *router.Router {
+	return p.ring
+}
+
+// Backends reports the state of every backend.
+func (p *Proxy) Backends() []BackendStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	out := make([]BackendStatus, len(p.backends))
+	for i, b := range p.backends {
+		b.mu.Lock()
+		out[i] = BackendStatus{URL: b.url, Healthy: b.healthy.Load(), Draining: b.draining, Relays: len(b.relays)}
+		b.mu.Unlock()
+	}
+	return out
+}
+
+// Close stops the health checks and closes every relay with 1001 (going
+// away); clients served afterwards are refused.
+func (p *Proxy) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+		for _, b := range p.backends {
+			b.closeAll(protocol.CloseGoingAway, "proxy closing")
+		}
+	})
+	return nil
+}
+
+// Serve relays c to a backend until either side closes. It is a route
+// handler: srv.HandleFunc("/ws", p.Serve). Without a usable backend, c is
+// closed with 1013 (try again later).
+func (p *Proxy) Serve(c *highlevel.Conn) {
+	select {
+	case <-p.closed:
+		c.CloseWithCode(protocol.CloseGoingAway, "proxy closing")
+		return
+	default:
+	}
+	var key string
+	if p.cfg.StickyKey != nil {
+		key = p.cfg.StickyKey(c)
+	}
+	b := p.pick(key)
+	if b == nil {
+		c.CloseWithCode(protocol.CloseTryAgainLater, "no backend available")
+		return
+	}
+	up := &Upstream{URL: b.url, Header: p.header(c)}
+	if p.cfg.Rewrite != nil {
+		p.cfg.Rewrite(c, up)
+	}
+	r, err := p.dial(c, up)
+	if err != nil {
+		if p.cfg.HealthInterval > 0 {
+			b.healthy.Store(false) // until the next check passes
+		}
+		c.CloseWithCode(protocol.CloseTryAgainLater, "backend unavailable")
+		return
+	}
+	if !b.attach(r) {
+		r.close(protocol.CloseTryAgainLater, "backend draining")
+		return
+	}
+	defer b.detach(r)
+	select {
+	case <-p.closed: // closed since the check above, after closing the relays
+		r.close(protocol.CloseGoingAway, "proxy closing")
+		return
+	default:
+	}
+	r.run()
+}
+
+// header returns the upstream handshake headers for c.
+func (p *Proxy) header(c *highlevel.Conn) http.Header {
+	h := make(http.Header)
+	req := c.RequestHeader()
+	for _, k := range p.cfg.ForwardHeaders {
+		for _, v := range req.Values(k) {
+			h.Add(k, v)
+		}
+	}
+	ip := c.RemoteAddr()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if prior := req.Get("X-Forwarded-For"); prior != "" {
+		ip = prior + ", " + ip
+	}
+	h.Set("X-Forwarded-For", ip)
+	return h
+}
+
+// pick returns the first usable backend key hashes to or, without a key,
+// the usable backend with the fewest relays; nil if none is usable.
+func (p *Proxy) pick(key string) *backend {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	n := len(p.backends)
+	if n == 0 {
+		return nil
+	}
+	if key != "" && p.ring != nil {
+		for _, u := range p.ring.Lookup(key, n) {
+			for _, b := range p.backends {
+				if b.url == u && b.usable() {
+					return b
+				}
+			}
+		}
+		return nil
+	}
+	start := int(p.next.Add(1) % uint64(n))
+	var best *backend
+	bestCount := 0
+	for i := 0; i < n; i++ {
+		b := p.backends[(start+i)%n]
+		if !b.usable() {
+			continue
+		}
+		if count := b.count(); best == nil || count < bestCount {
+			best, bestCount = b, count
+		}
+	}
+	return best
+}
+
+func (p *Proxy) find(backendURL string) *backend {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, b := range p.backends {
+		if b.url == backendURL {
+			return b
+		}
+	}
+	return nil
+}
+
+func (p *Proxy) remove(b *backend) {
+	p.mu.Lock()
+	p.backends = slices.DeleteFunc(p.backends, func(o *backend) bool { return o == b })
+	p.mu.Unlock()
+	if p.ring != nil {
+		p.ring.Remove(b.url)
+	}
+}
+
+// usable reports whether b is healthy and not draining.
+func (b *backend) usable() bool {
+	if !b.healthy.Load() {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.draining
+}
+
+// attach records r, reporting false if the backend started draining.
+func (b *backend) attach(r *relay) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.draining {
+		return false
+	}
+	b.relays[r] = struct{}{}
+	return true
+}
+
+func (b *backend) detach(r *relay) {
+	b.mu.Lock()
+	delete(b.relays, r)
+	b.mu.Unlock()
+}
+
+func (b *backend) count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.relays)
+}
+
+// closeAll closes every relay of b with code.
+func (b *backend) closeAll(code uint16, reason string) {
+	b.mu.Lock()
+	relays := make([]*relay, 0, len(b.relays))
+	for r := range b.relays {
+		relays = append(relays, r)
+	}
+	b.mu.Unlock()
+	for _, r := range relays {
+		r.close(code, reason)
+	}
+}
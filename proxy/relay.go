@@ -0,0 +1,167 @@
+// File: proxy/relay.go
+// Package proxy
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// A relay pairs a client with its upstream. Each direction runs on its own
+// goroutine and hands the buffers it reads to the other side's writer, which
+// releases them once sent, so payloads are not copied on the way.
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"sync"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/highlevel"
+	"github.com/momentics/hioload-ws/pool"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// streamReadSize is the buffer size of reads from a TCP upstream.
+const streamReadSize = 16 << 10
+
+// relay is one client and its upstream: a WebSocket connection or a stream.
+type relay struct {
+	client *highlevel.Conn
+	ws     *highlevel.Conn
+	stream net.Conn
+
+	closeOnce sync.Once
+}
+
+// dial opens the upstream of c.
+func (p *Proxy) dial(c *highlevel.Conn, up *Upstream) (*relay, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.cfg.DialTimeout)
+	defer cancel()
+	u, err := url.Parse(up.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "tcp" {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", u.Host)
+		if err != nil {
+			return nil, err
+		}
+		return &relay{client: c, stream: conn}, nil
+	}
+	var opts []highlevel.DialOption
+	for k, vs := range up.Header {
+		for _, v := range vs {
+			opts = append(opts, highlevel.WithHeader(k, v))
+		}
+	}
+	if sp := c.Subprotocol(); sp != "" {
+		opts = append(opts, highlevel.DialWithSubprotocols(sp))
+	}
+	ws, err := highlevel.DialContext(ctx, up.URL, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &relay{client: c, ws: ws}, nil
+}
+
+// run relays until both directions ended.
+func (r *relay) run() {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	if r.ws != nil {
+		go func() { defer wg.Done(); pump(r.ws, r.client) }()
+		go func() { defer wg.Done(); pump(r.client, r.ws) }()
+	} else {
+		go func() { defer wg.Done(); r.toStream() }()
+		go func() { defer wg.Done(); r.fromStream() }()
+	}
+	wg.Wait()
+}
+
+// close ends the relay, closing both sides with code.
+func (r *relay) close(code uint16, reason string) {
+	r.closeOnce.Do(func() {
+		r.client.CloseWithCode(code, reason)
+		if r.ws != nil {
+			r.ws.CloseWithCode(code, reason)
+		} else {
+			r.stream.Close()
+		}
+	})
+}
+
+// pump relays the messages of src to dst until src ends, then closes dst
+// with src's close status.
+func pump(dst, src *highlevel.Conn) {
+	for {
+		mt, buf, err := src.ReadBuffer()
+		if err != nil {
+			closeAfter(dst, err)
+			return
+		}
+		if err := dst.WriteBuffer(mt, buf); err != nil {
+			src.CloseWithCode(protocol.CloseGoingAway, "peer gone")
+			return
+		}
+	}
+}
+
+// closeAfter closes c after its peer ended with err, passing on the peer's
+// close status where it may be sent.
+func closeAfter(c *highlevel.Conn, err error) {
+	var ce *api.CloseError
+	if errors.As(err, &ce) {
+		switch ce.Code {
+		case protocol.CloseNoStatusRcvd:
+			c.CloseWithCode(protocol.CloseNormalClosure, "")
+			return
+		case protocol.CloseAbnormalClosure, 1015: // never sent on the wire
+		default:
+			c.CloseWithCode(ce.Code, ce.Reason)
+			return
+		}
+	}
+	c.CloseWithCode(protocol.CloseGoingAway, "peer gone")
+}
+
+// toStream writes the payload of each client message to the stream.
+func (r *relay) toStream() {
+	for {
+		_, buf, err := r.client.ReadBuffer()
+		if err != nil {
+			r.stream.Close()
+			return
+		}
+		_, err = r.stream.Write(buf.Bytes())
+		buf.Release()
+		if err != nil {
+			r.client.CloseWithCode(protocol.CloseGoingAway, "peer gone")
+			return
+		}
+	}
+}
+
+// fromStream sends what the stream delivers to the client as binary
+// messages.
+func (r *relay) fromStream() {
+	bp := pool.DefaultPool(streamReadSize, -1)
+	for {
+		buf := bp.Get(streamReadSize, -1)
+		n, err := r.stream.Read(buf.Bytes())
+		if n > 0 {
+			buf = buf.Slice(0, n)
+			if werr := r.client.WriteBuffer(int(highlevel.BinaryMessage), buf); werr != nil {
+				r.stream.Close()
+				return
+			}
+		} else {
+			buf.Release()
+		}
+		if err != nil {
+			r.client.CloseWithCode(protocol.CloseGoingAway, "peer gone")
+			return
+		}
+	}
+}
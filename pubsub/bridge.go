@@ -0,0 +1,199 @@
+// File: pubsub/bridge.go
+// Package pubsub
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// A Bridge replicates broker traffic between hioload-ws instances through an
+// external message bus (Redis, NATS, ...), so a Publish on one node reaches
+// subscribers on every node behind the load balancer. Locally published
+// messages matching the bridge pattern are forwarded to the Remote wrapped in
+// a small envelope naming the origin node; envelopes received from other
+// nodes are re-published locally with Message.Origin set, which keeps them
+// from being forwarded again. Envelopes a node receives from itself are ignored.
+
+package pubsub
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// Remote is the cross-node transport used by a Bridge.
+type Remote interface {
+	// Publish sends an encoded envelope for topic to all nodes.
+	Publish(topic string, data []byte) error
+	// Subscribe delivers envelopes published by any node (including this
+	// one) to fn until Close is called.
+	Subscribe(fn func(topic string, data []byte)) error
+	// Close releases the transport.
+	Close() error
+}
+
+// envelopeVersion tags the wire format of bridged messages.
+const envelopeVersion = 1
+
+// ErrBadEnvelope is reported for remote data not produced by a Bridge.
+var ErrBadEnvelope = errors.New("pubsub: malformed bridge envelope")
+
+// BridgeOption customizes a Bridge.
+type BridgeOption func(*Bridge)
+
+// WithBridgePattern limits replication to topics matching pattern
+// (default ">" = everything).
+func WithBridgePattern(pattern string) BridgeOption {
+	return func(br *Bridge) {
+		br.pattern = pattern
+	}
+}
+
+// WithNodeID sets the identifier stamped on outgoing messages (default random).
+// It must be unique per instance and at most 255 bytes.
+func WithNodeID(id string) BridgeOption {
+	return func(br *Bridge) {
+		br.nodeID = id
+	}
+}
+
+// WithBridgeQueue sets the outbound queue length and overflow policy used
+// while the Remote is slower than local publishers.
+func WithBridgeQueue(n int, p Policy) BridgeOption {
+	return func(br *Bridge) {
+		br.queue = []SubscribeOption{WithQueueSize(n), WithPolicy(p)}
+	}
+}
+
+// OnBridgeError registers a callback for Remote and decoding errors.
+func OnBridgeError(fn func(error)) BridgeOption {
+	return func(br *Bridge) {
+		br.onErr = fn
+	}
+}
+
+// Bridge connects a local Broker to a Remote.
+type Bridge struct {
+	broker  *Broker
+	remote  Remote
+	pattern string
+	nodeID  string
+	queue   []SubscribeOption
+	onErr   func(error)
+
+	sub       *Subscription
+	sent      atomic.Uint64
+	received  atomic.Uint64
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewBridge starts replicating between b and remote.
+func NewBridge(b *Broker, remote Remote, opts ...BridgeOption) (*Bridge, error) {
+	br := &Bridge{
+		broker:  b,
+		remote:  remote,
+		pattern: WildcardTail,
+		done:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(br)
+	}
+	if br.nodeID == "" {
+		br.nodeID = randomNodeID()
+	}
+	if len(br.nodeID) > 255 {
+		return nil, errors.New("pubsub: node ID longer than 255 bytes")
+	}
+	sub, err := b.Subscribe(br.pattern, br.queue...)
+	if err != nil {
+		return nil, err
+	}
+	br.sub = sub
+	if err := remote.Subscribe(br.receive); err != nil {
+		sub.Unsubscribe()
+		return nil, err
+	}
+	go br.forward()
+	return br, nil
+}
+
+// NodeID returns the identifier of this instance.
+func (br *Bridge) NodeID() string {
+	return br.nodeID
+}
+
+// Stats returns the number of messages sent to and received from other nodes.
+func (br *Bridge) Stats() (sent, received uint64) {
+	return br.sent.Load(), br.received.Load()
+}
+
+// Close stops replication and closes the Remote.
+func (br *Bridge) Close() error {
+	var err error
+	br.closeOnce.Do(func() {
+		br.sub.Unsubscribe()
+		<-br.done
+		err = br.remote.Close()
+	})
+	return err
+}
+
+// forward ships local messages to the Remote.
+func (br *Bridge) forward() {
+	defer close(br.done)
+	for msg := range br.sub.C() {
+		if msg.Origin != "" {
+			continue // replicated from another node
+		}
+		if err := br.remote.Publish(msg.Topic, br.encode(msg.Payload)); err != nil {
+			br.report(err)
+			continue
+		}
+		br.sent.Add(1)
+	}
+}
+
+// receive re-publishes a remote envelope locally.
+func (br *Bridge) receive(topic string, data []byte) {
+	origin, payload, err := decodeEnvelope(data)
+	if err != nil {
+		br.report(err)
+		return
+	}
+	if origin == br.nodeID {
+		return
+	}
+	br.received.Add(1)
+	br.broker.PublishMessage(Message{Topic: topic, Payload: payload, Origin: origin})
+}
+
+// encode frames payload as [version][len(node)][node][payload].
+func (br *Bridge) encode(payload []byte) []byte {
+	out := make([]byte, 0, 2+len(br.nodeID)+len(payload))
+	out = append(out, envelopeVersion, byte(len(br.nodeID)))
+	out = append(out, br.nodeID...)
+	return append(out, payload...)
+}
+
+// decodeEnvelope splits an envelope into origin node and payload.
+func decodeEnvelope(data []byte) (string, []byte, error) {
+	if len(data) < 2 || data[0] != envelopeVersion || len(data) < 2+int(data[1]) {
+		return "", nil, ErrBadEnvelope
+	}
+	n := 2 + int(data[1])
+	return string(data[2:n]), data[n:], nil
+}
+
+// randomNodeID returns a 64-bit random hex identifier.
+func randomNodeID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func (br *Bridge) report(err error) {
+	if br.onErr != nil {
+		br.onErr(err)
+	}
+}
@@ -0,0 +1,165 @@
+// File: pubsub/broker.go
+// Package pubsub
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package pubsub
+
+import "sync"
+
+// Option customizes Broker construction.
+type Option func(*Broker)
+
+// WithDefaultQueueSize sets the queue length used by subscriptions that do
+// not specify one.
+func WithDefaultQueueSize(n int) Option {
+	return func(b *Broker) {
+		if n > 0 {
+			b.queueSize = n
+		}
+	}
+}
+
+// WithDefaultPolicy sets the overflow policy used by subscriptions that do
+// not specify one.
+func WithDefaultPolicy(p Policy) Option {
+	return func(b *Broker) {
+		b.policy = p
+	}
+}
+
+// Broker routes published messages to matching subscriptions. Exact-topic
+// subscriptions are found by map lookup; wildcard ones are matched per publish.
+type Broker struct {
+	queueSize int
+	policy    Policy
+
+	mu     sync.RWMutex
+	exact  map[string]map[*Subscription]struct{}
+	wild   map[*Subscription]struct{}
+	closed bool
+}
+
+// New creates an empty Broker.
+func New(opts ...Option) *Broker {
+	b := &Broker{
+		queueSize: DefaultQueueSize,
+		policy:    DropOldest,
+		exact:     make(map[string]map[*Subscription]struct{}),
+		wild:      make(map[*Subscription]struct{}),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Subscribe registers interest in topics matching pattern.
+func (b *Broker) Subscribe(pattern string, opts ...SubscribeOption) (*Subscription, error) {
+	pat, err := parsePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	cfg := subConfig{queueSize: b.queueSize, policy: b.policy}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	s := newSubscription(b, pat, cfg)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+	if pat.wildcard {
+		b.wild[s] = struct{}{}
+	} else {
+		set := b.exact[pat.raw]
+		if set == nil {
+			set = make(map[*Subscription]struct{})
+			b.exact[pat.raw] = set
+		}
+		set[s] = struct{}{}
+	}
+	return s, nil
+}
+
+// Publish delivers payload to every subscription matching topic and returns
+// how many accepted it (a DropNewest subscriber with a full queue does not).
+// payload is shared, not copied.
+func (b *Broker) Publish(topic string, payload []byte) int {
+	return b.PublishMessage(Message{Topic: topic, Payload: payload})
+}
+
+// PublishMessage is Publish for a fully populated Message, e.g. one carrying
+// the Origin of a remote node.
+func (b *Broker) PublishMessage(msg Message) int {
+	topic := msg.Topic
+	delivered := 0
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.exact[topic] {
+		if s.deliver(msg) {
+			delivered++
+		}
+	}
+	for s := range b.wild {
+		if s.pattern.match(topic) && s.deliver(msg) {
+			delivered++
+		}
+	}
+	return delivered
+}
+
+// Subscribers returns the number of active subscriptions.
+func (b *Broker) Subscribers() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	n := len(b.wild)
+	for _, set := range b.exact {
+		n += len(set)
+	}
+	return n
+}
+
+// Close unsubscribes everyone; further Subscribe calls fail with ErrClosed.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.closed = true
+	var subs []*Subscription
+	for s := range b.wild {
+		subs = append(subs, s)
+	}
+	for _, set := range b.exact {
+		for s := range set {
+			subs = append(subs, s)
+		}
+	}
+	b.exact = make(map[string]map[*Subscription]struct{})
+	b.wild = make(map[*Subscription]struct{})
+	b.mu.Unlock()
+
+	for _, s := range subs {
+		s.close()
+	}
+}
+
+// remove unregisters s.
+func (b *Broker) remove(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if s.pattern.wildcard {
+		delete(b.wild, s)
+		return
+	}
+	if set := b.exact[s.pattern.raw]; set != nil {
+		delete(set, s)
+		if len(set) == 0 {
+			delete(b.exact, s.pattern.raw)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// File: pubsub/clusterbridge/remote.go
+// Package clusterbridge implements a pubsub.Remote over cluster membership.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Publishes are sent to every live member of the cluster over its streams,
+// so a Bridge replicates broker traffic between instances that discovered
+// each other by gossip, without an external bus. Nodes joining later only
+// receive what is published after they joined.
+//
+//	c, err := cluster.New(cluster.Config{Seeds: []string{"10.0.0.1:7946"}})
+//	bridge, err := pubsub.NewBridge(broker, clusterbridge.New(c), pubsub.WithNodeID(c.LocalNode().ID))
+
+package clusterbridge
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sync"
+
+	"github.com/momentics/hioload-ws/cluster"
+	"github.com/momentics/hioload-ws/pubsub"
+)
+
+// DefaultService names the cluster messages carrying bridge envelopes.
+const DefaultService = "pubsub"
+
+// Ensure compile-time interface compliance.
+var _ pubsub.Remote = (*Remote)(nil)
+
+// ErrClosed is returned after Close.
+var ErrClosed = errors.New("clusterbridge: closed")
+
+// Option customizes a Remote.
+type Option func(*Remote)
+
+// WithService sets the cluster service the Remote sends and receives on
+// (default DefaultService), e.g. to run several bridges over one cluster.
+func WithService(name string) Option {
+	return func(r *Remote) {
+		r.service = name
+	}
+}
+
+// Remote is a pubsub.Remote backed by a Cluster.
+type Remote struct {
+	c       *cluster.Cluster
+	service string
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// New creates a Remote publishing to the members of c. Closing the Remote
+// leaves c running.
+func New(c *cluster.Cluster, opts ...Option) *Remote {
+	r := &Remote{c: c, service: DefaultService}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Publish sends data for topic to every other live member. Members that
+// cannot be reached miss it; the error names them.
+func (r *Remote) Publish(topic string, data []byte) error {
+	if r.isClosed() {
+		return ErrClosed
+	}
+	if len(topic) > math.MaxUint16 {
+		return errors.New("clusterbridge: topic too long")
+	}
+	msg := make([]byte, 0, 2+len(topic)+len(data))
+	msg = binary.BigEndian.AppendUint16(msg, uint16(len(topic)))
+	msg = append(append(msg, topic...), data...)
+	return r.c.Broadcast(r.service, msg)
+}
+
+// Subscribe delivers the envelopes other members publish to fn.
+func (r *Remote) Subscribe(fn func(topic string, data []byte)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return ErrClosed
+	}
+	r.c.Handle(r.service, func(_ string, msg []byte) {
+		if len(msg) < 2 {
+			return
+		}
+		n := 2 + int(binary.BigEndian.Uint16(msg))
+		if len(msg) < n {
+			return
+		}
+		fn(string(msg[2:n]), msg[n:])
+	})
+	return nil
+}
+
+// Close stops delivering envelopes.
+func (r *Remote) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.closed {
+		r.closed = true
+		r.c.Handle(r.service, nil)
+	}
+	return nil
+}
+
+func (r *Remote) isClosed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closed
+}
@@ -0,0 +1,74 @@
+// File: pubsub/pattern.go
+// Package pubsub
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package pubsub
+
+import "strings"
+
+// pattern is a parsed subscription pattern.
+type pattern struct {
+	raw      string
+	segments []string
+	wildcard bool
+}
+
+// parsePattern validates and splits p.
+func parsePattern(p string) (pattern, error) {
+	if p == "" {
+		return pattern{}, ErrInvalidPattern
+	}
+	segs := strings.Split(p, ".")
+	wild := false
+	for i, s := range segs {
+		switch {
+		case s == "":
+			return pattern{}, ErrInvalidPattern
+		case s == WildcardTail && i != len(segs)-1:
+			return pattern{}, ErrInvalidPattern
+		case s == WildcardOne || s == WildcardTail:
+			wild = true
+		}
+	}
+	return pattern{raw: p, segments: segs, wildcard: wild}, nil
+}
+
+// Match reports whether topic matches the subscription pattern p.
+// Invalid patterns match nothing.
+func Match(p, topic string) bool {
+	pat, err := parsePattern(p)
+	if err != nil {
+		return false
+	}
+	return pat.match(topic)
+}
+
+// match walks topic segments against the pattern without allocating.
+func (p pattern) match(topic string) bool {
+	if !p.wildcard {
+		return p.raw == topic
+	}
+	rest := topic
+	for _, seg := range p.segments {
+		if rest == "" {
+			return false
+		}
+		if seg == WildcardTail {
+			return true // ">" needs at least one segment, checked above
+		}
+		var head string
+		if dot := strings.IndexByte(rest, '.'); dot >= 0 {
+			head, rest = rest[:dot], rest[dot+1:]
+			if rest == "" {
+				return false // trailing dot
+			}
+		} else {
+			head, rest = rest, ""
+		}
+		if head == "" || (seg != WildcardOne && seg != head) {
+			return false
+		}
+	}
+	return rest == ""
+}
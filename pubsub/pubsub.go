@@ -0,0 +1,61 @@
+// File: pubsub/pubsub.go
+// Package pubsub provides an in-process topic broker with wildcard
+// subscriptions and bounded per-subscriber queues.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Topics are dot-separated ("orders.eu.created"). Subscription patterns may
+// use "*" to match exactly one segment ("orders.*.created") and a trailing ">"
+// to match one or more remaining segments ("orders.>"). Each subscriber owns a
+// bounded queue; when it falls behind, the configured Policy decides whether
+// the oldest queued or the newest message is dropped, so a slow consumer never
+// stalls publishers.
+
+package pubsub
+
+import "errors"
+
+// Wildcard tokens accepted in subscription patterns.
+const (
+	WildcardOne  = "*"
+	WildcardTail = ">"
+)
+
+// DefaultQueueSize is the per-subscriber queue length when none is configured.
+const DefaultQueueSize = 256
+
+var (
+	// ErrInvalidPattern is returned for empty segments or a misplaced ">".
+	ErrInvalidPattern = errors.New("pubsub: invalid pattern")
+	// ErrClosed is returned when subscribing to a closed Broker.
+	ErrClosed = errors.New("pubsub: broker closed")
+)
+
+// Message is one published payload. Payload is shared by all subscribers and
+// must be treated as read-only.
+type Message struct {
+	Topic   string
+	Payload []byte
+	Origin  string // node ID for messages replicated by a Bridge; "" if local
+}
+
+// Policy selects which message is dropped when a subscriber queue is full.
+type Policy int
+
+const (
+	// DropOldest discards the oldest queued message to admit the new one.
+	DropOldest Policy = iota
+	// DropNewest discards the message being published.
+	DropNewest
+)
+
+// String returns the policy name.
+func (p Policy) String() string {
+	switch p {
+	case DropOldest:
+		return "drop-oldest"
+	case DropNewest:
+		return "drop-newest"
+	}
+	return "unknown"
+}
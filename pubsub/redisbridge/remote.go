@@ -0,0 +1,278 @@
+// File: pubsub/redisbridge/remote.go
+// Package redisbridge implements a pubsub.Remote over Redis Pub/Sub.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Each topic maps to the Redis channel prefix+topic. One connection issues
+// PUBLISH commands; a second one holds a PSUBSCRIBE on prefix+"*" and is
+// re-established with exponential backoff if it drops. The client speaks RESP
+// directly, so the bridge adds no module dependencies.
+//
+//	remote := redisbridge.New("redis:6379", redisbridge.WithPrefix("chat:"))
+//	bridge, err := pubsub.NewBridge(broker, remote)
+
+package redisbridge
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/momentics/hioload-ws/pubsub"
+)
+
+// DefaultPrefix namespaces bridge channels in Redis.
+const DefaultPrefix = "hioload:pubsub:"
+
+// Backoff bounds for re-establishing the subscription connection.
+const (
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 5 * time.Second
+)
+
+// Ensure compile-time interface compliance.
+var _ pubsub.Remote = (*Remote)(nil)
+
+// ErrClosed is returned after Close.
+var ErrClosed = errors.New("redisbridge: closed")
+
+// Option customizes a Remote.
+type Option func(*Remote)
+
+// WithPrefix sets the channel namespace (default DefaultPrefix).
+func WithPrefix(prefix string) Option {
+	return func(r *Remote) {
+		r.prefix = prefix
+	}
+}
+
+// WithPassword authenticates both connections with AUTH.
+func WithPassword(password string) Option {
+	return func(r *Remote) {
+		r.password = password
+	}
+}
+
+// WithTimeout bounds dialing and each PUBLISH round trip (default 2s).
+func WithTimeout(d time.Duration) Option {
+	return func(r *Remote) {
+		r.timeout = d
+	}
+}
+
+// OnError registers a callback for connection errors of the subscriber loop.
+func OnError(fn func(error)) Option {
+	return func(r *Remote) {
+		r.onErr = fn
+	}
+}
+
+// Remote is a pubsub.Remote backed by a Redis server.
+type Remote struct {
+	addr     string
+	prefix   string
+	password string
+	timeout  time.Duration
+	onErr    func(error)
+
+	pubMu sync.Mutex
+	pub   net.Conn
+	pubRd *bufio.Reader
+
+	subMu  sync.Mutex
+	sub    net.Conn
+	closed bool
+	stop   chan struct{}
+}
+
+// New creates a Remote for the Redis server at addr. No connection is made
+// until Publish or Subscribe.
+func New(addr string, opts ...Option) *Remote {
+	r := &Remote{
+		addr:    addr,
+		prefix:  DefaultPrefix,
+		timeout: 2 * time.Second,
+		stop:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Publish sends data on the channel for topic.
+func (r *Remote) Publish(topic string, data []byte) error {
+	r.pubMu.Lock()
+	defer r.pubMu.Unlock()
+	if r.isClosed() {
+		return ErrClosed
+	}
+	if r.pub == nil {
+		conn, rd, err := r.dial()
+		if err != nil {
+			return err
+		}
+		r.pub, r.pubRd = conn, rd
+	}
+	r.pub.SetDeadline(time.Now().Add(r.timeout))
+	cmd := appendCommand(nil, []byte("PUBLISH"), []byte(r.prefix+topic), data)
+	if _, err := r.pub.Write(cmd); err != nil {
+		r.resetPub()
+		return fmt.Errorf("redisbridge: publish: %w", err)
+	}
+	if _, err := readReply(r.pubRd); err != nil {
+		var replyErr redisError
+		if !errors.As(err, &replyErr) {
+			r.resetPub()
+		}
+		return err
+	}
+	return nil
+}
+
+// Subscribe connects the subscriber and delivers messages to fn from a
+// background goroutine, reconnecting as needed until Close. The first
+// connection attempt is synchronous so configuration errors surface here.
+func (r *Remote) Subscribe(fn func(topic string, data []byte)) error {
+	conn, rd, err := r.subscribe()
+	if err != nil {
+		return err
+	}
+	go r.subLoop(conn, rd, fn)
+	return nil
+}
+
+// Close stops the subscriber loop and closes both connections.
+func (r *Remote) Close() error {
+	r.subMu.Lock()
+	if r.closed {
+		r.subMu.Unlock()
+		return nil
+	}
+	r.closed = true
+	close(r.stop)
+	if r.sub != nil {
+		r.sub.Close()
+	}
+	r.subMu.Unlock()
+
+	r.pubMu.Lock()
+	r.resetPub()
+	r.pubMu.Unlock()
+	return nil
+}
+
+// subLoop reads push messages, redialing with backoff after failures.
+func (r *Remote) subLoop(conn net.Conn, rd *bufio.Reader, fn func(topic string, data []byte)) {
+	backoff := minBackoff
+	for {
+		if conn != nil {
+			err := r.readMessages(rd, fn)
+			conn.Close()
+			if r.isClosed() {
+				return
+			}
+			r.report(err)
+			backoff = minBackoff
+		}
+		select {
+		case <-r.stop:
+			return
+		case <-time.After(backoff):
+		}
+		var err error
+		if conn, rd, err = r.subscribe(); err != nil {
+			r.report(err)
+			conn = nil
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+	}
+}
+
+// readMessages dispatches pmessage pushes until the connection fails.
+func (r *Remote) readMessages(rd *bufio.Reader, fn func(topic string, data []byte)) error {
+	for {
+		reply, err := readReply(rd)
+		if err != nil {
+			return err
+		}
+		items, ok := reply.([]any)
+		if !ok || len(items) != 4 || asString(items[0]) != "pmessage" {
+			continue // subscription confirmations and pings
+		}
+		data, _ := items[3].([]byte)
+		fn(strings.TrimPrefix(asString(items[2]), r.prefix), data)
+	}
+}
+
+// subscribe dials and issues PSUBSCRIBE, registering the connection for Close.
+func (r *Remote) subscribe() (net.Conn, *bufio.Reader, error) {
+	conn, rd, err := r.dial()
+	if err != nil {
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Now().Add(r.timeout))
+	if _, err := conn.Write(appendCommand(nil, []byte("PSUBSCRIBE"), []byte(r.prefix+"*"))); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("redisbridge: psubscribe: %w", err)
+	}
+	if _, err := readReply(rd); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("redisbridge: psubscribe: %w", err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	r.subMu.Lock()
+	defer r.subMu.Unlock()
+	if r.closed {
+		conn.Close()
+		return nil, nil, ErrClosed
+	}
+	r.sub = conn
+	return conn, rd, nil
+}
+
+// dial connects and authenticates.
+func (r *Remote) dial() (net.Conn, *bufio.Reader, error) {
+	conn, err := net.DialTimeout("tcp", r.addr, r.timeout)
+	if err != nil {
+		return nil, nil, fmt.Errorf("redisbridge: dial: %w", err)
+	}
+	rd := bufio.NewReader(conn)
+	if r.password != "" {
+		conn.SetDeadline(time.Now().Add(r.timeout))
+		conn.Write(appendCommand(nil, []byte("AUTH"), []byte(r.password)))
+		if _, err := readReply(rd); err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("redisbridge: auth: %w", err)
+		}
+		conn.SetDeadline(time.Time{})
+	}
+	return conn, rd, nil
+}
+
+// resetPub drops the publish connection; caller holds pubMu.
+func (r *Remote) resetPub() {
+	if r.pub != nil {
+		r.pub.Close()
+	}
+	r.pub, r.pubRd = nil, nil
+}
+
+func (r *Remote) isClosed() bool {
+	r.subMu.Lock()
+	defer r.subMu.Unlock()
+	return r.closed
+}
+
+func (r *Remote) report(err error) {
+	if r.onErr != nil && err != nil {
+		r.onErr(err)
+	}
+}
@@ -0,0 +1,92 @@
+// File: pubsub/redisbridge/resp.go
+// Package redisbridge
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Minimal RESP2 codec: enough to issue commands and read the replies and
+// push messages used by PUBLISH/PSUBSCRIBE.
+
+package redisbridge
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// redisError is an error reply ("-ERR ...") returned by the server.
+type redisError string
+
+func (e redisError) Error() string { return "redisbridge: " + string(e) }
+
+// appendCommand encodes args as a RESP array of bulk strings.
+func appendCommand(buf []byte, args ...[]byte) []byte {
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, a := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(a)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, a...)
+		buf = append(buf, '\r', '\n')
+	}
+	return buf
+}
+
+// readReply decodes one RESP value: simple string, error, integer, bulk
+// string ([]byte, nil when absent) or array ([]any).
+func readReply(rd *bufio.Reader) (any, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 {
+		return nil, errors.New("redisbridge: short reply")
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(rd, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readReply(rd); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redisbridge: unexpected reply %q", line)
+}
+
+// asString converts a bulk or simple string reply element.
+func asString(v any) string {
+	switch s := v.(type) {
+	case []byte:
+		return string(s)
+	case string:
+		return s
+	}
+	return ""
+}
@@ -0,0 +1,120 @@
+// File: pubsub/subscription.go
+// Package pubsub
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package pubsub
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// SubscribeOption customizes a single subscription.
+type SubscribeOption func(*subConfig)
+
+type subConfig struct {
+	queueSize int
+	policy    Policy
+}
+
+// WithQueueSize bounds the subscription queue to n messages.
+func WithQueueSize(n int) SubscribeOption {
+	return func(c *subConfig) {
+		if n > 0 {
+			c.queueSize = n
+		}
+	}
+}
+
+// WithPolicy selects the overflow policy of the subscription.
+func WithPolicy(p Policy) SubscribeOption {
+	return func(c *subConfig) {
+		c.policy = p
+	}
+}
+
+// Subscription is a bounded queue of messages matching one pattern.
+type Subscription struct {
+	broker  *Broker
+	pattern pattern
+	policy  Policy
+
+	mu      sync.Mutex // serializes producers and close
+	ch      chan Message
+	closed  bool
+	dropped atomic.Uint64
+}
+
+func newSubscription(b *Broker, p pattern, cfg subConfig) *Subscription {
+	return &Subscription{
+		broker:  b,
+		pattern: p,
+		policy:  cfg.policy,
+		ch:      make(chan Message, cfg.queueSize),
+	}
+}
+
+// C returns the channel messages are delivered on; it is closed by
+// Unsubscribe or Broker.Close.
+func (s *Subscription) C() <-chan Message {
+	return s.ch
+}
+
+// Pattern returns the subscription pattern.
+func (s *Subscription) Pattern() string {
+	return s.pattern.raw
+}
+
+// Dropped returns how many messages were discarded by the overflow policy.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Unsubscribe stops delivery and closes C. It is safe to call repeatedly.
+func (s *Subscription) Unsubscribe() {
+	s.broker.remove(s)
+	s.close()
+}
+
+// deliver enqueues msg applying the overflow policy; it reports whether msg
+// was queued.
+func (s *Subscription) deliver(msg Message) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	select {
+	case s.ch <- msg:
+		return true
+	default:
+	}
+	if s.policy == DropNewest {
+		s.dropped.Add(1)
+		return false
+	}
+	// DropOldest: make room, then retry. Only producers hold s.mu, so the
+	// freed slot cannot be taken by anyone else.
+	select {
+	case <-s.ch:
+		s.dropped.Add(1)
+	default: // the consumer drained it meanwhile
+	}
+	select {
+	case s.ch <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// close closes the delivery channel once.
+func (s *Subscription) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.ch)
+	}
+}
@@ -0,0 +1,164 @@
+// File: ratelimit/limiter.go
+// Package ratelimit provides token-bucket rate limiting keyed by session,
+// client IP or route.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// A Limiter lazily creates one bucket per key in a sharded map. Buckets that
+// have refilled completely carry no state worth keeping and are evicted
+// opportunistically, so memory stays proportional to recently active keys.
+// Rate and burst can be changed at runtime (hot reload) without losing
+// existing buckets.
+
+package ratelimit
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// evictEvery is the number of Allow calls per shard between eviction scans.
+const evictEvery = 4096
+
+// Limiter is a keyed token-bucket rate limiter safe for concurrent use.
+type Limiter struct {
+	rate   atomic.Uint64 // float64 bits: tokens per second; <=0 = unlimited
+	burst  atomic.Int64  // bucket capacity
+	shards []*shard
+	mask   uint32
+}
+
+type shard struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	ops     int
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// New creates a Limiter admitting rate events per second per key with bursts
+// up to burst. rate <= 0 disables limiting.
+func New(rate float64, burst int) *Limiter {
+	const shardCount = 32
+	l := &Limiter{
+		shards: make([]*shard, shardCount),
+		mask:   shardCount - 1,
+	}
+	for i := range l.shards {
+		l.shards[i] = &shard{buckets: make(map[string]*bucket)}
+	}
+	l.SetLimit(rate, burst)
+	return l
+}
+
+// SetLimit changes rate and burst; existing buckets keep their tokens,
+// clamped to the new burst.
+func (l *Limiter) SetLimit(rate float64, burst int) {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	l.rate.Store(math.Float64bits(rate))
+	l.burst.Store(int64(burst))
+}
+
+// Limit returns the current rate and burst.
+func (l *Limiter) Limit() (float64, int) {
+	return math.Float64frombits(l.rate.Load()), int(l.burst.Load())
+}
+
+// Enabled reports whether the limiter currently restricts anything.
+func (l *Limiter) Enabled() bool {
+	return math.Float64frombits(l.rate.Load()) > 0
+}
+
+// Allow consumes one token for key, reporting whether the event is admitted.
+func (l *Limiter) Allow(key string) bool {
+	return l.AllowN(key, 1)
+}
+
+// AllowN consumes n tokens for key if available.
+func (l *Limiter) AllowN(key string, n int) bool {
+	return l.take(key, n) == 0
+}
+
+// Delay consumes one token for key and returns 0 if one is available;
+// otherwise it consumes nothing and returns how long until one is. Callers
+// that must not drop the event wait that long and try again.
+func (l *Limiter) Delay(key string) time.Duration {
+	return l.take(key, 1)
+}
+
+// take consumes n tokens for key if available, returning 0, or else the time
+// until the bucket holds n tokens.
+func (l *Limiter) take(key string, n int) time.Duration {
+	rate := math.Float64frombits(l.rate.Load())
+	if rate <= 0 {
+		return 0
+	}
+	burst := float64(l.burst.Load())
+	now := time.Now()
+
+	sh := l.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if sh.ops++; sh.ops >= evictEvery {
+		sh.ops = 0
+		sh.evict(now, rate, burst)
+	}
+
+	b, ok := sh.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		sh.buckets[key] = b
+	} else {
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+		b.last = now
+	}
+	if missing := float64(n) - b.tokens; missing > 0 {
+		return time.Duration(math.Ceil(missing / rate * float64(time.Second)))
+	}
+	b.tokens -= float64(n)
+	return 0
+}
+
+// Forget drops the bucket for key, e.g. when its session closes.
+func (l *Limiter) Forget(key string) {
+	sh := l.shard(key)
+	sh.mu.Lock()
+	delete(sh.buckets, key)
+	sh.mu.Unlock()
+}
+
+// Len returns the number of tracked keys.
+func (l *Limiter) Len() int {
+	n := 0
+	for _, sh := range l.shards {
+		sh.mu.Lock()
+		n += len(sh.buckets)
+		sh.mu.Unlock()
+	}
+	return n
+}
+
+// shard picks the shard owning key.
+func (l *Limiter) shard(key string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return l.shards[h.Sum32()&l.mask]
+}
+
+// evict removes buckets that would be full by now; caller holds sh.mu.
+func (sh *shard) evict(now time.Time, rate, burst float64) {
+	for k, b := range sh.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rate >= burst {
+			delete(sh.buckets, k)
+		}
+	}
+}
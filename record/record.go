@@ -0,0 +1,263 @@
+// File: record/record.go
+// Package record captures the raw byte stream of WebSocket connections and
+// replays it offline.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// A recording Transport wraps the transport of a connection and writes every
+// batch it receives and sends, with the time it passed and any error, to a
+// file. Replay feeds the received batches of such a file back into a fresh
+// WSConnection, in the order and split they arrived in, so a protocol bug
+// seen in production reproduces under a debugger:
+//
+//	srv, err := server.NewServer(cfg, server.WithRecorder(func(req *http.Request) (io.WriteCloser, error) {
+//		if req.URL.Query().Get("debug") == "" {
+//			return nil, nil
+//		}
+//		return os.Create(filepath.Join(dir, uuid()+".rec"))
+//	}))
+//	...
+//	res, err := record.Replay(f)
+//
+// A file starts with a magic string and the connection's metadata as a
+// length-prefixed JSON object, followed by one record per batch: a kind
+// byte, the time as big-endian Unix nanoseconds, the number of buffers and
+// each buffer prefixed by its 4-byte big-endian length. Errors are records
+// holding their message as the only buffer.
+
+package record
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// magic starts every recording; its last digit is the format version.
+const magic = "HIOREC1\n"
+
+// Kind tells what a record holds.
+type Kind byte
+
+const (
+	KindRecv      Kind = 1 // a batch returned by Recv
+	KindSend      Kind = 2 // a batch passed to Send
+	KindRecvError Kind = 3 // an error returned by Recv
+	KindSendError Kind = 4 // an error returned by Send
+	KindClose     Kind = 5 // the transport was closed
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindRecv:
+		return "recv"
+	case KindSend:
+		return "send"
+	case KindRecvError:
+		return "recv-error"
+	case KindSendError:
+		return "send-error"
+	case KindClose:
+		return "close"
+	}
+	return "kind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Record is one entry of a recording.
+type Record struct {
+	Kind Kind
+	Time time.Time
+	Data [][]byte // the batch of KindRecv and KindSend
+	Err  string   // the error message of KindRecvError and KindSendError
+}
+
+// Metadata keys written by ConnMeta and applied by Replay.
+const (
+	MetaPath        = "path"
+	MetaSubprotocol = "subprotocol"
+	MetaExtensions  = "extensions"
+	MetaCompression = "compression"
+	MetaStrict      = "strict"
+	MetaRemote      = "remote"
+	MetaStart       = "start" // RFC 3339 time the recording started
+)
+
+// ConnMeta returns the settings of c that decide how its byte stream
+// decodes, for the metadata of its recording.
+func ConnMeta(c *protocol.WSConnection) map[string]string {
+	meta := map[string]string{
+		MetaPath:        c.Path(),
+		MetaSubprotocol: c.Subprotocol(),
+		MetaExtensions:  c.Extensions(),
+		MetaCompression: strconv.FormatBool(c.Compression()),
+		MetaStrict:      strconv.FormatBool(c.Strict()),
+	}
+	if addr := c.RemoteAddr(); addr != nil {
+		meta[MetaRemote] = addr.String()
+	}
+	return meta
+}
+
+// Transport is an api.Transport recording the traffic of the one it wraps.
+// Recording stops at the first failed write to the file, which never fails
+// the connection; see Err.
+type Transport struct {
+	tr api.Transport
+
+	mu     sync.Mutex // serializes records of the receive and send paths
+	w      *bufio.Writer
+	c      io.Closer // the file, if closable
+	err    error     // first write error
+	closed bool
+}
+
+// NewTransport wraps tr, recording its traffic to w after a header holding
+// meta, e.g. ConnMeta of the connection. Each record is flushed as it is
+// written, so a recording survives a crash of the process. If w is an
+// io.Closer it is closed with the transport.
+func NewTransport(tr api.Transport, w io.Writer, meta map[string]string) (*Transport, error) {
+	all := map[string]string{MetaStart: time.Now().Format(time.RFC3339Nano)}
+	for k, v := range meta {
+		all[k] = v
+	}
+	head, err := json.Marshal(all)
+	if err != nil {
+		return nil, err
+	}
+	t := &Transport{tr: tr, w: bufio.NewWriter(w)}
+	t.c, _ = w.(io.Closer)
+	t.w.WriteString(magic)
+	t.w.Write(binary.BigEndian.AppendUint32(nil, uint32(len(head))))
+	t.w.Write(head)
+	if err := t.w.Flush(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Recv receives from the wrapped transport and records the batch or error.
+func (t *Transport) Recv() ([][]byte, error) {
+	bufs, err := t.tr.Recv()
+	if err != nil {
+		t.write(KindRecvError, [][]byte{[]byte(err.Error())})
+		return nil, err
+	}
+	t.write(KindRecv, bufs)
+	return bufs, nil
+}
+
+// Send records the batch and sends it with the wrapped transport.
+func (t *Transport) Send(bufs [][]byte) error {
+	t.write(KindSend, bufs)
+	err := t.tr.Send(bufs)
+	if err != nil {
+		t.write(KindSendError, [][]byte{[]byte(err.Error())})
+	}
+	return err
+}
+
+// Close records the close, closes the wrapped transport and then the file.
+func (t *Transport) Close() error {
+	t.write(KindClose, nil)
+	err := t.tr.Close()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.closed {
+		t.closed = true
+		if t.c != nil {
+			t.c.Close()
+		}
+	}
+	return err
+}
+
+// Features reports the features of the wrapped transport.
+func (t *Transport) Features() api.TransportFeatures {
+	return t.tr.Features()
+}
+
+// Err returns the error that stopped the recording, or nil.
+func (t *Transport) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// Unwrap returns the wrapped transport.
+func (t *Transport) Unwrap() api.Transport {
+	return t.tr
+}
+
+// write appends a record of kind holding bufs.
+func (t *Transport) write(kind Kind, bufs [][]byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil || t.closed {
+		return
+	}
+	var hdr [13]byte
+	hdr[0] = byte(kind)
+	binary.BigEndian.PutUint64(hdr[1:], uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint32(hdr[9:], uint32(len(bufs)))
+	t.w.Write(hdr[:])
+	for _, b := range bufs {
+		t.w.Write(binary.BigEndian.AppendUint32(hdr[:0], uint32(len(b))))
+		t.w.Write(b)
+	}
+	t.err = t.w.Flush()
+}
+
+// The methods below pass on the optional methods connections and servers
+// look for on a transport.
+
+// RemoteAddr returns the peer address of the wrapped transport, or nil.
+func (t *Transport) RemoteAddr() net.Addr {
+	if ra, ok := t.tr.(interface{ RemoteAddr() net.Addr }); ok {
+		return ra.RemoteAddr()
+	}
+	return nil
+}
+
+// TLSConnectionState returns the TLS state of the wrapped transport.
+func (t *Transport) TLSConnectionState() (tls.ConnectionState, bool) {
+	if ts, ok := t.tr.(interface {
+		TLSConnectionState() (tls.ConnectionState, bool)
+	}); ok {
+		return ts.TLSConnectionState()
+	}
+	return tls.ConnectionState{}, false
+}
+
+// SetTimeouts sets the read and write timeouts of the wrapped transport.
+func (t *Transport) SetTimeouts(read, write time.Duration) {
+	if ts, ok := t.tr.(interface {
+		SetTimeouts(read, write time.Duration)
+	}); ok {
+		ts.SetTimeouts(read, write)
+	}
+}
+
+// SetReadDeadline sets the read deadline of the wrapped transport.
+func (t *Transport) SetReadDeadline(d time.Time) error {
+	if ds, ok := t.tr.(interface{ SetReadDeadline(time.Time) error }); ok {
+		return ds.SetReadDeadline(d)
+	}
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline of the wrapped transport.
+func (t *Transport) SetWriteDeadline(d time.Time) error {
+	if ds, ok := t.tr.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		return ds.SetWriteDeadline(d)
+	}
+	return nil
+}
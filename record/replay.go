@@ -0,0 +1,297 @@
+// File: record/replay.go
+// Package record
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Reading recordings back and replaying them through a WSConnection.
+
+package record
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/pool"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// maxBuffer bounds a recorded buffer, guarding against corrupt files.
+const maxBuffer = 64 << 20
+
+// ErrFormat is returned for input that is not a recording.
+var ErrFormat = errors.New("record: not a recording")
+
+// Reader reads the records of a recording in order.
+type Reader struct {
+	r    *bufio.Reader
+	meta map[string]string
+}
+
+// NewReader reads the header of the recording in r.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	var head [len(magic) + 4]byte
+	if _, err := io.ReadFull(br, head[:]); err != nil {
+		return nil, ErrFormat
+	}
+	if string(head[:len(magic)]) != magic {
+		return nil, ErrFormat
+	}
+	n := binary.BigEndian.Uint32(head[len(magic):])
+	if n > maxBuffer {
+		return nil, ErrFormat
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(br, data); err != nil {
+		return nil, ErrFormat
+	}
+	rd := &Reader{r: br}
+	if err := json.Unmarshal(data, &rd.meta); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	return rd, nil
+}
+
+// Meta returns the metadata the recording was started with.
+func (r *Reader) Meta() map[string]string {
+	return r.meta
+}
+
+// Next returns the next record, io.EOF after the last one and
+// io.ErrUnexpectedEOF if the recording ends within a record, as it does
+// when the recording process died while writing it.
+func (r *Reader) Next() (Record, error) {
+	var hdr [13]byte
+	if _, err := io.ReadFull(r.r, hdr[:]); err != nil {
+		return Record{}, err
+	}
+	rec := Record{
+		Kind: Kind(hdr[0]),
+		Time: time.Unix(0, int64(binary.BigEndian.Uint64(hdr[1:]))),
+	}
+	count := binary.BigEndian.Uint32(hdr[9:])
+	if rec.Kind < KindRecv || rec.Kind > KindClose || count > maxBuffer {
+		return Record{}, fmt.Errorf("%w: bad record header", ErrFormat)
+	}
+	for i := uint32(0); i < count; i++ {
+		if _, err := io.ReadFull(r.r, hdr[:4]); err != nil {
+			return Record{}, unexpected(err)
+		}
+		n := binary.BigEndian.Uint32(hdr[:4])
+		if n > maxBuffer {
+			return Record{}, fmt.Errorf("%w: buffer of %d bytes", ErrFormat, n)
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r.r, b); err != nil {
+			return Record{}, unexpected(err)
+		}
+		rec.Data = append(rec.Data, b)
+	}
+	if rec.Kind == KindRecvError || rec.Kind == KindSendError {
+		for _, b := range rec.Data {
+			rec.Err += string(b)
+		}
+		rec.Data = nil
+	}
+	return rec, nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Player is an api.Transport playing back a recording: Recv returns its
+// received batches in order, then the error the recorded connection got,
+// and Send collects what is sent instead of sending it.
+type Player struct {
+	r *Reader
+
+	mu       sync.Mutex
+	sent     [][]byte
+	lastSend time.Time
+	closed   bool
+}
+
+// NewPlayer returns a Player reading r.
+func NewPlayer(r *Reader) *Player {
+	return &Player{r: r}
+}
+
+// Recv returns the next received batch of the recording. At its end it
+// returns the recorded Recv error, io.EOF for one that ended with the
+// connection closed locally or not at all.
+func (p *Player) Recv() ([][]byte, error) {
+	for {
+		p.mu.Lock()
+		closed := p.closed
+		p.mu.Unlock()
+		if closed {
+			return nil, api.ErrTransportClosed
+		}
+		rec, err := p.r.Next()
+		if err != nil {
+			return nil, err
+		}
+		switch rec.Kind {
+		case KindRecv:
+			return rec.Data, nil
+		case KindRecvError:
+			if rec.Err == io.EOF.Error() {
+				return nil, io.EOF
+			}
+			return nil, errors.New(rec.Err)
+		case KindClose:
+			return nil, io.EOF
+		}
+	}
+}
+
+// Send collects a copy of bufs.
+func (p *Player) Send(bufs [][]byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return api.ErrTransportClosed
+	}
+	for _, b := range bufs {
+		p.sent = append(p.sent, append([]byte(nil), b...))
+	}
+	p.lastSend = time.Now()
+	return nil
+}
+
+// Sent returns the buffers sent so far.
+func (p *Player) Sent() [][]byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([][]byte(nil), p.sent...)
+}
+
+// Close stops the playback.
+func (p *Player) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	return nil
+}
+
+// Features reports no special capabilities.
+func (p *Player) Features() api.TransportFeatures {
+	return api.TransportFeatures{}
+}
+
+// Message is a message decoded during a replay.
+type Message struct {
+	Opcode  byte
+	Payload []byte
+}
+
+// Result is the outcome of a replay.
+type Result struct {
+	Meta     map[string]string
+	Messages []Message // messages the connection decoded, in order
+	Sent     [][]byte  // frames the connection sent, e.g. pongs and closes
+	Err      error     // what ended the replay, io.EOF at the end of the recording
+}
+
+// ReplayOption customizes Replay.
+type ReplayOption func(*replayConfig)
+
+type replayConfig struct {
+	configure func(*protocol.WSConnection)
+	pool      api.BufferPool
+	settle    time.Duration
+}
+
+// WithConfigure runs fn on the connection before the replay, after the
+// recorded settings were applied, e.g. to set a read buffer limit the
+// metadata does not carry.
+func WithConfigure(fn func(*protocol.WSConnection)) ReplayOption {
+	return func(c *replayConfig) {
+		c.configure = fn
+	}
+}
+
+// WithBufferPool sets the pool the connection decodes into, by default
+// pool.DefaultPool.
+func WithBufferPool(p api.BufferPool) ReplayOption {
+	return func(c *replayConfig) {
+		c.pool = p
+	}
+}
+
+// WithSettle sets how long Replay waits for the connection to stop sending
+// once the received data is exhausted (default 20ms); frames are sent from
+// a goroutine of their own.
+func WithSettle(d time.Duration) ReplayOption {
+	return func(c *replayConfig) {
+		c.settle = d
+	}
+}
+
+// Replay decodes the received data of the recording in r with a
+// WSConnection configured from its metadata. The connection reads directly
+// from a Player on the calling goroutine, so messages and protocol errors
+// come out the same on every run. The error is non-nil only if r does not
+// hold a recording.
+func Replay(r io.Reader, opts ...ReplayOption) (*Result, error) {
+	cfg := replayConfig{settle: 20 * time.Millisecond}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	if cfg.pool == nil {
+		cfg.pool = pool.DefaultPool(64<<10, 0)
+	}
+	rd, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	meta := rd.Meta()
+	player := NewPlayer(rd)
+	conn := protocol.NewWSConnectionWithPath(player, cfg.pool, 64, meta[MetaPath])
+	conn.SetSubprotocol(meta[MetaSubprotocol])
+	conn.SetExtensions(meta[MetaExtensions])
+	conn.SetCompression(meta[MetaCompression] == "true")
+	conn.SetStrict(meta[MetaStrict] == "true")
+	if cfg.configure != nil {
+		cfg.configure(conn)
+	}
+
+	res := &Result{Meta: meta}
+	for res.Err == nil {
+		msgs, err := conn.RecvMessages()
+		for _, m := range msgs {
+			res.Messages = append(res.Messages, Message{Opcode: m.Opcode, Payload: append([]byte{}, m.Buf.Bytes()...)})
+			m.Buf.Release()
+		}
+		res.Err = err
+	}
+
+	// Let the send goroutine finish pongs and closes answering the input.
+	end := time.Now()
+	for {
+		player.mu.Lock()
+		last := player.lastSend
+		player.mu.Unlock()
+		if last.Before(end) {
+			last = end
+		}
+		if conn.MemUsage().Outbox == 0 && time.Since(last) >= cfg.settle {
+			break
+		}
+		time.Sleep(cfg.settle / 4)
+	}
+	res.Sent = player.Sent()
+	conn.Close()
+	return res, nil
+}
@@ -0,0 +1,261 @@
+// File: router/router.go
+// Package router maps session keys to cluster nodes by consistent hashing.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// A Router places every node on a hash ring at a number of virtual points
+// and assigns a key to the node owning the first point at or after the
+// key's hash. Adding or removing a node only moves the keys of the ring
+// segments it gains or loses, so sticky sessions survive scaling: a proxy
+// keeps sending a user to the same backend, and bridge clients agree on
+// which node owns a user without coordination, given the same membership:
+//
+//	r := router.New()
+//	r.Add("node-a", "node-b", "node-c") // e.g. pubsub Bridge node IDs
+//	owner, _ := r.Get(userID)
+//
+// Lookups read an immutable snapshot of the ring and never block; membership
+// changes build a new one and run the OnChange hooks with both, so
+// applications can migrate exactly the keys that moved.
+
+package router
+
+import (
+	"hash/fnv"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultReplicas is the number of ring points per node when none is set.
+const DefaultReplicas = 160
+
+// Option customizes a Router.
+type Option func(*Router)
+
+// WithReplicas sets the number of ring points per node (default
+// DefaultReplicas). More points spread keys more evenly at the cost of
+// memory and rebuild time.
+func WithReplicas(n int) Option {
+	return func(r *Router) {
+		if n > 0 {
+			r.replicas = n
+		}
+	}
+}
+
+// WithHash replaces the key and point hash, e.g. to match the placement of
+// another implementation. It must be the same on every node.
+func WithHash(fn func([]byte) uint64) Option {
+	return func(r *Router) {
+		r.hash = fn
+	}
+}
+
+// Change describes a membership change, see Router.OnChange.
+type Change struct {
+	Added   []string
+	Removed []string
+
+	before, after *ring
+}
+
+// Owner returns the node key was assigned to before and after the change,
+// "" where the ring was empty.
+func (c Change) Owner(key string) (before, after string) {
+	before, _ = c.before.get(key)
+	after, _ = c.after.get(key)
+	return before, after
+}
+
+// Moved reports whether the change assigned key to another node.
+func (c Change) Moved(key string) bool {
+	before, after := c.Owner(key)
+	return before != after
+}
+
+// Router assigns keys to nodes. It is safe for concurrent use.
+type Router struct {
+	replicas int
+	hash     func([]byte) uint64
+
+	ring atomic.Pointer[ring]
+
+	mu    sync.Mutex // serializes membership changes and their hooks
+	hooks []func(Change)
+}
+
+// New returns a Router without nodes.
+func New(opts ...Option) *Router {
+	r := &Router{replicas: DefaultReplicas, hash: hash64}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.ring.Store(&ring{hash: r.hash})
+	return r
+}
+
+// OnChange registers fn to run after every membership change that added or
+// removed a node, in registration order. Hooks run one change at a time on
+// the goroutine making it and must not change the membership themselves.
+func (r *Router) OnChange(fn func(Change)) {
+	r.mu.Lock()
+	r.hooks = append(r.hooks, fn)
+	r.mu.Unlock()
+}
+
+// Get returns the node owning key, or false if there are no nodes.
+func (r *Router) Get(key string) (string, bool) {
+	return r.ring.Load().get(key)
+}
+
+// Lookup returns up to n distinct nodes for key in ring order, the owner
+// first, so callers can fall back to the next when one is unavailable.
+// Keys whose owner is down move to the same fallback on every node.
+func (r *Router) Lookup(key string, n int) []string {
+	return r.ring.Load().lookup(key, n)
+}
+
+// Nodes returns the current members, sorted.
+func (r *Router) Nodes() []string {
+	return slices.Clone(r.ring.Load().nodes)
+}
+
+// Len returns the number of members.
+func (r *Router) Len() int {
+	return len(r.ring.Load().nodes)
+}
+
+// Add adds nodes that are not members yet.
+func (r *Router) Add(nodes ...string) {
+	r.update(func(cur []string) []string {
+		return append(slices.Clone(cur), nodes...)
+	})
+}
+
+// Remove removes nodes that are members.
+func (r *Router) Remove(nodes ...string) {
+	r.update(func(cur []string) []string {
+		return slices.DeleteFunc(slices.Clone(cur), func(n string) bool {
+			return slices.Contains(nodes, n)
+		})
+	})
+}
+
+// Set replaces the membership with nodes, e.g. from a service registry.
+func (r *Router) Set(nodes ...string) {
+	r.update(func([]string) []string {
+		return slices.Clone(nodes)
+	})
+}
+
+// update installs the ring of the membership edit returns and runs the
+// hooks if it differs from the current one.
+func (r *Router) update(edit func(cur []string) []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	before := r.ring.Load()
+	nodes := edit(before.nodes)
+	sort.Strings(nodes)
+	nodes = slices.Compact(nodes)
+	ch := Change{before: before}
+	for _, n := range nodes {
+		if _, ok := slices.BinarySearch(before.nodes, n); !ok {
+			ch.Added = append(ch.Added, n)
+		}
+	}
+	for _, n := range before.nodes {
+		if _, ok := slices.BinarySearch(nodes, n); !ok {
+			ch.Removed = append(ch.Removed, n)
+		}
+	}
+	if len(ch.Added) == 0 && len(ch.Removed) == 0 {
+		return
+	}
+	ch.after = r.build(nodes)
+	r.ring.Store(ch.after)
+	for _, fn := range r.hooks {
+		fn(ch)
+	}
+}
+
+// ring is an immutable snapshot of the membership.
+type ring struct {
+	hash   func([]byte) uint64
+	nodes  []string // sorted
+	points []point  // sorted by hash
+}
+
+// point is a virtual node: a position on the ring and its node.
+type point struct {
+	hash uint64
+	node int32 // index into nodes
+}
+
+// build places every node at r.replicas points.
+func (r *Router) build(nodes []string) *ring {
+	rg := &ring{hash: r.hash, nodes: nodes, points: make([]point, 0, len(nodes)*r.replicas)}
+	var buf []byte
+	for i, n := range nodes {
+		for v := 0; v < r.replicas; v++ {
+			buf = strconv.AppendInt(append(append(buf[:0], n...), '#'), int64(v), 10)
+			rg.points = append(rg.points, point{hash: r.hash(buf), node: int32(i)})
+		}
+	}
+	// Ties between nodes are broken by name, the same on every node.
+	sort.Slice(rg.points, func(i, j int) bool {
+		a, b := rg.points[i], rg.points[j]
+		return a.hash < b.hash || a.hash == b.hash && a.node < b.node
+	})
+	return rg
+}
+
+// search returns the index of the first point at or after key's hash.
+func (rg *ring) search(key string) int {
+	h := rg.hash([]byte(key))
+	i := sort.Search(len(rg.points), func(i int) bool { return rg.points[i].hash >= h })
+	if i == len(rg.points) {
+		i = 0
+	}
+	return i
+}
+
+func (rg *ring) get(key string) (string, bool) {
+	if len(rg.points) == 0 {
+		return "", false
+	}
+	return rg.nodes[rg.points[rg.search(key)].node], true
+}
+
+func (rg *ring) lookup(key string, n int) []string {
+	n = min(n, len(rg.nodes))
+	if n <= 0 {
+		return nil
+	}
+	out := make([]string, 0, n)
+	seen := make([]bool, len(rg.nodes))
+	for i, start := 0, rg.search(key); len(out) < n; i++ {
+		p := rg.points[(start+i)%len(rg.points)]
+		if !seen[p.node] {
+			seen[p.node] = true
+			out = append(out, rg.nodes[p.node])
+		}
+	}
+	return out
+}
+
+// hash64 is 64-bit FNV-1a followed by the murmur3 finalizer, which spreads
+// the hashes of similar keys such as "node#1" and "node#2" over the ring.
+func hash64(b []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(b)
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
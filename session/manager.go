@@ -0,0 +1,348 @@
+// File: session/manager.go
+// Package session
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// SessionManager is the public facade over the sharded internal registry.
+// It adds TTL and idle eviction driven by a background sweeper, lifecycle
+// hooks, and persistence of session attributes to a pluggable Store. Set
+// only changes the live session: its attributes reach the Store on Save, and
+// on Close when a retention period is set.
+
+package session
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	isession "github.com/momentics/hioload-ws/internal/session"
+)
+
+// defaultSweepInterval bounds how late an expired session may be evicted.
+const defaultSweepInterval = time.Second
+
+// ManagerOption customizes SessionManager construction.
+type ManagerOption func(*SessionManager)
+
+// WithTTL expires sessions d after creation (0 = never).
+func WithTTL(d time.Duration) ManagerOption {
+	return func(m *SessionManager) {
+		m.ttl = d
+	}
+}
+
+// WithIdleTimeout expires sessions not touched for d (0 = never).
+func WithIdleTimeout(d time.Duration) ManagerOption {
+	return func(m *SessionManager) {
+		m.idle = d
+	}
+}
+
+// WithSweepInterval sets how often the sweeper scans for expired sessions.
+func WithSweepInterval(d time.Duration) ManagerOption {
+	return func(m *SessionManager) {
+		if d > 0 {
+			m.sweepEvery = d
+		}
+	}
+}
+
+// OnCreate registers a callback invoked after a session is created.
+func OnCreate(fn func(Session)) ManagerOption {
+	return func(m *SessionManager) {
+		m.onCreate = fn
+	}
+}
+
+// OnExpire registers a callback invoked after the sweeper evicts a session
+// because its TTL elapsed or it went idle.
+func OnExpire(fn func(Session)) ManagerOption {
+	return func(m *SessionManager) {
+		m.onExpire = fn
+	}
+}
+
+// OnClose registers a callback invoked after a live session is closed
+// explicitly. Expired sessions fire OnExpire instead.
+func OnClose(fn func(Session)) ManagerOption {
+	return func(m *SessionManager) {
+		m.onClose = fn
+	}
+}
+
+// WithStore persists session attributes to st instead of the default MemoryStore.
+func WithStore(st Store) ManagerOption {
+	return func(m *SessionManager) {
+		m.store = st
+	}
+}
+
+// WithRetention keeps a closed session's record in the Store for d so a
+// reconnecting client (or another node) can restore it. 0 deletes on close.
+func WithRetention(d time.Duration) ManagerOption {
+	return func(m *SessionManager) {
+		m.retention = d
+	}
+}
+
+// OnStoreError registers a callback for Store failures that cannot be
+// returned to a caller (restore on create, save on close, sweeper touches).
+func OnStoreError(fn func(error)) ManagerOption {
+	return func(m *SessionManager) {
+		m.onStoreErr = fn
+	}
+}
+
+// SessionManager tracks the live sessions of a server.
+type SessionManager struct {
+	inner isession.SessionManager
+
+	ttl        time.Duration
+	idle       time.Duration
+	sweepEvery time.Duration
+
+	onCreate   func(Session)
+	onExpire   func(Session)
+	onClose    func(Session)
+	onStoreErr func(error)
+
+	store     Store
+	retention time.Duration
+	lastSweep time.Time // owned by the sweeper goroutine
+
+	secret       []byte        // resumption token key; empty = disabled
+	tokenTTL     time.Duration // how long resumption tokens stay valid
+	resumeWindow time.Duration // how long detached sessions await resumption
+	replayCap    int           // per-session replay buffer bound
+	bindings     sync.Map      // id -> *binding
+	redeemMu     sync.Mutex    // serializes redeeming tokens of stored sessions
+	tags         *tagIndex
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// NewSessionManager creates a manager with shardCount shards (<=0 = default).
+// A sweeper goroutine runs only when a TTL, idle timeout, retention or
+// resume window is configured.
+func NewSessionManager(shardCount int, opts ...ManagerOption) *SessionManager {
+	m := &SessionManager{
+		inner:      isession.NewSessionManager(shardCount),
+		sweepEvery: defaultSweepInterval,
+		tokenTTL:   DefaultTokenTTL,
+		tags:       newTagIndex(),
+		stopCh:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.store == nil {
+		m.store = NewMemoryStore(shardCount)
+	}
+	if m.ttl > 0 || m.idle > 0 || m.retention > 0 || m.resumeWindow > 0 {
+		m.lastSweep = time.Now()
+		go m.sweepLoop()
+	}
+	return m
+}
+
+// Open creates a session with a fresh random ID. The ID cannot have a stored
+// record, so the Store is not consulted.
+func (m *SessionManager) Open() Session {
+	return m.create(NewID(), false)
+}
+
+// Create returns the session for id, creating it if absent. A new session
+// is restored from the Store when a record for id exists.
+func (m *SessionManager) Create(id string) Session {
+	return m.create(id, true)
+}
+
+// create opens the session for id, applying TTL, restore and OnCreate once.
+func (m *SessionManager) create(id string, restore bool) Session {
+	s, created := m.inner.Open(id)
+	if created {
+		if m.ttl > 0 {
+			s.WithDeadline(time.Now().Add(m.ttl))
+		}
+		if restore {
+			m.restore(s)
+		}
+		if m.onCreate != nil {
+			m.onCreate(s)
+		}
+	}
+	return s
+}
+
+// Get looks up a live session by ID.
+func (m *SessionManager) Get(id string) (Session, bool) {
+	s, ok := m.inner.Get(id)
+	if !ok {
+		return nil, false
+	}
+	return s, true
+}
+
+// Close cancels the session and removes it from the manager. Its record is
+// retained in the Store for the retention period, or deleted.
+func (m *SessionManager) Close(id string) {
+	s, ok := m.inner.Remove(id)
+	if !ok {
+		return
+	}
+	var err error
+	if m.retention > 0 {
+		err = m.store.Save(m.snapshot(s, time.Now()))
+	} else {
+		err = m.store.Delete(id)
+	}
+	m.bindings.Delete(id)
+	m.untagAll(id)
+	m.storeErr(err)
+	if m.onClose != nil {
+		m.onClose(s)
+	}
+}
+
+// Save writes the live session's attributes through to the Store.
+func (m *SessionManager) Save(id string) error {
+	s, ok := m.inner.Get(id)
+	if !ok {
+		return ErrNotFound
+	}
+	return m.store.Save(m.snapshot(s, time.Now()))
+}
+
+// Store returns the backing session Store.
+func (m *SessionManager) Store() Store {
+	return m.store
+}
+
+// Range calls fn for every live session. fn must not open or close sessions.
+func (m *SessionManager) Range(fn func(Session)) {
+	m.inner.Range(func(s isession.Session) {
+		fn(s)
+	})
+}
+
+// Len returns the number of live sessions.
+func (m *SessionManager) Len() int {
+	return m.inner.Len()
+}
+
+// Stop halts the background sweeper; live sessions are left untouched.
+func (m *SessionManager) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stopCh)
+	})
+}
+
+// sweepLoop periodically evicts expired sessions until Stop is called.
+func (m *SessionManager) sweepLoop() {
+	ticker := time.NewTicker(m.sweepEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stopCh:
+			return
+		case now := <-ticker.C:
+			m.sweep(now)
+		}
+	}
+}
+
+// sweep removes every session whose TTL elapsed or which went idle by now,
+// and extends the Store expiry of sessions active since the previous sweep.
+func (m *SessionManager) sweep(now time.Time) {
+	var expired, abandoned, active []string
+	m.inner.Range(func(s isession.Session) {
+		switch {
+		case m.expired(s, now):
+			expired = append(expired, s.ID())
+		case m.detachedExpired(s.ID(), now):
+			abandoned = append(abandoned, s.ID())
+		case m.retention > 0 && s.LastActive().After(m.lastSweep):
+			active = append(active, s.ID())
+		}
+	})
+	m.lastSweep = now
+
+	// Detached sessions whose client never came back close normally.
+	for _, id := range abandoned {
+		m.Close(id)
+	}
+	for _, id := range expired {
+		s, ok := m.inner.Remove(id)
+		if !ok {
+			continue
+		}
+		m.bindings.Delete(id)
+		m.untagAll(id)
+		m.storeErr(m.store.Delete(id))
+		if m.onExpire != nil {
+			m.onExpire(s)
+		}
+	}
+	for _, id := range active {
+		if err := m.store.Touch(id, now.Add(m.retention)); !errors.Is(err, ErrNotFound) {
+			m.storeErr(err)
+		}
+	}
+	if sw, ok := m.store.(sweeper); ok {
+		sw.Sweep(now)
+	}
+}
+
+// restore copies a stored record's attributes into a newly created session.
+func (m *SessionManager) restore(s Session) {
+	rec, err := m.store.Load(s.ID())
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			m.storeErr(err)
+		}
+		return
+	}
+	for k, v := range rec.Attrs {
+		s.Set(k, v)
+	}
+}
+
+// snapshot captures the session's attributes and latest resumption token as
+// a Store record.
+func (m *SessionManager) snapshot(s Session, now time.Time) *Record {
+	ctx := s.Context()
+	keys := ctx.Keys()
+	rec := &Record{ID: s.ID(), Attrs: make(map[string]any, len(keys))}
+	for _, k := range keys {
+		if v, ok := ctx.Get(k); ok {
+			rec.Attrs[k] = v
+		}
+	}
+	if m.retention > 0 {
+		rec.Expires = now.Add(m.retention)
+	}
+	if v, ok := m.bindings.Load(s.ID()); ok {
+		b := v.(*binding)
+		b.mu.Lock()
+		rec.ResumeNonce = b.nonce
+		b.mu.Unlock()
+	}
+	return rec
+}
+
+// storeErr forwards a non-nil Store error to the OnStoreError hook.
+func (m *SessionManager) storeErr(err error) {
+	if err != nil && m.onStoreErr != nil {
+		m.onStoreErr(err)
+	}
+}
+
+// expired reports whether s is past its deadline or idle timeout.
+func (m *SessionManager) expired(s isession.Session, now time.Time) bool {
+	if d, ok := s.Deadline(); ok && now.After(d) {
+		return true
+	}
+	return m.idle > 0 && now.Sub(s.LastActive()) > m.idle
+}
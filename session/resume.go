@@ -0,0 +1,343 @@
+// File: session/resume.go
+// Package session
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Resumption lets a client that lost its connection re-bind to its previous
+// Session. The server issues an opaque HMAC-signed token at handshake time
+// (ResumeHeader response header); the client presents it on reconnect either
+// as the same request header or as a first text frame "hioload-resume:<token>".
+// The MAC covers the session ID, the issue time and a random nonce: a token
+// expires after the token TTL, resumes once, and is superseded by the next
+// token issued for its session, such as the one answering the resume. The
+// nonce of the latest token goes into the Store record with the session, so
+// this holds for sessions restored from the Store too.
+// While a session is detached, messages sent through SessionManager.Send are
+// kept in a bounded replay buffer and flushed in order on re-attach, ahead
+// of messages sent meanwhile.
+
+package session
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// Resumption wire constants.
+const (
+	ResumeHeader      = "X-Hioload-Resume"
+	ResumeFramePrefix = "hioload-resume:"
+)
+
+// DefaultTokenTTL is how long a resumption token stays valid unless
+// WithTokenTTL sets otherwise.
+const DefaultTokenTTL = 24 * time.Hour
+
+// Token layout after the session ID: issue time, nonce and MAC.
+const (
+	tokenStampLen = 8 // UnixNano issue time, big endian
+	tokenNonceLen = 8
+	tokenMACLen   = 16
+	tokenLen      = tokenStampLen + tokenNonceLen + tokenMACLen
+)
+
+// ErrDetached is returned by Send when the session has no live connection
+// and no replay buffer is configured.
+var ErrDetached = errors.New("session: no live connection")
+
+// Conn is the connection side a session is attached to.
+type Conn interface {
+	SendFrame(frame *protocol.WSFrame) error
+	Close() error
+}
+
+// Message is an outbound message retained for replay.
+type Message struct {
+	Opcode  byte
+	Payload []byte
+}
+
+// WithResumption enables resumption tokens signed with secret. A detached
+// session stays alive for window awaiting its client; 0 disables the grace
+// period so only sessions retained in the Store can be resumed.
+func WithResumption(secret []byte, window time.Duration) ManagerOption {
+	return func(m *SessionManager) {
+		m.secret = append([]byte(nil), secret...)
+		m.resumeWindow = window
+	}
+}
+
+// WithTokenTTL rejects resumption tokens issued more than d ago (0 = tokens
+// do not expire). The default is DefaultTokenTTL.
+func WithTokenTTL(d time.Duration) ManagerOption {
+	return func(m *SessionManager) {
+		m.tokenTTL = d
+	}
+}
+
+// WithReplayBuffer keeps up to n undelivered messages per detached session;
+// the oldest message is dropped when the buffer is full.
+func WithReplayBuffer(n int) ManagerOption {
+	return func(m *SessionManager) {
+		m.replayCap = n
+	}
+}
+
+// binding tracks the connection a session is attached to.
+type binding struct {
+	mu         sync.Mutex
+	conn       Conn
+	detachedAt time.Time
+	replay     []Message
+	flushing   bool   // Attach is writing replay to conn, Send queues behind it
+	nonce      uint64 // of the latest token issued, 0 once it resumed
+}
+
+// binding returns (creating if needed) the attachment record for id.
+func (m *SessionManager) binding(id string) *binding {
+	b, _ := m.bindings.LoadOrStore(id, &binding{})
+	return b.(*binding)
+}
+
+// ResumeEnabled reports whether resumption tokens are issued.
+func (m *SessionManager) ResumeEnabled() bool {
+	return len(m.secret) > 0
+}
+
+// Token issues a resumption token for s, or returns "" if resumption is
+// disabled. The tokens issued for s before can no longer resume it.
+func (m *SessionManager) Token(s Session) string {
+	if len(m.secret) == 0 || s == nil {
+		return ""
+	}
+	var tok [tokenLen]byte
+	binary.BigEndian.PutUint64(tok[:tokenStampLen], uint64(time.Now().UnixNano()))
+	nonce := tok[tokenStampLen : tokenStampLen+tokenNonceLen]
+	for binary.BigEndian.Uint64(nonce) == 0 {
+		rand.Read(nonce)
+	}
+	copy(tok[tokenStampLen+tokenNonceLen:], m.sign(s.ID(), tok[:tokenStampLen+tokenNonceLen]))
+
+	b := m.binding(s.ID())
+	b.mu.Lock()
+	b.nonce = binary.BigEndian.Uint64(nonce)
+	b.mu.Unlock()
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(s.ID())) + "." + enc.EncodeToString(tok[:])
+}
+
+// Resume validates token and returns the session it was issued for: the live
+// (possibly detached) session, or one restored from the Store. The token is
+// rejected once expired, used or superseded by a later Token; the caller
+// issues the client a new one. Nodes sharing a Store may both accept a token
+// presented to them at the same moment, as the Store offers no atomic
+// update.
+func (m *SessionManager) Resume(token string) (Session, bool) {
+	if len(m.secret) == 0 {
+		return nil, false
+	}
+	idPart, tokPart, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, false
+	}
+	enc := base64.RawURLEncoding
+	id, err := enc.DecodeString(idPart)
+	if err != nil {
+		return nil, false
+	}
+	tok, err := enc.DecodeString(tokPart)
+	if err != nil || len(tok) != tokenLen {
+		return nil, false
+	}
+	signed, mac := tok[:tokenStampLen+tokenNonceLen], tok[tokenStampLen+tokenNonceLen:]
+	if !hmac.Equal(mac, m.sign(string(id), signed)) {
+		return nil, false
+	}
+	issued := time.Unix(0, int64(binary.BigEndian.Uint64(tok[:tokenStampLen])))
+	if m.tokenTTL > 0 && time.Since(issued) > m.tokenTTL {
+		return nil, false
+	}
+	if !m.redeem(string(id), binary.BigEndian.Uint64(tok[tokenStampLen:])) {
+		return nil, false
+	}
+	if s, ok := m.Get(string(id)); ok {
+		return s, true
+	}
+	if _, err := m.store.Load(string(id)); err != nil {
+		return nil, false
+	}
+	return m.Create(string(id)), true
+}
+
+// Attach binds conn to the session, flushes any replay buffer to it and
+// returns the previously attached connection (nil if none). Messages sent
+// while the replay is written are queued behind it. The caller should close
+// a returned connection that differs from conn.
+func (m *SessionManager) Attach(id string, conn Conn) Conn {
+	b := m.binding(id)
+	b.mu.Lock()
+	prev := b.conn
+	b.conn = conn
+	b.detachedAt = time.Time{}
+	for len(b.replay) > 0 {
+		pending := b.replay
+		b.replay, b.flushing = nil, true
+		b.mu.Unlock()
+		for _, msg := range pending {
+			conn.SendFrame(&protocol.WSFrame{
+				IsFinal:    true,
+				Opcode:     msg.Opcode,
+				PayloadLen: int64(len(msg.Payload)),
+				Payload:    msg.Payload,
+			})
+		}
+		b.mu.Lock()
+	}
+	b.flushing = false
+	b.mu.Unlock()
+
+	if prev == conn {
+		return nil
+	}
+	return prev
+}
+
+// Detach unbinds conn from the session. With resumption enabled the session
+// stays alive for the resume window; otherwise it is closed. Detaching a
+// connection that was superseded by a newer Attach is a no-op.
+func (m *SessionManager) Detach(id string, conn Conn) {
+	v, ok := m.bindings.Load(id)
+	if !ok {
+		m.Close(id)
+		return
+	}
+	b := v.(*binding)
+	b.mu.Lock()
+	if b.conn != conn {
+		b.mu.Unlock()
+		return
+	}
+	b.conn = nil
+	b.detachedAt = time.Now()
+	b.mu.Unlock()
+
+	if len(m.secret) == 0 || m.resumeWindow <= 0 {
+		m.Close(id)
+	}
+}
+
+// Send delivers a message to the session's live connection, or buffers it for
+// replay while the session is detached or its replay is being flushed.
+func (m *SessionManager) Send(id string, opcode byte, payload []byte) error {
+	if _, ok := m.inner.Get(id); !ok {
+		return ErrNotFound
+	}
+	b := m.binding(id)
+	b.mu.Lock()
+	conn := b.conn
+	if conn == nil || b.flushing {
+		defer b.mu.Unlock()
+		return m.buffer(b, opcode, payload)
+	}
+	b.mu.Unlock()
+
+	err := conn.SendFrame(&protocol.WSFrame{
+		IsFinal:    true,
+		Opcode:     opcode,
+		PayloadLen: int64(len(payload)),
+		Payload:    payload,
+	})
+	if err == nil || m.replayCap <= 0 {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return m.buffer(b, opcode, payload)
+}
+
+// buffer appends a copy of the message to b's replay buffer, dropping the
+// oldest when full. b.mu must be held.
+func (m *SessionManager) buffer(b *binding, opcode byte, payload []byte) error {
+	if m.replayCap <= 0 {
+		return ErrDetached
+	}
+	if len(b.replay) >= m.replayCap {
+		copy(b.replay, b.replay[1:])
+		b.replay = b.replay[:len(b.replay)-1]
+	}
+	b.replay = append(b.replay, Message{Opcode: opcode, Payload: append([]byte(nil), payload...)})
+	return nil
+}
+
+// detachedExpired reports whether the session has been detached longer than
+// the resume window at now.
+func (m *SessionManager) detachedExpired(id string, now time.Time) bool {
+	if m.resumeWindow <= 0 {
+		return false
+	}
+	v, ok := m.bindings.Load(id)
+	if !ok {
+		return false
+	}
+	b := v.(*binding)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.conn == nil && !b.detachedAt.IsZero() && now.Sub(b.detachedAt) > m.resumeWindow
+}
+
+// redeem consumes the token with nonce issued for session id, reporting
+// false if a later token superseded it or it resumed already.
+func (m *SessionManager) redeem(id string, nonce uint64) bool {
+	v, ok := m.bindings.Load(id)
+	if !ok {
+		return m.redeemStored(id, nonce) // not live here
+	}
+	b := v.(*binding)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.nonce != nonce {
+		return false
+	}
+	b.nonce = 0
+	return true
+}
+
+// redeemStored consumes the token with nonce of a session to be restored from
+// the Store, rewriting its record with the nonce cleared.
+func (m *SessionManager) redeemStored(id string, nonce uint64) bool {
+	m.redeemMu.Lock()
+	defer m.redeemMu.Unlock()
+	rec, err := m.store.Load(id)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			m.storeErr(err)
+		}
+		return false
+	}
+	if rec.ResumeNonce == 0 || rec.ResumeNonce != nonce {
+		return false
+	}
+	rec.ResumeNonce = 0
+	if err := m.store.Save(rec); err != nil {
+		m.storeErr(err)
+		return false
+	}
+	return true
+}
+
+// sign computes the token MAC over id and the issue time and nonce in stamp.
+func (m *SessionManager) sign(id string, stamp []byte) []byte {
+	h := hmac.New(sha256.New, m.secret)
+	h.Write(stamp)
+	h.Write([]byte(id))
+	return h.Sum(nil)[:tokenMACLen]
+}
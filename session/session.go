@@ -0,0 +1,51 @@
+// File: session/session.go
+// Package session exposes per-connection sessions to hioload-ws applications.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Every accepted WebSocket connection is bound to a Session owned by the
+// server's SessionManager. Handlers reach it through api.OpenEvent,
+// protocol.WSConnection.Session() or highlevel.Conn.Session().
+
+package session
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/momentics/hioload-ws/api"
+)
+
+// Session is the application-visible per-connection state.
+type Session = api.Session
+
+// NewID returns a random 128-bit hex session identifier.
+func NewID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("session: crypto/rand unavailable: " + err.Error())
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// Get fetches a typed attribute; ok is false if the key is missing or holds
+// a value of another type.
+func Get[T any](s Session, key string) (T, bool) {
+	var zero T
+	if s == nil {
+		return zero, false
+	}
+	v, ok := s.Get(key)
+	if !ok {
+		return zero, false
+	}
+	t, ok := v.(T)
+	return t, ok
+}
+
+// Set stores a typed attribute; it mirrors Get for symmetry at call sites.
+func Set[T any](s Session, key string, value T) {
+	if s != nil {
+		s.Set(key, value)
+	}
+}
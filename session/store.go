@@ -0,0 +1,170 @@
+// File: session/store.go
+// Package session
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Store abstracts where session attributes are persisted between
+// connections, restarts and cluster nodes. MemoryStore is the sharded
+// in-process default; external backends (Redis, memcached) implement the
+// same four methods.
+
+package session
+
+import (
+	"errors"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by Store.Load when no record exists for an ID.
+var ErrNotFound = errors.New("session: record not found")
+
+// Record is the persisted form of a session.
+type Record struct {
+	ID      string
+	Attrs   map[string]any
+	Expires time.Time // zero = no expiry
+
+	// ResumeNonce identifies the latest resumption token issued for the
+	// session, 0 once a token resumed it. Stores must persist it, or
+	// sessions cannot be resumed from them.
+	ResumeNonce uint64
+}
+
+// expired reports whether the record is past its expiry at now.
+func (r *Record) expired(now time.Time) bool {
+	return !r.Expires.IsZero() && now.After(r.Expires)
+}
+
+// Store persists session records. Implementations must be safe for
+// concurrent use.
+type Store interface {
+	// Load returns the record for id or ErrNotFound.
+	Load(id string) (*Record, error)
+	// Save writes the record, replacing any previous value.
+	Save(rec *Record) error
+	// Delete removes the record; deleting a missing record is not an error.
+	Delete(id string) error
+	// Touch extends the record expiry without rewriting attributes.
+	Touch(id string, expires time.Time) error
+}
+
+// sweeper is implemented by stores that need periodic purging of expired
+// records; SessionManager calls it from its background sweeper.
+type sweeper interface {
+	Sweep(now time.Time)
+}
+
+// MemoryStore is a sharded in-process Store.
+type MemoryStore struct {
+	shards []*memoryShard
+	mask   uint32
+}
+
+type memoryShard struct {
+	mu      sync.RWMutex
+	records map[string]*Record
+}
+
+// Ensure compile-time interface compliance.
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore creates a MemoryStore with shardCount shards (<=0 = 16).
+func NewMemoryStore(shardCount int) *MemoryStore {
+	if shardCount <= 0 {
+		shardCount = 16
+	}
+	n := 1
+	for n < shardCount {
+		n <<= 1
+	}
+	shards := make([]*memoryShard, n)
+	for i := range shards {
+		shards[i] = &memoryShard{records: make(map[string]*Record)}
+	}
+	return &MemoryStore{shards: shards, mask: uint32(n - 1)}
+}
+
+// shard picks the shard owning id.
+func (s *MemoryStore) shard(id string) *memoryShard {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return s.shards[h.Sum32()&s.mask]
+}
+
+// Load returns a copy of the stored record.
+func (s *MemoryStore) Load(id string) (*Record, error) {
+	sh := s.shard(id)
+	sh.mu.RLock()
+	rec, ok := sh.records[id]
+	sh.mu.RUnlock()
+	if !ok || rec.expired(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return cloneRecord(rec), nil
+}
+
+// Save stores a copy of rec.
+func (s *MemoryStore) Save(rec *Record) error {
+	sh := s.shard(rec.ID)
+	sh.mu.Lock()
+	sh.records[rec.ID] = cloneRecord(rec)
+	sh.mu.Unlock()
+	return nil
+}
+
+// Delete removes the record for id.
+func (s *MemoryStore) Delete(id string) error {
+	sh := s.shard(id)
+	sh.mu.Lock()
+	delete(sh.records, id)
+	sh.mu.Unlock()
+	return nil
+}
+
+// Touch updates the record expiry.
+func (s *MemoryStore) Touch(id string, expires time.Time) error {
+	sh := s.shard(id)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	rec, ok := sh.records[id]
+	if !ok {
+		return ErrNotFound
+	}
+	rec.Expires = expires
+	return nil
+}
+
+// Sweep purges records expired at now.
+func (s *MemoryStore) Sweep(now time.Time) {
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+		for id, rec := range sh.records {
+			if rec.expired(now) {
+				delete(sh.records, id)
+			}
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// Len returns the number of stored records, including not yet swept ones.
+func (s *MemoryStore) Len() int {
+	n := 0
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		n += len(sh.records)
+		sh.mu.RUnlock()
+	}
+	return n
+}
+
+// cloneRecord copies rec so callers never share the attribute map.
+func cloneRecord(rec *Record) *Record {
+	out := &Record{ID: rec.ID, Expires: rec.Expires, ResumeNonce: rec.ResumeNonce, Attrs: make(map[string]any, len(rec.Attrs))}
+	for k, v := range rec.Attrs {
+		out.Attrs[k] = v
+	}
+	return out
+}
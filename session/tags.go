@@ -0,0 +1,203 @@
+// File: session/tags.go
+// Package session
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Tags group sessions ("room:lobby", "tenant:42") for targeted fan-out.
+// Broadcast copies the payload once into a pooled buffer shared by reference
+// count; each recipient connection releases its reference after the frame has
+// been encoded onto the wire. The shared buffer is read-only: send
+// interceptors of a recipient get a private copy of it.
+
+package session
+
+import (
+	"sync"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/pool"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// tagIndex maps tags to member session IDs and back.
+type tagIndex struct {
+	mu      sync.RWMutex
+	members map[string]map[string]struct{} // tag -> ids
+	tagsOf  map[string]map[string]struct{} // id -> tags
+}
+
+func newTagIndex() *tagIndex {
+	return &tagIndex{
+		members: make(map[string]map[string]struct{}),
+		tagsOf:  make(map[string]map[string]struct{}),
+	}
+}
+
+// Tag adds the live session id to each of tags.
+func (m *SessionManager) Tag(id string, tags ...string) error {
+	if _, ok := m.inner.Get(id); !ok {
+		return ErrNotFound
+	}
+	t := m.tags
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	own := t.tagsOf[id]
+	if own == nil {
+		own = make(map[string]struct{}, len(tags))
+		t.tagsOf[id] = own
+	}
+	for _, tag := range tags {
+		set := t.members[tag]
+		if set == nil {
+			set = make(map[string]struct{})
+			t.members[tag] = set
+		}
+		set[id] = struct{}{}
+		own[tag] = struct{}{}
+	}
+	return nil
+}
+
+// Untag removes session id from each of tags.
+func (m *SessionManager) Untag(id string, tags ...string) {
+	t := m.tags
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, tag := range tags {
+		t.remove(id, tag)
+	}
+}
+
+// Tags returns the tags of session id.
+func (m *SessionManager) Tags(id string) []string {
+	t := m.tags
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	out := make([]string, 0, len(t.tagsOf[id]))
+	for tag := range t.tagsOf[id] {
+		out = append(out, tag)
+	}
+	return out
+}
+
+// Tagged returns the live sessions carrying tag.
+func (m *SessionManager) Tagged(tag string) []Session {
+	t := m.tags
+	t.mu.RLock()
+	ids := make([]string, 0, len(t.members[tag]))
+	for id := range t.members[tag] {
+		ids = append(ids, id)
+	}
+	t.mu.RUnlock()
+
+	out := make([]Session, 0, len(ids))
+	for _, id := range ids {
+		if s, ok := m.inner.Get(id); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// Broadcast sends frame's payload to every session tagged tag except those
+// listed in exclude, returning the number of sessions it was delivered or
+// queued for replay to. The payload is copied once; frame may be reused by
+// the caller as soon as Broadcast returns.
+func (m *SessionManager) Broadcast(tag string, frame *protocol.WSFrame, exclude ...string) int {
+	t := m.tags
+	t.mu.RLock()
+	ids := make([]string, 0, len(t.members[tag]))
+	for id := range t.members[tag] {
+		if !contains(exclude, id) {
+			ids = append(ids, id)
+		}
+	}
+	t.mu.RUnlock()
+	if len(ids) == 0 {
+		return 0
+	}
+
+	// Split recipients into live connections and detached sessions.
+	live := make([]Conn, 0, len(ids))
+	var detached []string
+	for _, id := range ids {
+		var conn Conn
+		if v, ok := m.bindings.Load(id); ok {
+			b := v.(*binding)
+			b.mu.Lock()
+			conn = b.conn
+			b.mu.Unlock()
+		}
+		if conn != nil {
+			live = append(live, conn)
+		} else if m.replayCap > 0 {
+			detached = append(detached, id)
+		}
+	}
+
+	sent := 0
+	if len(live) > 0 {
+		payload := frame.Payload
+		src := pool.DefaultPool(len(payload), -1).Get(len(payload), -1)
+		if cap(src.Data) < len(payload) { // beyond the largest size class
+			src.Release()
+			src = api.Buffer{Data: make([]byte, len(payload))}
+		}
+		src.Data = src.Data[:len(payload)]
+		copy(src.Data, payload)
+		shared := pool.Share(src, len(live))
+		for _, conn := range live {
+			err := conn.SendFrame(&protocol.WSFrame{
+				IsFinal:    true,
+				Opcode:     frame.Opcode,
+				PayloadLen: int64(len(payload)),
+				Payload:    shared.Data,
+				Buf:        shared,
+			})
+			if err == nil {
+				sent++
+			}
+		}
+	}
+	for _, id := range detached {
+		if m.Send(id, frame.Opcode, frame.Payload) == nil {
+			sent++
+		}
+	}
+	return sent
+}
+
+// untagAll drops every tag of session id; called when it closes or expires.
+func (m *SessionManager) untagAll(id string) {
+	t := m.tags
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for tag := range t.tagsOf[id] {
+		t.remove(id, tag)
+	}
+}
+
+// remove unlinks id and tag; caller holds t.mu.
+func (t *tagIndex) remove(id, tag string) {
+	if set := t.members[tag]; set != nil {
+		delete(set, id)
+		if len(set) == 0 {
+			delete(t.members, tag)
+		}
+	}
+	if own := t.tagsOf[id]; own != nil {
+		delete(own, tag)
+		if len(own) == 0 {
+			delete(t.tagsOf, id)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
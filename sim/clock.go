@@ -0,0 +1,219 @@
+// File: sim/clock.go
+// Package sim runs timing-dependent logic under test control: a simulated
+// clock, a scheduler on it and in-memory transports whose deadlines use it.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Time only moves when a test calls Advance, so keepalive, read timeouts,
+// overflow waits and draining can be exercised step by step without sleeps:
+// start the code under test, wait with BlockUntil until it armed its timers,
+// then advance past them and check what happened.
+
+package sim
+
+import (
+	"sync"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+)
+
+// Epoch is the time a Clock created with the zero time starts at.
+var Epoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Clock is an api.Clock whose time moves only by Advance. Timers due at the
+// same instant fire in the order they were armed; AfterFunc functions run on
+// the goroutine calling Advance.
+type Clock struct {
+	mu      sync.Mutex
+	changed *sync.Cond // broadcast when timers are armed or disarmed
+	now     time.Time
+	timers  []*timer // armed, in no particular order
+	seq     uint64
+}
+
+// NewClock returns a Clock reading start, or Epoch if start is zero.
+func NewClock(start time.Time) *Clock {
+	if start.IsZero() {
+		start = Epoch
+	}
+	c := &Clock{now: start}
+	c.changed = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns the simulated time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Since returns the simulated time elapsed since t.
+func (c *Clock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// NewTimer returns a timer firing once d passed.
+func (c *Clock) NewTimer(d time.Duration) api.Timer {
+	t := &timer{clock: c, ch: make(chan time.Time, 1)}
+	c.arm(t, d)
+	return t
+}
+
+// NewTicker returns a ticker firing every d. It panics if d is not positive,
+// like time.NewTicker.
+func (c *Clock) NewTicker(d time.Duration) api.Ticker {
+	if d <= 0 {
+		panic("sim: non-positive interval for NewTicker")
+	}
+	t := &timer{clock: c, ch: make(chan time.Time, 1), period: d}
+	c.arm(t, d)
+	return ticker{t}
+}
+
+// AfterFunc returns a timer calling fn once d passed.
+func (c *Clock) AfterFunc(d time.Duration, fn func()) api.Timer {
+	t := &timer{clock: c, fn: fn}
+	c.arm(t, d)
+	return t
+}
+
+// Advance moves the time forward by d, firing the timers due on the way in
+// order, each at its own time.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	c.mu.Unlock()
+	c.AdvanceTo(end)
+}
+
+// AdvanceTo moves the time forward to t; see Advance.
+func (c *Clock) AdvanceTo(t time.Time) {
+	for {
+		c.mu.Lock()
+		next := c.next()
+		if next == nil || next.at.After(t) {
+			if t.After(c.now) {
+				c.now = t
+			}
+			c.mu.Unlock()
+			return
+		}
+		c.now = next.at
+		if next.period > 0 {
+			next.at = next.at.Add(next.period)
+		} else {
+			c.disarm(next)
+		}
+		now := c.now
+		c.mu.Unlock()
+		next.fire(now)
+	}
+}
+
+// Next returns how long until the next timer fires; ok is false if none is
+// armed.
+func (c *Clock) Next() (d time.Duration, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t := c.next(); t != nil {
+		return t.at.Sub(c.now), true
+	}
+	return 0, false
+}
+
+// Pending returns the number of armed timers and tickers.
+func (c *Clock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// BlockUntil waits until at least n timers and tickers are armed, e.g. by
+// goroutines the test started, so that a following Advance fires them.
+func (c *Clock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.timers) < n {
+		c.changed.Wait()
+	}
+}
+
+// arm schedules t to fire after d, replacing its previous schedule. It
+// reports whether t was armed.
+func (c *Clock) arm(t *timer, d time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	was := c.disarm(t)
+	c.seq++
+	t.at, t.seq = c.now.Add(max(d, 0)), c.seq
+	c.timers = append(c.timers, t)
+	c.changed.Broadcast()
+	return was
+}
+
+// disarm removes t, reporting whether it was armed. c.mu is held.
+func (c *Clock) disarm(t *timer) bool {
+	for i, x := range c.timers {
+		if x == t {
+			last := len(c.timers) - 1
+			c.timers[i] = c.timers[last]
+			c.timers[last] = nil
+			c.timers = c.timers[:last]
+			c.changed.Broadcast()
+			return true
+		}
+	}
+	return false
+}
+
+// next returns the timer due first. c.mu is held.
+func (c *Clock) next() *timer {
+	var first *timer
+	for _, t := range c.timers {
+		if first == nil || t.at.Before(first.at) || (t.at.Equal(first.at) && t.seq < first.seq) {
+			first = t
+		}
+	}
+	return first
+}
+
+// timer is a timer, ticker or AfterFunc of a Clock.
+type timer struct {
+	clock  *Clock
+	ch     chan time.Time // nil for AfterFunc
+	fn     func()
+	period time.Duration // tickers
+	at     time.Time
+	seq    uint64 // arming order, for timers due at the same time
+}
+
+// fire delivers now, dropping it if the previous tick was not received.
+func (t *timer) fire(now time.Time) {
+	if t.fn != nil {
+		t.fn()
+		return
+	}
+	select {
+	case t.ch <- now:
+	default:
+	}
+}
+
+func (t *timer) C() <-chan time.Time { return t.ch }
+
+func (t *timer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.disarm(t)
+}
+
+func (t *timer) Reset(d time.Duration) bool {
+	return t.clock.arm(t, d)
+}
+
+// ticker is the api.Ticker view of a periodic timer.
+type ticker struct{ *timer }
+
+func (t ticker) Stop() { t.timer.Stop() }
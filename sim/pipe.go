@@ -0,0 +1,225 @@
+// File: sim/pipe.go
+// Package sim
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// In-memory transports with deadlines on a Clock.
+
+package sim
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+)
+
+// pipes numbers the pipes for their addresses.
+var pipes atomic.Int64
+
+// Transport is one end of an in-memory connection made by Pipe. Sends never
+// block: batches queue until the other end receives them, one batch per
+// Recv. Read and write deadlines and timeouts are measured on the Clock, so
+// a blocked Recv fails with os.ErrDeadlineExceeded only once the clock is
+// advanced past its deadline.
+type Transport struct {
+	clock  *Clock
+	in     *queue // batches the other end sent
+	out    *queue
+	closed chan struct{}
+	peer   chan struct{} // closed with the other end
+	once   sync.Once
+	local  net.Addr
+	remote net.Addr
+
+	mu            sync.Mutex
+	readTimeout   time.Duration
+	writeTimeout  time.Duration
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+// Pipe returns the two ends of an in-memory connection with deadlines on c.
+func Pipe(c *Clock) (a, b *Transport) {
+	n := pipes.Add(1)
+	ab, ba := newQueue(), newQueue()
+	a = &Transport{clock: c, in: ba, out: ab, closed: make(chan struct{})}
+	b = &Transport{clock: c, in: ab, out: ba, closed: make(chan struct{})}
+	a.peer, b.peer = b.closed, a.closed
+	a.local, b.local = Addr(fmt.Sprintf("pipe-%d-a", n)), Addr(fmt.Sprintf("pipe-%d-b", n))
+	a.remote, b.remote = b.local, a.local
+	return a, b
+}
+
+// Send queues a copy of buffers for the other end as one batch.
+func (t *Transport) Send(buffers [][]byte) error {
+	select {
+	case <-t.closed:
+		return api.ErrTransportClosed
+	case <-t.peer:
+		return io.ErrClosedPipe
+	default:
+	}
+	if d := t.deadline(false); !d.IsZero() && !t.clock.Now().Before(d) {
+		return os.ErrDeadlineExceeded
+	}
+	batch := make([][]byte, len(buffers))
+	for i, b := range buffers {
+		batch[i] = append([]byte(nil), b...)
+	}
+	t.out.push(batch)
+	return nil
+}
+
+// Recv returns the next batch the other end sent, waiting for it until the
+// read deadline. It returns io.EOF once the other end closed and every batch
+// was received.
+func (t *Transport) Recv() ([][]byte, error) {
+	deadline := t.deadline(true)
+	var expired <-chan time.Time
+	for {
+		if b, ok := t.in.pop(); ok {
+			return b, nil
+		}
+		select {
+		case <-t.closed:
+			return nil, api.ErrTransportClosed
+		case <-t.peer:
+			if b, ok := t.in.pop(); ok {
+				return b, nil
+			}
+			return nil, io.EOF
+		default:
+		}
+		if !deadline.IsZero() && expired == nil {
+			d := deadline.Sub(t.clock.Now())
+			if d <= 0 {
+				return nil, os.ErrDeadlineExceeded
+			}
+			timer := t.clock.NewTimer(d)
+			defer timer.Stop()
+			expired = timer.C()
+		}
+		select {
+		case <-t.in.ready:
+		case <-t.closed:
+		case <-t.peer:
+		case <-expired:
+			if b, ok := t.in.pop(); ok {
+				return b, nil
+			}
+			return nil, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+// Close closes this end; the other end receives what was sent before, then
+// io.EOF.
+func (t *Transport) Close() error {
+	t.once.Do(func() { close(t.closed) })
+	return nil
+}
+
+// Closed reports whether this end was closed.
+func (t *Transport) Closed() bool {
+	select {
+	case <-t.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (t *Transport) Features() api.TransportFeatures {
+	return api.TransportFeatures{Batch: true, OS: []string{"sim"}}
+}
+
+// LocalAddr returns the address of this end.
+func (t *Transport) LocalAddr() net.Addr { return t.local }
+
+// RemoteAddr returns the address of the other end.
+func (t *Transport) RemoteAddr() net.Addr { return t.remote }
+
+// SetTimeouts bounds every following Recv and Send by a deadline that far
+// after it starts (0 = none), taking precedence over SetReadDeadline and
+// SetWriteDeadline.
+func (t *Transport) SetTimeouts(read, write time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.readTimeout, t.writeTimeout = read, write
+}
+
+// SetReadDeadline sets the deadline of Recv calls starting afterwards (zero
+// = none).
+func (t *Transport) SetReadDeadline(d time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.readDeadline = d
+	return nil
+}
+
+// SetWriteDeadline sets the deadline of Send calls (zero = none).
+func (t *Transport) SetWriteDeadline(d time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.writeDeadline = d
+	return nil
+}
+
+// deadline returns the deadline of a Recv (read) or Send starting now.
+func (t *Transport) deadline(read bool) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	timeout, deadline := t.writeTimeout, t.writeDeadline
+	if read {
+		timeout, deadline = t.readTimeout, t.readDeadline
+	}
+	if timeout > 0 {
+		return t.clock.Now().Add(timeout)
+	}
+	return deadline
+}
+
+// Addr is the address of a pipe end.
+type Addr string
+
+func (Addr) Network() string  { return "sim" }
+func (a Addr) String() string { return string(a) }
+
+// queue holds the batches sent one way.
+type queue struct {
+	mu      sync.Mutex
+	batches [][][]byte
+	ready   chan struct{} // signalled when a batch is pushed
+}
+
+func newQueue() *queue {
+	return &queue{ready: make(chan struct{}, 1)}
+}
+
+func (q *queue) push(b [][]byte) {
+	q.mu.Lock()
+	q.batches = append(q.batches, b)
+	q.mu.Unlock()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (q *queue) pop() ([][]byte, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.batches) == 0 {
+		return nil, false
+	}
+	b := q.batches[0]
+	q.batches[0] = nil
+	q.batches = q.batches[1:]
+	return b, true
+}
@@ -0,0 +1,81 @@
+// File: sim/scheduler.go
+// Package sim
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// api.Scheduler on a Clock.
+
+package sim
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+)
+
+// scheduler implements api.Scheduler with the timers of a Clock: jobs run on
+// the goroutine advancing it.
+type scheduler struct {
+	clock *Clock
+}
+
+// NewScheduler returns an api.Scheduler running its jobs on c.
+func NewScheduler(c *Clock) api.Scheduler {
+	return &scheduler{clock: c}
+}
+
+// Schedule runs fn once delayNanos of simulated time passed.
+func (s *scheduler) Schedule(delayNanos int64, fn func()) (api.Cancelable, error) {
+	j := &job{done: make(chan struct{})}
+	j.timer = s.clock.AfterFunc(time.Duration(delayNanos), func() {
+		fn()
+		j.finish(nil)
+	})
+	return j, nil
+}
+
+// Cancel cancels c if it is still pending.
+func (s *scheduler) Cancel(c api.Cancelable) error {
+	return c.Cancel()
+}
+
+// Now returns the simulated time in Unix nanoseconds.
+func (s *scheduler) Now() int64 {
+	return s.clock.Now().UnixNano()
+}
+
+// job is a scheduled function.
+type job struct {
+	timer api.Timer
+	once  sync.Once
+	done  chan struct{}
+	err   error
+}
+
+func (j *job) finish(err error) {
+	j.once.Do(func() {
+		j.err = err
+		close(j.done)
+	})
+}
+
+func (j *job) Cancel() error {
+	if j.timer.Stop() {
+		j.finish(context.Canceled)
+	}
+	return nil
+}
+
+func (j *job) Done() <-chan struct{} { return j.done }
+
+// Err returns context.Canceled once the job was cancelled.
+func (j *job) Err() error {
+	select {
+	case <-j.done:
+		return j.err
+	default:
+		return nil
+	}
+}
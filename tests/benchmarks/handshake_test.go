@@ -0,0 +1,85 @@
+// File: tests/benchmarks/handshake_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Benchmarks of upgrade request parsing: net/http against the in-place
+// UpgradeRequest, alone, answered as the listener does, and with the
+// *http.Request built for hooks.
+
+package benchmarks
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+const benchUpgradeHead = "GET /ws?room=1 HTTP/1.1\r\n" +
+	"Host: example.com\r\n" +
+	"User-Agent: bench\r\n" +
+	"Upgrade: websocket\r\n" +
+	"Connection: Upgrade\r\n" +
+	"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+	"Sec-WebSocket-Version: 13\r\n" +
+	"\r\n"
+
+func BenchmarkHandshakeParse(b *testing.B) {
+	rd := strings.NewReader(benchUpgradeHead)
+	br := bufio.NewReader(rd)
+	b.Run("net/http", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			rd.Reset(benchUpgradeHead)
+			br.Reset(rd)
+			if _, err := http.ReadRequest(br); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("UpgradeRequest", func(b *testing.B) {
+		b.ReportAllocs()
+		var accept [28]byte
+		for i := 0; i < b.N; i++ {
+			rd.Reset(benchUpgradeHead)
+			br.Reset(rd)
+			ur := protocol.AcquireUpgradeRequest()
+			if err := ur.Read(br); err != nil {
+				b.Fatal(err)
+			}
+			key, err := ur.Validate()
+			if err != nil {
+				b.Fatal(err)
+			}
+			protocol.AppendAcceptKey(accept[:0], key)
+			ur.Release()
+		}
+	})
+	b.Run("ReadUpgrade", func(b *testing.B) {
+		b.ReportAllocs()
+		hdr := make(http.Header)
+		for i := 0; i < b.N; i++ {
+			rd.Reset(benchUpgradeHead)
+			ur, err := protocol.ReadUpgrade(rd)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := protocol.WriteUpgradeResponse(io.Discard, ur, hdr); err != nil {
+				b.Fatal(err)
+			}
+			ur.Release()
+		}
+	})
+	b.Run("DoHandshakeRequestBuffered", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			rd.Reset(benchUpgradeHead)
+			if _, _, _, err := protocol.DoHandshakeRequestBuffered(rd); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,42 @@
+// File: tests/benchmarks/mask_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Benchmarks of payload masking against the byte-at-a-time loop it
+// replaced. Compare with -tags purego for the portable word path.
+
+package benchmarks
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+var maskKey = [4]byte{0xA1, 0xB2, 0xC3, 0xD4}
+
+// maskBytewise is the reference RFC 6455 loop.
+func maskBytewise(b []byte, key [4]byte) {
+	for i := range b {
+		b[i] ^= key[i%4]
+	}
+}
+
+func BenchmarkMask(b *testing.B) {
+	for _, size := range []int{125, 1024, 16 << 10, 64 << 10, 1 << 20} {
+		buf := make([]byte, size)
+		b.Run(fmt.Sprintf("bytewise/%d", size), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				maskBytewise(buf, maskKey)
+			}
+		})
+		b.Run(fmt.Sprintf("MaskBytes/%d", size), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				protocol.MaskBytes(buf, maskKey)
+			}
+		})
+	}
+}
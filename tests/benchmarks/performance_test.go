@@ -8,6 +8,7 @@
 package benchmarks
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"sync"
@@ -192,7 +193,7 @@ func runBenchmark(t *testing.T, clientCount int, packetSize int, duration time.D
 	}
 
 	// Stop server
-	if err := server.Shutdown(); err != nil {
+	if err := server.Shutdown(context.Background()); err != nil {
 		t.Logf("Server shutdown error: %v", err)
 	}
 
@@ -2,6 +2,7 @@
 package benchmarks
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"sync/atomic"
@@ -325,5 +326,5 @@ func TestHighLevelThroughput(t *testing.T) {
 	}
 	fmt.Printf("========================================================\n\n")
 
-	srv.Shutdown()
+	srv.Shutdown(context.Background())
 }
@@ -0,0 +1,123 @@
+// Package fake
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Fault injection for FakeTransport. Every random choice comes from a
+// generator seeded by Faults.Seed, so a failing run repeats exactly.
+
+package fake
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Op names a FakeTransport operation faults apply to.
+type Op int
+
+const (
+	OpSend Op = iota
+	OpRecv
+	OpClose
+)
+
+func (o Op) String() string {
+	switch o {
+	case OpSend:
+		return "send"
+	case OpRecv:
+		return "recv"
+	case OpClose:
+		return "close"
+	}
+	return "op"
+}
+
+// InjectedError fails the Nth call of Op, counting from 1, with Err.
+type InjectedError struct {
+	Op  Op
+	N   int
+	Err error
+}
+
+// Faults configures how a FakeTransport misbehaves. The zero value injects
+// nothing.
+type Faults struct {
+	Latency time.Duration // added to every operation
+	Jitter  time.Duration // random extra delay, up to this much
+	Seed    uint64        // seeds jitter and reordering
+
+	// MaxWrite accepts at most this many bytes per Send, recording the
+	// prefix and failing with io.ErrShortWrite; 0 = no limit.
+	MaxWrite int
+	// MaxRead returns at most this many bytes per Recv, in one buffer,
+	// keeping the rest of a batch for the next calls; 0 = no limit.
+	MaxRead int
+	// Reorder shuffles the buffers of every sent and received batch.
+	Reorder bool
+
+	Errors []InjectedError
+}
+
+// faultState is the progress of a FakeTransport through its Faults.
+type faultState struct {
+	rng     *rand.Rand
+	calls   [OpClose + 1]int
+	pending []byte // rest of a batch cut by MaxRead
+}
+
+// begin counts a call of op and returns its delay and injected error.
+// Callers hold the transport's lock.
+func (ft *FakeTransport) begin(op Op) (time.Duration, error) {
+	ft.state.calls[op]++
+	n := ft.state.calls[op]
+	delay := ft.Faults.Latency
+	if ft.Faults.Jitter > 0 {
+		delay += time.Duration(ft.rand().Int64N(int64(ft.Faults.Jitter)))
+	}
+	for _, e := range ft.Faults.Errors {
+		if e.Op == op && e.N == n {
+			return delay, e.Err
+		}
+	}
+	return delay, nil
+}
+
+// rand returns the generator seeded by Faults.Seed.
+func (ft *FakeTransport) rand() *rand.Rand {
+	if ft.state.rng == nil {
+		ft.state.rng = rand.New(rand.NewPCG(ft.Faults.Seed, ft.Faults.Seed))
+	}
+	return ft.state.rng
+}
+
+// reorder returns bufs shuffled if Faults.Reorder is set.
+func (ft *FakeTransport) reorder(bufs [][]byte) [][]byte {
+	if !ft.Faults.Reorder || len(bufs) < 2 {
+		return bufs
+	}
+	out := append([][]byte(nil), bufs...)
+	ft.rand().Shuffle(len(out), func(i, j int) { out[i], out[j] = out[j], out[i] })
+	return out
+}
+
+// nextChunk takes up to Faults.MaxRead bytes off the pending batch.
+func (ft *FakeTransport) nextChunk() [][]byte {
+	n := min(ft.Faults.MaxRead, len(ft.state.pending))
+	chunk := ft.state.pending[:n:n]
+	ft.state.pending = ft.state.pending[n:]
+	return [][]byte{chunk}
+}
+
+// Calls returns how many times op was called.
+func (ft *FakeTransport) Calls(op Op) int {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	return ft.state.calls[op]
+}
+
+func sleep(d time.Duration) {
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
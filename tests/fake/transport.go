@@ -1,14 +1,21 @@
 package fake
 
 import (
+	"io"
+	"sync"
+
 	"github.com/momentics/hioload-ws/api"
 )
 
-// FakeTransport implements api.Transport for testing.
+// FakeTransport implements api.Transport for testing. Set Faults to add
+// latency, short reads and writes, reordering and errors.
 type FakeTransport struct {
-	SendCalls [][][]byte  // Track what was sent
+	SendCalls [][][]byte // Track what was sent
 	RecvFunc  func() ([][]byte, error)
-	RecvData  [][]byte    // Data to return on Recv
+	RecvData  [][]byte // Data to return on Recv
+	Faults    Faults   // Failures to inject, see Faults
+	mu        sync.Mutex
+	state     faultState
 	closed    bool
 	features  api.TransportFeatures
 }
@@ -28,28 +35,119 @@ func NewFakeTransport() *FakeTransport {
 	}
 }
 
+// NewFaultyTransport creates a fake transport injecting f.
+func NewFaultyTransport(f Faults) *FakeTransport {
+	ft := NewFakeTransport()
+	ft.Faults = f
+	return ft
+}
+
 func (ft *FakeTransport) Send(buffers [][]byte) error {
-	ft.SendCalls = append(ft.SendCalls, buffers)
+	ft.mu.Lock()
+	delay, err := ft.begin(OpSend)
+	ft.mu.Unlock()
+	sleep(delay)
+	if err != nil {
+		return err
+	}
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	// Copied, as callers reuse their buffers once Send returns.
+	sent := make([][]byte, 0, len(buffers))
+	room := ft.Faults.MaxWrite
+	for _, b := range ft.reorder(buffers) {
+		if ft.Faults.MaxWrite > 0 && len(b) > room {
+			if room > 0 {
+				sent = append(sent, append([]byte(nil), b[:room]...))
+			}
+			ft.SendCalls = append(ft.SendCalls, sent)
+			return io.ErrShortWrite
+		}
+		room -= len(b)
+		sent = append(sent, append([]byte(nil), b...))
+	}
+	ft.SendCalls = append(ft.SendCalls, sent)
 	return nil
 }
 
 func (ft *FakeTransport) Recv() ([][]byte, error) {
-	if ft.RecvFunc != nil {
-		return ft.RecvFunc()
+	ft.mu.Lock()
+	delay, err := ft.begin(OpRecv)
+	ft.mu.Unlock()
+	sleep(delay)
+	if err != nil {
+		return nil, err
+	}
+	ft.mu.Lock()
+	if ft.Faults.MaxRead > 0 && len(ft.state.pending) > 0 {
+		defer ft.mu.Unlock()
+		return ft.nextChunk(), nil
+	}
+	recv := ft.RecvFunc
+	ft.mu.Unlock()
+
+	var data [][]byte
+	if recv != nil {
+		if data, err = recv(); err != nil {
+			return nil, err
+		}
 	}
-	if len(ft.RecvData) > 0 {
-		data := ft.RecvData
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if recv == nil {
+		if len(ft.RecvData) == 0 {
+			return [][]byte{}, nil
+		}
+		data = ft.RecvData
 		ft.RecvData = [][]byte{} // Clear after use
-		return data, nil
 	}
-	return [][]byte{}, nil
+	data = ft.reorder(data)
+	if ft.Faults.MaxRead > 0 {
+		for _, b := range data {
+			ft.state.pending = append(ft.state.pending, b...)
+		}
+		if len(ft.state.pending) == 0 {
+			return [][]byte{}, nil
+		}
+		return ft.nextChunk(), nil
+	}
+	return data, nil
 }
 
 func (ft *FakeTransport) Close() error {
+	ft.mu.Lock()
+	delay, err := ft.begin(OpClose)
+	ft.mu.Unlock()
+	sleep(delay)
+	if err != nil {
+		return err
+	}
+	ft.mu.Lock()
 	ft.closed = true
+	ft.mu.Unlock()
 	return nil
 }
 
+// Closed reports whether Close succeeded.
+func (ft *FakeTransport) Closed() bool {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	return ft.closed
+}
+
+// Sent returns the bytes of all sent batches, in order.
+func (ft *FakeTransport) Sent() []byte {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	var out []byte
+	for _, call := range ft.SendCalls {
+		for _, b := range call {
+			out = append(out, b...)
+		}
+	}
+	return out
+}
+
 func (ft *FakeTransport) Features() api.TransportFeatures {
 	return ft.features
 }
@@ -92,4 +190,4 @@ func (fh *FakeHandler) GetLastCall() any {
 // GetCallCount returns the number of calls.
 func (fh *FakeHandler) GetCallCount() int {
 	return len(fh.HandleCalls)
-}
\ No newline at end of file
+}
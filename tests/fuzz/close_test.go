@@ -0,0 +1,61 @@
+// File: tests/fuzz/close_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Fuzz target for the close frame payload parser.
+
+package fuzz
+
+import (
+	"errors"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// FuzzParseClosePayload tests that ParseClosePayload accepts exactly the
+// payloads RFC 6455 allows and reads back what NewCloseFrame writes.
+func FuzzParseClosePayload(f *testing.F) {
+	for _, seed := range [][]byte{
+		nil,
+		{0x03},
+		{0x03, 0xE8},
+		append([]byte{0x03, 0xE8}, "bye"...),
+		{0x03, 0xED},             // 1005 must not be sent
+		{0x0F, 0xA0, 'o', 'k'},   // 4000, application range
+		{0x03, 0xE9, 0xFF, 0xFE}, // invalid UTF-8 reason
+		{0x13, 0x88},             // 5000, out of range
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, p []byte) {
+		code, reason, err := protocol.ParseClosePayload(p)
+		if len(p) < 2 {
+			if code != protocol.CloseNoStatusRcvd || reason != "" || (err == nil) != (len(p) == 0) {
+				t.Fatalf("%x: %d %q %v", p, code, reason, err)
+			}
+			return
+		}
+		if code != uint16(p[0])<<8|uint16(p[1]) || reason != string(p[2:]) {
+			t.Fatalf("%x: %d %q", p, code, reason)
+		}
+		if err != nil {
+			if !errors.Is(err, protocol.ErrProtocolViolation) {
+				t.Fatalf("%x: unexpected error %v", p, err)
+			}
+			return
+		}
+		if code < 1000 || code >= 5000 || !utf8.ValidString(reason) {
+			t.Fatalf("%x: accepted code %d reason %q", p, code, reason)
+		}
+		frame := protocol.NewCloseFrame(code, reason)
+		if len(p) > protocol.MaxControlPayloadLen {
+			return // NewCloseFrame truncates
+		}
+		c2, r2, err := protocol.ParseClosePayload(frame.Payload)
+		if err != nil || c2 != code || r2 != reason {
+			t.Fatalf("%x: round trip gave %d %q %v", p, c2, r2, err)
+		}
+	})
+}
@@ -0,0 +1,77 @@
+// File: tests/fuzz/frame_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Fuzz target for the frame decoder. The seeds run with go test; explore
+// with go test -fuzz=FuzzDecodeFrame ./tests/fuzz.
+
+package fuzz
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// frameSeeds are frames of the unit tests: data and control frames, masked
+// and not, with each length encoding, fragments, compressed and truncated.
+func frameSeeds() [][]byte {
+	encode := func(f *protocol.WSFrame, mask bool) []byte {
+		f.PayloadLen = int64(len(f.Payload))
+		b, _ := protocol.EncodeFrameToBytesWithMask(f, mask)
+		return b
+	}
+	text := encode(&protocol.WSFrame{IsFinal: true, Opcode: protocol.OpcodeText, Payload: []byte("hello")}, false)
+	return [][]byte{
+		text,
+		text[:3],
+		encode(&protocol.WSFrame{IsFinal: true, Opcode: protocol.OpcodeBinary, Payload: []byte{1, 2, 3}}, true),
+		encode(&protocol.WSFrame{IsFinal: true, Opcode: protocol.OpcodeBinary, Payload: bytes.Repeat([]byte{7}, 300)}, true),
+		encode(&protocol.WSFrame{IsFinal: true, Opcode: protocol.OpcodeText, Payload: []byte(strings.Repeat("x", 70000))}, false),
+		encode(&protocol.WSFrame{Opcode: protocol.OpcodeText, Payload: []byte("frag")}, true),
+		encode(&protocol.WSFrame{IsFinal: true, Opcode: protocol.OpcodeContinuation, Payload: []byte("ment")}, true),
+		encode(&protocol.WSFrame{IsFinal: true, Opcode: protocol.OpcodePing, Payload: []byte("p")}, true),
+		encode(protocol.NewCloseFrame(protocol.CloseNormalClosure, "bye"), true),
+		{0xC1, 0x05, 0xF2, 0x48, 0xCD, 0xC9, 0xC9, 0x07, 0x00},       // RSV1, "Hello" deflated
+		{0x81, 0xFF, 0x7F, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, // 64-bit length overflow
+		{0x83, 0x81, 0, 0, 0, 0, 'x'}, // reserved opcode
+	}
+}
+
+// FuzzDecodeFrame tests that DecodeFrameFromBytes never panics, stays within
+// its input and decodes what it encodes back the same.
+func FuzzDecodeFrame(f *testing.F) {
+	for _, seed := range frameSeeds() {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		in := append([]byte(nil), raw...) // decoding unmasks in place
+		frame, n, err := protocol.DecodeFrameFromBytes(in)
+		if err != nil || frame == nil {
+			if n != 0 {
+				t.Fatalf("consumed %d bytes without a frame", n)
+			}
+			return
+		}
+		if n < 2 || n > len(raw) {
+			t.Fatalf("consumed %d of %d bytes", n, len(raw))
+		}
+		if frame.PayloadLen != int64(len(frame.Payload)) || frame.PayloadLen > protocol.MaxFramePayload {
+			t.Fatalf("payload length %d, have %d bytes", frame.PayloadLen, len(frame.Payload))
+		}
+
+		out, err := protocol.EncodeFrameToBytesWithMask(frame, false)
+		if err != nil {
+			t.Fatalf("re-encode failed: %v", err)
+		}
+		again, m, err := protocol.DecodeFrameFromBytes(out)
+		if err != nil || again == nil || m != len(out) {
+			t.Fatalf("re-decode failed: %v", err)
+		}
+		if again.IsFinal != frame.IsFinal || again.Rsv != frame.Rsv || again.Opcode != frame.Opcode || !bytes.Equal(again.Payload, frame.Payload) {
+			t.Fatalf("round trip changed the frame: %+v, %+v", frame, again)
+		}
+	})
+}
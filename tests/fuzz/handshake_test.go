@@ -0,0 +1,74 @@
+// File: tests/fuzz/handshake_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Fuzz target for the in-place upgrade request parser.
+
+package fuzz
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// upgradeSeeds are the request heads of the parser's unit tests.
+var upgradeSeeds = []string{
+	"GET /chat?room=1 HTTP/1.1\r\n" +
+		"Host: example.com\r\n" +
+		"upgrade: WebSocket\r\n" +
+		"Connection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Protocol: chat, superchat\r\n" +
+		"\r\nearly",
+	"GET /ws HTTP/1.1\nHost: x\nUpgrade: websocket\nConnection: Upgrade\nSec-WebSocket-Key: a2V5\nSec-WebSocket-Version: 8\n\n",
+	"GET /\r\n\r\n",
+	"GET / HTTP/1.0\r\n\r\n",
+	"GET / HTTP/1.1\r\nBad Name: x\r\n\r\n",
+	"GET / HTTP/1.1\r\nHost\r\n\r\n",
+	"GET / HTTP/1.1\r\nHost: x\r\n",
+	"GET / HTTP/1.1\r\nX-Pad: " + strings.Repeat("a", 5000) + "\r\n\r\n",
+	"GET http://[::1 HTTP/1.1\r\nHost: x\r\n\r\n",
+}
+
+// FuzzUpgradeRequest tests that UpgradeRequest never panics and that the
+// views and request it returns agree with each other.
+func FuzzUpgradeRequest(f *testing.F) {
+	for _, seed := range upgradeSeeds {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		ur := protocol.AcquireUpgradeRequest()
+		defer ur.Release()
+		if err := ur.Read(bufio.NewReader(bytes.NewReader(raw))); err != nil {
+			return
+		}
+		if len(ur.Method) == 0 || len(ur.Target) == 0 || string(ur.Proto) != "HTTP/1.1" {
+			t.Fatalf("request line %q %q %q", ur.Method, ur.Target, ur.Proto)
+		}
+		if !bytes.HasPrefix(ur.Target, ur.Path()) {
+			t.Fatalf("path %q not a prefix of %q", ur.Path(), ur.Target)
+		}
+		if key, err := ur.Validate(); err == nil {
+			if len(key) == 0 || len(protocol.AppendAcceptKey(nil, key)) != 28 {
+				t.Fatalf("key %q", key)
+			}
+		}
+		req, err := ur.HTTPRequest()
+		if err != nil {
+			return
+		}
+		if req.Method != string(ur.Method) || req.RequestURI != string(ur.Target) {
+			t.Fatalf("request %s %s, head %q %q", req.Method, req.RequestURI, ur.Method, ur.Target)
+		}
+		for _, name := range []string{protocol.HeaderSecWebSocketKey, protocol.HeaderUpgrade} {
+			if v := ur.Header(name); v != nil && req.Header.Get(name) != string(v) {
+				t.Fatalf("%s: %q, head %q", name, req.Header.Get(name), v)
+			}
+		}
+	})
+}
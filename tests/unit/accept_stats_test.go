@@ -0,0 +1,95 @@
+// File: tests/unit/accept_stats_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the accept-loop counters and handshake failure reasons.
+
+package unit
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/control"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// TestAcceptStats tests that accepts are counted and that failed handshakes
+// are told apart by reason.
+func TestAcceptStats(t *testing.T) {
+	port, tlsPort := freePort(t), freePort(t)
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf("127.0.0.1:%d", port)
+	srv, err := server.NewServer(cfg,
+		server.WithEndpoints(server.Endpoint{Addr: fmt.Sprintf("127.0.0.1:%d", tlsPort), TLS: selfSignedTLS(t)}),
+		server.WithHandshakeCheck(func(req *http.Request) error {
+			if req.URL.Path == "/private" {
+				return &protocol.HandshakeRejection{Status: http.StatusUnauthorized, Reason: "no token"}
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	defer srv.Shutdown()
+	runServer(t, srv, api.HandlerFunc(func(any) error { return nil }))
+
+	send := func(port int, data string) {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		conn.Write([]byte(data))
+		conn.Read(make([]byte, 512)) // the response, or the close
+	}
+	upgrade := "GET %s HTTP/1.1\r\nHost: x\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"
+	send(port, fmt.Sprintf(upgrade, "/"))
+	send(port, fmt.Sprintf(upgrade, "/private"))
+	send(port, "hello\r\n\r\n")
+	send(tlsPort, fmt.Sprintf(upgrade, "/")) // plain text on the TLS port
+
+	stats := srv.GetControl().Stats
+	counter := func(key string) uint64 {
+		c, _ := stats()["debug."+key].(*control.Counter)
+		if c == nil {
+			return 0
+		}
+		return c.Value()
+	}
+	if !waitFor(t, 2*time.Second, func() bool { return counter(server.ProbeAccepts) == 4 }) {
+		t.Fatalf("Expected 4 accepts, got %d", counter(server.ProbeAccepts))
+	}
+	for reason, want := range map[string]uint64{
+		server.FailTLS:          1,
+		server.FailBadRequest:   1,
+		server.FailUnauthorized: 1,
+		server.FailRateLimited:  0,
+	} {
+		if got := counter(server.ProbeHandshakeFailures + "." + reason); got != want {
+			t.Errorf("Expected %d %s failures, got %d", want, reason, got)
+		}
+	}
+	if n := counter(server.ProbeAcceptErrors); n != 0 {
+		t.Errorf("Expected no listener errors, got %d", n)
+	}
+	if h, _ := stats()["debug."+server.ProbeHandshakeLatency+".count"].(uint64); h != 1 {
+		t.Errorf("Expected 1 handshake timed, got %d", h)
+	}
+
+	// The four accepts show up in the rate once their second is over.
+	if !waitFor(t, 2*time.Second, func() bool {
+		r, _ := stats()["debug."+server.ProbeAcceptRate].(uint64)
+		return r > 0 && r <= 4
+	}) {
+		t.Errorf("Expected an accept rate, got %v", stats()["debug."+server.ProbeAcceptRate])
+	}
+}
@@ -0,0 +1,131 @@
+// File: tests/unit/acceptworkers_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for handshakes performed by accept workers and for listeners
+// accepting with AcceptEx.
+
+package unit
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/lowlevel/server"
+)
+
+// TestAcceptWorkers tests that a stalled handshake does not hold up other
+// clients, that connections beyond the busy workers are queued, and that
+// queued connections are closed with the listener.
+func TestAcceptWorkers(t *testing.T) {
+	port := freePort(t)
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf("127.0.0.1:%d", port)
+	cfg.ShutdownTimeout = 10 * time.Millisecond
+	cfg.AcceptWorkers = 2
+	cfg.AcceptQueue = 4
+	srv, err := server.NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	runServer(t, srv, prefixEcho(""))
+
+	// Clients that connect and never send their upgrade request.
+	stall := func() net.Conn {
+		t.Helper()
+		c, err := net.Dial("tcp", cfg.ListenAddr)
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	stats := srv.GetControl().Stats
+
+	first := stall()
+	conn, err := net.Dial("tcp", cfg.ListenAddr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	if got := echoOver(t, conn, "/", "x"); got != "x" {
+		t.Errorf("Expected echo past a stalled handshake, got %q", got)
+	}
+
+	stall()
+	stall()
+	if !waitFor(t, time.Second, func() bool { return stats()["debug."+server.ProbeAcceptPending] == int64(1) }) {
+		t.Fatalf("Expected one connection queued behind the busy workers, got %v", stats()["debug."+server.ProbeAcceptPending])
+	}
+	first.Close()
+	if !waitFor(t, time.Second, func() bool { return stats()["debug."+server.ProbeAcceptPending] == int64(0) }) {
+		t.Errorf("Expected the queued connection taken by the freed worker, got %v", stats()["debug."+server.ProbeAcceptPending])
+	}
+
+	late := stall()
+	if !waitFor(t, time.Second, func() bool { return stats()["debug."+server.ProbeAcceptPending] == int64(1) }) {
+		t.Fatalf("Expected one connection queued, got %v", stats()["debug."+server.ProbeAcceptPending])
+	}
+	srv.Shutdown()
+	late.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := late.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected the queued connection closed on shutdown")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Error("Expected the queued connection closed on shutdown, still open")
+	}
+}
+
+// TestAcceptEx tests that a listener with posted accepts serves clients
+// sending their upgrade request at once or in pieces.
+func TestAcceptEx(t *testing.T) {
+	port := freePort(t)
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf("127.0.0.1:%d", port)
+	cfg.ShutdownTimeout = 10 * time.Millisecond
+	cfg.AcceptEx = 2
+	srv, err := server.NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+	runServer(t, srv, prefixEcho(""))
+
+	for i := 0; i < 4; i++ {
+		conn, err := net.Dial("tcp", cfg.ListenAddr)
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		msg := fmt.Sprint(i)
+		if got := echoOver(t, conn, "/", msg); got != msg {
+			t.Errorf("Expected echo %q, got %q", msg, got)
+		}
+	}
+
+	// The first byte alone completes the accept; the rest follows.
+	conn, err := net.Dial("tcp", cfg.ListenAddr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	conn.Write([]byte("G"))
+	if got := echoOver(t, &skipFirstWrite{Conn: conn, skip: "G"}, "/", "y"); got != "y" {
+		t.Errorf("Expected echo after a split request, got %q", got)
+	}
+}
+
+// skipFirstWrite drops skip from the start of the first write, which was
+// already sent.
+type skipFirstWrite struct {
+	net.Conn
+	skip string
+}
+
+func (c *skipFirstWrite) Write(p []byte) (int, error) {
+	if c.skip != "" {
+		n := len(c.skip)
+		c.skip = ""
+		m, err := c.Conn.Write(p[n:])
+		return m + n, err
+	}
+	return c.Conn.Write(p)
+}
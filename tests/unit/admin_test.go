@@ -0,0 +1,115 @@
+// File: tests/unit/admin_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the admin HTTP endpoint.
+
+package unit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/control"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+)
+
+// adminDo sends one request to h with the given token.
+func adminDo(h http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// TestAdminServer tests authentication, introspection endpoints, reload
+// and drain against a running server facade.
+func TestAdminServer(t *testing.T) {
+	port := freePort(t)
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf(":%d", port)
+	cfg.ShutdownTimeout = 10 * time.Millisecond
+	srv, err := server.NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		srv.Run(api.HandlerFunc(func(any) error { return nil }))
+		close(stopped)
+	}()
+	t.Cleanup(srv.Shutdown)
+
+	conn, _, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	defer conn.Close()
+	waitConns(t, srv, 1)
+
+	h := srv.AdminServer("127.0.0.1:0", control.WithAdminToken("s3cret")).Handler()
+
+	if rec := adminDo(h, "GET", "/stats", "", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 without token, got %d", rec.Code)
+	}
+	if rec := adminDo(h, "GET", "/stats", "wrong", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 with wrong token, got %d", rec.Code)
+	}
+
+	var stats map[string]any
+	rec := adminDo(h, "GET", "/stats", "s3cret", "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil || stats["debug.connections.current"] != float64(1) {
+		t.Fatalf("Unexpected /stats %d: %s", rec.Code, rec.Body)
+	}
+	var conns []control.ConnInfo
+	rec = adminDo(h, "GET", "/connections", "s3cret", "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &conns); err != nil || len(conns) != 1 || conns[0].ID == "" {
+		t.Fatalf("Unexpected /connections %d: %s", rec.Code, rec.Body)
+	}
+	if rec := adminDo(h, "GET", "/debug/probes", "s3cret", ""); !strings.Contains(rec.Body.String(), "platform.cpus") {
+		t.Fatalf("Unexpected /debug/probes: %s", rec.Body)
+	}
+	if rec := adminDo(h, "GET", "/metrics", "s3cret", ""); !strings.Contains(rec.Body.String(), "hioload_debug_connections_current 1") {
+		t.Fatalf("Unexpected /metrics: %s", rec.Body)
+	}
+	if rec := adminDo(h, "GET", "/debug/pprof/", "s3cret", ""); rec.Code != http.StatusOK {
+		t.Fatalf("Expected pprof index, got %d", rec.Code)
+	}
+
+	if rec := adminDo(h, "GET", "/reload", "s3cret", ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected 405 for GET /reload, got %d", rec.Code)
+	}
+	reloaded := make(chan struct{}, 1)
+	srv.GetControl().OnReload(func() {
+		select {
+		case reloaded <- struct{}{}:
+		default:
+		}
+	})
+	if rec := adminDo(h, "POST", "/reload", "s3cret", `{"feature.x": true}`); rec.Code != http.StatusOK {
+		t.Fatalf("POST /reload: %d %s", rec.Code, rec.Body)
+	}
+	if srv.GetControl().GetConfig()["feature.x"] != true {
+		t.Fatal("Config update not applied")
+	}
+	select {
+	case <-reloaded:
+	case <-time.After(time.Second):
+		t.Fatal("Reload hooks not invoked")
+	}
+
+	if rec := adminDo(h, "POST", "/drain", "s3cret", ""); rec.Code != http.StatusOK {
+		t.Fatalf("POST /drain: %d %s", rec.Code, rec.Body)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Server still running after drain")
+	}
+}
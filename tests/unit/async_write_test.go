@@ -0,0 +1,152 @@
+// File: tests/unit/async_write_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for WriteMessageAsync and its overflow policies.
+
+package unit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/highlevel"
+)
+
+// TestWriteMessageAsync tests that async writes are sent in order and
+// reported to their callbacks.
+func TestWriteMessageAsync(t *testing.T) {
+	const count = 20
+	results := make(chan error, count)
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf(":%d", port))
+	srv.HandleFunc("/async", func(c *highlevel.Conn) {
+		if _, _, err := c.ReadMessage(); err != nil {
+			return
+		}
+		for i := 0; i < count; i++ {
+			c.WriteMessageAsync(int(highlevel.TextMessage), []byte(fmt.Sprintf("m%d", i)), func(err error) {
+				results <- err
+			})
+		}
+		c.ReadMessage()
+	})
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	conn, err := highlevel.Dial(fmt.Sprintf("ws://localhost:%d/async", port))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	conn.WriteString("go")
+	for i := 0; i < count; i++ {
+		if _, msg, err := conn.ReadMessage(); err != nil || string(msg) != fmt.Sprintf("m%d", i) {
+			t.Fatalf("Expected m%d, got %q (err=%v)", i, msg, err)
+		}
+	}
+	for i := 0; i < count; i++ {
+		select {
+		case err := <-results:
+			if err != nil {
+				t.Errorf("Expected successful write, got %v", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Completion callback not called")
+		}
+	}
+}
+
+// TestWriteMessageAsync_Overflow tests the overflow policies against a peer
+// that stops reading.
+func TestWriteMessageAsync_Overflow(t *testing.T) {
+	const count = 100
+	payload := make([]byte, 256<<10)
+	for _, policy := range []highlevel.WriteOverflowPolicy{highlevel.WriteDropNewest, highlevel.WriteDropOldest, highlevel.WriteClose} {
+		t.Run(string(policy), func(t *testing.T) {
+			var mu sync.Mutex
+			var wg sync.WaitGroup
+			errs := make([]error, count)
+			issued := make(chan struct{})
+
+			port := freePort(t)
+			srv := highlevel.NewServer(fmt.Sprintf(":%d", port),
+				highlevel.WithAsyncQueue(2, policy),
+				highlevel.WithChannelCapacity(1),
+			)
+			srv.HandleFunc("/resume", func(c *highlevel.Conn) {
+				if _, _, err := c.ReadMessage(); err != nil {
+					return
+				}
+				wg.Add(count)
+				for i := 0; i < count; i++ {
+					c.WriteMessageAsync(int(highlevel.BinaryMessage), payload, func(err error) {
+						mu.Lock()
+						errs[i] = err
+						mu.Unlock()
+						wg.Done()
+					})
+				}
+				close(issued)
+			})
+			defer srv.Shutdown(context.Background())
+			serve(t, srv)
+
+			conn, _, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+			defer conn.Close()
+			conn.Write([]byte{0x81, 0x82, 0, 0, 0, 0, 'g', 'o'})
+			select {
+			case <-issued:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Writes not issued")
+			}
+
+			mu.Lock()
+			rejected := 0
+			for _, err := range errs {
+				if errors.Is(err, highlevel.ErrWriteQueueFull) {
+					rejected++
+				}
+			}
+			last := errs[count-1]
+			mu.Unlock()
+			if rejected == 0 {
+				t.Errorf("Expected writes rejected with ErrWriteQueueFull")
+			}
+			switch policy {
+			case highlevel.WriteDropNewest:
+				if !errors.Is(last, highlevel.ErrWriteQueueFull) {
+					t.Errorf("Expected the newest write rejected, got %v", last)
+				}
+			case highlevel.WriteDropOldest:
+				if errors.Is(last, highlevel.ErrWriteQueueFull) {
+					t.Errorf("Expected the newest write queued, got %v", last)
+				}
+			case highlevel.WriteClose:
+				// The server sheds the connection: the client drains what
+				// was sent and then sees it closed.
+				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				var ne net.Error
+				if _, err := io.Copy(io.Discard, conn); errors.As(err, &ne) && ne.Timeout() {
+					t.Errorf("Expected the server to close the connection, got %v", err)
+				}
+			}
+
+			conn.Close()
+			done := make(chan struct{})
+			go func() { wg.Wait(); close(done) }()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Queued writes not completed after close")
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+// File: tests/unit/audit_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the connection event audit ring.
+
+package unit
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/control"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// TestAuditRing_Wrap tests that the ring keeps the newest events in order.
+func TestAuditRing_Wrap(t *testing.T) {
+	r := control.NewAuditRing(3)
+	for i := 0; i < 5; i++ {
+		r.Record(control.AuditEvent{Kind: control.AuditConnect, ID: fmt.Sprint(i)})
+	}
+	events := r.Events()
+	if len(events) != 3 || events[0].ID != "2" || events[2].ID != "4" {
+		t.Fatalf("Expected events 2..4, got %+v", events)
+	}
+	if r.Total() != 5 || events[0].Time.IsZero() {
+		t.Errorf("Expected total 5 and stamped times, got %d", r.Total())
+	}
+}
+
+// TestServerAudit tests connect, disconnect (with the peer's close code) and
+// handshake error events exposed through the debug probe.
+func TestServerAudit(t *testing.T) {
+	port := freePort(t)
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf(":%d", port)
+	cfg.ShutdownTimeout = 10 * time.Millisecond
+	srv, err := server.NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+	runServer(t, srv, api.HandlerFunc(func(data any) error {
+		if evt, ok := data.(interface{ GetBuffer() api.Buffer }); ok {
+			evt.GetBuffer().Release()
+		}
+		return nil
+	}))
+
+	conn, br, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	waitConns(t, srv, 1)
+	data, _ := protocol.EncodeFrameToBytesWithMask(protocol.NewCloseFrame(protocol.CloseGoingAway, "bye"), true)
+	conn.Write(data)
+	readClose(t, conn, br)
+	conn.Close()
+	waitConns(t, srv, 0)
+
+	bad, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	fmt.Fprint(bad, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	bad.SetReadDeadline(time.Now().Add(time.Second))
+	bad.Read(make([]byte, 512))
+	bad.Close()
+
+	var events []control.AuditEvent
+	waitFor(t, 500*time.Millisecond, func() bool {
+		events, _ = srv.GetControl().Stats()["debug."+server.ProbeAuditEvents].([]control.AuditEvent)
+		return len(events) >= 3
+	})
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %+v", events)
+	}
+	connect, disconnect, hsErr := events[0], events[1], events[2]
+	if connect.Kind != control.AuditConnect || connect.ID == "" || connect.RemoteAddr == "" {
+		t.Errorf("Unexpected connect event %+v", connect)
+	}
+	if disconnect.Kind != control.AuditDisconnect || disconnect.ID != connect.ID ||
+		disconnect.Code != protocol.CloseGoingAway || disconnect.Reason != "bye" || disconnect.Duration <= 0 {
+		t.Errorf("Unexpected disconnect event %+v", disconnect)
+	}
+	if hsErr.Kind != control.AuditError || hsErr.Error == "" || hsErr.RemoteAddr == "" {
+		t.Errorf("Unexpected error event %+v", hsErr)
+	}
+}
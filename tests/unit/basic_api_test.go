@@ -3,6 +3,7 @@ package unit
 import (
 	"testing"
 
+	"github.com/momentics/hioload-ws/api"
 	"github.com/momentics/hioload-ws/highlevel"
 	"github.com/momentics/hioload-ws/lowlevel/client"
 	"github.com/momentics/hioload-ws/lowlevel/server"
@@ -71,6 +72,26 @@ func TestPoolAPI(t *testing.T) {
 }
 
 // TestProtocolAPI tests protocol API availability
+// countingReleaser counts buffers returned to it.
+type countingReleaser struct{ n int }
+
+func (r *countingReleaser) Put(api.Buffer) { r.n++ }
+
+// TestPoolShare tests reference-counted buffer release.
+func TestPoolShare(t *testing.T) {
+	rel := &countingReleaser{}
+	shared := pool.Share(api.Buffer{Data: []byte("payload"), Pool: rel}, 3)
+	shared.Release()
+	shared.Release()
+	if rel.n != 0 {
+		t.Fatalf("Expected buffer held until last reference, released %d times", rel.n)
+	}
+	shared.Release()
+	if rel.n != 1 {
+		t.Fatalf("Expected single release after last reference, got %d", rel.n)
+	}
+}
+
 func TestProtocolAPI(t *testing.T) {
 	// Test constants
 	if protocol.MaxFramePayload <= 0 {
@@ -0,0 +1,137 @@
+//go:build chaos
+
+// File: tests/unit/chaos_enabled_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the faults injected in builds with the chaos tag:
+//
+//	go test -tags chaos -run Chaos ./tests/unit
+
+package unit
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/chaos"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+	"github.com/momentics/hioload-ws/pool"
+	"github.com/momentics/hioload-ws/protocol"
+	"github.com/momentics/hioload-ws/sim"
+)
+
+// TestChaosInjector tests frame drops in both directions, write delays,
+// cut connections and failed allocations.
+func TestChaosInjector(t *testing.T) {
+	inj := chaos.New(chaos.Config{DropEvery: 3}, 1)
+	a, b := sim.Pipe(sim.NewClock(time.Time{}))
+	conn := protocol.NewWSConnection(a, inj.Pool(pool.DefaultManager().GetPool(4096, -1)), 16)
+	inj.Wrap(conn)
+	defer conn.Close()
+
+	for i := 1; i <= 5; i++ {
+		p := []byte{byte('0' + i)}
+		if err := conn.WriteFrames([]*protocol.WSFrame{{IsFinal: true, Opcode: protocol.OpcodeText, Payload: p, PayloadLen: 1}}); err != nil {
+			t.Fatalf("WriteFrames %d: %v", i, err)
+		}
+	}
+	var sent string
+	for i := 0; i < 4; i++ {
+		sent += string(simFrame(t, b).Payload)
+	}
+	if sent != "1245" {
+		t.Errorf("sent %q, expected the third frame dropped", sent)
+	}
+
+	for i := 1; i <= 4; i++ {
+		b.Send([][]byte{maskedFrame([]byte{byte('0' + i)})})
+	}
+	var received string
+	for len(received) < 3 {
+		msgs, err := conn.RecvMessages()
+		if err != nil {
+			t.Fatalf("RecvMessages: %v", err)
+		}
+		for _, m := range msgs {
+			received += string(m.Buf.Bytes())
+			m.Buf.Release()
+		}
+	}
+	if received != "124" {
+		t.Errorf("received %q, expected the third frame dropped", received)
+	}
+
+	// A frame split across reads is gathered into a pooled buffer.
+	inj.Set(chaos.Config{AllocFailRate: 1})
+	frame := maskedFrame([]byte("whole"))
+	b.Send([][]byte{frame[:8]})
+	b.Send([][]byte{frame[8:]})
+	var msgs []protocol.Message
+	for len(msgs) == 0 {
+		var err error
+		if msgs, err = conn.RecvMessages(); err != nil {
+			t.Fatalf("RecvMessages: %v", err)
+		}
+	}
+	if string(msgs[0].Buf.Bytes()) != "whole" {
+		t.Fatalf("with allocations failing got %q", msgs[0].Buf.Bytes())
+	}
+
+	inj.Set(chaos.Config{SendDelay: 30 * time.Millisecond})
+	start := time.Now()
+	conn.WriteFrames([]*protocol.WSFrame{{IsFinal: true, Opcode: protocol.OpcodeText, Payload: []byte("slow"), PayloadLen: 4}})
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Errorf("write took %v, expected a 30ms delay", d)
+	}
+
+	inj.Set(chaos.Config{CloseRate: 1})
+	err := conn.WriteFrames([]*protocol.WSFrame{{IsFinal: true, Opcode: protocol.OpcodeText, Payload: []byte("x"), PayloadLen: 1}})
+	if !errors.Is(err, chaos.ErrInjected) || !a.Closed() {
+		t.Errorf("expected the connection cut, got %v (closed %v)", err, a.Closed())
+	}
+	if got := inj.Stats(); got.Dropped != 2 || got.Delayed != 1 || got.Closed != 1 || got.AllocFailed == 0 {
+		t.Errorf("stats %+v", got)
+	}
+}
+
+// TestChaosServerControl tests that servers built with the chaos tag apply
+// the chaos keys set through control to their connections.
+func TestChaosServerControl(t *testing.T) {
+	clk := sim.NewClock(time.Time{})
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf("127.0.0.1:%d", freePort(t))
+	cfg.ShutdownTimeout = 10 * time.Millisecond
+	cfg.Clock = clk
+	srv := startSimServer(t, cfg)
+	srv.GetControl().SetConfig(map[string]any{
+		server.CfgChaosDropEvery:     2,
+		server.CfgChaosAllocFailRate: "0.5",
+		server.CfgChaosCloseRate:     7, // out of range, ignored
+	})
+
+	a, b := sim.Pipe(clk)
+	if err := srv.ServeTransport(a, httptest.NewRequest("GET", "/chaos", nil)); err != nil {
+		t.Fatalf("ServeTransport: %v", err)
+	}
+	// Inbound 2 and 4 are dropped; of the echoes of 1 and 3 the second is.
+	for i := 1; i <= 4; i++ {
+		b.Send([][]byte{maskedFrame([]byte{byte('0' + i)})})
+	}
+	if f := simFrame(t, b); string(f.Payload) != "1" {
+		t.Fatalf("echo %q", f.Payload)
+	}
+	stats := srv.GetControl().Stats
+	if !waitFor(t, time.Second, func() bool {
+		s, _ := stats()["debug."+server.ProbeChaos].(chaos.Stats)
+		return s.Dropped == 3
+	}) {
+		t.Fatalf("chaos stats %+v", stats()["debug."+server.ProbeChaos])
+	}
+	if s, _ := stats()["debug."+server.ProbeChaos].(chaos.Stats); s.Closed != 0 {
+		t.Errorf("connections cut with an invalid close rate: %+v", s)
+	}
+}
@@ -0,0 +1,60 @@
+// File: tests/unit/chaos_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the fault injector in builds without the chaos tag; see
+// chaos_enabled_test.go for the faults themselves.
+
+package unit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/chaos"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+	"github.com/momentics/hioload-ws/pool"
+	"github.com/momentics/hioload-ws/protocol"
+	"github.com/momentics/hioload-ws/sim"
+)
+
+// TestChaosDisabled tests that without the chaos tag an injector leaves
+// connections and pools alone and servers ignore the chaos keys.
+func TestChaosDisabled(t *testing.T) {
+	if chaos.Enabled {
+		t.Skip("built with the chaos tag")
+	}
+	inj := chaos.New(chaos.Config{DropEvery: 1, CloseRate: 1, AllocFailRate: 1}, 1)
+	bp := pool.DefaultManager().GetPool(4096, -1)
+	if inj.Pool(bp) != bp {
+		t.Error("Pool should return the pool unwrapped")
+	}
+	a, b := sim.Pipe(sim.NewClock(time.Time{}))
+	conn := protocol.NewWSConnection(a, bp, 16)
+	inj.Wrap(conn)
+	if conn.Transport() != api.Transport(a) {
+		t.Error("Wrap should leave the transport alone")
+	}
+	if err := conn.WriteFrames([]*protocol.WSFrame{{IsFinal: true, Opcode: protocol.OpcodeText, Payload: []byte("hi"), PayloadLen: 2}}); err != nil {
+		t.Fatalf("WriteFrames: %v", err)
+	}
+	if f := simFrame(t, b); string(f.Payload) != "hi" {
+		t.Errorf("payload %q", f.Payload)
+	}
+	if inj.Stats() != (chaos.Stats{}) {
+		t.Errorf("stats %+v", inj.Stats())
+	}
+
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf("127.0.0.1:%d", freePort(t))
+	srv, err := server.NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	defer srv.Shutdown()
+	if _, ok := srv.GetControl().Stats()["debug."+server.ProbeChaos]; ok {
+		t.Error("chaos probe registered without the chaos tag")
+	}
+}
@@ -0,0 +1,119 @@
+// File: tests/unit/client_compression_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for client-side permessage-deflate and the compression threshold.
+
+package unit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/highlevel"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// TestClientCompression tests that a client dialed WithCompression
+// negotiates permessage-deflate, compresses only messages above the
+// threshold and reports the achieved ratio.
+func TestClientCompression(t *testing.T) {
+	port := freePort(t)
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf(":%d", port)
+	cfg.ShutdownTimeout = 10 * time.Millisecond
+	cfg.Compression = true
+	srv, err := server.NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+	runServer(t, srv, api.HandlerFunc(func(data any) error {
+		evt, ok := data.(interface {
+			WSConnection() *protocol.WSConnection
+			GetBuffer() api.Buffer
+		})
+		if !ok {
+			return nil
+		}
+		payload := append([]byte(nil), evt.GetBuffer().Bytes()...)
+		evt.GetBuffer().Release()
+		return evt.WSConnection().SendFrame(&protocol.WSFrame{
+			IsFinal: true, Opcode: protocol.OpcodeText, Payload: payload, PayloadLen: int64(len(payload)),
+		})
+	}))
+
+	conn, err := highlevel.Dial(fmt.Sprintf("ws://127.0.0.1:%d/", port), highlevel.WithCompression(64))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	ws := conn.GetUnderlyingWSConnection()
+	if !ws.Compression() {
+		t.Fatal("Expected permessage-deflate to be negotiated")
+	}
+
+	small := []byte("below threshold")
+	if err := conn.WriteMessage(int(highlevel.TextMessage), small); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	if _, data, err := conn.ReadMessage(); err != nil || !bytes.Equal(data, small) {
+		t.Fatalf("Expected small echo, got %q, %v", data, err)
+	}
+	if n := ws.GetStats()["deflate_in"]; n != 0 {
+		t.Errorf("Expected a message below the threshold to go uncompressed, deflate_in = %v", n)
+	}
+
+	large := bytes.Repeat([]byte("compressible "), 200)
+	if err := conn.WriteMessage(int(highlevel.TextMessage), large); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	if _, data, err := conn.ReadMessage(); err != nil || !bytes.Equal(data, large) {
+		t.Fatalf("Expected large echo intact, got %d bytes, %v", len(data), err)
+	}
+	stats := ws.GetStats()
+	if stats["deflate_in"] != int64(len(large)) {
+		t.Errorf("deflate_in = %v, want %d", stats["deflate_in"], len(large))
+	}
+	if stats["inflate_out"] != int64(len(large)) {
+		t.Errorf("inflate_out = %v, want %d", stats["inflate_out"], len(large))
+	}
+	if r := ws.CompressionRatio(); r <= 0 || r >= 0.5 {
+		t.Errorf("CompressionRatio = %v, want well below 1", r)
+	}
+}
+
+// TestAcceptDeflate tests the client's checks on the server's extension
+// response.
+func TestAcceptDeflate(t *testing.T) {
+	for _, tc := range []struct {
+		ext      string
+		accepted bool
+		fails    bool
+	}{
+		{"", false, false},
+		{"permessage-deflate; server_no_context_takeover; client_no_context_takeover", true, false},
+		{"permessage-deflate; server_no_context_takeover; server_max_window_bits=10", true, false},
+		{"permessage-deflate", false, true},
+		{"permessage-deflate; server_no_context_takeover; client_max_window_bits=9", false, true},
+		{"x-webkit-deflate-frame", false, true},
+	} {
+		hdr := http.Header{}
+		if tc.ext != "" {
+			hdr.Set(protocol.HeaderSecWebSocketExt, tc.ext)
+		}
+		accepted, err := protocol.AcceptDeflate(hdr)
+		if accepted != tc.accepted || (err != nil) != tc.fails {
+			t.Errorf("AcceptDeflate(%q) = %v, %v", tc.ext, accepted, err)
+		}
+		if err != nil && !errors.Is(err, protocol.ErrDeflateResponse) {
+			t.Errorf("AcceptDeflate(%q) error %v is not ErrDeflateResponse", tc.ext, err)
+		}
+	}
+}
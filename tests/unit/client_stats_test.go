@@ -0,0 +1,88 @@
+// File: tests/unit/client_stats_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for client latency, throughput and reconnect figures.
+
+package unit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/adapters"
+	"github.com/momentics/hioload-ws/lowlevel/client"
+)
+
+// TestClientStats tests the RTT measured by heartbeat pings, the message and
+// byte counts, and their publication as control probes.
+func TestClientStats(t *testing.T) {
+	cfg := client.DefaultConfig()
+	cfg.Addr = fmt.Sprintf("ws://127.0.0.1:%d/echo", echoServer(t))
+	cfg.Heartbeat = 50 * time.Millisecond
+	cfg.Proxy = nil
+	c, err := client.NewClient(cfg)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer c.Close()
+	ctrl := adapters.NewControlAdapter()
+	c.RegisterStats(ctrl, "client")
+
+	msg := []byte("measure me")
+	for i := 0; i < 3; i++ {
+		if err := c.WriteMessage(2, msg); err != nil {
+			t.Fatalf("WriteMessage: %v", err)
+		}
+		if _, data, err := c.ReadMessage(); err != nil || string(data) != string(msg) {
+			t.Fatalf("Echo: %q, %v", data, err)
+		}
+	}
+	if !waitFor(t, 3*time.Second, func() bool { s := c.Stats(); return s.RTT > 0 && s.PingsSent >= 2 }) {
+		t.Fatalf("Expected an RTT from heartbeat pongs, got %+v", c.Stats())
+	}
+
+	s := c.Stats()
+	if s.MessagesSent != 3 || s.BytesSent < int64(3*len(msg)) {
+		t.Errorf("Sent %d messages, %d bytes", s.MessagesSent, s.BytesSent)
+	}
+	if s.MessagesReceived < 3 || s.BytesReceived < int64(3*len(msg)) {
+		t.Errorf("Received %d messages, %d bytes", s.MessagesReceived, s.BytesReceived)
+	}
+	if s.LastPong.IsZero() || s.Uptime <= 0 || s.SendRate <= 0 || s.RecvRate <= 0 {
+		t.Errorf("Unexpected stats %+v", s)
+	}
+	if s.QueueDepth != 0 {
+		t.Errorf("Expected an empty send queue, got %d", s.QueueDepth)
+	}
+
+	stats := ctrl.Stats()
+	if v, ok := stats["debug.client.messages_sent"].(int64); !ok || v != 3 {
+		t.Errorf("Probe messages_sent = %v", stats["debug.client.messages_sent"])
+	}
+	if v, ok := stats["debug.client.rtt"].(time.Duration); !ok || v <= 0 {
+		t.Errorf("Probe rtt = %v", stats["debug.client.rtt"])
+	}
+}
+
+// TestPoolStats tests that pool members report their reconnects.
+func TestPoolStats(t *testing.T) {
+	pool, err := client.NewPool(poolConfig(2, fmt.Sprintf("ws://127.0.0.1:%d/echo", echoServer(t))))
+	if err != nil {
+		t.Fatalf("NewPool: %v", err)
+	}
+	defer pool.Close()
+	c, _ := pool.ForKey("k")
+	c.GetWSConnection().Close()
+	if !waitFor(t, 2*time.Second, func() bool { return pool.Healthy() == 2 && pool.Replaced() == 1 }) {
+		t.Fatal("Expected the closed member replaced")
+	}
+	var reconnects uint64
+	for _, s := range pool.Stats() {
+		reconnects += s.Reconnects
+	}
+	if reconnects != 1 {
+		t.Errorf("Expected 1 reconnect across members, got %d", reconnects)
+	}
+}
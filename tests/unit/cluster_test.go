@@ -0,0 +1,191 @@
+// File: tests/unit/cluster_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for gossip membership and the pubsub bridge over it.
+
+package unit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/cluster"
+	"github.com/momentics/hioload-ws/pubsub"
+	"github.com/momentics/hioload-ws/pubsub/clusterbridge"
+)
+
+// clusterNode starts a node with fast probes that records its events.
+func clusterNode(t *testing.T, id string, secret []byte, seeds ...string) (*cluster.Cluster, func() []cluster.Event) {
+	t.Helper()
+	c, err := cluster.New(cluster.Config{
+		NodeID:        id,
+		BindAddr:      "127.0.0.1:0",
+		Seeds:         seeds,
+		Secret:        secret,
+		ProbeInterval: 50 * time.Millisecond,
+		SyncInterval:  200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("New %s failed: %v", id, err)
+	}
+	t.Cleanup(func() { c.Close() })
+	var mu sync.Mutex
+	var events []cluster.Event
+	c.OnChange(func(ev cluster.Event) {
+		mu.Lock()
+		events = append(events, ev)
+		mu.Unlock()
+	})
+	return c, func() []cluster.Event {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]cluster.Event(nil), events...)
+	}
+}
+
+// memberIDs returns the IDs of the live members c knows.
+func memberIDs(c *cluster.Cluster) []string {
+	var ids []string
+	for _, m := range c.Members() {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+// hasEvent reports whether events holds one of typ for id.
+func hasEvent(events []cluster.Event, typ cluster.EventType, id string) bool {
+	for _, ev := range events {
+		if ev.Type == typ && ev.Member.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// TestClusterMembership tests discovery through a seed, graceful leaves and
+// failure detection.
+func TestClusterMembership(t *testing.T) {
+	a, eventsA := clusterNode(t, "a", nil)
+	seed := a.LocalNode().Addr
+	b, _ := clusterNode(t, "b", nil, seed)
+	c, eventsC := clusterNode(t, "c", nil, seed)
+	for _, n := range []*cluster.Cluster{a, b, c} {
+		if !waitFor(t, 3*time.Second, func() bool { return len(n.Members()) == 3 }) {
+			t.Fatalf("Expected %s to see 3 members, got %v", n.LocalNode().ID, memberIDs(n))
+		}
+	}
+	if !waitFor(t, time.Second, func() bool { return hasEvent(eventsC(), cluster.EventJoin, "b") }) {
+		t.Fatalf("Expected c to learn of b by gossip, got %v", eventsC())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := b.Leave(ctx); err != nil {
+		t.Fatalf("Leave failed: %v", err)
+	}
+	if !waitFor(t, time.Second, func() bool { return hasEvent(eventsA(), cluster.EventLeave, "b") }) {
+		t.Fatalf("Expected a to see b leave, got %v", eventsA())
+	}
+	if m, _ := a.Member("b"); m.State != cluster.StateLeft {
+		t.Fatalf("Expected b left, got %v", m.State)
+	}
+
+	c.Close()
+	if !waitFor(t, 3*time.Second, func() bool { return hasEvent(eventsA(), cluster.EventFail, "c") }) {
+		t.Fatalf("Expected a to declare c failed, got %v", eventsA())
+	}
+	if ids := memberIDs(a); len(ids) != 1 || ids[0] != "a" {
+		t.Fatalf("Expected a alone, got %v", ids)
+	}
+	if hasEvent(eventsA(), cluster.EventFail, "b") {
+		t.Fatal("Expected no failure reported for a node that left")
+	}
+}
+
+// TestClusterSecret tests that nodes with different secrets do not join.
+func TestClusterSecret(t *testing.T) {
+	a, _ := clusterNode(t, "a", []byte("s1"))
+	b, _ := clusterNode(t, "b", []byte("s1"), a.LocalNode().Addr)
+	x, _ := clusterNode(t, "x", []byte("s2"), a.LocalNode().Addr)
+	if !waitFor(t, 3*time.Second, func() bool { return len(b.Members()) == 2 }) {
+		t.Fatalf("Expected b to join, got %v", memberIDs(b))
+	}
+	if n, err := x.Join(a.LocalNode().Addr); n != 0 || err == nil {
+		t.Fatalf("Expected a to refuse x, got %d answers (err=%v)", n, err)
+	}
+	if ids := memberIDs(x); len(ids) != 1 {
+		t.Fatalf("Expected x to stay alone, got %v", ids)
+	}
+	if ids := memberIDs(a); len(ids) != 2 {
+		t.Fatalf("Expected a and b only, got %v", ids)
+	}
+}
+
+// TestClusterMessaging tests Send, Broadcast and the pubsub bridge.
+func TestClusterMessaging(t *testing.T) {
+	a, _ := clusterNode(t, "a", nil)
+	b, _ := clusterNode(t, "b", nil, a.LocalNode().Addr)
+	c, _ := clusterNode(t, "c", nil, a.LocalNode().Addr)
+	for _, n := range []*cluster.Cluster{a, b, c} {
+		if !waitFor(t, 3*time.Second, func() bool { return len(n.Members()) == 3 }) {
+			t.Fatalf("Expected %s to see 3 members, got %v", n.LocalNode().ID, memberIDs(n))
+		}
+	}
+
+	got := make(chan string, 10)
+	for _, n := range []*cluster.Cluster{b, c} {
+		n := n
+		n.Handle("echo", func(from string, payload []byte) {
+			got <- n.LocalNode().ID + "<" + from + ":" + string(payload)
+		})
+	}
+	if err := a.Send("b", "echo", []byte("one")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if err := a.Send("zz", "echo", nil); !errors.Is(err, cluster.ErrUnknownNode) {
+		t.Fatalf("Expected ErrUnknownNode, got %v", err)
+	}
+	if err := a.Broadcast("echo", []byte("all")); err != nil {
+		t.Fatalf("Broadcast failed: %v", err)
+	}
+	want := map[string]bool{"b<a:one": true, "b<a:all": true, "c<a:all": true}
+	for len(want) > 0 {
+		select {
+		case msg := <-got:
+			if !want[msg] {
+				t.Fatalf("Unexpected message %q", msg)
+			}
+			delete(want, msg)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Missing messages %v", want)
+		}
+	}
+
+	brokerA, brokerB := pubsub.New(), pubsub.New()
+	defer brokerA.Close()
+	defer brokerB.Close()
+	bridgeA, err := pubsub.NewBridge(brokerA, clusterbridge.New(a), pubsub.WithNodeID("a"))
+	if err != nil {
+		t.Fatalf("NewBridge failed: %v", err)
+	}
+	defer bridgeA.Close()
+	bridgeB, err := pubsub.NewBridge(brokerB, clusterbridge.New(b), pubsub.WithNodeID("b"))
+	if err != nil {
+		t.Fatalf("NewBridge failed: %v", err)
+	}
+	defer bridgeB.Close()
+	sub, _ := brokerB.Subscribe("chat.>")
+	brokerA.Publish("chat.room1", []byte("hello"))
+	select {
+	case msg := <-sub.C():
+		if msg.Topic != "chat.room1" || string(msg.Payload) != "hello" || msg.Origin != "a" {
+			t.Fatalf("Unexpected bridged message %+v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Publish not bridged to b")
+	}
+}
@@ -0,0 +1,253 @@
+// File: tests/unit/codec_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the ReadObject/WriteObject codecs.
+
+package unit
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/momentics/hioload-ws/codec"
+	"github.com/momentics/hioload-ws/highlevel"
+)
+
+type codecQuote struct {
+	Symbol string            `msgpack:"sym" cbor:"sym"`
+	Bid    float64           `msgpack:"bid" cbor:"bid"`
+	Size   int32             `msgpack:"size,omitempty" cbor:"size,omitempty"`
+	Venues []string          `msgpack:"venues" cbor:"venues"`
+	Raw    []byte            `msgpack:"raw" cbor:"raw"`
+	ID     [4]byte           `msgpack:"id" cbor:"id"`
+	Tags   map[string]uint16 `msgpack:"tags" cbor:"tags"`
+	Next   *codecQuote       `msgpack:"next" cbor:"next"`
+	Secret string            `msgpack:"-" cbor:"-"`
+}
+
+// TestCodecVectors tests encodings against the MessagePack spec and the
+// examples of RFC 8949 Appendix A.
+func TestCodecVectors(t *testing.T) {
+	for _, tc := range []struct {
+		codec highlevel.Codec
+		v     any
+		want  string
+	}{
+		{codec.MsgPack{}, nil, "c0"},
+		{codec.MsgPack{}, true, "c3"},
+		{codec.MsgPack{}, 127, "7f"},
+		{codec.MsgPack{}, -1, "ff"},
+		{codec.MsgPack{}, -33, "d0df"},
+		{codec.MsgPack{}, 256, "cd0100"},
+		{codec.MsgPack{}, 1.5, "cb3ff8000000000000"},
+		{codec.MsgPack{}, "a", "a161"},
+		{codec.MsgPack{}, []byte{1}, "c40101"},
+		{codec.MsgPack{}, []int{1, 2}, "920102"},
+		{codec.MsgPack{}, map[string]int{"a": 1}, "81a16101"},
+		{codec.CBOR{}, 23, "17"},
+		{codec.CBOR{}, 24, "1818"},
+		{codec.CBOR{}, 1000, "1903e8"},
+		{codec.CBOR{}, uint64(18446744073709551615), "1bffffffffffffffff"},
+		{codec.CBOR{}, -1000, "3903e7"},
+		{codec.CBOR{}, false, "f4"},
+		{codec.CBOR{}, nil, "f6"},
+		{codec.CBOR{}, 1.1, "fb3ff199999999999a"},
+		{codec.CBOR{}, "IETF", "6449455446"},
+		{codec.CBOR{}, []byte{1, 2, 3, 4}, "4401020304"},
+		{codec.CBOR{}, []int{1, 2, 3}, "83010203"},
+		{codec.CBOR{}, map[string]string{"a": "A", "b": "B"}, "a26161614161626142"},
+	} {
+		got, err := tc.codec.Marshal(tc.v)
+		if err != nil || hex.EncodeToString(got) != tc.want {
+			t.Errorf("%T(%#v): expected %s, got %x (err=%v)", tc.codec, tc.v, tc.want, got, err)
+		}
+	}
+}
+
+// TestCBORDecodeForms tests decoding of forms the encoder never writes.
+func TestCBORDecodeForms(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want any
+	}{
+		{"f93c00", 1.0},
+		{"f97bff", 65504.0},
+		{"f9c400", -4.0},
+		{"fa47c35000", 100000.0},
+		{"c11a514b67b0", int64(1363896240)}, // tag 1: epoch time
+		{"f7", nil},
+		{"3bffffffffffffffff", nil}, // -2^64 does not fit
+	} {
+		data, _ := hex.DecodeString(tc.in)
+		var v any
+		err := codec.CBOR{}.Unmarshal(data, &v)
+		if tc.want == nil && tc.in != "f7" {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %v", tc.in, v)
+			}
+			continue
+		}
+		if err != nil || v != tc.want {
+			t.Errorf("%s: expected %v, got %v (err=%v)", tc.in, tc.want, v, err)
+		}
+	}
+}
+
+// TestCodecRoundTrip tests structs, tags and generic decoding in both formats.
+func TestCodecRoundTrip(t *testing.T) {
+	in := codecQuote{
+		Symbol: "EURUSD",
+		Bid:    1.0842,
+		Venues: []string{"lmax", "ebs"},
+		Raw:    []byte{0, 1, 2},
+		ID:     [4]byte{9, 8, 7, 6},
+		Tags:   map[string]uint16{"lot": 1000},
+		Next:   &codecQuote{Symbol: "GBPUSD", Size: -5},
+		Secret: "hidden",
+	}
+	for _, c := range []highlevel.Codec{codec.MsgPack{}, codec.CBOR{}} {
+		data, err := c.Marshal(in)
+		if err != nil {
+			t.Fatalf("%T: marshal failed: %v", c, err)
+		}
+		var out codecQuote
+		if err := c.Unmarshal(data, &out); err != nil {
+			t.Fatalf("%T: unmarshal failed: %v", c, err)
+		}
+		want := in
+		want.Secret = ""
+		if !reflect.DeepEqual(out, want) {
+			t.Errorf("%T: expected %+v, got %+v", c, want, out)
+		}
+
+		var generic map[string]any
+		if err := c.Unmarshal(data, &generic); err != nil {
+			t.Fatalf("%T: generic unmarshal failed: %v", c, err)
+		}
+		if _, ok := generic["size"]; ok {
+			t.Errorf("%T: expected omitempty to drop size", c)
+		}
+		if generic["sym"] != "EURUSD" || !bytes.Equal(generic["raw"].([]byte), in.Raw) ||
+			generic["next"].(map[string]any)["size"] != int64(-5) ||
+			generic["tags"].(map[string]any)["lot"] != int64(1000) {
+			t.Errorf("%T: unexpected generic form %v", c, generic)
+		}
+	}
+}
+
+// TestCodecErrors tests that malformed or mistyped input is rejected.
+func TestCodecErrors(t *testing.T) {
+	var small int8
+	var s string
+	var q codecQuote
+	for _, tc := range []struct {
+		codec highlevel.Codec
+		in    string
+		v     any
+	}{
+		{codec.MsgPack{}, "cd0100", &small},   // 256 overflows int8
+		{codec.MsgPack{}, "a361", &s},         // truncated string
+		{codec.MsgPack{}, "dd7fffffff", &q},   // array longer than the input
+		{codec.MsgPack{}, "c0c0", &s},         // trailing data
+		{codec.MsgPack{}, "d6ff00000000", &q}, // timestamp extension
+		{codec.MsgPack{}, "a161", &small},     // string into int
+		{codec.CBOR{}, "9f01ff", &q},          // indefinite-length array
+		{codec.CBOR{}, "1a0001", &small},      // truncated head
+		{codec.CBOR{}, "00", s},               // not a pointer
+	} {
+		data, _ := hex.DecodeString(tc.in)
+		if err := tc.codec.Unmarshal(data, tc.v); err == nil {
+			t.Errorf("%T(%s): expected an error", tc.codec, tc.in)
+		}
+	}
+
+	deep := bytes.Repeat([]byte{0x91}, 2000)
+	var v any
+	if err := (codec.MsgPack{}).Unmarshal(append(deep, 0xc0), &v); err == nil {
+		t.Error("Expected deeply nested input rejected")
+	}
+	if _, err := (codec.CBOR{}).Marshal(make(chan int)); err == nil {
+		t.Error("Expected unsupported type rejected")
+	}
+}
+
+// fakeProto stands in for a generated message.
+type fakeProto struct{ text string }
+
+func (m *fakeProto) Marshal() ([]byte, error) { return []byte("pb:" + m.text), nil }
+
+func (m *fakeProto) Unmarshal(data []byte) error {
+	if !bytes.HasPrefix(data, []byte("pb:")) {
+		return errors.New("bad message")
+	}
+	m.text = string(data[3:])
+	return nil
+}
+
+// TestProtobufCodec tests generated methods and the function overrides.
+func TestProtobufCodec(t *testing.T) {
+	var pb codec.Protobuf
+	data, err := pb.Marshal(&fakeProto{text: "hi"})
+	if err != nil || string(data) != "pb:hi" {
+		t.Fatalf("Expected pb:hi, got %q (err=%v)", data, err)
+	}
+	var out fakeProto
+	if err := pb.Unmarshal(data, &out); err != nil || out.text != "hi" {
+		t.Fatalf("Expected hi, got %q (err=%v)", out.text, err)
+	}
+	if _, err := pb.Marshal(struct{}{}); err == nil {
+		t.Error("Expected a non-message rejected")
+	}
+
+	pb.MarshalFunc = func(v any) ([]byte, error) { return []byte(v.(string)), nil }
+	if data, _ := pb.Marshal("custom"); string(data) != "custom" {
+		t.Errorf("Expected MarshalFunc used, got %q", data)
+	}
+}
+
+// TestConnObjects tests ReadObject/WriteObject with a server default codec
+// and a per-connection codec on the client.
+func TestConnObjects(t *testing.T) {
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf(":%d", port), highlevel.WithCodec(codec.CBOR{}))
+	srv.HandleFunc("/obj", func(c *highlevel.Conn) {
+		for {
+			var q codecQuote
+			if err := c.ReadObject(&q); err != nil {
+				return
+			}
+			q.Bid *= 2
+			c.WriteObject(q)
+		}
+	})
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	conn, err := highlevel.Dial(fmt.Sprintf("ws://localhost:%d/obj", port))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	if _, ok := conn.Codec().(highlevel.JSONCodec); !ok {
+		t.Errorf("Expected JSONCodec by default, got %T", conn.Codec())
+	}
+	conn.SetCodec(codec.CBOR{})
+	if err := conn.WriteObject(codecQuote{Symbol: "XAUUSD", Bid: 1200}); err != nil {
+		t.Fatalf("WriteObject failed: %v", err)
+	}
+	mt, msg, err := conn.ReadMessage()
+	if err != nil || mt != int(highlevel.BinaryMessage) {
+		t.Fatalf("Expected a binary reply, got type %d (err=%v)", mt, err)
+	}
+	var q codecQuote
+	if err := (codec.CBOR{}).Unmarshal(msg, &q); err != nil || q.Symbol != "XAUUSD" || math.Abs(q.Bid-2400) > 1e-9 {
+		t.Errorf("Expected XAUUSD at 2400, got %+v (err=%v)", q, err)
+	}
+}
@@ -1,9 +1,11 @@
 package unit
 
 import (
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/momentics/hioload-ws/api"
 	"github.com/momentics/hioload-ws/highlevel"
 	"github.com/momentics/hioload-ws/lowlevel/client"
 	"github.com/momentics/hioload-ws/lowlevel/server"
@@ -312,3 +314,107 @@ func TestBufferPoolConcurrency(t *testing.T) {
 
 	t.Log("Buffer pool concurrency test passed")
 }
+
+// TestBufferPoolMemoryLock tests that locked pools serve and recycle usable buffers
+func TestBufferPoolMemoryLock(t *testing.T) {
+	manager := pool.NewBufferPoolManager(1, pool.WithMemoryLock(true))
+	if !manager.MemoryLocked() {
+		t.Fatal("Expected manager to report locked memory")
+	}
+	bp := manager.GetPool(4096, 0)
+
+	buf := bp.Get(4096, 0)
+	if len(buf.Bytes()) < 4096 {
+		t.Fatalf("Expected at least 4096 bytes, got %d", len(buf.Bytes()))
+	}
+	copy(buf.Bytes(), []byte("locked"))
+	if string(buf.Bytes()[:6]) != "locked" {
+		t.Errorf("Locked buffer write/read mismatch")
+	}
+	buf.Release()
+
+	if stats := bp.Stats(); stats.TotalAlloc != 1 {
+		t.Errorf("Expected 1 allocation, got %d", stats.TotalAlloc)
+	}
+
+	// A view cut past the slab origin finds its way back to the slab.
+	buf = bp.Get(4096, 0)
+	buf.Slice(100, 200).Release()
+	again := bp.Get(4096, 0)
+	if &again.Bytes()[0] != &buf.Bytes()[0] || len(again.Bytes()) != len(buf.Bytes()) {
+		t.Error("Expected the slab of a released view to be recycled whole")
+	}
+	again.Release()
+
+	// So does a view re-sliced to a smaller capacity.
+	view := again.Slice(10, 20)
+	view.Data = view.Data[:5:5]
+	view.Release()
+	again = bp.Get(4096, 0)
+	if &again.Bytes()[0] != &buf.Bytes()[0] || len(again.Bytes()) != len(buf.Bytes()) {
+		t.Error("Expected the slab of a re-sliced view to be recycled whole")
+	}
+	again.Release()
+	if stats := bp.Stats(); stats.TotalAlloc != 1 {
+		t.Errorf("Expected 1 allocation, got %d", stats.TotalAlloc)
+	}
+}
+
+// TestBufferPoolManagerOptions tests size class, budget and NUMA layout options
+func TestBufferPoolManagerOptions(t *testing.T) {
+	manager := pool.NewBufferPoolManager(4,
+		pool.WithNUMANodes(1),
+		pool.WithSizeClasses(8192, 1024),
+		pool.WithNodeBudget(1024),
+	)
+	if manager.NodeCount() != 1 {
+		t.Fatalf("Expected NUMA layout override of 1 node, got %d", manager.NodeCount())
+	}
+	if classes := manager.SizeClasses(); len(classes) != 2 || classes[0] != 1024 {
+		t.Fatalf("Expected sorted size classes [1024 8192], got %v", classes)
+	}
+
+	// Out-of-layout node requests must wrap onto an existing node.
+	bp := manager.GetPool(100, 3)
+	a, b := bp.Get(100, 0), bp.Get(100, 0)
+	if len(a.Bytes()) != 1024 {
+		t.Fatalf("Expected 1024-byte class, got %d", len(a.Bytes()))
+	}
+
+	// Budget allows one cached 1K buffer; the second is released.
+	a.Release()
+	b.Release()
+	if stats := bp.Stats(); stats.TotalFree != 1 {
+		t.Errorf("Expected 1 cached buffer under budget, got %d", stats.TotalFree)
+	}
+}
+
+// TestBufferPoolBudgetConcurrentPuts tests that buffers released at once
+// from many goroutines never cache more than the node budget.
+func TestBufferPoolBudgetConcurrentPuts(t *testing.T) {
+	manager := pool.NewBufferPoolManager(4,
+		pool.WithNUMANodes(1),
+		pool.WithSizeClasses(1024),
+		pool.WithNodeBudget(4*1024),
+	)
+	bp := manager.GetPool(1024, 0)
+	bufs := make([]api.Buffer, 64)
+	for i := range bufs {
+		bufs[i] = bp.Get(1024, 0)
+	}
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, buf := range bufs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			buf.Release()
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if stats := bp.Stats(); stats.TotalFree > 4 {
+		t.Errorf("Expected at most 4 cached buffers under budget, got %d", stats.TotalFree)
+	}
+}
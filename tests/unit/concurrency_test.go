@@ -5,9 +5,13 @@
 package unit
 
 import (
+	"sync"
+	"sync/atomic"
 	"testing"
 
+	"github.com/momentics/hioload-ws/adapters"
 	"github.com/momentics/hioload-ws/internal/concurrency"
+	"github.com/momentics/hioload-ws/pool"
 )
 
 // TestRingBuffer_EnqueueDequeue tests the basic functionality of the lock-free ring buffer.
@@ -179,4 +183,94 @@ func (teh *testEventHandler) HandleEvent(ev concurrency.Event) {
 	if teh.handleFunc != nil {
 		teh.handleFunc(ev)
 	}
-}
\ No newline at end of file
+}
+
+// TestPoolRing_Batch tests ordered batch push/pop on the exported ring.
+func TestPoolRing_Batch(t *testing.T) {
+	r := pool.NewRing[int](5) // rounds up to 8
+	if r.Cap() != 8 {
+		t.Fatalf("Expected capacity 8, got %d", r.Cap())
+	}
+	if n := r.EnqueueBatch([]int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}); n != 8 {
+		t.Fatalf("Expected 8 items enqueued, got %d", n)
+	}
+	dst := make([]int, 3)
+	if n := r.DequeueBatch(dst); n != 3 || dst[0] != 1 || dst[2] != 3 {
+		t.Fatalf("Unexpected batch dequeue: n=%d dst=%v", n, dst)
+	}
+	if v, ok := r.Dequeue(); !ok || v != 4 {
+		t.Fatalf("Expected 4, got %d (ok=%v)", v, ok)
+	}
+	if r.Len() != 4 {
+		t.Errorf("Expected length 4, got %d", r.Len())
+	}
+}
+
+// TestPoolRing_MPMC tests that concurrent batch producers and consumers lose nothing.
+func TestPoolRing_MPMC(t *testing.T) {
+	r := pool.NewRing[int](256)
+	const producers, perProducer = 4, 5000
+	var sent, received, count int64
+	var wg sync.WaitGroup
+
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(base int) {
+			defer wg.Done()
+			batch := make([]int, 0, 16)
+			for i := 1; i <= perProducer; i++ {
+				batch = append(batch, base+i)
+				atomic.AddInt64(&sent, int64(base+i))
+				if len(batch) == cap(batch) || i == perProducer {
+					for pending := batch; len(pending) > 0; {
+						pending = pending[r.EnqueueBatch(pending):]
+					}
+					batch = batch[:0]
+				}
+			}
+		}(p * perProducer)
+	}
+
+	total := int64(producers * perProducer)
+	var cwg sync.WaitGroup
+	for c := 0; c < 4; c++ {
+		cwg.Add(1)
+		go func() {
+			defer cwg.Done()
+			dst := make([]int, 8)
+			for atomic.LoadInt64(&count) < total {
+				n := r.DequeueBatch(dst)
+				for _, v := range dst[:n] {
+					atomic.AddInt64(&received, int64(v))
+				}
+				atomic.AddInt64(&count, int64(n))
+			}
+		}()
+	}
+	wg.Wait()
+	cwg.Wait()
+
+	if sent != received {
+		t.Errorf("Checksum mismatch: sent %d, received %d", sent, received)
+	}
+}
+
+// TestTopology tests that the CPU topology lists every processor once, on a
+// known NUMA node, in ID order.
+func TestTopology(t *testing.T) {
+	cpus := concurrency.Topology()
+	if len(cpus) == 0 {
+		t.Fatal("Expected at least one CPU")
+	}
+	for i, c := range cpus {
+		if i > 0 && c.ID <= cpus[i-1].ID {
+			t.Errorf("CPU %d listed after CPU %d", c.ID, cpus[i-1].ID)
+		}
+		if c.Node < 0 || c.Node >= concurrency.NUMANodes() {
+			t.Errorf("CPU %d on unknown node %d", c.ID, c.Node)
+		}
+	}
+	if n := len(adapters.CPUTopology()); n != len(cpus) {
+		t.Errorf("Expected the affinity API to report %d CPUs, got %d", len(cpus), n)
+	}
+}
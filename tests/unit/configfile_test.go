@@ -0,0 +1,218 @@
+// File: tests/unit/configfile_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for config file loading and hot reload.
+
+package unit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/control"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+)
+
+const yamlConfig = `# server settings
+server:
+  listen_addr: ":9100"
+  batch_size: 64
+  shutdown_timeout: 5s
+  subprotocols:
+    - mqtt
+    - 'graphql-transport-ws'
+ratelimit:
+  frames_per_sec: 12.5   # per session
+  frame_key: ip
+app:
+  debug: true
+`
+
+const tomlConfig = `# server settings
+[server]
+listen_addr = ":9100"
+batch_size = 64
+shutdown_timeout = "5s"
+subprotocols = [
+  "mqtt",
+  "graphql-transport-ws",
+]
+
+[ratelimit]
+frames_per_sec = 12.5 # per session
+frame_key = 'ip'
+
+[app]
+debug = true
+`
+
+const jsonConfig = `{
+  "server": {"listen_addr": ":9100", "batch_size": 64, "shutdown_timeout": "5s",
+             "subprotocols": ["mqtt", "graphql-transport-ws"]},
+  "ratelimit": {"frames_per_sec": 12.5, "frame_key": "ip"},
+  "app": {"debug": true}
+}`
+
+// TestLoadConfig tests that the three formats flatten identically and map
+// onto the server Config.
+func TestLoadConfig(t *testing.T) {
+	want := map[string]any{
+		"server.listen_addr":       ":9100",
+		"server.batch_size":        int64(64),
+		"server.shutdown_timeout":  "5s",
+		"server.subprotocols":      []any{"mqtt", "graphql-transport-ws"},
+		"ratelimit.frames_per_sec": 12.5,
+		"ratelimit.frame_key":      "ip",
+		"app.debug":                true,
+	}
+	dir := t.TempDir()
+	for name, body := range map[string]string{"c.yaml": yamlConfig, "c.toml": tomlConfig, "c.json": jsonConfig} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(body), 0o644)
+		got, err := control.LoadConfig(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %#v", name, got)
+		}
+
+		cfg, err := server.LoadConfig(path)
+		if err != nil {
+			t.Fatalf("%s: server.LoadConfig: %v", name, err)
+		}
+		if cfg.ListenAddr != ":9100" || cfg.BatchSize != 64 || cfg.ShutdownTimeout != 5*time.Second ||
+			cfg.RateLimit.FramesPerSec != 12.5 || cfg.RateLimit.FrameKey != server.RateLimitByIP ||
+			len(cfg.Subprotocols) != 2 || cfg.ReactorRing != server.DefaultConfig().ReactorRing {
+			t.Errorf("%s: unexpected Config %+v", name, cfg)
+		}
+	}
+
+	bad := filepath.Join(dir, "bad.toml")
+	os.WriteFile(bad, []byte("[server]\nbatch_size = \"many\"\n"), 0o644)
+	if _, err := server.LoadConfig(bad); err == nil {
+		t.Error("Expected type error for non-integer batch_size")
+	}
+}
+
+// TestParseConfigScalars tests that numbers are decimal unless a radix
+// prefix of the format says otherwise, and that an apostrophe inside a plain
+// YAML scalar does not hide the comment after it.
+func TestParseConfigScalars(t *testing.T) {
+	got, err := control.ParseConfig("yaml", []byte(
+		"dec: 010\nhex: 0x1f\noct: 0o17\nbin: 0b11\nmsg: it's here # note\nquoted: 'a # b'\n"))
+	if err != nil {
+		t.Fatalf("yaml: %v", err)
+	}
+	want := map[string]any{
+		"dec": int64(10), "hex": int64(31), "oct": int64(15), "bin": "0b11",
+		"msg": "it's here", "quoted": "a # b",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("yaml: got %#v", got)
+	}
+
+	got, err = control.ParseConfig("toml", []byte("dec = 1_000\nhex = 0xff\noct = 0o10\nbin = 0b101\nf = 1.5e3\n"))
+	if err != nil {
+		t.Fatalf("toml: %v", err)
+	}
+	want = map[string]any{"dec": int64(1000), "hex": int64(255), "oct": int64(8), "bin": int64(5), "f": 1500.0}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("toml: got %#v", got)
+	}
+	if _, err := control.ParseConfig("toml", []byte("x = 0x1p4\n")); err == nil {
+		t.Error("Expected a hex float to be rejected")
+	}
+}
+
+// TestWatchConfig tests that file changes and SIGHUP re-apply changed keys
+// through the server's control config.
+func TestWatchConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.yaml")
+	os.WriteFile(path, []byte("ratelimit:\n  frames_per_sec: 10\napp:\n  mode: a\n"), 0o644)
+
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf(":%d", freePort(t))
+	cfg.ShutdownTimeout = 10 * time.Millisecond
+	srv, err := server.NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+	runServer(t, srv, api.HandlerFunc(func(any) error { return nil }))
+	w, err := srv.WatchConfig(path, control.WithConfigPollInterval(20*time.Millisecond))
+	if err != nil {
+		t.Fatalf("WatchConfig: %v", err)
+	}
+	defer w.Close()
+	ctrl := srv.GetControl()
+	if ctrl.GetConfig()[server.CfgFramesPerSec] != int64(10) || ctrl.GetConfig()["app.mode"] != "a" {
+		t.Fatalf("Initial values not applied: %v", ctrl.GetConfig())
+	}
+
+	waitMode := func(mode string) {
+		t.Helper()
+		if !waitFor(t, time.Second, func() bool { return ctrl.GetConfig()["app.mode"] == mode }) {
+			t.Fatalf("Expected app.mode %q, got %v", mode, ctrl.GetConfig()["app.mode"])
+		}
+	}
+
+	os.WriteFile(path, []byte("ratelimit:\n  frames_per_sec: 10\napp:\n  mode: bb\n"), 0o644)
+	waitMode("bb")
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	// Without polling only SIGHUP picks up the change.
+	w.Close()
+	w, err = srv.WatchConfig(path, control.WithConfigPollInterval(0))
+	if err != nil {
+		t.Fatalf("WatchConfig: %v", err)
+	}
+	defer w.Close()
+	os.WriteFile(path, []byte("ratelimit:\n  frames_per_sec: 10\napp:\n  mode: cc\n"), 0o644)
+	if got := ctrl.GetConfig()["app.mode"]; got != "bb" {
+		t.Fatalf("Change applied without SIGHUP: %v", got)
+	}
+	p, _ := os.FindProcess(os.Getpid())
+	p.Signal(syscall.SIGHUP)
+	waitMode("cc")
+}
+
+// TestApplyEnv tests the HIOLOAD_* overlay on top of a Config.
+func TestApplyEnv(t *testing.T) {
+	t.Setenv("HIOLOAD_LISTEN_ADDR", "0.0.0.0:9101")
+	t.Setenv("HIOLOAD_BATCH_SIZE", "128")
+	t.Setenv("HIOLOAD_NUMA_NODE", "1")
+	t.Setenv("HIOLOAD_READ_TIMEOUT", "15s")
+	t.Setenv("HIOLOAD_RATELIMIT__FRAMES_PER_SEC", "2.5")
+	t.Setenv("HIOLOAD_SUBPROTOCOLS", "[mqtt, jsonrpc]")
+
+	env := control.LoadEnv("")
+	if env["ratelimit.frames_per_sec"] != 2.5 || env["batch_size"] != int64(128) {
+		t.Fatalf("Unexpected env values %v", env)
+	}
+
+	cfg := server.DefaultConfig()
+	if err := server.ApplyEnv(cfg); err != nil {
+		t.Fatalf("ApplyEnv: %v", err)
+	}
+	if cfg.ListenAddr != "0.0.0.0:9101" || cfg.BatchSize != 128 || cfg.NUMANode != 1 ||
+		cfg.ReadTimeout != 15*time.Second || cfg.RateLimit.FramesPerSec != 2.5 ||
+		!reflect.DeepEqual(cfg.Subprotocols, []string{"mqtt", "jsonrpc"}) {
+		t.Fatalf("Unexpected Config %+v", cfg)
+	}
+
+	t.Setenv("HIOLOAD_BATCH_SIZE", "lots")
+	if err := server.ApplyEnv(server.DefaultConfig()); err == nil {
+		t.Fatal("Expected error for non-integer HIOLOAD_BATCH_SIZE")
+	}
+}
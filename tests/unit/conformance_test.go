@@ -0,0 +1,94 @@
+// File: tests/unit/conformance_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the conformance battery, run against the server over
+// in-memory transports and over TCP.
+
+package unit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/conformance"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+)
+
+// TestConformanceInMemory tests that a strict server passes every case and
+// a lenient one fails those strict validation covers.
+func TestConformanceInMemory(t *testing.T) {
+	for _, strict := range []bool{true, false} {
+		cfg := server.DefaultConfig()
+		cfg.ListenAddr = fmt.Sprintf("127.0.0.1:%d", freePort(t))
+		cfg.ShutdownTimeout = 10 * time.Millisecond
+		cfg.StrictValidation = strict
+		srv := startSimServer(t, cfg)
+
+		// The lenient server leaves most violations unanswered: wait less.
+		timeout := conformance.DefaultTimeout
+		if !strict {
+			timeout = 200 * time.Millisecond
+		}
+		rep := conformance.Run(context.Background(), conformance.InMemory(srv, "/conformance"),
+			conformance.Options{Echo: true, Timeout: timeout})
+		var out strings.Builder
+		rep.WriteText(&out)
+		if len(rep.Results) != len(conformance.Cases()) {
+			t.Fatalf("strict %v: %d results\n%s", strict, len(rep.Results), out.String())
+		}
+		if strict {
+			if err := rep.Err(); err != nil {
+				t.Errorf("strict server: %v\n%s", err, out.String())
+			}
+			continue
+		}
+		failed := map[string]bool{}
+		for _, res := range rep.Failed() {
+			failed[res.Case] = true
+		}
+		for _, name := range []string{"unmasked-text", "reserved-opcode-data", "close-invalid-utf8"} {
+			if !failed[name] {
+				t.Errorf("lenient server passed %s\n%s", name, out.String())
+			}
+		}
+		if failed["ping-pong"] || failed["interleaved-ping"] {
+			t.Errorf("lenient server failed valid cases\n%s", out.String())
+		}
+	}
+}
+
+// TestConformanceDial tests the battery over TCP, skipping the echo cases
+// and stopping when the context ends.
+func TestConformanceDial(t *testing.T) {
+	port := freePort(t)
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf("127.0.0.1:%d", port)
+	cfg.ShutdownTimeout = 10 * time.Millisecond
+	cfg.StrictValidation = true
+	startSimServer(t, cfg)
+
+	dial := conformance.Dial(fmt.Sprintf("ws://127.0.0.1:%d/conformance", port), nil)
+	rep := conformance.Run(context.Background(), dial, conformance.Options{})
+	var out strings.Builder
+	rep.WriteText(&out)
+	if err := rep.Err(); err != nil {
+		t.Fatalf("%v\n%s", err, out.String())
+	}
+	if passed, _, skipped := rep.Counts(); skipped != 1 || passed != len(conformance.Cases())-1 {
+		t.Errorf("passed %d, skipped %d\n%s", passed, skipped, out.String())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rep = conformance.Run(ctx, dial, conformance.Options{Cases: conformance.Cases()[:2]})
+	if _, _, skipped := rep.Counts(); skipped != 2 {
+		t.Errorf("cancelled run: %+v", rep.Results)
+	}
+	if rep = conformance.Run(context.Background(), conformance.Dial("ws://127.0.0.1:1/", nil), conformance.Options{Cases: conformance.Cases()[:1]}); rep.Passed() {
+		t.Error("unreachable server passed")
+	}
+}
@@ -0,0 +1,55 @@
+// File: tests/unit/conn_values_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the connection-scoped value store.
+
+package unit
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/momentics/hioload-ws/highlevel"
+)
+
+// TestConnValues tests that values set by middleware reach the handler.
+func TestConnValues(t *testing.T) {
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf(":%d", port))
+	srv.Use(func(next func(*highlevel.Conn)) func(*highlevel.Conn) {
+		return func(c *highlevel.Conn) {
+			c.Set("user", "alice")
+			c.Context().Set("tenant", 7, true)
+			next(c)
+		}
+	})
+	srv.HandleFunc("/values", func(c *highlevel.Conn) {
+		if _, _, err := c.ReadMessage(); err != nil {
+			return
+		}
+		user, _ := c.Get("user")
+		tenant, _ := c.Get("tenant")
+		_, missing := c.Get("missing")
+		c.WriteString(fmt.Sprintf("%v %v %v %v", user, tenant, c.Context().IsPropagated("tenant"), missing))
+	})
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	conn, err := highlevel.Dial(fmt.Sprintf("ws://localhost:%d/values", port))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	conn.WriteString("hi")
+	if _, msg, err := conn.ReadMessage(); err != nil || string(msg) != "alice 7 true false" {
+		t.Errorf("Expected values from middleware, got %q (err=%v)", msg, err)
+	}
+
+	// Client-side connections get a store from the default factory.
+	conn.Set("k", 1)
+	if v, ok := conn.Get("k"); !ok || v != 1 {
+		t.Errorf("Expected client-side value, got %v %v", v, ok)
+	}
+}
@@ -0,0 +1,131 @@
+// File: tests/unit/connlimit_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for MaxConnections enforcement and overflow policies.
+
+package unit
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/control"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// startLimitedServer runs a server admitting a single connection.
+func startLimitedServer(t *testing.T, policy server.OverflowPolicy, wait time.Duration) (*server.Server, int) {
+	port := freePort(t)
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf(":%d", port)
+	cfg.ShutdownTimeout = 10 * time.Millisecond
+	cfg.MaxConnections = 1
+	cfg.OverflowPolicy = policy
+	cfg.OverflowWait = wait
+	srv, err := server.NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+	runServer(t, srv, api.HandlerFunc(func(any) error { return nil }))
+	return srv, port
+}
+
+// waitConns polls until the server reports n admitted connections.
+func waitConns(t *testing.T, srv *server.Server, n int64) {
+	t.Helper()
+	if !waitFor(t, time.Second, func() bool { return srv.GetActiveConnections() == n }) {
+		t.Fatalf("Expected %d active connections, got %d", n, srv.GetActiveConnections())
+	}
+}
+
+func TestConnectionLimit_Reject(t *testing.T) {
+	srv, port := startLimitedServer(t, server.OverflowReject, 0)
+	conn, _, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	defer conn.Close()
+	waitConns(t, srv, 1)
+
+	upgrade(t, port, "/", nil, http.StatusServiceUnavailable)
+	stats := srv.GetControl().Stats()
+	if stats["debug.connections.peak"] != int64(1) || stats["debug.connections.rejected"] != int64(1) {
+		t.Errorf("Unexpected connection stats: peak=%v rejected=%v",
+			stats["debug.connections.peak"], stats["debug.connections.rejected"])
+	}
+}
+
+func TestConnectionLimit_CloseOldestIdle(t *testing.T) {
+	srv, port := startLimitedServer(t, server.OverflowCloseOldestIdle, 0)
+	idle, br, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	defer idle.Close()
+	waitConns(t, srv, 1)
+
+	fresh, _, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	defer fresh.Close()
+
+	idle.SetReadDeadline(time.Now().Add(time.Second))
+	frame, err := protocol.DecodeFrame(br)
+	if err != nil || frame.Opcode != protocol.OpcodeClose {
+		t.Fatalf("Expected close frame on evicted connection, got %v (err=%v)", frame, err)
+	}
+	if code := binary.BigEndian.Uint16(frame.Payload); code != protocol.CloseTryAgainLater {
+		t.Errorf("Expected close code 1013, got %d", code)
+	}
+	waitConns(t, srv, 1)
+}
+
+func TestConnectionLimit_QueueTimeout(t *testing.T) {
+	srv, port := startLimitedServer(t, server.OverflowQueue, 100*time.Millisecond)
+	first, _, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	waitConns(t, srv, 1)
+
+	// A queued connection is admitted once the slot frees up.
+	queued, _, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	defer queued.Close()
+	first.Close()
+	connects := func() int {
+		n := 0
+		for _, e := range srv.AuditEvents() {
+			if e.Kind == control.AuditConnect {
+				n++
+			}
+		}
+		return n
+	}
+	if !waitFor(t, time.Second, func() bool { return connects() == 2 }) {
+		t.Fatalf("Expected the queued connection admitted, got %d connects", connects())
+	}
+	waitConns(t, srv, 1)
+
+	// With the slot taken again, the next one times out with 1013.
+	late, br, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	defer late.Close()
+	late.SetReadDeadline(time.Now().Add(time.Second))
+	frame, err := protocol.DecodeFrame(br)
+	if err != nil || frame.Opcode != protocol.OpcodeClose {
+		t.Fatalf("Expected close frame after queue timeout, got %v (err=%v)", frame, err)
+	}
+}
+
+// TestNewCloseFrame_Truncate tests that an over-long close reason is cut at
+// a rune boundary within the control payload limit.
+func TestNewCloseFrame_Truncate(t *testing.T) {
+	f := protocol.NewCloseFrame(protocol.CloseTryAgainLater, "xy"+strings.Repeat("é", 100))
+	if len(f.Payload) > protocol.MaxControlPayloadLen || int64(len(f.Payload)) != f.PayloadLen {
+		t.Fatalf("Payload of %d bytes, PayloadLen %d", len(f.Payload), f.PayloadLen)
+	}
+	code, reason, err := protocol.ParseClosePayload(f.Payload)
+	if err != nil || code != protocol.CloseTryAgainLater {
+		t.Fatalf("ParseClosePayload: %d, %v", code, err)
+	}
+	if !utf8.ValidString(reason) || len(reason) != protocol.MaxControlPayloadLen-3 {
+		t.Errorf("Reason of %d bytes, valid %v", len(reason), utf8.ValidString(reason))
+	}
+}
@@ -0,0 +1,88 @@
+// File: tests/unit/dial_context_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for context-bound dialing with DialContext and NewClientContext.
+
+package unit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/highlevel"
+	"github.com/momentics/hioload-ws/lowlevel/client"
+)
+
+// silentListener accepts connections and never answers the handshake.
+func silentListener(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { c.Close() })
+		}
+	}()
+	return "ws://" + ln.Addr().String() + "/"
+}
+
+// TestDialContextAbortsHandshake tests that the handshake honors the
+// context's deadline and cancellation instead of the fixed timeout.
+func TestDialContextAbortsHandshake(t *testing.T) {
+	url := silentListener(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := highlevel.DialContext(ctx, url)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected DeadlineExceeded, got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Dial took %v after a 100ms deadline", d)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	cfg := client.DefaultConfig()
+	cfg.Addr = url
+	cfg.Heartbeat = 0
+	if _, err := client.NewClientContext(ctx, cfg); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected Canceled, got %v", err)
+	}
+
+	if _, err := highlevel.DialContext(ctx, url); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected Canceled for a done context, got %v", err)
+	}
+}
+
+// TestDialContextOutlivesContext tests that a connection dialed with a
+// context keeps working once that context is done.
+func TestDialContextOutlivesContext(t *testing.T) {
+	port := echoServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	conn, err := highlevel.DialContext(ctx, fmt.Sprintf("ws://localhost:%d/echo", port))
+	cancel()
+	if err != nil {
+		t.Fatalf("DialContext: %v", err)
+	}
+	defer conn.Close()
+	if err := conn.WriteMessage(int(highlevel.TextMessage), []byte("ping")); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	if _, data, err := conn.ReadMessage(); err != nil || string(data) != "ping" {
+		t.Errorf("Expected echo after the dial context ended, got %q, %v", data, err)
+	}
+}
@@ -0,0 +1,151 @@
+// File: tests/unit/dial_options_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for handshake headers, cookies, subprotocols and dialers set
+// with DialOption and client.Option.
+
+package unit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/highlevel"
+	"github.com/momentics/hioload-ws/lowlevel/client"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// TestDialOptions tests that the handshake carries the configured header,
+// the jar's cookies and the offered subprotocols, and that the response's
+// subprotocol and cookies reach the connection and the jar.
+func TestDialOptions(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+	reqs := make(chan *http.Request, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		req, hdr, _, err := protocol.DoHandshakeRequestBuffered(c)
+		if err != nil {
+			return
+		}
+		reqs <- req
+		hdr.Set(protocol.HeaderSecWebSocketProto, protocol.SelectSubprotocol(req, []string{"v1"}))
+		hdr.Set("Set-Cookie", "next=xyz; Path=/")
+		protocol.WriteHandshakeResponse(c, hdr)
+		c.Read(make([]byte, 1))
+	}()
+
+	base, _ := url.Parse("http://" + ln.Addr().String() + "/")
+	jar, _ := cookiejar.New(nil)
+	jar.SetCookies(base, []*http.Cookie{{Name: "session", Value: "abc"}})
+
+	conn, err := highlevel.Dial("ws://"+ln.Addr().String()+"/chat?room=1",
+		highlevel.WithHeader("Authorization", "Bearer t"),
+		highlevel.WithCookieJar(jar),
+		highlevel.DialWithSubprotocols("v2", "v1"))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	req := <-reqs
+	if got := req.Header.Get("Authorization"); got != "Bearer t" {
+		t.Errorf("Authorization = %q", got)
+	}
+	if c, err := req.Cookie("session"); err != nil || c.Value != "abc" {
+		t.Errorf("Expected session cookie, got %v, %v", c, err)
+	}
+	if req.URL.Path != "/chat" || req.URL.Query().Get("room") != "1" {
+		t.Errorf("Unexpected request target %s", req.URL)
+	}
+	if got := conn.Subprotocol(); got != "v1" {
+		t.Errorf("Subprotocol = %q, want v1", got)
+	}
+	var next string
+	for _, c := range jar.Cookies(base) {
+		if c.Name == "next" {
+			next = c.Value
+		}
+	}
+	if next != "xyz" {
+		t.Errorf("Expected jar to store next=xyz, got %v", jar.Cookies(base))
+	}
+}
+
+// TestDialReservedHeader tests that handshake fields cannot be overridden.
+func TestDialReservedHeader(t *testing.T) {
+	_, err := highlevel.Dial("ws://127.0.0.1:1/", highlevel.WithHeader("Sec-WebSocket-Key", "x"))
+	if !errors.Is(err, client.ErrReservedHeader) {
+		t.Errorf("Expected ErrReservedHeader, got %v", err)
+	}
+}
+
+// recordingDialer dials with a *net.Dialer and records the addresses.
+type recordingDialer struct {
+	net.Dialer
+	mu    sync.Mutex
+	addrs []string
+}
+
+func (d *recordingDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.mu.Lock()
+	d.addrs = append(d.addrs, addr)
+	d.mu.Unlock()
+	return d.Dialer.DialContext(ctx, network, addr)
+}
+
+// TestDialWithDialer tests that a custom dialer opens the connection to the
+// resolved address and that a dial func receives the host unresolved.
+func TestDialWithDialer(t *testing.T) {
+	port := echoServer(t)
+	d := &recordingDialer{Dialer: net.Dialer{
+		LocalAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		KeepAlive: time.Second,
+	}}
+	conn, err := highlevel.Dial(fmt.Sprintf("ws://localhost:%d/echo", port), highlevel.WithDialer(d))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	conn.Close()
+	d.mu.Lock()
+	if len(d.addrs) == 0 || d.addrs[len(d.addrs)-1] != fmt.Sprintf("127.0.0.1:%d", port) {
+		t.Errorf("Expected the dialer to connect to 127.0.0.1, got %q", d.addrs)
+	}
+	d.mu.Unlock()
+
+	cfg := client.DefaultConfig()
+	cfg.Addr = fmt.Sprintf("ws://echo.test:%d/echo", port)
+	cfg.Heartbeat = 0
+	var got string
+	c, err := client.NewClient(cfg, client.WithProxy(nil), client.WithDialFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		got = addr
+		var d net.Dialer
+		return d.DialContext(ctx, network, fmt.Sprintf("127.0.0.1:%d", port))
+	}))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	c.Close()
+	if got != fmt.Sprintf("echo.test:%d", port) {
+		t.Errorf("Expected the dial func to get echo.test, got %q", got)
+	}
+	if cfg.DialFunc != nil || cfg.Proxy == nil {
+		t.Error("Expected options to leave the caller's Config unchanged")
+	}
+}
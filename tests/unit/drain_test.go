@@ -0,0 +1,139 @@
+// File: tests/unit/drain_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for draining a server ahead of a rolling deploy.
+
+package unit
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// TestServerDrain tests that Drain fails readiness, stops accepting and
+// sends 1001 to idle connections before busy ones, whether they are busy
+// receiving or sending, and that the peer's answer closes them.
+func TestServerDrain(t *testing.T) {
+	port := freePort(t)
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf("127.0.0.1:%d", port)
+	cfg.ShutdownTimeout = 10 * time.Millisecond
+	cfg.DrainIdle = 200 * time.Millisecond
+	srv, err := server.NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	opened := make(chan *protocol.WSConnection, 3)
+	t.Cleanup(srv.Shutdown)
+	runServer(t, srv, api.HandlerFunc(func(data any) error {
+		if evt, ok := data.(api.OpenEvent); ok {
+			opened <- evt.Conn.(*protocol.WSConnection)
+		}
+		return nil
+	}))
+
+	push, pushBr, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	defer push.Close()
+	var pushConn *protocol.WSConnection
+	select {
+	case pushConn = <-opened:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no OpenEvent")
+	}
+	idle, idleBr, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	defer idle.Close()
+	busy, busyBr, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	defer busy.Close()
+	waitConns(t, srv, 3)
+
+	// Every 20ms the busy connection sends a frame and the server pushes one
+	// on the push connection, until told to stop.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		tick := time.NewTicker(20 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-tick.C:
+				busy.Write(maskedFrame([]byte("x")))
+				pushConn.SendFrame(&protocol.WSFrame{IsFinal: true, Opcode: protocol.OpcodeText, Payload: []byte("y"), PayloadLen: 1})
+			}
+		}
+	}()
+
+	drained := make(chan error, 1)
+	go func() { drained <- srv.Drain(context.Background()) }()
+
+	if !waitFor(t, time.Second, func() bool { return !srv.Health().Ready(context.Background()).OK() }) {
+		t.Error("Expected readiness to fail while draining")
+	}
+	if !waitFor(t, time.Second, func() bool {
+		c, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), 200*time.Millisecond)
+		if err == nil {
+			c.Close()
+		}
+		return err != nil
+	}) {
+		t.Error("Expected the listener to be closed while draining")
+	}
+
+	// answerClose reads up to the close frame and answers it.
+	answerClose := func(name string, conn net.Conn, br *bufio.Reader) {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for {
+			frame, err := protocol.DecodeFrame(br)
+			if err != nil {
+				t.Fatalf("%s: expected close frame, got %v", name, err)
+			}
+			if frame.Opcode != protocol.OpcodeClose {
+				continue
+			}
+			if code := binary.BigEndian.Uint16(frame.Payload); code != protocol.CloseGoingAway {
+				t.Errorf("%s: expected close code 1001, got %d", name, code)
+			}
+			conn.Write([]byte{0x88, 0x82, 0, 0, 0, 0, 0x03, 0xe9})
+			return
+		}
+	}
+	answerClose("idle", idle, idleBr)
+
+	stats := srv.GetControl().Stats
+	if stats()["debug."+server.ProbeDraining] != true {
+		t.Errorf("Expected drain.active, got %v", stats()["debug."+server.ProbeDraining])
+	}
+	if !waitFor(t, time.Second, func() bool { return stats()["debug."+server.ProbeDrainRemaining] == int64(2) }) {
+		t.Fatalf("Expected the busy connections to remain, got %v", stats()["debug."+server.ProbeDrainRemaining])
+	}
+	select {
+	case err := <-drained:
+		t.Fatalf("Drain returned while connections were busy: %v", err)
+	default:
+	}
+
+	stop <- struct{}{}
+	answerClose("busy", busy, busyBr)
+	answerClose("push", push, pushBr)
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Errorf("Drain: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Drain did not return once the connections closed")
+	}
+}
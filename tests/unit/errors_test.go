@@ -0,0 +1,107 @@
+// File: tests/unit/errors_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the error taxonomy of the api package: errors returned by
+// the facades match its values with errors.Is and its types with errors.As.
+
+package unit
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/highlevel"
+	"github.com/momentics/hioload-ws/internal/transport"
+	"github.com/momentics/hioload-ws/pool"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// TestErrorValues tests that the package errors wrap the api values.
+func TestErrorValues(t *testing.T) {
+	for _, c := range []struct {
+		err, want error
+	}{
+		{protocol.ErrFrameTooLarge, api.ErrMessageTooBig},
+		{highlevel.ErrReadLimit, api.ErrMessageTooBig},
+		{protocol.ErrOutboxFull, api.ErrWriteQueueFull},
+		{highlevel.ErrWriteQueueFull, api.ErrWriteQueueFull},
+		{transport.ErrListenerClosed, api.ErrListenerClosed},
+	} {
+		if !errors.Is(c.err, c.want) {
+			t.Errorf("%v does not match %v", c.err, c.want)
+		}
+	}
+}
+
+// TestErrListenerClosed tests that an Accept blocked when the listener is
+// closed fails with api.ErrListenerClosed, whatever the OS reports.
+func TestErrListenerClosed(t *testing.T) {
+	l, err := transport.NewWebSocketListener("127.0.0.1:0", pool.NewBufferPoolManager(0).GetPool(1024, 0), 4)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	time.AfterFunc(50*time.Millisecond, func() { l.Close() })
+	if _, err := l.Accept(); !errors.Is(err, api.ErrListenerClosed) {
+		t.Errorf("Accept after Close: got %v, want ErrListenerClosed", err)
+	}
+}
+
+// TestErrHandshakeFailed tests that server and client handshake failures
+// match api.ErrHandshakeFailed and keep their cause.
+func TestErrHandshakeFailed(t *testing.T) {
+	_, _, _, err := protocol.DoHandshakeRequestBuffered(strings.NewReader("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+	if !errors.Is(err, api.ErrHandshakeFailed) || !errors.Is(err, protocol.ErrInvalidUpgradeHeaders) {
+		t.Errorf("server handshake: got %v", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		http.ReadRequest(bufio.NewReader(c))
+		protocol.WriteHandshakeRejection(c, &protocol.HandshakeRejection{Status: http.StatusForbidden, Reason: "no"})
+	}()
+	if _, err := highlevel.Dial("ws://" + ln.Addr().String() + "/ws"); !errors.Is(err, api.ErrHandshakeFailed) {
+		t.Errorf("rejected dial: got %v, want ErrHandshakeFailed", err)
+	}
+}
+
+// TestCloseError tests that reading from a connection the peer closed
+// reports the peer's close code and reason.
+func TestCloseError(t *testing.T) {
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf("127.0.0.1:%d", port))
+	srv.HandleFunc("/ws", func(c *highlevel.Conn) {
+		c.CloseWithCode(4001, "done")
+	})
+	t.Cleanup(func() { srv.Shutdown(context.Background()) })
+	serve(t, srv)
+
+	conn, err := highlevel.Dial(fmt.Sprintf("ws://127.0.0.1:%d/ws", port))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	conn.WriteString("hi") // handlers start on the first message
+	_, _, err = conn.ReadMessage()
+	var ce *api.CloseError
+	if !errors.As(err, &ce) || ce.Code != 4001 || ce.Reason != "done" {
+		t.Errorf("ReadMessage: got %v, want close 4001 done", err)
+	}
+}
@@ -0,0 +1,81 @@
+// File: tests/unit/events_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the handshake metadata and counters carried by lifecycle
+// events.
+
+package unit
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// TestLifecycleEventMetadata tests that OpenEvent describes the upgrade and
+// CloseEvent the close handshake and the traffic in between.
+func TestLifecycleEventMetadata(t *testing.T) {
+	port := freePort(t)
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf("127.0.0.1:%d", port)
+	cfg.ShutdownTimeout = 10 * time.Millisecond
+	srv, err := server.NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	opened := make(chan api.OpenEvent, 1)
+	closed := make(chan api.CloseEvent, 1)
+	t.Cleanup(srv.Shutdown)
+	runServer(t, srv, api.HandlerFunc(func(data any) error {
+		switch evt := data.(type) {
+		case api.OpenEvent:
+			evt.Conn.(*protocol.WSConnection).SendFrame(&protocol.WSFrame{
+				IsFinal: true, Opcode: protocol.OpcodeText, Payload: []byte("hi"), PayloadLen: 2,
+			})
+			opened <- evt
+		case api.CloseEvent:
+			closed <- evt
+		}
+		return nil
+	}))
+
+	conn, br, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	defer conn.Close()
+	select {
+	case evt := <-opened:
+		if evt.Path != "/resume" || evt.RemoteAddr == nil || evt.TLS != nil || evt.Subprotocol != "" || evt.Extensions != "" {
+			t.Errorf("OpenEvent: path %q addr %v tls %v proto %q ext %q",
+				evt.Path, evt.RemoteAddr, evt.TLS, evt.Subprotocol, evt.Extensions)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no OpenEvent")
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := br.Discard(4); err != nil { // the greeting
+		t.Fatalf("greeting: %v", err)
+	}
+	conn.Write(maskedFrame([]byte("hello")))
+	conn.Write([]byte{0x88, 0x80 | 5, 0, 0, 0, 0, 0x03, 0xE8, 'b', 'y', 'e'})
+	readClose(t, conn, br)
+	conn.Close()
+
+	select {
+	case evt := <-closed:
+		if evt.Code != 1000 || evt.Reason != "bye" {
+			t.Errorf("CloseEvent: code %d reason %q", evt.Code, evt.Reason)
+		}
+		if evt.FramesIn < 1 || evt.BytesIn < 5 || evt.FramesOut < 1 {
+			t.Errorf("CloseEvent counters: in %d frames %d bytes, out %d frames",
+				evt.FramesIn, evt.BytesIn, evt.FramesOut)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no CloseEvent")
+	}
+}
@@ -0,0 +1,119 @@
+// File: tests/unit/fair_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for fair scheduling of events across flows.
+
+package unit
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/internal/concurrency"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+)
+
+// flowEvent is an event of flow key costing size.
+type flowEvent struct {
+	key  string
+	seq  int
+	size int
+}
+
+func (e flowEvent) Data() any     { return e }
+func (e flowEvent) FlowKey() any  { return e.key }
+func (e flowEvent) FlowCost() int { return e.size }
+
+// TestEventLoop_FairScheduling tests that a flooding flow does not starve a
+// light one and that each flow keeps its order.
+func TestEventLoop_FairScheduling(t *testing.T) {
+	el := concurrency.NewEventLoop(8, 512)
+	el.SetQuantum(4096)
+
+	// The flood is queued before the light flow's events.
+	for i := 0; i < 400; i++ {
+		el.Push(flowEvent{key: "flood", seq: i, size: 1024})
+	}
+	for i := 0; i < 4; i++ {
+		el.Push(flowEvent{key: "light", seq: i, size: 1024})
+	}
+
+	var mu sync.Mutex
+	var order []flowEvent
+	done := make(chan struct{})
+	el.RegisterHandler(&testEventHandler{handleFunc: func(ev concurrency.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, ev.(flowEvent))
+		if len(order) == 404 {
+			close(done)
+		}
+	}})
+	go el.Run()
+	defer el.Stop()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for events")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	next := map[string]int{}
+	lastLight := -1
+	for i, ev := range order {
+		if ev.seq != next[ev.key] {
+			t.Fatalf("Flow %s out of order at %d: got seq %d, want %d", ev.key, i, ev.seq, next[ev.key])
+		}
+		next[ev.key]++
+		if ev.key == "light" {
+			lastLight = i
+		}
+	}
+	// With a 4 KiB quantum the flood dispatches 4 events per turn, so the
+	// light flow finishes within its first turn or two.
+	if lastLight < 0 || lastLight > 16 {
+		t.Errorf("Expected the light flow dispatched early, last at %d of %d", lastLight, len(order))
+	}
+
+	stats := el.FairStats()
+	if stats.Deferred == 0 {
+		t.Error("Expected deferred turns while both flows were queued")
+	}
+	if stats.QueueWait.Count() != 404 {
+		t.Errorf("Expected 404 queue wait samples, got %d", stats.QueueWait.Count())
+	}
+	if stats.Flows != 0 {
+		t.Errorf("Expected no active flows once drained, got %d", stats.Flows)
+	}
+}
+
+// TestFairSchedulingProbes tests that the server exposes the scheduler's
+// starvation metrics.
+func TestFairSchedulingProbes(t *testing.T) {
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = "127.0.0.1:0"
+	if err := server.ApplyConfig(cfg, map[string]any{server.CfgFairQuantum: 8192}); err != nil {
+		t.Fatalf("ApplyConfig failed: %v", err)
+	}
+	if cfg.FairQuantum != 8192 {
+		t.Errorf("Expected fair_quantum applied, got %d", cfg.FairQuantum)
+	}
+	srv, err := server.NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+	runServer(t, srv, api.HandlerFunc(func(any) error { return nil }))
+
+	stats := srv.GetControl().Stats()
+	for _, key := range []string{"debug." + server.ProbeFairFlows, "debug." + server.ProbeFairDeferred, "debug." + server.ProbeQueueLatency + ".count"} {
+		if _, ok := stats[key]; !ok {
+			t.Errorf("Expected probe %s in stats", key)
+		}
+	}
+}
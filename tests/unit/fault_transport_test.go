@@ -0,0 +1,89 @@
+// File: tests/unit/fault_transport_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the fault injection of the fake transport.
+
+package unit
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/pool"
+	"github.com/momentics/hioload-ws/protocol"
+	"github.com/momentics/hioload-ws/tests/fake"
+)
+
+// TestFaultyTransportWrites tests latency, short writes and reordering of
+// sent batches.
+func TestFaultyTransportWrites(t *testing.T) {
+	ft := fake.NewFaultyTransport(fake.Faults{Latency: 20 * time.Millisecond, MaxWrite: 5})
+	start := time.Now()
+	if err := ft.Send([][]byte{[]byte("abc"), []byte("defg")}); !errors.Is(err, io.ErrShortWrite) {
+		t.Fatalf("Expected io.ErrShortWrite, got %v", err)
+	}
+	if time.Since(start) < 20*time.Millisecond {
+		t.Fatal("Expected the latency applied")
+	}
+	if got := string(ft.Sent()); got != "abcde" {
+		t.Fatalf("Expected the prefix recorded, got %q", got)
+	}
+
+	batch := [][]byte{[]byte("1"), []byte("2"), []byte("3"), []byte("4"), []byte("5")}
+	var orders []string
+	for i := 0; i < 2; i++ {
+		ft := fake.NewFaultyTransport(fake.Faults{Reorder: true, Seed: 7})
+		for j := 0; j < 3; j++ {
+			ft.Send(batch)
+		}
+		orders = append(orders, string(ft.Sent()))
+	}
+	if orders[0] != orders[1] {
+		t.Fatalf("Expected the same order for the same seed, got %q and %q", orders[0], orders[1])
+	}
+	if orders[0] == "123451234512345" {
+		t.Fatal("Expected the batches reordered")
+	}
+}
+
+// TestFaultyTransportConnection tests a connection reading through short
+// reads with jitter and seeing an injected error.
+func TestFaultyTransportConnection(t *testing.T) {
+	boom := errors.New("boom")
+	ft := fake.NewFaultyTransport(fake.Faults{
+		Jitter:  2 * time.Millisecond,
+		MaxRead: 3,
+		Errors:  []fake.InjectedError{{Op: fake.OpRecv, N: 6, Err: boom}},
+	})
+	ft.RecvData = [][]byte{maskedFrame([]byte("hello")), maskedFrame([]byte("world"))}
+	conn := protocol.NewWSConnection(ft, pool.NewBufferPoolManager(0).GetPool(1024, 0), 4)
+
+	var got []string
+	var err error
+	for len(got) < 2 && err == nil {
+		var msgs []protocol.Message
+		msgs, err = conn.RecvMessages()
+		for _, m := range msgs {
+			got = append(got, string(m.Buf.Bytes()))
+			m.Buf.Release()
+		}
+	}
+	// 22 bytes in reads of 3: the sixth read fails, midway through "world".
+	if !errors.Is(err, boom) || len(got) != 1 || got[0] != "hello" {
+		t.Fatalf("Expected hello then the injected error, got %q, %v", got, err)
+	}
+	if n := ft.Calls(fake.OpRecv); n != 6 {
+		t.Fatalf("Expected 6 reads, got %d", n)
+	}
+
+	ft = fake.NewFaultyTransport(fake.Faults{Errors: []fake.InjectedError{{Op: fake.OpClose, N: 1, Err: boom}}})
+	if err := ft.Close(); !errors.Is(err, boom) || ft.Closed() {
+		t.Fatalf("Expected the first close to fail, got %v", err)
+	}
+	if err := ft.Close(); err != nil || !ft.Closed() {
+		t.Fatalf("Expected the second close to succeed, got %v", err)
+	}
+}
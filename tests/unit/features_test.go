@@ -0,0 +1,168 @@
+// File: tests/unit/features_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for runtime feature toggles: compression, keepalive, strict
+// validation and the rate-limit switch.
+
+package unit
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/control"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+func TestFeatures_WithConfig(t *testing.T) {
+	base := control.Features{RateLimit: true, KeepAlive: time.Second}
+	f := base.WithConfig(map[string]any{
+		control.CfgFeatureCompression: true,
+		control.CfgFeatureStrict:      "true",
+		control.CfgFeatureRateLimit:   false,
+		control.CfgFeatureKeepAlive:   "250ms",
+	})
+	want := control.Features{Compression: true, StrictValidation: true, KeepAlive: 250 * time.Millisecond}
+	if f != want {
+		t.Fatalf("Expected %+v, got %+v", want, f)
+	}
+	if f = f.WithConfig(map[string]any{control.CfgFeatureKeepAlive: int64(2)}); f.KeepAlive != 2*time.Second {
+		t.Errorf("Expected numeric keepalive in seconds, got %v", f.KeepAlive)
+	}
+	if f = f.WithConfig(map[string]any{control.CfgFeatureKeepAlive: false}); f.KeepAlive != 0 {
+		t.Errorf("Expected keepalive off, got %v", f.KeepAlive)
+	}
+	if f = f.WithConfig(map[string]any{control.CfgFeatureCompression: "bogus"}); !f.Compression {
+		t.Errorf("Expected invalid value to keep the setting")
+	}
+}
+
+func TestDeflate_RoundTripAndNegotiation(t *testing.T) {
+	msg := bytes.Repeat([]byte("hioload "), 64)
+	c, err := protocol.CompressPayload(msg)
+	if err != nil || len(c) >= len(msg) {
+		t.Fatalf("Expected smaller compressed payload, got %d bytes (err=%v)", len(c), err)
+	}
+	d, err := protocol.DecompressPayload(c)
+	if err != nil || !bytes.Equal(d, msg) {
+		t.Fatalf("Round trip mismatch (err=%v)", err)
+	}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	if protocol.NegotiateDeflate(req) != "" {
+		t.Errorf("Expected no extension without an offer")
+	}
+	req.Header.Set(protocol.HeaderSecWebSocketExt, "permessage-deflate; server_max_window_bits=10, permessage-deflate; client_max_window_bits")
+	if ext := protocol.NegotiateDeflate(req); !strings.HasPrefix(ext, "permessage-deflate") {
+		t.Errorf("Expected second offer accepted, got %q", ext)
+	}
+	req.Header.Set(protocol.HeaderSecWebSocketExt, "permessage-deflate; server_max_window_bits=10")
+	if ext := protocol.NegotiateDeflate(req); ext != "" {
+		t.Errorf("Expected restricted window declined, got %q", ext)
+	}
+}
+
+// TestServerFeatureToggles tests that toggles set through Control.SetConfig
+// apply to the next connection.
+func TestServerFeatureToggles(t *testing.T) {
+	port := freePort(t)
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf(":%d", port)
+	cfg.ShutdownTimeout = 10 * time.Millisecond
+	srv, err := server.NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+	runServer(t, srv, api.HandlerFunc(func(data any) error {
+		evt, ok := data.(interface {
+			WSConnection() *protocol.WSConnection
+			GetBuffer() api.Buffer
+		})
+		if !ok {
+			return nil
+		}
+		// Echo, compressed again on the way out when negotiated.
+		payload := append([]byte(nil), evt.GetBuffer().Bytes()...)
+		evt.GetBuffer().Release()
+		return evt.WSConnection().SendFrame(&protocol.WSFrame{
+			IsFinal: true, Opcode: protocol.OpcodeText, Payload: payload, PayloadLen: int64(len(payload)),
+		})
+	}))
+
+	offer := http.Header{"Sec-Websocket-Extensions": {"permessage-deflate"}}
+	conn, _, resp := upgrade(t, port, "/", offer, http.StatusSwitchingProtocols)
+	conn.Close()
+	if ext := resp.Header.Get(protocol.HeaderSecWebSocketExt); ext != "" {
+		t.Fatalf("Expected compression off by default, got %q", ext)
+	}
+
+	if err := srv.GetControl().SetConfig(map[string]any{
+		control.CfgFeatureCompression: true,
+		control.CfgFeatureStrict:      true,
+		control.CfgFeatureKeepAlive:   "50ms",
+	}); err != nil {
+		t.Fatalf("SetConfig: %v", err)
+	}
+	if f := srv.Features(); !f.Compression || !f.StrictValidation || f.KeepAlive != 50*time.Millisecond || !f.RateLimit {
+		t.Fatalf("Unexpected features %+v", f)
+	}
+
+	conn, br, resp := upgrade(t, port, "/", offer, http.StatusSwitchingProtocols)
+	defer conn.Close()
+	if ext := resp.Header.Get(protocol.HeaderSecWebSocketExt); !strings.HasPrefix(ext, "permessage-deflate") {
+		t.Fatalf("Expected permessage-deflate accepted, got %q", ext)
+	}
+	msg := bytes.Repeat([]byte("compress me "), 32)
+	compressed, _ := protocol.CompressPayload(msg)
+	data, _ := protocol.EncodeFrameToBytesWithMask(&protocol.WSFrame{
+		IsFinal: true, Rsv: protocol.Rsv1Bit, Opcode: protocol.OpcodeText, Payload: compressed, PayloadLen: int64(len(compressed)),
+	}, true)
+	conn.Write(data)
+
+	var gotEcho, gotPing bool
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for !gotEcho || !gotPing {
+		frame, err := protocol.DecodeFrame(br)
+		if err != nil {
+			t.Fatalf("Read failed (echo=%v ping=%v): %v", gotEcho, gotPing, err)
+		}
+		switch frame.Opcode {
+		case protocol.OpcodePing:
+			gotPing = true
+		case protocol.OpcodeText:
+			if frame.Rsv&protocol.Rsv1Bit == 0 {
+				t.Fatalf("Expected compressed echo")
+			}
+			if echo, err := protocol.DecompressPayload(frame.Payload); err != nil || !bytes.Equal(echo, msg) {
+				t.Fatalf("Echo mismatch (err=%v)", err)
+			}
+			gotEcho = true
+		}
+	}
+
+	// Strict validation: an unmasked client frame is a protocol error.
+	data, _ = protocol.EncodeFrameToBytesWithMask(&protocol.WSFrame{
+		IsFinal: true, Opcode: protocol.OpcodeText, Payload: []byte("x"), PayloadLen: 1,
+	}, false)
+	conn.Write(data)
+	for {
+		frame, err := protocol.DecodeFrame(br)
+		if err != nil {
+			t.Fatalf("Expected close frame: %v", err)
+		}
+		if frame.Opcode == protocol.OpcodeClose {
+			if code := uint16(frame.Payload[0])<<8 | uint16(frame.Payload[1]); code != protocol.CloseProtocolError {
+				t.Errorf("Expected close code 1002, got %d", code)
+			}
+			break
+		}
+	}
+}
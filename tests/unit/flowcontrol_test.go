@@ -0,0 +1,112 @@
+// File: tests/unit/flowcontrol_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for pausing and resuming reads per connection.
+
+package unit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/highlevel"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// maskedFrame returns a client text frame with a zero mask key.
+func maskedFrame(payload []byte) []byte {
+	var frame []byte
+	if len(payload) < 126 {
+		frame = []byte{0x81, 0x80 | byte(len(payload))}
+	} else {
+		frame = []byte{0x81, 0x80 | 126, byte(len(payload) >> 8), byte(len(payload))}
+	}
+	frame = append(frame, 0, 0, 0, 0)
+	return append(frame, payload...)
+}
+
+// TestPauseReading tests that a paused connection reads nothing, so a
+// flooding peer is held back by TCP, and that reading resumes.
+func TestPauseReading(t *testing.T) {
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf("127.0.0.1:%d", port))
+	paused := make(chan *highlevel.Conn, 1)
+	srv.HandleFunc("/resume", func(c *highlevel.Conn) {
+		for {
+			mt, msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			switch {
+			case string(msg) == "pause":
+				c.PauseReading()
+				paused <- c
+			case len(msg) < 100:
+				c.WriteMessage(mt, msg)
+			}
+		}
+	})
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	conn, br, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	defer conn.Close()
+	conn.Write(maskedFrame([]byte("pause")))
+	var sc *highlevel.Conn
+	select {
+	case sc = <-paused:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timed out waiting for the handler to pause")
+	}
+	if !sc.ReadingPaused() {
+		t.Error("Expected ReadingPaused after PauseReading")
+	}
+
+	// The read in flight when reading paused still completes.
+	conn.Write(maskedFrame([]byte("inflight")))
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if reply, err := protocol.DecodeFrame(br); err != nil || string(reply.Payload) != "inflight" {
+		t.Fatalf("Expected the in-flight message echoed, got %v", err)
+	}
+	conn.Write(maskedFrame([]byte("held")))
+	conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	if _, err := protocol.DecodeFrame(br); !isTimeout(err) {
+		t.Fatalf("Expected no echo while paused, got %v", err)
+	}
+
+	sc.ResumeReading()
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	reply, err := protocol.DecodeFrame(br)
+	if err != nil || string(reply.Payload) != "held" {
+		t.Fatalf("Expected the held message echoed after resume, got %v", err)
+	}
+
+	// Paused again, a flood stalls once the socket buffers are full.
+	conn.Write(maskedFrame([]byte("pause")))
+	<-paused
+	chunk := maskedFrame(make([]byte, 60000))
+	const limit = 256 << 20
+	written := 0
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	for written < limit {
+		n, err := conn.Write(chunk)
+		written += n
+		if err != nil {
+			if !isTimeout(err) {
+				t.Fatalf("Unexpected write error: %v", err)
+			}
+			break
+		}
+	}
+	if written >= limit {
+		t.Errorf("Expected the paused connection to stop the sender, wrote %d bytes", written)
+	}
+	// Four small messages plus at most the read in flight at the pause.
+	if got := sc.GetUnderlyingWSConnection().GetStats()["frames_received"]; got > 8 {
+		t.Errorf("Expected the server to stop reading, it decoded %d frames of %d sent", got, 3+written/len(chunk))
+	}
+}
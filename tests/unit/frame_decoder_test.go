@@ -0,0 +1,267 @@
+// File: tests/unit/frame_decoder_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the incremental frame decoder.
+
+package unit
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/highlevel"
+	"github.com/momentics/hioload-ws/lowlevel/client"
+	"github.com/momentics/hioload-ws/pool"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// TestFrameDecoder_Segments tests decoding a stream split at arbitrary
+// points, including inside headers and mask keys.
+func TestFrameDecoder_Segments(t *testing.T) {
+	sizes := []int{0, 5, 125, 126, 200, 70000}
+	var stream []byte
+	var want [][]byte
+	for i, size := range sizes {
+		payload := bytes.Repeat([]byte{byte('a' + i)}, size)
+		frame := &protocol.WSFrame{IsFinal: true, Opcode: protocol.OpcodeBinary, Payload: append([]byte(nil), payload...), PayloadLen: int64(size)}
+		data, err := protocol.EncodeFrameToBytesWithMask(frame, true)
+		if err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+		stream = append(stream, data...)
+		want = append(want, payload)
+	}
+
+	for _, chunk := range []int{1, 2, 3, 7, 13, 1000, len(stream)} {
+		d := protocol.NewFrameDecoder(pool.NewBufferPoolManager(0).GetPool(4096, 0))
+		var got [][]byte
+		for off := 0; off < len(stream); off += chunk {
+			end := min(off+chunk, len(stream))
+			seg := append([]byte(nil), stream[off:end]...) // transports hand over owned segments
+			err := d.Decode(seg, func(f *protocol.WSFrame) error {
+				if int64(len(f.Payload)) != f.PayloadLen || len(f.Buf.Data) != len(f.Payload) {
+					t.Errorf("Chunk %d: payload %d bytes, buffer %d, header %d", chunk, len(f.Payload), len(f.Buf.Data), f.PayloadLen)
+				}
+				got = append(got, append([]byte(nil), f.Payload...))
+				f.Buf.Release()
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Chunk %d: decode failed: %v", chunk, err)
+			}
+		}
+		if d.Buffered() != 0 {
+			t.Errorf("Chunk %d: expected nothing buffered at a frame boundary, got %d", chunk, d.Buffered())
+		}
+		if len(got) != len(want) {
+			t.Fatalf("Chunk %d: expected %d frames, got %d", chunk, len(want), len(got))
+		}
+		for i := range want {
+			if !bytes.Equal(got[i], want[i]) {
+				t.Errorf("Chunk %d: frame %d payload mismatch", chunk, i)
+			}
+		}
+	}
+}
+
+// TestFrameDecoder_ZeroCopy tests that a frame held whole in one segment is
+// unmasked in place, and a split one is gathered into a pooled buffer.
+func TestFrameDecoder_ZeroCopy(t *testing.T) {
+	frame := &protocol.WSFrame{IsFinal: true, Opcode: protocol.OpcodeText, Payload: []byte("hello world"), PayloadLen: 11}
+	data, _ := protocol.EncodeFrameToBytesWithMask(frame, true)
+
+	d := protocol.NewFrameDecoder(pool.NewBufferPoolManager(0).GetPool(4096, 0))
+	var got *protocol.WSFrame
+	d.Decode(data, func(f *protocol.WSFrame) error { got = f; return nil })
+	if got == nil || string(got.Payload) != "hello world" {
+		t.Fatalf("Expected the frame decoded, got %+v", got)
+	}
+	if &got.Payload[0] != &data[len(data)-11] || got.Buf.Pool != nil {
+		t.Error("Expected the payload to reference the segment")
+	}
+	if cap(got.Payload) != len(got.Payload) {
+		t.Error("Expected the payload capped so appends cannot overwrite the segment")
+	}
+
+	// The first decode unmasked data in place.
+	data, _ = protocol.EncodeFrameToBytesWithMask(frame, true)
+	got = nil
+	d.Decode(data[:9], func(f *protocol.WSFrame) error { got = f; return nil })
+	if d.Buffered() != 3 {
+		t.Errorf("Expected 3 payload bytes buffered, got %d", d.Buffered())
+	}
+	d.Decode(data[9:], func(f *protocol.WSFrame) error { got = f; return nil })
+	if got == nil || string(got.Payload) != "hello world" || got.Buf.Pool == nil {
+		t.Fatalf("Expected the split frame gathered into a pooled buffer, got %+v", got)
+	}
+	got.Buf.Release()
+
+	if err := d.Decode([]byte{0x82, 0xFF, 0, 0, 0, 0, 1, 0, 0, 0}, func(*protocol.WSFrame) error { return nil }); err == nil {
+		t.Error("Expected an error for a frame above MaxFramePayload")
+	}
+}
+
+// TestFrameDecoder_SegmentOwner tests that a pooled segment goes back to its
+// pool once the frames decoded in place from it are all released, and at
+// once when it only fed a gathered frame.
+func TestFrameDecoder_SegmentOwner(t *testing.T) {
+	var seg []byte
+	for _, s := range []string{"one", "two", "three"} {
+		data, _ := protocol.EncodeFrameToBytesWithMask(&protocol.WSFrame{IsFinal: true, Opcode: protocol.OpcodeText, Payload: []byte(s), PayloadLen: int64(len(s))}, true)
+		seg = append(seg, data...)
+	}
+	split, _ := protocol.EncodeFrameToBytesWithMask(&protocol.WSFrame{IsFinal: true, Opcode: protocol.OpcodeText, Payload: []byte("split"), PayloadLen: 5}, true)
+	seg = append(seg, split[:8]...)
+
+	rc := &releaseCounter{}
+	d := protocol.NewFrameDecoder(nil)
+	var frames []*protocol.WSFrame
+	collect := func(f *protocol.WSFrame) error { frames = append(frames, f); return nil }
+	if err := d.DecodeBuffer(api.Buffer{Data: seg, Pool: rc}, collect); err != nil || len(frames) != 3 {
+		t.Fatalf("Expected 3 frames, got %d, %v", len(frames), err)
+	}
+	for i, f := range frames {
+		if got := rc.puts.Load(); got != 0 {
+			t.Fatalf("Segment released with %d frames still held", len(frames)-i)
+		}
+		f.Buf.Release()
+	}
+	if got := rc.puts.Load(); got != 1 {
+		t.Fatalf("Expected the segment released once after the last frame, got %d", got)
+	}
+
+	if err := d.DecodeBuffer(api.Buffer{Data: split[8:], Pool: rc}, collect); err != nil || len(frames) != 4 {
+		t.Fatalf("Expected the split frame, got %d frames, %v", len(frames), err)
+	}
+	if got := rc.puts.Load(); got != 2 {
+		t.Errorf("Expected a segment holding no frame released at once, got %d releases", got)
+	}
+	if string(frames[3].Payload) != "split" {
+		t.Errorf("Expected %q gathered, got %q", "split", frames[3].Payload)
+	}
+}
+
+// TestFrameDecoder_MemoryLock tests that segments read into pinned slabs are
+// unlocked and unmapped again once the frames decoded from them are released.
+func TestFrameDecoder_MemoryLock(t *testing.T) {
+	// A one-byte budget caches nothing, so every returned slab is released.
+	bp := pool.NewBufferPoolManager(1, pool.WithMemoryLock(true), pool.WithNodeBudget(1)).GetPool(4096, 0)
+	before := pool.LockedSlabs()
+	frame, _ := protocol.EncodeFrameToBytesWithMask(&protocol.WSFrame{IsFinal: true, Opcode: protocol.OpcodeBinary, Payload: make([]byte, 100), PayloadLen: 100}, true)
+
+	const n = 64
+	d := protocol.NewFrameDecoder(bp)
+	var frames []*protocol.WSFrame
+	for i := 0; i < n; i++ {
+		seg := bp.Get(4096, 0)
+		m := copy(seg.Bytes(), frame)
+		m += copy(seg.Bytes()[m:], frame)
+		err := d.DecodeBuffer(seg.Slice(0, m), func(f *protocol.WSFrame) error {
+			frames = append(frames, f)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+	}
+	if len(frames) != 2*n {
+		t.Fatalf("Expected %d frames, got %d", 2*n, len(frames))
+	}
+	if pool.LockedSlabs()-before < n {
+		t.Skip("Memory locking unavailable (RLIMIT_MEMLOCK)")
+	}
+	for _, f := range frames {
+		f.Buf.Release()
+	}
+	if got := pool.LockedSlabs() - before; got != 0 {
+		t.Errorf("Expected every pinned slab released, %d left", got)
+	}
+}
+
+// TestFrameDecoder_ClientReads tests that frames decoded in place stay
+// intact while the client transport reads the next ones: each read must land
+// in a segment of its own.
+func TestFrameDecoder_ClientReads(t *testing.T) {
+	local, peer := net.Pipe()
+	bp := pool.NewBufferPoolManager(0).GetPool(4096, 0)
+	conn := protocol.NewWSConnection(client.NewTransport(local, bp, 4096), bp, 16)
+	defer conn.Close()
+	conn.Start()
+
+	want := []string{"AAAA", "BBBB", "CCCC"}
+	for _, s := range want {
+		data, _ := protocol.EncodeFrameToBytes(&protocol.WSFrame{IsFinal: true, Opcode: protocol.OpcodeText, Payload: []byte(s), PayloadLen: 4})
+		if _, err := peer.Write(data); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	for _, s := range want {
+		msgs, err := conn.RecvMessages()
+		if err != nil || len(msgs) != 1 {
+			t.Fatalf("RecvMessages: %d messages, %v", len(msgs), err)
+		}
+		if got := string(msgs[0].Buf.Bytes()); got != s {
+			t.Errorf("Expected %q, got %q", s, got)
+		}
+	}
+}
+
+// TestFrameDecoder_Limit tests that a frame above the limit is refused from
+// its header and that a slow large frame holds only what has arrived.
+func TestFrameDecoder_Limit(t *testing.T) {
+	d := protocol.NewFrameDecoder(nil)
+	d.SetLimit(1024)
+	header := []byte{0x82, 0x80 | 126, 0x08, 0x00, 0, 0, 0, 0} // 2048 bytes, masked
+	if err := d.Decode(header, func(*protocol.WSFrame) error { return nil }); !errors.Is(err, protocol.ErrFrameTooLarge) {
+		t.Fatalf("Expected ErrFrameTooLarge, got %v", err)
+	}
+
+	d = protocol.NewFrameDecoder(nil)
+	header = []byte{0x82, 0x80 | 127, 0, 0, 0, 0, 0, 0x0F, 0, 0, 0, 0, 0, 0} // 960 KiB
+	d.Decode(header, func(*protocol.WSFrame) error { return nil })
+	d.Decode(make([]byte, 1000), func(*protocol.WSFrame) error { return nil })
+	if d.Buffered() != 1000 {
+		t.Errorf("Expected 1000 bytes buffered, got %d", d.Buffered())
+	}
+	d.Reset()
+	if d.Buffered() != 0 {
+		t.Errorf("Expected nothing buffered after Reset, got %d", d.Buffered())
+	}
+}
+
+// TestReadBufferLimit tests that the server closes a connection announcing
+// a frame above Config.ReadBufferLimit with 1009.
+func TestReadBufferLimit(t *testing.T) {
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf("127.0.0.1:%d", port), highlevel.WithReadBufferLimit(4096))
+	srv.HandleFunc("/resume", func(c *highlevel.Conn) {
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	conn, br, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	defer conn.Close()
+	conn.Write([]byte{0x82, 0x80 | 126, 0x20, 0x00, 0, 0, 0, 0}) // 8 KiB
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	reply, err := protocol.DecodeFrame(br)
+	if err != nil {
+		t.Fatalf("Expected a close frame, got %v", err)
+	}
+	if reply.Opcode != protocol.OpcodeClose || len(reply.Payload) < 2 || binary.BigEndian.Uint16(reply.Payload) != protocol.CloseMessageTooBig {
+		t.Errorf("Expected close 1009, got opcode %d payload %v", reply.Opcode, reply.Payload)
+	}
+}
@@ -0,0 +1,180 @@
+// File: tests/unit/framedump_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for frame dumps in JSONL and pcapng and for dumps selected
+// through server control.
+
+package unit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/framedump"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+	"github.com/momentics/hioload-ws/pool"
+	"github.com/momentics/hioload-ws/protocol"
+	"github.com/momentics/hioload-ws/sim"
+)
+
+// TestFrameDumpJSONL tests that an attached writer logs the frames sent and
+// received, control frames included, with payloads cut at MaxPayload.
+func TestFrameDumpJSONL(t *testing.T) {
+	var out bytes.Buffer
+	w, err := framedump.NewWriter(&out, framedump.FormatJSONL, framedump.Options{Payloads: true, MaxPayload: 3})
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	a, b := sim.Pipe(sim.NewClock(time.Time{}))
+	conn := protocol.NewWSConnection(a, pool.DefaultManager().GetPool(4096, -1), 16)
+	w.Attach(conn)
+
+	if err := conn.WriteFrames([]*protocol.WSFrame{{IsFinal: true, Opcode: protocol.OpcodeText, Payload: []byte("hello"), PayloadLen: 5}}); err != nil {
+		t.Fatalf("WriteFrames: %v", err)
+	}
+	simFrame(t, b)
+	b.Send([][]byte{maskedFrame([]byte("hi"))})
+	if _, err := conn.RecvMessages(); err != nil {
+		t.Fatalf("RecvMessages: %v", err)
+	}
+	conn.CloseWithCode(protocol.CloseNormalClosure, "bye")
+	w.Close()
+
+	recs := jsonlRecords(t, &out)
+	if len(recs) != 3 || w.Frames() != 3 {
+		t.Fatalf("got %d records, %d frames: %+v", len(recs), w.Frames(), recs)
+	}
+	if r := recs[0]; r.Dir != "send" || r.Type != "text" || !r.Fin || r.Len != 5 || string(r.Payload) != "hel" || !r.Truncated {
+		t.Errorf("sent text: %+v", r)
+	}
+	if r := recs[1]; r.Dir != "recv" || r.Opcode != protocol.OpcodeText || !r.Masked || string(r.Payload) != "hi" || r.Truncated {
+		t.Errorf("received text: %+v", r)
+	}
+	if r := recs[2]; r.Type != "close" || r.CloseCode != protocol.CloseNormalClosure || r.CloseReason != "bye" {
+		t.Errorf("close: %+v", r)
+	}
+	if err := w.WriteFrame(nil, "send", &protocol.WSFrame{Opcode: protocol.OpcodePing}); err != nil || w.Frames() != 3 {
+		t.Errorf("write after Close: %v, %d frames", err, w.Frames())
+	}
+}
+
+// TestFrameDumpPcapng tests the pcapng blocks and the packet layout of
+// framedump.LinkType.
+func TestFrameDumpPcapng(t *testing.T) {
+	var out bytes.Buffer
+	w, err := framedump.NewWriter(&out, framedump.FormatPcapng, framedump.Options{})
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	w.WriteFrame(nil, "recv", &protocol.WSFrame{IsFinal: true, Rsv: protocol.Rsv1Bit, Opcode: protocol.OpcodeBinary, Masked: true, Payload: []byte("zz"), PayloadLen: 2})
+	w.WriteFrame(nil, "send", &protocol.WSFrame{IsFinal: true, Opcode: protocol.OpcodePong})
+
+	le := binary.LittleEndian
+	var blocks [][]byte
+	for data := out.Bytes(); len(data) > 0; {
+		n := le.Uint32(data[4:])
+		if n%4 != 0 || int(n) > len(data) || le.Uint32(data[n-4:]) != n {
+			t.Fatalf("bad block length %d", n)
+		}
+		blocks, data = append(blocks, data[:n]), data[n:]
+	}
+	if len(blocks) != 4 || le.Uint32(blocks[0]) != 0x0A0D0D0A || le.Uint32(blocks[1]) != 1 {
+		t.Fatalf("expected a section, an interface and two packets, got %d blocks", len(blocks))
+	}
+	if lt := le.Uint16(blocks[1][8:]); lt != framedump.LinkType {
+		t.Errorf("link type %d", lt)
+	}
+	epb := blocks[2]
+	if le.Uint32(epb) != 6 || le.Uint32(epb[20:]) != 12 || le.Uint32(epb[24:]) != 14 {
+		t.Fatalf("packet: type %d, captured %d, original %d", le.Uint32(epb), le.Uint32(epb[20:]), le.Uint32(epb[24:]))
+	}
+	pkt := epb[28:40]
+	if pkt[0] != 1 || pkt[1] != 0 || pkt[2] != 0x80|protocol.Rsv1Bit|protocol.OpcodeBinary || pkt[3] != 0x01 ||
+		binary.BigEndian.Uint64(pkt[4:]) != 2 {
+		t.Errorf("packet header % x", pkt)
+	}
+	if pkt := blocks[3][28:40]; pkt[1] != 1 || pkt[2] != 0x80|protocol.OpcodePong {
+		t.Errorf("pong header % x", pkt)
+	}
+}
+
+// TestServerFrameDump tests that a server dumps the connections of the
+// routes selected through control to a file each, until they close.
+func TestServerFrameDump(t *testing.T) {
+	clk := sim.NewClock(time.Time{})
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf("127.0.0.1:%d", freePort(t))
+	cfg.ShutdownTimeout = 10 * time.Millisecond
+	cfg.Clock = clk
+	srv := startSimServer(t, cfg)
+	dir := t.TempDir()
+	if err := srv.GetControl().SetConfig(map[string]any{
+		server.CfgDumpRoutes:   "/dump",
+		server.CfgDumpDir:      dir,
+		server.CfgDumpPayloads: true,
+		server.CfgDumpFormat:   "xml", // rejected, JSONL stays
+	}); err != nil {
+		t.Fatalf("SetConfig: %v", err)
+	}
+
+	var clients []*sim.Transport
+	for _, path := range []string{"/dump", "/other"} {
+		a, b := sim.Pipe(clk)
+		if err := srv.ServeTransport(a, httptest.NewRequest("GET", path, nil)); err != nil {
+			t.Fatalf("ServeTransport: %v", err)
+		}
+		clients = append(clients, b)
+		b.Send([][]byte{maskedFrame([]byte(path))})
+		if f := simFrame(t, b); string(f.Payload) != path {
+			t.Fatalf("echo %q", f.Payload)
+		}
+	}
+	stats := srv.GetControl().Stats
+	files, _ := stats()["debug."+server.ProbeDumps].([]string)
+	if len(files) != 1 || !strings.HasPrefix(files[0], dir) || !strings.HasSuffix(files[0], ".jsonl") {
+		t.Fatalf("dump files %v", files)
+	}
+
+	clients[0].Close()
+	if !waitFor(t, time.Second, func() bool {
+		files, _ := stats()["debug."+server.ProbeDumps].([]string)
+		return len(files) == 0
+	}) {
+		t.Fatal("dump not closed with its connection")
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	recs := jsonlRecords(t, f)
+	if len(recs) != 2 || recs[0].Dir != "recv" || recs[1].Dir != "send" || string(recs[1].Payload) != "/dump" ||
+		recs[0].Path != "/dump" || recs[0].Session == "" {
+		t.Fatalf("records %+v", recs)
+	}
+}
+
+// jsonlRecords decodes the records of a JSONL dump.
+func jsonlRecords(t *testing.T, r io.Reader) []framedump.Record {
+	t.Helper()
+	var recs []framedump.Record
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		var rec framedump.Record
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("line %q: %v", sc.Text(), err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs
+}
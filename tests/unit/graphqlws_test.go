@@ -0,0 +1,129 @@
+// File: tests/unit/graphqlws_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the graphql-transport-ws protocol helper.
+
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/graphqlws"
+	"github.com/momentics/hioload-ws/highlevel"
+)
+
+// TestGraphQLOperationType tests operation detection in GraphQL documents.
+func TestGraphQLOperationType(t *testing.T) {
+	cases := map[string]string{
+		"{ hello }":                   "query",
+		"query Q { hello }":           "query",
+		"# comment\nmutation { add }": "mutation",
+		"fragment F on T { a { b } } subscription S { ...F }": "subscription",
+		"": "",
+	}
+	for doc, want := range cases {
+		if got := graphqlws.OperationType(doc); got != want {
+			t.Errorf("OperationType(%q) = %q, want %q", doc, got, want)
+		}
+	}
+}
+
+// TestGraphQLWSLifecycle tests init, queries, subscriptions and client-side
+// completion over a negotiated connection.
+func TestGraphQLWSLifecycle(t *testing.T) {
+	cancelled := make(chan string, 1)
+	execute := func(ctx context.Context, req *graphqlws.Request) (*graphqlws.Result, error) {
+		return &graphqlws.Result{Data: map[string]any{"hello": req.Variables["name"]}}, nil
+	}
+	subscribe := func(ctx context.Context, req *graphqlws.Request) (<-chan *graphqlws.Result, error) {
+		ch := make(chan *graphqlws.Result)
+		go func() {
+			defer close(ch)
+			if req.OperationName == "Forever" {
+				<-ctx.Done()
+				cancelled <- req.OperationName
+				return
+			}
+			for i := 1; i <= 2; i++ {
+				select {
+				case ch <- &graphqlws.Result{Data: map[string]any{"tick": i}}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return ch, nil
+	}
+	init := func(ctx context.Context, payload json.RawMessage) (any, error) {
+		var p struct{ Token string }
+		if json.Unmarshal(payload, &p) != nil || p.Token != "secret" {
+			return nil, fmt.Errorf("bad token")
+		}
+		return map[string]any{"user": "u1"}, nil
+	}
+
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf(":%d", port), highlevel.WithSubprotocols(graphqlws.Subprotocol))
+	srv.HandleFunc("/graphql", func(c *highlevel.Conn) {
+		graphqlws.Serve(c, graphqlws.WithExecute(execute), graphqlws.WithSubscribe(subscribe), graphqlws.WithInit(init))
+	})
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	opts := highlevel.DefaultOptions()
+	opts.Subprotocols = []string{graphqlws.Subprotocol}
+	conn, err := highlevel.DialWithOptions(fmt.Sprintf("ws://localhost:%d/graphql", port), opts)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	send := func(raw string) {
+		t.Helper()
+		if err := conn.WriteString(raw); err != nil {
+			t.Fatalf("WriteString: %v", err)
+		}
+	}
+	expect := func(typ, id, payload string) {
+		t.Helper()
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage: %v", err)
+		}
+		var msg graphqlws.Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("Invalid message %q: %v", data, err)
+		}
+		if msg.Type != typ || msg.ID != id || (payload != "" && string(msg.Payload) != payload) {
+			t.Fatalf("Expected %s/%s %s, got %s", typ, id, payload, data)
+		}
+	}
+
+	send(`{"type":"connection_init","payload":{"token":"secret"}}`)
+	expect(graphqlws.TypeConnectionAck, "", `{"user":"u1"}`)
+
+	send(`{"id":"1","type":"subscribe","payload":{"query":"query($name: String) { hello(name: $name) }","variables":{"name":"ws"}}}`)
+	expect(graphqlws.TypeNext, "1", `{"data":{"hello":"ws"}}`)
+	expect(graphqlws.TypeComplete, "1", "")
+
+	send(`{"id":"2","type":"subscribe","payload":{"query":"subscription { tick }"}}`)
+	expect(graphqlws.TypeNext, "2", `{"data":{"tick":1}}`)
+	expect(graphqlws.TypeNext, "2", `{"data":{"tick":2}}`)
+	expect(graphqlws.TypeComplete, "2", "")
+
+	send(`{"id":"3","type":"subscribe","payload":{"operationName":"Forever","query":"subscription Forever { forever }"}}`)
+	send(`{"id":"3","type":"complete"}`)
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Client complete did not cancel the subscription")
+	}
+
+	send(`{"type":"ping"}`)
+	expect(graphqlws.TypePong, "", "")
+}
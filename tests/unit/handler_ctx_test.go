@@ -0,0 +1,80 @@
+// File: tests/unit/handler_ctx_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for context-aware handlers run with Server.RunCtx.
+
+package unit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// TestRunCtx tests that handlers get the connection's context, bounded by
+// HandlerTimeout, and that it is cancelled with the close status once the
+// connection closes.
+func TestRunCtx(t *testing.T) {
+	port := freePort(t)
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf("127.0.0.1:%d", port)
+	cfg.ShutdownTimeout = 10 * time.Millisecond
+	cfg.HandlerTimeout = time.Minute
+	srv, err := server.NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	type call struct {
+		conn     any
+		deadline bool
+		connCtx  context.Context
+	}
+	calls := make(chan call, 4)
+	go srv.RunCtx(api.HandlerCtxFunc(func(ctx context.Context, data any) error {
+		if b, ok := data.(interface{ GetBuffer() api.Buffer }); ok {
+			b.GetBuffer().Release()
+			_, deadline := ctx.Deadline()
+			calls <- call{conn: api.FromContext(ctx), deadline: deadline, connCtx: api.ContextFromData(data)}
+		}
+		return nil
+	}))
+	t.Cleanup(srv.Shutdown)
+
+	conn, br, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	defer conn.Close()
+	conn.Write(maskedFrame([]byte("hello")))
+
+	var c call
+	select {
+	case c = <-calls:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler not called")
+	}
+	if _, ok := c.conn.(*protocol.WSConnection); !ok || !c.deadline {
+		t.Errorf("handler context: conn %T, deadline %v", c.conn, c.deadline)
+	}
+	if c.connCtx.Err() != nil {
+		t.Fatalf("connection context done while open: %v", c.connCtx.Err())
+	}
+
+	conn.Write([]byte{0x88, 0x80 | 5, 0, 0, 0, 0, 0x03, 0xE8, 'b', 'y', 'e'})
+	readClose(t, conn, br)
+	conn.Close()
+	select {
+	case <-c.connCtx.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection context not cancelled on close")
+	}
+	var ce *api.CloseError
+	if cause := context.Cause(c.connCtx); !errors.As(cause, &ce) || ce.Code != 1000 {
+		t.Errorf("cancel cause = %v, want close 1000", cause)
+	}
+}
@@ -0,0 +1,237 @@
+// File: tests/unit/handshake_parser_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the in-place upgrade request parser.
+
+package unit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/momentics/hioload-ws/highlevel"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+const upgradeHead = "GET /chat?room=1 HTTP/1.1\r\n" +
+	"Host: example.com\r\n" +
+	"upgrade: WebSocket\r\n" +
+	"Connection: keep-alive, Upgrade\r\n" +
+	"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+	"Sec-WebSocket-Version: 13\r\n" +
+	"Sec-WebSocket-Protocol: chat, superchat\r\n" +
+	"\r\n"
+
+// TestUpgradeRequestParse tests the views, validation and the accept key of
+// the RFC 6455 example, and that bytes after the head stay buffered.
+func TestUpgradeRequestParse(t *testing.T) {
+	br := bufio.NewReader(strings.NewReader(upgradeHead + "early"))
+	ur := protocol.AcquireUpgradeRequest()
+	defer ur.Release()
+	if err := ur.Read(br); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if string(ur.Method) != "GET" || string(ur.Target) != "/chat?room=1" || string(ur.Path()) != "/chat" {
+		t.Errorf("request line: %q %q path %q", ur.Method, ur.Target, ur.Path())
+	}
+	if got := string(ur.Header("sec-websocket-protocol")); got != "chat, superchat" {
+		t.Errorf("Header = %q", got)
+	}
+	key, err := ur.Validate()
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if got := string(protocol.AppendAcceptKey(nil, key)); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("accept = %q", got)
+	}
+	if rest, _ := br.Peek(5); string(rest) != "early" {
+		t.Errorf("buffered after head = %q", rest)
+	}
+
+	req, err := ur.HTTPRequest()
+	if err != nil {
+		t.Fatalf("HTTPRequest: %v", err)
+	}
+	if req.Host != "example.com" || req.URL.Path != "/chat" || req.URL.Query().Get("room") != "1" {
+		t.Errorf("request: host %q url %v", req.Host, req.URL)
+	}
+	if got := protocol.SelectSubprotocol(req, []string{"superchat"}); got != "superchat" {
+		t.Errorf("SelectSubprotocol = %q", got)
+	}
+}
+
+// TestReadUpgrade tests reading a head arriving in pieces straight from the
+// connection, the bytes read past it, the 101 response built from the views,
+// and the request built once and parsed again from the head.
+func TestReadUpgrade(t *testing.T) {
+	ur, err := protocol.ReadUpgrade(iotest.HalfReader(strings.NewReader(upgradeHead + "early")))
+	if err != nil {
+		t.Fatalf("ReadUpgrade: %v", err)
+	}
+	defer ur.Release()
+	if string(ur.Path()) != "/chat" || string(ur.Header("Host")) != "example.com" {
+		t.Errorf("path %q host %q", ur.Path(), ur.Header("Host"))
+	}
+	if rest := string(ur.Rest()); !strings.HasPrefix("early", rest) {
+		t.Errorf("Rest = %q", rest)
+	}
+	if ur.Head() != upgradeHead {
+		t.Errorf("Head = %q", ur.Head())
+	}
+
+	var out bytes.Buffer
+	if err := protocol.WriteUpgradeResponse(&out, ur, http.Header{"X-Extra": {"1"}}); err != nil {
+		t.Fatalf("WriteUpgradeResponse: %v", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(&out), nil)
+	if err != nil {
+		t.Fatalf("ReadResponse: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" ||
+		resp.Header.Get("Upgrade") != "websocket" || resp.Header.Get("X-Extra") != "1" {
+		t.Errorf("response %d %v", resp.StatusCode, resp.Header)
+	}
+
+	req, err := ur.HTTPRequest()
+	if err != nil {
+		t.Fatalf("HTTPRequest: %v", err)
+	}
+	if again, _ := ur.HTTPRequest(); again != req {
+		t.Error("HTTPRequest built the request twice")
+	}
+	parsed, err := protocol.ParseRequestHead(ur.Head())
+	if err != nil || parsed.URL.Path != "/chat" || parsed.Header.Get("Sec-WebSocket-Protocol") != "chat, superchat" {
+		t.Errorf("ParseRequestHead: %v %+v", err, parsed)
+	}
+
+	for _, head := range []string{"GET / HTTP/1.1\r\nHost: x\r\n", "GET / HTTP/1.1\r\n" + strings.Repeat("X-Pad: aaaaaaaa\r\n", 600)} {
+		if _, err := protocol.ReadUpgrade(strings.NewReader(head)); err == nil {
+			t.Errorf("head of %d bytes accepted", len(head))
+		}
+	}
+}
+
+// TestServerUpgradeEarlyFrame tests that the server answers an upgrade
+// sent in one write with a frame, delivers the frame, and exposes the
+// request headers it parsed lazily.
+func TestServerUpgradeEarlyFrame(t *testing.T) {
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf("127.0.0.1:%d", port))
+	srv.HandleFunc("/early", func(c *highlevel.Conn) {
+		mt, msg, err := c.ReadMessage()
+		if err == nil {
+			c.WriteMessage(mt, append(msg, " "+c.RequestHeader().Get("X-Probe")...))
+		}
+	})
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	head := strings.Replace(upgradeHead, "/chat?room=1", "/early", 1)
+	head = strings.Replace(head, "\r\n\r\n", "\r\nX-Probe: seen\r\n\r\n", 1)
+	if _, err := conn.Write(append([]byte(head), maskedFrame([]byte("early"))...)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Upgrade failed: %v %v", resp, err)
+	}
+	if reply, err := protocol.DecodeFrame(br); err != nil || string(reply.Payload) != "early seen" {
+		t.Fatalf("Expected \"early seen\", got %v", err)
+	}
+}
+
+// TestUpgradeRequestInvalid tests that malformed, incomplete and oversized
+// heads are rejected.
+func TestUpgradeRequestInvalid(t *testing.T) {
+	cases := map[string]struct {
+		head string
+		want error
+	}{
+		"no version":   {"GET /\r\n\r\n", protocol.ErrMalformedHandshake},
+		"http/1.0":     {"GET / HTTP/1.0\r\n\r\n", protocol.ErrMalformedHandshake},
+		"bad field":    {"GET / HTTP/1.1\r\nBad Name: x\r\n\r\n", protocol.ErrMalformedHandshake},
+		"no colon":     {"GET / HTTP/1.1\r\nHost\r\n\r\n", protocol.ErrMalformedHandshake},
+		"oversized":    {"GET / HTTP/1.1\r\n" + strings.Repeat("X-Pad: "+strings.Repeat("a", 1000)+"\r\n", 9) + "\r\n", protocol.ErrHandshakeTooLarge},
+		"line too big": {"GET / HTTP/1.1\r\nX-Pad: " + strings.Repeat("a", 5000) + "\r\n\r\n", protocol.ErrHandshakeTooLarge},
+	}
+	for name, c := range cases {
+		ur := protocol.AcquireUpgradeRequest()
+		if err := ur.Read(bufio.NewReader(strings.NewReader(c.head))); !errors.Is(err, c.want) {
+			t.Errorf("%s: got %v, want %v", name, err, c.want)
+		}
+		ur.Release()
+	}
+
+	ur := protocol.AcquireUpgradeRequest()
+	defer ur.Release()
+	if err := ur.Read(bufio.NewReader(strings.NewReader("GET / HTTP/1.1\r\nHost: x\r\n"))); err == nil {
+		t.Error("incomplete head accepted")
+	}
+	head := strings.Replace(upgradeHead, "Sec-WebSocket-Version: 13", "Sec-WebSocket-Version: 8", 1)
+	if err := ur.Read(bufio.NewReader(strings.NewReader(head))); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if _, err := ur.Validate(); err != protocol.ErrBadWebSocketVersion {
+		t.Errorf("Validate = %v, want ErrBadWebSocketVersion", err)
+	}
+}
+
+// TestUpgradeRequestAllocs tests that reading, validating and answering an
+// upgrade allocates nothing.
+func TestUpgradeRequestAllocs(t *testing.T) {
+	rd := strings.NewReader(upgradeHead)
+	br := bufio.NewReader(rd)
+	hdr := http.Header{"Upgrade": {"websocket"}, "Connection": {"Upgrade"}, "Sec-Websocket-Accept": {"s3pPLMBiTxaQ9kYGzzhZRbK+xOo="}}
+	resp := make([]byte, 0, 512)
+	var accept [28]byte
+	allocs := testing.AllocsPerRun(100, func() {
+		rd.Reset(upgradeHead)
+		br.Reset(rd)
+		ur := protocol.AcquireUpgradeRequest()
+		if err := ur.Read(br); err != nil {
+			t.Fatal(err)
+		}
+		key, err := ur.Validate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		protocol.AppendAcceptKey(accept[:0], key)
+		resp = protocol.AppendHandshakeResponse(resp[:0], hdr)
+		ur.Release()
+	})
+	if allocs != 0 {
+		t.Errorf("%.0f allocations per handshake, want 0", allocs)
+	}
+
+	hdr = make(http.Header)
+	allocs = testing.AllocsPerRun(100, func() {
+		rd.Reset(upgradeHead)
+		ur, err := protocol.ReadUpgrade(rd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		protocol.WriteUpgradeResponse(io.Discard, ur, hdr)
+		ur.Release()
+	})
+	if allocs != 0 {
+		t.Errorf("%.0f allocations per ReadUpgrade, want 0", allocs)
+	}
+}
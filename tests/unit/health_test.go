@@ -0,0 +1,94 @@
+// File: tests/unit/health_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the health/readiness registry and server checks.
+
+package unit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/control"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+)
+
+func TestHealthRegistry(t *testing.T) {
+	h := control.NewHealthRegistry()
+	if !h.Live(context.Background()).OK() || !h.Ready(context.Background()).OK() {
+		t.Fatal("Expected empty registry to pass")
+	}
+	h.Register("db", control.HealthReady, func(context.Context) error { return errors.New("down") })
+	h.Register("loop", control.HealthLive|control.HealthReady, func(context.Context) error { return nil })
+	h.Register("slow", control.HealthLive, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	live := h.Live(ctx)
+	if live.OK() || live.Checks["loop"] != "ok" || live.Checks["slow"] == "ok" || live.Checks["db"] != "" {
+		t.Errorf("Unexpected live result %+v", live)
+	}
+	h.Unregister("slow")
+	ready := h.Ready(context.Background())
+	if ready.OK() || ready.Checks["db"] != "down" || ready.Checks["loop"] != "ok" {
+		t.Errorf("Unexpected ready result %+v", ready)
+	}
+	if w := adminDo(h.LiveHandler(), http.MethodGet, "/livez", "", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected live 200, got %d", w.Code)
+	}
+	if w := adminDo(h.ReadyHandler(), http.MethodGet, "/readyz", "", ""); w.Code != http.StatusServiceUnavailable ||
+		!strings.Contains(w.Body.String(), `"db": "down"`) {
+		t.Errorf("Expected ready 503 naming db, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+// TestServerHealth tests the built-in checks across Run and Shutdown and the
+// unauthenticated admin probe endpoints.
+func TestServerHealth(t *testing.T) {
+	port := freePort(t)
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf(":%d", port)
+	cfg.ShutdownTimeout = 10 * time.Millisecond
+	srv, err := server.NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	if res := srv.Health().Live(context.Background()); res.OK() || res.Checks[server.HealthReactor] == "ok" {
+		t.Errorf("Expected reactor check to fail before Run, got %+v", res)
+	}
+
+	t.Cleanup(srv.Shutdown)
+	runServer(t, srv, api.HandlerFunc(func(any) error { return nil }))
+	var res control.HealthResult
+	waitFor(t, 500*time.Millisecond, func() bool {
+		res = srv.Health().Ready(context.Background())
+		return res.OK()
+	})
+	if !res.OK() || len(res.Checks) != 4 {
+		t.Fatalf("Expected all four checks ready, got %+v", res)
+	}
+
+	admin := srv.AdminServer("127.0.0.1:0", control.WithAdminToken("secret")).Handler()
+	if w := adminDo(admin, http.MethodGet, "/readyz", "", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected /readyz 200 without token, got %d", w.Code)
+	}
+	if w := adminDo(admin, http.MethodGet, "/stats", "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected /stats to stay protected, got %d", w.Code)
+	}
+
+	srv.Shutdown()
+	if w := adminDo(admin, http.MethodGet, "/readyz", "", ""); w.Code != http.StatusServiceUnavailable ||
+		!strings.Contains(w.Body.String(), "shutting down") {
+		t.Errorf("Expected /readyz 503 after Shutdown, got %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,110 @@
+// File: tests/unit/hooks_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for highlevel server lifecycle hooks.
+
+package unit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/highlevel"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// TestServerLifecycleHooks tests OnConnect, OnDisconnect with the close code
+// and duration, and OnError for a protocol violation.
+func TestServerLifecycleHooks(t *testing.T) {
+	type closed struct {
+		path string
+		code uint16
+		d    time.Duration
+	}
+	connected := make(chan string, 4)
+	disconnected := make(chan closed, 4)
+	failed := make(chan error, 4)
+
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf(":%d", port))
+	srv.OnConnect(func(c *highlevel.Conn) {
+		connected <- c.Param("room")
+	})
+	srv.OnDisconnect(func(c *highlevel.Conn, code uint16, d time.Duration) {
+		disconnected <- closed{c.Param("room"), code, d}
+	})
+	srv.OnError(func(c *highlevel.Conn, err error) {
+		failed <- err
+	})
+	srv.HandleFunc("/room/:room", func(c *highlevel.Conn) {
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	conn, err := highlevel.Dial(fmt.Sprintf("ws://localhost:%d/room/blue", port))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	select {
+	case room := <-connected:
+		if room != "blue" {
+			t.Errorf("Expected OnConnect with room blue, got %q", room)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnConnect not called before any message")
+	}
+	ws := conn.GetUnderlyingWSConnection()
+	ws.SendFrame(&protocol.WSFrame{
+		IsFinal: true, Opcode: protocol.OpcodeClose, Masked: true,
+		Payload: protocol.NewCloseFrame(4000, "bye").Payload, PayloadLen: 5,
+	})
+	// Close discards queued frames: let the send loop write the close first.
+	if !waitFor(t, 2*time.Second, func() bool { return ws.GetStats()["frames_sent"] >= 1 }) {
+		t.Fatal("Close frame not written")
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Fatal("Expected the server to answer the close")
+	}
+	conn.Close()
+	select {
+	case c := <-disconnected:
+		if c.path != "blue" || c.code != 4000 || c.d <= 0 {
+			t.Errorf("Unexpected OnDisconnect %+v", c)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnDisconnect not called")
+	}
+
+	// An oversized frame header is a fatal read error, answered with 1009
+	// Message Too Big before any of the payload is read. /nowhere has no
+	// route and is upgraded by an empty fallback.
+	srv.NotFound(highlevel.Fallback{})
+	raw, _, _ := upgrade(t, port, "/nowhere", nil, http.StatusSwitchingProtocols)
+	defer raw.Close()
+	if room := <-connected; room != "" {
+		t.Errorf("Expected unrouted connection, got room %q", room)
+	}
+	raw.Write([]byte{0x82, 0xff, 0, 0, 1, 0, 0, 0, 0, 0, 1, 2, 3, 4})
+	select {
+	case err := <-failed:
+		if err == nil || !strings.Contains(err.Error(), "exceeds") {
+			t.Errorf("Expected oversized frame error, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnError not called")
+	}
+	if c := <-disconnected; c.code != protocol.CloseMessageTooBig {
+		t.Errorf("Expected close code 1009, got %d", c.code)
+	}
+}
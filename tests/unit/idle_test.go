@@ -0,0 +1,91 @@
+// File: tests/unit/idle_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the idle connection sweeper, run on the simulated clock.
+
+package unit
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/control"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+	"github.com/momentics/hioload-ws/protocol"
+	"github.com/momentics/hioload-ws/sim"
+)
+
+// TestSimServerIdleTimeout tests that connections receiving no frame for
+// IdleTimeout are closed with 1001 "idle timeout", that data frames defer
+// it, and that pongs do unless IdleIgnorePongs is set.
+func TestSimServerIdleTimeout(t *testing.T) {
+	for _, ignorePongs := range []bool{false, true} {
+		t.Run(fmt.Sprintf("ignorePongs=%v", ignorePongs), func(t *testing.T) {
+			clk := sim.NewClock(time.Time{})
+			cfg := server.DefaultConfig()
+			cfg.ListenAddr = fmt.Sprintf("127.0.0.1:%d", freePort(t))
+			cfg.ShutdownTimeout = 10 * time.Millisecond
+			cfg.Clock = clk
+			cfg.IdleTimeout = 10 * time.Second
+			cfg.IdleIgnorePongs = ignorePongs
+			srv := startSimServer(t, cfg)
+
+			var clients []*sim.Transport
+			for i := 0; i < 3; i++ {
+				a, b := sim.Pipe(clk)
+				if err := srv.ServeTransport(a, httptest.NewRequest("GET", "/idle", nil)); err != nil {
+					t.Fatalf("ServeTransport: %v", err)
+				}
+				clients = append(clients, b)
+			}
+			quiet, chatty, ponger := clients[0], clients[1], clients[2]
+			waitConns(t, srv, 3)
+
+			clk.BlockUntil(1) // the wheel's ticker
+			clk.Advance(6 * time.Second)
+			chatty.Send([][]byte{maskedFrame([]byte("x"))})
+			if f := simFrame(t, chatty); string(f.Payload) != "x" {
+				t.Fatalf("echo %q", f.Payload)
+			}
+			pong := maskedFrame(nil)
+			pong[0] = 0x8A
+			ponger.Send([][]byte{pong})
+
+			closedAt := func(tr *sim.Transport, from, to time.Duration) {
+				t.Helper()
+				if now := clk.Since(sim.Epoch); now < from || now >= to {
+					t.Fatalf("closed at %v, expected between %v and %v", now, from, to)
+				}
+				f := simFrame(t, tr)
+				if code, reason, _ := protocol.ParseClosePayload(f.Payload); f.Opcode != protocol.OpcodeClose ||
+					code != protocol.CloseGoingAway || reason != "idle timeout" {
+					t.Fatalf("expected 1001 idle timeout, got opcode %d %d %q", f.Opcode, code, reason)
+				}
+			}
+			closed := func() uint64 {
+				c, _ := srv.GetControl().Stats()["debug."+server.ProbeIdleClosed].(*control.Counter)
+				return c.Value()
+			}
+
+			if ignorePongs {
+				simAdvanceUntil(t, clk, func() bool { return srv.GetActiveConnections() == 1 })
+				closedAt(quiet, 10*time.Second, 11*time.Second)
+				closedAt(ponger, 10*time.Second, 11*time.Second)
+			} else {
+				simAdvanceUntil(t, clk, func() bool { return srv.GetActiveConnections() == 2 })
+				closedAt(quiet, 10*time.Second, 11*time.Second)
+			}
+			simAdvanceUntil(t, clk, func() bool { return srv.GetActiveConnections() == 0 })
+			closedAt(chatty, 16*time.Second, 17*time.Second)
+			if !ignorePongs {
+				closedAt(ponger, 16*time.Second, 17*time.Second)
+			}
+			if n := closed(); n != 3 {
+				t.Errorf("%s = %d, expected 3", server.ProbeIdleClosed, n)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+// File: tests/unit/interceptor_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for per-connection send and receive frame interceptors.
+
+package unit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/pool"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// xorInterceptor flips every payload byte in place, standing in for a cipher.
+func xorInterceptor(f *protocol.WSFrame) (*protocol.WSFrame, error) {
+	for i := range f.Payload {
+		f.Payload[i] ^= 0x5A
+	}
+	return f, nil
+}
+
+func textFrame(s string) *protocol.WSFrame {
+	return &protocol.WSFrame{IsFinal: true, Opcode: protocol.OpcodeText, Payload: []byte(s), PayloadLen: int64(len(s))}
+}
+
+// TestSendInterceptors tests that send interceptors run in order on data
+// frames before encoding, can drop frames and fail the send, and leave
+// control frames alone.
+func TestSendInterceptors(t *testing.T) {
+	var sent [][]byte
+	tr := &api.MockTransport{
+		SendFunc: func(b [][]byte) error {
+			for _, seg := range b {
+				sent = append(sent, append([]byte(nil), seg...))
+			}
+			return nil
+		},
+		CloseFunc: func() error { return nil },
+	}
+	conn := protocol.NewWSConnection(tr, nil, 4)
+	conn.AddSendInterceptor(func(f *protocol.WSFrame) (*protocol.WSFrame, error) {
+		if string(f.Payload) == "drop" {
+			return nil, nil
+		}
+		if string(f.Payload) == "fail" {
+			return nil, errors.New("refused")
+		}
+		signed := *f
+		signed.Payload = append([]byte("sig:"), f.Payload...)
+		signed.PayloadLen = int64(len(signed.Payload))
+		return &signed, nil
+	})
+	conn.AddSendInterceptor(xorInterceptor)
+
+	ping := &protocol.WSFrame{IsFinal: true, Opcode: protocol.OpcodePing, Payload: []byte("p"), PayloadLen: 1}
+	if err := conn.WriteFrames([]*protocol.WSFrame{textFrame("a"), textFrame("drop"), ping}); err != nil {
+		t.Fatalf("WriteFrames: %v", err)
+	}
+	if len(sent) != 2 {
+		t.Fatalf("Expected 2 frames written, got %d", len(sent))
+	}
+	f, err := protocol.DecodeFrame(bytes.NewReader(sent[0]))
+	if err != nil {
+		t.Fatalf("DecodeFrame: %v", err)
+	}
+	xorInterceptor(f)
+	if string(f.Payload) != "sig:a" {
+		t.Errorf("Expected intercepted payload sig:a, got %q", f.Payload)
+	}
+	if f, _ := protocol.DecodeFrame(bytes.NewReader(sent[1])); f == nil || string(f.Payload) != "p" {
+		t.Errorf("Expected control frame untouched, got %+v", f)
+	}
+	if got := conn.GetStats()["frames_sent"]; got != 2 {
+		t.Errorf("frames_sent = %d, want 2", got)
+	}
+
+	sent = nil
+	if err := conn.WriteFrames([]*protocol.WSFrame{textFrame("b"), textFrame("fail")}); err == nil || err.Error() != "refused" {
+		t.Errorf("WriteFrames: got %v, want refused", err)
+	}
+	if err := conn.SendFrame(textFrame("fail")); err == nil || err.Error() != "refused" {
+		t.Errorf("SendFrame: got %v, want refused", err)
+	}
+	if len(sent) != 0 {
+		t.Errorf("Expected nothing written after a failed interceptor, got %d frames", len(sent))
+	}
+}
+
+// TestSendInterceptors_SharedBuffer tests that an interceptor modifying the
+// payload in place leaves a shared buffer intact for its other owners.
+func TestSendInterceptors_SharedBuffer(t *testing.T) {
+	capture := func(sent *[]byte) *api.MockTransport {
+		return &api.MockTransport{
+			SendFunc: func(b [][]byte) error {
+				for _, seg := range b {
+					*sent = append(*sent, seg...)
+				}
+				return nil
+			},
+			CloseFunc: func() error { return nil },
+		}
+	}
+	var wireA, wireB []byte
+	connA := protocol.NewWSConnection(capture(&wireA), nil, 4)
+	connA.AddSendInterceptor(xorInterceptor)
+	connB := protocol.NewWSConnection(capture(&wireB), nil, 4)
+
+	rel := &countingReleaser{}
+	shared := pool.Share(api.Buffer{Data: []byte("hi"), Pool: rel}, 2)
+	if !shared.Shared() {
+		t.Fatal("Expected Share to return a shared buffer")
+	}
+	for _, conn := range []*protocol.WSConnection{connA, connB} {
+		frame := &protocol.WSFrame{IsFinal: true, Opcode: protocol.OpcodeText, Payload: shared.Data, PayloadLen: 2, Buf: shared}
+		if err := conn.WriteFrames([]*protocol.WSFrame{frame}); err != nil {
+			t.Fatalf("WriteFrames: %v", err)
+		}
+	}
+	if string(shared.Data) != "hi" {
+		t.Errorf("Expected shared payload untouched, got %q", shared.Data)
+	}
+	f, err := protocol.DecodeFrame(bytes.NewReader(wireA))
+	if err != nil {
+		t.Fatalf("DecodeFrame: %v", err)
+	}
+	if xorInterceptor(f); string(f.Payload) != "hi" {
+		t.Errorf("Expected intercepted payload on A, got %q", f.Payload)
+	}
+	if f, _ := protocol.DecodeFrame(bytes.NewReader(wireB)); f == nil || string(f.Payload) != "hi" {
+		t.Errorf("Expected B to send the original payload, got %+v", f)
+	}
+	if rel.n != 1 {
+		t.Errorf("Expected shared buffer released once, got %d", rel.n)
+	}
+}
+
+// TestRecvInterceptors tests that receive interceptors see decoded data
+// frames, can drop them, and close the connection when they fail.
+func TestRecvInterceptors(t *testing.T) {
+	wire := func(frames ...*protocol.WSFrame) [][]byte {
+		var raws [][]byte
+		for _, f := range frames {
+			xorInterceptor(f)
+			f.Masked = true
+			data, err := protocol.EncodeFrameToBytesWithMask(f, true)
+			if err != nil {
+				t.Fatalf("EncodeFrameToBytesWithMask: %v", err)
+			}
+			raws = append(raws, data)
+		}
+		return raws
+	}
+	var recv [][]byte
+	var sent [][]byte
+	tr := &api.MockTransport{
+		RecvFunc: func() ([][]byte, error) {
+			raws := recv
+			recv = nil
+			return raws, nil
+		},
+		SendFunc: func(b [][]byte) error {
+			sent = append(sent, b...)
+			return nil
+		},
+		CloseFunc: func() error { return nil },
+	}
+	conn := protocol.NewWSConnection(tr, pool.NewBufferPoolManager(0).GetPool(1024, 0), 4)
+	conn.AddRecvInterceptor(xorInterceptor)
+	conn.AddRecvInterceptor(func(f *protocol.WSFrame) (*protocol.WSFrame, error) {
+		switch string(f.Payload) {
+		case "noise":
+			return nil, nil
+		case "forged":
+			return nil, &api.CloseError{Code: 4001, Reason: "bad signature"}
+		}
+		return f, nil
+	})
+
+	recv = wire(textFrame("hello"), textFrame("noise"))
+	msgs, err := conn.RecvMessages()
+	if err != nil {
+		t.Fatalf("RecvMessages: %v", err)
+	}
+	if len(msgs) != 1 || string(msgs[0].Buf.Bytes()) != "hello" {
+		t.Fatalf("Expected only hello delivered, got %d messages", len(msgs))
+	}
+	msgs[0].Buf.Release()
+
+	recv = wire(textFrame("forged"))
+	if _, err := conn.RecvMessages(); !errors.Is(err, protocol.ErrProtocolViolation) {
+		t.Errorf("Expected ErrProtocolViolation, got %v", err)
+	}
+	if len(sent) != 1 || len(sent[0]) < 4 || binary.BigEndian.Uint16(sent[0][2:]) != 4001 {
+		t.Errorf("Expected close 4001 sent, got %v", sent)
+	}
+}
@@ -0,0 +1,133 @@
+// File: tests/unit/ipfilter_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the CIDR allow/deny lists applied on accept.
+
+package unit
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/internal/transport"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+)
+
+func tcpAddr(ip string) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: 4000}
+}
+
+// TestIPFilter tests rule matching and per-rule hit counters.
+func TestIPFilter(t *testing.T) {
+	f, err := transport.NewIPFilter([]string{"10.0.0.0/8", "2001:db8::/32"}, []string{"10.1.0.0/16", "10.2.3.4"})
+	if err != nil {
+		t.Fatalf("Failed to build filter: %v", err)
+	}
+	cases := map[string]bool{
+		"10.9.9.9":        true,
+		"::ffff:10.9.9.9": true, // IPv4-mapped
+		"10.1.2.3":        false,
+		"10.2.3.4":        false,
+		"10.2.3.5":        true,
+		"2001:db8::1":     true,
+		"192.0.2.1":       false,
+	}
+	for ip, want := range cases {
+		if got := f.Admit(tcpAddr(ip)); got != want {
+			t.Errorf("Admit(%s) = %v, want %v", ip, got, want)
+		}
+	}
+	if !f.Admit(&net.UnixAddr{Name: "/tmp/ws.sock", Net: "unix"}) {
+		t.Error("Expected Unix socket peers admitted")
+	}
+	want := map[string]uint64{"allow 10.0.0.0/8": 3, "allow 2001:db8::/32": 1, "deny 10.1.0.0/16": 1, "deny 10.2.3.4/32": 1}
+	if hits := f.Hits(); fmt.Sprint(hits) != fmt.Sprint(want) {
+		t.Errorf("Expected hits %v, got %v", want, hits)
+	}
+	if f.Refused() != 1 {
+		t.Errorf("Expected one peer refused by the allow list, got %d", f.Refused())
+	}
+
+	if err := f.SetRules(nil, []string{"10.1.0.0/16", "bogus"}); err == nil {
+		t.Fatal("Expected an invalid rule rejected")
+	}
+	if err := f.SetRules(nil, []string{"10.1.0.0/16"}); err != nil {
+		t.Fatalf("Failed to replace rules: %v", err)
+	}
+	if hits := f.Hits(); len(hits) != 1 || hits["deny 10.1.0.0/16"] != 1 {
+		t.Errorf("Expected the kept rule to keep its count, got %v", hits)
+	}
+	if !f.Admit(tcpAddr("192.0.2.1")) {
+		t.Error("Expected any address admitted without allow rules")
+	}
+}
+
+// refusedOnAccept reports whether the server closes a connection to port
+// without answering an upgrade request.
+func refusedOnAccept(t *testing.T, port int) bool {
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: x\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var b [1]byte
+	_, err = conn.Read(b[:])
+	return err == io.EOF || (err != nil && !isTimeout(err))
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+// TestServerIPFilter tests refusing peers on accept and hot-reloading the
+// lists through control.
+func TestServerIPFilter(t *testing.T) {
+	port := freePort(t)
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf("127.0.0.1:%d", port)
+	cfg.ShutdownTimeout = 10 * time.Millisecond
+	if err := server.ApplyConfig(cfg, map[string]any{"ipfilter.deny": []any{"127.0.0.0/8"}}); err != nil {
+		t.Fatalf("Failed to apply config: %v", err)
+	}
+	srv, err := server.NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer srv.Shutdown()
+	runServer(t, srv, api.HandlerFunc(func(any) error { return nil }))
+
+	if !refusedOnAccept(t, port) {
+		t.Fatal("Expected the denied peer refused")
+	}
+	hits := srv.GetControl().Stats()["debug.ipfilter.hits"].(map[string]uint64)
+	if hits["deny 127.0.0.0/8"] != 1 {
+		t.Errorf("Expected one hit on the deny rule, got %v", hits)
+	}
+
+	srv.GetControl().SetConfig(map[string]any{server.CfgIPDeny: []any{}})
+	conn, _, _ := upgrade(t, port, "/", nil, http.StatusSwitchingProtocols)
+	conn.Close()
+
+	srv.GetControl().SetConfig(map[string]any{server.CfgIPAllow: "10.0.0.0/8, 192.168.0.0/16"})
+	if !refusedOnAccept(t, port) {
+		t.Fatal("Expected a peer outside the allow list refused")
+	}
+	if got := srv.GetControl().Stats()["debug.ipfilter.refused"]; got != uint64(1) {
+		t.Errorf("Expected one refused peer, got %v", got)
+	}
+
+	srv.GetControl().SetConfig(map[string]any{server.CfgIPAllow: []any{"not-an-ip"}})
+	if !refusedOnAccept(t, port) {
+		t.Error("Expected an invalid reload to keep the previous rules")
+	}
+}
@@ -0,0 +1,170 @@
+// File: tests/unit/jsonrpc_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the JSON-RPC 2.0 layer.
+
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/highlevel"
+	"github.com/momentics/hioload-ws/jsonrpc"
+)
+
+// TestJSONRPC tests calls, errors, timeouts, notifications and callbacks
+// between a server and a client peer.
+func TestJSONRPC(t *testing.T) {
+	notified := make(chan string, 1)
+	router := jsonrpc.NewRouter()
+	jsonrpc.Handle(router, "sum", func(ctx context.Context, nums []int) (int, error) {
+		total := 0
+		for _, n := range nums {
+			total += n
+		}
+		return total, nil
+	})
+	jsonrpc.Handle(router, "sleep", func(ctx context.Context, ms int) (bool, error) {
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		return true, nil
+	})
+	jsonrpc.Handle(router, "fail", func(ctx context.Context, _ any) (any, error) {
+		return nil, jsonrpc.NewError(-32000, "quota exceeded", nil)
+	})
+	jsonrpc.Handle(router, "event", func(ctx context.Context, name string) (any, error) {
+		notified <- name
+		return nil, nil
+	})
+	jsonrpc.Handle(router, "greet", func(ctx context.Context, _ any) (string, error) {
+		// Call back into the client that issued this request.
+		var name string
+		err := jsonrpc.ConnFromContext(ctx).Call(ctx, "client.name", nil, &name)
+		return "hello " + name, err
+	})
+
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf(":%d", port))
+	srv.HandleFunc("/rpc", func(c *highlevel.Conn) {
+		jsonrpc.NewConn(c, jsonrpc.WithRouter(router)).Serve()
+	})
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	ws, err := highlevel.Dial(fmt.Sprintf("ws://localhost:%d/rpc", port))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	clientRouter := jsonrpc.NewRouter()
+	jsonrpc.Handle(clientRouter, "client.name", func(ctx context.Context, _ any) (string, error) {
+		return "c1", nil
+	})
+	client := jsonrpc.NewConn(ws, jsonrpc.WithRouter(clientRouter), jsonrpc.WithTimeout(2*time.Second))
+	defer client.Close()
+	go client.Serve()
+
+	ctx := context.Background()
+	var sum int
+	if err := client.Call(ctx, "sum", []int{1, 2, 3}, &sum); err != nil || sum != 6 {
+		t.Fatalf("sum = %d, %v", sum, err)
+	}
+
+	var rpcErr *jsonrpc.Error
+	if err := client.Call(ctx, "fail", nil, nil); !errors.As(err, &rpcErr) || rpcErr.Code != -32000 {
+		t.Fatalf("Expected application error -32000, got %v", err)
+	}
+	if err := client.Call(ctx, "nope", nil, nil); !errors.As(err, &rpcErr) || rpcErr.Code != jsonrpc.CodeMethodNotFound {
+		t.Fatalf("Expected method not found, got %v", err)
+	}
+	if err := client.Call(ctx, "sum", "x", nil); !errors.As(err, &rpcErr) || rpcErr.Code != jsonrpc.CodeInvalidParams {
+		t.Fatalf("Expected invalid params, got %v", err)
+	}
+
+	// In-flight calls run concurrently on the server.
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := client.Call(ctx, "sleep", 200, nil); err != nil {
+				t.Errorf("sleep: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed > 800*time.Millisecond {
+		t.Fatalf("Concurrent calls took %v, expected them to overlap", elapsed)
+	}
+
+	short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := client.Call(short, "sleep", 500, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected deadline exceeded, got %v", err)
+	}
+	if n := client.Pending(); n != 0 {
+		t.Fatalf("Expected no pending calls after timeout, got %d", n)
+	}
+
+	if err := client.Notify("event", "started"); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	select {
+	case name := <-notified:
+		if name != "started" {
+			t.Fatalf("Unexpected notification %q", name)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Notification not delivered")
+	}
+
+	var greeting string
+	if err := client.Call(ctx, "greet", nil, &greeting); err != nil || greeting != "hello c1" {
+		t.Fatalf("greet = %q, %v", greeting, err)
+	}
+}
+
+// TestJSONRPCBatch tests batch requests sent as a raw array.
+func TestJSONRPCBatch(t *testing.T) {
+	router := jsonrpc.NewRouter()
+	jsonrpc.Handle(router, "double", func(ctx context.Context, n int) (int, error) { return 2 * n, nil })
+
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf(":%d", port))
+	srv.HandleFunc("/rpc", func(c *highlevel.Conn) {
+		jsonrpc.NewConn(c, jsonrpc.WithRouter(router)).Serve()
+	})
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	ws, err := highlevel.Dial(fmt.Sprintf("ws://localhost:%d/rpc", port))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer ws.Close()
+
+	ws.WriteString(`[{"jsonrpc":"2.0","id":1,"method":"double","params":2},` +
+		`{"jsonrpc":"2.0","method":"double","params":5},` +
+		`{"jsonrpc":"2.0","id":"b","method":"double","params":4}]`)
+	_, data, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	var replies []struct {
+		ID     json.RawMessage `json:"id"`
+		Result int             `json:"result"`
+	}
+	if err := json.Unmarshal(data, &replies); err != nil {
+		t.Fatalf("Invalid batch reply %s: %v", data, err)
+	}
+	if len(replies) != 2 || string(replies[0].ID) != "1" || replies[0].Result != 4 ||
+		string(replies[1].ID) != `"b"` || replies[1].Result != 8 {
+		t.Fatalf("Unexpected batch reply %s", data)
+	}
+}
@@ -0,0 +1,146 @@
+// File: tests/unit/jwt_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for JWT authentication of upgrade requests.
+
+package unit
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/highlevel"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+var jwtSecret = []byte("test-secret")
+
+// signJWT returns a token over claims signed by sign under alg.
+func signJWT(alg string, claims map[string]any, sign func(input []byte) []byte) string {
+	enc := base64.RawURLEncoding
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+	return input + "." + enc.EncodeToString(sign([]byte(input)))
+}
+
+// hs256 signs with jwtSecret.
+func hs256(input []byte) []byte {
+	mac := hmac.New(sha256.New, jwtSecret)
+	mac.Write(input)
+	return mac.Sum(nil)
+}
+
+// TestJWTAuth tests that upgrades need a valid token and handlers see its
+// claims.
+func TestJWTAuth(t *testing.T) {
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf("127.0.0.1:%d", port), highlevel.JWTAuth(highlevel.JWTOptions{
+		Key:      jwtSecret,
+		Issuer:   "hioload",
+		Audience: "ws",
+	}))
+	srv.HandleFunc("/whoami", func(c *highlevel.Conn) {
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+			claims, ok := highlevel.JWTClaims(c)
+			if !ok {
+				c.WriteString("no claims")
+				continue
+			}
+			c.WriteString(claims.Subject())
+		}
+	})
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	exp := float64(time.Now().Add(time.Hour).Unix())
+	valid := signJWT("HS256", map[string]any{"sub": "alice", "iss": "hioload", "aud": []string{"ws"}, "exp": exp}, hs256)
+
+	conn, br, _ := upgrade(t, port, "/whoami", http.Header{"Authorization": {"Bearer " + valid}}, http.StatusSwitchingProtocols)
+	defer conn.Close()
+	conn.Write([]byte{0x81, 0x81, 0, 0, 0, 0, '?'})
+	if reply, err := protocol.DecodeFrame(br); err != nil || string(reply.Payload) != "alice" {
+		t.Errorf("Expected the handler to see subject alice, got %v (err=%v)", reply, err)
+	}
+
+	query, _, _ := upgrade(t, port, "/whoami?access_token="+valid, nil, http.StatusSwitchingProtocols)
+	query.Close()
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rejected := map[string]string{
+		"missing":      "",
+		"expired":      signJWT("HS256", map[string]any{"iss": "hioload", "aud": "ws", "exp": float64(time.Now().Add(-time.Hour).Unix())}, hs256),
+		"not yet":      signJWT("HS256", map[string]any{"iss": "hioload", "aud": "ws", "nbf": exp}, hs256),
+		"issuer":       signJWT("HS256", map[string]any{"iss": "other", "aud": "ws"}, hs256),
+		"audience":     signJWT("HS256", map[string]any{"iss": "hioload", "aud": "api"}, hs256),
+		"alg none":     signJWT("none", map[string]any{"iss": "hioload", "aud": "ws"}, func([]byte) []byte { return nil }),
+		"wrong secret": signJWT("HS256", map[string]any{"iss": "hioload", "aud": "ws"}, func(in []byte) []byte { return hs256(append(in, 'x')) }),
+		"wrong alg": signJWT("ES256", map[string]any{"iss": "hioload", "aud": "ws"}, func(in []byte) []byte {
+			h := sha256.Sum256(in)
+			r, s, _ := ecdsa.Sign(rand.Reader, ecKey, h[:])
+			return append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}),
+		"malformed": "not.a.token",
+	}
+	for name, token := range rejected {
+		t.Run(name, func(t *testing.T) {
+			header := http.Header{}
+			if token != "" {
+				header.Set("Authorization", "Bearer "+token)
+			}
+			upgrade(t, port, "/whoami", header, http.StatusUnauthorized)
+		})
+	}
+
+	// The rejection does not tell the client why the token failed.
+	req, _ := http.NewRequest("GET", fmt.Sprintf("http://127.0.0.1:%d/whoami", port), nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Authorization", "Bearer "+rejected["expired"])
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to send upgrade: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "invalid token" {
+		t.Errorf("Expected rejection reason %q, got %q", "invalid token", body)
+	}
+}
+
+// TestJWTAuth_ECDSA tests verifying ES256 tokens with a public key.
+func TestJWTAuth_ECDSA(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf("127.0.0.1:%d", port), highlevel.JWTAuth(highlevel.JWTOptions{Key: &key.PublicKey}))
+	srv.HandleFunc("/ws", func(c *highlevel.Conn) {})
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	token := signJWT("ES256", map[string]any{"sub": "bob"}, func(in []byte) []byte {
+		h := sha256.Sum256(in)
+		r, s, _ := ecdsa.Sign(rand.Reader, key, h[:])
+		return append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	})
+	conn, _, _ := upgrade(t, port, "/ws", http.Header{"Authorization": {"Bearer " + token}}, http.StatusSwitchingProtocols)
+	conn.Close()
+	hs := signJWT("HS256", map[string]any{"sub": "bob"}, hs256)
+	upgrade(t, port, "/ws", http.Header{"Authorization": {"Bearer " + hs}}, http.StatusUnauthorized)
+}
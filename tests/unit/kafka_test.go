@@ -0,0 +1,123 @@
+// File: tests/unit/kafka_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the Kafka sink/source adapter and pool.SliceBatch.
+
+package unit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/adapters/kafka"
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/pool"
+	"github.com/momentics/hioload-ws/pubsub"
+)
+
+// memoryLog is an in-process Producer and Consumer.
+type memoryLog struct {
+	mu      sync.Mutex
+	batches [][]kafka.Record
+	feed    chan api.Batch[kafka.Record]
+}
+
+func (l *memoryLog) Produce(_ context.Context, b api.Batch[kafka.Record]) error {
+	l.mu.Lock()
+	l.batches = append(l.batches, append([]kafka.Record(nil), b.Underlying()...))
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *memoryLog) Poll(ctx context.Context) (api.Batch[kafka.Record], error) {
+	select {
+	case b := <-l.feed:
+		return b, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *memoryLog) Close() error { return nil }
+
+func (l *memoryLog) produced() [][]kafka.Record {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([][]kafka.Record(nil), l.batches...)
+}
+
+func TestSliceBatch(t *testing.T) {
+	b := pool.NewSliceBatch[int](4)
+	for i := 0; i < 5; i++ {
+		b.Append(i)
+	}
+	first, second := b.Split(2)
+	if first.Len() != 2 || second.Len() != 3 || second.Get(0) != 2 {
+		t.Fatalf("Unexpected split: %v %v", first.Underlying(), second.Underlying())
+	}
+	if s := b.Slice(-1, 99); s.Len() != 5 {
+		t.Errorf("Expected clamped slice of 5, got %d", s.Len())
+	}
+	if b.Get(10) != 0 {
+		t.Error("Expected zero value for out-of-range Get")
+	}
+	b.Reset()
+	if b.Len() != 0 {
+		t.Error("Expected empty batch after Reset")
+	}
+}
+
+func TestKafkaSink(t *testing.T) {
+	broker := pubsub.New()
+	defer broker.Close()
+	log := &memoryLog{}
+	sink, err := kafka.NewSink(broker, log,
+		kafka.WithTopicPrefix("ws."),
+		kafka.WithPatterns("chat.*"),
+		kafka.WithBatchSize(3),
+		kafka.WithLinger(20*time.Millisecond),
+		kafka.WithKeyFunc(func(m pubsub.Message) []byte { return []byte(m.Topic) }),
+	)
+	if err != nil {
+		t.Fatalf("NewSink: %v", err)
+	}
+
+	for _, p := range []string{"a", "b", "c", "d"} {
+		broker.Publish("chat.lobby", []byte(p))
+	}
+	broker.Publish("users.x", []byte("ignored"))
+	broker.PublishMessage(pubsub.Message{Topic: "chat.lobby", Payload: []byte("loop"), Origin: kafka.Origin})
+	// Linger flushes the trailing record.
+	waitFor(t, time.Second, func() bool { return len(log.produced()) == 2 })
+	sink.Close()
+
+	got := log.produced()
+	if len(got) != 2 || len(got[0]) != 3 || len(got[1]) != 1 {
+		t.Fatalf("Expected batches of 3 and 1, got %v", got)
+	}
+	if r := got[0][0]; r.Topic != "ws.chat.lobby" || string(r.Value) != "a" || string(r.Key) != "chat.lobby" {
+		t.Errorf("Unexpected record %+v", r)
+	}
+}
+
+func TestKafkaSource(t *testing.T) {
+	broker := pubsub.New()
+	defer broker.Close()
+	log := &memoryLog{feed: make(chan api.Batch[kafka.Record], 1)}
+	src := kafka.NewSource(broker, log, kafka.WithTopicPrefix("ws."))
+	defer src.Close()
+
+	sub, _ := broker.Subscribe("orders.*")
+	batch := pool.NewSliceBatch[kafka.Record](2)
+	batch.Append(kafka.Record{Topic: "ws.orders.created", Value: []byte("o1")})
+	batch.Append(kafka.Record{Topic: "other.orders.created", Value: []byte("skip")})
+	log.feed <- batch
+
+	msg := expectMessage(t, sub, "o1")
+	if msg.Topic != "orders.created" || msg.Origin != kafka.Origin {
+		t.Errorf("Unexpected message %+v", msg)
+	}
+}
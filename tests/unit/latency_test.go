@@ -0,0 +1,133 @@
+// File: tests/unit/latency_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for latency histograms and their export through Stats.
+
+package unit
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/control"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// TestLatencyHistogram_Quantiles tests percentile accuracy over a uniform
+// 1..10000µs distribution.
+func TestLatencyHistogram_Quantiles(t *testing.T) {
+	h := control.NewLatencyHistogram()
+	for i := 1; i <= 10000; i++ {
+		h.Record(time.Duration(i) * time.Microsecond)
+	}
+	for _, tc := range []struct {
+		q    float64
+		want time.Duration
+	}{{.5, 5 * time.Millisecond}, {.95, 9500 * time.Microsecond}, {.99, 9900 * time.Microsecond}, {.999, 9990 * time.Microsecond}} {
+		got := h.Quantile(tc.q)
+		if diff := float64(got-tc.want) / float64(tc.want); diff < 0 || diff > 0.01 {
+			t.Errorf("Quantile(%v) = %v, want within 1%% above %v", tc.q, got, tc.want)
+		}
+	}
+	s := h.Snapshot()
+	if s.Count != 10000 || s.Min != time.Microsecond || s.Max != 10*time.Millisecond {
+		t.Errorf("Unexpected snapshot %+v", s)
+	}
+	if h.Quantile(1) != 10*time.Millisecond {
+		t.Errorf("Expected p100 capped at max, got %v", h.Quantile(1))
+	}
+
+	h.Record(-time.Second)
+	h.Record(time.Hour * 100) // beyond range, clamped into the last bucket
+	if h.Quantile(0) != 0 || h.Count() != 10002 {
+		t.Errorf("Expected negative value recorded as zero, got p0=%v count=%d", h.Quantile(0), h.Count())
+	}
+
+	h.Reset()
+	if h.Count() != 0 || h.Quantile(.99) != 0 {
+		t.Errorf("Expected empty histogram after Reset")
+	}
+}
+
+// TestServerLatencyStats tests that handshake, handler and send latencies are
+// recorded by the server and exported through Stats and Prometheus.
+func TestServerLatencyStats(t *testing.T) {
+	port := freePort(t)
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf(":%d", port)
+	cfg.ShutdownTimeout = 10 * time.Millisecond
+	srv, err := server.NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+	runServer(t, srv, api.HandlerFunc(func(data any) error {
+		evt, ok := data.(interface {
+			WSConnection() *protocol.WSConnection
+			GetBuffer() api.Buffer
+		})
+		if !ok {
+			return nil
+		}
+		defer evt.GetBuffer().Release()
+		time.Sleep(2 * time.Millisecond)
+		return srv.Sessions().Send(evt.WSConnection().Session().ID(), protocol.OpcodeText, []byte("pong"))
+	}))
+
+	conn, br, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	defer conn.Close()
+	data, _ := protocol.EncodeFrameToBytesWithMask(&protocol.WSFrame{
+		IsFinal: true, Opcode: protocol.OpcodeText, Payload: []byte("ping"), PayloadLen: 4,
+	}, true)
+	if _, err := conn.Write(data); err != nil {
+		t.Fatalf("Failed to write frame: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if frame, err := protocol.DecodeFrame(br); err != nil || string(frame.Payload) != "pong" {
+		t.Fatalf("Expected pong, got %v (err=%v)", frame, err)
+	}
+
+	// The send time is recorded once the write returns, possibly after the
+	// client has read the reply.
+	var stats map[string]any
+	waitFor(t, 200*time.Millisecond, func() bool {
+		stats = srv.GetControl().Stats()
+		return stats["debug.latency.send.count"] == uint64(1)
+	})
+	for _, key := range []string{"handshake", "handler", "send"} {
+		prefix := "debug.latency." + key
+		if n, _ := stats[prefix+".count"].(uint64); n != 1 {
+			t.Errorf("Expected %s.count 1, got %v", prefix, stats[prefix+".count"])
+		}
+		if d, _ := stats[prefix+".p99"].(time.Duration); d <= 0 {
+			t.Errorf("Expected positive %s.p99, got %v", prefix, stats[prefix+".p99"])
+		}
+	}
+	if d := stats["debug.latency.handler.p50"].(time.Duration); d < 2*time.Millisecond {
+		t.Errorf("Expected handler p50 >= 2ms, got %v", d)
+	}
+
+	h := control.NewLatencyHistogram()
+	h.Record(3 * time.Millisecond)
+	rec := httptest.NewRecorder()
+	control.PrometheusHandler(func() map[string]any { return map[string]any{"rtt": h} }).
+		ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE hioload_rtt summary\n",
+		`hioload_rtt{quantile="0.999"} 0.003` + "\n",
+		"hioload_rtt_sum 0.003\n",
+		"hioload_rtt_count 1\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Missing %q in:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// File: tests/unit/logging_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for per-subsystem logging levels.
+
+package unit
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"github.com/momentics/hioload-ws/adapters"
+	"github.com/momentics/hioload-ws/control"
+)
+
+// recordingLogger collects formatted records.
+type recordingLogger struct {
+	mu      sync.Mutex
+	records []string
+}
+
+func (r *recordingLogger) add(level, msg string, args []any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, fmt.Sprint(level, " ", msg, " ", args))
+}
+
+func (r *recordingLogger) Debug(msg string, args ...any) { r.add("DEBUG", msg, args) }
+func (r *recordingLogger) Info(msg string, args ...any)  { r.add("INFO", msg, args) }
+func (r *recordingLogger) Warn(msg string, args ...any)  { r.add("WARN", msg, args) }
+func (r *recordingLogger) Error(msg string, args ...any) { r.add("ERROR", msg, args) }
+
+func (r *recordingLogger) take() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := r.records
+	r.records = nil
+	return out
+}
+
+// TestSubsystemLogging tests level gating, overrides and runtime changes
+// through Control.SetConfig.
+func TestSubsystemLogging(t *testing.T) {
+	rec := &recordingLogger{}
+	control.SetLogger(rec)
+	t.Cleanup(func() {
+		control.SetLogger(nil)
+		control.SetLogLevel("", slog.LevelInfo)
+		control.ResetLogLevel(control.LogTransport)
+		control.ResetLogLevel("test")
+	})
+
+	log := control.Logger("test")
+	log.Debug("hidden")
+	log.Info("shown", "k", 1)
+	if got := rec.take(); len(got) != 1 || got[0] != "INFO shown [subsystem test k 1]" {
+		t.Fatalf("Unexpected records %q", got)
+	}
+
+	control.SetLogLevel("test", slog.LevelDebug)
+	log.Debug("now visible")
+	control.Logger(control.LogTransport).Debug("still hidden")
+	if got := rec.take(); len(got) != 1 || got[0] != "DEBUG now visible [subsystem test]" {
+		t.Fatalf("Unexpected records %q", got)
+	}
+
+	ctrl := adapters.NewControlAdapter()
+	if err := ctrl.SetConfig(map[string]any{"log.level": "error", "log.level.transport": "debug"}); err != nil {
+		t.Fatalf("SetConfig: %v", err)
+	}
+	control.Logger(control.LogTransport).Debug("transport debug")
+	control.Logger(control.LogServer).Warn("server warn")
+	if got := rec.take(); len(got) != 1 || got[0] != "DEBUG transport debug [subsystem transport]" {
+		t.Fatalf("Unexpected records %q", got)
+	}
+	if control.LogLevel(control.LogServer) != slog.LevelError {
+		t.Fatalf("Expected default level error, got %v", control.LogLevel(control.LogServer))
+	}
+	if err := ctrl.SetConfig(map[string]any{"log.level.server": "loud"}); err == nil {
+		t.Fatal("Expected error for invalid level name")
+	}
+}
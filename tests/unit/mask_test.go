@@ -0,0 +1,44 @@
+// File: tests/unit/mask_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for payload masking.
+
+package unit
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// TestMaskBytes tests the optimized routine against the RFC 6455 definition
+// for lengths around every block size and unaligned starts.
+func TestMaskBytes(t *testing.T) {
+	key := [4]byte{0xA1, 0xB2, 0xC3, 0xD4}
+	src := make([]byte, 1100)
+	for i := range src {
+		src[i] = byte(i * 7)
+	}
+	for _, off := range []int{0, 1, 3} {
+		for n := 0; n <= 1024; n++ {
+			if n > 300 && n%61 != 0 && n != 1024 {
+				continue
+			}
+			got := append([]byte(nil), src[off:off+n]...)
+			protocol.MaskBytes(got, key)
+			want := make([]byte, n)
+			for i := range want {
+				want[i] = src[off+i] ^ key[i%4]
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("Mask mismatch for %d bytes at offset %d", n, off)
+			}
+			protocol.MaskBytes(got, key)
+			if !bytes.Equal(got, src[off:off+n]) {
+				t.Fatalf("Masking twice did not restore %d bytes at offset %d", n, off)
+			}
+		}
+	}
+}
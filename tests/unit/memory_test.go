@@ -0,0 +1,82 @@
+// File: tests/unit/memory_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for per-connection memory accounting.
+
+package unit
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+)
+
+// TestConnectionMemory tests that buffers held by a handler and partial
+// frames are accounted to their connections.
+func TestConnectionMemory(t *testing.T) {
+	port := freePort(t)
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf(":%d", port)
+	cfg.ShutdownTimeout = 10 * time.Millisecond
+	srv, err := server.NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	held := make(chan api.Buffer, 1)
+	t.Cleanup(srv.Shutdown)
+	runServer(t, srv, api.HandlerFunc(func(data any) error {
+		if ev, ok := data.(interface{ GetBuffer() api.Buffer }); ok {
+			held <- ev.GetBuffer() // kept until the test releases it
+		}
+		return nil
+	}))
+
+	heavy, _, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	defer heavy.Close()
+	partial, _, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	defer partial.Close()
+	waitConns(t, srv, 2)
+
+	// A frame split across reads is gathered into a pooled buffer.
+	const size = 3000
+	big := maskedFrame(bytes.Repeat([]byte("x"), size))
+	stats := srv.GetControl().Stats
+	heavy.Write(big[:1000])
+	if !waitFor(t, time.Second, func() bool { return stats()["debug."+server.ProbeMemReadBuffer] == int64(1000-8) }) {
+		t.Fatalf("Expected the first part buffered, got %v", stats()["debug."+server.ProbeMemReadBuffer])
+	}
+	heavy.Write(big[1000:])
+	var buf api.Buffer
+	select {
+	case buf = <-held:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Handler did not receive the frame")
+	}
+	frame := maskedFrame(bytes.Repeat([]byte("y"), 1000))
+	partial.Write(frame[:508]) // 8 header bytes and 500 of payload
+
+	if !waitFor(t, time.Second, func() bool { return stats()["debug."+server.ProbeMemReadBuffer] == int64(500) }) {
+		t.Errorf("Expected 500 bytes buffered, got %v", stats()["debug."+server.ProbeMemReadBuffer])
+	}
+	if got := stats()["debug."+server.ProbeMemInFlight]; got != int64(size) {
+		t.Errorf("Expected %d bytes in flight, got %v", size, got)
+	}
+	if got := stats()["debug."+server.ProbeMemTotal]; got != int64(size+500) {
+		t.Errorf("Expected %d bytes in total, got %v", size+500, got)
+	}
+	top, _ := stats()["debug."+server.ProbeMemTop].([]server.ConnMemory)
+	if len(top) != 2 || top[0].InFlight != size || top[0].RemoteAddr != heavy.LocalAddr().String() || top[1].ReadBuffer != 500 {
+		t.Errorf("Unexpected heaviest connections: %+v", top)
+	}
+
+	buf.Release()
+	if got := stats()["debug."+server.ProbeMemInFlight]; got != int64(0) {
+		t.Errorf("Expected nothing in flight after release, got %v", got)
+	}
+}
@@ -0,0 +1,74 @@
+// File: tests/unit/message_middleware_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the per-message middleware chain.
+
+package unit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/momentics/hioload-ws/highlevel"
+)
+
+// TestMessageMiddleware tests counting, dropping and rewriting inbound
+// messages before the handler reads them.
+func TestMessageMiddleware(t *testing.T) {
+	var seen atomic.Int64
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf(":%d", port))
+	srv.UseMessage(
+		func(c *highlevel.Conn, mt highlevel.MessageType, p []byte, next func(highlevel.MessageType, []byte)) {
+			seen.Add(1)
+			next(mt, p)
+		},
+		func(c *highlevel.Conn, mt highlevel.MessageType, p []byte, next func(highlevel.MessageType, []byte)) {
+			if !bytes.Equal(p, []byte("spam")) {
+				next(mt, p)
+			}
+		},
+		func(c *highlevel.Conn, mt highlevel.MessageType, p []byte, next func(highlevel.MessageType, []byte)) {
+			switch {
+			case bytes.HasPrefix(p, []byte("cmd:")):
+				next(mt, p[4:]) // view into the pooled buffer
+			case bytes.HasPrefix(p, []byte("shout:")):
+				next(highlevel.BinaryMessage, bytes.ToUpper(p[6:])) // new payload
+			default:
+				next(mt, p)
+			}
+		},
+	)
+	srv.HandleFunc("/mw", func(c *highlevel.Conn) {
+		for {
+			mt, msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			c.WriteString(fmt.Sprintf("%d %s", mt, msg))
+		}
+	})
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	conn, err := highlevel.Dial(fmt.Sprintf("ws://localhost:%d/mw", port))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	for _, msg := range []string{"plain", "spam", "cmd:run", "shout:hey"} {
+		conn.WriteString(msg)
+	}
+	for _, want := range []string{"1 plain", "1 run", "2 HEY"} {
+		if _, msg, err := conn.ReadMessage(); err != nil || string(msg) != want {
+			t.Fatalf("Expected %q, got %q (err=%v)", want, msg, err)
+		}
+	}
+	if n := seen.Load(); n != 4 {
+		t.Errorf("Expected 4 messages through the chain, got %d", n)
+	}
+}
@@ -0,0 +1,68 @@
+// File: tests/unit/message_type_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for message types surviving the receive path.
+
+package unit
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/momentics/hioload-ws/highlevel"
+)
+
+// TestMessageTypeRoundTrip tests that text and binary frames are reported
+// with their own type on both the server and the client side.
+func TestMessageTypeRoundTrip(t *testing.T) {
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf(":%d", port))
+	srv.HandleFunc("/echo", func(c *highlevel.Conn) {
+		for {
+			mt, msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			c.WriteMessage(mt, append([]byte(fmt.Sprintf("%d:", mt)), msg...))
+		}
+	})
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	conn, err := highlevel.Dial(fmt.Sprintf("ws://localhost:%d/echo", port))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	for _, tc := range []struct {
+		mt   highlevel.MessageType
+		want string
+	}{
+		{highlevel.TextMessage, "1:text"},
+		{highlevel.BinaryMessage, "2:binary"},
+		{highlevel.TextMessage, "1:again"},
+	} {
+		payload := tc.want[2:]
+		if err := conn.WriteMessage(int(tc.mt), []byte(payload)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		mt, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if highlevel.MessageType(mt) != tc.mt || string(msg) != tc.want {
+			t.Errorf("Expected type %d %q, got type %d %q", tc.mt, tc.want, mt, msg)
+		}
+	}
+
+	if err := conn.WriteJSON(map[string]int{"n": 1}); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	mt, msg, err := conn.ReadMessage()
+	if err != nil || highlevel.MessageType(mt) != highlevel.TextMessage || string(msg) != `1:{"n":1}` {
+		t.Errorf("Expected JSON echoed as text, got type %d %q (err=%v)", mt, msg, err)
+	}
+}
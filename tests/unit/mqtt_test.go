@@ -0,0 +1,196 @@
+// File: tests/unit/mqtt_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the MQTT-over-WebSocket packet layer and broker session.
+
+package unit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/momentics/hioload-ws/highlevel"
+	"github.com/momentics/hioload-ws/mqtt"
+	"github.com/momentics/hioload-ws/pubsub"
+)
+
+// TestMQTTCodec tests encode/decode round trips and stream reassembly.
+func TestMQTTCodec(t *testing.T) {
+	packets := []mqtt.Packet{
+		&mqtt.Connect{
+			ProtocolName: mqtt.ProtocolName, ProtocolLevel: mqtt.ProtocolLevel,
+			CleanSession: true, KeepAlive: 30, ClientID: "dev-1",
+			WillTopic: "devices/dev-1/state", WillMessage: []byte("offline"), WillQoS: 1,
+			Username: "user", Password: []byte("secret"), HasUsername: true, HasPassword: true,
+		},
+		&mqtt.Publish{Topic: "a/b", PacketID: 7, QoS: 1, Payload: make([]byte, 300)},
+		&mqtt.Subscribe{PacketID: 8, Filters: []mqtt.TopicFilter{{Filter: "a/+", QoS: 1}, {Filter: "b/#"}}},
+		&mqtt.SubAck{PacketID: 8, ReturnCodes: []byte{0, mqtt.SubAckFailure}},
+		&mqtt.Unsubscribe{PacketID: 9, Filters: []string{"a/+"}},
+		&mqtt.Ack{Kind: mqtt.TypeUnsubAck, PacketID: 9},
+		&mqtt.PingReq{},
+		&mqtt.Disconnect{},
+	}
+	var stream []byte
+	for _, p := range packets {
+		var err error
+		if stream, err = mqtt.Append(stream, p); err != nil {
+			t.Fatalf("Append(%T): %v", p, err)
+		}
+	}
+
+	if _, _, err := mqtt.Decode(stream[:3]); !errors.Is(err, mqtt.ErrIncomplete) {
+		t.Fatalf("Expected ErrIncomplete for a partial packet, got %v", err)
+	}
+
+	// Feed one byte at a time: packets must come out whole and in order.
+	dec := mqtt.NewDecoder(0)
+	var got []mqtt.Packet
+	for i := range stream {
+		dec.Feed(stream[i : i+1])
+		for {
+			p, err := dec.Next()
+			if err != nil {
+				t.Fatalf("Next: %v", err)
+			}
+			if p == nil {
+				break
+			}
+			if pub, ok := p.(*mqtt.Publish); ok {
+				pub.Payload = append([]byte(nil), pub.Payload...) // outlive the next Feed
+			}
+			got = append(got, p)
+		}
+	}
+	if !reflect.DeepEqual(got, packets) {
+		t.Fatalf("Round trip mismatch:\n got %#v\nwant %#v", got, packets)
+	}
+
+	small := mqtt.NewDecoder(64)
+	small.Feed(stream)
+	small.Next() // CONNECT fits
+	if _, err := small.Next(); !errors.Is(err, mqtt.ErrTooLarge) {
+		t.Fatalf("Expected ErrTooLarge for the 300-byte PUBLISH, got %v", err)
+	}
+}
+
+// TestMQTTTopicMapping tests translation between MQTT and pubsub topics.
+func TestMQTTTopicMapping(t *testing.T) {
+	if topic, ok := mqtt.ToPubSubTopic("sensors/k1/temp"); !ok || topic != "sensors.k1.temp" {
+		t.Fatalf("ToPubSubTopic = %q, %v", topic, ok)
+	}
+	for _, bad := range []string{"a/+/b", "a.b/c", "/a", "a//b"} {
+		if _, ok := mqtt.ToPubSubTopic(bad); ok {
+			t.Errorf("ToPubSubTopic(%q) should fail", bad)
+		}
+	}
+	cases := map[string][]string{
+		"sensors/+/temp": {"sensors.*.temp"},
+		"sensors/#":      {"sensors", "sensors.>"},
+		"#":              {">"},
+	}
+	for filter, want := range cases {
+		if got, ok := mqtt.ToPubSubPatterns(filter); !ok || !reflect.DeepEqual(got, want) {
+			t.Errorf("ToPubSubPatterns(%q) = %v, %v; want %v", filter, got, ok, want)
+		}
+	}
+	if _, ok := mqtt.ToPubSubPatterns("a/#/b"); ok {
+		t.Error("ToPubSubPatterns should reject '#' before the last level")
+	}
+}
+
+// TestMQTTServe tests a full MQTT session over a negotiated WebSocket.
+func TestMQTTServe(t *testing.T) {
+	broker := pubsub.New()
+	defer broker.Close()
+
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf(":%d", port), highlevel.WithSubprotocols(mqtt.Subprotocol))
+	srv.HandleFunc("/mqtt", func(c *highlevel.Conn) {
+		if c.Subprotocol() != mqtt.Subprotocol {
+			c.Close()
+			return
+		}
+		mqtt.Serve(c, broker, mqtt.WithAuthenticator(func(cp *mqtt.Connect) byte {
+			if string(cp.Password) != "secret" {
+				return mqtt.RefusedBadCredentials
+			}
+			return mqtt.Accepted
+		}))
+	})
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	opts := highlevel.DefaultOptions()
+	opts.Subprotocols = []string{mqtt.Subprotocol}
+	conn, err := highlevel.DialWithOptions(fmt.Sprintf("ws://localhost:%d/mqtt", port), opts)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	if conn.Subprotocol() != mqtt.Subprotocol {
+		t.Fatalf("Expected negotiated subprotocol %q, got %q", mqtt.Subprotocol, conn.Subprotocol())
+	}
+
+	dec := mqtt.NewDecoder(0)
+	send := func(p mqtt.Packet) {
+		t.Helper()
+		data, err := mqtt.Append(nil, p)
+		if err != nil {
+			t.Fatalf("Append(%T): %v", p, err)
+		}
+		if err := conn.WriteMessage(int(highlevel.BinaryMessage), data); err != nil {
+			t.Fatalf("WriteMessage: %v", err)
+		}
+	}
+	recv := func() mqtt.Packet {
+		t.Helper()
+		for {
+			if p, err := dec.Next(); err != nil || p != nil {
+				if err != nil {
+					t.Fatalf("Decode: %v", err)
+				}
+				return p
+			}
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatalf("ReadMessage: %v", err)
+			}
+			dec.Feed(data)
+		}
+	}
+
+	send(&mqtt.Connect{CleanSession: true, ClientID: "dev-1", Password: []byte("secret")})
+	if ack, ok := recv().(*mqtt.ConnAck); !ok || ack.ReturnCode != mqtt.Accepted {
+		t.Fatalf("Expected accepted CONNACK, got %#v", ack)
+	}
+
+	send(&mqtt.Subscribe{PacketID: 1, Filters: []mqtt.TopicFilter{{Filter: "sensors/+/temp"}, {Filter: "bad/#/x"}}})
+	if ack, ok := recv().(*mqtt.SubAck); !ok || !reflect.DeepEqual(ack.ReturnCodes, []byte{0, mqtt.SubAckFailure}) {
+		t.Fatalf("Unexpected SUBACK %#v", ack)
+	}
+
+	broker.Publish("sensors.k1.temp", []byte("21.5"))
+	if pub, ok := recv().(*mqtt.Publish); !ok || pub.Topic != "sensors/k1/temp" || string(pub.Payload) != "21.5" {
+		t.Fatalf("Unexpected PUBLISH %#v", pub)
+	}
+
+	sub, _ := broker.Subscribe("devices.>")
+	defer sub.Unsubscribe()
+	send(&mqtt.Publish{Topic: "devices/d1/state", QoS: 1, PacketID: 5, Payload: []byte("on")})
+	if ack, ok := recv().(*mqtt.Ack); !ok || ack.Kind != mqtt.TypePubAck || ack.PacketID != 5 {
+		t.Fatalf("Expected PUBACK 5, got %#v", ack)
+	}
+	if msg := expectMessage(t, sub, "on"); msg.Topic != "devices.d1.state" {
+		t.Fatalf("Expected topic devices.d1.state, got %q", msg.Topic)
+	}
+
+	send(&mqtt.PingReq{})
+	if _, ok := recv().(*mqtt.PingResp); !ok {
+		t.Fatal("Expected PINGRESP")
+	}
+}
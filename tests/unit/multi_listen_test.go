@@ -0,0 +1,189 @@
+// File: tests/unit/multi_listen_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for serving one server on several addresses.
+
+package unit
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/highlevel"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// selfSignedTLS returns a server TLS config with a throwaway certificate.
+func selfSignedTLS(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+// echoOver upgrades conn on path, sends msg and returns the echoed payload.
+func echoOver(t *testing.T, conn net.Conn, path, msg string) string {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	req, _ := http.NewRequest("GET", "http://localhost"+path, nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("Failed to write upgrade: %v", err)
+	}
+	br := bufio.NewReader(conn)
+	if resp, err := http.ReadResponse(br, req); err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Upgrade failed: %v %v", resp, err)
+	}
+	// Masked text frame with a zero mask key.
+	frame := append([]byte{0x81, 0x80 | byte(len(msg)), 0, 0, 0, 0}, msg...)
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatalf("Failed to send frame: %v", err)
+	}
+	reply, err := protocol.DecodeFrame(br)
+	if err != nil {
+		t.Fatalf("Failed to read echo: %v", err)
+	}
+	return string(reply.Payload)
+}
+
+// serve runs srv.ListenAndServe in the background and returns once srv
+// accepts connections.
+func serve(t *testing.T, srv *highlevel.Server) {
+	t.Helper()
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+	select {
+	case <-srv.Ready():
+	case err := <-errc:
+		t.Fatalf("ListenAndServe: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server not ready")
+	}
+}
+
+// runServer runs srv.Run with handler in the background and returns once
+// srv accepts connections.
+func runServer(t *testing.T, srv *server.Server, handler api.Handler) {
+	t.Helper()
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Run(handler) }()
+	select {
+	case <-srv.Ready():
+	case err := <-errc:
+		t.Fatalf("Run: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server not ready")
+	}
+}
+
+// TestMultipleListeners tests one server serving TCP, a Unix socket and TLS
+// with the same routes.
+func TestMultipleListeners(t *testing.T) {
+	dir, err := os.MkdirTemp("", "hws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "ws.sock")
+
+	plain, second, secure := freePort(t), freePort(t), freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf("127.0.0.1:%d", plain),
+		highlevel.WithListen(fmt.Sprintf("127.0.0.1:%d", second), "unix:"+sock),
+		highlevel.WithTLSListen(fmt.Sprintf("127.0.0.1:%d", secure), selfSignedTLS(t)),
+	)
+	srv.HandleFunc("/echo", func(c *highlevel.Conn) {
+		for {
+			mt, msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			c.WriteMessage(mt, msg)
+		}
+	})
+	serve(t, srv)
+	defer srv.Shutdown(context.Background())
+
+	if addrs := srv.Addrs(); len(addrs) != 4 || addrs[0].String() != fmt.Sprintf("127.0.0.1:%d", plain) || addrs[2].Network() != "unix" {
+		t.Errorf("Unexpected listen addresses %v", addrs)
+	}
+
+	for _, port := range []int{plain, second} {
+		conn, err := highlevel.Dial(fmt.Sprintf("ws://127.0.0.1:%d/echo", port))
+		if err != nil {
+			t.Fatalf("Failed to dial :%d: %v", port, err)
+		}
+		defer conn.Close()
+		conn.WriteString("tcp")
+		if _, msg, err := conn.ReadMessage(); err != nil || string(msg) != "tcp" {
+			t.Errorf("Expected echo on :%d, got %q (err=%v)", port, msg, err)
+		}
+	}
+	if !waitFor(t, 2*time.Second, func() bool { return srv.GetActiveConnections() == 2 }) {
+		t.Errorf("Expected both TCP connections tracked by one server, got %d", srv.GetActiveConnections())
+	}
+
+	uc, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatalf("Failed to dial the Unix socket: %v", err)
+	}
+	if got := echoOver(t, uc, "/echo", "unix"); got != "unix" {
+		t.Errorf("Expected echo over the Unix socket, got %q", got)
+	}
+
+	tc, err := tls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", secure), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("Failed to dial TLS: %v", err)
+	}
+	if got := echoOver(t, tc, "/echo", "tls"); got != "tls" {
+		t.Errorf("Expected echo over TLS, got %q", got)
+	}
+}
+
+// TestEndpointsFailure tests that a failing endpoint releases the listeners
+// opened before it.
+func TestEndpointsFailure(t *testing.T) {
+	port := freePort(t)
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf("127.0.0.1:%d", port)
+	_, err := server.NewServer(cfg, server.WithEndpoints(server.Endpoint{Addr: "unix:/nonexistent/dir/ws.sock"}))
+	if err == nil {
+		t.Fatal("Expected an error for an unusable endpoint")
+	}
+	l, err := net.Listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		t.Fatalf("Expected the primary listener closed, got %v", err)
+	}
+	l.Close()
+}
@@ -0,0 +1,152 @@
+// File: tests/unit/mux_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for logical channel multiplexing with highlevel.Mux.
+
+package unit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/highlevel"
+)
+
+// muxServer serves /mux, echoing on every channel the client opens except
+// channel 3, which is never read. It reports channels whose reads end.
+func muxServer(t *testing.T) (int, <-chan uint32) {
+	port := freePort(t)
+	closed := make(chan uint32, 8)
+	srv := highlevel.NewServer(fmt.Sprintf(":%d", port))
+	srv.HandleFunc("/mux", func(c *highlevel.Conn) {
+		m := highlevel.NewMux(c, 1024)
+		for {
+			ch, err := m.Accept()
+			if err != nil {
+				return
+			}
+			id, _ := ch.Channel()
+			if id == 3 {
+				continue
+			}
+			go func() {
+				for {
+					mt, data, err := ch.ReadMessage()
+					if err != nil {
+						closed <- id
+						return
+					}
+					ch.WriteMessage(mt, append([]byte(fmt.Sprintf("%d:", id)), data...))
+				}
+			}()
+		}
+	})
+	t.Cleanup(func() { srv.Shutdown(context.Background()) })
+	serve(t, srv)
+	return port, closed
+}
+
+// TestMuxChannels tests that channels carry independent message streams of
+// both types and that closing one tells the peer.
+func TestMuxChannels(t *testing.T) {
+	port, closed := muxServer(t)
+	conn, err := highlevel.Dial(fmt.Sprintf("ws://127.0.0.1:%d/mux", port))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	m := highlevel.NewMux(conn, 0)
+	defer m.Close()
+
+	a, err := m.Open(1)
+	if err != nil {
+		t.Fatalf("Open(1): %v", err)
+	}
+	b, _ := m.Open(2)
+	if _, err := m.Open(1); err != highlevel.ErrChannelInUse {
+		t.Errorf("Expected ErrChannelInUse, got %v", err)
+	}
+	b.WriteMessage(int(highlevel.BinaryMessage), []byte{0xB6, 1, 2})
+	a.WriteMessage(int(highlevel.TextMessage), []byte("hello"))
+	if mt, data, err := a.ReadMessage(); err != nil || mt != int(highlevel.TextMessage) || string(data) != "1:hello" {
+		t.Errorf("Channel 1 got %d %q, %v", mt, data, err)
+	}
+	if mt, data, err := b.ReadMessage(); err != nil || mt != int(highlevel.BinaryMessage) || !bytes.Equal(data, []byte("2:\xB6\x01\x02")) {
+		t.Errorf("Channel 2 got %d %q, %v", mt, data, err)
+	}
+	if err := a.WriteMessage(int(highlevel.PingMessage), nil); err != highlevel.ErrChannelMessageType {
+		t.Errorf("Expected ErrChannelMessageType for a ping, got %v", err)
+	}
+
+	// Several times the server's window: credit must be returned as it reads.
+	chunk := bytes.Repeat([]byte("x"), 1024)
+	for i := 0; i < 6; i++ {
+		if err := a.WriteMessage(int(highlevel.BinaryMessage), chunk); err != nil {
+			t.Fatalf("Write %d: %v", i, err)
+		}
+		if _, data, err := a.ReadMessage(); err != nil || len(data) != len(chunk)+2 {
+			t.Fatalf("Echo %d: %d bytes, %v", i, len(data), err)
+		}
+	}
+
+	b.Close()
+	select {
+	case id := <-closed:
+		if id != 2 {
+			t.Errorf("Expected channel 2 closed, got %d", id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Server did not see the channel close")
+	}
+	if _, _, err := b.ReadMessage(); err == nil {
+		t.Error("Expected reads on a closed channel to fail")
+	}
+	a.WriteMessage(int(highlevel.TextMessage), []byte("still"))
+	if _, data, err := a.ReadMessage(); err != nil || string(data) != "1:still" {
+		t.Errorf("Expected channel 1 unaffected, got %q, %v", data, err)
+	}
+}
+
+// TestMuxFlowControl tests that a channel whose reader stalls stops its
+// writer once the peer's window is used up, without blocking other channels.
+func TestMuxFlowControl(t *testing.T) {
+	port, _ := muxServer(t)
+	conn, err := highlevel.Dial(fmt.Sprintf("ws://127.0.0.1:%d/mux", port))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	m := highlevel.NewMux(conn, 0)
+	defer m.Close()
+	stalled, _ := m.Open(3)
+	live, _ := m.Open(1)
+
+	big := make([]byte, 2048)
+	if err := stalled.WriteMessage(int(highlevel.BinaryMessage), big); err != nil {
+		t.Fatalf("First write: %v", err)
+	}
+	blocked := make(chan error, 1)
+	go func() { blocked <- stalled.WriteMessage(int(highlevel.BinaryMessage), big) }()
+	select {
+	case err := <-blocked:
+		t.Fatalf("Expected the write past the window to block, got %v", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	live.WriteMessage(int(highlevel.TextMessage), []byte("flowing"))
+	if _, data, err := live.ReadMessage(); err != nil || string(data) != "1:flowing" {
+		t.Errorf("Expected the other channel to flow, got %q, %v", data, err)
+	}
+
+	stalled.Close()
+	select {
+	case err := <-blocked:
+		if err != highlevel.ErrClosed {
+			t.Errorf("Expected ErrClosed for the blocked write, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Blocked write not released by Close")
+	}
+}
@@ -0,0 +1,145 @@
+// File: tests/unit/nats_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the NATS adapter against a minimal in-process server.
+
+package unit
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	natsadapter "github.com/momentics/hioload-ws/adapters/nats"
+	"github.com/momentics/hioload-ws/pubsub"
+)
+
+// fakeNATS routes PUB to matching SUBs of other connections (echo disabled).
+type fakeNATS struct {
+	ln   net.Listener
+	mu   sync.Mutex
+	subs map[net.Conn]map[string]string // conn -> sid -> subject
+}
+
+func startFakeNATS(t *testing.T) *fakeNATS {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	f := &fakeNATS{ln: ln, subs: map[net.Conn]map[string]string{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return f
+}
+
+func (f *fakeNATS) serve(conn net.Conn) {
+	defer conn.Close()
+	f.mu.Lock()
+	f.subs[conn] = map[string]string{}
+	f.mu.Unlock()
+	fmt.Fprint(conn, "INFO {\"server_id\":\"fake\"}\r\n")
+	rd := bufio.NewReader(conn)
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "PING":
+			fmt.Fprint(conn, "PONG\r\n")
+		case "SUB":
+			f.mu.Lock()
+			f.subs[conn][fields[2]] = fields[1]
+			f.mu.Unlock()
+		case "PUB":
+			n, _ := strconv.Atoi(fields[2])
+			body := make([]byte, n+2)
+			io.ReadFull(rd, body)
+			f.publish(conn, fields[1], body[:n])
+		}
+	}
+}
+
+// publish delivers to every other connection subscribed to a matching subject.
+func (f *fakeNATS) publish(from net.Conn, subject string, payload []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for conn, sids := range f.subs {
+		if conn == from {
+			continue
+		}
+		for sid, pattern := range sids {
+			if pubsub.Match(pattern, subject) {
+				fmt.Fprintf(conn, "MSG %s %s %d\r\n%s\r\n", subject, sid, len(payload), payload)
+			}
+		}
+	}
+}
+
+func TestNATSAdapter(t *testing.T) {
+	f := startFakeNATS(t)
+	broker := pubsub.New()
+	defer broker.Close()
+
+	a, err := natsadapter.New(f.ln.Addr().String(), broker,
+		natsadapter.WithSubjectPrefix("ws."),
+		natsadapter.WithInbound("orders.>"),
+		natsadapter.WithOutbound("chat.*"),
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer a.Close()
+
+	// A plain backbone client on the same server.
+	peer, err := net.Dial("tcp", f.ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer peer.Close()
+	prd := bufio.NewReader(peer)
+	prd.ReadString('\n') // INFO
+	fmt.Fprint(peer, "CONNECT {}\r\nSUB ws.chat.* 1\r\nPING\r\n")
+	prd.ReadString('\n') // PONG
+
+	// NATS -> WebSocket topic.
+	sub, _ := broker.Subscribe("orders.*")
+	fmt.Fprint(peer, "PUB ws.orders.created 2\r\no1\r\n")
+	msg := expectMessage(t, sub, "o1")
+	if msg.Topic != "orders.created" || msg.Origin != natsadapter.Origin {
+		t.Errorf("Unexpected inbound message %+v", msg)
+	}
+
+	// WebSocket topic -> NATS.
+	broker.Publish("chat.lobby", []byte("hi"))
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	line, err := prd.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "MSG ws.chat.lobby 1 2") {
+		t.Fatalf("Expected MSG on ws.chat.lobby, got %q (err=%v)", line, err)
+	}
+	if body, _ := prd.ReadString('\n'); body != "hi\r\n" {
+		t.Errorf("Expected payload hi, got %q", body)
+	}
+	if received, sent := a.Stats(); received != 1 || sent != 1 {
+		t.Errorf("Expected 1/1 messages, got %d/%d", received, sent)
+	}
+}
@@ -0,0 +1,90 @@
+// File: tests/unit/numa_acceptors_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for one acceptor, reactor and buffer pool per NUMA node.
+
+package unit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/highlevel"
+	"github.com/momentics/hioload-ws/internal/concurrency"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+)
+
+// TestNUMAAcceptors tests that every node gets a listener on the shared
+// port, and that connections landing on any of them are served.
+func TestNUMAAcceptors(t *testing.T) {
+	dir, err := os.MkdirTemp("", "hws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "ws.sock")
+
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf("127.0.0.1:%d", port),
+		highlevel.WithListen("unix:"+sock),
+		highlevel.WithNUMAAcceptors(0, 1),
+	)
+	srv.HandleFunc("/echo", func(c *highlevel.Conn) {
+		for {
+			mt, msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			c.WriteMessage(mt, msg)
+		}
+	})
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	addrs := srv.Addrs()
+	if len(addrs) != 3 || addrs[0].String() != addrs[1].String() || addrs[2].Network() != "unix" {
+		t.Fatalf("Expected two TCP listeners on one port and one Unix socket, got %v", addrs)
+	}
+
+	// The kernel spreads connections over the group, so some reach each node.
+	for i := 0; i < 6; i++ {
+		conn, err := highlevel.Dial(fmt.Sprintf("ws://127.0.0.1:%d/echo", port))
+		if err != nil {
+			t.Fatalf("Dial %d: %v", i, err)
+		}
+		defer conn.Close()
+		msg := fmt.Sprint("node test ", i)
+		conn.WriteString(msg)
+		if _, got, err := conn.ReadMessage(); err != nil || string(got) != msg {
+			t.Fatalf("Echo %d: %q, %v", i, got, err)
+		}
+	}
+}
+
+// TestNUMAAcceptorsConfig tests that Config.NUMAAcceptors opens one
+// listener per node of the machine and that the reactors report healthy.
+func TestNUMAAcceptorsConfig(t *testing.T) {
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = "127.0.0.1:0"
+	cfg.NUMAAcceptors = true
+	srv, err := server.NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	if n := len(srv.Addrs()); n != concurrency.NUMANodes() {
+		t.Errorf("Expected %d listeners, got %d", concurrency.NUMANodes(), n)
+	}
+	defer srv.Shutdown()
+	runServer(t, srv, api.HandlerFunc(func(any) error { return nil }))
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if res := srv.Health().Live(ctx); res.Checks[server.HealthReactor] != "ok" {
+		t.Errorf("Expected the reactors live, got %v", res.Checks)
+	}
+}
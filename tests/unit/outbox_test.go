@@ -0,0 +1,146 @@
+// File: tests/unit/outbox_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for outbound queue limits and slow-consumer policies.
+
+package unit
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+	"github.com/momentics/hioload-ws/pool"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// stalledTransport models a peer that stopped reading: Send blocks until the
+// transport is closed.
+type stalledTransport struct {
+	sending   chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newStalledTransport() *stalledTransport {
+	return &stalledTransport{sending: make(chan struct{}, 1), closed: make(chan struct{})}
+}
+
+func (t *stalledTransport) Send([][]byte) error {
+	select {
+	case t.sending <- struct{}{}:
+	default:
+	}
+	<-t.closed
+	return api.ErrTransportClosed
+}
+
+func (t *stalledTransport) Recv() ([][]byte, error) {
+	<-t.closed
+	return nil, api.ErrTransportClosed
+}
+
+func (t *stalledTransport) Close() error {
+	t.closeOnce.Do(func() { close(t.closed) })
+	return nil
+}
+
+func (t *stalledTransport) Features() api.TransportFeatures { return api.TransportFeatures{} }
+
+// stalledConn returns a connection whose send loop is stuck writing one frame
+// and whose outbox holds HighWater more, so the next SendFrame overflows.
+func stalledConn(t *testing.T, limit protocol.OutboxLimit) *protocol.WSConnection {
+	tr := newStalledTransport()
+	conn := protocol.NewWSConnection(tr, pool.NewBufferPoolManager(0).GetPool(1024, 0), 64)
+	conn.SetOutboxLimit(limit)
+	t.Cleanup(func() { conn.Close() })
+
+	frame := func() *protocol.WSFrame {
+		return &protocol.WSFrame{IsFinal: true, Opcode: protocol.OpcodeBinary, Payload: []byte("x"), PayloadLen: 1}
+	}
+	if err := conn.SendFrame(frame()); err != nil {
+		t.Fatalf("First send failed: %v", err)
+	}
+	select {
+	case <-tr.sending:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Send loop did not start")
+	}
+	for i := 0; i < limit.HighWater; i++ {
+		if err := conn.SendFrame(frame()); err != nil {
+			t.Fatalf("Send %d below the high-water mark failed: %v", i, err)
+		}
+	}
+	if depth := conn.GetStats()["outbox_depth"]; depth != int64(limit.HighWater) {
+		t.Fatalf("Expected outbox depth %d, got %d", limit.HighWater, depth)
+	}
+	return conn
+}
+
+func overflowFrame() *protocol.WSFrame {
+	return &protocol.WSFrame{IsFinal: true, Opcode: protocol.OpcodeBinary, Payload: []byte("y"), PayloadLen: 1}
+}
+
+// TestOutboxLimit_Block tests that a full outbox fails sends after Wait.
+func TestOutboxLimit_Block(t *testing.T) {
+	conn := stalledConn(t, protocol.OutboxLimit{HighWater: 2, Wait: 50 * time.Millisecond})
+	start := time.Now()
+	if err := conn.SendFrame(overflowFrame()); !errors.Is(err, protocol.ErrOutboxFull) {
+		t.Fatalf("Expected ErrOutboxFull, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected the send to wait, returned after %v", elapsed)
+	}
+}
+
+// TestOutboxLimit_DropOldest tests that the oldest queued frame makes room.
+func TestOutboxLimit_DropOldest(t *testing.T) {
+	conn := stalledConn(t, protocol.OutboxLimit{HighWater: 2, Policy: protocol.SlowConsumerDropOldest})
+	if err := conn.SendFrame(overflowFrame()); err != nil {
+		t.Fatalf("Expected the frame queued, got %v", err)
+	}
+	stats := conn.GetStats()
+	if stats["frames_dropped"] != 1 || stats["outbox_depth"] != 2 {
+		t.Errorf("Expected one dropped frame and depth 2, got %v", stats)
+	}
+}
+
+// TestOutboxLimit_Close tests that a slow consumer is closed with the
+// configured code.
+func TestOutboxLimit_Close(t *testing.T) {
+	conn := stalledConn(t, protocol.OutboxLimit{
+		HighWater: 2,
+		Policy:    protocol.SlowConsumerClose,
+		CloseCode: protocol.ClosePolicyViolation,
+	})
+	if err := conn.SendFrame(overflowFrame()); !errors.Is(err, protocol.ErrOutboxFull) {
+		t.Fatalf("Expected ErrOutboxFull, got %v", err)
+	}
+	select {
+	case <-conn.Done():
+	case <-time.After(3 * time.Second):
+		t.Fatal("Slow consumer not closed")
+	}
+	if code, reason, ok := conn.CloseStatus(); !ok || code != protocol.ClosePolicyViolation || reason != "slow consumer" {
+		t.Errorf("Expected close 1008 slow consumer, got %d %q %v", code, reason, ok)
+	}
+}
+
+// TestOutboxLimit_Config tests the config file keys.
+func TestOutboxLimit_Config(t *testing.T) {
+	cfg := server.DefaultConfig()
+	err := server.ApplyConfig(cfg, map[string]any{
+		"server.outbox_high_water": int64(16),
+		"slow_consumer":            "close",
+		"slow_consumer_wait":       "250ms",
+		"slow_consumer_close_code": int64(1008),
+	})
+	want := protocol.OutboxLimit{HighWater: 16, Policy: protocol.SlowConsumerClose, Wait: 250 * time.Millisecond, CloseCode: 1008}
+	if err != nil || cfg.Outbox != want {
+		t.Errorf("Expected %+v, got %+v (err=%v)", want, cfg.Outbox, err)
+	}
+}
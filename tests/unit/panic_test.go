@@ -0,0 +1,145 @@
+// File: tests/unit/panic_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for handler panic recovery and the OnPanic hooks.
+
+package unit
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/highlevel"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// TestServerPanicRecovery tests that a handler panic on the reactor closes
+// only its connection with 1011, reaches OnPanic with the connection and
+// stack, is counted, and leaves the reactor serving other connections.
+func TestServerPanicRecovery(t *testing.T) {
+	port := freePort(t)
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf("127.0.0.1:%d", port)
+	cfg.ShutdownTimeout = 10 * time.Millisecond
+	srv, err := server.NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	type panicked struct {
+		conn      *protocol.WSConnection
+		recovered any
+		stack     []byte
+	}
+	panics := make(chan panicked, 4)
+	srv.OnPanic(func(conn *protocol.WSConnection, recovered any, stack []byte) {
+		panics <- panicked{conn, recovered, stack}
+	})
+	handled := make(chan string, 4)
+	t.Cleanup(srv.Shutdown)
+	runServer(t, srv, api.HandlerFunc(func(data any) error {
+		b, ok := data.(interface{ GetBuffer() api.Buffer })
+		if !ok {
+			return nil
+		}
+		buf := b.GetBuffer()
+		msg := string(buf.Data)
+		buf.Release()
+		if msg == "boom" {
+			panic("boom")
+		}
+		handled <- msg
+		return nil
+	}))
+
+	bad, br, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	defer bad.Close()
+	bad.Write(maskedFrame([]byte("boom")))
+	select {
+	case p := <-panics:
+		if p.conn == nil || p.recovered != "boom" || !strings.Contains(string(p.stack), "panic_test.go") {
+			t.Errorf("Unexpected OnPanic call: conn %v, recovered %v", p.conn, p.recovered)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnPanic not called")
+	}
+	bad.SetReadDeadline(time.Now().Add(2 * time.Second))
+	frame, err := protocol.DecodeFrame(br)
+	if err != nil || frame.Opcode != protocol.OpcodeClose || len(frame.Payload) < 2 {
+		t.Fatalf("Expected close frame, got %+v, %v", frame, err)
+	}
+	if code := binary.BigEndian.Uint16(frame.Payload); code != protocol.CloseInternalServerErr {
+		t.Errorf("Expected close code 1011, got %d", code)
+	}
+
+	good, _, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	defer good.Close()
+	good.Write(maskedFrame([]byte("hello")))
+	select {
+	case msg := <-handled:
+		if msg != "hello" {
+			t.Errorf("Expected hello, got %q", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("reactor stopped after a handler panic")
+	}
+
+	if n := srv.GetControl().Stats()["debug."+server.ProbeHandlerPanics]; n != uint64(1) {
+		t.Errorf("Expected %s = 1, got %v", server.ProbeHandlerPanics, n)
+	}
+}
+
+// TestHighlevelOnPanic tests that a panicking route handler closes its
+// connection with 1011 and reaches OnPanic instead of the process.
+func TestHighlevelOnPanic(t *testing.T) {
+	type panicked struct {
+		room      string
+		recovered any
+	}
+	panics := make(chan panicked, 4)
+
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf(":%d", port))
+	srv.OnPanic(func(c *highlevel.Conn, recovered any, stack []byte) {
+		panics <- panicked{c.Param("room"), recovered}
+	})
+	srv.HandleFunc("/room/:room", func(c *highlevel.Conn) {
+		if _, _, err := c.ReadMessage(); err != nil {
+			return
+		}
+		panic("route panic")
+	})
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	conn, err := highlevel.Dial(fmt.Sprintf("ws://localhost:%d/room/red", port))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	if err := conn.WriteMessage(int(highlevel.TextMessage), []byte("hi")); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	select {
+	case p := <-panics:
+		if p.room != "red" || p.recovered != "route panic" {
+			t.Errorf("Unexpected OnPanic call %+v", p)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnPanic not called")
+	}
+
+	_, _, err = conn.ReadMessage()
+	var ce *api.CloseError
+	if !errors.As(err, &ce) || ce.Code != protocol.CloseInternalServerErr {
+		t.Errorf("Expected close 1011, got %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+// File: tests/unit/params_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for server parameters changed at runtime through Control.SetConfig.
+
+package unit
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/control"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// TestHotReloadParams tests that a raised connection limit admits a queued
+// connection and that a read timeout closes established idle connections.
+func TestHotReloadParams(t *testing.T) {
+	srv, port := startLimitedServer(t, server.OverflowQueue, 5*time.Second)
+	first, br1, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	defer first.Close()
+	waitConns(t, srv, 1)
+	queued, br2, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	defer queued.Close()
+
+	ctrl := srv.GetControl()
+	if err := ctrl.SetConfig(map[string]any{server.CfgMaxConnections: 2, server.CfgBatchSize: 8}); err != nil {
+		t.Fatalf("SetConfig: %v", err)
+	}
+	waitConns(t, srv, 2)
+
+	if err := ctrl.SetConfig(map[string]any{server.CfgReadTimeout: "100ms"}); err != nil {
+		t.Fatalf("SetConfig: %v", err)
+	}
+	first.SetReadDeadline(time.Now().Add(2 * time.Second))
+	queued.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for i, br := range []*bufio.Reader{br1, br2} {
+		frame, err := protocol.DecodeFrame(br)
+		if err != nil || frame.Opcode != protocol.OpcodeClose {
+			t.Fatalf("conn %d: expected close frame after read timeout, got %v (err=%v)", i, frame, err)
+		}
+		if code := binary.BigEndian.Uint16(frame.Payload); code != protocol.CloseGoingAway {
+			t.Errorf("conn %d: expected close code 1001, got %d", i, code)
+		}
+	}
+	waitConns(t, srv, 0)
+}
+
+// TestHotReloadKeepAlive tests that switching keepalive off stops the pings
+// of an established connection.
+func TestHotReloadKeepAlive(t *testing.T) {
+	port := freePort(t)
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf(":%d", port)
+	cfg.ShutdownTimeout = 10 * time.Millisecond
+	cfg.KeepAlive = 50 * time.Millisecond
+	srv, err := server.NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+	runServer(t, srv, api.HandlerFunc(func(any) error { return nil }))
+
+	conn, br, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if frame, err := protocol.DecodeFrame(br); err != nil || frame.Opcode != protocol.OpcodePing {
+		t.Fatalf("Expected keepalive ping, got %v (err=%v)", frame, err)
+	}
+
+	if err := srv.GetControl().SetConfig(map[string]any{control.CfgFeatureKeepAlive: false}); err != nil {
+		t.Fatalf("SetConfig: %v", err)
+	}
+	// At most the ping already pending follows in four intervals.
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	pings := 0
+	for {
+		if _, err := protocol.DecodeFrame(br); err != nil {
+			break
+		}
+		pings++
+	}
+	if pings > 1 {
+		t.Fatalf("Expected no pings after keepalive was switched off, got %d", pings)
+	}
+}
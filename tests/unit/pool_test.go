@@ -0,0 +1,138 @@
+// File: tests/unit/pool_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for client.Pool balancing and health checks.
+
+package unit
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/lowlevel/client"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// poolConfig returns a pool config for addrs with quick health checks.
+func poolConfig(size int, addrs ...string) *client.PoolConfig {
+	cfg := client.DefaultConfig()
+	cfg.Heartbeat = 0
+	cfg.Proxy = nil
+	return &client.PoolConfig{Config: cfg, Addrs: addrs, Size: size, HealthInterval: 200 * time.Millisecond}
+}
+
+// waitFor polls cond until it holds or the timeout passes.
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) bool {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return cond()
+}
+
+// TestPoolBalancing tests round-robin and key-hash selection across servers.
+func TestPoolBalancing(t *testing.T) {
+	a := fmt.Sprintf("ws://127.0.0.1:%d/echo", echoServer(t))
+	b := fmt.Sprintf("ws://127.0.0.1:%d/echo", echoServer(t))
+	pool, err := client.NewPool(poolConfig(4, a, b))
+	if err != nil {
+		t.Fatalf("NewPool: %v", err)
+	}
+	defer pool.Close()
+	if pool.Size() != 4 || pool.Healthy() != 4 {
+		t.Fatalf("Expected 4 healthy members, got %d of %d", pool.Healthy(), pool.Size())
+	}
+
+	seen := map[*client.Client]bool{}
+	for i := 0; i < 4; i++ {
+		c, err := pool.Next()
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		seen[c] = true
+	}
+	if len(seen) != 4 {
+		t.Errorf("Expected round-robin over 4 members, got %d distinct", len(seen))
+	}
+
+	c, _ := pool.ForKey("user-42")
+	for i := 0; i < 8; i++ {
+		if again, _ := pool.ForKey("user-42"); again != c {
+			t.Fatal("Expected the same key to map to the same member")
+		}
+	}
+	if err := pool.SendKey("user-42", []byte("keyed")); err != nil {
+		t.Fatalf("SendKey: %v", err)
+	}
+	if _, data, err := c.ReadMessage(); err != nil || string(data) != "keyed" {
+		t.Errorf("Expected echo on the keyed member, got %q, %v", data, err)
+	}
+}
+
+// TestPoolReplacesFailedMembers tests that closed connections and ones that
+// stop answering pings are replaced.
+func TestPoolReplacesFailedMembers(t *testing.T) {
+	pool, err := client.NewPool(poolConfig(2, fmt.Sprintf("ws://127.0.0.1:%d/echo", echoServer(t))))
+	if err != nil {
+		t.Fatalf("NewPool: %v", err)
+	}
+	defer pool.Close()
+	c, _ := pool.Next()
+	c.GetWSConnection().Close()
+	if !waitFor(t, 2*time.Second, func() bool { return pool.Replaced() == 1 && pool.Healthy() == 2 }) {
+		t.Fatalf("Expected the closed member replaced, replaced=%d healthy=%d", pool.Replaced(), pool.Healthy())
+	}
+	if err := pool.Send([]byte("after")); err != nil {
+		t.Errorf("Send after replacement: %v", err)
+	}
+
+	// A server that completes the handshake but never answers pings.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+	var accepted atomic.Int32
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go func() {
+				defer c.Close()
+				_, hdr, _, err := protocol.DoHandshakeRequestBuffered(c)
+				if err != nil {
+					return
+				}
+				protocol.WriteHandshakeResponse(c, hdr)
+				io.Copy(io.Discard, c)
+			}()
+		}
+	}()
+	mute, err := client.NewPool(poolConfig(1, "ws://"+ln.Addr().String()+"/"))
+	if err != nil {
+		t.Fatalf("NewPool: %v", err)
+	}
+	defer mute.Close()
+	if !waitFor(t, 2*time.Second, func() bool { return mute.Replaced() > 0 && accepted.Load() > 1 }) {
+		t.Errorf("Expected an unresponsive member to be replaced, replaced=%d accepted=%d", mute.Replaced(), accepted.Load())
+	}
+}
+
+// TestPoolUnavailable tests that a pool with no reachable server fails.
+func TestPoolUnavailable(t *testing.T) {
+	if _, err := client.NewPool(poolConfig(2, fmt.Sprintf("ws://127.0.0.1:%d/", freePort(t)))); err == nil {
+		t.Error("Expected NewPool to fail with no reachable server")
+	}
+}
@@ -0,0 +1,98 @@
+// File: tests/unit/priority_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the priority send lanes of a connection.
+
+package unit
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/pool"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// gatedTransport holds the first Send until release is closed and records
+// the frames written.
+type gatedTransport struct {
+	sending chan struct{}
+	release chan struct{}
+	mu      sync.Mutex
+	frames  []*protocol.WSFrame
+	first   sync.Once
+}
+
+func (t *gatedTransport) Send(bufs [][]byte) error {
+	t.first.Do(func() {
+		t.sending <- struct{}{}
+		<-t.release
+	})
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, b := range bufs {
+		r := bytes.NewReader(b)
+		for r.Len() > 0 {
+			f, err := protocol.DecodeFrame(r)
+			if err != nil {
+				return err
+			}
+			t.frames = append(t.frames, f)
+		}
+	}
+	return nil
+}
+
+func (t *gatedTransport) Recv() ([][]byte, error)         { select {} }
+func (t *gatedTransport) Close() error                    { return nil }
+func (t *gatedTransport) Features() api.TransportFeatures { return api.TransportFeatures{} }
+
+// TestSendPriorityLanes tests that queued control and high-priority frames
+// overtake queued normal frames while each lane keeps its order.
+func TestSendPriorityLanes(t *testing.T) {
+	tr := &gatedTransport{sending: make(chan struct{}), release: make(chan struct{})}
+	conn := protocol.NewWSConnection(tr, pool.NewBufferPoolManager(0).GetPool(1024, 0), 64)
+	defer conn.Close()
+
+	data := func(payload string, p protocol.Priority) *protocol.WSFrame {
+		return &protocol.WSFrame{IsFinal: true, Opcode: protocol.OpcodeBinary, Payload: []byte(payload), PayloadLen: int64(len(payload)), Priority: p}
+	}
+	conn.SendFrame(data("bulk0", protocol.PriorityNormal))
+	select {
+	case <-tr.sending:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Send loop did not start")
+	}
+	for _, p := range []string{"bulk1", "bulk2", "bulk3"} {
+		conn.SendFrame(data(p, protocol.PriorityNormal))
+	}
+	conn.SendFrame(data("urgent1", protocol.PriorityHigh))
+	conn.SendFrame(&protocol.WSFrame{IsFinal: true, Opcode: protocol.OpcodePing, Payload: []byte("ping"), PayloadLen: 4})
+	conn.SendFrame(data("urgent2", protocol.PriorityHigh))
+	close(tr.release)
+
+	waitFor(t, 2*time.Second, func() bool {
+		tr.mu.Lock()
+		defer tr.mu.Unlock()
+		return len(tr.frames) == 7
+	})
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	var got []string
+	for _, f := range tr.frames {
+		got = append(got, string(f.Payload))
+	}
+	want := []string{"bulk0", "ping", "urgent1", "urgent2", "bulk1", "bulk2", "bulk3"}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Expected %v, got %v", want, got)
+		}
+	}
+}
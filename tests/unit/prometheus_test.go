@@ -0,0 +1,104 @@
+// File: tests/unit/prometheus_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the Prometheus exposition handler.
+
+package unit
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/adapters"
+	"github.com/momentics/hioload-ws/control"
+)
+
+// TestPrometheusHandler tests gauges, counters and histograms rendered from
+// control stats and registry sources.
+func TestPrometheusHandler(t *testing.T) {
+	ctrl := adapters.NewControlAdapter()
+	ctrl.RegisterDebugProbe("connections.current", func() any { return 3 })
+	ctrl.RegisterDebugProbe("uptime", func() any { return 1500 * time.Millisecond })
+	ctrl.RegisterDebugProbe("label", func() any { return "ignored" })
+
+	reg := control.NewMetricsRegistry()
+	frames := &control.Counter{}
+	frames.Add(41)
+	frames.Inc()
+	latency := control.NewHistogram(0.01, 0.1, 1)
+	latency.Observe(0.005)
+	latency.Observe(0.05)
+	latency.Observe(5)
+	reg.Set("frames", frames)
+	reg.Set("latency_seconds", latency)
+
+	rec := httptest.NewRecorder()
+	control.PrometheusHandler(ctrl.Stats, reg.GetSnapshot).
+		ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("Unexpected content type %q", ct)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE hioload_debug_connections_current gauge\nhioload_debug_connections_current 3\n",
+		"hioload_debug_uptime 1.5\n",
+		"# TYPE hioload_frames_total counter\nhioload_frames_total 42\n",
+		"# TYPE hioload_latency_seconds histogram\n",
+		`hioload_latency_seconds_bucket{le="0.01"} 1` + "\n",
+		`hioload_latency_seconds_bucket{le="0.1"} 2` + "\n",
+		`hioload_latency_seconds_bucket{le="1"} 2` + "\n",
+		`hioload_latency_seconds_bucket{le="+Inf"} 3` + "\n",
+		"hioload_latency_seconds_sum 5.055\n",
+		"hioload_latency_seconds_count 3\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Missing %q in:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "label") {
+		t.Errorf("Non-numeric probe exported:\n%s", body)
+	}
+}
+
+// TestPrometheusHandler_Labels tests that keys with label sets are exported
+// as series of one family.
+func TestPrometheusHandler_Labels(t *testing.T) {
+	a, b := &control.Counter{}, &control.Counter{}
+	a.Add(3)
+	b.Add(5)
+	lat := control.NewLatencyHistogram()
+	lat.Record(2 * time.Millisecond)
+	stats := func() map[string]any {
+		return map[string]any{
+			`msgs{route="/a"}`:    a,
+			`msgs{route="/b"}`:    b,
+			`active{route="/a"}`:  2,
+			`active{route="/b"}`:  "skipped",
+			`latency{route="/a"}`: lat,
+		}
+	}
+	rec := httptest.NewRecorder()
+	control.PrometheusHandler(stats).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE hioload_msgs_total counter\n" +
+			`hioload_msgs_total{route="/a"} 3` + "\n" +
+			`hioload_msgs_total{route="/b"} 5` + "\n",
+		"# TYPE hioload_active gauge\n" + `hioload_active{route="/a"} 2` + "\n",
+		`hioload_latency{route="/a",quantile="0.5"} `,
+		`hioload_latency_count{route="/a"} 1` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Missing %q in:\n%s", want, body)
+		}
+	}
+	if n := strings.Count(body, "# TYPE hioload_msgs_total"); n != 1 {
+		t.Errorf("Expected one TYPE line per family, got %d", n)
+	}
+	if strings.Contains(body, `route="/b"} skipped`) || strings.Contains(body, `hioload_active{route="/b"}`) {
+		t.Errorf("Non-numeric series exported:\n%s", body)
+	}
+}
@@ -0,0 +1,216 @@
+// File: tests/unit/proxy_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for dialing WebSocket servers through proxies.
+
+package unit
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/momentics/hioload-ws/highlevel"
+	"github.com/momentics/hioload-ws/lowlevel/client"
+)
+
+// echoServer starts a highlevel server echoing messages on /echo and
+// returns its port.
+func echoServer(t *testing.T) int {
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf(":%d", port))
+	srv.HandleFunc("/echo", func(c *highlevel.Conn) {
+		for {
+			mt, data, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			c.WriteMessage(mt, data)
+		}
+	})
+	t.Cleanup(func() { srv.Shutdown(context.Background()) })
+	serve(t, srv)
+	return port
+}
+
+// connectProxy starts an HTTP proxy that serves CONNECT for requests
+// carrying auth as Proxy-Authorization and answers 407 to the rest. It
+// reports the targets it tunnels to.
+func connectProxy(t *testing.T, auth string) (*url.URL, <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	targets := make(chan string, 4)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				br := bufio.NewReader(c)
+				req, err := http.ReadRequest(br)
+				if err != nil || req.Method != http.MethodConnect {
+					return
+				}
+				if req.Header.Get("Proxy-Authorization") != auth {
+					io.WriteString(c, "HTTP/1.1 407 Proxy Authentication Required\r\nContent-Length: 0\r\n\r\n")
+					return
+				}
+				up, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					io.WriteString(c, "HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\n\r\n")
+					return
+				}
+				defer up.Close()
+				targets <- req.Host
+				io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+				go func() { io.Copy(up, br); up.Close() }()
+				io.Copy(c, up)
+			}()
+		}
+	}()
+	return &url.URL{Scheme: "http", Host: ln.Addr().String()}, targets
+}
+
+// TestDialHTTPProxy tests that the client tunnels through an HTTP proxy with
+// CONNECT and Basic credentials, and fails when the proxy refuses.
+func TestDialHTTPProxy(t *testing.T) {
+	port := echoServer(t)
+	proxyURL, targets := connectProxy(t, "Basic dXNlcjpwYXNz") // user:pass
+
+	opts := highlevel.DefaultOptions()
+	authed := *proxyURL
+	authed.User = url.UserPassword("user", "pass")
+	opts.Proxy = http.ProxyURL(&authed)
+	conn, err := highlevel.DialWithOptions(fmt.Sprintf("ws://127.0.0.1:%d/echo", port), opts)
+	if err != nil {
+		t.Fatalf("Dial through proxy: %v", err)
+	}
+	defer conn.Close()
+	if target := <-targets; target != fmt.Sprintf("127.0.0.1:%d", port) {
+		t.Errorf("Proxy tunneled to %s", target)
+	}
+	if err := conn.WriteMessage(int(highlevel.TextMessage), []byte("via proxy")); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	if _, data, err := conn.ReadMessage(); err != nil || string(data) != "via proxy" {
+		t.Errorf("Expected echo through the tunnel, got %q, %v", data, err)
+	}
+
+	opts.Proxy = http.ProxyURL(proxyURL)
+	_, err = highlevel.DialWithOptions(fmt.Sprintf("ws://127.0.0.1:%d/echo", port), opts)
+	if !errors.Is(err, client.ErrProxyRejected) {
+		t.Errorf("Expected ErrProxyRejected without credentials, got %v", err)
+	}
+}
+
+// socks5Proxy starts a SOCKS5 proxy requiring user:pass and reports the
+// targets it connects to.
+func socks5Proxy(t *testing.T) (string, <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	targets := make(chan string, 4)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				buf := make([]byte, 512)
+				// Greeting: VER NMETHODS METHODS; insist on user/pass.
+				if _, err := io.ReadFull(c, buf[:2]); err != nil {
+					return
+				}
+				if _, err := io.ReadFull(c, buf[:buf[1]]); err != nil {
+					return
+				}
+				c.Write([]byte{5, 2})
+				// Auth: VER ULEN UNAME PLEN PASSWD.
+				io.ReadFull(c, buf[:2])
+				user := make([]byte, buf[1])
+				io.ReadFull(c, user)
+				io.ReadFull(c, buf[:1])
+				pass := make([]byte, buf[0])
+				io.ReadFull(c, pass)
+				if string(user) != "user" || string(pass) != "pass" {
+					c.Write([]byte{1, 1})
+					return
+				}
+				c.Write([]byte{1, 0})
+				// Request: VER CMD RSV ATYP DST.ADDR DST.PORT.
+				io.ReadFull(c, buf[:4])
+				var host string
+				switch buf[3] {
+				case 1:
+					io.ReadFull(c, buf[:4])
+					host = net.IP(buf[:4]).String()
+				case 3:
+					io.ReadFull(c, buf[:1])
+					name := make([]byte, buf[0])
+					io.ReadFull(c, name)
+					host = string(name)
+				}
+				io.ReadFull(c, buf[:2])
+				target := net.JoinHostPort(host, fmt.Sprint(int(buf[0])<<8|int(buf[1])))
+				up, err := net.Dial("tcp", target)
+				if err != nil {
+					c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer up.Close()
+				targets <- target
+				c.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+				go func() { io.Copy(up, c); up.Close() }()
+				io.Copy(c, up)
+			}()
+		}
+	}()
+	return ln.Addr().String(), targets
+}
+
+// TestDialSOCKS5Proxy tests that WithProxy tunnels through a SOCKS5 proxy
+// with username and password, and fails on bad credentials.
+func TestDialSOCKS5Proxy(t *testing.T) {
+	port := echoServer(t)
+	addr, targets := socks5Proxy(t)
+	wsURL := fmt.Sprintf("ws://localhost:%d/echo", port)
+
+	conn, err := highlevel.Dial(wsURL, highlevel.WithProxy("socks5://user:pass@"+addr))
+	if err != nil {
+		t.Fatalf("Dial through SOCKS5: %v", err)
+	}
+	defer conn.Close()
+	if target := <-targets; target != fmt.Sprintf("localhost:%d", port) {
+		t.Errorf("Proxy connected to %s, want the unresolved host", target)
+	}
+	if err := conn.WriteMessage(int(highlevel.TextMessage), []byte("via socks")); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	if _, data, err := conn.ReadMessage(); err != nil || string(data) != "via socks" {
+		t.Errorf("Expected echo through the tunnel, got %q, %v", data, err)
+	}
+
+	_, err = highlevel.Dial(wsURL, highlevel.WithProxy("socks5://user:wrong@"+addr))
+	if !errors.Is(err, client.ErrProxyRejected) {
+		t.Errorf("Expected ErrProxyRejected for bad credentials, got %v", err)
+	}
+	if _, err := highlevel.Dial(wsURL, highlevel.WithProxy("ftp://"+addr)); err == nil {
+		t.Error("Expected an unsupported proxy scheme to fail")
+	}
+}
@@ -0,0 +1,317 @@
+// File: tests/unit/pubsub_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the pubsub broker and its highlevel.Conn integration.
+
+package unit
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/highlevel"
+	"github.com/momentics/hioload-ws/pubsub"
+	"github.com/momentics/hioload-ws/pubsub/redisbridge"
+)
+
+func TestPubSub_Match(t *testing.T) {
+	cases := []struct {
+		pattern, topic string
+		want           bool
+	}{
+		{"orders.created", "orders.created", true},
+		{"orders.created", "orders.deleted", false},
+		{"orders.*", "orders.created", true},
+		{"orders.*", "orders", false},
+		{"orders.*", "orders.eu.created", false},
+		{"orders.*.created", "orders.eu.created", true},
+		{"orders.>", "orders.eu.created", true},
+		{"orders.>", "orders", false},
+		{"*", "orders", true},
+		{"orders.*", "orders.", false},
+		{"orders..x", "orders..x", false},
+		{"orders.>.x", "orders.a.x", false},
+	}
+	for _, c := range cases {
+		if got := pubsub.Match(c.pattern, c.topic); got != c.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", c.pattern, c.topic, got, c.want)
+		}
+	}
+}
+
+func TestPubSub_PublishSubscribe(t *testing.T) {
+	b := pubsub.New()
+	defer b.Close()
+
+	exact, _ := b.Subscribe("orders.created")
+	wild, _ := b.Subscribe("orders.*")
+	other, _ := b.Subscribe("users.>")
+	if _, err := b.Subscribe("orders.>.x"); err != pubsub.ErrInvalidPattern {
+		t.Errorf("Expected ErrInvalidPattern, got %v", err)
+	}
+
+	if n := b.Publish("orders.created", []byte("o1")); n != 2 {
+		t.Errorf("Expected 2 deliveries, got %d", n)
+	}
+	for _, s := range []*pubsub.Subscription{exact, wild} {
+		select {
+		case msg := <-s.C():
+			if msg.Topic != "orders.created" || string(msg.Payload) != "o1" {
+				t.Errorf("Unexpected message %+v on %s", msg, s.Pattern())
+			}
+		default:
+			t.Errorf("Expected message on %s", s.Pattern())
+		}
+	}
+	if len(other.C()) != 0 {
+		t.Error("Expected no message on non-matching subscription")
+	}
+
+	wild.Unsubscribe()
+	if _, ok := <-wild.C(); ok {
+		t.Error("Expected closed channel after Unsubscribe")
+	}
+	if n := b.Subscribers(); n != 2 {
+		t.Errorf("Expected 2 subscribers, got %d", n)
+	}
+}
+
+func TestPubSub_Backpressure(t *testing.T) {
+	b := pubsub.New()
+	defer b.Close()
+	oldest, _ := b.Subscribe("t", pubsub.WithQueueSize(2), pubsub.WithPolicy(pubsub.DropOldest))
+	newest, _ := b.Subscribe("t", pubsub.WithQueueSize(2), pubsub.WithPolicy(pubsub.DropNewest))
+	for i := 1; i <= 4; i++ {
+		b.Publish("t", []byte(fmt.Sprint(i)))
+	}
+
+	drain := func(s *pubsub.Subscription) string {
+		out := ""
+		for len(s.C()) > 0 {
+			out += string((<-s.C()).Payload)
+		}
+		return out
+	}
+	if got := drain(oldest); got != "34" || oldest.Dropped() != 2 {
+		t.Errorf("DropOldest kept %q (dropped %d), want \"34\" (2)", got, oldest.Dropped())
+	}
+	if got := drain(newest); got != "12" || newest.Dropped() != 2 {
+		t.Errorf("DropNewest kept %q (dropped %d), want \"12\" (2)", got, newest.Dropped())
+	}
+}
+
+func TestHighLevelConnSubscribe(t *testing.T) {
+	broker := pubsub.New()
+	defer broker.Close()
+
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf(":%d", port))
+	srv.HandleFunc("/orders", func(c *highlevel.Conn) {
+		if _, err := c.Subscribe(broker, "orders.*"); err != nil {
+			c.Close()
+			return
+		}
+		c.WriteString("ready")
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	conn, err := highlevel.Dial(fmt.Sprintf("ws://localhost:%d/orders", port))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	conn.WriteString("hello") // starts the server-side handler
+	if msg, err := conn.ReadString(); err != nil || msg != "ready" {
+		t.Fatalf("Expected ready, got %q (err=%v)", msg, err)
+	}
+
+	broker.Publish("orders.created", []byte(`{"id":1}`))
+	if msg, err := conn.ReadString(); err != nil || msg != `{"id":1}` {
+		t.Fatalf("Expected published order, got %q (err=%v)", msg, err)
+	}
+}
+
+// memoryBus is an in-process pubsub.Remote hub shared by several nodes.
+type memoryBus struct {
+	mu   sync.Mutex
+	subs []func(topic string, data []byte)
+}
+
+type memoryRemote struct{ bus *memoryBus }
+
+func (r memoryRemote) Publish(topic string, data []byte) error {
+	r.bus.mu.Lock()
+	subs := append([]func(string, []byte){}, r.bus.subs...)
+	r.bus.mu.Unlock()
+	for _, fn := range subs {
+		fn(topic, data)
+	}
+	return nil
+}
+
+func (r memoryRemote) Subscribe(fn func(topic string, data []byte)) error {
+	r.bus.mu.Lock()
+	r.bus.subs = append(r.bus.subs, fn)
+	r.bus.mu.Unlock()
+	return nil
+}
+
+func (r memoryRemote) Close() error { return nil }
+
+// expectMessage waits for one message on s.
+func expectMessage(t *testing.T, s *pubsub.Subscription, payload string) pubsub.Message {
+	t.Helper()
+	select {
+	case msg := <-s.C():
+		if string(msg.Payload) != payload {
+			t.Fatalf("Expected %q, got %q", payload, msg.Payload)
+		}
+		return msg
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for %q", payload)
+	}
+	return pubsub.Message{}
+}
+
+func TestPubSubBridge(t *testing.T) {
+	bus := &memoryBus{}
+	a, b := pubsub.New(), pubsub.New()
+	defer a.Close()
+	defer b.Close()
+	bra, err := pubsub.NewBridge(a, memoryRemote{bus}, pubsub.WithNodeID("a"))
+	if err != nil {
+		t.Fatalf("NewBridge: %v", err)
+	}
+	defer bra.Close()
+	brb, _ := pubsub.NewBridge(b, memoryRemote{bus}, pubsub.WithNodeID("b"), pubsub.WithBridgePattern("orders.>"))
+	defer brb.Close()
+
+	subA, _ := a.Subscribe("orders.*")
+	subB, _ := b.Subscribe("orders.*")
+	a.Publish("orders.created", []byte("o1"))
+
+	expectMessage(t, subA, "o1")
+	if msg := expectMessage(t, subB, "o1"); msg.Origin != "a" {
+		t.Errorf("Expected origin a, got %q", msg.Origin)
+	}
+	// Each bridge forwards in order, so a message back from either side
+	// arrives after any echo of o1 would have.
+	b.Publish("orders.synced", []byte("s1"))
+	expectMessage(t, subB, "s1")
+	expectMessage(t, subA, "s1")
+	a.Publish("orders.synced", []byte("s2"))
+	expectMessage(t, subA, "s2")
+	expectMessage(t, subB, "s2")
+	if len(subA.C()) != 0 || len(subB.C()) != 0 {
+		t.Error("Expected no echoed duplicates")
+	}
+
+	b.Publish("users.joined", []byte("u1")) // outside b's bridge pattern
+	b.Publish("orders.synced", []byte("s3"))
+	expectMessage(t, subB, "s3")
+	expectMessage(t, subA, "s3")
+	if _, received := bra.Stats(); received != 2 {
+		t.Errorf("Expected unbridged topic to stay local, a received %d", received)
+	}
+}
+
+// fakeRedis is a minimal RESP server supporting PSUBSCRIBE prefix* and PUBLISH.
+type fakeRedis struct {
+	ln   net.Listener
+	mu   sync.Mutex
+	subs map[net.Conn]string // conn -> pattern prefix
+}
+
+func startFakeRedis(t *testing.T) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	f := &fakeRedis{ln: ln, subs: map[net.Conn]string{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	for {
+		var n int
+		if _, err := fmt.Fscanf(rd, "*%d\r\n", &n); err != nil {
+			return
+		}
+		args := make([]string, n)
+		for i := range args {
+			var l int
+			fmt.Fscanf(rd, "$%d\r\n", &l)
+			buf := make([]byte, l+2)
+			io.ReadFull(rd, buf)
+			args[i] = string(buf[:l])
+		}
+		switch args[0] {
+		case "PSUBSCRIBE":
+			f.mu.Lock()
+			f.subs[conn] = strings.TrimSuffix(args[1], "*")
+			f.mu.Unlock()
+			fmt.Fprintf(conn, "*3\r\n$10\r\npsubscribe\r\n$%d\r\n%s\r\n:1\r\n", len(args[1]), args[1])
+		case "PUBLISH":
+			f.mu.Lock()
+			count := 0
+			for sub, prefix := range f.subs {
+				if strings.HasPrefix(args[1], prefix) {
+					fmt.Fprintf(sub, "*4\r\n$8\r\npmessage\r\n$%d\r\n%s*\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n",
+						len(prefix)+1, prefix, len(args[1]), args[1], len(args[2]), args[2])
+					count++
+				}
+			}
+			f.mu.Unlock()
+			fmt.Fprintf(conn, ":%d\r\n", count)
+		}
+	}
+}
+
+func TestRedisBridge(t *testing.T) {
+	f := startFakeRedis(t)
+	a, b := pubsub.New(), pubsub.New()
+	defer a.Close()
+	defer b.Close()
+	bra, err := pubsub.NewBridge(a, redisbridge.New(f.ln.Addr().String()))
+	if err != nil {
+		t.Fatalf("NewBridge a: %v", err)
+	}
+	defer bra.Close()
+	brb, err := pubsub.NewBridge(b, redisbridge.New(f.ln.Addr().String()))
+	if err != nil {
+		t.Fatalf("NewBridge b: %v", err)
+	}
+	defer brb.Close()
+
+	sub, _ := b.Subscribe("room.*")
+	a.Publish("room.lobby", []byte("hi"))
+	if msg := expectMessage(t, sub, "hi"); msg.Origin != bra.NodeID() {
+		t.Errorf("Expected origin %q, got %q", bra.NodeID(), msg.Origin)
+	}
+}
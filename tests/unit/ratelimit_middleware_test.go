@@ -0,0 +1,148 @@
+// File: tests/unit/ratelimit_middleware_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the highlevel rate limiting middleware.
+
+package unit
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/highlevel"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// limitedServer starts an echo server behind RateLimitMiddleware and reports
+// the close code of every connection on closed.
+func limitedServer(t *testing.T, cfg highlevel.RateLimitConfig) (string, chan uint16) {
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf("127.0.0.1:%d", port))
+	srv.Use(highlevel.RateLimitMiddleware(cfg))
+	closed := make(chan uint16, 16)
+	srv.OnDisconnect(func(c *highlevel.Conn, code uint16, d time.Duration) {
+		closed <- code
+	})
+	srv.HandleFunc("/echo", func(c *highlevel.Conn) {
+		for {
+			mt, msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			c.WriteMessage(mt, msg)
+		}
+	})
+	t.Cleanup(func() { srv.Shutdown(context.Background()) })
+	serve(t, srv)
+	return fmt.Sprintf("ws://127.0.0.1:%d/echo", port), closed
+}
+
+// echoes sends n messages, each once the previous one was echoed or
+// 300ms passed without the echo, and returns how many were echoed.
+func echoes(t *testing.T, conn *highlevel.Conn, n int) int {
+	got := make(chan struct{}, n)
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+			got <- struct{}{}
+		}
+	}()
+	count := 0
+	for i := 0; i < n; i++ {
+		if err := conn.WriteString(fmt.Sprint(i)); err != nil {
+			break
+		}
+		select {
+		case <-got:
+			count++
+		case <-time.After(300 * time.Millisecond):
+		}
+	}
+	return count
+}
+
+// TestRateLimitMiddleware_Messages tests that messages beyond the
+// per-connection burst are dropped without closing the connection.
+func TestRateLimitMiddleware_Messages(t *testing.T) {
+	url, _ := limitedServer(t, highlevel.RateLimitConfig{
+		MessagesPerConn: highlevel.RateLimit{Rate: 0.01, Burst: 3},
+	})
+	for i := 0; i < 2; i++ { // each connection has a bucket of its own
+		conn, err := highlevel.Dial(url)
+		if err != nil {
+			t.Fatalf("Failed to dial: %v", err)
+		}
+		if n := echoes(t, conn, 5); n != 3 {
+			t.Errorf("Expected 3 of 5 messages echoed on connection %d, got %d", i, n)
+		}
+		conn.Close()
+	}
+}
+
+// TestRateLimitMiddleware_CloseOnLimit tests that exceeding a message limit
+// closes the connection with 1008.
+func TestRateLimitMiddleware_CloseOnLimit(t *testing.T) {
+	url, closed := limitedServer(t, highlevel.RateLimitConfig{
+		MessagesPerIP: highlevel.RateLimit{Rate: 0.01, Burst: 2},
+		CloseOnLimit:  true,
+	})
+	conn, err := highlevel.Dial(url)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	if n := echoes(t, conn, 4); n != 2 {
+		t.Errorf("Expected 2 messages echoed, got %d", n)
+	}
+	select {
+	case code := <-closed:
+		if code != protocol.ClosePolicyViolation {
+			t.Errorf("Expected close code 1008, got %d", code)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Connection not closed on the message limit")
+	}
+}
+
+// TestRateLimitMiddleware_Connections tests the per-IP connection limit and
+// a store shared by two servers.
+func TestRateLimitMiddleware_Connections(t *testing.T) {
+	cfg := highlevel.RateLimitConfig{
+		ConnectionsPerIP: highlevel.RateLimit{Rate: 0.01, Burst: 2},
+		Store:            highlevel.NewRateLimitStore(),
+	}
+	first, closed := limitedServer(t, cfg)
+	second, _ := limitedServer(t, cfg)
+	for _, url := range []string{first, second} {
+		conn, err := highlevel.Dial(url)
+		if err != nil {
+			t.Fatalf("Failed to dial: %v", err)
+		}
+		defer conn.Close()
+		if n := echoes(t, conn, 1); n != 1 {
+			t.Errorf("Expected the connection to %s served, got %d echoes", url, n)
+		}
+	}
+
+	conn, err := highlevel.Dial(first)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	if n := echoes(t, conn, 1); n != 0 {
+		t.Errorf("Expected the connection beyond the shared burst refused, got %d echoes", n)
+	}
+	select {
+	case code := <-closed:
+		if code != protocol.CloseTryAgainLater {
+			t.Errorf("Expected close code 1013, got %d", code)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Connection beyond the shared burst not closed")
+	}
+}
@@ -0,0 +1,115 @@
+// File: tests/unit/ratelimit_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the ratelimit package and server handshake and frame
+// throttling.
+
+package unit
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+	"github.com/momentics/hioload-ws/protocol"
+	"github.com/momentics/hioload-ws/ratelimit"
+)
+
+func TestRateLimiter_BurstAndRefill(t *testing.T) {
+	l := ratelimit.New(20, 3)
+	for i := 0; i < 3; i++ {
+		if !l.Allow("a") {
+			t.Fatalf("Expected burst event %d to be admitted", i)
+		}
+	}
+	if l.Allow("a") {
+		t.Fatal("Expected event beyond burst to be rejected")
+	}
+	if d := l.Delay("a"); d <= 0 || d > 50*time.Millisecond {
+		t.Fatalf("Expected a wait of up to one token at 20/s, got %v", d)
+	}
+	if !l.Allow("b") {
+		t.Fatal("Expected independent key to have its own bucket")
+	}
+	if !waitFor(t, time.Second, func() bool { return l.Allow("a") }) {
+		t.Fatal("Expected bucket to refill over time")
+	}
+
+	l.SetLimit(0, 0)
+	if l.Enabled() {
+		t.Fatal("Expected zero rate to disable limiting")
+	}
+	for i := 0; i < 100; i++ {
+		if !l.Allow("a") {
+			t.Fatal("Expected disabled limiter to admit everything")
+		}
+	}
+}
+
+func TestServerHandshakeRateLimit(t *testing.T) {
+	port := freePort(t)
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf(":%d", port)
+	cfg.ShutdownTimeout = 10 * time.Millisecond
+	cfg.RateLimit.HandshakesPerSec = 0.001
+	cfg.RateLimit.HandshakeBurst = 1
+	srv, err := server.NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer srv.Shutdown()
+	runServer(t, srv, api.HandlerFunc(func(any) error { return nil }))
+
+	conn, _, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	conn.Close()
+
+	upgrade(t, port, "/", nil, http.StatusTooManyRequests)
+	if got := srv.GetControl().Stats()["debug.ratelimit.handshakes_rejected"]; got != int64(1) {
+		t.Errorf("Expected one rejected handshake, got %v", got)
+	}
+
+	// Hot reload lifts the limit.
+	srv.GetControl().SetConfig(map[string]any{server.CfgHandshakesPerSec: 0.0})
+	conn, _, _ = upgrade(t, port, "/", nil, http.StatusSwitchingProtocols)
+	conn.Close()
+}
+
+// TestServerFrameRateLimit tests that frames beyond the limit pause reading
+// until the bucket refills, so every frame is delivered in order, late.
+func TestServerFrameRateLimit(t *testing.T) {
+	port := freePort(t)
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf("127.0.0.1:%d", port)
+	cfg.ShutdownTimeout = 10 * time.Millisecond
+	cfg.RateLimit.FramesPerSec = 20
+	cfg.RateLimit.FrameBurst = 2
+	srv := startSimServer(t, cfg)
+
+	conn, br, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	defer conn.Close()
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		conn.Write(maskedFrame([]byte{byte('0' + i)}))
+	}
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for i := 0; i < 6; i++ {
+		f, err := protocol.DecodeFrame(br)
+		if err != nil {
+			t.Fatalf("echo %d: %v", i, err)
+		}
+		if want := string(rune('0' + i)); string(f.Payload) != want {
+			t.Fatalf("echo %d: got %q, want %q", i, f.Payload, want)
+		}
+	}
+	// Four frames beyond the burst of two take 200ms at 20/s.
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Expected reading to pause, all echoes after %v", elapsed)
+	}
+	if got := srv.GetControl().Stats()["debug.ratelimit.frames_throttled"]; got == int64(0) {
+		t.Error("Expected throttled frames to be counted")
+	}
+}
@@ -0,0 +1,241 @@
+// File: tests/unit/record_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for recording connection traffic and replaying it.
+
+package unit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/highlevel"
+	"github.com/momentics/hioload-ws/protocol"
+	"github.com/momentics/hioload-ws/record"
+)
+
+// memFile is an in-memory recording file.
+type memFile struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.buf.Write(p)
+}
+
+func (f *memFile) Close() error {
+	f.mu.Lock()
+	f.closed = true
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *memFile) isClosed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closed
+}
+
+// scriptTransport returns its batches from Recv, then io.EOF, and keeps
+// what is sent.
+type scriptTransport struct {
+	mu      sync.Mutex
+	batches [][][]byte
+	sent    [][]byte
+}
+
+func (t *scriptTransport) Recv() ([][]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.batches) == 0 {
+		return nil, io.EOF
+	}
+	b := t.batches[0]
+	t.batches = t.batches[1:]
+	return b, nil
+}
+
+func (t *scriptTransport) Send(bufs [][]byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, b := range bufs {
+		t.sent = append(t.sent, append([]byte(nil), b...))
+	}
+	return nil
+}
+
+func (t *scriptTransport) Close() error                    { return nil }
+func (t *scriptTransport) Features() api.TransportFeatures { return api.TransportFeatures{} }
+
+// TestRecordReplay tests recording a server connection and replaying it.
+func TestRecordReplay(t *testing.T) {
+	var mu sync.Mutex
+	var files []*memFile
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf(":%d", port), highlevel.WithRecorder(func(req *http.Request) (io.WriteCloser, error) {
+		if req.URL.Query().Get("rec") == "" {
+			return nil, nil
+		}
+		f := &memFile{}
+		mu.Lock()
+		files = append(files, f)
+		mu.Unlock()
+		return f, nil
+	}))
+	srv.HandleFunc("/ws", func(c *highlevel.Conn) {
+		for {
+			mt, msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			c.WriteMessage(mt, msg)
+		}
+	})
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	for _, query := range []string{"", "?rec=1"} {
+		c, err := highlevel.Dial(fmt.Sprintf("ws://127.0.0.1:%d/ws%s", port, query))
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		c.WriteMessage(int(highlevel.TextMessage), []byte("hello"))
+		c.WriteMessage(int(highlevel.PingMessage), []byte("p"))
+		c.WriteMessage(int(highlevel.BinaryMessage), []byte{1, 2, 3})
+		for _, want := range []string{"hello", "\x01\x02\x03"} {
+			if _, msg, err := c.ReadMessage(); err != nil || string(msg) != want {
+				t.Fatalf("Expected echo %q, got %q, %v", want, msg, err)
+			}
+		}
+		c.Close()
+	}
+	mu.Lock()
+	if len(files) != 1 {
+		t.Fatalf("Expected one recorded connection, got %d", len(files))
+	}
+	f := files[0]
+	mu.Unlock()
+	if !waitFor(t, 2*time.Second, f.isClosed) {
+		t.Fatal("Recording not closed with the connection")
+	}
+	data := f.buf.Bytes()
+
+	rd, err := record.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+	if rd.Meta()[record.MetaPath] != "/ws" || rd.Meta()[record.MetaStart] == "" {
+		t.Fatalf("Unexpected metadata %v", rd.Meta())
+	}
+	kinds := map[record.Kind]int{}
+	var echoed bool
+	for {
+		rec, err := rd.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+		kinds[rec.Kind]++
+		for _, b := range rec.Data {
+			if rec.Kind == record.KindSend && bytes.Contains(b, []byte("hello")) {
+				echoed = true
+			}
+		}
+	}
+	if kinds[record.KindRecv] == 0 || kinds[record.KindClose] != 1 || !echoed {
+		t.Fatalf("Unexpected records %v, echo recorded: %v", kinds, echoed)
+	}
+
+	var first *record.Result
+	for run := 0; run < 2; run++ {
+		res, err := record.Replay(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Replay failed: %v", err)
+		}
+		if len(res.Messages) < 2 || string(res.Messages[0].Payload) != "hello" ||
+			res.Messages[0].Opcode != protocol.OpcodeText || !bytes.Equal(res.Messages[1].Payload, []byte{1, 2, 3}) {
+			t.Fatalf("Unexpected replayed messages %+v", res.Messages)
+		}
+		var ponged bool
+		for _, b := range res.Sent {
+			ponged = ponged || bytes.Equal(b, []byte{0x80 | protocol.OpcodePong, 1, 'p'})
+		}
+		if !ponged {
+			t.Fatalf("Expected the ping answered in the replay, sent %q", res.Sent)
+		}
+		if first == nil {
+			first = res
+		} else if len(res.Messages) != len(first.Messages) {
+			t.Fatalf("Replays differ: %d and %d messages", len(first.Messages), len(res.Messages))
+		}
+	}
+
+	if _, err := record.Replay(bytes.NewReader([]byte("not a recording"))); !errors.Is(err, record.ErrFormat) {
+		t.Fatalf("Expected ErrFormat, got %v", err)
+	}
+}
+
+// TestRecordReplayViolation tests that a frame split across reads and a
+// protocol violation replay as recorded, and that a recording cut short
+// still replays up to the cut.
+func TestRecordReplayViolation(t *testing.T) {
+	text := maskedFrame([]byte("split"))
+	tr := &scriptTransport{batches: [][][]byte{
+		{text[:4]},
+		{text[4:]},
+		{{0x83, 0x81, 0, 0, 0, 0, 'x'}}, // reserved opcode
+	}}
+	var f memFile
+	rt, err := record.NewTransport(tr, &f, map[string]string{record.MetaStrict: "true"})
+	if err != nil {
+		t.Fatalf("NewTransport failed: %v", err)
+	}
+	for {
+		if _, err := rt.Recv(); err != nil {
+			break
+		}
+	}
+	rt.Close()
+	if !f.isClosed() || rt.Err() != nil {
+		t.Fatalf("Expected the recording closed cleanly, got %v", rt.Err())
+	}
+
+	res, err := record.Replay(bytes.NewReader(f.buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if len(res.Messages) != 1 || string(res.Messages[0].Payload) != "split" {
+		t.Fatalf("Expected the split frame reassembled, got %+v", res.Messages)
+	}
+	if !errors.Is(res.Err, protocol.ErrProtocolViolation) {
+		t.Fatalf("Expected a protocol violation, got %v", res.Err)
+	}
+	if n := len(res.Sent); n == 0 || len(res.Sent[n-1]) < 4 || res.Sent[n-1][0] != 0x88 || !bytes.Equal(res.Sent[n-1][2:4], []byte{0x03, 0xEA}) {
+		t.Fatalf("Expected a 1002 close, sent %q", res.Sent)
+	}
+
+	// Cut past the error and close records into the reserved frame.
+	cut := f.buf.Bytes()[:f.buf.Len()-38]
+	res, err = record.Replay(bytes.NewReader(cut))
+	if err != nil {
+		t.Fatalf("Replay of a truncated recording failed: %v", err)
+	}
+	if len(res.Messages) != 1 || !errors.Is(res.Err, io.ErrUnexpectedEOF) {
+		t.Fatalf("Expected the message and io.ErrUnexpectedEOF, got %d, %v", len(res.Messages), res.Err)
+	}
+}
@@ -0,0 +1,170 @@
+// File: tests/unit/reliable_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for at-least-once delivery with highlevel.Reliable and Conn.Ack.
+
+package unit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/highlevel"
+)
+
+// ackServer serves /rel on port, acknowledging reliable messages and
+// reporting their IDs and payloads. The first connection is dropped without
+// an ack when dropFirst is set.
+func ackServer(t *testing.T, port int, dropFirst bool) <-chan string {
+	got := make(chan string, 16)
+	var conns atomic.Int32
+	srv := highlevel.NewServer(fmt.Sprintf(":%d", port))
+	srv.HandleFunc("/rel", func(c *highlevel.Conn) {
+		first := conns.Add(1) == 1
+		for {
+			_, data, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			id, payload, ok := highlevel.ParseReliable(data)
+			if !ok {
+				continue
+			}
+			got <- fmt.Sprintf("%d:%s", id, payload)
+			if first && dropFirst {
+				c.Close()
+				return
+			}
+			c.Ack(id)
+			c.WriteMessage(int(highlevel.TextMessage), []byte("seen"))
+		}
+	})
+	t.Cleanup(func() { srv.Shutdown(context.Background()) })
+	serve(t, srv)
+	return got
+}
+
+// expect reads the next delivery from got.
+func expect(t *testing.T, got <-chan string, want string) {
+	t.Helper()
+	select {
+	case g := <-got:
+		if g != want {
+			t.Errorf("Server received %q, want %q", g, want)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Timed out waiting for %q", want)
+	}
+}
+
+// TestReliableAcks tests that acknowledged messages leave the queue and that
+// other server messages reach OnMessage.
+func TestReliableAcks(t *testing.T) {
+	port := freePort(t)
+	got := ackServer(t, port, false)
+	var mu sync.Mutex
+	var seen int
+	r, err := highlevel.NewReliable(fmt.Sprintf("ws://127.0.0.1:%d/rel", port), highlevel.ReliableOptions{
+		RetryInterval: 50 * time.Millisecond,
+		OnMessage: func(mt int, data []byte) {
+			mu.Lock()
+			seen++
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewReliable: %v", err)
+	}
+	defer r.Close()
+	if !waitFor(t, 2*time.Second, r.Connected) {
+		t.Fatal("Reliable did not connect")
+	}
+	for i := 1; i <= 3; i++ {
+		if id, err := r.Send([]byte(fmt.Sprint("m", i))); err != nil || id != uint64(i) {
+			t.Fatalf("Send = %d, %v", id, err)
+		}
+	}
+	for i := 1; i <= 3; i++ {
+		expect(t, got, fmt.Sprintf("%d:m%d", i, i))
+	}
+	if !waitFor(t, 2*time.Second, func() bool { return r.Pending() == 0 }) {
+		t.Errorf("Expected all messages acknowledged, %d pending", r.Pending())
+	}
+	if !waitFor(t, 2*time.Second, func() bool { mu.Lock(); defer mu.Unlock(); return seen == 3 }) {
+		t.Errorf("Expected 3 server messages in OnMessage, got %d", seen)
+	}
+}
+
+// TestReliableResendsAfterReconnect tests that a message whose connection
+// dropped before the ack is delivered again on the next connection.
+func TestReliableResendsAfterReconnect(t *testing.T) {
+	port := freePort(t)
+	got := ackServer(t, port, true)
+	r, err := highlevel.NewReliable(fmt.Sprintf("ws://127.0.0.1:%d/rel", port), highlevel.ReliableOptions{RetryInterval: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewReliable: %v", err)
+	}
+	defer r.Close()
+	if !waitFor(t, 2*time.Second, r.Connected) {
+		t.Fatal("Reliable did not connect")
+	}
+	r.Send([]byte("once"))
+	expect(t, got, "1:once")
+	expect(t, got, "1:once")
+	if !waitFor(t, 2*time.Second, func() bool { return r.Pending() == 0 }) {
+		t.Errorf("Expected the resent message acknowledged, %d pending", r.Pending())
+	}
+	if n := r.Stats().Reconnects; n != 1 {
+		t.Errorf("Expected 1 reconnect, got %d", n)
+	}
+}
+
+// TestReliableOfflineQueue tests that messages queued while offline are
+// bounded, persisted, and delivered by a later sender on the same directory.
+func TestReliableOfflineQueue(t *testing.T) {
+	port := freePort(t)
+	url := fmt.Sprintf("ws://127.0.0.1:%d/rel", port)
+	dir := t.TempDir()
+	opts := highlevel.ReliableOptions{Dir: dir, MaxPending: 2, RetryInterval: 50 * time.Millisecond}
+
+	r, err := highlevel.NewReliable(url, opts)
+	if err != nil {
+		t.Fatalf("NewReliable: %v", err)
+	}
+	r.Send([]byte("a"))
+	r.Send([]byte("b"))
+	if _, err := r.Send([]byte("c")); err != highlevel.ErrQueueFull {
+		t.Errorf("Expected ErrQueueFull, got %v", err)
+	}
+	r.Close()
+	if _, err := r.Send([]byte("d")); err != highlevel.ErrReliableClosed {
+		t.Errorf("Expected ErrReliableClosed, got %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Fatalf("Expected 2 persisted messages, got %d", len(entries))
+	}
+
+	got := ackServer(t, port, false)
+	r, err = highlevel.NewReliable(url, opts)
+	if err != nil {
+		t.Fatalf("NewReliable: %v", err)
+	}
+	defer r.Close()
+	expect(t, got, "1:a")
+	expect(t, got, "2:b")
+	if !waitFor(t, 2*time.Second, func() bool { return r.Pending() == 0 }) {
+		t.Fatalf("Expected persisted messages acknowledged, %d pending", r.Pending())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected acknowledged messages removed from disk, %d left", len(entries))
+	}
+	if id, err := r.Send([]byte("e")); err != nil || id != 3 {
+		t.Errorf("Expected numbering to continue at 3, got %d, %v", id, err)
+	}
+}
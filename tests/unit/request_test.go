@@ -0,0 +1,115 @@
+// File: tests/unit/request_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for correlated request/response on highlevel connections.
+
+package unit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/highlevel"
+)
+
+// TestConnRequest tests correlation, concurrency, remote errors, timeouts and
+// coexistence with ordinary messages.
+func TestConnRequest(t *testing.T) {
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf(":%d", port))
+	release := make(chan struct{})
+	srv.HandleFunc("/req", func(c *highlevel.Conn) {
+		c.OnRequest(func(ctx context.Context, payload []byte) ([]byte, error) {
+			switch string(payload) {
+			case "fail":
+				return nil, errors.New("boom")
+			case "slow":
+				<-release
+			}
+			return bytes.ToUpper(payload), nil
+		})
+		for {
+			_, msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			c.WriteString("echo:" + string(msg))
+		}
+	})
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	conn, err := highlevel.Dial(fmt.Sprintf("ws://localhost:%d/req", port))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	messages := make(chan string, 8)
+	go func() {
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			messages <- string(msg)
+		}
+	}()
+
+	// The first message also starts the server-side handler.
+	conn.WriteString("hello")
+	if msg := <-messages; msg != "echo:hello" {
+		t.Fatalf("Expected echo:hello, got %q", msg)
+	}
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			want := fmt.Sprintf("CALL-%d", i)
+			resp, err := conn.Request(ctx, []byte(fmt.Sprintf("call-%d", i)))
+			if err != nil || string(resp) != want {
+				t.Errorf("Request %d = %q, %v; want %q", i, resp, err, want)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var reqErr *highlevel.RequestError
+	if _, err := conn.Request(ctx, []byte("fail")); !errors.As(err, &reqErr) || reqErr.Message != "boom" {
+		t.Fatalf("Expected remote error boom, got %v", err)
+	}
+
+	short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := conn.Request(short, []byte("slow")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected deadline exceeded, got %v", err)
+	}
+	if n := conn.PendingRequests(); n != 0 {
+		t.Fatalf("Expected pending table to be cleaned up, got %d", n)
+	}
+
+	// The late response to the timed-out call must not leak to the reader.
+	received := func() int64 { return conn.GetUnderlyingWSConnection().GetStats()["frames_received"] }
+	before := received()
+	close(release)
+	if !waitFor(t, 2*time.Second, func() bool { return received() == before+1 }) {
+		t.Fatal("Late response not received")
+	}
+	conn.WriteString("after")
+	select {
+	case msg := <-messages:
+		if msg != "echo:after" {
+			t.Fatalf("Expected echo:after, got %q", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Ordinary message not delivered")
+	}
+}
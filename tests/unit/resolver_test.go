@@ -0,0 +1,117 @@
+// File: tests/unit/resolver_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for pluggable name resolution and Happy Eyeballs dialing.
+
+package unit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/highlevel"
+	"github.com/momentics/hioload-ws/lowlevel/client"
+)
+
+// fakeResolver answers lookups from a fixed table and records the networks
+// asked for. Networks listed in hang never answer.
+type fakeResolver struct {
+	addrs map[string][]netip.Addr
+	hang  map[string]bool
+	err   error
+
+	mu    sync.Mutex
+	asked []string
+}
+
+func (r *fakeResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	r.mu.Lock()
+	r.asked = append(r.asked, network+" "+host)
+	r.mu.Unlock()
+	if r.hang[network] {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.addrs[network], nil
+}
+
+func (r *fakeResolver) queried() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.asked...)
+}
+
+// TestResolverHappyEyeballs tests that both address families are looked up
+// and that an unreachable IPv6 address falls back to IPv4 promptly.
+func TestResolverHappyEyeballs(t *testing.T) {
+	port := echoServer(t)
+	r := &fakeResolver{addrs: map[string][]netip.Addr{
+		// 100::/64 is the discard-only prefix of RFC 6666.
+		"ip6": {netip.MustParseAddr("100::1")},
+		"ip4": {netip.MustParseAddr("127.0.0.1")},
+	}}
+
+	start := time.Now()
+	conn, err := highlevel.Dial(fmt.Sprintf("ws://echo.test:%d/echo", port), highlevel.WithResolver(r))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Dial took %v, expected the IPv4 attempt after 250ms", d)
+	}
+	if got := r.queried(); len(got) != 2 {
+		t.Errorf("Expected AAAA and A lookups, got %q", got)
+	}
+	if err := conn.WriteMessage(int(highlevel.TextMessage), []byte("hi")); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	if _, data, err := conn.ReadMessage(); err != nil || string(data) != "hi" {
+		t.Errorf("Echo: %q, %v", data, err)
+	}
+}
+
+// TestResolverSlowAAAA tests that an outstanding AAAA lookup does not hold
+// up a dial once the A answer is in.
+func TestResolverSlowAAAA(t *testing.T) {
+	port := echoServer(t)
+	cfg := client.DefaultConfig()
+	cfg.Addr = fmt.Sprintf("ws://echo.test:%d/echo", port)
+	cfg.Heartbeat = 0
+	cfg.Proxy = nil
+	cfg.Resolver = &fakeResolver{
+		addrs: map[string][]netip.Addr{"ip4": {netip.MustParseAddr("127.0.0.1")}},
+		hang:  map[string]bool{"ip6": true},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	c, err := client.NewClientContext(ctx, cfg)
+	if err != nil {
+		t.Fatalf("NewClientContext: %v", err)
+	}
+	c.Close()
+}
+
+// TestResolverError tests that lookup failures fail the dial.
+func TestResolverError(t *testing.T) {
+	errNXDomain := errors.New("no such host")
+	_, err := highlevel.Dial("ws://missing.test:80/", highlevel.WithResolver(&fakeResolver{err: errNXDomain}))
+	if !errors.Is(err, errNXDomain) {
+		t.Errorf("Expected the resolver's error, got %v", err)
+	}
+
+	_, err = highlevel.Dial("ws://empty.test:80/", highlevel.WithResolver(&fakeResolver{}))
+	if err == nil {
+		t.Error("Expected an error for a host without addresses")
+	}
+}
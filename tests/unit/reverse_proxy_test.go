@@ -0,0 +1,336 @@
+// File: tests/unit/reverse_proxy_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the reverse proxy package.
+
+package unit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/highlevel"
+	"github.com/momentics/hioload-ws/protocol"
+	"github.com/momentics/hioload-ws/proxy"
+	"github.com/momentics/hioload-ws/router"
+)
+
+// proxyBackend starts a WebSocket server answering each message with
+// "name:X-Forwarded-For:message", or closing with 4001 on "close".
+func proxyBackend(t *testing.T, name string) string {
+	t.Helper()
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf(":%d", port))
+	srv.HandleFunc("/ws", func(c *highlevel.Conn) {
+		xff := c.RequestHeader().Get("X-Forwarded-For")
+		for {
+			mt, msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			if string(msg) == "close" {
+				c.CloseWithCode(4001, "bye")
+				return
+			}
+			c.WriteMessage(mt, []byte(name+":"+xff+":"+string(msg)))
+		}
+	})
+	t.Cleanup(func() { srv.Shutdown(context.Background()) })
+	serve(t, srv)
+	return fmt.Sprintf("ws://127.0.0.1:%d/ws", port)
+}
+
+// proxyFront starts a server relaying /ws through p and returns its URL.
+func proxyFront(t *testing.T, p *proxy.Proxy) string {
+	t.Helper()
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf(":%d", port))
+	srv.HandleFunc("/ws", p.Serve)
+	t.Cleanup(func() {
+		p.Close()
+		srv.Shutdown(context.Background())
+	})
+	serve(t, srv)
+	return fmt.Sprintf("ws://127.0.0.1:%d/ws", port)
+}
+
+// proxyRoundTrip sends msg and returns the reply.
+func proxyRoundTrip(t *testing.T, c *highlevel.Conn, msg string) string {
+	t.Helper()
+	if err := c.WriteString(msg); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	_, reply, err := c.ReadMessage()
+	if err != nil {
+		t.Fatalf("Failed to read reply to %q: %v", msg, err)
+	}
+	return string(reply)
+}
+
+// TestReverseProxy tests relaying in both directions, X-Forwarded-For,
+// least-relays balancing and close status propagation.
+func TestReverseProxy(t *testing.T) {
+	b1, b2 := proxyBackend(t, "b1"), proxyBackend(t, "b2")
+	p, err := proxy.New(proxy.Config{Backends: []string{b1, b2}, HealthInterval: -1})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	front := proxyFront(t, p)
+
+	c1, err := highlevel.Dial(front)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer c1.Close()
+	first := proxyRoundTrip(t, c1, "hello")
+	if !strings.HasSuffix(first, ":127.0.0.1:hello") {
+		t.Fatalf("Expected reply with the client address, got %q", first)
+	}
+	c2, err := highlevel.Dial(front)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer c2.Close()
+	second := proxyRoundTrip(t, c2, "again")
+	if first[:2] == second[:2] {
+		t.Fatalf("Expected the second client on the other backend, got %q and %q", first, second)
+	}
+	for _, st := range p.Backends() {
+		if st.Relays != 1 || !st.Healthy {
+			t.Errorf("Expected one relay on healthy %s, got %+v", st.URL, st)
+		}
+	}
+
+	c1.WriteString("close")
+	_, _, err = c1.ReadMessage()
+	var ce *api.CloseError
+	if !errors.As(err, &ce) || ce.Code != 4001 || ce.Reason != "bye" {
+		t.Fatalf("Expected the backend's 4001 close, got %v", err)
+	}
+	if !waitFor(t, 2*time.Second, func() bool {
+		total := 0
+		for _, st := range p.Backends() {
+			total += st.Relays
+		}
+		return total == 1
+	}) {
+		t.Fatalf("Expected the closed relay to be released, got %+v", p.Backends())
+	}
+}
+
+// TestReverseProxyDrain tests that a drained backend takes no new clients
+// and that its relays are closed with 1001 when the drain times out.
+func TestReverseProxyDrain(t *testing.T) {
+	b1, b2 := proxyBackend(t, "b1"), proxyBackend(t, "b2")
+	p, err := proxy.New(proxy.Config{Backends: []string{b1}, HealthInterval: -1})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	front := proxyFront(t, p)
+
+	c1, err := highlevel.Dial(front)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer c1.Close()
+	proxyRoundTrip(t, c1, "hello")
+	if err := p.Add(b2); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	drained := make(chan error, 1)
+	go func() { drained <- p.Drain(ctx, b1) }()
+	if !waitFor(t, time.Second, func() bool {
+		for _, st := range p.Backends() {
+			if st.URL == b1 {
+				return st.Draining
+			}
+		}
+		return false
+	}) {
+		t.Fatalf("Expected %s draining, got %+v", b1, p.Backends())
+	}
+
+	c2, err := highlevel.Dial(front)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer c2.Close()
+	if reply := proxyRoundTrip(t, c2, "new"); !strings.HasPrefix(reply, "b2:") {
+		t.Fatalf("Expected the new client on b2, got %q", reply)
+	}
+	if err := <-drained; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the drain to time out, got %v", err)
+	}
+	_, _, err = c1.ReadMessage()
+	var ce *api.CloseError
+	if !errors.As(err, &ce) || ce.Code != protocol.CloseGoingAway {
+		t.Fatalf("Expected 1001 on the drained relay, got %v", err)
+	}
+	if st := p.Backends(); len(st) != 1 || st[0].URL != b2 {
+		t.Fatalf("Expected only b2 left, got %+v", st)
+	}
+	if err := p.Drain(context.Background(), b1); !errors.Is(err, proxy.ErrUnknownBackend) {
+		t.Fatalf("Expected ErrUnknownBackend, got %v", err)
+	}
+}
+
+// TestReverseProxyHealth tests that a backend failing its checks is skipped
+// and that clients are refused with 1013 when none is usable.
+func TestReverseProxyHealth(t *testing.T) {
+	live := proxyBackend(t, "live")
+	dead := fmt.Sprintf("ws://127.0.0.1:%d/ws", freePort(t))
+	p, err := proxy.New(proxy.Config{
+		Backends:       []string{dead, live},
+		HealthInterval: 50 * time.Millisecond,
+		HealthCheck: func(ctx context.Context, backend string) error {
+			if backend == dead {
+				return errors.New("down")
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	front := proxyFront(t, p)
+	if !waitFor(t, 2*time.Second, func() bool {
+		for _, st := range p.Backends() {
+			if st.URL == dead {
+				return !st.Healthy
+			}
+		}
+		return false
+	}) {
+		t.Fatalf("Expected the dead backend marked unhealthy, got %+v", p.Backends())
+	}
+	for i := 0; i < 3; i++ {
+		c, err := highlevel.Dial(front)
+		if err != nil {
+			t.Fatalf("Failed to dial: %v", err)
+		}
+		if reply := proxyRoundTrip(t, c, "ping"); !strings.HasPrefix(reply, "live:") {
+			t.Fatalf("Expected the live backend, got %q", reply)
+		}
+		c.Close()
+	}
+
+	if err := p.Drain(context.Background(), live); err != nil {
+		t.Fatalf("Drain failed: %v", err)
+	}
+	c, err := highlevel.Dial(front)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer c.Close()
+	_, _, err = c.ReadMessage()
+	var ce *api.CloseError
+	if !errors.As(err, &ce) || ce.Code != protocol.CloseTryAgainLater {
+		t.Fatalf("Expected 1013 without a backend, got %v", err)
+	}
+	if _, err := proxy.New(proxy.Config{Backends: []string{"http://127.0.0.1:80/"}}); err == nil {
+		t.Fatal("Expected an error for an http backend")
+	}
+}
+
+// TestReverseProxyTCP tests relaying to a byte-stream backend.
+func TestReverseProxyTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() { io.Copy(conn, conn); conn.Close() }()
+		}
+	}()
+	p, err := proxy.New(proxy.Config{Backends: []string{"tcp://" + ln.Addr().String()}})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	front := proxyFront(t, p)
+
+	c, err := highlevel.Dial(front)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer c.Close()
+	if err := c.WriteString("over tcp"); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	mt, reply, err := c.ReadMessage()
+	if err != nil || mt != int(highlevel.BinaryMessage) || string(reply) != "over tcp" {
+		t.Fatalf("Expected the bytes back as a binary message, got %d %q (err=%v)", mt, reply, err)
+	}
+}
+
+// TestReverseProxySticky tests that clients with the same key reach the
+// same backend and move only when it is drained.
+func TestReverseProxySticky(t *testing.T) {
+	b1, b2 := proxyBackend(t, "b1"), proxyBackend(t, "b2")
+	p, err := proxy.New(proxy.Config{
+		Backends:       []string{b1, b2},
+		HealthInterval: -1,
+		StickyKey:      func(c *highlevel.Conn) string { return c.RequestHeader().Get("X-User") },
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	front := proxyFront(t, p)
+	var moved []string
+	p.Router().OnChange(func(ch router.Change) {
+		for _, u := range []string{"alice", "bob", "carol", "dave"} {
+			if ch.Moved(u) {
+				moved = append(moved, u)
+			}
+		}
+	})
+
+	// backendOf returns the name of the backend serving user.
+	backendOf := func(user string) string {
+		c, err := highlevel.Dial(front, highlevel.WithHeader("X-User", user))
+		if err != nil {
+			t.Fatalf("Failed to dial: %v", err)
+		}
+		defer c.Close()
+		return proxyRoundTrip(t, c, "hi")[:2]
+	}
+	owners := map[string]string{}
+	for _, u := range []string{"alice", "bob", "carol", "dave"} {
+		owners[u] = backendOf(u)
+		if got := backendOf(u); got != owners[u] {
+			t.Fatalf("Expected %s to stay on %s, got %s", u, owners[u], got)
+		}
+		if want, _ := p.Router().Get(u); want != map[string]string{"b1": b1, "b2": b2}[owners[u]] {
+			t.Fatalf("Expected %s on the router's choice %s, got %s", u, want, owners[u])
+		}
+	}
+
+	if err := p.Drain(context.Background(), b1); err != nil {
+		t.Fatalf("Drain failed: %v", err)
+	}
+	for u, was := range owners {
+		if got := backendOf(u); got != "b2" {
+			t.Fatalf("Expected %s on b2 after the drain, got %s", u, got)
+		}
+		if slices.Contains(moved, u) != (was == "b1") {
+			t.Fatalf("Expected the change to move exactly b1's users, got %v for %v", moved, owners)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// File: tests/unit/route_metrics_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the per-route figures of MetricsMiddleware.
+
+package unit
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/control"
+	"github.com/momentics/hioload-ws/highlevel"
+)
+
+// TestRouteMetrics tests connection, message, byte and latency figures
+// labelled by route pattern.
+func TestRouteMetrics(t *testing.T) {
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf(":%d", port))
+	srv.Use(highlevel.MetricsMiddleware)
+	echo := func(c *highlevel.Conn) {
+		for {
+			mt, msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+			c.WriteMessage(mt, msg)
+		}
+	}
+	// Figures are process-wide: the port keeps the routes of each run apart.
+	base := fmt.Sprintf("/rm%d", port)
+	srv.HandleFunc(base+"/room/:id", echo)
+	srv.HandleFunc(base+"/lobby", echo)
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	talk := func(path string, msgs ...string) *highlevel.Conn {
+		conn, err := highlevel.Dial(fmt.Sprintf("ws://localhost:%d%s", port, path))
+		if err != nil {
+			t.Fatalf("Failed to dial %s: %v", path, err)
+		}
+		for _, m := range msgs {
+			conn.WriteString(m)
+			if _, _, err := conn.ReadMessage(); err != nil {
+				t.Fatalf("Echo on %s failed: %v", path, err)
+			}
+		}
+		return conn
+	}
+	talk(base+"/room/1", "hello", "hi").Close()
+	defer talk(base+"/room/2", "abc").Close()
+	defer talk(base+"/lobby", "x").Close()
+
+	var stats map[string]any
+	room := `{route="` + base + `/room/:id"}`
+	counter := func(key string) uint64 { return stats[key].(*control.Counter).Value() }
+	waitFor(t, 2*time.Second, func() bool {
+		stats = highlevel.RouteMetrics()
+		return stats["route.connections_active"+room] == int64(1)
+	})
+	if got := stats["route.connections_active"+room]; got != int64(1) {
+		t.Errorf("Expected 1 active connection on the room route, got %v", got)
+	}
+	if got := counter("route.connections" + room); got != 2 {
+		t.Errorf("Expected 2 connections on the room route, got %d", got)
+	}
+	if in, out := counter("route.messages_in"+room), counter("route.messages_out"+room); in != 3 || out != 3 {
+		t.Errorf("Expected 3 messages each way, got %d in, %d out", in, out)
+	}
+	if in, out := counter("route.bytes_in"+room), counter("route.bytes_out"+room); in != 10 || out != 10 {
+		t.Errorf("Expected 10 bytes each way, got %d in, %d out", in, out)
+	}
+	lat := stats["route.handler_latency"+room].(*control.LatencyHistogram)
+	if lat.Count() < 2 || lat.Quantile(0.5) < 5*time.Millisecond {
+		t.Errorf("Expected handler latencies of at least 5ms, got %d with median %v", lat.Count(), lat.Quantile(0.5))
+	}
+	if got := counter(`route.messages_in{route="` + base + `/lobby"}`); got != 1 {
+		t.Errorf("Expected 1 message on the lobby route, got %d", got)
+	}
+
+	rec := httptest.NewRecorder()
+	control.PrometheusHandler(highlevel.RouteMetrics).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if want := `hioload_route_messages_in_total{route="` + base + `/lobby"} 1`; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("Missing %q in:\n%s", want, rec.Body.String())
+	}
+}
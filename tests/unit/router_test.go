@@ -0,0 +1,123 @@
+// File: tests/unit/router_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the consistent-hashing router.
+
+package unit
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/momentics/hioload-ws/router"
+)
+
+// TestRouterBalance tests that keys spread evenly and that two routers with
+// the same members agree on every key.
+func TestRouterBalance(t *testing.T) {
+	r := router.New()
+	if _, ok := r.Get("k"); ok {
+		t.Fatal("Expected no owner on an empty router")
+	}
+	r.Add("node-a", "node-b", "node-c", "node-d")
+	other := router.New()
+	other.Set("node-d", "node-c", "node-b", "node-a", "node-a")
+	if got := other.Nodes(); !slices.Equal(got, []string{"node-a", "node-b", "node-c", "node-d"}) {
+		t.Fatalf("Expected sorted distinct members, got %v", got)
+	}
+
+	const keys = 20000
+	counts := map[string]int{}
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		owner, _ := r.Get(key)
+		if o, _ := other.Get(key); o != owner {
+			t.Fatalf("Routers disagree on %s: %s vs %s", key, owner, o)
+		}
+		counts[owner]++
+	}
+	for node, n := range counts {
+		if n < keys/4*7/10 || n > keys/4*13/10 {
+			t.Errorf("Expected about %d keys on %s, got %d", keys/4, node, n)
+		}
+	}
+}
+
+// TestRouterRebalance tests that adding a node only moves keys to it,
+// removing one only moves its keys, and that the hooks report exactly the
+// moved keys.
+func TestRouterRebalance(t *testing.T) {
+	r := router.New(router.WithReplicas(100))
+	r.Add("a", "b", "c")
+	var changes []router.Change
+	r.OnChange(func(ch router.Change) { changes = append(changes, ch) })
+
+	before := map[string]string{}
+	for i := 0; i < 5000; i++ {
+		key := fmt.Sprintf("s%d", i)
+		before[key], _ = r.Get(key)
+	}
+	r.Add("d", "a")
+	if len(changes) != 1 || !slices.Equal(changes[0].Added, []string{"d"}) || len(changes[0].Removed) != 0 {
+		t.Fatalf("Expected one change adding d, got %+v", changes)
+	}
+	moved := 0
+	for key, old := range before {
+		now, _ := r.Get(key)
+		if now != old {
+			moved++
+			if now != "d" {
+				t.Fatalf("Key %s moved from %s to %s instead of d", key, old, now)
+			}
+		}
+		if changes[0].Moved(key) != (now != old) {
+			t.Fatalf("Change misreports key %s (%s -> %s)", key, old, now)
+		}
+	}
+	if moved < 5000/4*7/10 || moved > 5000/4*13/10 {
+		t.Errorf("Expected about a quarter of the keys to move, got %d", moved)
+	}
+
+	r.Remove("b", "x")
+	if len(changes) != 2 || !slices.Equal(changes[1].Removed, []string{"b"}) {
+		t.Fatalf("Expected a change removing b, got %+v", changes[1:])
+	}
+	for key := range before {
+		was, now := changes[1].Owner(key)
+		if was != "b" && was != now {
+			t.Fatalf("Key %s moved from %s to %s though its owner stayed", key, was, now)
+		}
+	}
+	r.Remove("x")
+	if len(changes) != 2 {
+		t.Fatal("Expected no change for a non-member")
+	}
+}
+
+// TestRouterLookup tests the fallback order of Lookup.
+func TestRouterLookup(t *testing.T) {
+	r := router.New()
+	if got := r.Lookup("k", 3); got != nil {
+		t.Fatalf("Expected no nodes, got %v", got)
+	}
+	r.Add("a", "b", "c")
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("k%d", i)
+		all := r.Lookup(key, 5)
+		owner, _ := r.Get(key)
+		if len(all) != 3 || all[0] != owner {
+			t.Fatalf("Expected 3 nodes starting with %s, got %v", owner, all)
+		}
+		if sorted := slices.Sorted(slices.Values(all)); !slices.Equal(sorted, []string{"a", "b", "c"}) {
+			t.Fatalf("Expected distinct nodes, got %v", all)
+		}
+		// Without its owner, a key goes to the next node of its lookup.
+		without := router.New()
+		without.Add(all[1:]...)
+		if next, _ := without.Get(key); next != all[1] {
+			t.Fatalf("Expected %s to fall back to %s, got %s", key, all[1], next)
+		}
+	}
+}
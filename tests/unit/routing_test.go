@@ -0,0 +1,88 @@
+// File: tests/unit/routing_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for highlevel routing: constraints, typed parameters and the
+// NotFound / MethodNotAllowed fallbacks.
+
+package unit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/highlevel"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// TestRoutingConstraints tests typed parameters and 404 before upgrade.
+func TestRoutingConstraints(t *testing.T) {
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf(":%d", port))
+	srv.HandleFunc("/users/:id<int>", func(c *highlevel.Conn) {
+		id, err := c.ParamInt("id")
+		if _, _, rerr := c.ReadMessage(); rerr != nil {
+			return
+		}
+		c.WriteString(fmt.Sprintf("user %d %v", id, err))
+	})
+	srv.HandleFunc("/orders/:id<uuid>", func(c *highlevel.Conn) {
+		id, err := c.ParamUUID("id")
+		if _, _, rerr := c.ReadMessage(); rerr != nil {
+			return
+		}
+		c.WriteString(fmt.Sprintf("order %x %v", id[:2], err))
+	})
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	for path, want := range map[string]string{
+		"/users/42": "user 42 <nil>",
+		"/orders/123e4567-e89b-12d3-a456-426614174000": "order 123e <nil>",
+	} {
+		conn, err := highlevel.Dial(fmt.Sprintf("ws://localhost:%d%s", port, path))
+		if err != nil {
+			t.Fatalf("Failed to dial %s: %v", path, err)
+		}
+		conn.WriteString("hi")
+		if _, msg, err := conn.ReadMessage(); err != nil || string(msg) != want {
+			t.Errorf("%s: expected %q, got %q (err=%v)", path, want, msg, err)
+		}
+		conn.Close()
+	}
+
+	for _, path := range []string{"/users/abc", "/orders/42", "/nowhere"} {
+		upgrade(t, port, path, nil, http.StatusNotFound)
+	}
+}
+
+// TestRoutingFallbacks tests the default 405 and a NotFound handler closing
+// the upgraded connection with an application code.
+func TestRoutingFallbacks(t *testing.T) {
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf(":%d", port))
+	srv.POST("/submit", func(c *highlevel.Conn) {})
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	upgrade(t, port, "/submit", nil, http.StatusMethodNotAllowed)
+	srv.MethodNotAllowed(highlevel.Fallback{Status: http.StatusForbidden, Reason: "no"})
+	upgrade(t, port, "/submit", nil, http.StatusForbidden)
+
+	srv.NotFound(highlevel.Fallback{Handler: func(c *highlevel.Conn) {
+		c.CloseWithCode(4404, "no such route")
+	}})
+	conn, br, _ := upgrade(t, port, "/nowhere", nil, http.StatusSwitchingProtocols)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	frame, err := protocol.DecodeFrame(br)
+	if err != nil || frame.Opcode != protocol.OpcodeClose {
+		t.Fatalf("Expected close frame without sending a message, got %v (err=%v)", frame, err)
+	}
+	if code := uint16(frame.Payload[0])<<8 | uint16(frame.Payload[1]); code != 4404 || string(frame.Payload[2:]) != "no such route" {
+		t.Errorf("Expected 4404 no such route, got %d %q", code, frame.Payload[2:])
+	}
+}
@@ -0,0 +1,81 @@
+// File: tests/unit/send_frames_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for batched frame submission with WSConnection.SendFrames.
+
+package unit
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// TestSendFrames tests that a batch is queued without waiting and submitted
+// in one transport Send with one segment per frame, in order.
+func TestSendFrames(t *testing.T) {
+	var mu sync.Mutex
+	var calls [][][]byte
+	release := make(chan struct{})
+	tr := &api.MockTransport{
+		SendFunc: func(b [][]byte) error {
+			<-release
+			segs := make([][]byte, len(b))
+			for i, seg := range b {
+				segs[i] = append([]byte(nil), seg...)
+			}
+			mu.Lock()
+			calls = append(calls, segs)
+			mu.Unlock()
+			return nil
+		},
+		CloseFunc: func() error { return nil },
+	}
+	conn := protocol.NewWSConnection(tr, nil, 4)
+
+	var frames []*protocol.WSFrame
+	for i := 0; i < 5; i++ {
+		p := []byte(fmt.Sprintf("msg%d", i))
+		frames = append(frames, &protocol.WSFrame{IsFinal: true, Opcode: protocol.OpcodeText, Payload: p, PayloadLen: int64(len(p))})
+	}
+	// The transport is blocked: SendFrames must return anyway.
+	if err := conn.SendFrames(frames); err != nil {
+		t.Fatalf("SendFrames: %v", err)
+	}
+	close(release)
+	sent := func() int64 { return conn.GetStats()["frames_sent"] }
+	if !waitFor(t, 2*time.Second, func() bool { return sent() == int64(len(frames)) }) {
+		t.Fatalf("frames_sent = %d, want %d", sent(), len(frames))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(calls) != 1 {
+		t.Fatalf("Expected 1 transport Send, got %d", len(calls))
+	}
+	if len(calls[0]) != len(frames) {
+		t.Fatalf("Expected %d segments, got %d", len(frames), len(calls[0]))
+	}
+	for i, seg := range calls[0] {
+		f, err := protocol.DecodeFrame(bytes.NewReader(seg))
+		if err != nil {
+			t.Fatalf("segment %d: %v", i, err)
+		}
+		if want := fmt.Sprintf("msg%d", i); string(f.Payload) != want {
+			t.Errorf("segment %d: got %q, want %q", i, f.Payload, want)
+		}
+	}
+
+	if err := conn.SendFrames(nil); err != nil {
+		t.Errorf("empty batch: %v", err)
+	}
+	conn.Close()
+	if err := conn.SendFrames(frames[:1]); err != api.ErrTransportClosed {
+		t.Errorf("after Close: got %v, want ErrTransportClosed", err)
+	}
+}
@@ -0,0 +1,96 @@
+// File: tests/unit/server_options_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for highlevel server options applied to each connection.
+
+package unit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/highlevel"
+)
+
+// TestServerOptions_ReadLimitAndTimeout tests that WithReadLimit and
+// WithReadTimeout reach the connections handed to handlers.
+func TestServerOptions_ReadLimitAndTimeout(t *testing.T) {
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf(":%d", port),
+		highlevel.WithReadLimit(8),
+		highlevel.WithReadTimeout(100*time.Millisecond),
+		highlevel.WithWriteTimeout(time.Second),
+	)
+	srv.HandleFunc("/opts", func(c *highlevel.Conn) {
+		for {
+			start := time.Now()
+			_, msg, err := c.ReadMessage()
+			switch {
+			case err == nil:
+				c.WriteString("ok:" + string(msg))
+			case strings.Contains(err.Error(), "read limit"):
+				c.WriteString("limit")
+			default:
+				c.WriteString(fmt.Sprintf("%v after %v", err, time.Since(start).Round(100*time.Millisecond)))
+				return
+			}
+		}
+	})
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	conn, err := highlevel.Dial(fmt.Sprintf("ws://localhost:%d/opts", port))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	conn.WriteString("short")
+	conn.WriteString("far too long for the limit")
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for _, want := range []string{"ok:short", "limit", "read timeout after 100ms"} {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Read failed waiting for %q: %v", want, err)
+		}
+		if string(msg) != want {
+			t.Errorf("Expected %q, got %q", want, msg)
+		}
+	}
+}
+
+// TestServerOptions_WriteTimeout tests that WithWriteTimeout fails the
+// writes to a peer that stopped reading.
+func TestServerOptions_WriteTimeout(t *testing.T) {
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf(":%d", port), highlevel.WithWriteTimeout(200*time.Millisecond))
+	result := make(chan error, 1)
+	srv.HandleFunc("/slow", func(c *highlevel.Conn) {
+		chunk := make([]byte, 64<<10)
+		for i := 0; i < 4096; i++ {
+			if err := c.WriteMessage(int(highlevel.BinaryMessage), chunk); err != nil {
+				result <- err
+				return
+			}
+		}
+		result <- nil
+	})
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	conn, _, _ := upgrade(t, port, "/slow", nil, http.StatusSwitchingProtocols)
+	defer conn.Close()
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Fatal("Wrote 256 MiB to a peer not reading")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Writes did not time out")
+	}
+}
@@ -0,0 +1,551 @@
+// Package unit tests the public session API.
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+
+package unit
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/highlevel"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+	"github.com/momentics/hioload-ws/protocol"
+	"github.com/momentics/hioload-ws/session"
+)
+
+// TestSessionManager_Attributes tests lookup, typed attributes, Range and Close.
+func TestSessionManager_Attributes(t *testing.T) {
+	m := session.NewSessionManager(4)
+	s := m.Open()
+	if len(s.ID()) != 32 {
+		t.Fatalf("Expected 32-char hex ID, got %q", s.ID())
+	}
+
+	session.Set(s, "user", "alice")
+	s.Set("visits", 3)
+	if v, ok := session.Get[string](s, "user"); !ok || v != "alice" {
+		t.Errorf("Expected user alice, got %q (ok=%v)", v, ok)
+	}
+	if _, ok := session.Get[string](s, "visits"); ok {
+		t.Error("Expected typed Get to reject mismatched type")
+	}
+
+	got, ok := m.Get(s.ID())
+	if !ok || got != s {
+		t.Fatal("Expected lookup by ID to return the same session")
+	}
+
+	m.Create("fixed")
+	seen := 0
+	m.Range(func(session.Session) { seen++ })
+	if seen != 2 || m.Len() != 2 {
+		t.Errorf("Expected 2 sessions, ranged %d, Len %d", seen, m.Len())
+	}
+
+	m.Close(s.ID())
+	select {
+	case <-s.Done():
+	default:
+		t.Error("Expected closed session to be cancelled")
+	}
+	if _, ok := m.Get(s.ID()); ok {
+		t.Error("Expected closed session to be removed")
+	}
+}
+
+// TestSessionManager_Expiry tests idle eviction, TTL and lifecycle hooks.
+func TestSessionManager_Expiry(t *testing.T) {
+	var mu sync.Mutex
+	events := map[string][]string{}
+	record := func(kind string) func(session.Session) {
+		return func(s session.Session) {
+			mu.Lock()
+			events[kind] = append(events[kind], s.ID())
+			mu.Unlock()
+		}
+	}
+	m := session.NewSessionManager(0,
+		session.WithIdleTimeout(40*time.Millisecond),
+		session.WithSweepInterval(10*time.Millisecond),
+		session.OnCreate(record("create")),
+		session.OnExpire(record("expire")),
+		session.OnClose(record("close")),
+	)
+	defer m.Stop()
+
+	idle := m.Create("idle")
+	busy := m.Create("busy")
+	closed := m.Create("closed")
+	m.Close(closed.ID())
+
+	// busy is touched on every poll until the sweeper expires idle.
+	if !waitFor(t, time.Second, func() bool {
+		busy.Touch()
+		mu.Lock()
+		defer mu.Unlock()
+		return len(events["expire"]) > 0
+	}) {
+		t.Fatal("Expected idle session to expire")
+	}
+	select {
+	case <-idle.Done():
+	default:
+		t.Fatal("Expected the expired session done")
+	}
+	if _, ok := m.Get("busy"); !ok {
+		t.Error("Expected touched session to survive")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events["create"]) != 3 {
+		t.Errorf("Expected 3 OnCreate calls, got %v", events["create"])
+	}
+	if len(events["expire"]) != 1 || events["expire"][0] != "idle" {
+		t.Errorf("Expected OnExpire for idle only, got %v", events["expire"])
+	}
+	if len(events["close"]) != 1 || events["close"][0] != "closed" {
+		t.Errorf("Expected OnClose for closed only, got %v", events["close"])
+	}
+}
+
+// TestSessionManager_TTL tests that TTL sets a deadline and evicts on expiry.
+func TestSessionManager_TTL(t *testing.T) {
+	m := session.NewSessionManager(0,
+		session.WithTTL(20*time.Millisecond),
+		session.WithSweepInterval(5*time.Millisecond),
+	)
+	defer m.Stop()
+
+	s := m.Open()
+	if _, ok := s.Deadline(); !ok {
+		t.Fatal("Expected TTL to set a deadline")
+	}
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Expected session to expire after TTL")
+	}
+	if m.Len() != 0 {
+		t.Errorf("Expected no live sessions, got %d", m.Len())
+	}
+}
+
+// TestSessionManager_Store tests that retained records restore into a new manager.
+func TestSessionManager_Store(t *testing.T) {
+	store := session.NewMemoryStore(4)
+	first := session.NewSessionManager(0, session.WithStore(store), session.WithRetention(time.Minute))
+	s := first.Create("user:alice")
+	s.Set("visits", 2)
+	first.Close(s.ID())
+	first.Stop()
+
+	rec, err := store.Load("user:alice")
+	if err != nil || rec.Attrs["visits"] != 2 {
+		t.Fatalf("Expected retained record, got %+v (err=%v)", rec, err)
+	}
+
+	// A second manager (e.g. after restart) restores the attributes.
+	second := session.NewSessionManager(0, session.WithStore(store))
+	defer second.Stop()
+	restored := second.Create("user:alice")
+	if v, ok := session.Get[int](restored, "visits"); !ok || v != 2 {
+		t.Errorf("Expected restored visits 2, got %d (ok=%v)", v, ok)
+	}
+
+	// Without retention, closing deletes the record.
+	second.Close(restored.ID())
+	if _, err := store.Load("user:alice"); err != session.ErrNotFound {
+		t.Errorf("Expected ErrNotFound after close, got %v", err)
+	}
+}
+
+// TestSessionManager_ResumeReplay tests token validation and replay on re-attach.
+func TestSessionManager_ResumeReplay(t *testing.T) {
+	m := session.NewSessionManager(0,
+		session.WithResumption([]byte("secret"), time.Minute),
+		session.WithReplayBuffer(2),
+	)
+	defer m.Stop()
+
+	s := m.Open()
+	tok := m.Token(s)
+	if _, ok := m.Resume(tok + "x"); ok {
+		t.Error("Expected tampered token to be rejected")
+	}
+
+	first := &recordingConn{}
+	m.Attach(s.ID(), first)
+	m.Detach(s.ID(), first)
+	for _, msg := range []string{"a", "b", "c"} {
+		if err := m.Send(s.ID(), protocol.OpcodeText, []byte(msg)); err != nil {
+			t.Fatalf("Send while detached failed: %v", err)
+		}
+	}
+
+	resumed, ok := m.Resume(tok)
+	if !ok || resumed.ID() != s.ID() {
+		t.Fatal("Expected token to resume the detached session")
+	}
+	second := &recordingConn{}
+	m.Attach(resumed.ID(), second)
+	if got := second.payloads(); len(got) != 2 || got[0] != "b" || got[1] != "c" {
+		t.Errorf("Expected replay of newest 2 messages [b c], got %v", got)
+	}
+}
+
+// TestSessionManager_TokenRotation tests that a token resumes once, is
+// superseded by the next token issued, and expires after the token TTL.
+func TestSessionManager_TokenRotation(t *testing.T) {
+	m := session.NewSessionManager(0,
+		session.WithResumption([]byte("secret"), time.Minute),
+		session.WithTokenTTL(100*time.Millisecond),
+	)
+	defer m.Stop()
+	s := m.Open()
+
+	old := m.Token(s)
+	tok := m.Token(s)
+	if tok == old {
+		t.Fatal("Expected each token to differ")
+	}
+	if _, ok := m.Resume(old); ok {
+		t.Error("Expected a superseded token to be rejected")
+	}
+	if resumed, ok := m.Resume(tok); !ok || resumed.ID() != s.ID() {
+		t.Fatal("Expected the latest token to resume the session")
+	}
+	if _, ok := m.Resume(tok); ok {
+		t.Error("Expected a used token to be rejected")
+	}
+
+	tok = m.Token(s)
+	time.Sleep(150 * time.Millisecond)
+	if _, ok := m.Resume(tok); ok {
+		t.Error("Expected an expired token to be rejected")
+	}
+	other := session.NewSessionManager(0, session.WithResumption([]byte("other"), time.Minute))
+	defer other.Stop()
+	if _, ok := other.Resume(m.Token(s)); ok {
+		t.Error("Expected a token signed with another secret to be rejected")
+	}
+}
+
+// TestSessionManager_ResumeFromStore tests that a token resumes a session
+// restored from the Store once only, also across managers sharing it.
+func TestSessionManager_ResumeFromStore(t *testing.T) {
+	store := session.NewMemoryStore(4)
+	opts := []session.ManagerOption{
+		session.WithStore(store),
+		session.WithRetention(time.Minute),
+		session.WithResumption([]byte("secret"), 0),
+	}
+	m := session.NewSessionManager(0, opts...)
+	defer m.Stop()
+	s := m.Open()
+	s.Set("visits", 3)
+	old := m.Token(s)
+	tok := m.Token(s)
+	m.Close(s.ID())
+
+	if _, ok := m.Resume(old); ok {
+		t.Error("Expected a superseded token to be rejected after restore")
+	}
+	resumed, ok := m.Resume(tok)
+	if !ok || resumed.ID() != s.ID() {
+		t.Fatal("Expected the token to resume the stored session")
+	}
+	if v, _ := session.Get[int](resumed, "visits"); v != 3 {
+		t.Errorf("Expected restored visits 3, got %d", v)
+	}
+	m.Close(resumed.ID())
+	if _, ok := m.Resume(tok); ok {
+		t.Error("Expected a used token to be rejected after another restore")
+	}
+
+	other := session.NewSessionManager(0, opts...)
+	defer other.Stop()
+	if _, ok := other.Resume(tok); ok {
+		t.Error("Expected a used token to be rejected by another manager")
+	}
+}
+
+// gatedConn is a recordingConn whose first SendFrame waits for gate.
+type gatedConn struct {
+	recordingConn
+	entered, gate chan struct{}
+	once          sync.Once
+}
+
+func (c *gatedConn) SendFrame(f *protocol.WSFrame) error {
+	c.once.Do(func() {
+		close(c.entered)
+		<-c.gate
+	})
+	return c.recordingConn.SendFrame(f)
+}
+
+// TestSessionManager_ReplayOrder tests that a message sent while Attach
+// writes the replay buffer is delivered after it.
+func TestSessionManager_ReplayOrder(t *testing.T) {
+	m := session.NewSessionManager(0,
+		session.WithResumption([]byte("secret"), time.Minute),
+		session.WithReplayBuffer(4),
+	)
+	defer m.Stop()
+	s := m.Open()
+	first := &recordingConn{}
+	m.Attach(s.ID(), first)
+	m.Detach(s.ID(), first)
+	for _, msg := range []string{"a", "b"} {
+		m.Send(s.ID(), protocol.OpcodeText, []byte(msg))
+	}
+
+	conn := &gatedConn{entered: make(chan struct{}), gate: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		m.Attach(s.ID(), conn)
+		close(done)
+	}()
+	<-conn.entered
+	if err := m.Send(s.ID(), protocol.OpcodeText, []byte("c")); err != nil {
+		t.Fatalf("Send during replay: %v", err)
+	}
+	close(conn.gate)
+	<-done
+	if got := conn.payloads(); len(got) != 3 || got[0] != "a" || got[1] != "b" || got[2] != "c" {
+		t.Errorf("Expected [a b c], got %v", got)
+	}
+}
+
+// TestSessionManager_TagBroadcast tests tag membership and targeted fan-out.
+func TestSessionManager_TagBroadcast(t *testing.T) {
+	m := session.NewSessionManager(0, session.WithReplayBuffer(4))
+	defer m.Stop()
+
+	a, b, c, d := m.Open(), m.Open(), m.Open(), m.Open()
+	conns := map[string]*recordingConn{}
+	for _, s := range []session.Session{a, b, c} {
+		conns[s.ID()] = &recordingConn{}
+		m.Attach(s.ID(), conns[s.ID()])
+	}
+	m.Tag(a.ID(), "room:lobby", "tenant:42")
+	m.Tag(b.ID(), "room:lobby")
+	m.Tag(d.ID(), "room:lobby") // detached: message goes to its replay buffer
+	if err := m.Tag("missing", "room:lobby"); err != session.ErrNotFound {
+		t.Errorf("Expected ErrNotFound tagging unknown session, got %v", err)
+	}
+
+	frame := &protocol.WSFrame{IsFinal: true, Opcode: protocol.OpcodeText, Payload: []byte("hi"), PayloadLen: 2}
+	if n := m.Broadcast("room:lobby", frame, a.ID()); n != 2 {
+		t.Errorf("Expected delivery to 2 sessions, got %d", n)
+	}
+	if got := conns[b.ID()].payloads(); len(got) != 1 || got[0] != "hi" {
+		t.Errorf("Expected b to receive broadcast, got %v", got)
+	}
+	if got := conns[a.ID()].payloads(); len(got) != 0 {
+		t.Errorf("Expected excluded sender to receive nothing, got %v", got)
+	}
+	if got := conns[c.ID()].payloads(); len(got) != 0 {
+		t.Errorf("Expected untagged session to receive nothing, got %v", got)
+	}
+	late := &recordingConn{}
+	m.Attach(d.ID(), late)
+	if got := late.payloads(); len(got) != 1 || got[0] != "hi" {
+		t.Errorf("Expected detached member to get replay, got %v", got)
+	}
+
+	m.Close(b.ID())
+	m.Untag(d.ID(), "room:lobby")
+	if got := m.Tagged("room:lobby"); len(got) != 1 || got[0].ID() != a.ID() {
+		t.Errorf("Expected only a left in lobby, got %d sessions", len(got))
+	}
+	if tags := m.Tags(a.ID()); len(tags) != 2 {
+		t.Errorf("Expected a to keep 2 tags, got %v", tags)
+	}
+}
+
+// TestServerResumeHeader tests token issuance and header-based resumption.
+func TestServerResumeHeader(t *testing.T) {
+	port := freePort(t)
+	sessions := session.NewSessionManager(0,
+		session.WithResumption([]byte("secret"), time.Minute),
+		session.WithReplayBuffer(8),
+	)
+	defer sessions.Stop()
+
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf(":%d", port)
+	cfg.ShutdownTimeout = 10 * time.Millisecond
+	srv, err := server.NewServer(cfg, server.WithSessionManager(sessions))
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer srv.Shutdown()
+	runServer(t, srv, api.HandlerFunc(func(any) error { return nil }))
+
+	conn, _, resp := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	tok := resp.Header.Get(session.ResumeHeader)
+	if tok == "" {
+		t.Fatal("Expected resumption token in handshake response")
+	}
+	waitConns(t, srv, 1)
+	conn.Close()
+	waitConns(t, srv, 0) // the session is detached before the slot is freed
+
+	var id string
+	sessions.Range(func(s session.Session) { id = s.ID() })
+	sessions.Send(id, protocol.OpcodeText, []byte("missed"))
+
+	conn, br, resp := upgrade(t, port, "/resume", http.Header{session.ResumeHeader: {tok}}, http.StatusSwitchingProtocols)
+	tok2 := resp.Header.Get(session.ResumeHeader)
+	defer conn.Close()
+	if tok2 == "" || tok2 == tok {
+		t.Errorf("Expected a new token on resume, got %q", tok2)
+	}
+	if _, ok := sessions.Resume(tok); ok {
+		t.Error("Expected the used token to be rejected")
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	frame, err := protocol.DecodeFrame(br)
+	if err != nil || string(frame.Payload) != "missed" {
+		t.Fatalf("Expected replayed message, got %v (err=%v)", frame, err)
+	}
+	if sessions.Len() != 1 {
+		t.Errorf("Expected a single live session, got %d", sessions.Len())
+	}
+}
+
+// recordingConn is a session.Conn capturing sent frames.
+type recordingConn struct {
+	mu     sync.Mutex
+	frames []string
+}
+
+func (c *recordingConn) SendFrame(f *protocol.WSFrame) error {
+	c.mu.Lock()
+	c.frames = append(c.frames, string(f.Payload))
+	c.mu.Unlock()
+	f.Buf.Release()
+	return nil
+}
+
+func (c *recordingConn) Close() error { return nil }
+
+func (c *recordingConn) payloads() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.frames...)
+}
+
+// freePort reserves and releases a local TCP port.
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve port: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// upgrade performs a client handshake for path with header added to the
+// request and fails the test unless the response status is want. The
+// connection and its reader are returned for a 101; otherwise the connection
+// is closed and both are nil.
+func upgrade(t *testing.T, port int, path string, header http.Header, want int) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	req, _ := http.NewRequest("GET", fmt.Sprintf("http://127.0.0.1:%d%s", port, path), nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		t.Fatalf("Failed to write upgrade: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		t.Fatalf("Failed to read the upgrade response for %s: %v", path, err)
+	}
+	if resp.StatusCode != want {
+		conn.Close()
+		t.Fatalf("Expected status %d for %s, got %d", want, path, resp.StatusCode)
+	}
+	if want != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, nil, resp
+	}
+	conn.SetReadDeadline(time.Time{})
+	return conn, br, resp
+}
+
+// readClose skips data frames until the peer's close frame and returns it.
+func readClose(t *testing.T, conn net.Conn, br *bufio.Reader) *protocol.WSFrame {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		frame, err := protocol.DecodeFrame(br)
+		if err != nil {
+			t.Fatalf("Expected a close frame: %v", err)
+		}
+		if frame.Opcode == protocol.OpcodeClose {
+			return frame
+		}
+	}
+}
+
+// TestHighLevelConnSession tests that every server connection carries a Session.
+func TestHighLevelConnSession(t *testing.T) {
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf(":%d", port))
+	srv.HandleFunc("/session", func(c *highlevel.Conn) {
+		s := c.Session()
+		if s == nil {
+			c.Close()
+			return
+		}
+		s.Set("route", "/session")
+		c.WriteString(s.ID())
+	})
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	conn, err := highlevel.Dial(fmt.Sprintf("ws://localhost:%d/session", port))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	if err := conn.WriteString("hello"); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	id, err := conn.ReadString()
+	if err != nil {
+		t.Fatalf("Failed to read session ID: %v", err)
+	}
+
+	s, ok := srv.Sessions().Get(id)
+	if !ok {
+		t.Fatalf("Session %q not found in server manager", id)
+	}
+	if v, _ := session.Get[string](s, "route"); v != "/session" {
+		t.Errorf("Expected route attribute, got %q", v)
+	}
+}
@@ -0,0 +1,134 @@
+// File: tests/unit/shutdown_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the graceful shutdown of the highlevel server.
+
+package unit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/highlevel"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// startBusyServer serves /resume with a handler that runs work on its
+// connection after the first message. It returns once the handler has
+// started, with a reader of the frames sent to the raw client connection.
+func startBusyServer(t *testing.T, work func(*highlevel.Conn)) (*highlevel.Server, func() (*protocol.WSFrame, error)) {
+	started := make(chan struct{})
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf(":%d", port))
+	srv.HandleFunc("/resume", func(c *highlevel.Conn) {
+		if _, _, err := c.ReadMessage(); err != nil {
+			return
+		}
+		close(started)
+		work(c)
+	})
+	serve(t, srv)
+
+	conn, br, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	t.Cleanup(func() { conn.Close() })
+	conn.Write([]byte{0x81, 0x82, 0, 0, 0, 0, 'h', 'i'})
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Handler not started")
+	}
+	return srv, func() (*protocol.WSFrame, error) {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		return protocol.DecodeFrame(br)
+	}
+}
+
+// TestServerShutdown_Drains tests that Shutdown sends 1001 Going Away and
+// waits for a running handler to return.
+func TestServerShutdown_Drains(t *testing.T) {
+	srv, readFrame := startBusyServer(t, func(*highlevel.Conn) { time.Sleep(300 * time.Millisecond) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Errorf("Expected clean shutdown, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("Shutdown returned after %v without waiting for the handler", elapsed)
+	}
+
+	frame, err := readFrame()
+	if err != nil {
+		t.Fatalf("Failed to read frame: %v", err)
+	}
+	if frame.Opcode != protocol.OpcodeClose || len(frame.Payload) < 2 {
+		t.Fatalf("Expected close frame, got %+v", frame)
+	}
+	if code := uint16(frame.Payload[0])<<8 | uint16(frame.Payload[1]); code != protocol.CloseGoingAway {
+		t.Errorf("Expected close code 1001, got %d", code)
+	}
+}
+
+// TestServerShutdown_CloseLast tests that the going-away frame follows the
+// messages already queued and is the last frame: later writes fail.
+func TestServerShutdown_CloseLast(t *testing.T) {
+	queued := make(chan struct{})
+	goingAway := make(chan struct{})
+	lateErr := make(chan error, 1)
+	srv, readFrame := startBusyServer(t, func(c *highlevel.Conn) {
+		c.WriteString("first")
+		c.WriteString("second")
+		close(queued)
+		<-goingAway
+		lateErr <- c.WriteString("late")
+	})
+	<-queued
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Shutdown(ctx) }()
+
+	for _, want := range []string{"first", "second"} {
+		if frame, err := readFrame(); err != nil || frame.Opcode != protocol.OpcodeText || string(frame.Payload) != want {
+			t.Fatalf("Expected %q ahead of the close frame, got %+v, %v", want, frame, err)
+		}
+	}
+	if frame, err := readFrame(); err != nil || frame.Opcode != protocol.OpcodeClose {
+		t.Fatalf("Expected close frame, got %+v, %v", frame, err)
+	}
+	close(goingAway)
+	if err := <-lateErr; err == nil {
+		t.Error("Expected a write after the close frame to fail")
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("Expected clean shutdown, got %v", err)
+	}
+	if frame, err := readFrame(); err == nil {
+		t.Errorf("Expected no frame after the close frame, got %+v", frame)
+	}
+}
+
+// TestServerShutdown_Deadline tests that Shutdown gives up on a stuck
+// handler when the context expires.
+func TestServerShutdown_Deadline(t *testing.T) {
+	stuck := make(chan struct{})
+	defer close(stuck)
+	srv, _ := startBusyServer(t, func(*highlevel.Conn) { <-stuck })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := srv.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Shutdown took %v past its deadline", elapsed)
+	}
+}
@@ -0,0 +1,365 @@
+// File: tests/unit/sim_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the simulated clock and transports, and for keepalive,
+// read timeouts and draining run on them.
+
+package unit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+	"github.com/momentics/hioload-ws/pool"
+	"github.com/momentics/hioload-ws/protocol"
+	"github.com/momentics/hioload-ws/sim"
+)
+
+// TestSimClock tests that timers, tickers and scheduled jobs fire in order
+// at their simulated times and only when the clock is advanced.
+func TestSimClock(t *testing.T) {
+	clk := sim.NewClock(time.Time{})
+	start := clk.Now()
+	var fired []string
+	note := func(name string) func() {
+		return func() { fired = append(fired, fmt.Sprintf("%s@%s", name, clk.Since(start))) }
+	}
+	clk.AfterFunc(2*time.Second, note("b"))
+	clk.AfterFunc(time.Second, note("a"))
+	stopped := clk.AfterFunc(time.Second, note("stopped"))
+	clk.AfterFunc(2*time.Second, note("c")) // same time as b, armed later
+	timer := clk.NewTimer(5 * time.Second)
+	tick := clk.NewTicker(1500 * time.Millisecond)
+	sched := sim.NewScheduler(clk)
+	job, _ := sched.Schedule(int64(3*time.Second), note("job"))
+	cancelled, _ := sched.Schedule(int64(3*time.Second), note("cancelled"))
+
+	if !stopped.Stop() || stopped.Stop() {
+		t.Fatal("Stop should report only the first stop of an armed timer")
+	}
+	sched.Cancel(cancelled)
+	if d, ok := clk.Next(); !ok || d != time.Second || clk.Pending() != 6 {
+		t.Fatalf("Next %v %v, pending %d", d, ok, clk.Pending())
+	}
+	select {
+	case <-tick.C():
+		t.Fatal("ticker fired before the clock moved")
+	default:
+	}
+
+	clk.Advance(3 * time.Second)
+	if got := fmt.Sprint(fired); got != "[a@1s b@2s c@2s job@3s]" {
+		t.Errorf("fired %s", got)
+	}
+	if at := <-tick.C(); at.Sub(start) != 1500*time.Millisecond {
+		t.Errorf("first tick at %v", at.Sub(start))
+	}
+	select {
+	case <-tick.C():
+		t.Error("tick at 3s should be dropped while the first was not received")
+	default:
+	}
+	select {
+	case <-job.Done():
+	default:
+		t.Error("job not done")
+	}
+	if job.Err() != nil || !errors.Is(cancelled.Err(), context.Canceled) {
+		t.Errorf("job err %v, cancelled err %v", job.Err(), cancelled.Err())
+	}
+	if timer.Reset(time.Second) != true {
+		t.Error("Reset of an armed timer should report it was armed")
+	}
+	clk.Advance(time.Second)
+	if at := <-timer.C(); at.Sub(start) != 4*time.Second {
+		t.Errorf("reset timer fired at %v", at.Sub(start))
+	}
+	tick.Stop()
+	if clk.Pending() != 0 {
+		t.Errorf("pending %d after all fired or stopped", clk.Pending())
+	}
+	if got := clk.Since(start); got != 4*time.Second || sched.Now() != clk.Now().UnixNano() {
+		t.Errorf("clock at %v", got)
+	}
+}
+
+// TestSimPipe tests that pipe ends exchange batches, honour deadlines on the
+// simulated clock and report the other end closing.
+func TestSimPipe(t *testing.T) {
+	clk := sim.NewClock(time.Time{})
+	a, b := sim.Pipe(clk)
+	msg := []byte("one")
+	a.Send([][]byte{msg, []byte("two")})
+	msg[0] = 'X' // sent data is copied
+	if got, err := b.Recv(); err != nil || len(got) != 2 || string(got[0]) != "one" {
+		t.Fatalf("Recv %q %v", got, err)
+	}
+	if a.RemoteAddr() != b.LocalAddr() || a.LocalAddr().Network() != "sim" {
+		t.Errorf("addresses %v %v", a.RemoteAddr(), b.LocalAddr())
+	}
+
+	b.SetTimeouts(time.Second, 0)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := b.Recv()
+		errc <- err
+	}()
+	clk.BlockUntil(1)
+	clk.Advance(999 * time.Millisecond)
+	select {
+	case err := <-errc:
+		t.Fatalf("Recv returned %v before its deadline", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	clk.Advance(time.Millisecond)
+	if err := simWait(t, errc); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	b.SetTimeouts(0, 0)
+	a.Send([][]byte{[]byte("last")})
+	a.Close()
+	if got, err := b.Recv(); err != nil || string(got[0]) != "last" {
+		t.Fatalf("Recv after close %q %v", got, err)
+	}
+	if _, err := b.Recv(); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+	if err := b.Send([][]byte{msg}); err == nil {
+		t.Error("Send to a closed end should fail")
+	}
+	if err := a.Send([][]byte{msg}); !errors.Is(err, api.ErrTransportClosed) {
+		t.Errorf("Send on a closed end: %v", err)
+	}
+}
+
+// TestSimKeepAlive tests that keepalive pings go out at the simulated
+// interval.
+func TestSimKeepAlive(t *testing.T) {
+	clk := sim.NewClock(time.Time{})
+	a, b := sim.Pipe(clk)
+	conn := protocol.NewWSConnection(a, pool.DefaultManager().GetPool(4096, -1), 16)
+	conn.SetClock(clk)
+	defer conn.Close()
+	go conn.KeepAlive(30 * time.Second)
+	clk.BlockUntil(1)
+	for i := 0; i < 3; i++ {
+		clk.Advance(30 * time.Second)
+		if f := simFrame(t, b); f.Opcode != protocol.OpcodePing {
+			t.Fatalf("ping %d: opcode %d", i, f.Opcode)
+		}
+	}
+	if got := clk.Since(sim.Epoch); got != 90*time.Second {
+		t.Errorf("clock at %v", got)
+	}
+}
+
+// TestSimServerReadTimeout tests that a server on the simulated clock pings
+// at the keepalive interval and closes a silent connection with 1001 once
+// the read timeout passed.
+func TestSimServerReadTimeout(t *testing.T) {
+	clk := sim.NewClock(time.Time{})
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf("127.0.0.1:%d", freePort(t))
+	cfg.ShutdownTimeout = 10 * time.Millisecond
+	cfg.Clock = clk
+	cfg.KeepAlive = 10 * time.Second
+	cfg.ReadTimeout = 25 * time.Second
+	srv := startSimServer(t, cfg)
+
+	a, b := sim.Pipe(clk)
+	if err := srv.ServeTransport(a, httptest.NewRequest("GET", "/sim", nil)); err != nil {
+		t.Fatalf("ServeTransport: %v", err)
+	}
+	waitConns(t, srv, 1)
+	for _, at := range []time.Duration{10 * time.Second, 20 * time.Second} {
+		clk.BlockUntil(2) // the keepalive timer and the read deadline
+		clk.Advance(10 * time.Second)
+		if f := simFrame(t, b); f.Opcode != protocol.OpcodePing {
+			t.Fatalf("at %v: opcode %d, expected ping", at, f.Opcode)
+		}
+	}
+	clk.BlockUntil(2)
+	clk.Advance(5 * time.Second)
+	f := simFrame(t, b)
+	if code, reason, _ := protocol.ParseClosePayload(f.Payload); f.Opcode != protocol.OpcodeClose ||
+		code != protocol.CloseGoingAway || reason != "read timeout" {
+		t.Fatalf("expected 1001 read timeout at 25s, got opcode %d %d %q", f.Opcode, code, reason)
+	}
+	waitConns(t, srv, 0)
+}
+
+// TestSimServerDrain tests that Drain sends 1001 to connections once they were
+// idle for DrainIdle of simulated time, idle ones first, and that the peer's
+// answer closes them.
+func TestSimServerDrain(t *testing.T) {
+	clk := sim.NewClock(time.Time{})
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf("127.0.0.1:%d", freePort(t))
+	cfg.ShutdownTimeout = 10 * time.Millisecond
+	cfg.Clock = clk
+	cfg.DrainIdle = 5 * time.Second
+	srv := startSimServer(t, cfg)
+
+	var clients []*sim.Transport
+	for i := 0; i < 2; i++ {
+		a, b := sim.Pipe(clk)
+		if err := srv.ServeTransport(a, httptest.NewRequest("GET", "/sim", nil)); err != nil {
+			t.Fatalf("ServeTransport: %v", err)
+		}
+		clients = append(clients, b)
+	}
+	idle, busy := clients[0], clients[1]
+	waitConns(t, srv, 2)
+
+	clk.Advance(3 * time.Second)
+	busy.Send([][]byte{maskedFrame([]byte("x"))})
+	if f := simFrame(t, busy); string(f.Payload) != "x" {
+		t.Fatalf("echo %q", f.Payload)
+	}
+	idleClose, busyClose := simAnswerClose(idle), simAnswerClose(busy)
+
+	var drainErr error
+	drained := make(chan struct{})
+	go func() {
+		drainErr = srv.Drain(context.Background())
+		close(drained)
+	}()
+	clk.BlockUntil(1) // the drain ticker
+	simAdvanceUntil(t, clk, func() bool { return srv.GetActiveConnections() == 1 })
+	if now := clk.Since(sim.Epoch); now < 5*time.Second || now >= 8*time.Second {
+		t.Fatalf("idle connection closed at %v, expected between 5s and 8s", now)
+	}
+	if code := simWait(t, idleClose); code != protocol.CloseGoingAway {
+		t.Fatalf("idle: close code %d, expected 1001", code)
+	}
+	simAdvanceUntil(t, clk, func() bool { return srv.GetActiveConnections() == 0 })
+	if now := clk.Since(sim.Epoch); now < 8*time.Second {
+		t.Fatalf("busy connection closed at %v, expected from 8s", now)
+	}
+	if code := simWait(t, busyClose); code != protocol.CloseGoingAway {
+		t.Fatalf("busy: close code %d, expected 1001", code)
+	}
+	// Drain notices on its next tick.
+	simAdvanceUntil(t, clk, func() bool {
+		select {
+		case <-drained:
+			return true
+		default:
+			return false
+		}
+	})
+	if drainErr != nil {
+		t.Fatalf("Drain: %v", drainErr)
+	}
+}
+
+// startSimServer runs a server echoing data frames.
+func startSimServer(t *testing.T, cfg *server.Config) *server.Server {
+	t.Helper()
+	srv, err := server.NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+	runServer(t, srv, api.HandlerFunc(func(data any) error {
+		evt, ok := data.(interface {
+			WSConnection() *protocol.WSConnection
+			GetBuffer() api.Buffer
+		})
+		if !ok {
+			return nil
+		}
+		payload := append([]byte(nil), evt.GetBuffer().Bytes()...)
+		evt.GetBuffer().Release()
+		return evt.WSConnection().SendFrame(&protocol.WSFrame{
+			IsFinal: true, Opcode: protocol.OpcodeText, Payload: payload, PayloadLen: int64(len(payload)),
+		})
+	}))
+	if !waitFor(t, time.Second, func() bool { return srv.Health().Ready(context.Background()).OK() }) {
+		t.Fatal("server not ready")
+	}
+	return srv
+}
+
+// simFrame decodes the first frame of the next batch tr receives. Real time
+// only bounds the wait, so a broken test fails instead of hanging.
+func simFrame(t *testing.T, tr *sim.Transport) *protocol.WSFrame {
+	t.Helper()
+	type result struct {
+		batch [][]byte
+		err   error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		b, err := tr.Recv()
+		ch <- result{b, err}
+	}()
+	r := simWait(t, ch)
+	if r.err != nil {
+		t.Fatalf("Recv: %v", r.err)
+	}
+	f, err := protocol.DecodeFrame(bytes.NewReader(bytes.Join(r.batch, nil)))
+	if err != nil {
+		t.Fatalf("DecodeFrame: %v", err)
+	}
+	return f
+}
+
+// simAnswerClose reads tr up to the close frame, answers it and sends its
+// status code on the returned channel.
+func simAnswerClose(tr *sim.Transport) <-chan uint16 {
+	ch := make(chan uint16, 1)
+	go func() {
+		for {
+			b, err := tr.Recv()
+			if err != nil {
+				return
+			}
+			f, err := protocol.DecodeFrame(bytes.NewReader(bytes.Join(b, nil)))
+			if err != nil || f.Opcode != protocol.OpcodeClose {
+				continue
+			}
+			code, _, _ := protocol.ParseClosePayload(f.Payload)
+			tr.Send([][]byte{{0x88, 0x82, 0, 0, 0, 0, 0x03, 0xe9}})
+			ch <- code
+			return
+		}
+	}()
+	return ch
+}
+
+// simWait receives from ch within two seconds of real time.
+func simWait[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out")
+		panic("unreachable")
+	}
+}
+
+// simAdvanceUntil advances clk by 100ms steps until cond holds, giving the
+// goroutines woken by each step a moment of real time to react.
+func simAdvanceUntil(t *testing.T, clk *sim.Clock, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 200 && !cond(); i++ {
+		clk.Advance(100 * time.Millisecond)
+		waitFor(t, 20*time.Millisecond, cond)
+	}
+	if !cond() {
+		t.Fatalf("condition not met by %v", clk.Since(sim.Epoch))
+	}
+}
@@ -0,0 +1,96 @@
+// File: tests/unit/splice_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for relaying proxied connections.
+
+package unit
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/lowlevel/server"
+)
+
+// tcpPair returns both ends of a loopback TCP connection.
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+	dialed, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	accepted, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	t.Cleanup(func() {
+		dialed.Close()
+		accepted.Close()
+	})
+	return dialed, accepted
+}
+
+// TestSplice tests that spliced connections relay bytes and FINs both ways
+// and that Splice returns once both ends closed.
+func TestSplice(t *testing.T) {
+	client, proxyIn := tcpPair(t)
+	proxyOut, upstream := tcpPair(t)
+	type result struct {
+		inKernel bool
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		inKernel, err := server.Splice(proxyIn, proxyOut)
+		done <- result{inKernel, err}
+	}()
+
+	deadline := time.Now().Add(3 * time.Second)
+	client.SetDeadline(deadline)
+	upstream.SetDeadline(deadline)
+	read := func(c net.Conn, n int) string {
+		t.Helper()
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(c, buf); err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		return string(buf)
+	}
+	for i := 0; i < 3; i++ {
+		client.Write([]byte("ping"))
+		if got := read(upstream, 4); got != "ping" {
+			t.Errorf("Expected ping upstream, got %q", got)
+		}
+		upstream.Write([]byte("pong"))
+		if got := read(client, 4); got != "pong" {
+			t.Errorf("Expected pong at the client, got %q", got)
+		}
+	}
+
+	client.(*net.TCPConn).CloseWrite()
+	if n, err := upstream.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected EOF upstream after the client's FIN, got %d %v", n, err)
+	}
+	upstream.Write([]byte("last"))
+	if got := read(client, 4); got != "last" {
+		t.Errorf("Expected bytes after a half close, got %q", got)
+	}
+	upstream.Close()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Errorf("Splice: %v", r.err)
+		}
+		t.Logf("in kernel: %v", r.inKernel)
+	case <-time.After(3 * time.Second):
+		t.Fatal("Expected Splice to return once both ends closed")
+	}
+}
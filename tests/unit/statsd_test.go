@@ -0,0 +1,82 @@
+// File: tests/unit/statsd_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the metrics pusher and StatsD sink.
+
+package unit
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/control"
+)
+
+// TestStatsDSink tests gauges, counter deltas, rates and histogram
+// percentiles pushed to a UDP listener.
+func TestStatsDSink(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	defer pc.Close()
+
+	sink, err := control.NewStatsDSink(pc.LocalAddr().String(),
+		control.WithStatsDPrefix("ws"),
+		control.WithDogStatsDTags("env:test"),
+		control.WithStatsDPacketSize(128))
+	if err != nil {
+		t.Fatalf("NewStatsDSink: %v", err)
+	}
+
+	reg := control.NewMetricsRegistry()
+	msgs := &control.Counter{}
+	latency := control.NewHistogram(0.01, 0.1, 1)
+	reg.Set("connections", 7)
+	reg.Set("messages", msgs)
+	reg.Set("latency", latency)
+	pusher := control.NewMetricsPusher(sink, time.Hour, reg.GetSnapshot)
+
+	msgs.Add(10)
+	pusher.Push()
+	msgs.Add(5)
+	for i := 0; i < 100; i++ {
+		latency.Observe(0.05)
+	}
+	if err := pusher.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+
+	var lines []string
+	buf := make([]byte, 2048)
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		if n > 128 {
+			t.Errorf("Datagram of %d bytes exceeds packet size", n)
+		}
+		lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+	}
+	all := strings.Join(lines, "\n")
+	for _, want := range []string{
+		"ws.connections:7|g|#env:test",
+		"ws.messages:10|c|#env:test",
+		"ws.messages:5|c|#env:test",
+		"ws.latency.count:100|c|#env:test",
+	} {
+		if !strings.Contains(all, want+"\n") && !strings.HasSuffix(all, want) {
+			t.Errorf("Missing %q in:\n%s", want, all)
+		}
+	}
+	for _, prefix := range []string{"ws.messages.rate:", "ws.latency.p50:0.055", "ws.latency.p99:0.099"} {
+		if !strings.Contains(all, prefix) {
+			t.Errorf("Missing %q in:\n%s", prefix, all)
+		}
+	}
+}
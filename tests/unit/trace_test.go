@@ -0,0 +1,119 @@
+// File: tests/unit/trace_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for per-connection debug tracing.
+
+package unit
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/control"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+	"github.com/momentics/hioload-ws/protocol"
+	"github.com/momentics/hioload-ws/session"
+)
+
+// TestConnectionTrace tests that tracing one connection by session ID logs
+// its frames and state changes only, and can be toggled via the admin server.
+func TestConnectionTrace(t *testing.T) {
+	rec := &recordingLogger{}
+	control.SetLogger(rec)
+	t.Cleanup(func() { control.SetLogger(nil) })
+
+	port := freePort(t)
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf(":%d", port)
+	cfg.ShutdownTimeout = 10 * time.Millisecond
+	srv, err := server.NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+	var handled atomic.Int32
+	runServer(t, srv, api.HandlerFunc(func(data any) error {
+		if evt, ok := data.(interface{ GetBuffer() api.Buffer }); ok {
+			evt.GetBuffer().Release()
+			handled.Add(1)
+		}
+		return nil
+	}))
+
+	traced, _, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	defer traced.Close()
+	waitConns(t, srv, 1)
+	var tracedID string
+	srv.Sessions().Range(func(s session.Session) { tracedID = s.ID() })
+	other, _, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	defer other.Close()
+	waitConns(t, srv, 2)
+
+	if err := srv.TraceConnection(tracedID, true); err != nil {
+		t.Fatalf("TraceConnection: %v", err)
+	}
+	sendPing := func(c net.Conn) {
+		data, _ := protocol.EncodeFrameToBytesWithMask(&protocol.WSFrame{
+			IsFinal: true, Opcode: protocol.OpcodeText, Payload: []byte("ping"), PayloadLen: 4,
+		}, true)
+		if _, err := c.Write(data); err != nil {
+			t.Fatalf("Failed to write frame: %v", err)
+		}
+	}
+	sendPing(other)
+	sendPing(traced)
+
+	var records []string
+	waitFor(t, 500*time.Millisecond, func() bool {
+		records = append(records, rec.take()...)
+		return containsRecord(records, "buffer acquired")
+	})
+	for _, want := range []string{"INFO trace enabled", "INFO frame recv [subsystem trace opcode 1 fin true masked true len 4", "INFO buffer acquired [subsystem trace len 4"} {
+		if !containsRecord(records, want) {
+			t.Errorf("Missing %q in %q", want, records)
+		}
+	}
+	for _, r := range records {
+		if !strings.Contains(r, "subsystem trace") || !strings.Contains(r, "session "+tracedID) {
+			t.Errorf("Unexpected record for another connection or subsystem: %q", r)
+		}
+	}
+	if got := srv.GetControl().GetConfig()[server.CfgTraceConnections]; fmt.Sprint(got) != "["+tracedID+"]" {
+		t.Errorf("Expected %s in config, got %v", server.CfgTraceConnections, got)
+	}
+
+	admin := srv.AdminServer("127.0.0.1:0").Handler()
+	if w := adminDo(admin, http.MethodGet, "/connections", "", ""); !strings.Contains(w.Body.String(), `"trace": true`) {
+		t.Errorf("Expected traced connection in listing, got %s", w.Body.String())
+	}
+	if w := adminDo(admin, http.MethodPost, "/connections/trace?id="+tracedID+"&enabled=false", "", ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 disabling trace, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := adminDo(admin, http.MethodPost, "/connections/trace", "", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without id, got %d", w.Code)
+	}
+	rec.take()
+	sendPing(traced)
+	if !waitFor(t, time.Second, func() bool { return handled.Load() == 3 }) {
+		t.Fatalf("Expected 3 frames handled, got %d", handled.Load())
+	}
+	if got := rec.take(); len(got) != 0 {
+		t.Errorf("Expected no records after disabling trace, got %q", got)
+	}
+}
+
+func containsRecord(records []string, prefix string) bool {
+	for _, r := range records {
+		if strings.Contains(r, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+// File: tests/unit/upgrade_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for restarts that hand listening sockets to a new process.
+
+package unit
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/highlevel"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+)
+
+// replyWith serves every message with reply.
+func replyWith(reply string) func(*highlevel.Conn) {
+	return func(c *highlevel.Conn) {
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+			c.WriteString(reply)
+		}
+	}
+}
+
+// ask sends a message on conn and returns the reply.
+func ask(conn *highlevel.Conn) (string, error) {
+	if err := conn.WriteString("?"); err != nil {
+		return "", err
+	}
+	_, msg, err := conn.ReadMessage()
+	return string(msg), err
+}
+
+// TestUpgrade tests that new connections reach the upgraded process while an
+// established one is still served by the old process until it closes.
+func TestUpgrade(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Upgrade is not supported on Windows")
+	}
+	if list := os.Getenv(server.EnvListeners); list != "" {
+		// Started by Upgrade below: serve the inherited address for a while.
+		srv := highlevel.NewServer(strings.Split(list, "\n")[0])
+		srv.HandleFunc("/ask", replyWith("new"))
+		time.AfterFunc(5*time.Second, func() { os.Exit(0) })
+		srv.ListenAndServe()
+		os.Exit(1)
+	}
+
+	addr := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+	srv := highlevel.NewServer(addr, highlevel.WithUpgradeCommand(os.Args[0], "-test.run=^TestUpgrade$"))
+	srv.HandleFunc("/ask", replyWith("old"))
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	old, err := highlevel.Dial("ws://" + addr + "/ask")
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer old.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		done <- srv.Upgrade(ctx)
+	}()
+
+	upgraded := waitFor(t, 4*time.Second, func() bool {
+		conn, err := highlevel.Dial("ws://" + addr + "/ask")
+		if err != nil {
+			t.Fatalf("Connection refused during the upgrade: %v", err)
+		}
+		defer conn.Close()
+		reply, _ := ask(conn)
+		return reply == "new"
+	})
+	if !upgraded {
+		t.Fatal("Expected new connections served by the new process")
+	}
+
+	if reply, err := ask(old); err != nil || reply != "old" {
+		t.Errorf("Expected the old process to keep serving its connection, got %q (err=%v)", reply, err)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("Upgrade returned with a connection still open: %v", err)
+	default:
+	}
+	old.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Upgrade failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Upgrade did not return after the last connection closed")
+	}
+}
+
+// TestUpgrade_Failure tests that a new process exiting before it is ready
+// leaves the server serving.
+func TestUpgrade_Failure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Upgrade is not supported on Windows")
+	}
+	addr := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+	srv := highlevel.NewServer(addr, highlevel.WithUpgradeCommand("/bin/sh", "-c", "exit 3"))
+	srv.HandleFunc("/ask", replyWith("old"))
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	if err := srv.Upgrade(context.Background()); err == nil {
+		t.Fatal("Expected an error when the new process exits")
+	}
+	conn, err := highlevel.Dial("ws://" + addr + "/ask")
+	if err != nil {
+		t.Fatalf("Expected the server still accepting: %v", err)
+	}
+	defer conn.Close()
+	if reply, err := ask(conn); err != nil || reply != "old" {
+		t.Errorf("Expected reply from the old process, got %q (err=%v)", reply, err)
+	}
+}
+
+// TestReusePort tests two servers bound to one address with the reuse_port
+// config key set.
+func TestReusePort(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("SO_REUSEPORT is not supported on Windows")
+	}
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf("127.0.0.1:%d", freePort(t))
+	if err := server.ApplyConfig(cfg, map[string]any{"reuse_port": true}); err != nil || !cfg.ReusePort {
+		t.Fatalf("Expected reuse_port applied, got %v (err=%v)", cfg.ReusePort, err)
+	}
+	a, err := server.NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create the first server: %v", err)
+	}
+	defer a.StopAccepting()
+	b, err := server.NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Expected a second server on the same address: %v", err)
+	}
+	defer b.StopAccepting()
+
+	if l, err := net.Listen("tcp", cfg.ListenAddr); err == nil {
+		l.Close()
+		t.Error("Expected a plain listener refused on a shared address")
+	}
+}
@@ -0,0 +1,195 @@
+// File: tests/unit/vhost_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for serving several TLS server names on one listener.
+
+package unit
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/highlevel"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// hostCert returns a self-signed certificate for the DNS name name.
+func hostCert(t *testing.T, name string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{name},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// dialHost connects over TLS naming serverName and returns the connection
+// and the name on the certificate the server presented.
+func dialHost(t *testing.T, port int, serverName string) (*tls.Conn, string) {
+	t.Helper()
+	conn, err := tls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port), &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("Failed to dial %s: %v", serverName, err)
+	}
+	return conn, conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+// prefixEcho replies to each frame with prefix and its payload.
+func prefixEcho(prefix string) api.Handler {
+	return api.HandlerFunc(func(data any) error {
+		evt, ok := data.(interface {
+			WSConnection() *protocol.WSConnection
+			GetBuffer() api.Buffer
+		})
+		if !ok {
+			return nil
+		}
+		payload := append([]byte(prefix), evt.GetBuffer().Bytes()...)
+		evt.GetBuffer().Release()
+		return evt.WSConnection().SendFrame(&protocol.WSFrame{
+			IsFinal: true, Opcode: protocol.OpcodeText, Payload: payload, PayloadLen: int64(len(payload)),
+		})
+	})
+}
+
+// TestVirtualHosts tests that a listener presents the certificate of the
+// host the client names and hands its events to the host's handler.
+func TestVirtualHosts(t *testing.T) {
+	port := freePort(t)
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = ""
+	cfg.ShutdownTimeout = 10 * time.Millisecond
+	srv, err := server.NewServer(cfg, server.WithEndpoints(server.Endpoint{
+		Addr: fmt.Sprintf("127.0.0.1:%d", port),
+		Hosts: []server.VirtualHost{
+			{Name: "a.test", Certificate: hostCert(t, "a.test"), Handler: prefixEcho("a:")},
+			{Name: "*.B.test", Certificate: hostCert(t, "*.b.test")},
+		},
+	}))
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+	runServer(t, srv, prefixEcho("default:"))
+
+	for _, tc := range []struct{ sni, cert, reply string }{
+		{"a.test", "a.test", "a:x"},
+		{"A.TEST", "a.test", "a:x"},
+		{"x.b.test", "*.b.test", "default:x"},
+	} {
+		conn, cert := dialHost(t, port, tc.sni)
+		if cert != tc.cert {
+			t.Errorf("%s: expected certificate %s, got %s", tc.sni, tc.cert, cert)
+		}
+		if got := echoOver(t, conn, "/", "x"); got != tc.reply {
+			t.Errorf("%s: expected reply %q, got %q", tc.sni, tc.reply, got)
+		}
+	}
+
+	for _, sni := range []string{"c.test", "b.test", "y.x.b.test"} {
+		conn, err := tls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port), &tls.Config{ServerName: sni, InsecureSkipVerify: true})
+		if err == nil {
+			conn.Close()
+			t.Errorf("%s: expected the handshake to fail without a certificate", sni)
+		}
+	}
+}
+
+// TestVirtualHostsInvalid tests that NewServer refuses unusable hosts.
+func TestVirtualHostsInvalid(t *testing.T) {
+	cert := hostCert(t, "a.test")
+	for name, hosts := range map[string][]server.VirtualHost{
+		"no certificate": {{Name: "a.test"}},
+		"no name":        {{Certificate: cert}},
+		"duplicate":      {{Name: "a.test", Certificate: cert}, {Name: "A.test.", Certificate: cert}},
+	} {
+		cfg := server.DefaultConfig()
+		cfg.ListenAddr = ""
+		ep := server.Endpoint{Addr: fmt.Sprintf("127.0.0.1:%d", freePort(t)), Hosts: hosts}
+		if _, err := server.NewServer(cfg, server.WithEndpoints(ep)); err == nil {
+			t.Errorf("%s: expected NewServer to fail", name)
+		}
+	}
+}
+
+// TestHostRoutes tests that connections to a host with routes of its own
+// are routed by those only, while other hosts use the server's routes.
+func TestHostRoutes(t *testing.T) {
+	port := freePort(t)
+	fallback := selfSignedTLS(t)
+	srv := highlevel.NewServer("", highlevel.WithTLSHosts(fmt.Sprintf("127.0.0.1:%d", port), fallback,
+		server.VirtualHost{Name: "a.test", Certificate: hostCert(t, "a.test")},
+		server.VirtualHost{Name: "*.b.test", Certificate: hostCert(t, "*.b.test")},
+	))
+	echo := func(prefix string) func(*highlevel.Conn) {
+		return func(c *highlevel.Conn) {
+			for {
+				mt, msg, err := c.ReadMessage()
+				if err != nil {
+					return
+				}
+				c.WriteMessage(mt, append([]byte(prefix+c.VirtualHost()+":"), msg...))
+			}
+		}
+	}
+	srv.HandleFunc("/echo", echo("default@"))
+	srv.Host("A.test").Group("/api").HandleFunc("/echo", echo("api@"))
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	for _, tc := range []struct{ sni, cert, path, reply string }{
+		{"a.test", "a.test", "/api/echo", "api@a.test:x"},
+		{"x.b.test", "*.b.test", "/echo", "default@*.b.test:x"},
+		{"other.test", "localhost", "/echo", "default@:x"},
+	} {
+		conn, cert := dialHost(t, port, tc.sni)
+		if cert != tc.cert {
+			t.Errorf("%s: expected certificate %s, got %s", tc.sni, tc.cert, cert)
+		}
+		if got := echoOver(t, conn, tc.path, "x"); got != tc.reply {
+			t.Errorf("%s: expected reply %q, got %q", tc.sni, tc.reply, got)
+		}
+	}
+
+	// The host's own routes replace the server's.
+	conn, _ := dialHost(t, port, "a.test")
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	req, _ := http.NewRequest("GET", "http://a.test/echo", nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("Failed to write upgrade: %v", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for a server route on a host with its own, got %v %v", resp, err)
+	}
+}
@@ -0,0 +1,78 @@
+// File: tests/unit/watchdog_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the slow-handler watchdog.
+
+package unit
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/control"
+	"github.com/momentics/hioload-ws/lowlevel/server"
+)
+
+// blockedHandler stands in for a handler stuck on a downstream call.
+func blockedHandler(release <-chan struct{}) {
+	<-release
+}
+
+// TestSlowHandlerWatchdog tests that a handler call blocking past
+// Config.SlowHandler is logged once with its route and stack, and counted.
+func TestSlowHandlerWatchdog(t *testing.T) {
+	rec := &recordingLogger{}
+	control.SetLogger(rec)
+	t.Cleanup(func() { control.SetLogger(nil) })
+
+	port := freePort(t)
+	cfg := server.DefaultConfig()
+	cfg.ListenAddr = fmt.Sprintf(":%d", port)
+	cfg.ShutdownTimeout = 10 * time.Millisecond
+	cfg.SlowHandler = 50 * time.Millisecond
+	srv, err := server.NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	release := make(chan struct{})
+	t.Cleanup(srv.Shutdown)
+	runServer(t, srv, api.HandlerFunc(func(data any) error {
+		if ev, ok := data.(interface{ GetBuffer() api.Buffer }); ok {
+			blockedHandler(release)
+			ev.GetBuffer().Release()
+		}
+		return nil
+	}))
+
+	conn, _, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	defer conn.Close()
+	conn.Write(maskedFrame([]byte("hi")))
+
+	slow := func() uint64 {
+		c, _ := srv.GetControl().Stats()["debug."+server.ProbeSlowHandlers].(*control.Counter)
+		return c.Value()
+	}
+	if !waitFor(t, time.Second, func() bool { return slow() == 1 }) {
+		t.Fatalf("Expected 1 slow handler, got %d", slow())
+	}
+	time.Sleep(100 * time.Millisecond) // still the same call: not reported again
+	close(release)
+	if n := slow(); n != 1 {
+		t.Errorf("Expected the blocked call to be reported once, got %d", n)
+	}
+
+	var logged string
+	for _, r := range rec.take() {
+		if strings.Contains(r, "slow handler") {
+			logged = r
+		}
+	}
+	if !strings.Contains(logged, "route /") || !strings.Contains(logged, "blockedHandler") {
+		t.Errorf("Expected route and stack in the log, got %q", logged)
+	}
+}
@@ -0,0 +1,96 @@
+// File: tests/unit/write_buffer_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for zero-copy writes of pooled buffers.
+
+package unit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/highlevel"
+)
+
+// releaseCounter counts the buffers handed back to it.
+type releaseCounter struct{ puts atomic.Int64 }
+
+func (r *releaseCounter) Put(api.Buffer) { r.puts.Add(1) }
+
+// TestWriteBuffer_Echo tests relaying received buffers straight back out.
+func TestWriteBuffer_Echo(t *testing.T) {
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf(":%d", port))
+	srv.HandleFunc("/echo", func(c *highlevel.Conn) {
+		for {
+			mt, buf, err := c.ReadBuffer()
+			if err != nil {
+				return
+			}
+			if err := c.WriteBuffer(mt, buf); err != nil {
+				return
+			}
+		}
+	})
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	conn, err := highlevel.Dial(fmt.Sprintf("ws://localhost:%d/echo", port))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	big := bytes.Repeat([]byte("0123456789abcdef"), 200)
+	msgs := []struct {
+		mt   highlevel.MessageType
+		data []byte
+	}{
+		{highlevel.TextMessage, []byte("hello")},
+		{highlevel.BinaryMessage, big},
+		{highlevel.TextMessage, []byte("bye")},
+	}
+	for _, m := range msgs {
+		r := &releaseCounter{}
+		if err := conn.WriteBuffer(int(m.mt), api.Buffer{Data: m.data, Pool: r}); err != nil {
+			t.Fatalf("WriteBuffer failed: %v", err)
+		}
+		if r.puts.Load() != 1 {
+			t.Errorf("Expected the client buffer released once, got %d", r.puts.Load())
+		}
+	}
+	for _, m := range msgs {
+		mt, got, err := conn.ReadMessage()
+		if err != nil || mt != int(m.mt) || !bytes.Equal(got, m.data) {
+			t.Fatalf("Expected %d bytes of type %d, got %d of type %d (err=%v)", len(m.data), m.mt, len(got), mt, err)
+		}
+	}
+}
+
+// TestWriteBuffer_Closed tests that a write on a closed connection still
+// releases the buffer.
+func TestWriteBuffer_Closed(t *testing.T) {
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf(":%d", port))
+	srv.HandleFunc("/echo", func(c *highlevel.Conn) {})
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	conn, err := highlevel.Dial(fmt.Sprintf("ws://localhost:%d/echo", port))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	conn.Close()
+	r := &releaseCounter{}
+	if err := conn.WriteBuffer(int(highlevel.BinaryMessage), api.Buffer{Data: []byte("x"), Pool: r}); err == nil {
+		t.Error("Expected an error writing to a closed connection")
+	}
+	if r.puts.Load() != 1 {
+		t.Errorf("Expected the buffer released once, got %d", r.puts.Load())
+	}
+}
@@ -0,0 +1,134 @@
+// File: tests/unit/write_coalescing_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for concurrent writers and write coalescing on highlevel.Conn.
+
+package unit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/highlevel"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// TestWriteCoalescing tests that small messages are held back until the
+// flush interval and that Flush and Close send them early.
+func TestWriteCoalescing(t *testing.T) {
+	port := freePort(t)
+	srv := highlevel.NewServer(fmt.Sprintf(":%d", port), highlevel.WithWriteCoalescing(300*time.Millisecond))
+	srv.HandleFunc("/resume", func(c *highlevel.Conn) {
+		for {
+			_, msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			for i := 0; i < 3; i++ {
+				c.WriteString(fmt.Sprintf("%s-%d", msg, i))
+			}
+			switch string(msg) {
+			case "flush":
+				c.Flush()
+			case "close":
+				c.Close()
+				return
+			}
+		}
+	})
+	defer srv.Shutdown(context.Background())
+	serve(t, srv)
+
+	conn, br, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+	defer conn.Close()
+
+	conn.Write([]byte{0x81, 0x84, 0, 0, 0, 0, 'w', 'a', 'i', 't'})
+	conn.SetReadDeadline(time.Now().Add(150 * time.Millisecond))
+	var ne net.Error
+	if _, err := br.Peek(1); !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatalf("Expected messages held back by the coalescer, got %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for i := 0; i < 3; i++ {
+		frame, err := protocol.DecodeFrame(br)
+		if err != nil || string(frame.Payload) != fmt.Sprintf("wait-%d", i) {
+			t.Fatalf("Expected wait-%d after the interval, got %v (err=%v)", i, frame, err)
+		}
+	}
+
+	conn.Write([]byte{0x81, 0x85, 0, 0, 0, 0, 'f', 'l', 'u', 's', 'h'})
+	conn.SetReadDeadline(time.Now().Add(150 * time.Millisecond))
+	for i := 0; i < 3; i++ {
+		frame, err := protocol.DecodeFrame(br)
+		if err != nil || string(frame.Payload) != fmt.Sprintf("flush-%d", i) {
+			t.Fatalf("Expected flush-%d without waiting, got %v (err=%v)", i, frame, err)
+		}
+	}
+
+	conn.Write([]byte{0x81, 0x85, 0, 0, 0, 0, 'c', 'l', 'o', 's', 'e'})
+	conn.SetReadDeadline(time.Now().Add(150 * time.Millisecond))
+	for i := 0; i < 3; i++ {
+		frame, err := protocol.DecodeFrame(br)
+		if err != nil || string(frame.Payload) != fmt.Sprintf("close-%d", i) {
+			t.Fatalf("Expected close-%d written before the close, got %v (err=%v)", i, frame, err)
+		}
+	}
+}
+
+// TestConcurrentWriters tests that messages written from several goroutines
+// arrive whole and in per-writer order, with and without coalescing.
+func TestConcurrentWriters(t *testing.T) {
+	const writers, perWriter = 8, 50
+	for _, interval := range []time.Duration{0, time.Millisecond} {
+		t.Run(fmt.Sprint(interval), func(t *testing.T) {
+			port := freePort(t)
+			srv := highlevel.NewServer(fmt.Sprintf(":%d", port), highlevel.WithWriteCoalescing(interval))
+			srv.HandleFunc("/resume", func(c *highlevel.Conn) {
+				if _, _, err := c.ReadMessage(); err != nil {
+					return
+				}
+				var wg sync.WaitGroup
+				for w := 0; w < writers; w++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						for i := 0; i < perWriter; i++ {
+							c.WriteString(fmt.Sprintf("%d:%d", w, i))
+						}
+					}()
+				}
+				wg.Wait()
+			})
+			defer srv.Shutdown(context.Background())
+			serve(t, srv)
+
+			conn, br, _ := upgrade(t, port, "/resume", nil, http.StatusSwitchingProtocols)
+			defer conn.Close()
+			conn.Write([]byte{0x81, 0x82, 0, 0, 0, 0, 'g', 'o'})
+
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			next := make([]int, writers)
+			for n := 0; n < writers*perWriter; n++ {
+				frame, err := protocol.DecodeFrame(br)
+				if err != nil {
+					t.Fatalf("Failed to read message %d: %v", n, err)
+				}
+				var w, i int
+				if _, err := fmt.Sscanf(string(frame.Payload), "%d:%d", &w, &i); err != nil || w >= writers {
+					t.Fatalf("Corrupted message %q", frame.Payload)
+				}
+				if i != next[w] {
+					t.Fatalf("Writer %d: expected message %d, got %d", w, next[w], i)
+				}
+				next[w]++
+			}
+		})
+	}
+}
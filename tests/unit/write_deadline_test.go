@@ -0,0 +1,159 @@
+// File: tests/unit/write_deadline_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for the write timeout enforced by the send loop.
+
+package unit
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/pool"
+	"github.com/momentics/hioload-ws/protocol"
+	"github.com/momentics/hioload-ws/sim"
+)
+
+// deadlineTransport is a stalledTransport honouring write deadlines: Send
+// blocks until the deadline set by SetWriteDeadline passes.
+type deadlineTransport struct {
+	*stalledTransport
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+func (t *deadlineTransport) SetWriteDeadline(d time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.deadline = d
+	return nil
+}
+
+func (t *deadlineTransport) Send(bufs [][]byte) error {
+	t.mu.Lock()
+	d := t.deadline
+	t.mu.Unlock()
+	if d.IsZero() {
+		return t.stalledTransport.Send(bufs)
+	}
+	select {
+	case <-t.closed:
+		return api.ErrTransportClosed
+	case <-time.After(time.Until(d)):
+		return os.ErrDeadlineExceeded
+	}
+}
+
+func timeoutFrame(payload string) *protocol.WSFrame {
+	return &protocol.WSFrame{IsFinal: true, Opcode: protocol.OpcodeBinary, Payload: []byte(payload), PayloadLen: int64(len(payload))}
+}
+
+// TestWriteTimeout_Queue tests that SendFrame fails with ErrWriteTimeout
+// when the outbox has no room before the write timeout.
+func TestWriteTimeout_Queue(t *testing.T) {
+	conn := stalledConn(t, protocol.OutboxLimit{HighWater: 2})
+	conn.SetWriteTimeout(50 * time.Millisecond)
+	start := time.Now()
+	err := conn.SendFrame(overflowFrame())
+	if !errors.Is(err, protocol.ErrWriteTimeout) || !errors.Is(err, api.ErrOperationTimeout) {
+		t.Fatalf("Expected ErrWriteTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected the send to wait, returned after %v", elapsed)
+	}
+}
+
+// TestWriteTimeout_TransportDeadline tests that the send loop arms the
+// transport write deadline of a batch and closes the connection once a
+// write misses it.
+func TestWriteTimeout_TransportDeadline(t *testing.T) {
+	tr := &deadlineTransport{stalledTransport: newStalledTransport()}
+	conn := protocol.NewWSConnection(tr, pool.NewBufferPoolManager(0).GetPool(1024, 0), 4)
+	defer conn.Close()
+	conn.SetWriteTimeout(50 * time.Millisecond)
+
+	start := time.Now()
+	if err := conn.SendFrame(timeoutFrame("late")); err != nil {
+		t.Fatalf("SendFrame: %v", err)
+	}
+	select {
+	case <-conn.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Connection not closed after the missed write deadline")
+	}
+	tr.mu.Lock()
+	d := tr.deadline
+	tr.mu.Unlock()
+	if d.Before(start.Add(50*time.Millisecond)) || d.After(time.Now()) {
+		t.Errorf("Write deadline %v, expected 50ms after %v", d, start)
+	}
+	if err := conn.SendFrame(timeoutFrame("after")); !errors.Is(err, api.ErrTransportClosed) {
+		t.Errorf("Expected ErrTransportClosed after the timeout, got %v", err)
+	}
+}
+
+// TestWriteTimeout_ExpiredInQueue tests that a frame whose deadline passed
+// while queued behind a slow write is not written and closes the
+// connection.
+func TestWriteTimeout_ExpiredInQueue(t *testing.T) {
+	clk := sim.NewClock(time.Time{})
+	tr := &gatedTransport{sending: make(chan struct{}), release: make(chan struct{})}
+	conn := protocol.NewWSConnection(tr, pool.NewBufferPoolManager(0).GetPool(1024, 0), 4)
+	conn.SetClock(clk)
+	defer conn.Close()
+	conn.SetWriteTimeout(10 * time.Second)
+
+	conn.SendFrame(timeoutFrame("first"))
+	select {
+	case <-tr.sending:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Send loop did not start")
+	}
+	if err := conn.SendFrame(timeoutFrame("queued")); err != nil {
+		t.Fatalf("SendFrame: %v", err)
+	}
+	clk.Advance(11 * time.Second)
+	close(tr.release)
+	select {
+	case <-conn.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Connection not closed for the expired frame")
+	}
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if len(tr.frames) != 1 || string(tr.frames[0].Payload) != "first" {
+		t.Errorf("Expected only the first frame written, got %d frames", len(tr.frames))
+	}
+}
+
+// TestWriteDeadline_Absolute tests that SetWriteDeadline bounds later
+// frames by the same instant, not by its distance from the call, and wins
+// over a longer write timeout.
+func TestWriteDeadline_Absolute(t *testing.T) {
+	tr := &deadlineTransport{stalledTransport: newStalledTransport()}
+	conn := protocol.NewWSConnection(tr, pool.NewBufferPoolManager(0).GetPool(1024, 0), 4)
+	defer conn.Close()
+	conn.SetWriteTimeout(time.Hour)
+	deadline := time.Now().Add(150 * time.Millisecond)
+	conn.SetWriteDeadline(deadline)
+
+	if err := conn.SendFrame(timeoutFrame("late")); err != nil {
+		t.Fatalf("SendFrame: %v", err)
+	}
+	select {
+	case <-conn.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Connection not closed after the missed write deadline")
+	}
+	tr.mu.Lock()
+	d := tr.deadline
+	tr.mu.Unlock()
+	if !d.Equal(time.Unix(0, deadline.UnixNano())) {
+		t.Errorf("Write deadline %v, expected %v", d, deadline)
+	}
+}
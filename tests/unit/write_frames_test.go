@@ -0,0 +1,137 @@
+// File: tests/unit/write_frames_test.go
+// Author: momentics <momentics@gmail.com>
+// License: Apache-2.0
+//
+// Unit tests for batched frame submission with WSConnection.WriteFrames.
+
+package unit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/momentics/hioload-ws/api"
+	"github.com/momentics/hioload-ws/protocol"
+)
+
+// TestWriteFrames tests that a batch is submitted in one transport Send with
+// one segment per frame, in order.
+func TestWriteFrames(t *testing.T) {
+	var calls [][][]byte
+	tr := &api.MockTransport{
+		SendFunc: func(b [][]byte) error {
+			segs := make([][]byte, len(b))
+			for i, seg := range b {
+				segs[i] = append([]byte(nil), seg...)
+			}
+			calls = append(calls, segs)
+			return nil
+		},
+		CloseFunc: func() error { return nil },
+	}
+	conn := protocol.NewWSConnection(tr, nil, 4)
+
+	var frames []*protocol.WSFrame
+	for i := 0; i < 5; i++ {
+		p := []byte(fmt.Sprintf("msg%d", i))
+		frames = append(frames, &protocol.WSFrame{IsFinal: true, Opcode: protocol.OpcodeText, Payload: p, PayloadLen: int64(len(p))})
+	}
+	if err := conn.WriteFrames(frames); err != nil {
+		t.Fatalf("WriteFrames: %v", err)
+	}
+	if len(calls) != 1 {
+		t.Fatalf("Expected 1 transport Send, got %d", len(calls))
+	}
+	if len(calls[0]) != len(frames) {
+		t.Fatalf("Expected %d segments, got %d", len(frames), len(calls[0]))
+	}
+	for i, seg := range calls[0] {
+		f, err := protocol.DecodeFrame(bytes.NewReader(seg))
+		if err != nil {
+			t.Fatalf("segment %d: %v", i, err)
+		}
+		if want := fmt.Sprintf("msg%d", i); string(f.Payload) != want {
+			t.Errorf("segment %d: got %q, want %q", i, f.Payload, want)
+		}
+	}
+	if got := conn.GetStats()["frames_sent"]; got != int64(len(frames)) {
+		t.Errorf("frames_sent = %d, want %d", got, len(frames))
+	}
+
+	if err := conn.WriteFrames(nil); err != nil || len(calls) != 1 {
+		t.Errorf("empty batch: err %v, %d Sends", err, len(calls))
+	}
+	conn.Close()
+	if err := conn.WriteFrames(frames[:1]); err != api.ErrTransportClosed {
+		t.Errorf("after Close: got %v, want ErrTransportClosed", err)
+	}
+}
+
+// TestWriteFrames_Ordering tests that a batch goes through the send lanes:
+// after the frames SendFrame queued before it, and never after the close
+// frame of SendClose.
+func TestWriteFrames_Ordering(t *testing.T) {
+	var mu sync.Mutex
+	var sent []string
+	release := make(chan struct{})
+	tr := &api.MockTransport{
+		SendFunc: func(b [][]byte) error {
+			<-release
+			mu.Lock()
+			defer mu.Unlock()
+			for _, seg := range b {
+				f, err := protocol.DecodeFrame(bytes.NewReader(seg))
+				if err != nil {
+					return err
+				}
+				sent = append(sent, string(f.Payload))
+			}
+			return nil
+		},
+		CloseFunc: func() error { return nil },
+	}
+	conn := protocol.NewWSConnection(tr, nil, 8)
+	defer conn.Close()
+
+	text := func(s string) *protocol.WSFrame {
+		return &protocol.WSFrame{IsFinal: true, Opcode: protocol.OpcodeText, Payload: []byte(s), PayloadLen: int64(len(s))}
+	}
+	for _, s := range []string{"q1", "q2"} {
+		if err := conn.SendFrame(text(s)); err != nil {
+			t.Fatalf("SendFrame: %v", err)
+		}
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- conn.WriteFrames([]*protocol.WSFrame{text("b1"), text("b2")}) }()
+	close(release)
+	if err := <-errc; err != nil {
+		t.Fatalf("WriteFrames: %v", err)
+	}
+	if err := conn.SendClose(protocol.CloseNormalClosure, ""); err != nil {
+		t.Fatalf("SendClose: %v", err)
+	}
+	if err := conn.WriteFrames([]*protocol.WSFrame{text("late")}); !errors.Is(err, protocol.ErrCloseSent) {
+		t.Errorf("after SendClose: got %v, want ErrCloseSent", err)
+	}
+	want := []string{"q1", "q2", "b1", "b2"}
+	waitFor(t, time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(sent) == len(want)+1
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sent) != len(want)+1 {
+		t.Fatalf("Expected %v then the close frame, got %q", want, sent)
+	}
+	for i, s := range want {
+		if sent[i] != s {
+			t.Errorf("frame %d: got %q, want %q", i, sent[i], s)
+		}
+	}
+}